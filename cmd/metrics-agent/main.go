@@ -7,42 +7,109 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"math"
 	"os"
-	"os/signal"
-	"runtime"
-	"sync"
-	"syscall"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/janhuddel/metrics-agent/internal/agent"
 	"github.com/janhuddel/metrics-agent/internal/config"
-	"github.com/janhuddel/metrics-agent/internal/metricchannel"
-	"github.com/janhuddel/metrics-agent/internal/modules"
+	"github.com/janhuddel/metrics-agent/internal/processors"
 	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
 )
 
 var (
 	// flagVersion prints the version and exits
 	flagVersion = flag.Bool("version", false, "Print version and exit")
+	// flagSchema prints the JSON Schema of the configuration file
+	flagSchema = flag.Bool("schema", false, "Print the JSON Schema of the configuration file and exit")
 	// flagConfig specifies the path to the configuration file
 	flagConfig = flag.String("c", "", "Path to configuration file")
+	// flagConfigURL fetches the configuration file from a URL
+	flagConfigURL = flag.String("config-url", "", "Fetch the configuration file from this URL and cache it in the -c path or the storage directory")
+	// flagConfigKey is the public key remote configuration files are signed with
+	flagConfigKey = flag.String("config-key", "", "Base64-encoded Ed25519 public key the remote configuration must be signed with")
+	// flagConfigUnsigned accepts a remote configuration without a signature
+	flagConfigUnsigned = flag.Bool("config-unsigned", false, "Accept a remote configuration without -config-key, i.e. without verifying its signature")
+	// flagConfigRefresh is how often the remote configuration is fetched again
+	flagConfigRefresh = flag.Duration("config-refresh", 5*time.Minute, "How often the remote configuration is fetched again (0 disables it)")
+	// flagPprof enables the net/http/pprof endpoints on the given address
+	flagPprof = flag.String("pprof", "", "Serve pprof endpoints on this address (e.g. localhost:6060)")
+	// flagProfileDir specifies where profiles are written on SIGUSR2
+	flagProfileDir = flag.String("profile-dir", os.TempDir(), "Directory for CPU/heap profiles written on SIGUSR2")
 )
 
+// remoteConfigCache is the file the remote configuration is cached in, in the storage directory
+const remoteConfigCache = "remote-config.json"
+
 // version can be overridden at build time with -ldflags
 var version = "dev"
 
+// commit and date are the Git commit and build date, set at build time with
+// -ldflags. If not set, they are taken from the VCS information Go embeds.
+var (
+	commit = ""
+	date   = ""
+)
+
 // main is the entry point of the metrics-agent application.
 // It initializes logging, parses command-line flags, and runs all modules
 // concurrently in a single process.
 func main() {
 	// Parse flags first to get config path
 	flag.Parse()
+	agent.SetBuildInfo(version, commit, date)
+
+	// Log skipped field values of the public metrics package like the agent
+	metrics.SetLogger(utils.ModuleLogger{})
 
 	// Handle version flag
 	if *flagVersion {
-		fmt.Fprintf(os.Stderr, "metrics-agent %s (%s %s)\n", version, runtime.GOOS, runtime.GOARCH)
+		fmt.Fprintln(os.Stderr, agent.VersionString())
+		return
+	}
+
+	// Handle schema flag
+	if *flagSchema {
+		if err := agent.PrintSchema(os.Stdout); err != nil {
+			utils.Fatalf("Failed to write configuration schema: %v", err)
+		}
 		return
 	}
 
+	// Handle the "config" command before loading the configuration it creates
+	if args := flag.Args(); len(args) > 0 && args[0] == "config" {
+		if err := agent.RunConfigCommand(args[1:], *flagConfig); err != nil {
+			utils.Fatalf("%v", err)
+		}
+		return
+	}
+
+	// Fetch the configuration file from a remote URL into the local cache
+	var remote *config.RemoteSource
+	if *flagConfigURL != "" {
+		cachePath := *flagConfig
+		if cachePath == "" {
+			cachePath = filepath.Join(utils.StorageDir(), remoteConfigCache)
+		}
+		if *flagConfigKey == "" {
+			if !*flagConfigUnsigned {
+				utils.Fatalf("-config-url requires -config-key to verify the configuration, or -config-unsigned to accept it unsigned")
+			}
+			utils.Warnf("Remote configuration from %s is not verified: whoever can change the file or the response controls the agent", *flagConfigURL)
+		}
+		var err error
+		if remote, err = config.NewRemoteSource(*flagConfigURL, *flagConfigKey, cachePath); err != nil {
+			utils.Fatalf("Invalid remote configuration: %v", err)
+		}
+		if err := agent.FetchRemoteConfig(remote); err != nil {
+			utils.Fatalf("Failed to fetch configuration from %s: %v", *flagConfigURL, err)
+		}
+		*flagConfig = cachePath
+	}
+
 	// Set global config path for modules to use
 	if *flagConfig != "" {
 		config.GlobalConfigPath = *flagConfig
@@ -84,287 +151,185 @@ func main() {
 		utils.Debugf("Using default log level: info")
 	}
 
-	// Run all modules in a single process
-	runAllModules(globalConfig)
-}
-
-// ModuleManager handles the lifecycle of all metric collection modules.
-type ModuleManager struct {
-	globalConfig *config.GlobalConfig
-	metricCh     *metricchannel.Channel
-	signalCh     chan os.Signal
-}
-
-// NewModuleManager creates a new module manager instance.
-func NewModuleManager(globalConfig *config.GlobalConfig) *ModuleManager {
-	return &ModuleManager{
-		globalConfig: globalConfig,
-		signalCh:     make(chan os.Signal, 2),
+	// Handle the "modules" command. The "selftest" command runs after the
+	// network settings are applied.
+	if args := flag.Args(); len(args) > 0 && args[0] != "selftest" {
+		if args[0] != "modules" {
+			utils.Fatalf("Unknown command '%s'", args[0])
+		}
+		if err := agent.RunModulesCommand(os.Stdout, args[1:], globalConfig); err != nil {
+			utils.Fatalf("%v", err)
+		}
+		return
 	}
-}
-
-// runAllModules starts all registered modules concurrently in a single process.
-// It handles graceful shutdown on SIGTERM/SIGINT signals and module restart on SIGHUP.
-// Provides panic recovery for each module to ensure the process remains stable.
-func runAllModules(globalConfig *config.GlobalConfig) {
-	manager := NewModuleManager(globalConfig)
-	manager.run()
-}
-
-// run executes the main module management loop.
-func (mm *ModuleManager) run() {
-	// Set up signal handling
-	signal.Notify(mm.signalCh, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
-	defer signal.Stop(mm.signalCh)
-
-	// Channel to communicate signal type to main loop
-	signalType := make(chan os.Signal, 1)
-
-	// Signal handler goroutine
-	go mm.handleSignals(signalType)
 
-	for {
-		// Set up context for graceful shutdown
-		ctx, cancel := context.WithCancel(context.Background())
-
-		// Initialize metric channel and serializer
-		if err := mm.initializeMetricChannel(); err != nil {
-			utils.Errorf("Failed to initialize metric channel: %v", err)
-			cancel()
-			return
+	// Report migrated settings, so the configuration file can be updated
+	if globalConfig != nil && len(globalConfig.Migrations) > 0 {
+		for _, change := range globalConfig.Migrations {
+			utils.Warnf("Configuration migrated: %s", change)
 		}
-
-		// Filter and validate enabled modules
-		enabledModules, disabledModules := mm.filterEnabledModules()
-		if len(enabledModules) == 0 {
-			utils.Infof("No modules enabled, exiting")
-			mm.cleanup(cancel)
-			return
+		if globalConfig.ConfigVersion <= config.CurrentConfigVersion {
+			utils.Warnf("Update the configuration file and set \"config_version\": %d to silence these warnings", config.CurrentConfigVersion)
 		}
+	}
 
-		// Log module status
-		mm.logModuleStatus(enabledModules, disabledModules)
-
-		// Get restart configuration
-		maxRestarts := mm.getRestartLimit()
-
-		// Run all modules concurrently and wait for either completion or signal
-		done := make(chan struct{})
-		go func() {
-			mm.runModules(ctx, enabledModules, maxRestarts)
-			close(done)
-		}()
-
-		// Wait for either all modules to complete or a signal
-		select {
-		case sig := <-signalType:
-			mm.handleShutdownSignal(sig, cancel)
-			if sig == syscall.SIGHUP {
-				continue // Restart the loop
-			}
-			return // Exit the process
-		case <-done:
-			// All modules completed normally
-			utils.Infof("All modules completed normally")
-			mm.cleanup(cancel)
-			return
+	// Tune the garbage collector for the available memory
+	configureGC(globalConfig)
+
+	// Compute day boundaries in the configured timezone instead of the system's
+	if globalConfig != nil && globalConfig.Timezone != "" {
+		if err := utils.SetTimezone(globalConfig.Timezone); err != nil {
+			utils.Fatalf("Invalid timezone: %v", err)
 		}
+		utils.Infof("Using timezone %s for day boundaries", globalConfig.Timezone)
 	}
-}
 
-// handleSignals processes incoming signals and forwards them to the main loop.
-func (mm *ModuleManager) handleSignals(signalType chan<- os.Signal) {
-	utils.WithPanicRecoveryAndContinue("Signal handler", "main", func() {
-		for {
-			sig := <-mm.signalCh
-			utils.Infof("Received signal: %s", sig)
-			signalType <- sig
+	// Show the OAuth2 authorization pages in the configured language or from custom templates
+	if globalConfig != nil && globalConfig.OAuth2Pages != (config.OAuth2PagesConfig{}) {
+		pages := globalConfig.OAuth2Pages
+		if err := utils.SetOAuth2Pages(pages.Language, pages.LandingPage, pages.SuccessPage); err != nil {
+			utils.Fatalf("Invalid oauth2_pages: %v", err)
 		}
-	})
-}
-
-// initializeMetricChannel creates and starts the metric channel and serializer.
-func (mm *ModuleManager) initializeMetricChannel() error {
-	mm.metricCh = metricchannel.New(100)
-	utils.Debugf("Created metric channel with buffer size: 100")
-
-	mm.metricCh.StartSerializer()
-	utils.Debugf("Started metric serializer")
-
-	return nil
-}
-
-// filterEnabledModules returns lists of enabled and disabled modules based on configuration.
-func (mm *ModuleManager) filterEnabledModules() (enabled, disabled []string) {
-	allModuleNames := modules.Global.List()
-	utils.Debugf("Found %d registered modules: %v", len(allModuleNames), allModuleNames)
-
-	if len(allModuleNames) == 0 {
-		utils.Infof("No modules registered, exiting")
-		return nil, nil
 	}
 
-	return filterEnabledModules(allModuleNames, mm.globalConfig)
-}
-
-// logModuleStatus logs the status of enabled and disabled modules.
-func (mm *ModuleManager) logModuleStatus(enabled, disabled []string) {
-	if len(disabled) > 0 {
-		utils.Infof("Disabled modules: %v", disabled)
+	// Delay storage writes if configured, pending changes are flushed on shutdown
+	if globalConfig != nil && globalConfig.Storage.WriteDelay > 0 {
+		utils.SetStorageWriteDelay(globalConfig.Storage.WriteDelay.Duration(), globalConfig.Storage.MaxPendingWrites)
+		utils.Debugf("Storage writes delayed by %v", globalConfig.Storage.WriteDelay)
 	}
-	utils.Infof("Starting %d enabled modules: %v", len(enabled), enabled)
-}
 
-// getRestartLimit returns the configured restart limit with appropriate logging.
-func (mm *ModuleManager) getRestartLimit() int {
-	maxRestarts := 3 // Default value
-	if mm.globalConfig != nil {
-		if mm.globalConfig.ModuleRestartLimit == 0 {
-			maxRestarts = 0 // 0 means unlimited restarts
-		} else if mm.globalConfig.ModuleRestartLimit > 0 {
-			maxRestarts = mm.globalConfig.ModuleRestartLimit
+	// Limit the state files of the modules if storage quotas are configured
+	if globalConfig != nil && (globalConfig.Storage.StorageQuota != (config.StorageQuota{}) || len(globalConfig.Storage.Quotas) > 0) {
+		overrides := make(map[string]utils.StorageQuota, len(globalConfig.Storage.Quotas))
+		for name, quota := range globalConfig.Storage.Quotas {
+			overrides[name] = storageQuota(quota)
 		}
-		// If ModuleRestartLimit < 0, use default (3)
+		utils.SetStorageQuotas(storageQuota(globalConfig.Storage.StorageQuota), overrides)
+		utils.Debugf("Storage quotas enabled (max_size: %d bytes, max_age: %v, %d overrides)",
+			globalConfig.Storage.MaxSize, globalConfig.Storage.MaxAge, len(overrides))
 	}
 
-	if maxRestarts == 0 {
-		utils.Infof("Module restart limit: unlimited")
-		utils.Warnf("Unlimited restarts (limit=0) is NOT recommended for telegraf/systemd deployments!")
-	} else {
-		utils.Infof("Module restart limit: %d", maxRestarts)
+	// Record all outbound connections if an audit log is configured
+	if globalConfig != nil && globalConfig.AuditLog != "" {
+		if err := utils.OpenAuditLog(globalConfig.AuditLog); err != nil {
+			utils.Fatalf("Failed to enable audit log: %v", err)
+		}
+		defer utils.CloseAuditLog()
+		utils.Infof("Recording outbound connections in audit log: %s", globalConfig.AuditLog)
 	}
 
-	return maxRestarts
-}
-
-// runModules starts all enabled modules concurrently with restart capability.
-func (mm *ModuleManager) runModules(ctx context.Context, moduleNames []string, maxRestarts int) {
-	var wg sync.WaitGroup
-	for _, moduleName := range moduleNames {
-		wg.Add(1)
-		go mm.runModule(ctx, &wg, moduleName, maxRestarts)
+	// Restrict outbound connections to the allowed destinations
+	if globalConfig != nil && globalConfig.AllowedDestinations != nil {
+		if err := utils.SetNetworkAllowlist(globalConfig.AllowedDestinations); err != nil {
+			utils.Fatalf("Invalid allowed_destinations: %v", err)
+		}
+		if len(globalConfig.AllowedDestinations) == 0 {
+			utils.Infof("Offline mode: all outbound connections are blocked")
+		} else {
+			utils.Infof("Outbound connections restricted to: %s", strings.Join(globalConfig.AllowedDestinations, ", "))
+		}
 	}
 
-	// Wait for all modules to complete
-	wg.Wait()
-}
-
-// runModule runs a single module with restart capability.
-func (mm *ModuleManager) runModule(ctx context.Context, wg *sync.WaitGroup, moduleName string, maxRestarts int) {
-	defer wg.Done()
-
-	restartCount := 0
+	// Space out requests to the webservers of devices
+	if globalConfig != nil && globalConfig.DeviceRequestsPerSecond != 0 {
+		utils.SetDeviceRateLimit(globalConfig.DeviceRequestsPerSecond)
+	}
 
-	for {
-		// Check for context cancellation before each iteration
-		select {
-		case <-ctx.Done():
-			utils.Infof("[%s] module stopped due to context cancellation", moduleName)
-			return
-		default:
+	// Handle the "selftest" command
+	if args := flag.Args(); len(args) > 0 {
+		if len(args) > 1 {
+			utils.Fatalf("usage: metrics-agent selftest")
 		}
-
-		// Execute the module
-		mm.executeModule(ctx, moduleName, restartCount, maxRestarts)
-
-		// Check for context cancellation after module execution
-		select {
-		case <-ctx.Done():
-			utils.Infof("[%s] module stopped due to context cancellation", moduleName)
-			return
-		default:
+		if err := agent.RunSelfTest(context.Background(), os.Stdout, globalConfig); err != nil {
+			utils.Fatalf("%v", err)
 		}
+		return
+	}
 
-		// Increment restart count and check limits
-		restartCount++
-		if maxRestarts > 0 && restartCount >= maxRestarts {
-			utils.Errorf("[%s] module failed %d times, exiting program", moduleName, restartCount)
-			return
+	// Refuse to send raw device identifiers if anonymization can't be applied
+	if globalConfig != nil && globalConfig.Pipeline.Anonymize != nil {
+		if err := globalConfig.Pipeline.Anonymize.Validate(); err != nil {
+			utils.Fatalf("Invalid pipeline anonymize configuration: %v", err)
 		}
+	}
 
-		// Log restart and wait with context cancellation support
-		mm.logRestart(moduleName, restartCount, maxRestarts)
-
-		// Use context-aware sleep instead of time.Sleep
-		select {
-		case <-ctx.Done():
-			utils.Infof("[%s] module stopped due to context cancellation during restart delay", moduleName)
-			return
-		case <-time.After(1 * time.Second):
-			// Continue to next iteration
+	// Refuse to start without a configured custom processor, e.g. one applying
+	// business logic the outputs rely on
+	if globalConfig != nil {
+		if err := processors.ValidateCustom(globalConfig.Pipeline.Custom); err != nil {
+			utils.Fatalf("Invalid pipeline custom configuration: %v", err)
 		}
 	}
-}
 
-// executeModule runs a single module execution with panic recovery.
-func (mm *ModuleManager) executeModule(ctx context.Context, moduleName string, restartCount, maxRestarts int) {
-	utils.WithPanicRecoveryAndContinue("Module execution", moduleName, func() {
-		if maxRestarts == 0 {
-			utils.Infof("[%s] starting module (attempt %d/unlimited)", moduleName, restartCount+1)
-		} else {
-			utils.Infof("[%s] starting module (attempt %d/%d)", moduleName, restartCount+1, maxRestarts+1)
+	// Refuse to serve the HTTP endpoints with incomplete authentication or TLS
+	if globalConfig != nil {
+		if err := globalConfig.HTTP.Validate(); err != nil {
+			utils.Fatalf("Invalid http configuration: %v", err)
 		}
-		if err := modules.Global.Run(ctx, moduleName, mm.metricCh.Get()); err != nil {
-			utils.Errorf("[%s] module error: %v", moduleName, err)
-		}
-		utils.Infof("[%s] module stopped", moduleName)
-	})
-}
+	}
+
+	// Serve pprof endpoints for diagnosing performance issues
+	if *flagPprof != "" {
+		utils.StartPprofServer(*flagPprof)
+	}
 
-// logRestart logs module restart information.
-func (mm *ModuleManager) logRestart(moduleName string, restartCount, maxRestarts int) {
-	if maxRestarts == 0 {
-		utils.Infof("[%s] restarting module after completion/panic (restart %d/unlimited)", moduleName, restartCount)
+	// Keep stray writes to stdout from corrupting the metric stream
+	if restore, err := utils.RedirectStrayStdout(); err != nil {
+		utils.Warnf("Failed to redirect stray stdout output: %v", err)
 	} else {
-		utils.Infof("[%s] restarting module after completion/panic (restart %d/%d)", moduleName, restartCount, maxRestarts)
+		defer restore()
 	}
-}
 
-// handleShutdownSignal processes shutdown signals and cleans up resources.
-func (mm *ModuleManager) handleShutdownSignal(sig os.Signal, cancel context.CancelFunc) {
-	utils.Infof("Received %s, stopping modules...", sig)
-	cancel() // Stop all modules
+	// Identify the agent and this run in logs and metrics
+	agentID := utils.OpenAgentID()
+	if globalConfig != nil && globalConfig.Identity.Logs {
+		utils.SetGlobalLogPrefix(fmt.Sprintf("agent=%s run=%s", agentID, utils.RunID()))
+	}
+	utils.Infof("Starting metrics-agent %s (agent_id=%s, run_id=%s)", version, agentID, utils.RunID())
 
-	// Clean up resources
-	mm.cleanup(cancel)
+	// Count the start of an installed update; one that keeps failing is rolled back
+	updater := agent.OpenUpdater(globalConfig)
+	updatePending := agent.StartUpdate(updater)
 
-	switch sig {
-	case syscall.SIGHUP:
-		utils.Infof("Restarting all modules...")
-	case syscall.SIGTERM, syscall.SIGINT:
-		utils.Infof("Shutting down...")
-	}
+	// Run all modules in a single process
+	agent.Run(globalConfig, agent.Options{
+		ConfigPath:    configPath,
+		Remote:        remote,
+		Updater:       updater,
+		UpdatePending: updatePending,
+		ConfigRefresh: *flagConfigRefresh,
+		ProfileDir:    *flagProfileDir,
+	})
 }
 
-// cleanup closes the metric channel and cancels the context.
-func (mm *ModuleManager) cleanup(cancel context.CancelFunc) {
-	if mm.metricCh != nil {
-		mm.metricCh.Close()
-	}
-	cancel()
+// storageQuota converts a configured storage quota.
+func storageQuota(quota config.StorageQuota) utils.StorageQuota {
+	return utils.StorageQuota{MaxSize: quota.MaxSize, MaxAge: quota.MaxAge.Duration()}
 }
 
-// filterEnabledModules filters modules based on enabled configuration.
-// This function is extracted to be reusable between main.go and tests.
-func filterEnabledModules(allModuleNames []string, globalConfig *config.GlobalConfig) (enabled, disabled []string) {
-	for _, moduleName := range allModuleNames {
-		enabledFlag := false
-		if globalConfig != nil && globalConfig.Modules != nil {
-			if moduleConfig, exists := globalConfig.Modules[moduleName]; exists {
-				enabledFlag = moduleConfig.Enabled
-				utils.Debugf("Module %s: enabled=%v (from config)", moduleName, enabledFlag)
+// configureGC applies the configured GC settings, falling back to defaults
+// based on the system memory.
+func configureGC(globalConfig *config.GlobalConfig) {
+	totalMemory, _ := utils.SystemMemory()
+	gcPercent, memoryLimit := utils.DefaultGCSettings(totalMemory)
+
+	if globalConfig != nil {
+		if globalConfig.GCPercent != nil {
+			gcPercent = *globalConfig.GCPercent
+		}
+		if globalConfig.MemoryLimit != "" {
+			if limit, err := utils.ParseMemorySize(globalConfig.MemoryLimit); err == nil {
+				memoryLimit = limit
 			} else {
-				utils.Debugf("Module %s: enabled=false (no config found)", moduleName)
+				utils.Warnf("Ignoring memory_limit: %v", err)
 			}
-		} else {
-			utils.Debugf("Module %s: enabled=false (no global config)", moduleName)
 		}
+	}
 
-		if enabledFlag {
-			enabled = append(enabled, moduleName)
-		} else {
-			disabled = append(disabled, moduleName)
-		}
+	utils.ConfigureGC(gcPercent, memoryLimit)
+	if memoryLimit == math.MaxInt64 {
+		utils.Debugf("GC configured: gc_percent=%d, no memory limit", gcPercent)
+	} else {
+		utils.Debugf("GC configured: gc_percent=%d, memory_limit=%d bytes", gcPercent, memoryLimit)
 	}
-	return enabled, disabled
 }
@@ -0,0 +1,8 @@
+package main
+
+// Custom pipeline processors (see package pkg/processor) are compiled in by
+// importing their package in this file, e.g.
+//
+//	import _ "example.com/site/metrics-processors"
+//
+// and enabled in the "custom" section of the pipeline configuration.
@@ -0,0 +1,757 @@
+// Package agent runs all registered metric collection modules concurrently in
+// a single process, designed to work with telegraf's inputs.execd plugin. It
+// restarts failed modules, passes their metrics through the pipeline to stdout
+// and the configured outputs, reloads the configuration on SIGHUP and reports
+// its own status.
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/httpserver"
+	"github.com/janhuddel/metrics-agent/internal/metricchannel"
+	"github.com/janhuddel/metrics-agent/internal/notify"
+	"github.com/janhuddel/metrics-agent/internal/otlp"
+	"github.com/janhuddel/metrics-agent/internal/processors"
+	"github.com/janhuddel/metrics-agent/internal/prometheus"
+	"github.com/janhuddel/metrics-agent/internal/update"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// defaultRecentMetrics is the number of metrics kept per module for the "recent" command
+const defaultRecentMetrics = 10
+
+// defaultSerializerShards is the number of goroutines that process and serialize metrics
+const defaultSerializerShards = 4
+
+// defaultMaxLineLength is the maximum length of a written line in bytes, the
+// longest line telegraf's execd input reads
+const defaultMaxLineLength = 64 * 1024
+
+// defaultModuleConcurrency is the number of goroutines each module may run
+// concurrently to emit metrics
+const defaultModuleConcurrency = 64
+
+// defaultMetricBuffer is the buffer size of the shared metric channel and each serializer shard
+const defaultMetricBuffer = 100
+
+// defaultModuleBuffer is the buffer size of the channel each module sends its metrics to
+const defaultModuleBuffer = 100
+
+// defaultStopTimeout is how long each module may take to stop on shutdown or reload
+const defaultStopTimeout = 10 * time.Second
+
+// defaultRestartHistory is the number of restarts recorded per module
+const defaultRestartHistory = 20
+
+// cpuProfileDuration is how long the CPU profile runs when profiles are dumped on SIGUSR2
+const cpuProfileDuration = 30 * time.Second
+
+// Collection trigger modes for interval-based modules
+const (
+	triggerInterval = "interval"
+	triggerSignal   = "signal"
+	triggerStdin    = "stdin"
+)
+
+// version, commit and date describe the running binary, see SetBuildInfo
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// SetBuildInfo sets the version, Git commit and build date of the running
+// binary, which are set at build time with -ldflags. If commit or date are
+// empty, they are taken from the VCS information Go embeds.
+func SetBuildInfo(buildVersion, buildCommit, buildDate string) {
+	version, commit, date = buildVersion, buildCommit, buildDate
+}
+
+// Options are the settings of the agent that don't come from the
+// configuration file.
+type Options struct {
+	ConfigPath    string               // watched for changes if watch_config is set
+	Remote        *config.RemoteSource // fetches ConfigPath from a URL if set
+	Updater       *update.Updater      // checks for new releases if set
+	UpdatePending bool                 // an installed update runs and waits for confirmation
+	ConfigRefresh time.Duration        // how often the remote configuration is fetched again
+	ProfileDir    string               // where profiles are written on SIGUSR2
+}
+
+// ModuleManager handles the lifecycle of all metric collection modules.
+type ModuleManager struct {
+	configPath    string               // watched for changes if watch_config is set
+	remote        *config.RemoteSource // fetches configPath from a URL if set
+	configRefresh time.Duration        // how often remote is fetched again
+	profileDir    string               // where profiles are written on SIGUSR2
+	updater       *update.Updater      // checks for new releases if set
+	updateReady   bool                 // an installed update runs and waits for confirmation
+	pipeline      *processors.Pipeline
+	notifier      *notify.Notifier
+	exporter      *otlp.Exporter
+	scrape        *prometheus.Exporter
+	httpServer    *httpserver.Server
+	recent        *metricchannel.Recent
+	inventory     *utils.DeviceInventory
+	absence       *processors.AbsenceTracker
+	restarts      *utils.RestartHistory
+	signalCh      chan os.Signal
+	triggerMode   string
+	startTime     time.Time
+
+	// configMu guards globalConfig and the pipeline, notifier and absence
+	// tracker built from it, which are replaced when the configuration
+	// changes. pendingConfig is a changed configuration applied on the next
+	// reload of the modules.
+	configMu      sync.RWMutex
+	globalConfig  *config.GlobalConfig
+	pendingConfig *config.GlobalConfig
+
+	// metricMu guards metricCh, which is replaced on every reload. The
+	// emit-test command and endpoint, which don't run within a run of the
+	// modules, hold it for reading while they send.
+	metricMu sync.RWMutex
+	metricCh *metricchannel.Channel
+
+	// senders are the goroutines sending to metricCh during a run of the
+	// modules; they are waited for before metricCh is closed
+	senders sync.WaitGroup
+
+	// stateMu protects moduleStates, which is reported by the "status" command
+	stateMu      sync.Mutex
+	moduleStates map[string]string
+	probeResults map[string]string
+	paused       map[string]int       // paused modules and the number of metrics dropped since
+	lastMetric   map[string]time.Time // when each module last passed on a metric
+	latest       string               // newer release found by the update check
+}
+
+// NewModuleManager creates a new module manager instance.
+func NewModuleManager(globalConfig *config.GlobalConfig) *ModuleManager {
+	return &ModuleManager{
+		globalConfig: globalConfig,
+		pipeline:     newPipeline(globalConfig),
+		notifier:     newNotifier(globalConfig),
+		exporter:     newExporter(globalConfig),
+		scrape:       newPrometheus(globalConfig),
+		httpServer:   newHTTPServer(globalConfig),
+		recent:       newRecent(globalConfig),
+		inventory:    utils.NewDeviceInventory(),
+		absence:      newAbsenceTracker(globalConfig),
+		signalCh:     make(chan os.Signal, 2),
+		triggerMode:  getTriggerMode(globalConfig),
+		startTime:    time.Now(),
+		moduleStates: make(map[string]string),
+		probeResults: make(map[string]string),
+		paused:       make(map[string]int),
+		lastMetric:   make(map[string]time.Time),
+	}
+}
+
+// Run starts all registered modules concurrently in a single process.
+// It handles graceful shutdown on SIGTERM/SIGINT signals and module restart on SIGHUP.
+// Provides panic recovery for each module to ensure the process remains stable.
+func Run(globalConfig *config.GlobalConfig, opts Options) {
+	manager := NewModuleManager(globalConfig)
+	manager.configPath = opts.ConfigPath
+	manager.remote = opts.Remote
+	manager.configRefresh = opts.ConfigRefresh
+	manager.profileDir = opts.ProfileDir
+	manager.updater = opts.Updater
+	manager.updateReady = opts.UpdatePending
+	manager.run()
+}
+
+// run executes the main module management loop.
+func (mm *ModuleManager) run() {
+	// Set up signal handling
+	signals := []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGUSR2}
+	if mm.triggerMode == triggerSignal {
+		signals = append(signals, syscall.SIGUSR1)
+	}
+	signal.Notify(mm.signalCh, signals...)
+	defer signal.Stop(mm.signalCh)
+
+	// Settings read once are taken from the configuration the agent started with
+	globalConfig := mm.currentConfig()
+
+	// Record module restarts across agent restarts. Initialized before the
+	// stdin commands and the HTTP endpoints, which report it.
+	mm.restarts = newRestartHistory(globalConfig)
+
+	// Set up collection triggers (tickers created by modules pick up the mode)
+	utils.SetTriggeredCollection(mm.triggerMode != triggerInterval)
+	utils.Infof("Collection trigger: %s", mm.triggerMode)
+	go mm.readStdinCommands(os.Stdin)
+
+	// Channel to communicate signal type to main loop
+	signalType := make(chan os.Signal, 1)
+
+	// Signal handler goroutine
+	go mm.handleSignals(signalType)
+
+	// Serve the latest metrics for scraping
+	mm.scrape.Start()
+
+	// Serve the status of the agent; a busy port doesn't stop the modules
+	mm.httpServer.HandleFunc("/status", mm.serveStatus)
+	mm.httpServer.HandleFunc("/emit-test", mm.serveEmitTest)
+	mm.httpServer.HandleFunc("/modules", serveModules)
+	if err := mm.httpServer.Start(); err != nil {
+		utils.Errorf("Failed to start HTTP server: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		mm.httpServer.Stop(ctx)
+	}()
+
+	// Export metrics to OpenTelemetry; the last batch is sent on shutdown
+	if mm.exporter != nil {
+		utils.Infof("Exporting metrics to OTLP endpoint: %s", globalConfig.OTLP.Endpoint)
+		mm.exporter.Start()
+		defer mm.exporter.Stop()
+	}
+
+	// Reload the modules when the configuration file changes
+	if globalConfig != nil && globalConfig.WatchConfig {
+		watchCtx, stopWatch := context.WithCancel(context.Background())
+		defer stopWatch()
+		go mm.watchConfig(watchCtx)
+	}
+
+	// Fetch changes of the remote configuration file
+	if mm.remote != nil && mm.configRefresh > 0 {
+		refreshCtx, stopRefresh := context.WithCancel(context.Background())
+		defer stopRefresh()
+		go mm.refreshRemoteConfig(refreshCtx, mm.configRefresh)
+	}
+
+	// Confirm a running update and check for newer releases
+	if mm.updater != nil {
+		updateCtx, stopUpdates := context.WithCancel(context.Background())
+		defer stopUpdates()
+		go mm.runUpdates(updateCtx)
+	}
+
+	// Report the agent as up. The deferred "last will" reports a planned stop, so
+	// a missing agent_status=0 means the agent or its host died.
+	mm.writeAgentStatus(true, "started")
+	mm.writeAgentMetric(agentInfoMetric())
+	stopReason := "stopped"
+	defer func() { mm.writeAgentStatus(false, stopReason) }()
+
+	for {
+		// The configuration is replaced on a reload
+		globalConfig = mm.currentConfig()
+
+		// Set up context for graceful shutdown
+		ctx, cancel := context.WithCancel(context.Background())
+
+		// Initialize metric channel and serializer
+		if err := mm.initializeMetricChannel(); err != nil {
+			utils.Errorf("Failed to initialize metric channel: %v", err)
+			cancel()
+			return
+		}
+
+		// Filter and validate enabled modules
+		enabledModules, disabledModules := mm.filterEnabledModules()
+		if len(enabledModules) == 0 {
+			utils.Infof("No modules enabled, exiting")
+			mm.cleanup(cancel)
+			return
+		}
+
+		// Log module status
+		mm.logModuleStatus(enabledModules, disabledModules)
+
+		// Each module instance is supervised on its own
+		enabledModules = expandInstances(enabledModules, globalConfig)
+
+		// Skip modules whose configuration section is broken
+		enabledModules = mm.skipConfigErrors(enabledModules)
+		if len(enabledModules) == 0 {
+			utils.Errorf("No modules with valid configuration, exiting")
+			mm.cleanup(cancel)
+			return
+		}
+
+		// Skip modules whose startup probe fails
+		enabledModules = mm.probeModules(ctx, enabledModules)
+		if len(enabledModules) == 0 {
+			utils.Errorf("No modules passed their startup probe, exiting")
+			mm.cleanup(cancel)
+			return
+		}
+
+		// Summarize what actually runs, e.g. to verify a config change on a remote host
+		summary := mm.configSummary(enabledModules)
+		summary.log()
+		if globalConfig != nil && globalConfig.ConfigSummaryMetric {
+			mm.writeAgentMetric(summary.metric())
+		}
+
+		// Report the resource usage of the modules as self-metrics
+		if interval := mm.getSelfMetricsInterval(); interval > 0 {
+			mm.goSender(func() { mm.reportSelfMetrics(ctx, interval) })
+		}
+
+		// Notify about modules exceeding their error budget
+		go mm.watchErrorBudgets(ctx)
+
+		// Report the devices known to the modules
+		if interval := mm.getDeviceInventoryInterval(); interval > 0 {
+			mm.goSender(func() { mm.reportDeviceInventory(ctx, interval) })
+		}
+
+		// Mark devices that fell silent as absent
+		if mm.absence != nil {
+			mm.goSender(func() { mm.reportAbsence(ctx, mm.absence.CheckInterval()) })
+		}
+
+		// Send a heartbeat for each running module
+		if interval := mm.getHeartbeatInterval(); interval > 0 {
+			mm.goSender(func() { mm.reportHeartbeats(ctx, interval) })
+		}
+
+		// Write the status for monitoring without the HTTP endpoint
+		if path, interval := mm.getStatusFile(); path != "" {
+			go mm.writeStatusFiles(ctx, path, interval)
+		}
+
+		// Get restart configuration
+		maxRestarts := mm.getRestartLimit()
+
+		// Run all modules concurrently and wait for either completion or signal
+		stopped := make(map[string]chan struct{}, len(enabledModules))
+		for _, moduleName := range enabledModules {
+			stopped[moduleName] = make(chan struct{})
+		}
+		done := make(chan struct{})
+		go func() {
+			mm.runModules(ctx, enabledModules, maxRestarts, stopped)
+			close(done)
+		}()
+
+		// Wait for either all modules to complete or a signal
+		select {
+		case sig := <-signalType:
+			mm.handleShutdownSignal(sig, cancel, stopped)
+			if sig == syscall.SIGHUP {
+				mm.reloadConfig()
+				continue // Restart the loop
+			}
+			stopReason = "signal"
+			return // Exit the process
+		case <-done:
+			// All modules completed normally
+			utils.Infof("All modules completed normally")
+			mm.cleanup(cancel)
+			return
+		}
+	}
+}
+
+// handleSignals processes incoming signals and forwards them to the main loop.
+func (mm *ModuleManager) handleSignals(signalType chan<- os.Signal) {
+	utils.WithPanicRecoveryAndContinue("Signal handler", "main", func() {
+		for {
+			sig := <-mm.signalCh
+			if sig == syscall.SIGUSR1 {
+				utils.Debugf("Received %s, triggering collection", sig)
+				utils.TriggerCollection()
+				continue
+			}
+			if sig == syscall.SIGUSR2 {
+				go dumpProfiles(mm.profileDir)
+				continue
+			}
+			utils.Infof("Received signal: %s", sig)
+			signalType <- sig
+		}
+	})
+}
+
+// dumpProfiles writes CPU and heap profiles to dir.
+func dumpProfiles(dir string) {
+	utils.WithPanicRecoveryAndContinue("Profile dump", "main", func() {
+		utils.Infof("Recording %v CPU profile and heap profile to %s", cpuProfileDuration, dir)
+		paths, err := utils.WriteProfiles(dir, cpuProfileDuration)
+		if err != nil {
+			utils.Errorf("Failed to write profiles: %v", err)
+			return
+		}
+		utils.Infof("Profiles written: %s", strings.Join(paths, ", "))
+	})
+}
+
+// buildInfo returns the Git commit and build date of the binary. Values not
+// set with -ldflags are taken from the VCS information embedded by go build,
+// which has the commit time instead of the build date. Unknown values are "".
+func buildInfo() (string, string) {
+	revision, built := commit, date
+	if revision != "" && built != "" {
+		return revision, built
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return revision, built
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if revision == "" {
+				revision = setting.Value
+				if len(revision) > 7 {
+					revision = revision[:7]
+				}
+			}
+		case "vcs.time":
+			if built == "" {
+				built = setting.Value
+			}
+		}
+	}
+	return revision, built
+}
+
+// VersionString describes the running binary for the -version flag.
+func VersionString() string {
+	revision, built := buildInfo()
+	if revision == "" {
+		revision = "unknown"
+	}
+	if built == "" {
+		built = "unknown"
+	}
+	return fmt.Sprintf("metrics-agent %s (commit %s, built %s, %s %s/%s)",
+		version, revision, built, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// currentConfig returns the running configuration, which is replaced when
+// the configuration changes.
+func (mm *ModuleManager) currentConfig() *config.GlobalConfig {
+	mm.configMu.RLock()
+	defer mm.configMu.RUnlock()
+	return mm.globalConfig
+}
+
+// optionalString returns a pointer to value, or nil to mark an empty value as missing.
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// initializeMetricChannel creates and starts the metric channel and serializer.
+func (mm *ModuleManager) initializeMetricChannel() error {
+	globalConfig := mm.currentConfig()
+	bufferSize := mm.metricBuffer()
+	metricCh := metricchannel.New(bufferSize)
+	utils.Debugf("Created metric channel with buffer size: %d", bufferSize)
+
+	// The pipeline is shared across restarts, so processors keep their state
+	if mm.pipeline.Len() > 0 {
+		metricCh.SetProcessor(mm.pipeline)
+		utils.Debugf("Using metric pipeline with %d processors", mm.pipeline.Len())
+	}
+	if mm.exporter != nil {
+		metricCh.AddExporter(mm.exporter)
+	}
+	if mm.scrape != nil {
+		metricCh.AddExporter(mm.scrape)
+	}
+
+	if globalConfig != nil && globalConfig.Pipeline.ReorderWindow > 0 {
+		window := globalConfig.Pipeline.ReorderWindow.Duration()
+		metricCh.SetReorderWindow(window)
+		utils.Debugf("Reordering metrics by timestamp within %v", window)
+	}
+
+	metricCh.SetLimits(mm.lineLimits())
+
+	shards := mm.serializerShards()
+	metricCh.SetShards(shards)
+	metricCh.StartSerializer()
+	utils.Debugf("Started metric serializer with %d shards", shards)
+
+	mm.metricMu.Lock()
+	mm.metricCh = metricCh
+	mm.metricMu.Unlock()
+	return nil
+}
+
+// channel returns the metric channel of the current run of the modules, or
+// nil if none is running.
+func (mm *ModuleManager) channel() *metricchannel.Channel {
+	mm.metricMu.RLock()
+	defer mm.metricMu.RUnlock()
+	return mm.metricCh
+}
+
+// goSender runs fn in a goroutine that sends to the metric channel of the
+// current run of the modules. It must return once the context of the run is
+// cancelled, as the channel is only closed after it returned.
+func (mm *ModuleManager) goSender(fn func()) {
+	mm.senders.Add(1)
+	go func() {
+		defer mm.senders.Done()
+		fn()
+	}()
+}
+
+// serializerShards returns the number of goroutines that process and
+// serialize metrics.
+func (mm *ModuleManager) serializerShards() int {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil || globalConfig.SerializerShards == 0 {
+		return defaultSerializerShards
+	}
+	return max(globalConfig.SerializerShards, 1)
+}
+
+// lineLimits returns the limits of the written lines. Unknown actions are
+// logged and ignored.
+func (mm *ModuleManager) lineLimits() metricchannel.Limits {
+	globalConfig := mm.currentConfig()
+	limits := metricchannel.Limits{MaxLineLength: defaultMaxLineLength}
+	if globalConfig == nil {
+		return limits
+	}
+	cfg := globalConfig.LineLimits
+	if cfg.MaxLineLength != 0 {
+		limits.MaxLineLength = max(cfg.MaxLineLength, 0)
+	}
+	limits.MaxFields = max(cfg.MaxFields, 0)
+	limits.MaxStringLength = max(cfg.MaxStringLength, 0)
+	switch cfg.Action {
+	case "", "truncate":
+	case "drop":
+		limits.Drop = true
+	default:
+		utils.Warnf("Ignoring unknown line_limits action %q", cfg.Action)
+	}
+	return limits
+}
+
+// metricBuffer returns the buffer size of the shared metric channel.
+func (mm *ModuleManager) metricBuffer() int {
+	globalConfig := mm.currentConfig()
+	size := defaultMetricBuffer
+	if globalConfig != nil && globalConfig.MetricBuffer != 0 {
+		size = globalConfig.MetricBuffer
+	}
+	return max(size, 0)
+}
+
+// handleShutdownSignal processes shutdown signals, waits for the modules to
+// stop and cleans up resources.
+func (mm *ModuleManager) handleShutdownSignal(sig os.Signal, cancel context.CancelFunc, stopped map[string]chan struct{}) {
+	utils.Infof("Received %s, stopping modules...", sig)
+	cancel() // Stop all modules
+
+	start := time.Now()
+	if stuck := mm.waitForModules(stopped); len(stuck) > 0 {
+		utils.Warnf("Modules not stopped in time: %s", strings.Join(stuck, ", "))
+	} else {
+		utils.Infof("All modules stopped in %v", time.Since(start).Round(time.Millisecond))
+	}
+
+	// Clean up resources
+	mm.cleanup(cancel)
+
+	switch sig {
+	case syscall.SIGHUP:
+		utils.Infof("Restarting all modules...")
+	case syscall.SIGTERM, syscall.SIGINT:
+		utils.Infof("Shutting down...")
+	}
+}
+
+// waitForModules waits for the modules to return after their context was
+// cancelled. All modules are waited for in parallel, each with its own stop
+// timeout, so one stuck module doesn't cut the time of the others short. The
+// modules that did not stop in time are logged with their remaining goroutines,
+// marked in the status and returned.
+func (mm *ModuleManager) waitForModules(stopped map[string]chan struct{}) []string {
+	var (
+		mu    sync.Mutex
+		stuck []string
+		wg    sync.WaitGroup
+	)
+	for moduleName, ch := range stopped {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			timer := time.NewTimer(mm.stopTimeout(moduleName))
+			defer timer.Stop()
+			select {
+			case <-ch:
+			case <-timer.C:
+				mu.Lock()
+				stuck = append(stuck, moduleName)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(stuck) == 0 {
+		return nil
+	}
+
+	sort.Strings(stuck)
+	goroutines, err := utils.GoroutinesByLabel(moduleLabel)
+	if err != nil {
+		utils.Debugf("Failed to count goroutines per module: %v", err)
+	}
+	for _, moduleName := range stuck {
+		utils.Warnf("[%s] module did not stop within %v, %d goroutines still running",
+			moduleName, mm.stopTimeout(moduleName), goroutines[moduleName])
+		mm.setModuleState(moduleName, stateStopTimeout)
+	}
+	return stuck
+}
+
+// stopTimeout returns how long a module may take to stop. A module setting
+// takes precedence over the global one; instances use the setting of their module.
+func (mm *ModuleManager) stopTimeout(moduleName string) time.Duration {
+	globalConfig := mm.currentConfig()
+	timeout := defaultStopTimeout
+	if globalConfig == nil {
+		return timeout
+	}
+	if global := globalConfig.StopTimeout; global > 0 {
+		timeout = global.Duration()
+	}
+	if module := globalConfig.Modules[baseModuleName(moduleName)].StopTimeout; module > 0 {
+		timeout = module.Duration()
+	}
+	return timeout
+}
+
+// cleanup cancels the context, closes the metric channel once all goroutines
+// sending to it returned and writes pending storage changes.
+func (mm *ModuleManager) cleanup(cancel context.CancelFunc) {
+	cancel()
+	mm.senders.Wait()
+
+	mm.metricMu.Lock()
+	if mm.metricCh != nil {
+		mm.metricCh.Close()
+		mm.metricCh = nil
+	}
+	mm.metricMu.Unlock()
+	utils.FlushStorages()
+}
+
+// newAbsenceTracker creates the tracker of absent devices, or nil if it is
+// not configured.
+func newAbsenceTracker(globalConfig *config.GlobalConfig) *processors.AbsenceTracker {
+	if globalConfig == nil || globalConfig.Pipeline.Absence == nil {
+		return nil
+	}
+	return processors.NewAbsenceTracker(*globalConfig.Pipeline.Absence)
+}
+
+// newPipeline creates the metric processing pipeline from the configuration.
+func newPipeline(globalConfig *config.GlobalConfig) *processors.Pipeline {
+	if globalConfig == nil {
+		return processors.NewPipeline()
+	}
+	pipeline := processors.FromConfig(globalConfig.Pipeline)
+	if tagger := processors.NewTagger(identityTags(globalConfig)); tagger != nil {
+		pipeline.Append(tagger)
+	}
+	return pipeline
+}
+
+// newNotifier creates the notifier for failing modules from the configuration.
+// It returns nil if notifications are not configured.
+func newNotifier(globalConfig *config.GlobalConfig) *notify.Notifier {
+	if globalConfig == nil {
+		return nil
+	}
+	return notify.New(globalConfig.Notify)
+}
+
+// newExporter creates the OTLP exporter from the global configuration.
+// It returns nil if no OTLP endpoint is configured or the configuration is invalid.
+func newExporter(globalConfig *config.GlobalConfig) *otlp.Exporter {
+	if globalConfig == nil {
+		return nil
+	}
+	exporter, err := otlp.New(globalConfig.OTLP, version)
+	if err != nil {
+		utils.Errorf("Invalid otlp configuration, metrics are not exported: %v", err)
+		return nil
+	}
+	return exporter
+}
+
+// newRecent creates the buffer of recent metrics per module, or nil if it is disabled.
+func newRecent(globalConfig *config.GlobalConfig) *metricchannel.Recent {
+	size := defaultRecentMetrics
+	if globalConfig != nil && globalConfig.RecentMetrics != 0 {
+		size = globalConfig.RecentMetrics
+	}
+	return metricchannel.NewRecent(size)
+}
+
+// newRestartHistory creates the restart history of the modules, or nil if it
+// is disabled.
+func newRestartHistory(globalConfig *config.GlobalConfig) *utils.RestartHistory {
+	limit := defaultRestartHistory
+	if globalConfig != nil && globalConfig.RestartHistory != 0 {
+		limit = globalConfig.RestartHistory
+	}
+	return utils.OpenRestartHistory(limit)
+}
+
+// newPrometheus creates the Prometheus endpoint from the global configuration.
+// It returns nil if no endpoint is configured.
+func newPrometheus(globalConfig *config.GlobalConfig) *prometheus.Exporter {
+	if globalConfig == nil {
+		return nil
+	}
+	return prometheus.New(globalConfig.Prometheus)
+}
+
+// newHTTPServer creates the HTTP server from the global configuration.
+// It returns nil if no server is configured.
+func newHTTPServer(globalConfig *config.GlobalConfig) *httpserver.Server {
+	if globalConfig == nil {
+		return nil
+	}
+	return httpserver.New(globalConfig.HTTP)
+}
+
+// getTriggerMode returns the configured collection trigger mode.
+// Unknown values fall back to interval-based collection.
+func getTriggerMode(globalConfig *config.GlobalConfig) string {
+	if globalConfig == nil || globalConfig.CollectionTrigger == "" {
+		return triggerInterval
+	}
+
+	mode := strings.ToLower(globalConfig.CollectionTrigger)
+	switch mode {
+	case triggerInterval, triggerSignal, triggerStdin:
+		return mode
+	default:
+		utils.Warnf("Unknown collection trigger '%s', using '%s'", globalConfig.CollectionTrigger, triggerInterval)
+		return triggerInterval
+	}
+}
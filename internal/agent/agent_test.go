@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/metricchannel"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestGetTriggerMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *config.GlobalConfig
+		expected string
+	}{
+		{"nil config", nil, triggerInterval},
+		{"not set", &config.GlobalConfig{}, triggerInterval},
+		{"signal", &config.GlobalConfig{CollectionTrigger: "signal"}, triggerSignal},
+		{"stdin uppercase", &config.GlobalConfig{CollectionTrigger: "STDIN"}, triggerStdin},
+		{"unknown", &config.GlobalConfig{CollectionTrigger: "cron"}, triggerInterval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if mode := getTriggerMode(tt.config); mode != tt.expected {
+				t.Errorf("Expected trigger mode %s, got %s", tt.expected, mode)
+			}
+		})
+	}
+}
+
+func TestBufferSizes(t *testing.T) {
+	if size := NewModuleManager(nil).metricBuffer(); size != defaultMetricBuffer {
+		t.Errorf("Expected default metric buffer %d without config, got %d", defaultMetricBuffer, size)
+	}
+
+	mm := NewModuleManager(&config.GlobalConfig{
+		MetricBuffer: 1000,
+		ModuleBuffer: 10,
+		Modules: map[string]config.ModuleConfig{
+			"opendtu": {BufferSize: 500},
+			"meter":   {BufferSize: -1},
+		},
+	})
+	if size := mm.metricBuffer(); size != 1000 {
+		t.Errorf("Expected metric buffer 1000, got %d", size)
+	}
+	for module, expected := range map[string]int{"demo": 10, "opendtu.haus1": 500, "meter": 0} {
+		if size := mm.moduleBuffer(module); size != expected {
+			t.Errorf("Expected buffer %d for %s, got %d", expected, module, size)
+		}
+	}
+	mm.metricCh = metricchannel.New(mm.metricBuffer())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if size := cap(mm.moduleChannel(ctx, "opendtu")); size != 500 {
+		t.Errorf("Expected module channel with buffer 500, got %d", size)
+	}
+}
+
+func TestSerializerShards(t *testing.T) {
+	if shards := NewModuleManager(nil).serializerShards(); shards != defaultSerializerShards {
+		t.Errorf("Expected default %d shards without config, got %d", defaultSerializerShards, shards)
+	}
+	for configured, expected := range map[int]int{1: 1, 8: 8, -2: 1} {
+		if shards := NewModuleManager(&config.GlobalConfig{SerializerShards: configured}).serializerShards(); shards != expected {
+			t.Errorf("Expected %d shards for %d, got %d", expected, configured, shards)
+		}
+	}
+}
+
+func TestWaitForModules(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{
+		StopTimeout: config.Duration(time.Second),
+		Modules: map[string]config.ModuleConfig{
+			"stuck": {StopTimeout: config.Duration(20 * time.Millisecond)},
+		},
+	})
+
+	// A stuck module doesn't shorten the timeout of a module still stopping
+	stopped := map[string]chan struct{}{
+		"stuck":    make(chan struct{}),
+		"stopping": make(chan struct{}),
+		"done":     make(chan struct{}),
+	}
+	close(stopped["done"])
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(stopped["stopping"])
+	}()
+
+	stuck := mm.waitForModules(stopped)
+	if len(stuck) != 1 || stuck[0] != "stuck" {
+		t.Errorf("Expected only stuck to fail to stop, got %v", stuck)
+	}
+	if state := mm.moduleStates["stuck"]; state != stateStopTimeout {
+		t.Errorf("Expected state %s, got %q", stateStopTimeout, state)
+	}
+	if timeout := mm.stopTimeout("stopping"); timeout != time.Second {
+		t.Errorf("Expected global stop timeout, got %v", timeout)
+	}
+	if timeout := mm.stopTimeout("stuck.instance"); timeout != 20*time.Millisecond {
+		t.Errorf("Expected module stop timeout for instance, got %v", timeout)
+	}
+}
+
+func TestCleanupStopsSendersBeforeClose(t *testing.T) {
+	mm := NewModuleManager(nil)
+
+	// Emit test metrics throughout the reloads, like the HTTP endpoint does
+	stop := make(chan struct{})
+	emitted := make(chan struct{})
+	go func() {
+		defer close(emitted)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				mm.emitTestMetric()
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		metricCh := metricchannel.New(1)
+		mm.metricMu.Lock()
+		mm.metricCh = metricCh
+		mm.metricMu.Unlock()
+
+		// The forwarder blocks on the full channel until the run is cancelled
+		in := mm.moduleChannel(ctx, "demo")
+		for j := 0; j < 3; j++ {
+			in <- metrics.Metric{Name: "demo", Fields: map[string]interface{}{"value": j}, Timestamp: time.Now()}
+		}
+		mm.cleanup(cancel)
+
+		if mm.channel() != nil {
+			t.Fatal("Expected no metric channel after cleanup")
+		}
+	}
+	close(stop)
+	<-emitted
+}
+
+func TestRunWithoutConfig(t *testing.T) {
+	var out bytes.Buffer
+	utils.Stdout().SetWriter(&out)
+	defer utils.Stdout().SetWriter(os.Stdout)
+
+	// A configuration file that fails to load leaves the agent without
+	// configuration; it starts with all modules disabled and stops again
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewModuleManager(nil).run()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the agent without configuration to stop")
+	}
+	if !strings.Contains(out.String(), `agent_status`) {
+		t.Errorf("Expected the agent status written, got %q", out.String())
+	}
+}
@@ -0,0 +1,385 @@
+// Package agent runs the metric collection modules of the metrics-agent.
+//
+// This file contains the commands read from stdin and the command-line
+// commands such as "config", "modules" and "selftest".
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/modules"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// selfTestTimeout limits how long the self-test checks of a module may take
+const selfTestTimeout = 30 * time.Second
+
+// defaultInitPath is the file written by "config init" if no path is given
+const defaultInitPath = "metrics-agent.json"
+
+// readStdinCommands reads commands line by line from the reader.
+// Supported commands are "collect", "reload", "status", "recent [module]",
+// "restarts [module]", "pause <module>" and "resume <module>". An empty line triggers
+// a collection, which is what telegraf's execd plugin sends when signal = "STDIN".
+func (mm *ModuleManager) readStdinCommands(reader io.Reader) {
+	utils.WithPanicRecoveryAndContinue("Stdin command reader", "main", func() {
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			mm.handleCommand(strings.TrimSpace(scanner.Text()))
+		}
+		if err := scanner.Err(); err != nil {
+			utils.Warnf("Failed to read commands from stdin: %v", err)
+			return
+		}
+		utils.Debugf("Stdin closed, no more commands")
+	})
+}
+
+// handleCommand executes a single command received on stdin.
+func (mm *ModuleManager) handleCommand(command string) {
+	command, arg, _ := strings.Cut(command, " ")
+	switch strings.ToLower(command) {
+	case "", "collect":
+		utils.Debugf("Received collect command, triggering collection")
+		if mm.triggerMode == triggerInterval && command != "" {
+			utils.Warnf("Collect command ignored, collection_trigger is '%s'", triggerInterval)
+			return
+		}
+		utils.TriggerCollection()
+	case "reload":
+		utils.Infof("Received reload command")
+		select {
+		case mm.signalCh <- syscall.SIGHUP:
+		default:
+			utils.Warnf("Reload already pending, ignoring reload command")
+		}
+	case "status":
+		mm.logStatus()
+	case "recent":
+		mm.logRecent(strings.TrimSpace(arg))
+	case "restarts":
+		mm.logRestarts(strings.TrimSpace(arg))
+	case "pause":
+		mm.pauseModule(strings.TrimSpace(arg))
+	case "resume":
+		mm.resumeModule(strings.TrimSpace(arg))
+	case "emit-test":
+		if metric, err := mm.emitTestMetric(); err != nil {
+			utils.Warnf("Failed to send test metric: %v", err)
+		} else {
+			utils.Infof("Sent test metric %s with test_id=%s through the pipeline and outputs", metric.Name, metric.Fields["test_id"])
+		}
+	default:
+		utils.Warnf("Unknown command on stdin: %q (supported: collect, reload, status, recent, restarts, pause, resume, emit-test)", command)
+	}
+}
+
+// pauseModule stops passing on the metrics of a module, or of all instances of
+// a module, without stopping it. The module keeps its connections and can be
+// resumed without a restart.
+func (mm *ModuleManager) pauseModule(moduleName string) {
+	if moduleName == "" {
+		utils.Warnf("Pause command requires a module name")
+		return
+	}
+	if _, err := modules.Global.Get(baseModuleName(moduleName)); err != nil {
+		utils.Warnf("Cannot pause %s: %v", moduleName, err)
+		return
+	}
+	mm.stateMu.Lock()
+	defer mm.stateMu.Unlock()
+	if _, ok := mm.paused[moduleName]; ok {
+		utils.Infof("[%s] module is already paused", moduleName)
+		return
+	}
+	mm.paused[moduleName] = 0
+	utils.Infof("[%s] module paused, metrics are dropped until it is resumed", moduleName)
+}
+
+// resumeModule passes on the metrics of a paused module again.
+func (mm *ModuleManager) resumeModule(moduleName string) {
+	if moduleName == "" {
+		utils.Warnf("Resume command requires a module name")
+		return
+	}
+	mm.stateMu.Lock()
+	defer mm.stateMu.Unlock()
+	dropped, ok := mm.paused[moduleName]
+	if !ok {
+		utils.Infof("[%s] module is not paused", moduleName)
+		return
+	}
+	delete(mm.paused, moduleName)
+	utils.Infof("[%s] module resumed, %d metrics were dropped while paused", moduleName, dropped)
+}
+
+// dropIfPaused reports whether the module (or its base module) is paused and
+// counts the dropped metric.
+func (mm *ModuleManager) dropIfPaused(moduleName string) bool {
+	mm.stateMu.Lock()
+	defer mm.stateMu.Unlock()
+	for _, name := range []string{moduleName, baseModuleName(moduleName)} {
+		if _, ok := mm.paused[name]; ok {
+			mm.paused[name]++
+			return true
+		}
+	}
+	return false
+}
+
+// isPaused reports whether the module (or its base module) is paused.
+// The caller must hold stateMu.
+func (mm *ModuleManager) isPaused(moduleName string) bool {
+	_, paused := mm.paused[moduleName]
+	_, basePaused := mm.paused[baseModuleName(moduleName)]
+	return paused || basePaused
+}
+
+// PrintSchema writes the JSON Schema of the configuration file, including the
+// custom settings of all compiled-in modules.
+func PrintSchema(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(config.Schema(modules.Global.Configs()))
+}
+
+// RunConfigCommand runs "config init [path]", which writes an example
+// configuration file to path, the -c path or defaultInitPath.
+func RunConfigCommand(args []string, configFlag string) error {
+	if len(args) == 0 || args[0] != "init" || len(args) > 2 {
+		return fmt.Errorf("usage: metrics-agent config init [path]")
+	}
+
+	path := defaultInitPath
+	if len(args) == 2 {
+		path = args[1]
+	} else if configFlag != "" {
+		path = configFlag
+	}
+	if err := initConfig(path); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote example configuration to %s with all modules disabled.\n", path)
+	fmt.Fprintf(os.Stderr, "Enable modules and fill in their custom settings, see 'metrics-agent modules describe <name>'.\n")
+	return nil
+}
+
+// initConfig writes an example configuration file listing all compiled-in
+// modules. It doesn't overwrite an existing file.
+func initConfig(path string) error {
+	configs := modules.Global.Configs()
+	moduleConfigs := make(map[string]interface{})
+	for _, name := range modules.Global.List() {
+		moduleConfigs[name] = configs[name]
+	}
+	data, err := config.Example(moduleConfigs)
+	if err != nil {
+		return fmt.Errorf("failed to generate example configuration: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("configuration file %s already exists", path)
+		}
+		return fmt.Errorf("failed to create configuration file: %w", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write configuration file: %w", err)
+	}
+	return file.Close()
+}
+
+// RunModulesCommand runs "modules list" or "modules describe <name>", which
+// print the compiled-in modules and their settings.
+func RunModulesCommand(w io.Writer, args []string, globalConfig *config.GlobalConfig) error {
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		return printModuleList(w, globalConfig)
+	case len(args) == 2 && args[0] == "describe":
+		return describeModule(w, args[1], globalConfig)
+	default:
+		return fmt.Errorf("usage: metrics-agent modules list | metrics-agent modules describe <name>")
+	}
+}
+
+// RunSelfTest runs "selftest", which checks all enabled modules and instances
+// concurrently and writes a table of the results with remediation hints for
+// failed checks. It returns an error if a check failed.
+func RunSelfTest(ctx context.Context, w io.Writer, globalConfig *config.GlobalConfig) error {
+	enabled, _ := filterEnabledModules(modules.Global.List(), globalConfig)
+	sort.Strings(enabled)
+	moduleNames := expandInstances(enabled, globalConfig)
+	if len(moduleNames) == 0 {
+		return fmt.Errorf("no modules enabled, nothing to test")
+	}
+
+	results := make([][]utils.Check, len(moduleNames))
+	var wg sync.WaitGroup
+	for i, moduleName := range moduleNames {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = selfTestModule(ctx, moduleName, globalConfig)
+		}()
+	}
+	wg.Wait()
+
+	total, failed := 0, 0
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODULE\tCHECK\tRESULT\tDETAILS")
+	for i, moduleName := range moduleNames {
+		for _, check := range results[i] {
+			total++
+			if check.Passed() {
+				fmt.Fprintf(tw, "%s\t%s\tpass\t%s\n", moduleName, check.Name, check.Detail)
+				continue
+			}
+			failed++
+			fmt.Fprintf(tw, "%s\t%s\tFAIL\t%v\n", moduleName, check.Name, check.Err)
+			if hint := check.Remediation(); hint != "" {
+				fmt.Fprintf(tw, "\t\t\t-> %s\n", hint)
+			}
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("selftest failed: %d of %d checks failed", failed, total)
+	}
+	return nil
+}
+
+// selfTestModule checks the configuration of a module or instance, runs its
+// probe and, if the probe passed, its self-test checks.
+func selfTestModule(ctx context.Context, moduleName string, globalConfig *config.GlobalConfig) []utils.Check {
+	name, instance := config.SplitInstanceName(moduleName)
+	configHint := fmt.Sprintf("Fix the module settings, see 'metrics-agent modules describe %s'", name)
+	if err := globalConfig.ModuleErrors[name]; err != nil {
+		return []utils.Check{{Name: "configuration", Err: err, Hint: configHint}}
+	}
+	ctx = config.WithInstance(ctx, instance)
+
+	var checks []utils.Check
+	if modules.Global.HasProbe(name) {
+		probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+		err := modules.Global.Probe(probeCtx, name)
+		cancel()
+		if config.IsModuleError(err) {
+			return []utils.Check{{Name: "configuration", Err: err, Hint: configHint}}
+		}
+		if err != nil {
+			return []utils.Check{{Name: "reachable", Err: err}}
+		}
+		checks = append(checks, utils.Check{Name: "reachable"})
+	}
+
+	testCtx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+	checks = append(checks, modules.Global.SelfTest(testCtx, name)...)
+	if len(checks) == 0 {
+		checks = append(checks, utils.Check{Name: "configuration", Detail: "module has no connectivity checks"})
+	}
+	return checks
+}
+
+// printModuleList writes a table of all compiled-in modules and whether they
+// are enabled in the configuration.
+func printModuleList(w io.Writer, globalConfig *config.GlobalConfig) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODULE\tENABLED\tINSTANCES\tDESCRIPTION")
+	for _, info := range modules.Global.DescribeAll() {
+		instances := strings.Join(configuredInstances(info.Name, globalConfig), ", ")
+		if instances == "" {
+			instances = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", info.Name, moduleEnabledState(info.Name, globalConfig), instances, info.Description)
+	}
+	return tw.Flush()
+}
+
+// describeModule writes whether a module is enabled and the settings of its
+// configuration section.
+func describeModule(w io.Writer, name string, globalConfig *config.GlobalConfig) error {
+	info, err := modules.Global.Describe(name)
+	if err != nil {
+		return fmt.Errorf("unknown module '%s', see 'metrics-agent modules list'", name)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Module:\t%s\n", name)
+	if info.Description != "" {
+		fmt.Fprintf(tw, "Description:\t%s\n", info.Description)
+	}
+	if len(info.Measurements) > 0 {
+		fmt.Fprintf(tw, "Measurements:\t%s\n", strings.Join(info.Measurements, ", "))
+	}
+	fmt.Fprintf(tw, "Enabled:\t%s\n", moduleEnabledState(name, globalConfig))
+	if instances := configuredInstances(name, globalConfig); len(instances) > 0 {
+		fmt.Fprintf(tw, "Instances:\t%s\n", strings.Join(instances, ", "))
+	}
+	fmt.Fprintf(tw, "Startup probe:\t%s\n", yesNo(info.Probe))
+	fmt.Fprintf(tw, "Self-test:\t%s\n", yesNo(info.SelfTest))
+
+	fmt.Fprintln(tw, "\nModule settings:")
+	for _, setting := range config.ModuleSettings() {
+		fmt.Fprintf(tw, "  %s\t%s\n", setting.Name, setting.Type)
+	}
+
+	fmt.Fprintln(tw, "\nCustom settings (in \"custom\"):")
+	cfg, ok := modules.Global.Configs()[name]
+	if !ok {
+		fmt.Fprintln(tw, "  unknown, the module does not register its settings")
+		return tw.Flush()
+	}
+	settings := config.CustomSettings(cfg)
+	if len(settings) == 0 {
+		fmt.Fprintln(tw, "  none")
+	}
+	for _, setting := range settings {
+		fmt.Fprintf(tw, "  %s\t%s\n", setting.Name, setting.Type)
+	}
+	return tw.Flush()
+}
+
+// moduleEnabledState returns "yes" or "no" depending on whether a module is
+// enabled in the configuration, or "config error" if its section is invalid.
+func moduleEnabledState(name string, globalConfig *config.GlobalConfig) string {
+	if globalConfig == nil {
+		return "no"
+	}
+	if globalConfig.ModuleErrors[name] != nil {
+		return "config error"
+	}
+	return yesNo(globalConfig.Modules[name].Enabled)
+}
+
+// configuredInstances returns the instance names configured for a module.
+func configuredInstances(name string, globalConfig *config.GlobalConfig) []string {
+	if globalConfig == nil {
+		return nil
+	}
+	return globalConfig.Modules[name].InstanceNames()
+}
+
+// yesNo formats a bool for the command output.
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,304 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/metricchannel"
+	"github.com/janhuddel/metrics-agent/internal/modules"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestReadStdinCommands(t *testing.T) {
+	utils.SetTriggeredCollection(true)
+	defer utils.SetTriggeredCollection(false)
+
+	ticker := utils.NewCollectionTicker(time.Hour)
+	defer ticker.Stop()
+
+	manager := NewModuleManager(&config.GlobalConfig{CollectionTrigger: triggerStdin})
+	manager.setModuleState("demo", "running (restarts: 0)")
+
+	// Empty lines (execd STDIN signal) and "collect" both trigger a collection
+	for _, input := range []string{"\n", "collect\n"} {
+		manager.readStdinCommands(strings.NewReader(input))
+		select {
+		case <-ticker.C:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %q on stdin to trigger a collection", input)
+		}
+	}
+
+	manager.readStdinCommands(strings.NewReader("status\nunknown\nRELOAD\n"))
+	select {
+	case sig := <-manager.signalCh:
+		if sig != syscall.SIGHUP {
+			t.Errorf("Expected reload to send SIGHUP, got %v", sig)
+		}
+	default:
+		t.Error("Expected reload command to request a restart")
+	}
+}
+
+func TestSelfTestModule(t *testing.T) {
+	modules.Global.RegisterProbe("selftest-ok", func(ctx context.Context) error { return nil })
+	modules.Global.RegisterSelfTest("selftest-ok", func(ctx context.Context) []utils.Check {
+		return []utils.Check{{Name: "API token", Detail: config.InstanceFromContext(ctx)}}
+	})
+	modules.Global.RegisterProbe("selftest-down", func(ctx context.Context) error {
+		return fmt.Errorf("connection refused")
+	})
+	modules.Global.RegisterSelfTest("selftest-down", func(ctx context.Context) []utils.Check {
+		t.Error("Expected no self-test checks after a failed probe")
+		return nil
+	})
+	globalConfig := &config.GlobalConfig{ModuleErrors: map[string]error{"selftest-invalid": fmt.Errorf("invalid json")}}
+
+	checks := selfTestModule(context.Background(), "selftest-ok.haus1", globalConfig)
+	if len(checks) != 2 || checks[0].Name != "reachable" || !checks[1].Passed() || checks[1].Detail != "haus1" {
+		t.Errorf("Expected probe and self-test check to pass, got %+v", checks)
+	}
+	checks = selfTestModule(context.Background(), "selftest-down", globalConfig)
+	if len(checks) != 1 || checks[0].Passed() {
+		t.Errorf("Expected failed probe check, got %+v", checks)
+	}
+	checks = selfTestModule(context.Background(), "selftest-invalid", globalConfig)
+	if len(checks) != 1 || checks[0].Name != "configuration" || checks[0].Remediation() == "" {
+		t.Errorf("Expected failed configuration check with hint, got %+v", checks)
+	}
+	checks = selfTestModule(context.Background(), "demo", globalConfig)
+	if len(checks) != 1 || !checks[0].Passed() {
+		t.Errorf("Expected passed check for module without checks, got %+v", checks)
+	}
+}
+
+func TestRunSelfTest(t *testing.T) {
+	var out strings.Builder
+	if err := RunSelfTest(context.Background(), &out, &config.GlobalConfig{}); err == nil {
+		t.Error("Expected error without enabled modules")
+	}
+
+	globalConfig := &config.GlobalConfig{Modules: map[string]config.ModuleConfig{"demo": {Enabled: true}}}
+	if err := RunSelfTest(context.Background(), &out, globalConfig); err != nil {
+		t.Fatalf("Expected selftest to pass, got %v", err)
+	}
+	if !strings.Contains(out.String(), "MODULE") || !strings.Contains(out.String(), "demo") {
+		t.Errorf("Expected result table, got %q", out.String())
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{})
+	mm.metricCh = metricchannel.New(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Pausing the module also pauses its instances
+	ch := mm.moduleChannel(ctx, "demo.haus1")
+	mm.handleCommand("pause demo")
+	mm.handleCommand("pause unknown-module")
+	if _, ok := mm.paused["unknown-module"]; ok {
+		t.Error("Expected unknown modules not to be paused")
+	}
+	if !mm.isPaused("demo.haus1") {
+		t.Fatal("Expected instance of paused module to be paused")
+	}
+
+	ch <- metrics.Metric{Name: "demo", Fields: map[string]interface{}{"value": 1}}
+	ch <- metrics.Metric{Name: "demo", Fields: map[string]interface{}{"value": 2}}
+	dropped := func() int {
+		mm.stateMu.Lock()
+		defer mm.stateMu.Unlock()
+		return mm.paused["demo"]
+	}
+	deadline := time.Now().Add(time.Second)
+	for dropped() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if dropped() != 2 {
+		t.Errorf("Expected 2 dropped metrics, got %d", dropped())
+	}
+	if len(mm.metricCh.Get()) != 0 {
+		t.Error("Expected no metrics to be forwarded while paused")
+	}
+
+	mm.handleCommand("resume demo")
+	ch <- metrics.Metric{Name: "demo", Fields: map[string]interface{}{"value": 3}}
+	select {
+	case m := <-mm.metricCh.Get():
+		if m.Fields["value"] != 3 {
+			t.Errorf("Expected value 3 after resume, got %v", m.Fields["value"])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected metrics to be forwarded after resume")
+	}
+	if recent := mm.recent.Get("demo.haus1"); len(recent) != 1 {
+		t.Errorf("Expected only the metric after resume to be recorded, got %d", len(recent))
+	}
+}
+
+func TestPrintSchemaValidatesExampleConfig(t *testing.T) {
+	var buf bytes.Buffer
+	if err := PrintSchema(&buf); err != nil {
+		t.Fatalf("Failed to print schema: %v", err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &schema); err != nil {
+		t.Fatalf("Schema is not valid JSON: %v", err)
+	}
+
+	data, err := os.ReadFile("../../metrics-agent.example.json")
+	if err != nil {
+		t.Fatalf("Failed to read example config: %v", err)
+	}
+	var example interface{}
+	if err := json.Unmarshal(data, &example); err != nil {
+		t.Fatalf("Failed to parse example config: %v", err)
+	}
+
+	checkSchema(t, "", example, schema)
+}
+
+// checkSchema checks value against the subset of JSON Schema generated by config.Schema
+func checkSchema(t *testing.T, path string, value interface{}, schema map[string]interface{}) {
+	t.Helper()
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if typ, ok := schema["type"]; ok && typ != "object" {
+			t.Errorf("%s: expected %v, got object", path, typ)
+			return
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for key, item := range v {
+			if property, ok := properties[key].(map[string]interface{}); ok {
+				checkSchema(t, path+"/"+key, item, property)
+			} else if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				checkSchema(t, path+"/"+key, item, additional)
+			} else if schema["additionalProperties"] == false {
+				t.Errorf("%s: unknown setting %s", path, key)
+			}
+		}
+	case []interface{}:
+		if schema["type"] != "array" {
+			t.Errorf("%s: expected %v, got array", path, schema["type"])
+			return
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range v {
+			checkSchema(t, fmt.Sprintf("%s/%d", path, i), item, items)
+		}
+	case string:
+		if typ, ok := schema["type"]; ok && typ != "string" {
+			t.Errorf("%s: expected %v, got string", path, typ)
+		}
+	case bool:
+		if typ, ok := schema["type"]; ok && typ != "boolean" {
+			t.Errorf("%s: expected %v, got boolean", path, typ)
+		}
+	case float64:
+		if typ, ok := schema["type"]; ok && typ != "number" && (typ != "integer" || v != float64(int64(v))) {
+			t.Errorf("%s: expected %v, got %v", path, typ, v)
+		}
+	}
+}
+
+func TestModulesCommand(t *testing.T) {
+	globalConfig := &config.GlobalConfig{
+		Modules: map[string]config.ModuleConfig{
+			"dwd": {Enabled: true},
+			"tasmota": {Enabled: true, Instances: map[string]config.InstanceConfig{
+				"haus1": {}, "haus2": {},
+			}},
+		},
+		ModuleErrors: map[string]error{"tibber": fmt.Errorf("invalid")},
+	}
+
+	var buf bytes.Buffer
+	if err := RunModulesCommand(&buf, []string{"list"}, globalConfig); err != nil {
+		t.Fatalf("Failed to list modules: %v", err)
+	}
+	lines := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n")[1:] {
+		name, rest, _ := strings.Cut(line, " ")
+		lines[name] = strings.Join(strings.Fields(rest), " ")
+	}
+	for name, expected := range map[string]string{
+		"dwd":     "yes -",
+		"tasmota": "yes haus1, haus2",
+		"tibber":  "config error -",
+		"netatmo": "no -",
+	} {
+		if !strings.HasPrefix(lines[name], expected+" ") {
+			t.Errorf("Expected %s to be listed as %q with description, got %q", name, expected, lines[name])
+		}
+	}
+
+	buf.Reset()
+	if err := RunModulesCommand(&buf, []string{"describe", "tasmota"}, globalConfig); err != nil {
+		t.Fatalf("Failed to describe module: %v", err)
+	}
+	for _, expected := range []string{"Instances:", "haus1, haus2", "Measurements:", "electricity", "Self-test:", "enabled", "broker"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected description to contain %q, got:\n%s", expected, buf.String())
+		}
+	}
+
+	if err := RunModulesCommand(&buf, []string{"describe", "unknown"}, globalConfig); err == nil {
+		t.Error("Expected an error for an unknown module")
+	}
+	if err := RunModulesCommand(&buf, []string{"remove"}, globalConfig); err == nil {
+		t.Error("Expected an error for an unknown subcommand")
+	}
+}
+
+func TestInitConfig(t *testing.T) {
+	path := t.TempDir() + "/metrics-agent.json"
+	if err := initConfig(path); err != nil {
+		t.Fatalf("Failed to write example configuration: %v", err)
+	}
+
+	globalConfig, err := config.LoadGlobalConfigFromPath(path)
+	if err != nil {
+		t.Fatalf("Example configuration is invalid: %v", err)
+	}
+	if len(globalConfig.ModuleErrors) > 0 {
+		t.Errorf("Expected no module errors, got %v", globalConfig.ModuleErrors)
+	}
+	for _, name := range modules.Global.List() {
+		moduleConfig, exists := globalConfig.Modules[name]
+		if !exists {
+			t.Errorf("Expected module %s in example configuration", name)
+		} else if moduleConfig.Enabled {
+			t.Errorf("Expected module %s to be disabled", name)
+		}
+	}
+
+	// The example passes the generated schema
+	var buf bytes.Buffer
+	if err := PrintSchema(&buf); err != nil {
+		t.Fatalf("Failed to print schema: %v", err)
+	}
+	var schema, example map[string]interface{}
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(buf.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &example); err != nil {
+		t.Fatal(err)
+	}
+	checkSchema(t, "", example, schema)
+
+	if err := initConfig(path); err == nil {
+		t.Error("Expected an existing configuration file not to be overwritten")
+	}
+}
@@ -0,0 +1,374 @@
+// Package agent runs the metric collection modules of the metrics-agent.
+//
+// This file contains reloading the configuration when the file changes or
+// the remote configuration is fetched, and the summary of the configuration.
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/modules"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// remoteConfigTimeout limits fetching the remote configuration
+const remoteConfigTimeout = time.Minute
+
+// defaultWatchDebounce is how long a changed configuration file must stay unchanged before it is applied
+const defaultWatchDebounce = 2 * time.Second
+
+// agentConfigMetricName is the name of the metric summarizing the running configuration
+const agentConfigMetricName = "agent_config"
+
+// watchConfig requests a reload of the modules whenever the configuration file
+// changes, until ctx is cancelled. A changed file that fails to load is not
+// applied, so a typo doesn't stop the running modules.
+func (mm *ModuleManager) watchConfig(ctx context.Context) {
+	globalConfig := mm.currentConfig()
+	if mm.configPath == "" {
+		utils.Warnf("watch_config is set, but there is no configuration file to watch")
+		return
+	}
+	debounce := defaultWatchDebounce
+	if globalConfig.WatchConfigDebounce > 0 {
+		debounce = globalConfig.WatchConfigDebounce.Duration()
+	}
+
+	utils.Infof("Watching configuration file %s for changes", mm.configPath)
+	utils.WithPanicRecoveryAndContinue("Config watcher", "main", func() {
+		utils.WatchFile(ctx, mm.configPath, debounce, mm.handleConfigChange)
+	})
+}
+
+// FetchRemoteConfig fetches the remote configuration file into its cache. If
+// the URL can't be reached, the agent starts with the file cached by an
+// earlier fetch.
+func FetchRemoteConfig(remote *config.RemoteSource) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteConfigTimeout)
+	defer cancel()
+
+	changed, err := remote.Fetch(ctx)
+	if err != nil {
+		if _, statErr := os.Stat(remote.CachePath()); statErr != nil {
+			return err
+		}
+		utils.Warnf("Using cached configuration %s: %v", remote.CachePath(), err)
+		return nil
+	}
+	if changed {
+		utils.Infof("Fetched remote configuration into %s", remote.CachePath())
+	}
+	return nil
+}
+
+// refreshRemoteConfig fetches the remote configuration file every interval
+// until ctx is cancelled, and requests a reload of the modules when it
+// changed. With watch_config the reload is left to the file watcher.
+func (mm *ModuleManager) refreshRemoteConfig(ctx context.Context, interval time.Duration) {
+	globalConfig := mm.currentConfig()
+	utils.WithPanicRecoveryAndContinue("Remote config refresh", "main", func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			fetchCtx, cancel := context.WithTimeout(ctx, remoteConfigTimeout)
+			changed, err := mm.remote.Fetch(fetchCtx)
+			cancel()
+			if err != nil {
+				utils.Warnf("Failed to refresh remote configuration, keeping the cached one: %v", err)
+				continue
+			}
+			if changed && (globalConfig == nil || !globalConfig.WatchConfig) {
+				mm.handleConfigChange()
+			}
+		}
+	})
+}
+
+// handleConfigChange loads a changed configuration file and requests a
+// reload of the modules, reporting it as an agent_status metric. If only the
+// settings of the modules changed and the running modules apply them
+// themselves, the configuration is replaced without a reload.
+func (mm *ModuleManager) handleConfigChange() {
+	globalConfig, err := mm.loadConfig()
+	if err != nil {
+		utils.Errorf("Configuration file %s changed but can't be loaded, keeping the running configuration: %v", mm.configPath, err)
+		return
+	}
+
+	// Modules applying the change themselves don't need a restart
+	mm.writeAgentStatus(true, "config_changed")
+	if running := mm.currentConfig(); running != nil && reflect.DeepEqual(agentSettings(running), agentSettings(globalConfig)) &&
+		modules.Global.ConfigChanged() {
+		mm.configMu.Lock()
+		mm.globalConfig = globalConfig
+		mm.configMu.Unlock()
+		utils.Infof("Configuration file %s changed, applied by all running modules", mm.configPath)
+		return
+	}
+
+	mm.configMu.Lock()
+	mm.pendingConfig = globalConfig
+	mm.configMu.Unlock()
+
+	utils.Infof("Configuration file %s changed, reloading modules", mm.configPath)
+	select {
+	case mm.signalCh <- syscall.SIGHUP:
+	default:
+		utils.Warnf("Reload already pending, ignoring configuration change")
+	}
+}
+
+// loadConfig loads and validates the configuration file.
+func (mm *ModuleManager) loadConfig() (*config.GlobalConfig, error) {
+	globalConfig, err := config.LoadGlobalConfigFromPath(mm.configPath)
+	if err != nil {
+		return nil, err
+	}
+	if globalConfig.Pipeline.Anonymize != nil {
+		if err := globalConfig.Pipeline.Anonymize.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid pipeline anonymize configuration: %w", err)
+		}
+	}
+	return globalConfig, nil
+}
+
+// reloadConfig replaces the running configuration while the modules are
+// stopped for a reload: by the changed configuration handleConfigChange
+// loaded or, for a SIGHUP, by the configuration file loaded again. A file that
+// can't be loaded keeps the running configuration.
+func (mm *ModuleManager) reloadConfig() {
+	mm.configMu.Lock()
+	globalConfig := mm.pendingConfig
+	mm.pendingConfig = nil
+	mm.configMu.Unlock()
+
+	if globalConfig == nil {
+		if mm.configPath == "" {
+			return
+		}
+		var err error
+		if globalConfig, err = mm.loadConfig(); err != nil {
+			utils.Errorf("Failed to reload configuration file %s, keeping the running configuration: %v", mm.configPath, err)
+			return
+		}
+	}
+	mm.applyConfig(globalConfig)
+}
+
+// applyConfig replaces the running configuration. The pipeline, the notifier
+// and the absence tracker are rebuilt if their settings changed, so unchanged
+// processors keep their state. Outputs and endpoints started with the agent
+// keep their settings until it is restarted.
+func (mm *ModuleManager) applyConfig(globalConfig *config.GlobalConfig) {
+	running := mm.currentConfig()
+	if running == nil {
+		running = &config.GlobalConfig{}
+	}
+
+	level := globalConfig.LogLevel
+	if level == "" {
+		level = "info"
+	}
+	config.SetLogLevel(level)
+
+	var restart []string
+	for name, changed := range map[string]bool{
+		"otlp":               !reflect.DeepEqual(running.OTLP, globalConfig.OTLP),
+		"prometheus":         !reflect.DeepEqual(running.Prometheus, globalConfig.Prometheus),
+		"http":               !reflect.DeepEqual(running.HTTP, globalConfig.HTTP),
+		"collection_trigger": running.CollectionTrigger != globalConfig.CollectionTrigger,
+	} {
+		if changed {
+			restart = append(restart, name)
+		}
+	}
+	if len(restart) > 0 {
+		sort.Strings(restart)
+		utils.Warnf("Changed settings %s take effect when the agent is restarted", strings.Join(restart, ", "))
+	}
+
+	mm.configMu.Lock()
+	defer mm.configMu.Unlock()
+	if !reflect.DeepEqual(running.Pipeline, globalConfig.Pipeline) || !reflect.DeepEqual(running.Identity, globalConfig.Identity) {
+		mm.pipeline = newPipeline(globalConfig)
+		utils.Infof("Pipeline changed, rebuilt %d processors", mm.pipeline.Len())
+	}
+	if !reflect.DeepEqual(running.Pipeline.Absence, globalConfig.Pipeline.Absence) {
+		mm.absence = newAbsenceTracker(globalConfig)
+	}
+	if !reflect.DeepEqual(running.Notify, globalConfig.Notify) {
+		mm.notifier = newNotifier(globalConfig)
+	}
+	mm.globalConfig = globalConfig
+}
+
+// agentSettings returns a copy of the configuration without the friendly
+// names and custom settings of the modules and instances, which only the
+// modules themselves use.
+func agentSettings(globalConfig *config.GlobalConfig) config.GlobalConfig {
+	settings := *globalConfig
+	settings.Migrations = nil
+	settings.Modules = make(map[string]config.ModuleConfig, len(globalConfig.Modules))
+	for name, moduleConfig := range globalConfig.Modules {
+		moduleConfig.BaseConfig = config.BaseConfig{}
+		instances := make(map[string]config.InstanceConfig, len(moduleConfig.Instances))
+		for instance, instanceConfig := range moduleConfig.Instances {
+			instanceConfig.BaseConfig = config.BaseConfig{}
+			instances[instance] = instanceConfig
+		}
+		moduleConfig.Instances = instances
+		settings.Modules[name] = moduleConfig
+	}
+	return settings
+}
+
+// endpointKeys are the custom settings holding the address of the device or
+// service a module connects to, in the order they are looked up.
+var endpointKeys = []string{"url", "broker", "address", "host", "endpoint", "ip"}
+
+// configSummary describes what the agent runs after a (re)start.
+type configSummary struct {
+	Modules  []moduleSummary // running modules and instances in start order
+	Outputs  []string        // e.g. "stdout", "otlp http://collector:4318/v1/metrics (shadow)"
+	Pipeline []string        // processor names in the order they are applied
+}
+
+// moduleSummary describes a running module or instance.
+type moduleSummary struct {
+	Name     string
+	Endpoint string // address the module connects to, empty if not configured
+}
+
+// configSummary summarizes the running modules, the outputs and the pipeline.
+// Secrets in endpoints (e.g. passwords in broker URLs) are redacted.
+func (mm *ModuleManager) configSummary(moduleNames []string) configSummary {
+	globalConfig := mm.currentConfig()
+	var summary configSummary
+	for _, name := range moduleNames {
+		summary.Modules = append(summary.Modules, moduleSummary{
+			Name:     name,
+			Endpoint: utils.RedactSecrets(moduleEndpoint(name, globalConfig)),
+		})
+	}
+
+	summary.Outputs = append(summary.Outputs, "stdout")
+	if mm.exporter != nil {
+		otlp := "otlp " + utils.RedactSecrets(globalConfig.OTLP.Endpoint)
+		if globalConfig.OTLP.Shadow {
+			otlp += " (shadow)"
+		}
+		summary.Outputs = append(summary.Outputs, otlp)
+	}
+	if mm.scrape != nil {
+		summary.Outputs = append(summary.Outputs, "prometheus "+globalConfig.Prometheus.Listen)
+	}
+
+	summary.Pipeline = mm.pipeline.Names()
+	return summary
+}
+
+// moduleEndpoint returns the endpoint of a module or instance from its custom
+// settings. Settings of an instance take precedence over those of its module.
+func moduleEndpoint(name string, globalConfig *config.GlobalConfig) string {
+	if globalConfig == nil {
+		return ""
+	}
+	moduleName, instance := config.SplitInstanceName(name)
+	moduleConfig := globalConfig.Modules[moduleName]
+	settings := []map[string]interface{}{moduleConfig.Custom}
+	if instance != "" {
+		settings = append([]map[string]interface{}{moduleConfig.Instances[instance].Custom}, settings...)
+	}
+	for _, custom := range settings {
+		for _, key := range endpointKeys {
+			if value, ok := custom[key].(string); ok && value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// log logs the summary, one line per section.
+func (s configSummary) log() {
+	utils.Infof("Config summary: modules: %s", s.modulesString())
+	utils.Infof("Config summary: outputs: %s", strings.Join(s.Outputs, ", "))
+	pipeline := "none"
+	if len(s.Pipeline) > 0 {
+		pipeline = strings.Join(s.Pipeline, " -> ")
+	}
+	utils.Infof("Config summary: pipeline: %s", pipeline)
+}
+
+// modulesString lists the modules with their endpoints, e.g.
+// "tasmota.haus1 (tcp://broker:1883), demo".
+func (s configSummary) modulesString() string {
+	modules := make([]string, 0, len(s.Modules))
+	for _, module := range s.Modules {
+		if module.Endpoint == "" {
+			modules = append(modules, module.Name)
+			continue
+		}
+		modules = append(modules, module.Name+" ("+module.Endpoint+")")
+	}
+	return strings.Join(modules, ", ")
+}
+
+// metric builds the agent_config metric from the summary. The checksum
+// changes with any part of the summary, so hosts running a different
+// configuration stand out in a table of the latest values.
+func (s configSummary) metric() metrics.Metric {
+	modules := s.modulesString()
+	outputs := strings.Join(s.Outputs, ", ")
+	pipeline := strings.Join(s.Pipeline, ",")
+	checksum := sha256.Sum256([]byte(modules + "\n" + outputs + "\n" + pipeline))
+	return metrics.Metric{
+		Name: agentConfigMetricName,
+		Fields: map[string]interface{}{
+			"modules":      modules,
+			"module_count": len(s.Modules),
+			"outputs":      outputs,
+			"pipeline":     optionalString(pipeline),
+			"checksum":     hex.EncodeToString(checksum[:8]),
+			"run_id":       utils.RunID(),
+		},
+		Timestamp: time.Now(),
+	}
+}
+
+// identityTags returns the identity tags added to every metric, or nil if
+// none are configured. Unknown tags are logged and ignored.
+func identityTags(globalConfig *config.GlobalConfig) map[string]string {
+	if globalConfig == nil || len(globalConfig.Identity.Tags) == 0 {
+		return nil
+	}
+	tags := make(map[string]string)
+	for _, tag := range globalConfig.Identity.Tags {
+		switch tag {
+		case config.IdentityAgentID:
+			tags[tag] = utils.AgentID()
+		case config.IdentityRunID:
+			tags[tag] = utils.RunID()
+		default:
+			utils.Warnf("Ignoring unknown identity tag %q (supported: %s, %s)", tag, config.IdentityAgentID, config.IdentityRunID)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,745 @@
+// Package agent runs the metric collection modules of the metrics-agent.
+//
+// This file contains running the modules: restarting them with backoff,
+// passing their metrics through the per-module processors and the startup
+// probes.
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	runtimepprof "runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/modules"
+	"github.com/janhuddel/metrics-agent/internal/notify"
+	"github.com/janhuddel/metrics-agent/internal/processors"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// stateConfigError is the status of a module skipped due to invalid configuration
+const stateConfigError = "config_error"
+
+// stateStopTimeout is the status of a module that did not stop within its stop timeout
+const stateStopTimeout = "stop_timeout"
+
+// stateProbeFailed is the status of a module skipped because its startup probe failed
+const stateProbeFailed = "probe_failed"
+
+// probeTimeout limits how long all startup probes may take together
+const probeTimeout = 10 * time.Second
+
+// moduleLabel is the pprof label identifying the goroutines of a module
+const moduleLabel = "module"
+
+// Reasons a module returned from Run
+const (
+	// exitNormal is a module that finished its work and returned nil
+	exitNormal = "normal"
+
+	// exitCanceled is a module stopped by the agent on shutdown or reload
+	exitCanceled = "canceled"
+
+	// exitError is a module that failed and is restarted
+	exitError = "error"
+)
+
+// setModuleState records the current state of a module for status reporting.
+func (mm *ModuleManager) setModuleState(moduleName, state string) {
+	mm.stateMu.Lock()
+	defer mm.stateMu.Unlock()
+	mm.moduleStates[moduleName] = state
+}
+
+// setLastMetric records when a module last passed on a metric for status reporting.
+func (mm *ModuleManager) setLastMetric(moduleName string, at time.Time) {
+	mm.stateMu.Lock()
+	defer mm.stateMu.Unlock()
+	mm.lastMetric[moduleName] = at
+}
+
+// baseModuleName returns the module name of an instance ("netatmo" for "netatmo.haus1").
+func baseModuleName(moduleName string) string {
+	name, _ := config.SplitInstanceName(moduleName)
+	return name
+}
+
+// filterEnabledModules returns lists of enabled and disabled modules based on configuration.
+func (mm *ModuleManager) filterEnabledModules() (enabled, disabled []string) {
+	globalConfig := mm.currentConfig()
+	allModuleNames := modules.Global.List()
+	utils.Debugf("Found %d registered modules: %v", len(allModuleNames), allModuleNames)
+
+	if len(allModuleNames) == 0 {
+		utils.Infof("No modules registered, exiting")
+		return nil, nil
+	}
+
+	return filterEnabledModules(allModuleNames, globalConfig)
+}
+
+// logModuleStatus logs the status of enabled and disabled modules.
+func (mm *ModuleManager) logModuleStatus(enabled, disabled []string) {
+	if len(disabled) > 0 {
+		utils.Infof("Disabled modules: %v", disabled)
+	}
+	utils.Infof("Starting %d enabled modules: %v", len(enabled), enabled)
+}
+
+// getRestartLimit returns the configured restart limit with appropriate logging.
+func (mm *ModuleManager) getRestartLimit() int {
+	globalConfig := mm.currentConfig()
+	maxRestarts := 3 // Default value
+	if globalConfig != nil {
+		if globalConfig.ModuleRestartLimit == 0 {
+			maxRestarts = 0 // 0 means unlimited restarts
+		} else if globalConfig.ModuleRestartLimit > 0 {
+			maxRestarts = globalConfig.ModuleRestartLimit
+		}
+		// If ModuleRestartLimit < 0, use default (3)
+	}
+
+	if maxRestarts == 0 {
+		utils.Infof("Module restart limit: unlimited")
+		utils.Warnf("Unlimited restarts (limit=0) is NOT recommended for telegraf/systemd deployments!")
+	} else {
+		utils.Infof("Module restart limit: %d", maxRestarts)
+	}
+
+	return maxRestarts
+}
+
+// runModules starts all enabled modules concurrently with restart capability.
+// The channel of a module in stopped is closed once the module has returned.
+func (mm *ModuleManager) runModules(ctx context.Context, moduleNames []string, maxRestarts int, stopped map[string]chan struct{}) {
+	var wg sync.WaitGroup
+	for _, moduleName := range moduleNames {
+		wg.Add(1)
+		go func() {
+			defer close(stopped[moduleName])
+			mm.runModule(ctx, &wg, moduleName, maxRestarts)
+		}()
+	}
+
+	// Wait for all modules to complete
+	wg.Wait()
+}
+
+// runModule runs a single module with restart capability.
+func (mm *ModuleManager) runModule(ctx context.Context, wg *sync.WaitGroup, moduleName string, maxRestarts int) {
+	defer wg.Done()
+	defer func() {
+		if ctx.Err() != nil {
+			mm.setModuleState(moduleName, "stopped")
+		}
+	}()
+
+	restartCount := 0
+	crashLoop := crashLoopDetector{after: mm.notifier.CrashLoopAfter()}
+	metricCh := mm.moduleChannel(ctx, moduleName)
+
+	// Spread the start of modules, so they don't all poll and connect at once
+	if delay := mm.startupDelay(moduleName); delay > 0 {
+		utils.Infof("[%s] delaying start by %v", moduleName, delay.Round(time.Millisecond))
+		mm.setModuleState(moduleName, "waiting (startup jitter)")
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+
+	for {
+		// Check for context cancellation before each iteration
+		select {
+		case <-ctx.Done():
+			utils.Infof("[%s] module stopped due to context cancellation", moduleName)
+			return
+		default:
+		}
+
+		// Execute the module
+		mm.setModuleState(moduleName, fmt.Sprintf("running (restarts: %d)", restartCount))
+		started := time.Now()
+		err := mm.executeModule(ctx, moduleName, metricCh, restartCount, maxRestarts)
+
+		// A module with invalid configuration would fail the same way again
+		if config.IsModuleError(err) {
+			utils.Errorf("[%s] skipping module due to configuration error", moduleName)
+			mm.setModuleState(moduleName, stateConfigError)
+			return
+		}
+
+		// Modules stopped by the agent and modules that are done aren't restarted
+		switch exitReason(ctx, err) {
+		case exitCanceled:
+			utils.Infof("[%s] module stopped due to context cancellation", moduleName)
+			return
+		case exitNormal:
+			utils.Infof("[%s] module finished, not restarting it", moduleName)
+			mm.setModuleState(moduleName, "finished")
+			return
+		}
+
+		// Keep the restart for investigating intermittent failures later
+		mm.restarts.Record(moduleName, utils.Restart{
+			Time:   time.Now(),
+			Reason: restartReason(err),
+			Uptime: time.Since(started),
+			RunID:  utils.RunID(),
+		})
+
+		// Increment restart count and check limits
+		restartCount++
+		if maxRestarts > 0 && restartCount >= maxRestarts {
+			utils.Errorf("[%s] module failed %d times, exiting program", moduleName, restartCount)
+			mm.setModuleState(moduleName, "failed")
+			mm.sendNotification(notify.Event{
+				Module:   moduleName,
+				Reason:   notify.ReasonRestartLimit,
+				Restarts: restartCount,
+				Error:    errorString(err),
+				Since:    crashLoop.failure(started),
+			})
+			return
+		}
+
+		// Notify once per crash loop, without delaying the restart
+		crashLoop.failure(started)
+		if crashLoop.due() {
+			go mm.sendNotification(notify.Event{
+				Module:   moduleName,
+				Reason:   notify.ReasonCrashLoop,
+				Restarts: restartCount,
+				Error:    errorString(err),
+				Since:    crashLoop.since,
+			})
+		}
+
+		// Log restart and wait with context cancellation support
+		mm.logRestart(moduleName, restartCount, maxRestarts)
+		mm.setModuleState(moduleName, fmt.Sprintf("restarting (restarts: %d)", restartCount))
+
+		// Use context-aware sleep instead of time.Sleep
+		select {
+		case <-ctx.Done():
+			utils.Infof("[%s] module stopped due to context cancellation during restart delay", moduleName)
+			return
+		case <-time.After(1 * time.Second):
+			// Continue to next iteration
+		}
+	}
+}
+
+// crashLoopDetector detects modules that have been failing for longer than after.
+// Failures belong to the same crash loop as long as the module does not run for
+// longer than after between them.
+type crashLoopDetector struct {
+	after    time.Duration
+	since    time.Time
+	notified bool
+}
+
+// failure records that a module run started at started has failed and returns
+// the time the current crash loop started.
+func (d *crashLoopDetector) failure(started time.Time) time.Time {
+	if d.since.IsZero() || (d.after > 0 && time.Since(started) >= d.after) {
+		d.since = time.Now()
+		d.notified = false
+	}
+	return d.since
+}
+
+// due reports whether the current crash loop has to be notified. It returns
+// true only once per crash loop.
+func (d *crashLoopDetector) due() bool {
+	if d.after <= 0 || d.notified || time.Since(d.since) < d.after {
+		return false
+	}
+	d.notified = true
+	return true
+}
+
+// sendNotification notifies about a failing module and logs delivery errors.
+func (mm *ModuleManager) sendNotification(event notify.Event) {
+	mm.configMu.RLock()
+	notifier := mm.notifier
+	mm.configMu.RUnlock()
+	if notifier == nil {
+		return
+	}
+	if err := notifier.Notify(event); err != nil {
+		utils.Errorf("[%s] failed to send %s notification: %v", event.Module, event.Reason, err)
+		return
+	}
+	utils.Infof("[%s] sent %s notification", event.Module, event.Reason)
+}
+
+// errorString returns the message of err, or an empty string if err is nil.
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// restartReason returns the reason recorded for a module restart.
+func restartReason(err error) string {
+	if err == nil {
+		return "stopped without error"
+	}
+	return err.Error()
+}
+
+// exitReason classifies why a module returned. Modules should return nil when
+// their context is cancelled; ctx.Err() and errors wrapping it are accepted as
+// cancellation as well. A cancellation error while ctx is still active comes
+// from the module itself and is a failure.
+func exitReason(ctx context.Context, err error) string {
+	switch {
+	case ctx.Err() != nil && (err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)):
+		return exitCanceled
+	case err == nil:
+		return exitNormal
+	default:
+		return exitError
+	}
+}
+
+// moduleChannel returns the channel a module sends its metrics to. Missing
+// field values are resolved, NaN and infinite values are dropped and the
+// fields of the metrics are renamed as configured for the module. Then the
+// metrics are recorded as recent metrics of the module and passed on to the
+// metric channel until ctx is cancelled; while the module is paused they are
+// dropped.
+func (mm *ModuleManager) moduleChannel(ctx context.Context, moduleName string) chan<- metrics.Metric {
+	in := make(chan metrics.Metric, mm.moduleBuffer(moduleName))
+	out := mm.channel().Get()
+	devices := mm.getDeviceFilter(moduleName)
+	renamer := mm.getFieldRenamer(moduleName)
+	attributes := mm.getAttributeMapper(moduleName)
+	states := mm.getStateMapper(moduleName)
+	aligner := mm.getTimestampAligner(moduleName)
+	missing := mm.missingMode(moduleName)
+	nonFinite := mm.nonFiniteMode(moduleName)
+	mm.goSender(func() {
+		utils.WithPanicRecoveryAndContinue("Metric forwarder", moduleName, func() {
+			for {
+				select {
+				case m := <-in:
+					if mm.dropIfPaused(moduleName) {
+						continue
+					}
+					if m.Fields = metrics.ResolveMissing(m.Fields, missing); len(m.Fields) == 0 {
+						continue
+					}
+					var dropped int
+					if m.Fields, dropped = metrics.DropNonFinite(m.Fields, nonFinite); dropped > 0 {
+						utils.Debugf("[%s] dropped %d NaN or infinite values of %s (device %q)", moduleName, dropped, m.Name, m.Tags["device"])
+					}
+					if len(m.Fields) == 0 {
+						continue
+					}
+					m, keep := devices.Process(m)
+					if !keep {
+						continue
+					}
+					m, _ = renamer.Process(m)
+					if m, keep = attributes.Process(m); !keep {
+						continue
+					}
+					if m, keep = states.Process(m); !keep {
+						continue
+					}
+					m, _ = aligner.Process(m)
+					mm.recent.Record(moduleName, m)
+					now := time.Now()
+					mm.inventory.Seen(moduleName, m.Tags["device"], now)
+					m = mm.absence.Seen(moduleName, m, now)
+					mm.setLastMetric(moduleName, now)
+					select {
+					case out <- m:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	})
+	return in
+}
+
+// executeModule runs a single module execution with panic recovery.
+// It returns the error the module stopped with.
+func (mm *ModuleManager) executeModule(ctx context.Context, moduleName string, metricCh chan<- metrics.Metric, restartCount, maxRestarts int) (err error) {
+	// Kept if the supervision code panics, so the module is restarted
+	err = errors.New("module execution panicked")
+	utils.WithPanicRecoveryAndContinue("Module execution", moduleName, func() {
+		if maxRestarts == 0 {
+			utils.Infof("[%s] starting module (attempt %d/unlimited)", moduleName, restartCount+1)
+		} else {
+			utils.Infof("[%s] starting module (attempt %d/%d)", moduleName, restartCount+1, maxRestarts+1)
+		}
+		// Interval-based modules only collect within their schedule
+		ctx = utils.WithSchedule(ctx, mm.getSchedule(moduleName))
+		ctx = utils.WithSkipInitialCollection(ctx, mm.skipInitialCollection(moduleName))
+		ctx = utils.WithRetry(ctx, mm.retryPolicy(moduleName))
+		ctx = utils.WithConcurrencyLimit(ctx, utils.NewSemaphore(mm.concurrencyLimit(moduleName)))
+		ctx = utils.WithDeviceInventory(ctx, mm.inventory)
+
+		// Label the module's goroutines so they can be counted per module
+		runtimepprof.Do(ctx, runtimepprof.Labels(moduleLabel, moduleName), func(ctx context.Context) {
+			if name, instance := config.SplitInstanceName(moduleName); instance != "" {
+				tags := mm.getInstances(name)[instance].Tags
+				err = modules.Global.RunInstance(ctx, name, instance, tags, metricCh)
+			} else {
+				err = modules.Global.Run(ctx, moduleName, metricCh)
+			}
+		})
+		switch exitReason(ctx, err) {
+		case exitError:
+			utils.Errorf("[%s] module error: %v", moduleName, err)
+		case exitCanceled:
+			if err != nil {
+				utils.Debugf("[%s] module returned %q when stopped, modules should return nil on cancellation", moduleName, err)
+			}
+		}
+		utils.Infof("[%s] module stopped", moduleName)
+	})
+	return err
+}
+
+// skipConfigErrors reports enabled modules whose configuration section could not
+// be parsed, marks them in the status and returns the remaining modules.
+func (mm *ModuleManager) skipConfigErrors(moduleNames []string) []string {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil || len(globalConfig.ModuleErrors) == 0 {
+		return moduleNames
+	}
+
+	valid := make([]string, 0, len(moduleNames))
+	for _, moduleName := range moduleNames {
+		name, _ := config.SplitInstanceName(moduleName)
+		if err := globalConfig.ModuleErrors[name]; err != nil {
+			utils.Errorf("[%s] skipping module: %v", moduleName, err)
+			mm.setModuleState(moduleName, stateConfigError)
+			continue
+		}
+		valid = append(valid, moduleName)
+	}
+	return valid
+}
+
+// probeModules runs the startup probes of all modules concurrently, records the
+// results for the status output and returns the modules whose probe passed.
+// Modules without a probe always pass.
+func (mm *ModuleManager) probeModules(ctx context.Context, moduleNames []string) []string {
+	probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	errs := make([]error, len(moduleNames))
+	var wg sync.WaitGroup
+	for i, moduleName := range moduleNames {
+		name, instance := config.SplitInstanceName(moduleName)
+		if !modules.Global.HasProbe(name) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = modules.Global.Probe(config.WithInstance(probeCtx, instance), name)
+		}()
+	}
+	wg.Wait()
+
+	passed := make([]string, 0, len(moduleNames))
+	for i, moduleName := range moduleNames {
+		name, _ := config.SplitInstanceName(moduleName)
+		if !modules.Global.HasProbe(name) {
+			passed = append(passed, moduleName)
+			continue
+		}
+
+		err := errs[i]
+		mm.setProbeResult(moduleName, err)
+		switch {
+		case err == nil:
+			utils.Debugf("[%s] startup probe passed", moduleName)
+			passed = append(passed, moduleName)
+		case config.IsModuleError(err):
+			utils.Errorf("[%s] skipping module due to configuration error: %v", moduleName, err)
+			mm.setModuleState(moduleName, stateConfigError)
+		default:
+			utils.Errorf("[%s] skipping module, startup probe failed: %v", moduleName, err)
+			mm.setModuleState(moduleName, stateProbeFailed)
+		}
+	}
+	return passed
+}
+
+// setProbeResult records the probe result of a module for the "status" command.
+func (mm *ModuleManager) setProbeResult(moduleName string, err error) {
+	mm.stateMu.Lock()
+	defer mm.stateMu.Unlock()
+	if err != nil {
+		mm.probeResults[moduleName] = fmt.Sprintf("failed: %v", err)
+	} else {
+		mm.probeResults[moduleName] = "ok"
+	}
+}
+
+// getSchedule returns the collection schedule of a module, or nil if it has
+// none. Instances use the schedule of their module.
+func (mm *ModuleManager) getSchedule(moduleName string) *utils.Schedule {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil {
+		return nil
+	}
+	// Invalid schedules are reported as configuration errors when loading
+	schedule, _ := config.ParseSchedule(globalConfig.Modules[baseModuleName(moduleName)].Schedule)
+	return schedule
+}
+
+// getFieldRenamer returns the field renamer of a module, or nil if the module
+// doesn't rename fields. Instances use the mapping of their module.
+func (mm *ModuleManager) getFieldRenamer(moduleName string) *processors.FieldRenamer {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil {
+		return nil
+	}
+	return processors.NewFieldRenamer(globalConfig.Modules[baseModuleName(moduleName)].RenameFields)
+}
+
+// getAttributeMapper returns the attribute mapper of a module, or nil if the
+// module doesn't map attributes. Instances use the settings of their module.
+func (mm *ModuleManager) getAttributeMapper(moduleName string) *processors.AttributeMapper {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil {
+		return nil
+	}
+	return processors.NewAttributeMapper(globalConfig.Modules[baseModuleName(moduleName)].Attributes)
+}
+
+// getStateMapper returns the state mapper of a module, or nil if the module
+// doesn't map states. Instances use the mappings of their module.
+func (mm *ModuleManager) getStateMapper(moduleName string) *processors.StateMapper {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil {
+		return nil
+	}
+	return processors.NewStateMapper(globalConfig.Modules[baseModuleName(moduleName)].StateMappings)
+}
+
+// getTimestampAligner returns the timestamp aligner of a module, or nil if its
+// timestamps are not aligned. Instances use the interval of their module.
+func (mm *ModuleManager) getTimestampAligner(moduleName string) *processors.TimestampAligner {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil {
+		return nil
+	}
+	return processors.NewTimestampAligner(globalConfig.Modules[baseModuleName(moduleName)].AlignTimestamps.Duration())
+}
+
+// concurrencyLimit returns the number of goroutines a module may run
+// concurrently, or 0 if it is not limited. A module setting takes precedence
+// over the global one; instances use the limit of their module.
+func (mm *ModuleManager) concurrencyLimit(moduleName string) int {
+	globalConfig := mm.currentConfig()
+	limit := defaultModuleConcurrency
+	if globalConfig == nil {
+		return limit
+	}
+	if global := globalConfig.ModuleConcurrency; global != 0 {
+		limit = global
+	}
+	if module := globalConfig.Modules[baseModuleName(moduleName)].MaxConcurrency; module != 0 {
+		limit = module
+	}
+	if limit < 0 {
+		return 0
+	}
+	return limit
+}
+
+// moduleBuffer returns the buffer size of the channel a module sends its
+// metrics to. A module setting takes precedence over the global one; instances
+// use the setting of their module.
+func (mm *ModuleManager) moduleBuffer(moduleName string) int {
+	globalConfig := mm.currentConfig()
+	size := defaultModuleBuffer
+	if globalConfig == nil {
+		return size
+	}
+	if global := globalConfig.ModuleBuffer; global != 0 {
+		size = global
+	}
+	if module := globalConfig.Modules[baseModuleName(moduleName)].BufferSize; module != 0 {
+		size = module
+	}
+	return max(size, 0)
+}
+
+// missingMode returns how missing field values of a module are written. A
+// module setting takes precedence over the global one; instances use the
+// setting of their module. Unknown settings are logged and ignored.
+func (mm *ModuleManager) missingMode(moduleName string) metrics.MissingMode {
+	globalConfig := mm.currentConfig()
+	mode := metrics.MissingOmit
+	if globalConfig == nil {
+		return mode
+	}
+	for _, setting := range []string{globalConfig.MissingValues, globalConfig.Modules[baseModuleName(moduleName)].MissingValues} {
+		if setting == "" {
+			continue
+		}
+		if !metrics.MissingMode(setting).IsValid() {
+			utils.Warnf("[%s] ignoring unknown missing_values setting %q", moduleName, setting)
+			continue
+		}
+		mode = metrics.MissingMode(setting)
+	}
+	return mode
+}
+
+// nonFiniteMode returns how NaN and infinite field values of a module are
+// handled. A module setting takes precedence over the global one; instances use
+// the setting of their module. Unknown settings are logged and ignored.
+func (mm *ModuleManager) nonFiniteMode(moduleName string) metrics.NonFiniteMode {
+	globalConfig := mm.currentConfig()
+	mode := metrics.NonFiniteDropField
+	if globalConfig == nil {
+		return mode
+	}
+	for _, setting := range []string{globalConfig.NonFiniteValues, globalConfig.Modules[baseModuleName(moduleName)].NonFiniteValues} {
+		if setting == "" {
+			continue
+		}
+		if !metrics.NonFiniteMode(setting).IsValid() {
+			utils.Warnf("[%s] ignoring unknown non_finite_values setting %q", moduleName, setting)
+			continue
+		}
+		mode = metrics.NonFiniteMode(setting)
+	}
+	return mode
+}
+
+// startupDelay returns a random delay up to the startup jitter of a module, or
+// 0 if it has none. Instances use the jitter of their module.
+func (mm *ModuleManager) startupDelay(moduleName string) time.Duration {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil {
+		return 0
+	}
+	jitter := globalConfig.Modules[baseModuleName(moduleName)].StartupJitter.Duration()
+	if jitter <= 0 {
+		return 0
+	}
+	return rand.N(jitter)
+}
+
+// skipInitialCollection reports whether a module skips its initial collection.
+// Instances use the setting of their module.
+func (mm *ModuleManager) skipInitialCollection(moduleName string) bool {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil {
+		return false
+	}
+	return globalConfig.Modules[baseModuleName(moduleName)].SkipInitialCollection
+}
+
+// retryPolicy returns the retries of failed collections of a module, none if
+// it has no retry configured. Instances use the setting of their module.
+func (mm *ModuleManager) retryPolicy(moduleName string) utils.RetryPolicy {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil {
+		return utils.RetryPolicy{}
+	}
+	retry := globalConfig.Modules[baseModuleName(moduleName)].Retry
+	if retry == nil {
+		return utils.RetryPolicy{}
+	}
+	return utils.RetryPolicy{Attempts: max(retry.Attempts, 0), Backoff: retry.Backoff.Duration()}
+}
+
+// getDeviceFilter returns the device filter of a module, or nil if the module
+// doesn't filter devices. Instances use the lists of their module.
+func (mm *ModuleManager) getDeviceFilter(moduleName string) *processors.DeviceFilter {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil {
+		return nil
+	}
+	return processors.NewDeviceFilter(globalConfig.Modules[baseModuleName(moduleName)].Devices)
+}
+
+// getInstances returns the configured instances of a module, if any.
+func (mm *ModuleManager) getInstances(moduleName string) map[string]config.InstanceConfig {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil {
+		return nil
+	}
+	return globalConfig.Modules[moduleName].Instances
+}
+
+// logRestart logs module restart information.
+func (mm *ModuleManager) logRestart(moduleName string, restartCount, maxRestarts int) {
+	if maxRestarts == 0 {
+		utils.Infof("[%s] restarting module after completion/panic (restart %d/unlimited)", moduleName, restartCount)
+	} else {
+		utils.Infof("[%s] restarting module after completion/panic (restart %d/%d)", moduleName, restartCount, maxRestarts)
+	}
+}
+
+// filterEnabledModules filters modules based on enabled configuration.
+func filterEnabledModules(allModuleNames []string, globalConfig *config.GlobalConfig) (enabled, disabled []string) {
+	for _, moduleName := range allModuleNames {
+		enabledFlag := false
+		if globalConfig != nil && globalConfig.Modules != nil {
+			if moduleConfig, exists := globalConfig.Modules[moduleName]; exists {
+				enabledFlag = moduleConfig.Enabled
+				utils.Debugf("Module %s: enabled=%v (from config)", moduleName, enabledFlag)
+			} else {
+				utils.Debugf("Module %s: enabled=false (no config found)", moduleName)
+			}
+		} else {
+			utils.Debugf("Module %s: enabled=false (no global config)", moduleName)
+		}
+
+		if enabledFlag {
+			enabled = append(enabled, moduleName)
+		} else {
+			disabled = append(disabled, moduleName)
+		}
+	}
+	return enabled, disabled
+}
+
+// expandInstances replaces each module that has instances configured with its
+// instance-scoped names (e.g. "tasmota" becomes "tasmota.haus1" and "tasmota.haus2"),
+// so that every instance is started and restarted independently.
+func expandInstances(moduleNames []string, globalConfig *config.GlobalConfig) []string {
+	if globalConfig == nil {
+		return moduleNames
+	}
+
+	expanded := make([]string, 0, len(moduleNames))
+	for _, moduleName := range moduleNames {
+		moduleConfig := globalConfig.Modules[moduleName]
+		if len(moduleConfig.Instances) == 0 {
+			expanded = append(expanded, moduleName)
+			continue
+		}
+		for _, instance := range moduleConfig.InstanceNames() {
+			expanded = append(expanded, config.InstanceName(moduleName, instance))
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,716 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/metricchannel"
+	"github.com/janhuddel/metrics-agent/internal/modules"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestFilterEnabledModules(t *testing.T) {
+	// Register test modules
+	testRegistry := modules.NewRegistry()
+	testRegistry.Register("module1", func(ctx context.Context, ch chan<- metrics.Metric) error { return nil })
+	testRegistry.Register("module2", func(ctx context.Context, ch chan<- metrics.Metric) error { return nil })
+	testRegistry.Register("module3", func(ctx context.Context, ch chan<- metrics.Metric) error { return nil })
+
+	allModuleNames := testRegistry.List()
+
+	tests := []struct {
+		name             string
+		globalConfig     *config.GlobalConfig
+		expectedEnabled  []string
+		expectedDisabled []string
+		description      string
+	}{
+		{
+			name: "all modules enabled",
+			globalConfig: &config.GlobalConfig{
+				Modules: map[string]config.ModuleConfig{
+					"module1": {Enabled: true},
+					"module2": {Enabled: true},
+					"module3": {Enabled: true},
+				},
+			},
+			expectedEnabled:  []string{"module1", "module2", "module3"},
+			expectedDisabled: []string{},
+			description:      "All modules should be enabled when explicitly set to true",
+		},
+		{
+			name: "some modules enabled",
+			globalConfig: &config.GlobalConfig{
+				Modules: map[string]config.ModuleConfig{
+					"module1": {Enabled: true},
+					"module2": {Enabled: false},
+					"module3": {Enabled: true},
+				},
+			},
+			expectedEnabled:  []string{"module1", "module3"},
+			expectedDisabled: []string{"module2"},
+			description:      "Only explicitly enabled modules should be enabled",
+		},
+		{
+			name: "no modules enabled",
+			globalConfig: &config.GlobalConfig{
+				Modules: map[string]config.ModuleConfig{
+					"module1": {Enabled: false},
+					"module2": {Enabled: false},
+					"module3": {Enabled: false},
+				},
+			},
+			expectedEnabled:  []string{},
+			expectedDisabled: []string{"module1", "module2", "module3"},
+			description:      "No modules should be enabled when all set to false",
+		},
+		{
+			name: "enabled not specified (default disabled)",
+			globalConfig: &config.GlobalConfig{
+				Modules: map[string]config.ModuleConfig{
+					"module1": {LogLevel: "debug"}, // enabled not specified
+					"module2": {Enabled: true},
+					"module3": {LogLevel: "info"}, // enabled not specified
+				},
+			},
+			expectedEnabled:  []string{"module2"},
+			expectedDisabled: []string{"module1", "module3"},
+			description:      "Modules without enabled field should default to disabled",
+		},
+		{
+			name: "modules not in config (default disabled)",
+			globalConfig: &config.GlobalConfig{
+				Modules: map[string]config.ModuleConfig{
+					"module2": {Enabled: true},
+					// module1 and module3 not in config
+				},
+			},
+			expectedEnabled:  []string{"module2"},
+			expectedDisabled: []string{"module1", "module3"},
+			description:      "Modules not in config should default to disabled",
+		},
+		{
+			name:             "nil global config (all disabled)",
+			globalConfig:     nil,
+			expectedEnabled:  []string{},
+			expectedDisabled: []string{"module1", "module2", "module3"},
+			description:      "All modules should be disabled when global config is nil",
+		},
+		{
+			name: "nil modules config (all disabled)",
+			globalConfig: &config.GlobalConfig{
+				Modules: nil,
+			},
+			expectedEnabled:  []string{},
+			expectedDisabled: []string{"module1", "module2", "module3"},
+			description:      "All modules should be disabled when modules config is nil",
+		},
+		{
+			name: "empty modules config (all disabled)",
+			globalConfig: &config.GlobalConfig{
+				Modules: map[string]config.ModuleConfig{},
+			},
+			expectedEnabled:  []string{},
+			expectedDisabled: []string{"module1", "module2", "module3"},
+			description:      "All modules should be disabled when modules config is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enabled, disabled := filterEnabledModules(allModuleNames, tt.globalConfig)
+
+			// Check enabled modules
+			if len(enabled) != len(tt.expectedEnabled) {
+				t.Errorf("%s: Expected %d enabled modules, got %d", tt.description, len(tt.expectedEnabled), len(enabled))
+			}
+
+			// Check that all expected enabled modules are present
+			enabledMap := make(map[string]bool)
+			for _, module := range enabled {
+				enabledMap[module] = true
+			}
+			for _, expectedModule := range tt.expectedEnabled {
+				if !enabledMap[expectedModule] {
+					t.Errorf("%s: Expected module %s to be enabled, but it wasn't", tt.description, expectedModule)
+				}
+			}
+
+			// Check disabled modules
+			if len(disabled) != len(tt.expectedDisabled) {
+				t.Errorf("%s: Expected %d disabled modules, got %d", tt.description, len(tt.expectedDisabled), len(disabled))
+			}
+
+			// Check that all expected disabled modules are present
+			disabledMap := make(map[string]bool)
+			for _, module := range disabled {
+				disabledMap[module] = true
+			}
+			for _, expectedModule := range tt.expectedDisabled {
+				if !disabledMap[expectedModule] {
+					t.Errorf("%s: Expected module %s to be disabled, but it wasn't", tt.description, expectedModule)
+				}
+			}
+		})
+	}
+}
+
+func TestModuleFilteringEdgeCases(t *testing.T) {
+	t.Run("no registered modules", func(t *testing.T) {
+		emptyRegistry := modules.NewRegistry()
+		allModuleNames := emptyRegistry.List()
+
+		globalConfig := &config.GlobalConfig{
+			Modules: map[string]config.ModuleConfig{
+				"nonexistent": {Enabled: true},
+			},
+		}
+
+		enabled, disabled := filterEnabledModules(allModuleNames, globalConfig)
+
+		if len(enabled) != 0 {
+			t.Errorf("Expected 0 enabled modules, got %d", len(enabled))
+		}
+		if len(disabled) != 0 {
+			t.Errorf("Expected 0 disabled modules, got %d", len(disabled))
+		}
+	})
+
+	t.Run("module in config but not registered", func(t *testing.T) {
+		testRegistry := modules.NewRegistry()
+		testRegistry.Register("registered-module", func(ctx context.Context, ch chan<- metrics.Metric) error { return nil })
+		allModuleNames := testRegistry.List()
+
+		globalConfig := &config.GlobalConfig{
+			Modules: map[string]config.ModuleConfig{
+				"registered-module":   {Enabled: true},
+				"unregistered-module": {Enabled: true}, // This won't be in allModuleNames
+			},
+		}
+
+		enabled, disabled := filterEnabledModules(allModuleNames, globalConfig)
+
+		// Only the registered module should be considered
+		if len(enabled) != 1 || enabled[0] != "registered-module" {
+			t.Errorf("Expected only 'registered-module' to be enabled, got %v", enabled)
+		}
+		if len(disabled) != 0 {
+			t.Errorf("Expected 0 disabled modules, got %d", len(disabled))
+		}
+	})
+}
+
+// Benchmark the module filtering function
+func BenchmarkFilterEnabledModules(b *testing.B) {
+	// Create a large number of modules
+	testRegistry := modules.NewRegistry()
+	for i := 0; i < 100; i++ {
+		moduleName := fmt.Sprintf("module%d", i)
+		testRegistry.Register(moduleName, func(ctx context.Context, ch chan<- metrics.Metric) error { return nil })
+	}
+	allModuleNames := testRegistry.List()
+
+	// Create config with half enabled, half disabled
+	globalConfig := &config.GlobalConfig{
+		Modules: make(map[string]config.ModuleConfig),
+	}
+	for i, moduleName := range allModuleNames {
+		globalConfig.Modules[moduleName] = config.ModuleConfig{
+			Enabled: i%2 == 0, // Alternate between enabled/disabled
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		filterEnabledModules(allModuleNames, globalConfig)
+	}
+}
+
+func TestExpandInstances(t *testing.T) {
+	globalConfig := &config.GlobalConfig{
+		Modules: map[string]config.ModuleConfig{
+			"demo": {Enabled: true},
+			"tasmota": {Enabled: true, Instances: map[string]config.InstanceConfig{
+				"haus2": {}, "haus1": {},
+			}},
+		},
+	}
+
+	expanded := expandInstances([]string{"demo", "tasmota"}, globalConfig)
+	expected := []string{"demo", "tasmota.haus1", "tasmota.haus2"}
+	if strings.Join(expanded, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, expanded)
+	}
+
+	if expanded := expandInstances([]string{"demo"}, nil); len(expanded) != 1 || expanded[0] != "demo" {
+		t.Errorf("Expected modules to be unchanged without config, got %v", expanded)
+	}
+}
+
+func TestSkipConfigErrors(t *testing.T) {
+	globalConfig := &config.GlobalConfig{
+		ModuleErrors: map[string]error{
+			"tasmota": &config.ModuleError{Module: "tasmota", Err: fmt.Errorf("broken")},
+		},
+	}
+	mm := NewModuleManager(globalConfig)
+
+	valid := mm.skipConfigErrors([]string{"demo", "tasmota.haus1"})
+	if len(valid) != 1 || valid[0] != "demo" {
+		t.Errorf("Expected only demo to remain, got %v", valid)
+	}
+	if state := mm.moduleStates["tasmota.haus1"]; state != stateConfigError {
+		t.Errorf("Expected state %s, got %q", stateConfigError, state)
+	}
+}
+
+func TestProbeModules(t *testing.T) {
+	modules.Global.RegisterProbe("probe-ok", func(ctx context.Context) error { return nil })
+	modules.Global.RegisterProbe("probe-down", func(ctx context.Context) error {
+		return fmt.Errorf("connection refused")
+	})
+	modules.Global.RegisterProbe("probe-config", func(ctx context.Context) error {
+		return &config.ModuleError{Module: "probe-config", Err: fmt.Errorf("url is required")}
+	})
+	mm := NewModuleManager(&config.GlobalConfig{})
+
+	passed := mm.probeModules(context.Background(), []string{"demo", "probe-ok", "probe-down", "probe-config.haus1"})
+	if len(passed) != 2 || passed[0] != "demo" || passed[1] != "probe-ok" {
+		t.Errorf("Expected demo and probe-ok to pass, got %v", passed)
+	}
+	if state := mm.moduleStates["probe-down"]; state != stateProbeFailed {
+		t.Errorf("Expected state %s, got %q", stateProbeFailed, state)
+	}
+	if state := mm.moduleStates["probe-config.haus1"]; state != stateConfigError {
+		t.Errorf("Expected state %s, got %q", stateConfigError, state)
+	}
+	if result := mm.probeResults["probe-ok"]; result != "ok" {
+		t.Errorf("Expected probe result ok, got %q", result)
+	}
+	if result := mm.probeResults["probe-down"]; !strings.Contains(result, "connection refused") {
+		t.Errorf("Expected failed probe result, got %q", result)
+	}
+	if _, ok := mm.probeResults["demo"]; ok {
+		t.Error("Expected no probe result for module without probe")
+	}
+}
+
+func TestModuleChannelRecordsRecent(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{RecentMetrics: 2})
+	mm.metricCh = metricchannel.New(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := mm.moduleChannel(ctx, "demo")
+	for i := 1; i <= 3; i++ {
+		ch <- metrics.Metric{Name: "demo", Fields: map[string]interface{}{"value": i}}
+	}
+
+	out := mm.metricCh.Get()
+	for i := 1; i <= 3; i++ {
+		select {
+		case m := <-out:
+			if m.Fields["value"] != i {
+				t.Errorf("Expected value %d to be forwarded, got %v", i, m.Fields["value"])
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected metric %d to be forwarded", i)
+		}
+	}
+
+	recent := mm.recent.Get("demo")
+	if len(recent) != 2 || recent[0].Fields["value"] != 2 || recent[1].Fields["value"] != 3 {
+		t.Errorf("Expected the last 2 metrics to be kept, got %v", recent)
+	}
+	mm.handleCommand("recent demo")
+}
+
+func TestModuleChannelRecentDisabled(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{RecentMetrics: -1})
+	mm.metricCh = metricchannel.New(10)
+	if mm.recent != nil {
+		t.Fatal("Expected recent metrics to be disabled")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mm.moduleChannel(ctx, "demo") <- metrics.Metric{Name: "demo", Fields: map[string]interface{}{"value": 1}}
+	select {
+	case <-mm.metricCh.Get():
+	case <-time.After(time.Second):
+		t.Fatal("Expected metric to be forwarded")
+	}
+}
+
+func TestModuleChannelRenamesFields(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{Modules: map[string]config.ModuleConfig{
+		"demo": {RenameFields: map[string]string{"value": "reading"}},
+	}})
+	mm.metricCh = metricchannel.New(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Instances use the mapping of their module
+	mm.moduleChannel(ctx, "demo.haus1") <- metrics.Metric{Name: "demo", Fields: map[string]interface{}{"value": 1, "other": 2}}
+	select {
+	case m := <-mm.metricCh.Get():
+		if m.Fields["reading"] != 1 || m.Fields["other"] != 2 || m.Fields["value"] != nil {
+			t.Errorf("Expected value to be renamed to reading, got %v", m.Fields)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected metric to be forwarded")
+	}
+}
+
+func TestModuleChannelMapsStates(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{Modules: map[string]config.ModuleConfig{
+		"demo": {
+			RenameFields:  map[string]string{"status": "state"},
+			StateMappings: map[string]config.StateMapping{"state": {Values: map[string]int{"idle": 0, "charging": 1}, Tag: "state"}},
+		},
+	}})
+	mm.metricCh = metricchannel.New(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Mappings refer to the renamed fields
+	mm.moduleChannel(ctx, "demo.haus1") <- metrics.Metric{Name: "demo", Fields: map[string]interface{}{"status": "charging"}}
+	select {
+	case m := <-mm.metricCh.Get():
+		if m.Fields["state"] != 1 || m.Tags["state"] != "charging" {
+			t.Errorf("Expected state to be mapped, got %v %v", m.Fields, m.Tags)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected metric to be forwarded")
+	}
+}
+
+func TestModuleChannelMapsAttributes(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{Modules: map[string]config.ModuleConfig{
+		"demo": {Attributes: map[string]config.AttributeMapping{
+			"firmware": {As: config.AttributeField},
+			"model":    {As: config.AttributeDrop},
+		}},
+	}})
+	mm.metricCh = metricchannel.New(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mm.moduleChannel(ctx, "demo.haus1") <- metrics.Metric{
+		Name:   "demo",
+		Tags:   map[string]string{"device": "node1", "firmware": "1.2.3", "model": "D1"},
+		Fields: map[string]interface{}{"value": 1},
+	}
+	select {
+	case m := <-mm.metricCh.Get():
+		if m.Fields["firmware"] != "1.2.3" || len(m.Tags) != 1 {
+			t.Errorf("Expected firmware as field and model dropped, got %v %v", m.Fields, m.Tags)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected metric to be forwarded")
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	if limit := NewModuleManager(nil).concurrencyLimit("demo"); limit != defaultModuleConcurrency {
+		t.Errorf("Expected default limit %d without config, got %d", defaultModuleConcurrency, limit)
+	}
+
+	mm := NewModuleManager(&config.GlobalConfig{
+		ModuleConcurrency: 8,
+		Modules: map[string]config.ModuleConfig{
+			"tasmota": {MaxConcurrency: 2},
+			"meter":   {MaxConcurrency: -1},
+		},
+	})
+	for module, expected := range map[string]int{"demo": 8, "tasmota.haus1": 2, "meter": 0} {
+		if limit := mm.concurrencyLimit(module); limit != expected {
+			t.Errorf("Expected limit %d for %s, got %d", expected, module, limit)
+		}
+	}
+}
+
+func TestModuleChannelResolvesMissingValues(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{Modules: map[string]config.ModuleConfig{
+		"demo": {MissingValues: string(metrics.MissingZero)},
+	}})
+	mm.metricCh = metricchannel.New(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	temperature := 0.0
+	ch := mm.moduleChannel(ctx, "demo.haus1")
+	ch <- metrics.Metric{Name: "demo", Fields: map[string]interface{}{"temperature": &temperature, "humidity": (*int)(nil)}}
+	select {
+	case m := <-mm.metricCh.Get():
+		if m.Fields["temperature"] != 0.0 || m.Fields["humidity"] != 0 {
+			t.Errorf("Expected missing humidity written as zero, got %v", m.Fields)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected metric to be forwarded")
+	}
+
+	// By default missing values are omitted, and metrics without values dropped
+	mm = NewModuleManager(nil)
+	mm.metricCh = metricchannel.New(10)
+	ch = mm.moduleChannel(ctx, "demo")
+	ch <- metrics.Metric{Name: "demo", Fields: map[string]interface{}{"humidity": (*int)(nil)}}
+	ch <- metrics.Metric{Name: "demo", Fields: map[string]interface{}{"temperature": &temperature, "humidity": (*int)(nil)}}
+	select {
+	case m := <-mm.metricCh.Get():
+		if len(m.Fields) != 1 || m.Fields["temperature"] != 0.0 {
+			t.Errorf("Expected only temperature, got %v", m.Fields)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected metric to be forwarded")
+	}
+}
+
+func TestMissingMode(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{
+		MissingValues: "zero",
+		Modules: map[string]config.ModuleConfig{
+			"tasmota": {MissingValues: "omit"},
+			"meter":   {MissingValues: "null"},
+		},
+	})
+	for module, expected := range map[string]metrics.MissingMode{"demo": metrics.MissingZero, "tasmota.haus1": metrics.MissingOmit, "meter": metrics.MissingZero} {
+		if mode := mm.missingMode(module); mode != expected {
+			t.Errorf("Expected mode %s for %s, got %s", expected, module, mode)
+		}
+	}
+	if mode := NewModuleManager(nil).missingMode("demo"); mode != metrics.MissingOmit {
+		t.Errorf("Expected missing values omitted without config, got %s", mode)
+	}
+}
+
+func TestModuleChannelDropsNonFinite(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{Modules: map[string]config.ModuleConfig{
+		"meter": {NonFiniteValues: "drop_metric"},
+	}})
+	mm.metricCh = metricchannel.New(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// By default only the NaN field is dropped
+	mm.moduleChannel(ctx, "demo") <- metrics.Metric{Name: "demo", Fields: map[string]interface{}{"temperature": math.NaN(), "humidity": 40}}
+	select {
+	case m := <-mm.metricCh.Get():
+		if len(m.Fields) != 1 || m.Fields["humidity"] != 40 {
+			t.Errorf("Expected only humidity, got %v", m.Fields)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected metric to be forwarded")
+	}
+
+	// With drop_metric the whole metric is dropped
+	ch := mm.moduleChannel(ctx, "meter")
+	ch <- metrics.Metric{Name: "meter", Fields: map[string]interface{}{"power": math.Inf(1), "voltage": 230.0}}
+	ch <- metrics.Metric{Name: "meter", Fields: map[string]interface{}{"power": 100.0}}
+	select {
+	case m := <-mm.metricCh.Get():
+		if m.Fields["power"] != 100.0 {
+			t.Errorf("Expected metric with infinite power dropped, got %v", m.Fields)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected metric to be forwarded")
+	}
+	if dropped := mm.pipelineStats()["non_finite_dropped"]; dropped < 2 {
+		t.Errorf("Expected dropped values counted, got %d", dropped)
+	}
+}
+
+func TestModuleChannelAlignsTimestamps(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{Modules: map[string]config.ModuleConfig{
+		"demo": {AlignTimestamps: config.Duration(10 * time.Second)},
+	}})
+	mm.metricCh = metricchannel.New(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	timestamp := time.Date(2024, 6, 1, 12, 0, 17, 250, time.UTC)
+	mm.moduleChannel(ctx, "demo.haus1") <- metrics.Metric{Name: "demo", Fields: map[string]interface{}{"value": 1}, Timestamp: timestamp}
+	select {
+	case m := <-mm.metricCh.Get():
+		if expected := time.Date(2024, 6, 1, 12, 0, 10, 0, time.UTC); !m.Timestamp.Equal(expected) {
+			t.Errorf("Expected timestamp aligned to %v, got %v", expected, m.Timestamp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected metric to be forwarded")
+	}
+}
+
+func TestModuleChannelFiltersDevices(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{Modules: map[string]config.ModuleConfig{
+		"demo": {Devices: &config.DeviceFilter{Exclude: []string{"neighbor*"}}},
+	}})
+	mm.metricCh = metricchannel.New(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := mm.moduleChannel(ctx, "demo.haus1")
+	ch <- metrics.Metric{Name: "demo", Tags: map[string]string{"device": "neighbor1"}, Fields: map[string]interface{}{"value": 1}}
+	ch <- metrics.Metric{Name: "demo", Tags: map[string]string{"device": "own"}, Fields: map[string]interface{}{"value": 2}}
+	select {
+	case m := <-mm.metricCh.Get():
+		if m.Tags["device"] != "own" {
+			t.Errorf("Expected metric of excluded device to be dropped, got %v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected metric to be forwarded")
+	}
+}
+
+func TestStartupBurstProtection(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{Modules: map[string]config.ModuleConfig{
+		"dwd":  {StartupJitter: config.Duration(10 * time.Second), SkipInitialCollection: true},
+		"demo": {},
+	}})
+
+	// Instances use the settings of their module
+	for i := 0; i < 100; i++ {
+		if delay := mm.startupDelay("dwd.haus1"); delay < 0 || delay >= 10*time.Second {
+			t.Fatalf("Expected delay below 10s, got %v", delay)
+		}
+	}
+	if !mm.skipInitialCollection("dwd.haus1") {
+		t.Error("Expected dwd instance to skip the initial collection")
+	}
+
+	if delay := mm.startupDelay("demo"); delay != 0 {
+		t.Errorf("Expected no delay without jitter, got %v", delay)
+	}
+	if mm.skipInitialCollection("demo") {
+		t.Error("Expected demo to collect initially")
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{Modules: map[string]config.ModuleConfig{
+		"netatmo": {Retry: &config.RetryConfig{Attempts: 3, Backoff: config.Duration(10 * time.Second)}},
+		"dwd":     {Retry: &config.RetryConfig{Attempts: -1}},
+	}})
+
+	// Instances use the setting of their module
+	if policy := mm.retryPolicy("netatmo.haus1"); policy.Attempts != 3 || policy.Backoff != 10*time.Second {
+		t.Errorf("Expected 3 retries with 10s backoff, got %+v", policy)
+	}
+	if policy := mm.retryPolicy("dwd"); policy.Attempts != 0 {
+		t.Errorf("Expected no retries for negative attempts, got %+v", policy)
+	}
+	if policy := mm.retryPolicy("demo"); policy != (utils.RetryPolicy{}) {
+		t.Errorf("Expected no retries without configuration, got %+v", policy)
+	}
+}
+
+func TestExitReason(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	active := context.Background()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		err      error
+		expected string
+	}{
+		{"nil while running", active, nil, exitNormal},
+		{"error while running", active, errors.New("connection lost"), exitError},
+		{"own cancellation while running", active, context.Canceled, exitError},
+		{"nil when stopped", canceled, nil, exitCanceled},
+		{"ctx.Err() when stopped", canceled, context.Canceled, exitCanceled},
+		{"wrapped cancellation when stopped", canceled, fmt.Errorf("failed to connect: %w", context.Canceled), exitCanceled},
+		{"error when stopped", canceled, errors.New("connection lost"), exitError},
+	}
+	for _, tt := range tests {
+		if reason := exitReason(tt.ctx, tt.err); reason != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.expected, reason)
+		}
+	}
+}
+
+func TestRunModuleExitReasons(t *testing.T) {
+	modules.Global.Register("finishing-test", func(ctx context.Context, ch chan<- metrics.Metric) error {
+		return nil
+	})
+	modules.Global.Register("canceled-test", func(ctx context.Context, ch chan<- metrics.Metric) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	storage, err := utils.NewStorageWithConfig(&utils.StorageConfig{ModuleName: "restarts", PreferredDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	mm := NewModuleManager(&config.GlobalConfig{})
+	mm.metricCh = metricchannel.New(10)
+	mm.restarts = utils.NewRestartHistory(storage, 5)
+
+	// A module that is done is neither restarted nor counted as failure
+	var wg sync.WaitGroup
+	wg.Add(1)
+	mm.runModule(context.Background(), &wg, "finishing-test", 3)
+	if restarts := mm.restarts.Get("finishing-test"); len(restarts) != 0 {
+		t.Errorf("Expected no restart of a finished module, got %+v", restarts)
+	}
+	if state := mm.moduleStates["finishing-test"]; state != "finished" {
+		t.Errorf("Expected state finished, got %q", state)
+	}
+
+	// A module returning ctx.Err() on shutdown is stopped, not failed
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	wg.Add(1)
+	mm.runModule(ctx, &wg, "canceled-test", 3)
+	if restarts := mm.restarts.Get("canceled-test"); len(restarts) != 0 {
+		t.Errorf("Expected no restart of a stopped module, got %+v", restarts)
+	}
+	if state := mm.moduleStates["canceled-test"]; state != "stopped" {
+		t.Errorf("Expected state stopped, got %q", state)
+	}
+}
+
+func TestCrashLoopDetector(t *testing.T) {
+	d := crashLoopDetector{after: 50 * time.Millisecond}
+
+	// Quick failures within the window are not notified yet
+	since := d.failure(time.Now())
+	if d.due() {
+		t.Errorf("Expected no notification right after the first failure")
+	}
+
+	// Failing for longer than the window is notified once
+	time.Sleep(60 * time.Millisecond)
+	if d.failure(time.Now()) != since {
+		t.Errorf("Expected quick failures to belong to the same crash loop")
+	}
+	if !d.due() {
+		t.Errorf("Expected notification after failing for longer than the window")
+	}
+	if d.due() {
+		t.Errorf("Expected only one notification per crash loop")
+	}
+
+	// A run longer than the window starts a new crash loop
+	if d.failure(time.Now().Add(-time.Second)) == since {
+		t.Errorf("Expected a new crash loop after a long run")
+	}
+	if d.due() {
+		t.Errorf("Expected no notification at the start of a new crash loop")
+	}
+
+	// Without a window, crash loops are never notified
+	disabled := crashLoopDetector{}
+	disabled.failure(time.Now().Add(-time.Hour))
+	if disabled.due() {
+		t.Errorf("Expected crash loop notifications to be disabled")
+	}
+}
@@ -0,0 +1,472 @@
+// Package agent runs the metric collection modules of the metrics-agent.
+//
+// This file contains the metrics the agent reports about itself: resource
+// usage of the modules, output queues, error budgets, device inventory,
+// absence markers, heartbeats and the agent status.
+package agent
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/modules"
+	"github.com/janhuddel/metrics-agent/internal/notify"
+	"github.com/janhuddel/metrics-agent/internal/output"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// agentInfoMetricName is the name of the metric describing the running binary
+const agentInfoMetricName = "agent_info"
+
+// agentStatusMetricName is the name of the metric reporting agent startup and planned shutdown
+const agentStatusMetricName = "agent_status"
+
+// selfMetricName is the name of the metric reporting the resource usage of a module
+const selfMetricName = "agent_module"
+
+// outputMetricName is the name of the metric reporting the queue and lag of an output
+const outputMetricName = "agent_output"
+
+// defaultErrorBudgetWindow is the window upstream calls are counted in if the
+// error budget of a module doesn't set one
+const defaultErrorBudgetWindow = time.Hour
+
+// defaultErrorBudgetMinCalls is the number of calls below which an error budget is not evaluated
+const defaultErrorBudgetMinCalls = 10
+
+// errorBudgetCheckInterval is how often the error budgets are checked for notifications
+const errorBudgetCheckInterval = time.Minute
+
+// inventoryMetricName is the name of the metric with the metadata of a device
+const inventoryMetricName = "device_inventory"
+
+// heartbeatMetricName is the name of the metric sent for each running module
+const heartbeatMetricName = "module_up"
+
+// getSelfMetricsInterval returns the configured self-metrics interval.
+// Zero disables the self-metrics.
+func (mm *ModuleManager) getSelfMetricsInterval() time.Duration {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil || globalConfig.SelfMetricsInterval < 0 {
+		return 0
+	}
+	return globalConfig.SelfMetricsInterval.Duration()
+}
+
+// reportSelfMetrics sends the module metrics every interval until ctx is cancelled.
+func (mm *ModuleManager) reportSelfMetrics(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mm.sendSelfMetrics()
+			mm.sendOutputMetrics()
+		}
+	}
+}
+
+// sendSelfMetrics sends an agent_module metric with the goroutine count of each
+// running module to the metric channel.
+func (mm *ModuleManager) sendSelfMetrics() {
+	goroutines, err := utils.GoroutinesByLabel(moduleLabel)
+	if err != nil {
+		utils.Warnf("Failed to count goroutines per module: %v", err)
+		return
+	}
+
+	now := time.Now()
+	ch := mm.channel().Get()
+	for _, name := range mm.runningModules() {
+		metric := metrics.Metric{
+			Name:      selfMetricName,
+			Tags:      map[string]string{"module": name},
+			Fields:    map[string]interface{}{"goroutines": goroutines[name]},
+			Timestamp: now,
+		}
+		select {
+		case ch <- metric:
+		default:
+			utils.Warnf("Metrics channel is full, dropping self-metric of module %s", name)
+		}
+	}
+}
+
+// outputStats returns the state of each output by name: "stdout" for the
+// Line Protocol and "otlp" if the OTLP export is configured.
+func (mm *ModuleManager) outputStats() map[string]output.Stats {
+	stats := make(map[string]output.Stats, 2)
+	if metricCh := mm.channel(); metricCh != nil {
+		stats["stdout"] = metricCh.Stats()
+	}
+	if mm.exporter != nil {
+		stats["otlp"] = mm.exporter.Stats()
+	}
+	return stats
+}
+
+// sendOutputMetrics sends an agent_output metric with the queue depth, flush
+// duration and last success of each output to the metric channel, so an
+// output falling behind can be told apart from one that keeps up.
+func (mm *ModuleManager) sendOutputMetrics() {
+	now := time.Now()
+	ch := mm.channel().Get()
+	for name, stats := range mm.outputStats() {
+		fields := map[string]interface{}{
+			"queue_depth":       stats.Queued,
+			"dropped":           stats.Dropped,
+			"failures":          stats.Failures,
+			"flush_duration_ms": float64(stats.FlushDuration) / float64(time.Millisecond),
+		}
+		if !stats.LastSuccess.IsZero() {
+			fields["last_success"] = stats.LastSuccess.Unix()
+		}
+		tags := map[string]string{"output": name}
+		if stats.Shadow {
+			tags["shadow"] = "true"
+		}
+		metric := metrics.Metric{
+			Name:   outputMetricName,
+			Tags:   tags,
+			Fields: fields,
+			FieldKinds: map[string]metrics.Kind{
+				"dropped":  metrics.KindCounter,
+				"failures": metrics.KindCounter,
+			},
+			Timestamp: now,
+		}
+		select {
+		case ch <- metric:
+		default:
+			utils.Warnf("Metrics channel is full, dropping self-metric of output %s", name)
+		}
+	}
+}
+
+// runningModules returns the sorted names of the running modules and instances.
+func (mm *ModuleManager) runningModules() []string {
+	mm.stateMu.Lock()
+	names := make([]string, 0, len(mm.moduleStates))
+	for name, state := range mm.moduleStates {
+		if strings.HasPrefix(state, "running") {
+			names = append(names, name)
+		}
+	}
+	mm.stateMu.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// errorBudget returns the error budget of a module, or nil if it has none.
+// A module setting takes precedence over the global one; instances use the
+// budget of their module.
+func (mm *ModuleManager) errorBudget(moduleName string) *config.ErrorBudgetConfig {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil {
+		return nil
+	}
+	if budget := globalConfig.Modules[baseModuleName(moduleName)].ErrorBudget; budget != nil {
+		return budget
+	}
+	return globalConfig.ErrorBudget
+}
+
+// callWindow returns the window the upstream calls of a module are counted in.
+func (mm *ModuleManager) callWindow(moduleName string) time.Duration {
+	if budget := mm.errorBudget(moduleName); budget != nil && budget.Window > 0 {
+		return min(budget.Window.Duration(), utils.MaxCallWindow)
+	}
+	return defaultErrorBudgetWindow
+}
+
+// checkErrorBudget returns an error if the failed upstream calls of a module
+// exceed its error budget.
+func (mm *ModuleManager) checkErrorBudget(moduleName string) error {
+	budget := mm.errorBudget(moduleName)
+	if budget == nil {
+		return nil
+	}
+	minCalls := budget.MinCalls
+	if minCalls <= 0 {
+		minCalls = defaultErrorBudgetMinCalls
+	}
+
+	window := mm.callWindow(moduleName)
+	counts := utils.ModuleCalls(moduleName, window)
+	if counts.Calls < minCalls || counts.FailureRatio() <= budget.MaxFailureRatio {
+		return nil
+	}
+	return fmt.Errorf("error budget exceeded: %d of %d upstream calls failed in the last %v",
+		counts.Failures, counts.Calls, window)
+}
+
+// moduleHealth returns the health of the modules: the result of their health
+// check, or an error if a running module exceeds its error budget. Modules
+// with an error budget count as checked.
+func (mm *ModuleManager) moduleHealth() map[string]error {
+	health := modules.Global.Health()
+	for _, name := range mm.runningModules() {
+		if mm.errorBudget(name) == nil {
+			continue
+		}
+		if err := mm.checkErrorBudget(name); err != nil && health[name] == nil {
+			health[name] = err
+		} else if _, checked := health[name]; !checked {
+			health[name] = nil
+		}
+	}
+	return health
+}
+
+// callSummaries returns the upstream calls of each running module that made
+// any, e.g. "(calls: 3 of 40 failed in 1h0m0s)".
+func (mm *ModuleManager) callSummaries() map[string]string {
+	summaries := make(map[string]string)
+	for _, name := range mm.runningModules() {
+		window := mm.callWindow(name)
+		if counts := utils.ModuleCalls(name, window); counts.Calls > 0 {
+			summaries[name] = fmt.Sprintf("(calls: %d of %d failed in %v)", counts.Failures, counts.Calls, window)
+		}
+	}
+	return summaries
+}
+
+// watchErrorBudgets checks the error budgets of the running modules every
+// errorBudgetCheckInterval until ctx is cancelled.
+func (mm *ModuleManager) watchErrorBudgets(ctx context.Context) {
+	ticker := time.NewTicker(errorBudgetCheckInterval)
+	defer ticker.Stop()
+
+	exceeded := make(map[string]bool)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mm.checkErrorBudgets(exceeded)
+		}
+	}
+}
+
+// checkErrorBudgets logs modules that exceeded their error budget or are back
+// within it, and notifies once per exceedance if the budget asks for it.
+// exceeded holds the modules over budget at the previous check.
+func (mm *ModuleManager) checkErrorBudgets(exceeded map[string]bool) {
+	for _, name := range mm.runningModules() {
+		err := mm.checkErrorBudget(name)
+		switch {
+		case err != nil && !exceeded[name]:
+			exceeded[name] = true
+			utils.Warnf("[%s] %v", name, err)
+			if mm.errorBudget(name).Notify {
+				mm.sendNotification(notify.Event{
+					Module: name,
+					Reason: notify.ReasonErrorBudget,
+					Error:  err.Error(),
+					Since:  time.Now(),
+				})
+			}
+		case err == nil && exceeded[name]:
+			delete(exceeded, name)
+			utils.Infof("[%s] upstream calls are within the error budget again", name)
+		}
+	}
+}
+
+// getDeviceInventoryInterval returns the configured device inventory interval.
+// Zero disables the inventory.
+func (mm *ModuleManager) getDeviceInventoryInterval() time.Duration {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil || globalConfig.DeviceInventoryInterval < 0 {
+		return 0
+	}
+	return globalConfig.DeviceInventoryInterval.Duration()
+}
+
+// reportDeviceInventory sends the device inventory every interval until ctx is cancelled.
+func (mm *ModuleManager) reportDeviceInventory(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mm.sendDeviceInventory()
+		}
+	}
+}
+
+// sendDeviceInventory sends a device_inventory metric with the metadata of
+// each device known to a running module to the metric channel. The metadata
+// is sent as fields, so it doesn't add series when it changes.
+func (mm *ModuleManager) sendDeviceInventory() {
+	now := time.Now()
+	ch := mm.channel().Get()
+	for _, device := range mm.inventory.Devices(mm.runningModules()) {
+		fields := map[string]interface{}{
+			"module":    device.Module,
+			"last_seen": device.LastSeen.Unix(),
+		}
+		for name, value := range map[string]string{"model": device.Model, "ip": device.IP, "firmware": device.Firmware} {
+			if value != "" {
+				fields[name] = value
+			}
+		}
+		metric := metrics.Metric{
+			Name:      inventoryMetricName,
+			Tags:      map[string]string{"device": device.Device},
+			Fields:    fields,
+			Timestamp: now,
+		}
+		select {
+		case ch <- metric:
+		default:
+			utils.Warnf("Metrics channel is full, dropping inventory of device %s", device.Device)
+		}
+	}
+}
+
+// reportAbsence sends the markers of devices that fell silent every interval
+// until ctx is cancelled.
+func (mm *ModuleManager) reportAbsence(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			ch := mm.channel().Get()
+			for _, marker := range mm.absence.Expired(now) {
+				utils.Infof("Device %s sent no %s metric for its TTL, marking it absent", marker.Tags["device"], marker.Name)
+				select {
+				case ch <- marker:
+				default:
+					utils.Warnf("Metrics channel is full, dropping absence marker of device %s", marker.Tags["device"])
+				}
+			}
+		}
+	}
+}
+
+// getHeartbeatInterval returns the configured heartbeat interval.
+// Zero disables the heartbeats.
+func (mm *ModuleManager) getHeartbeatInterval() time.Duration {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil || globalConfig.HeartbeatInterval < 0 {
+		return 0
+	}
+	return globalConfig.HeartbeatInterval.Duration()
+}
+
+// reportHeartbeats sends the heartbeats every interval until ctx is cancelled.
+func (mm *ModuleManager) reportHeartbeats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mm.sendHeartbeats()
+		}
+	}
+}
+
+// sendHeartbeats sends a module_up metric for each running module to the metric
+// channel. Unlike the metrics of a module, the heartbeat doesn't depend on its
+// devices sending data, so a missing series means the module is dead or restarting.
+func (mm *ModuleManager) sendHeartbeats() {
+	now := time.Now()
+	ch := mm.channel().Get()
+	for _, name := range mm.runningModules() {
+		module, instance := config.SplitInstanceName(name)
+		tags := map[string]string{"module": module}
+		if instance != "" {
+			tags["instance"] = instance
+		}
+		metric := metrics.Metric{
+			Name:      heartbeatMetricName,
+			Tags:      tags,
+			Fields:    map[string]interface{}{"value": 1},
+			Timestamp: now,
+		}
+		select {
+		case ch <- metric:
+		default:
+			utils.Warnf("Metrics channel is full, dropping heartbeat of module %s", name)
+		}
+	}
+}
+
+// writeAgentStatus writes an agent_status metric directly to stdout. It bypasses
+// the metric channel and pipeline, so it is written even while the channel is
+// shut down and always precedes or follows the module metrics.
+func (mm *ModuleManager) writeAgentStatus(up bool, reason string) {
+	mm.writeAgentMetric(agentStatusMetric(up, reason))
+}
+
+// writeAgentMetric writes a metric about the agent itself directly to stdout
+// with the identity tags, bypassing the metric channel and pipeline.
+func (mm *ModuleManager) writeAgentMetric(metric metrics.Metric) {
+	globalConfig := mm.currentConfig()
+	metric.Tags = identityTags(globalConfig)
+	line, err := metric.ToLineProtocolSafe()
+	if err != nil {
+		utils.Errorf("Failed to serialize %s: %v", metric.Name, err)
+		return
+	}
+	if err := utils.Stdout().WriteLine(line); err != nil {
+		utils.Errorf("Failed to write %s: %v", metric.Name, err)
+	}
+}
+
+// agentStatusMetric builds the agent_status metric with status 1 if the agent is
+// up and 0 on a planned stop.
+func agentStatusMetric(up bool, reason string) metrics.Metric {
+	status := 0
+	if up {
+		status = 1
+	}
+	return metrics.Metric{
+		Name: agentStatusMetricName,
+		Fields: map[string]interface{}{
+			"status":   status,
+			"reason":   reason,
+			"version":  version,
+			"run_id":   utils.RunID(),
+			"agent_id": optionalString(utils.AgentID()),
+		},
+		Timestamp: time.Now(),
+	}
+}
+
+// agentInfoMetric builds the agent_info metric describing the running binary,
+// so the version each host runs can be seen next to its metrics.
+func agentInfoMetric() metrics.Metric {
+	commit, built := buildInfo()
+	return metrics.Metric{
+		Name: agentInfoMetricName,
+		Fields: map[string]interface{}{
+			"version":    version,
+			"commit":     optionalString(commit),
+			"build_date": optionalString(built),
+			"go_version": runtime.Version(),
+			"platform":   runtime.GOOS + "/" + runtime.GOARCH,
+		},
+		Timestamp: time.Now(),
+	}
+}
@@ -0,0 +1,228 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/metricchannel"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestSendSelfMetrics(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{SelfMetricsInterval: config.Duration(time.Minute)})
+	mm.metricCh = metricchannel.New(10)
+	mm.setModuleState("selftest", "running (restarts: 0)")
+	mm.setModuleState("stoppedtest", "stopped")
+
+	if interval := mm.getSelfMetricsInterval(); interval != time.Minute {
+		t.Errorf("Expected interval 1m, got %v", interval)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	started := make(chan struct{})
+	runtimepprof.Do(context.Background(), runtimepprof.Labels(moduleLabel, "selftest"), func(context.Context) {
+		go func() {
+			close(started)
+			<-stop
+		}()
+	})
+	<-started
+
+	mm.sendSelfMetrics()
+
+	ch := mm.metricCh.Get()
+	if len(ch) != 1 {
+		t.Fatalf("Expected 1 metric for the running module, got %d", len(ch))
+	}
+	metric := <-ch
+	if metric.Name != selfMetricName || metric.Tags["module"] != "selftest" {
+		t.Errorf("Unexpected metric %s %v", metric.Name, metric.Tags)
+	}
+	if metric.Fields["goroutines"] != 1 {
+		t.Errorf("Expected 1 goroutine, got %v", metric.Fields["goroutines"])
+	}
+}
+
+func TestSendOutputMetrics(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{SelfMetricsInterval: config.Duration(time.Minute)})
+	mm.metricCh = metricchannel.New(10)
+	mm.metricCh.Get() <- metrics.Metric{Name: "queued", Fields: map[string]interface{}{"value": 1}}
+
+	mm.sendOutputMetrics()
+
+	ch := mm.metricCh.Get()
+	<-ch
+	if len(ch) != 1 {
+		t.Fatalf("Expected 1 metric for stdout without OTLP export, got %d", len(ch))
+	}
+	metric := <-ch
+	if metric.Name != outputMetricName || metric.Tags["output"] != "stdout" {
+		t.Errorf("Unexpected metric %s %v", metric.Name, metric.Tags)
+	}
+	if metric.Fields["queue_depth"] != 1 || metric.FieldKind("dropped") != metrics.KindCounter {
+		t.Errorf("Expected queue depth 1 and dropped counter, got %v", metric.Fields)
+	}
+	if _, exists := metric.Fields["last_success"]; exists {
+		t.Error("Expected no last_success before the first write")
+	}
+}
+
+func TestSendDeviceInventory(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{DeviceInventoryInterval: config.Duration(time.Hour)})
+	mm.metricCh = metricchannel.New(10)
+	mm.setModuleState("demo", "running (restarts: 0)")
+	mm.setModuleState("stoppedtest", "stopped")
+
+	if interval := mm.getDeviceInventoryInterval(); interval != time.Hour {
+		t.Errorf("Expected interval 1h, got %v", interval)
+	}
+
+	// Devices are known from their metrics and from the metadata reported by modules
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mm.moduleChannel(ctx, "demo") <- metrics.Metric{Name: "demo", Tags: map[string]string{"device": "node1"}, Fields: map[string]interface{}{"value": 1}}
+	<-mm.metricCh.Get()
+	mm.inventory.Report("demo", "node1", utils.DeviceMetadata{Model: "D1 mini", Firmware: "2026.9.0"}, time.Now())
+	mm.inventory.Seen("stoppedtest", "node2", time.Now())
+
+	mm.sendDeviceInventory()
+
+	ch := mm.metricCh.Get()
+	if len(ch) != 1 {
+		t.Fatalf("Expected 1 metric for the device of the running module, got %d", len(ch))
+	}
+	metric := <-ch
+	if metric.Name != inventoryMetricName || metric.Tags["device"] != "node1" || len(metric.Tags) != 1 {
+		t.Errorf("Unexpected metric %s %v", metric.Name, metric.Tags)
+	}
+	if metric.Fields["module"] != "demo" || metric.Fields["model"] != "D1 mini" || metric.Fields["firmware"] != "2026.9.0" {
+		t.Errorf("Expected device metadata as fields, got %v", metric.Fields)
+	}
+	if _, ok := metric.Fields["ip"]; ok {
+		t.Errorf("Expected no field for unknown IP, got %v", metric.Fields)
+	}
+}
+
+func TestSendHeartbeats(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{HeartbeatInterval: config.Duration(time.Minute)})
+	mm.metricCh = metricchannel.New(10)
+	mm.setModuleState("demo", "running (restarts: 0)")
+	mm.setModuleState("tasmota.haus1", "running (restarts: 2)")
+	mm.setModuleState("stoppedtest", "restarting in 5s")
+
+	if interval := mm.getHeartbeatInterval(); interval != time.Minute {
+		t.Errorf("Expected interval 1m, got %v", interval)
+	}
+
+	mm.sendHeartbeats()
+
+	ch := mm.metricCh.Get()
+	if len(ch) != 2 {
+		t.Fatalf("Expected 2 heartbeats for the running modules, got %d", len(ch))
+	}
+	demo, instance := <-ch, <-ch
+	if demo.Name != heartbeatMetricName || demo.Tags["module"] != "demo" || len(demo.Tags) != 1 {
+		t.Errorf("Unexpected heartbeat %s %v", demo.Name, demo.Tags)
+	}
+	if instance.Tags["module"] != "tasmota" || instance.Tags["instance"] != "haus1" {
+		t.Errorf("Expected module and instance tags, got %v", instance.Tags)
+	}
+	if demo.Fields["value"] != 1 {
+		t.Errorf("Expected value 1, got %v", demo.Fields["value"])
+	}
+}
+
+func TestErrorBudget(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{
+		ErrorBudget: &config.ErrorBudgetConfig{MaxFailureRatio: 0.5, MinCalls: 4},
+		Modules: map[string]config.ModuleConfig{
+			"budgetlenient": {ErrorBudget: &config.ErrorBudgetConfig{Window: config.Duration(48 * time.Hour), MaxFailureRatio: 0.9, MinCalls: 4}},
+		},
+	})
+	for _, name := range []string{"budgetstrict.haus1", "budgetlenient", "budgetidle"} {
+		mm.setModuleState(name, "running (restarts: 0)")
+	}
+	for _, name := range []string{"budgetstrict.haus1", "budgetlenient"} {
+		utils.RecordCall(name, http.StatusOK, nil)
+		for i := 0; i < 3; i++ {
+			utils.RecordCall(name, http.StatusTooManyRequests, nil)
+		}
+	}
+
+	if window := mm.callWindow("budgetlenient"); window != utils.MaxCallWindow {
+		t.Errorf("Expected window limited to %v, got %v", utils.MaxCallWindow, window)
+	}
+	health := mm.moduleHealth()
+	if err, checked := health["budgetstrict.haus1"]; !checked || err == nil {
+		t.Errorf("Expected module over budget to be unhealthy, got %v", err)
+	}
+	if err, checked := health["budgetlenient"]; !checked || err != nil {
+		t.Errorf("Expected module within its own budget to be healthy, got %v", err)
+	}
+	if err, checked := health["budgetidle"]; !checked || err != nil {
+		t.Errorf("Expected module without calls to be healthy, got %v", err)
+	}
+	if summary := mm.callSummaries()["budgetstrict.haus1"]; summary != "(calls: 3 of 4 failed in 1h0m0s)" {
+		t.Errorf("Unexpected call summary %q", summary)
+	}
+
+	// Exceeding the budget is reported once
+	exceeded := make(map[string]bool)
+	mm.checkErrorBudgets(exceeded)
+	if !exceeded["budgetstrict.haus1"] || len(exceeded) != 1 {
+		t.Errorf("Expected only the strict module over budget, got %v", exceeded)
+	}
+}
+
+func TestAgentStatusMetric(t *testing.T) {
+	tests := []struct {
+		up       bool
+		reason   string
+		expected string
+	}{
+		{true, "started", `agent_status reason="started",run_id="` + utils.RunID() + `",status=1i,version="dev"`},
+		{false, "signal", `agent_status reason="signal",run_id="` + utils.RunID() + `",status=0i,version="dev"`},
+	}
+	for _, tt := range tests {
+		line, err := agentStatusMetric(tt.up, tt.reason).ToLineProtocolSafe()
+		if err != nil {
+			t.Fatalf("Failed to serialize agent status: %v", err)
+		}
+		if !strings.HasPrefix(line, tt.expected+" ") {
+			t.Errorf("Expected %s, got %s", tt.expected, line)
+		}
+	}
+}
+
+func TestAgentInfoMetric(t *testing.T) {
+	oldCommit, oldDate := commit, date
+	defer func() { commit, date = oldCommit, oldDate }()
+	commit, date = "abc1234", "2026-01-02T03:04:05Z"
+
+	m := agentInfoMetric()
+	if m.Name != agentInfoMetricName {
+		t.Errorf("Expected metric %s, got %s", agentInfoMetricName, m.Name)
+	}
+	line, err := m.ToLineProtocolSafe()
+	if err != nil {
+		t.Fatalf("Failed to serialize agent info: %v", err)
+	}
+	for _, field := range []string{`build_date="2026-01-02T03:04:05Z"`, `commit="abc1234"`, `version="dev"`, `go_version="` + runtime.Version() + `"`} {
+		if !strings.Contains(line, field) {
+			t.Errorf("Expected %s in %s", field, line)
+		}
+	}
+
+	expected := "metrics-agent dev (commit abc1234, built 2026-01-02T03:04:05Z, " + runtime.Version()
+	if s := VersionString(); !strings.HasPrefix(s, expected) {
+		t.Errorf("Expected version string starting with %q, got %q", expected, s)
+	}
+}
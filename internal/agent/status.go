@@ -0,0 +1,432 @@
+// Package agent runs the metric collection modules of the metrics-agent.
+//
+// This file contains the status of the agent and its modules, reported by
+// the "status" command, the HTTP endpoints and the status files.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/modules"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// defaultStatusFileInterval is how often the status file is written
+const defaultStatusFileInterval = 30 * time.Second
+
+// testMetricName is the name of the synthetic metric sent by the emit-test command
+const testMetricName = "agent_test"
+
+// logStatus logs the state of all modules to stderr.
+func (mm *ModuleManager) logStatus() {
+	goroutines, err := utils.GoroutinesByLabel(moduleLabel)
+	if err != nil {
+		utils.Debugf("Failed to count goroutines per module: %v", err)
+	}
+
+	health := mm.moduleHealth()
+	calls := mm.callSummaries()
+
+	mm.stateMu.Lock()
+	defer mm.stateMu.Unlock()
+
+	names := make([]string, 0, len(mm.moduleStates))
+	for name := range mm.moduleStates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	utils.Infof("Status: version=%s uptime=%s collection_trigger=%s modules=%d goroutines=%d",
+		version, time.Since(mm.startTime).Truncate(time.Second), mm.triggerMode, len(names), runtime.NumGoroutine())
+	for _, name := range names {
+		state := mm.moduleStates[name]
+		if mm.isPaused(name) {
+			state += " (paused)"
+		}
+		if err, checked := health[name]; checked && err != nil {
+			state += fmt.Sprintf(" (unhealthy: %v)", err)
+		} else if checked {
+			state += " (healthy)"
+		}
+		if summary, ok := calls[name]; ok {
+			state += " " + summary
+		}
+		if probe, ok := mm.probeResults[name]; ok {
+			utils.Infof("Status: [%s] %s goroutines=%d (probe: %s)", name, state, goroutines[name], probe)
+		} else {
+			utils.Infof("Status: [%s] %s goroutines=%d", name, state, goroutines[name])
+		}
+		if restarts := mm.restarts.Get(name); len(restarts) > 0 {
+			last := restarts[len(restarts)-1]
+			utils.Infof("Status: [%s] %d recorded restarts, last at %s after %s: %s", name, len(restarts),
+				last.Time.Format(time.RFC3339), last.Uptime.Truncate(time.Second), last.Reason)
+		}
+	}
+
+	outputs := mm.outputStats()
+	outputNames := make([]string, 0, len(outputs))
+	for name := range outputs {
+		outputNames = append(outputNames, name)
+	}
+	sort.Strings(outputNames)
+	for _, name := range outputNames {
+		stats := outputs[name]
+		lastSuccess := "never"
+		if !stats.LastSuccess.IsZero() {
+			lastSuccess = stats.LastSuccess.Format(time.RFC3339)
+		}
+		if stats.Shadow {
+			name += " (shadow)"
+		}
+		utils.Infof("Status: output %s queued=%d dropped=%d failures=%d flush_duration=%s last_success=%s",
+			name, stats.Queued, stats.Dropped, stats.Failures, stats.FlushDuration, lastSuccess)
+	}
+
+	stats := mm.pipelineStats()
+	if len(stats) == 0 {
+		return
+	}
+	counters := make([]string, 0, len(stats))
+	for name, value := range stats {
+		counters = append(counters, fmt.Sprintf("%s=%d", name, value))
+	}
+	sort.Strings(counters)
+	utils.Infof("Status: pipeline %s", strings.Join(counters, " "))
+}
+
+// pipelineStats returns the counters of the pipeline processors, the number
+// of dropped NaN and infinite values and of metrics exceeding the line limits.
+func (mm *ModuleManager) pipelineStats() map[string]int64 {
+	mm.configMu.RLock()
+	stats := mm.pipeline.Stats()
+	mm.configMu.RUnlock()
+	if dropped := metrics.NonFiniteDropped(); dropped > 0 {
+		stats["non_finite_dropped"] = dropped
+	}
+	if metricCh := mm.channel(); metricCh != nil {
+		truncated, dropped := metricCh.LimitStats()
+		if truncated > 0 {
+			stats["line_limit_truncated"] = truncated
+		}
+		if dropped > 0 {
+			stats["line_limit_dropped"] = dropped
+		}
+	}
+	return stats
+}
+
+// moduleStatus is the status of a module served by the /status endpoint.
+type moduleStatus struct {
+	State      string `json:"state"`
+	Paused     bool   `json:"paused,omitempty"`
+	Healthy    *bool  `json:"healthy,omitempty"`
+	Health     string `json:"health,omitempty"`
+	Probe      string `json:"probe,omitempty"`
+	Goroutines int    `json:"goroutines"`
+
+	LastMetric *time.Time `json:"last_metric,omitempty"`
+
+	Calls    *callStatus     `json:"calls,omitempty"`
+	Restarts []restartStatus `json:"restarts,omitempty"`
+}
+
+// callStatus is the number of upstream calls of a module served by the /status endpoint.
+type callStatus struct {
+	Window string `json:"window"`
+	Total  int    `json:"total"`
+	Failed int    `json:"failed"`
+}
+
+// restartStatus is a recorded restart of a module served by the /status endpoint.
+type restartStatus struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+	Uptime string    `json:"uptime"`
+	RunID  string    `json:"run_id,omitempty"`
+}
+
+// agentStatus is the status of the agent served by the /status endpoint.
+type agentStatus struct {
+	Version           string                  `json:"version"`
+	Commit            string                  `json:"commit,omitempty"`
+	BuildDate         string                  `json:"build_date,omitempty"`
+	GoVersion         string                  `json:"go_version"`
+	AgentID           string                  `json:"agent_id,omitempty"`
+	RunID             string                  `json:"run_id"`
+	Uptime            string                  `json:"uptime"`
+	CollectionTrigger string                  `json:"collection_trigger"`
+	Goroutines        int                     `json:"goroutines"`
+	UpdateAvailable   string                  `json:"update_available,omitempty"`
+	Modules           map[string]moduleStatus `json:"modules"`
+	Pipeline          map[string]int64        `json:"pipeline,omitempty"`
+	Outputs           map[string]outputStatus `json:"outputs,omitempty"`
+}
+
+// outputStatus is the state of an output served by the /status endpoint.
+type outputStatus struct {
+	Queued        int        `json:"queued"`
+	Dropped       int64      `json:"dropped"`
+	Failures      int64      `json:"failures"`
+	FlushDuration string     `json:"flush_duration"`
+	LastSuccess   *time.Time `json:"last_success,omitempty"`
+	Shadow        bool       `json:"shadow,omitempty"`
+}
+
+// serveStatus serves the state of all modules as JSON, like the "status" command.
+func (mm *ModuleManager) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(mm.status()); err != nil {
+		utils.Debugf("Failed to write status: %v", err)
+	}
+}
+
+// status collects the state of the agent, its modules and outputs.
+func (mm *ModuleManager) status() agentStatus {
+	goroutines, err := utils.GoroutinesByLabel(moduleLabel)
+	if err != nil {
+		utils.Debugf("Failed to count goroutines per module: %v", err)
+	}
+	health := mm.moduleHealth()
+
+	revision, built := buildInfo()
+	status := agentStatus{
+		Version:           version,
+		Commit:            revision,
+		BuildDate:         built,
+		GoVersion:         runtime.Version(),
+		AgentID:           utils.AgentID(),
+		RunID:             utils.RunID(),
+		Uptime:            time.Since(mm.startTime).Truncate(time.Second).String(),
+		CollectionTrigger: mm.triggerMode,
+		Goroutines:        runtime.NumGoroutine(),
+		Modules:           make(map[string]moduleStatus),
+		Pipeline:          mm.pipelineStats(),
+		Outputs:           make(map[string]outputStatus),
+	}
+	for name, stats := range mm.outputStats() {
+		state := outputStatus{
+			Queued:        stats.Queued,
+			Dropped:       stats.Dropped,
+			Failures:      stats.Failures,
+			FlushDuration: stats.FlushDuration.String(),
+			Shadow:        stats.Shadow,
+		}
+		if !stats.LastSuccess.IsZero() {
+			state.LastSuccess = &stats.LastSuccess
+		}
+		status.Outputs[name] = state
+	}
+
+	mm.stateMu.Lock()
+	status.UpdateAvailable = mm.latest
+	for name, state := range mm.moduleStates {
+		module := moduleStatus{
+			State:      state,
+			Paused:     mm.isPaused(name),
+			Probe:      mm.probeResults[name],
+			Goroutines: goroutines[name],
+		}
+		window := mm.callWindow(name)
+		if counts := utils.ModuleCalls(name, window); counts.Calls > 0 {
+			module.Calls = &callStatus{Window: window.String(), Total: counts.Calls, Failed: counts.Failures}
+		}
+		for _, restart := range mm.restarts.Get(name) {
+			module.Restarts = append(module.Restarts, restartStatus{
+				Time:   restart.Time,
+				Reason: restart.Reason,
+				Uptime: restart.Uptime.Truncate(time.Second).String(),
+				RunID:  restart.RunID,
+			})
+		}
+		if err, checked := health[name]; checked {
+			healthy := err == nil
+			module.Healthy = &healthy
+			if err != nil {
+				module.Health = err.Error()
+			}
+		}
+		if last, ok := mm.lastMetric[name]; ok {
+			module.LastMetric = &last
+		}
+		status.Modules[name] = module
+	}
+	mm.stateMu.Unlock()
+	return status
+}
+
+// getStatusFile returns the configured path of the status file and how often
+// it is written. An empty path disables the status file.
+func (mm *ModuleManager) getStatusFile() (string, time.Duration) {
+	globalConfig := mm.currentConfig()
+	if globalConfig == nil || globalConfig.StatusFile == "" {
+		return "", 0
+	}
+	interval := defaultStatusFileInterval
+	if globalConfig.StatusFileInterval > 0 {
+		interval = globalConfig.StatusFileInterval.Duration()
+	}
+	return globalConfig.StatusFile, interval
+}
+
+// writeStatusFiles writes the status file right away and then every interval
+// until ctx is cancelled.
+func (mm *ModuleManager) writeStatusFiles(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := mm.writeStatusFile(path); err != nil {
+			utils.Warnf("Failed to write status file %s: %v", path, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// writeStatusFile writes the status of the agent as JSON to path. The file is
+// replaced atomically, so readers never see a partial status.
+func (mm *ModuleManager) writeStatusFile(path string) error {
+	data, err := json.MarshalIndent(mm.status(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, append(data, '\n'), 0644)
+}
+
+// serveModules serves the descriptions of all compiled-in modules as JSON,
+// like the "modules list" command.
+func serveModules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(modules.Global.DescribeAll()); err != nil {
+		utils.Debugf("Failed to write modules: %v", err)
+	}
+}
+
+// emitTestMetric sends a synthetic agent_test metric to the metric channel, so
+// it passes the pipeline and all outputs like a metric of a module. Its test_id
+// field identifies it in the database.
+func (mm *ModuleManager) emitTestMetric() (metrics.Metric, error) {
+	// Held while sending, so the channel isn't closed by a reload meanwhile
+	mm.metricMu.RLock()
+	defer mm.metricMu.RUnlock()
+	if mm.metricCh == nil {
+		return metrics.Metric{}, errors.New("metric channel is not running")
+	}
+	now := time.Now()
+	metric := metrics.Metric{
+		Name: testMetricName,
+		Tags: map[string]string{"source": "emit-test"},
+		Fields: map[string]interface{}{
+			"value":   1,
+			"test_id": strconv.FormatInt(now.UnixNano(), 36),
+		},
+		Timestamp: now,
+	}
+	select {
+	case mm.metricCh.Get() <- metric:
+		return metric, nil
+	default:
+		return metrics.Metric{}, errors.New("metric channel is full")
+	}
+}
+
+// serveEmitTest sends a test metric on POST, like the "emit-test" command,
+// and responds with its test_id.
+func (mm *ModuleManager) serveEmitTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	metric, err := mm.emitTestMetric()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	utils.Infof("Sent test metric %s with test_id=%s through the pipeline and outputs", metric.Name, metric.Fields["test_id"])
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	response := map[string]interface{}{"name": metric.Name, "test_id": metric.Fields["test_id"]}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		utils.Debugf("Failed to write test metric response: %v", err)
+	}
+}
+
+// logRecent logs the recent metrics of a module, or of all modules if moduleName
+// is empty, in line protocol to stderr.
+func (mm *ModuleManager) logRecent(moduleName string) {
+	if mm.recent == nil {
+		utils.Warnf("Recent metrics are disabled (recent_metrics is negative)")
+		return
+	}
+
+	names := []string{moduleName}
+	if moduleName == "" {
+		names = mm.recent.Modules()
+	}
+	for _, name := range names {
+		recent := mm.recent.Get(name)
+		if len(recent) == 0 {
+			utils.Infof("Recent: [%s] no metrics", name)
+			continue
+		}
+		for _, m := range recent {
+			line, err := m.ToLineProtocolSafe()
+			if err != nil {
+				line = fmt.Sprintf("%s (%v)", m.Name, err)
+			}
+			utils.Infof("Recent: [%s] %s", name, line)
+		}
+	}
+}
+
+// logRestarts logs the recorded restarts of a module, or of all modules if
+// moduleName is empty, to stderr.
+func (mm *ModuleManager) logRestarts(moduleName string) {
+	if mm.restarts == nil {
+		utils.Warnf("Restart history is disabled (restart_history is negative or storage is unavailable)")
+		return
+	}
+
+	names := []string{moduleName}
+	if moduleName == "" {
+		names = mm.restarts.Modules()
+	}
+	for _, name := range names {
+		restarts := mm.restarts.Get(name)
+		if len(restarts) == 0 {
+			utils.Infof("Restarts: [%s] no restarts", name)
+			continue
+		}
+		for _, restart := range restarts {
+			run := ""
+			if restart.RunID != "" {
+				run = " in run " + restart.RunID
+			}
+			utils.Infof("Restarts: [%s] %s after %s%s: %s", name, restart.Time.Format(time.RFC3339),
+				restart.Uptime.Truncate(time.Second), run, restart.Reason)
+		}
+	}
+}
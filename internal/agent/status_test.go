@@ -0,0 +1,169 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/metricchannel"
+	"github.com/janhuddel/metrics-agent/internal/modules"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestServeStatus(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{})
+	mm.setModuleState("demo", "running")
+	mm.handleCommand("pause demo")
+
+	rec := httptest.NewRecorder()
+	mm.serveStatus(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var status agentStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to parse status: %v", err)
+	}
+	if status.Version != version {
+		t.Errorf("Expected version %s, got %s", version, status.Version)
+	}
+	demo, ok := status.Modules["demo"]
+	if !ok || demo.State != "running" || !demo.Paused {
+		t.Errorf("Expected paused running demo module, got %+v", status.Modules)
+	}
+
+	rec = httptest.NewRecorder()
+	mm.serveStatus(rec, httptest.NewRequest(http.MethodPost, "/status", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for POST, got %d", rec.Code)
+	}
+}
+
+func TestWriteStatusFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status", "agent.json")
+	mm := NewModuleManager(&config.GlobalConfig{StatusFile: path})
+	mm.setModuleState("demo", "running")
+	last := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	mm.setLastMetric("demo", last)
+
+	if got, interval := mm.getStatusFile(); got != path || interval != defaultStatusFileInterval {
+		t.Errorf("Expected %s every %v, got %s every %v", path, defaultStatusFileInterval, got, interval)
+	}
+	if err := mm.writeStatusFile(path); err != nil {
+		t.Fatalf("Failed to write status file: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read status file: %v", err)
+	}
+	var status agentStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		t.Fatalf("Failed to parse status file: %v", err)
+	}
+	demo := status.Modules["demo"]
+	if demo.State != "running" || demo.LastMetric == nil || !demo.LastMetric.Equal(last) {
+		t.Errorf("Expected running demo module with last metric at %v, got %+v", last, demo)
+	}
+}
+
+func TestServeModules(t *testing.T) {
+	rec := httptest.NewRecorder()
+	serveModules(rec, httptest.NewRequest(http.MethodGet, "/modules", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	var infos []modules.ModuleInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &infos); err != nil {
+		t.Fatalf("Failed to parse modules: %v", err)
+	}
+	found := false
+	for _, info := range infos {
+		if info.Name == "tasmota" {
+			found = info.Description != "" && info.Probe
+		}
+	}
+	if !found {
+		t.Errorf("Expected tasmota with description and probe, got %+v", infos)
+	}
+}
+
+func TestEmitTestMetric(t *testing.T) {
+	mm := NewModuleManager(&config.GlobalConfig{})
+	if _, err := mm.emitTestMetric(); err == nil {
+		t.Error("Expected error without metric channel")
+	}
+
+	mm.metricCh = metricchannel.New(10)
+	mm.handleCommand("emit-test")
+	select {
+	case m := <-mm.metricCh.Get():
+		if m.Name != testMetricName || m.Fields["test_id"] == "" {
+			t.Errorf("Expected test metric with test_id, got %+v", m)
+		}
+	default:
+		t.Fatal("Expected test metric in the metric channel")
+	}
+
+	rec := httptest.NewRecorder()
+	mm.serveEmitTest(rec, httptest.NewRequest(http.MethodPost, "/emit-test", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", rec.Code)
+	}
+	var response map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if m := <-mm.metricCh.Get(); m.Fields["test_id"] != response["test_id"] {
+		t.Errorf("Expected test_id %s in response, got %v", m.Fields["test_id"], response)
+	}
+
+	rec = httptest.NewRecorder()
+	mm.serveEmitTest(rec, httptest.NewRequest(http.MethodGet, "/emit-test", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for GET, got %d", rec.Code)
+	}
+}
+
+func TestRestartHistory(t *testing.T) {
+	modules.Global.Register("restart-test", func(ctx context.Context, ch chan<- metrics.Metric) error {
+		return fmt.Errorf("connection lost")
+	})
+	storage, err := utils.NewStorageWithConfig(&utils.StorageConfig{ModuleName: "restarts", PreferredDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	mm := NewModuleManager(&config.GlobalConfig{})
+	mm.metricCh = metricchannel.New(10)
+	mm.restarts = utils.NewRestartHistory(storage, 5)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	mm.runModule(context.Background(), &wg, "restart-test", 1)
+
+	restarts := mm.restarts.Get("restart-test")
+	if len(restarts) != 1 || restarts[0].Reason != "connection lost" {
+		t.Fatalf("Expected the failure to be recorded, got %+v", restarts)
+	}
+
+	var buf bytes.Buffer
+	utils.SetGlobalLogger(utils.NewLogger(utils.INFO, &buf))
+	defer utils.SetGlobalLogger(utils.NewLogger(utils.INFO, os.Stderr))
+	mm.handleCommand("restarts restart-test")
+	if !strings.Contains(buf.String(), "[restart-test]") || !strings.Contains(buf.String(), "connection lost") {
+		t.Errorf("Expected the restart to be logged, got %q", buf.String())
+	}
+}
@@ -0,0 +1,191 @@
+// Package agent runs the metric collection modules of the metrics-agent.
+//
+// This file contains counting, checking and installing updates of the agent.
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/update"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// updateStorage is the storage the state of an installed update is kept in
+const updateStorage = "update"
+
+// updateConfirmAfter is how long an installed update must run before it is
+// confirmed and the previous binary is removed
+const updateConfirmAfter = 5 * time.Minute
+
+// updateTimeout limits looking up and installing an update
+const updateTimeout = 10 * time.Minute
+
+// OpenUpdater creates the updater for the configured repository.
+func OpenUpdater(globalConfig *config.GlobalConfig) *update.Updater {
+	storage, err := utils.NewStorage(updateStorage)
+	if err != nil {
+		utils.Warnf("Failed to create storage, updates can't be installed: %v", err)
+		storage = nil
+	}
+	repository := config.DefaultUpdateRepository
+	if globalConfig != nil && globalConfig.Update.Repository != "" {
+		repository = globalConfig.Update.Repository
+	}
+	updater := update.New(repository, version, storage)
+	if globalConfig != nil && globalConfig.Update.PublicKey != "" {
+		if err := updater.SetPublicKey(globalConfig.Update.PublicKey); err != nil {
+			utils.Warnf("Updates can't be installed: %v", err)
+		}
+	}
+	return updater
+}
+
+// StartUpdate counts the start of an installed update and reports whether one
+// is running. An update that failed to start too often is rolled back and the
+// process exits, so the previous version is started.
+func StartUpdate(updater *update.Updater) bool {
+	exePath, err := executablePath()
+	if err != nil {
+		utils.Warnf("Failed to find the executable, updates can't be installed: %v", err)
+		return false
+	}
+	previous := updater.PreviousVersion()
+	pending, err := updater.Start(exePath)
+	if errors.Is(err, update.ErrRolledBack) {
+		utils.Fatalf("Update %s failed to start %d times, restored version %s, exiting to start it",
+			version, update.MaxStartAttempts, previous)
+	}
+	if err != nil {
+		utils.Errorf("%v", err)
+	}
+	if pending {
+		utils.Infof("Running update %s (previous version %s), confirmed after running for %v",
+			version, previous, updateConfirmAfter)
+	}
+	return pending
+}
+
+// executablePath returns the path of the running binary with symlinks resolved.
+func executablePath() (string, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(exePath)
+}
+
+// runUpdates confirms a running update once it has been running for
+// updateConfirmAfter, and looks up the latest release every check interval
+// until ctx is cancelled. The first check also waits updateConfirmAfter, so a
+// crashing agent doesn't query GitHub on every start.
+func (mm *ModuleManager) runUpdates(ctx context.Context) {
+	globalConfig := mm.currentConfig()
+	var interval time.Duration
+	if globalConfig != nil {
+		interval = globalConfig.Update.CheckInterval.Duration()
+	}
+	if interval > 0 && !update.IsRelease(version) {
+		utils.Infof("Not checking for updates of development version %s", version)
+		interval = 0
+	}
+	if interval <= 0 && !mm.updateReady {
+		return
+	}
+
+	utils.WithPanicRecoveryAndContinue("Update check", "main", func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(updateConfirmAfter):
+		}
+		if mm.updateReady {
+			mm.confirmUpdate()
+		}
+		if interval <= 0 {
+			return
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			mm.checkUpdate(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// confirmUpdate marks the running update as working.
+func (mm *ModuleManager) confirmUpdate() {
+	exePath, err := executablePath()
+	if err == nil {
+		err = mm.updater.Confirm(exePath)
+	}
+	if err != nil {
+		utils.Warnf("Failed to confirm update %s: %v", version, err)
+		return
+	}
+	utils.Infof("Update %s confirmed", version)
+}
+
+// checkUpdate looks up the latest release and, with auto_install, installs it
+// and stops the agent, so that telegraf or systemd start the new version.
+func (mm *ModuleManager) checkUpdate(ctx context.Context) {
+	globalConfig := mm.currentConfig()
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
+
+	latest, err := mm.updater.Latest(ctx)
+	if err != nil {
+		utils.Warnf("Failed to check for updates: %v", err)
+		return
+	}
+	if !update.Newer(latest, version) {
+		utils.Debugf("No update available, latest release is %s", latest)
+		return
+	}
+	mm.stateMu.Lock()
+	mm.latest = latest
+	mm.stateMu.Unlock()
+
+	if !globalConfig.Update.AutoInstall {
+		utils.Infof("Update available: %s (running %s)", latest, version)
+		return
+	}
+	if mm.updater.Failed(latest) {
+		utils.Warnf("Update %s is available, but failed to start before and is not installed again", latest)
+		return
+	}
+	if globalConfig.Update.PublicKey == "" {
+		utils.Warnf("Update %s is available, but auto_install requires a public_key to verify releases with", latest)
+		return
+	}
+
+	exePath, err := executablePath()
+	if err != nil {
+		utils.Errorf("Failed to find the executable to update: %v", err)
+		return
+	}
+	utils.Infof("Installing update %s over %s", latest, exePath)
+	if err := mm.updater.Install(ctx, latest, exePath); err != nil {
+		utils.Errorf("Failed to install update %s: %v", latest, err)
+		return
+	}
+
+	utils.Infof("Installed update %s, stopping to start it", latest)
+	mm.writeAgentStatus(true, "update_installed")
+	select {
+	case mm.signalCh <- syscall.SIGTERM:
+	default:
+		utils.Warnf("Shutdown already pending, update %s is started on the next start", latest)
+	}
+}
@@ -10,13 +10,17 @@
 package config
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/janhuddel/metrics-agent/internal/utils"
@@ -37,6 +41,17 @@ type BaseConfig struct {
 	// Custom contains module-specific configuration settings.
 	// The structure depends on the individual module's requirements.
 	Custom map[string]interface{} `json:"custom,omitempty"`
+
+	// Instance is the name of the instance this configuration was loaded for.
+	// It is empty when the module runs without instances.
+	Instance string `json:"-"`
+}
+
+// InstanceName returns the module name scoped to the configured instance
+// (e.g. "netatmo.haus1"). Modules use it for storage files and client IDs
+// so that instances don't share state.
+func (bc *BaseConfig) InstanceName(moduleName string) string {
+	return InstanceName(moduleName, bc.Instance)
 }
 
 // GetFriendlyName returns the friendly name for a device, checking for overrides first.
@@ -59,13 +74,254 @@ type ModuleConfig struct {
 	// Defaults to false (disabled) for security - modules must be explicitly enabled.
 	Enabled bool `json:"enabled,omitempty"`
 
+	// Schedule restricts the collections of interval-based modules to daily time
+	// windows, optionally with a different interval per window.
+	// If not set, the module collects around the clock.
+	Schedule []ScheduleWindow `json:"schedule,omitempty"`
+
+	// StartupJitter delays the start of the module by a random duration up to
+	// this value (e.g. "30s"), so not all modules poll and connect at once when
+	// the agent starts. Instances are delayed independently.
+	StartupJitter Duration `json:"startup_jitter,omitempty"`
+
+	// SkipInitialCollection makes interval-based modules wait for the first
+	// interval instead of collecting right after starting, so a restart doesn't
+	// emit duplicates of the last collection.
+	SkipInitialCollection bool `json:"skip_initial_collection,omitempty"`
+
+	// Retry retries failed collections of interval-based HTTP modules within
+	// their interval, so a transient network error doesn't lose the sample.
+	// If not set, a failed collection waits for the next interval.
+	// Instances use the setting of their module.
+	Retry *RetryConfig `json:"retry,omitempty"`
+
+	// RenameFields maps field names of the module's metrics to new names
+	// (e.g. {"sum_power_today": "energy_today"}). Instances use the mapping of their module.
+	RenameFields map[string]string `json:"rename_fields,omitempty"`
+
+	// AlignTimestamps truncates the timestamps of the module's metrics to
+	// multiples of this interval (e.g. "10s"), so series of different modules
+	// can be joined on their timestamps. Instances use the interval of their module.
+	AlignTimestamps Duration `json:"align_timestamps,omitempty"`
+
+	// Devices restricts the metrics of the module to some devices, e.g. to ignore
+	// devices of neighbors on a shared MQTT broker. Instances use the lists of their module.
+	Devices *DeviceFilter `json:"devices,omitempty"`
+
+	// StateMappings converts string states of the module's fields into numbers,
+	// keyed by field name (after renaming). Instances use the mappings of their module.
+	StateMappings map[string]StateMapping `json:"state_mappings,omitempty"`
+
+	// Attributes decides per device attribute (e.g. "model" or "firmware") whether
+	// it is written as tag, as field or dropped, keyed by tag or field name.
+	// Instances use the settings of their module.
+	Attributes map[string]AttributeMapping `json:"attributes,omitempty"`
+
+	// MaxConcurrency limits the goroutines the module runs concurrently to emit
+	// metrics. If not set, the global module_concurrency is used; negative values
+	// disable the limit. Instances are limited independently.
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+
+	// BufferSize is the number of metrics the module can send ahead of the
+	// agent processing them. If not set, the global module_buffer is used;
+	// negative values make the channel unbuffered. Instances use the setting of their module.
+	BufferSize int `json:"buffer_size,omitempty"`
+
+	// ErrorBudget overrides the global error budget for this module.
+	// Instances use the budget of their module.
+	ErrorBudget *ErrorBudgetConfig `json:"error_budget,omitempty"`
+
+	// MissingValues overrides the global missing_values for this module.
+	// Instances use the setting of their module.
+	MissingValues string `json:"missing_values,omitempty"`
+
+	// NonFiniteValues overrides the global non_finite_values for this module.
+	// Instances use the setting of their module.
+	NonFiniteValues string `json:"non_finite_values,omitempty"`
+
+	// StopTimeout overrides the global stop_timeout for this module.
+	// Instances use the setting of their module.
+	StopTimeout Duration `json:"stop_timeout,omitempty"`
+
 	// BaseConfig provides common functionality for device name overrides and custom settings.
 	BaseConfig `json:",inline"`
+
+	// Instances contains named sub-configurations of the module.
+	// If set, one instance of the module runs per entry instead of a single module.
+	Instances map[string]InstanceConfig `json:"instances,omitempty"`
+}
+
+// RetryConfig configures the retries of failed collections.
+type RetryConfig struct {
+	// Attempts is the maximum number of retries after a failed collection.
+	Attempts int `json:"attempts"`
+
+	// Backoff is the delay before the first retry (e.g. "5s"), doubled for each
+	// further retry. Defaults to 5 seconds. Retries that would start after the
+	// next collection is due are skipped.
+	Backoff Duration `json:"backoff,omitempty"`
+}
+
+// DeviceFilter selects devices by their "device" tag. Entries may contain
+// wildcards as supported by path.Match (e.g. "tasmota_6886*").
+type DeviceFilter struct {
+	// Include keeps only metrics of matching devices. If empty, all devices are included.
+	Include []string `json:"include,omitempty"`
+
+	// Exclude drops metrics of matching devices, even if they are included.
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// StateMapping maps the enumerated string states of a field (e.g. "charging",
+// "idle") to numbers, so they can be graphed and aggregated.
+type StateMapping struct {
+	// Values maps states to numbers, e.g. {"charging": 1, "idle": 0}.
+	// States are matched case-insensitively.
+	Values map[string]int `json:"values"`
+
+	// Default is used for states not in Values. If not set, the field is
+	// dropped for unknown states.
+	Default *int `json:"default,omitempty"`
+
+	// Tag adds the original state as tag with this name (e.g. "state"), so the
+	// readable state is kept next to the number.
+	Tag string `json:"tag,omitempty"`
+}
+
+// BinaryStates maps the states of switches and binary sensors ("ON"/"OFF") to 1 and 0.
+var BinaryStates = StateMapping{Values: map[string]int{"on": 1, "off": 0}}
+
+// Lookup returns the number of a state, or the default for unknown states.
+// It returns false if the state is unknown and no default is configured.
+func (sm StateMapping) Lookup(state string) (int, bool) {
+	state = strings.TrimSpace(state)
+	for key, value := range sm.Values {
+		if strings.EqualFold(key, state) {
+			return value, true
+		}
+	}
+	if sm.Default != nil {
+		return *sm.Default, true
+	}
+	return 0, false
+}
+
+// OAuth2PagesConfig configures the pages shown in the browser during the
+// OAuth2 authorization flow.
+type OAuth2PagesConfig struct {
+	// Language of the built-in pages: "en" (default) or "de".
+	Language string `json:"language,omitempty"`
+
+	// LandingPage is the path of an HTML template replacing the page linking
+	// to the authorization page of the provider, which it gets as {{.AuthURL}}.
+	LandingPage string `json:"landing_page,omitempty"`
+
+	// SuccessPage is the path of an HTML template replacing the page shown
+	// after the authorization.
+	SuccessPage string `json:"success_page,omitempty"`
+}
+
+// LineLimitsConfig restricts the size of the written Line Protocol lines.
+type LineLimitsConfig struct {
+	// MaxLineLength is the maximum length of a line in bytes. Defaults to
+	// 65536, the longest line telegraf's execd input reads; negative values
+	// disable the limit.
+	MaxLineLength int `json:"max_line_length,omitempty"`
+
+	// MaxFields is the maximum number of fields of a metric (0 for no limit).
+	MaxFields int `json:"max_fields,omitempty"`
+
+	// MaxStringLength is the maximum length of a string field value in bytes
+	// (0 for no limit).
+	MaxStringLength int `json:"max_string_length,omitempty"`
+
+	// Action decides what happens to a metric exceeding a limit: "truncate"
+	// (default) shortens the strings and leaves out surplus fields, "drop"
+	// drops the metric.
+	Action string `json:"action,omitempty"`
+}
+
+// ErrorBudgetConfig sets how many upstream calls of a module (HTTP requests
+// and connection attempts) may fail before the module is reported unhealthy.
+type ErrorBudgetConfig struct {
+	// Window is the rolling window the calls are counted in (e.g. "1h").
+	// Defaults to 1 hour; longer windows than 24 hours are shortened.
+	Window Duration `json:"window,omitempty"`
+
+	// MaxFailureRatio is the share of failed calls (0 to 1) above which the
+	// module is unhealthy, e.g. 0.2 for 20%.
+	MaxFailureRatio float64 `json:"max_failure_ratio"`
+
+	// MinCalls is the number of calls in the window below which the budget is
+	// not evaluated, so a single failure after a restart doesn't count. Defaults to 10.
+	MinCalls int `json:"min_calls,omitempty"`
+
+	// Notify sends an "error_budget_exceeded" notification when the module
+	// exceeds its budget (see NotifyConfig).
+	Notify bool `json:"notify,omitempty"`
+}
+
+// Identity tags
+const (
+	// IdentityAgentID is the tag of the agent ID, which stays the same across restarts.
+	IdentityAgentID = "agent_id"
+
+	// IdentityRunID is the tag of the run ID, which changes on every start.
+	IdentityRunID = "run_id"
+)
+
+// IdentityConfig sets where the agent ID and the run ID are added, so logs and
+// metrics of agents in a fleet can be correlated across crashes and restarts.
+type IdentityConfig struct {
+	// Tags adds the IDs as tags to every metric, e.g. ["agent_id"]. A run_id
+	// tag starts new series on every start of the agent.
+	Tags []string `json:"tags,omitempty"`
+
+	// Logs adds the IDs to every log line.
+	Logs bool `json:"logs,omitempty"`
+}
+
+// Attribute handling modes
+const (
+	// AttributeTag writes the attribute as tag.
+	AttributeTag = "tag"
+
+	// AttributeField writes the attribute as string field, so changes such as
+	// firmware updates don't start new series.
+	AttributeField = "field"
+
+	// AttributeDrop removes the attribute.
+	AttributeDrop = "drop"
+)
+
+// AttributeMapping decides how a device attribute is written.
+type AttributeMapping struct {
+	// As is "tag", "field" or "drop".
+	As string `json:"as"`
+
+	// Every writes a field attribute at most once per interval and series
+	// (e.g. "24h"), and whenever its value changes. If not set, the field is
+	// written with every metric.
+	Every Duration `json:"every,omitempty"`
+}
+
+// InstanceConfig represents the configuration of a named module instance.
+// Friendly name overrides and custom settings are merged over the module's settings.
+type InstanceConfig struct {
+	// Tags are added to every metric produced by the instance (e.g. {"home": "haus1"}).
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// BaseConfig provides instance-specific device name overrides and custom settings.
+	BaseConfig `json:",inline"`
 }
 
 // GlobalConfig represents the global configuration file structure.
 // It contains system-wide settings and module-specific configurations.
 type GlobalConfig struct {
+	// ConfigVersion is the layout version of the configuration file.
+	// Older layouts are migrated to CurrentConfigVersion when loading.
+	ConfigVersion int `json:"config_version,omitempty"`
+
 	// LogLevel sets the global logging level for the application.
 	// Valid values: "debug", "info", "warn", "error"
 	LogLevel string `json:"log_level,omitempty"`
@@ -77,9 +333,268 @@ type GlobalConfig struct {
 	// - negative values: fall back to default (3)
 	ModuleRestartLimit int `json:"module_restart_limit,omitempty"`
 
+	// CollectionTrigger controls when interval-based modules collect metrics.
+	// - "interval": default, each module collects on its own timer
+	// - "signal": collect when SIGUSR1 is received (telegraf execd signal = "SIGUSR1")
+	// - "stdin": collect when a line is read from stdin (telegraf execd signal = "STDIN")
+	CollectionTrigger string `json:"collection_trigger,omitempty"`
+
+	// GCPercent sets the garbage collection target percentage (like GOGC).
+	// If not set, 50 is used on systems with up to 1 GiB of memory and 100 otherwise.
+	GCPercent *int `json:"gc_percent,omitempty"`
+
+	// MemoryLimit sets a soft memory limit for the Go runtime (like GOMEMLIMIT), e.g. "64MiB".
+	// If not set, 10% of the system memory (at least 32 MiB) is used on systems with
+	// up to 1 GiB of memory and no limit otherwise.
+	MemoryLimit string `json:"memory_limit,omitempty"`
+
+	// Timezone is the IANA timezone (e.g. "Europe/Berlin") day boundaries are
+	// computed in, e.g. of daily totals, the daily yield of inverters and
+	// collection schedules. Defaults to the system timezone.
+	Timezone string `json:"timezone,omitempty"`
+
+	// OAuth2Pages configures the pages shown in the browser while a module
+	// is authorized with OAuth2.
+	OAuth2Pages OAuth2PagesConfig `json:"oauth2_pages,omitempty"`
+
+	// SelfMetricsInterval controls how often an "agent_module" metric with the
+	// goroutine count of each running module is sent (e.g. "1m").
+	// If not set, no self-metrics are sent.
+	SelfMetricsInterval Duration `json:"self_metrics_interval,omitempty"`
+
+	// DeviceInventoryInterval controls how often a "device_inventory" metric with
+	// the metadata of each known device is sent (e.g. "1h").
+	// If not set, no inventory is sent.
+	DeviceInventoryInterval Duration `json:"device_inventory_interval,omitempty"`
+
+	// HeartbeatInterval controls how often a "module_up" metric is sent for each
+	// running module (e.g. "1m"), so that alerts can detect a dead module by the
+	// absence of the series. If not set, no heartbeats are sent.
+	HeartbeatInterval Duration `json:"heartbeat_interval,omitempty"`
+
+	// ConfigSummaryMetric writes an "agent_config" metric with the running
+	// modules, outputs and pipeline processors whenever the modules are
+	// (re)started. The summary is always logged.
+	ConfigSummaryMetric bool `json:"config_summary_metric,omitempty"`
+
+	// WatchConfig reloads the modules automatically when the configuration
+	// file changes, like on SIGHUP. Invalid changes are logged and not applied.
+	WatchConfig bool `json:"watch_config,omitempty"`
+
+	// WatchConfigDebounce is how long the configuration file must stay unchanged
+	// after a change before it is applied (e.g. "5s"). Defaults to 2 seconds.
+	WatchConfigDebounce Duration `json:"watch_config_debounce,omitempty"`
+
+	// RecentMetrics is the number of metrics kept per module for the "recent"
+	// command. If not set, the last 10 metrics are kept; negative values disable it.
+	RecentMetrics int `json:"recent_metrics,omitempty"`
+
+	// RestartHistory is the number of restarts recorded per module with their
+	// reason, shown by the "status" and "restarts" commands. The history is kept
+	// across agent restarts. Defaults to 20; negative values disable it.
+	RestartHistory int `json:"restart_history,omitempty"`
+
+	// ModuleConcurrency limits the goroutines each module runs concurrently to
+	// emit metrics, so a misbehaving module cannot spawn unbounded work and
+	// starve the others. Defaults to 64; negative values disable the limit.
+	ModuleConcurrency int `json:"module_concurrency,omitempty"`
+
+	// SerializerShards is the number of goroutines that process and serialize
+	// metrics, so a slow export of one device's metrics doesn't delay the
+	// others. Defaults to 4; 1 processes all metrics in one goroutine.
+	SerializerShards int `json:"serializer_shards,omitempty"`
+
+	// MetricBuffer is the number of metrics the shared metric channel and each
+	// serializer shard buffer before the modules have to wait for the output.
+	// Defaults to 100; negative values make the channels unbuffered.
+	MetricBuffer int `json:"metric_buffer,omitempty"`
+
+	// ModuleBuffer is the number of metrics each module can send ahead of the
+	// agent processing them. Defaults to 100; negative values make the channels unbuffered.
+	ModuleBuffer int `json:"module_buffer,omitempty"`
+
+	// StopTimeout is how long each module may take to stop on shutdown or
+	// reload (e.g. "10s"). Modules are stopped in parallel, each with its own
+	// timeout, and modules that don't stop in time are logged. Defaults to 10 seconds.
+	StopTimeout Duration `json:"stop_timeout,omitempty"`
+
+	// ErrorBudget sets how many upstream calls of each module may fail before
+	// it is reported unhealthy. If not set, failed calls are only counted.
+	ErrorBudget *ErrorBudgetConfig `json:"error_budget,omitempty"`
+
+	// MissingValues decides how fields are written that a module knows but has
+	// no value for, e.g. a sensor that is absent: "omit" (default) leaves them
+	// out, "zero" writes them as 0, false or "".
+	MissingValues string `json:"missing_values,omitempty"`
+
+	// NonFiniteValues decides how NaN and infinite field values are handled,
+	// which Line Protocol can't represent: "drop_field" (default) drops the
+	// field, "drop_metric" drops the whole metric.
+	NonFiniteValues string `json:"non_finite_values,omitempty"`
+
+	// LineLimits restricts the size of the written Line Protocol lines, so a
+	// device with a pathological payload can't break the batches of telegraf.
+	LineLimits LineLimitsConfig `json:"line_limits,omitempty"`
+
+	// Identity adds the agent ID and run ID to logs and metrics.
+	Identity IdentityConfig `json:"identity,omitempty"`
+
+	// Update configures checking for and installing new releases of the agent.
+	Update UpdateConfig `json:"update,omitempty"`
+
+	// Pipeline configures the processors applied to all metrics before output.
+	Pipeline PipelineConfig `json:"pipeline,omitempty"`
+
+	// Storage configures how the persistent state of modules and processors is written.
+	Storage StorageConfig `json:"storage,omitempty"`
+
+	// Notify configures notifications about modules that keep failing.
+	Notify NotifyConfig `json:"notify,omitempty"`
+
+	// OTLP configures the export of all metrics to an OpenTelemetry receiver.
+	OTLP OTLPConfig `json:"otlp,omitempty"`
+
+	// Prometheus configures an HTTP endpoint exposing all metrics for scraping.
+	Prometheus PrometheusConfig `json:"prometheus,omitempty"`
+
+	// HTTP configures the HTTP server serving the status of the agent.
+	HTTP HTTPConfig `json:"http,omitempty"`
+
+	// StatusFile is the path of a file the status of the agent is written to
+	// as JSON, like served by the /status endpoint, e.g. for the textfile
+	// collector of node_exporter or the file input of telegraf.
+	// If not set, no status file is written.
+	StatusFile string `json:"status_file,omitempty"`
+
+	// StatusFileInterval is how often the status file is written (e.g. "1m").
+	// Defaults to 30 seconds.
+	StatusFileInterval Duration `json:"status_file_interval,omitempty"`
+
+	// AuditLog is the path of a file to which every outbound request and
+	// connection of the modules is appended as a JSON line.
+	// If not set, no audit log is written.
+	AuditLog string `json:"audit_log,omitempty"`
+
+	// AllowedDestinations restricts outbound connections to these host names
+	// ("api.netatmo.com", "*.tibber.com"), IP addresses and CIDR ranges.
+	// If not set, all destinations are allowed; an empty list blocks all of them.
+	AllowedDestinations []string `json:"allowed_destinations,omitempty"`
+
+	// DeviceRequestsPerSecond limits the HTTP requests to each device in the
+	// local network (e.g. Tasmota EnergyTotal fetches), shared by all modules
+	// and channels. Defaults to 2; negative values disable the limit.
+	DeviceRequestsPerSecond float64 `json:"device_requests_per_second,omitempty"`
+
 	// Modules contains configuration for each available module.
 	// Only modules with "enabled": true will be started.
 	Modules map[string]ModuleConfig `json:"modules,omitempty"`
+
+	// ModuleErrors contains the modules whose configuration section could not be parsed.
+	// A broken section does not prevent the other modules from being configured.
+	ModuleErrors map[string]error `json:"-"`
+
+	// Migrations describes the changes made to migrate an older configuration layout.
+	Migrations []string `json:"-"`
+}
+
+// StorageConfig configures the persistence of module and processor state.
+type StorageConfig struct {
+	// WriteDelay delays writing changes until no further change was made for
+	// this long (e.g. "10s"), to reduce writes to SD cards. Pending changes are
+	// written on shutdown. If not set, every change is written immediately.
+	WriteDelay Duration `json:"write_delay,omitempty"`
+
+	// MaxPendingWrites is the number of delayed changes after which the state
+	// is written even if it keeps changing. Defaults to 100.
+	MaxPendingWrites int `json:"max_pending_writes,omitempty"`
+
+	// StorageQuota limits the state file of every module and processor.
+	StorageQuota
+
+	// Quotas overrides the quota for single modules or instances by name,
+	// e.g. "tasmota" or "netatmo.haus1".
+	Quotas map[string]StorageQuota `json:"quotas,omitempty"`
+}
+
+// StorageQuota limits the state a module keeps, so a misbehaving module can't
+// fill the disk. Zero values disable a limit.
+type StorageQuota struct {
+	// MaxSize is the maximum size of the state file in bytes. The least
+	// recently used entries are removed to stay within it.
+	MaxSize int `json:"max_size,omitempty"`
+
+	// MaxAge removes entries that were not read or written for this long (e.g. "720h").
+	MaxAge Duration `json:"max_age,omitempty"`
+}
+
+// UnmarshalJSON parses the global configuration, decoding each module section
+// separately so that a broken section is recorded in ModuleErrors instead of
+// failing the whole configuration.
+func (gc *GlobalConfig) UnmarshalJSON(data []byte) error {
+	type globalConfigAlias GlobalConfig
+	aux := struct {
+		*globalConfigAlias
+		Modules map[string]json.RawMessage `json:"modules,omitempty"`
+	}{
+		globalConfigAlias: (*globalConfigAlias)(gc),
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	gc.Modules = nil
+	gc.ModuleErrors = nil
+	if aux.Modules == nil {
+		return nil
+	}
+
+	gc.Modules = make(map[string]ModuleConfig, len(aux.Modules))
+	for name, raw := range aux.Modules {
+		var moduleConfig ModuleConfig
+		err := json.Unmarshal(raw, &moduleConfig)
+		if err == nil {
+			_, err = ParseSchedule(moduleConfig.Schedule)
+		}
+		if err != nil {
+			if gc.ModuleErrors == nil {
+				gc.ModuleErrors = make(map[string]error)
+			}
+			gc.ModuleErrors[name] = &ModuleError{Module: name, Err: err}
+
+			// Keep the enabled flag if it can be read, so that disabled
+			// modules with a broken section are not reported as errors
+			var probe struct {
+				Enabled bool `json:"enabled"`
+			}
+			_ = json.Unmarshal(raw, &probe)
+			moduleConfig = ModuleConfig{Enabled: probe.Enabled}
+		}
+		gc.Modules[name] = moduleConfig
+	}
+	return nil
+}
+
+// ModuleError reports an invalid configuration of a single module.
+// The supervisor skips modules failing with a ModuleError instead of restarting them.
+type ModuleError struct {
+	Module string
+	Err    error
+}
+
+// Error implements the error interface.
+func (e *ModuleError) Error() string {
+	return fmt.Sprintf("invalid configuration of module %s: %v", e.Module, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ModuleError) Unwrap() error {
+	return e.Err
+}
+
+// IsModuleError reports whether err is or wraps a ModuleError.
+func IsModuleError(err error) bool {
+	var moduleErr *ModuleError
+	return errors.As(err, &moduleErr)
 }
 
 // Loader handles loading configuration from JSON files for specific modules.
@@ -87,6 +602,7 @@ type GlobalConfig struct {
 type Loader struct {
 	configPath string
 	moduleName string
+	instance   string
 }
 
 // NewLoader creates a new configuration loader for a specific module.
@@ -106,21 +622,47 @@ func NewLoaderWithPath(moduleName string, configPath string) *Loader {
 	}
 }
 
+// NewLoaderFromContext creates a loader for the module carried by the context
+// (see utils.WithModule), scoped to its instance and reading GlobalConfigPath
+// if it is set.
+func NewLoaderFromContext(ctx context.Context) (*Loader, error) {
+	moduleName, instance := SplitInstanceName(utils.ModuleFromContext(ctx))
+	if moduleName == "" {
+		return nil, fmt.Errorf("no module in context")
+	}
+	loader := NewLoader(moduleName)
+	loader.SetInstance(instance)
+	if GlobalConfigPath != "" {
+		loader.SetConfigPath(GlobalConfigPath)
+	}
+	return loader, nil
+}
+
 // SetConfigPath sets a specific configuration file path.
 // This overrides the automatic configuration file discovery.
 func (l *Loader) SetConfigPath(path string) {
 	l.configPath = path
 }
 
+// SetInstance selects a named module instance.
+// Its settings are applied on top of the module's settings when loading.
+func (l *Loader) SetInstance(instance string) {
+	l.instance = instance
+}
+
 // LoadConfig loads configuration for the module from JSON file.
 // It starts with the provided default configuration and merges in any
 // module-specific settings found in the configuration file.
+// An invalid module section or custom setting is returned as a *ModuleError.
 func (l *Loader) LoadConfig(defaultConfig interface{}) (interface{}, error) {
 	// Start with default configuration
 	config := l.cloneConfig(defaultConfig)
 
 	// Load from config file if available
 	if err := l.loadFromFile(config); err != nil {
+		if IsModuleError(err) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to load config from file: %w", err)
 	}
 
@@ -145,8 +687,11 @@ func (l *Loader) loadFromFile(config interface{}) error {
 		return err
 	}
 
-	var globalConfig GlobalConfig
-	if err := json.Unmarshal(data, &globalConfig); err != nil {
+	globalConfig, err := parseCachedGlobalConfig(data)
+	if err != nil {
+		return err
+	}
+	if err := globalConfig.ModuleErrors[l.moduleName]; err != nil {
 		return err
 	}
 
@@ -157,40 +702,82 @@ func (l *Loader) loadFromFile(config interface{}) error {
 	}
 
 	// Apply module config to the target config struct
-	return l.applyModuleConfig(config, moduleConfig)
+	if err := l.applyModuleConfig(config, moduleConfig.BaseConfig); err != nil {
+		return err
+	}
+
+	if l.instance == "" {
+		return nil
+	}
+
+	// Apply instance config on top of the module config
+	instanceConfig, exists := moduleConfig.Instances[l.instance]
+	if !exists {
+		return &ModuleError{Module: l.moduleName, Err: fmt.Errorf("instance %s not found", l.instance)}
+	}
+	return l.applyModuleConfig(config, instanceConfig.BaseConfig)
 }
 
-// applyModuleConfig applies module-specific configuration to the target config.
-func (l *Loader) applyModuleConfig(config interface{}, moduleConfig ModuleConfig) error {
+// applyModuleConfig applies module or instance configuration to the target config.
+// Friendly name overrides are merged with overrides that were already applied.
+func (l *Loader) applyModuleConfig(config interface{}, baseConfig BaseConfig) error {
 	// Use reflection to apply the module config to the target config struct
 	configValue := reflect.ValueOf(config).Elem()
-	_ = configValue.Type()
 
 	// Apply friendly name overrides if the target config has this field
 	if friendlyNameField := configValue.FieldByName("FriendlyNameOverrides"); friendlyNameField.IsValid() && friendlyNameField.CanSet() {
-		if moduleConfig.FriendlyNameOverrides != nil {
-			friendlyNameField.Set(reflect.ValueOf(moduleConfig.FriendlyNameOverrides))
+		if baseConfig.FriendlyNameOverrides != nil {
+			merged := make(map[string]string)
+			if existing, ok := friendlyNameField.Interface().(map[string]string); ok {
+				for deviceID, name := range existing {
+					merged[deviceID] = name
+				}
+			}
+			for deviceID, name := range baseConfig.FriendlyNameOverrides {
+				merged[deviceID] = name
+			}
+			friendlyNameField.Set(reflect.ValueOf(merged))
 		}
 	}
 
+	// Record the instance the config was loaded for
+	if instanceField := configValue.FieldByName("Instance"); instanceField.IsValid() && instanceField.CanSet() && instanceField.Kind() == reflect.String {
+		instanceField.SetString(l.instance)
+	}
+
 	// Apply custom settings to individual fields
-	if moduleConfig.Custom != nil {
-		l.applyCustomSettings(configValue, moduleConfig.Custom)
+	if baseConfig.Custom != nil {
+		if err := l.applyCustomSettings(configValue, baseConfig.Custom); err != nil {
+			return &ModuleError{Module: l.moduleName, Err: err}
+		}
 	}
 
 	return nil
 }
 
 // applyCustomSettings applies custom settings to the config struct fields.
-func (l *Loader) applyCustomSettings(configValue reflect.Value, custom map[string]interface{}) {
+// All settings are applied; the errors of settings that could not be converted
+// to the field type are joined and returned.
+func (l *Loader) applyCustomSettings(configValue reflect.Value, custom map[string]interface{}) error {
 	configType := configValue.Type()
+	var errs []error
 
 	for i := 0; i < configValue.NumField(); i++ {
 		field := configValue.Field(i)
 		fieldType := configType.Field(i)
 
-		// Skip unexported fields and embedded structs
-		if !field.CanSet() || fieldType.Anonymous {
+		// Skip unexported fields
+		if !field.CanSet() {
+			continue
+		}
+
+		// Settings of embedded option structs (e.g. MQTTOptions) are set at the top level
+		if fieldType.Anonymous {
+			if field.Kind() == reflect.Struct && fieldType.Type != reflect.TypeOf(BaseConfig{}) {
+				if err := l.applyCustomSettings(field, custom); err != nil {
+					errs = append(errs, err)
+				}
+			}
 			continue
 		}
 
@@ -205,52 +792,107 @@ func (l *Loader) applyCustomSettings(configValue reflect.Value, custom map[strin
 
 		// Apply custom setting if it exists
 		if customValue, exists := custom[jsonName]; exists {
-			l.setFieldValue(field, customValue)
+			if err := l.setFieldValue(field, customValue); err != nil {
+				errs = append(errs, fmt.Errorf("custom setting %s: %w", jsonName, err))
+			}
 		}
 	}
+	return errors.Join(errs...)
 }
 
 // setFieldValue sets a field value with type conversion.
-func (l *Loader) setFieldValue(field reflect.Value, value interface{}) {
+// It returns an error if the value cannot be converted to the field type.
+func (l *Loader) setFieldValue(field reflect.Value, value interface{}) error {
 	fieldType := field.Type()
 	valueType := reflect.TypeOf(value)
 
+	// A JSON null leaves the default in place
+	if valueType == nil {
+		return nil
+	}
+
 	// Direct assignment if types match
 	if valueType.AssignableTo(fieldType) {
 		field.Set(reflect.ValueOf(value))
-		return
+		return nil
+	}
+
+	// Types with their own JSON decoding (e.g. Duration) decode the value themselves
+	if reflect.PointerTo(fieldType).Implements(reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()) {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		target := reflect.New(fieldType)
+		if err := json.Unmarshal(data, target.Interface()); err != nil {
+			return err
+		}
+		field.Set(target.Elem())
+		return nil
 	}
 
 	// Handle string to duration conversion
 	if fieldType == reflect.TypeOf(time.Duration(0)) {
-		if str, ok := value.(string); ok {
-			if duration, err := time.ParseDuration(str); err == nil {
-				field.Set(reflect.ValueOf(duration))
-			}
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected duration string, got %v", value)
+		}
+		duration, err := time.ParseDuration(str)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(duration))
+		return nil
+	}
+
+	// Handle collections and numbers (e.g. []interface{} to []string, float64 to int)
+	// by round-tripping through JSON into the target type
+	if valueType != reflect.TypeOf("") {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
 		}
-		return
+		target := reflect.New(fieldType)
+		if err := json.Unmarshal(data, target.Interface()); err != nil {
+			return fmt.Errorf("cannot use %v as %s", value, fieldType)
+		}
+		field.Set(target.Elem())
+		return nil
 	}
 
 	// Handle string to other types
-	if valueType == reflect.TypeOf("") {
-		str := value.(string)
-		switch fieldType.Kind() {
-		case reflect.String:
-			field.SetString(str)
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			if intVal, err := strconv.ParseInt(str, 10, 64); err == nil {
-				field.SetInt(intVal)
-			}
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			if uintVal, err := strconv.ParseUint(str, 10, 64); err == nil {
-				field.SetUint(uintVal)
-			}
-		case reflect.Bool:
-			if boolVal, err := strconv.ParseBool(str); err == nil {
-				field.SetBool(boolVal)
-			}
+	str := value.(string)
+	switch fieldType.Kind() {
+	case reflect.String:
+		field.SetString(str)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		intVal, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return fmt.Errorf("cannot use %q as %s", str, fieldType)
+		}
+		field.SetInt(intVal)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		uintVal, err := strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			return fmt.Errorf("cannot use %q as %s", str, fieldType)
 		}
+		field.SetUint(uintVal)
+	case reflect.Float32, reflect.Float64:
+		floatVal, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return fmt.Errorf("cannot use %q as %s", str, fieldType)
+		}
+		field.SetFloat(floatVal)
+	case reflect.Bool:
+		boolVal, err := strconv.ParseBool(str)
+		if err != nil {
+			return fmt.Errorf("cannot use %q as %s", str, fieldType)
+		}
+		field.SetBool(boolVal)
+	default:
+		return fmt.Errorf("cannot use %q as %s", str, fieldType)
 	}
+	return nil
 }
 
 // getConfigPath determines the configuration file path to use.
@@ -346,11 +988,117 @@ func LoadGlobalConfigFromPath(configPath string) (*GlobalConfig, error) {
 		return nil, fmt.Errorf("failed to read configuration file %s: %w", configPath, err)
 	}
 
-	var globalConfig GlobalConfig
-	if err := json.Unmarshal(data, &globalConfig); err != nil {
+	globalConfig, err := parseGlobalConfig(data)
+	if err != nil {
 		return nil, fmt.Errorf("failed to parse configuration file %s: %w", configPath, err)
 	}
 
+	return globalConfig, nil
+}
+
+// resolvedConfig is a configuration file whose layout was migrated and whose
+// references between settings and secret references were resolved.
+type resolvedConfig struct {
+	data         []byte
+	migrations   []string
+	moduleErrors map[string]error
+}
+
+// lastResolved caches the last resolved configuration file by the checksum of
+// its content, so that the loaders of the modules don't query the secrets
+// providers again for every module.
+var lastResolved struct {
+	sync.Mutex
+	sum    [sha256.Size]byte
+	config *resolvedConfig
+}
+
+// parseGlobalConfig parses a configuration file, migrating older layouts to
+// CurrentConfigVersion and resolving references between settings and secret
+// references. The changes made by the migration are recorded in Migrations.
+// The secrets are resolved again on every call, so that reloading the
+// configuration picks up rotated secrets.
+func parseGlobalConfig(data []byte) (*GlobalConfig, error) {
+	resolved, err := resolveConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	lastResolved.Lock()
+	lastResolved.sum = sha256.Sum256(data)
+	lastResolved.config = resolved
+	lastResolved.Unlock()
+
+	return resolved.decode()
+}
+
+// parseCachedGlobalConfig parses a configuration file like parseGlobalConfig,
+// but reuses the secrets resolved by the last parse of the same content.
+func parseCachedGlobalConfig(data []byte) (*GlobalConfig, error) {
+	lastResolved.Lock()
+	resolved := lastResolved.config
+	if resolved != nil && lastResolved.sum != sha256.Sum256(data) {
+		resolved = nil
+	}
+	lastResolved.Unlock()
+
+	if resolved == nil {
+		return parseGlobalConfig(data)
+	}
+	return resolved.decode()
+}
+
+// resolveConfig migrates a configuration file and resolves its references
+// between settings and its secret references.
+func resolveConfig(data []byte) (*resolvedConfig, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		raw = make(map[string]interface{})
+	}
+
+	migrations, err := MigrateConfig(raw)
+	if err != nil {
+		return nil, err
+	}
+	refErrors, err := resolveRefs(raw)
+	if err != nil {
+		return nil, err
+	}
+	secretErrors, err := resolveSecrets(context.Background(), raw)
+	if err != nil {
+		return nil, err
+	}
+	for name, err := range refErrors {
+		if secretErrors == nil {
+			secretErrors = make(map[string]error)
+		}
+		secretErrors[name] = err
+	}
+	if migrated, err := json.Marshal(raw); err == nil {
+		data = migrated
+	}
+	return &resolvedConfig{data: data, migrations: migrations, moduleErrors: secretErrors}, nil
+}
+
+// decode returns a new GlobalConfig of the resolved configuration file.
+func (rc *resolvedConfig) decode() (*GlobalConfig, error) {
+	var globalConfig GlobalConfig
+	if err := json.Unmarshal(rc.data, &globalConfig); err != nil {
+		return nil, err
+	}
+	globalConfig.Migrations = rc.migrations
+
+	// Modules whose references or secrets can't be resolved are skipped like broken sections
+	for name, err := range rc.moduleErrors {
+		if globalConfig.ModuleErrors == nil {
+			globalConfig.ModuleErrors = make(map[string]error)
+		}
+		globalConfig.ModuleErrors[name] = err
+		globalConfig.Modules[name] = ModuleConfig{Enabled: true}
+	}
 	return &globalConfig, nil
 }
 
@@ -1,10 +1,16 @@
 package config
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
 )
 
 func TestModuleConfig_Enabled(t *testing.T) {
@@ -355,3 +361,497 @@ func TestModuleConfig_JSONSerialization(t *testing.T) {
 		})
 	}
 }
+
+func TestLoader_CustomSettingsConversion(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "config.json")
+	content := `{
+		"modules": {
+			"test": {
+				"custom": {
+					"ids": ["a", "b"],
+					"attempts": 10,
+					"interval": "5m",
+					"timeout": "30s",
+					"ratio": 2.5
+				}
+			}
+		}
+	}`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	type testConfig struct {
+		IDs      []string      `json:"ids"`
+		Attempts int           `json:"attempts"`
+		Interval time.Duration `json:"interval"`
+		Timeout  Duration      `json:"timeout"`
+		Ratio    float64       `json:"ratio"`
+	}
+
+	loaded, err := NewLoaderWithPath("test", configPath).LoadConfig(&testConfig{})
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	cfg := loaded.(*testConfig)
+
+	if len(cfg.IDs) != 2 || cfg.IDs[0] != "a" || cfg.IDs[1] != "b" {
+		t.Errorf("Expected IDs [a b], got %v", cfg.IDs)
+	}
+	if cfg.Attempts != 10 {
+		t.Errorf("Expected Attempts 10, got %d", cfg.Attempts)
+	}
+	if cfg.Interval != 5*time.Minute {
+		t.Errorf("Expected Interval 5m, got %v", cfg.Interval)
+	}
+	if cfg.Timeout.Duration() != 30*time.Second {
+		t.Errorf("Expected Timeout 30s, got %v", cfg.Timeout)
+	}
+	if cfg.Ratio != 2.5 {
+		t.Errorf("Expected Ratio 2.5, got %v", cfg.Ratio)
+	}
+}
+
+func TestLoader_EmbeddedOptions(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "config.json")
+	content := `{
+		"modules": {
+			"test": {
+				"custom": {
+					"broker": "tcp://broker:1883",
+					"qos": 2,
+					"max_in_flight": 5
+				}
+			}
+		}
+	}`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	type testConfig struct {
+		BaseConfig
+		MQTTOptions
+		Broker string `json:"broker"`
+	}
+
+	defaults := &testConfig{MQTTOptions: MQTTOptions{QoS: 1, CleanSession: true}}
+	loaded, err := NewLoaderWithPath("test", configPath).LoadConfig(defaults)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	cfg := loaded.(*testConfig)
+
+	if cfg.Broker != "tcp://broker:1883" || cfg.QoS != 2 || cfg.MaxInFlight != 5 {
+		t.Errorf("Unexpected config: %+v", cfg)
+	}
+	if !cfg.CleanSession {
+		t.Error("Expected unset clean_session to keep its default")
+	}
+}
+
+func TestMQTTOptions_Validate(t *testing.T) {
+	if err := (MQTTOptions{QoS: 1}).Validate(); err != nil {
+		t.Errorf("Expected valid options, got %v", err)
+	}
+	if err := (MQTTOptions{QoS: 3}).Validate(); err == nil {
+		t.Error("Expected error for qos 3")
+	}
+	if err := (MQTTOptions{MaxInFlight: -1}).Validate(); err == nil {
+		t.Error("Expected error for negative max_in_flight")
+	}
+}
+
+func TestLoader_Instances(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "config.json")
+	content := `{
+		"modules": {
+			"test": {
+				"enabled": true,
+				"friendly_name_overrides": {"d1": "Module D1", "d2": "Module D2"},
+				"custom": {"broker": "tcp://shared:1883", "user": "shared"},
+				"instances": {
+					"haus1": {
+						"tags": {"home": "haus1"},
+						"friendly_name_overrides": {"d2": "Haus1 D2"},
+						"custom": {"user": "haus1"}
+					},
+					"haus2": {
+						"tags": {"home": "haus2"},
+						"custom": {"broker": "tcp://haus2:1883"}
+					}
+				}
+			}
+		}
+	}`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	type testConfig struct {
+		BaseConfig
+		Broker string `json:"broker"`
+		User   string `json:"user"`
+	}
+
+	loader := NewLoaderWithPath("test", configPath)
+	loader.SetInstance("haus1")
+	loaded, err := loader.LoadConfig(&testConfig{})
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	cfg := loaded.(*testConfig)
+
+	if cfg.Broker != "tcp://shared:1883" || cfg.User != "haus1" {
+		t.Errorf("Expected instance settings merged over module settings, got broker=%s user=%s", cfg.Broker, cfg.User)
+	}
+	if cfg.FriendlyNameOverrides["d1"] != "Module D1" || cfg.FriendlyNameOverrides["d2"] != "Haus1 D2" {
+		t.Errorf("Expected merged friendly name overrides, got %v", cfg.FriendlyNameOverrides)
+	}
+	if cfg.Instance != "haus1" || cfg.InstanceName("test") != "test.haus1" {
+		t.Errorf("Expected instance haus1, got %q", cfg.Instance)
+	}
+
+	loader.SetInstance("missing")
+	if _, err := loader.LoadConfig(&testConfig{}); err == nil {
+		t.Error("Expected error for unknown instance")
+	}
+
+	// A loader from the context is scoped to the module and instance in it
+	if _, err := NewLoaderFromContext(context.Background()); err == nil {
+		t.Error("Expected error without module in context")
+	}
+	originalPath := GlobalConfigPath
+	GlobalConfigPath = configPath
+	defer func() { GlobalConfigPath = originalPath }()
+	loader, err = NewLoaderFromContext(utils.WithModule(context.Background(), "test.haus2"))
+	if err != nil {
+		t.Fatalf("Failed to create loader from context: %v", err)
+	}
+	loaded, err = loader.LoadConfig(&testConfig{})
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg := loaded.(*testConfig); cfg.Broker != "tcp://haus2:1883" || cfg.InstanceName("test") != "test.haus2" {
+		t.Errorf("Expected the settings of instance haus2, got broker=%s instance=%q", cfg.Broker, cfg.Instance)
+	}
+
+	globalConfig, err := LoadGlobalConfigFromPath(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load global config: %v", err)
+	}
+	names := globalConfig.Modules["test"].InstanceNames()
+	if len(names) != 2 || names[0] != "haus1" || names[1] != "haus2" {
+		t.Errorf("Expected instances [haus1 haus2], got %v", names)
+	}
+	if globalConfig.Modules["test"].Instances["haus2"].Tags["home"] != "haus2" {
+		t.Errorf("Expected instance tags to be loaded, got %v", globalConfig.Modules["test"].Instances["haus2"].Tags)
+	}
+}
+
+func TestSplitInstanceName(t *testing.T) {
+	if module, instance := SplitInstanceName("tasmota.haus1"); module != "tasmota" || instance != "haus1" {
+		t.Errorf("Expected tasmota/haus1, got %s/%s", module, instance)
+	}
+	if module, instance := SplitInstanceName("tasmota"); module != "tasmota" || instance != "" {
+		t.Errorf("Expected tasmota without instance, got %s/%s", module, instance)
+	}
+}
+
+func TestLoadGlobalConfig_ModuleErrors(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "config.json")
+	content := `{
+		"log_level": "debug",
+		"modules": {
+			"good": {"enabled": true, "schedule": [{"from": "06:00", "to": "23:00", "interval": "5m"}]},
+			"broken": {"enabled": true, "friendly_name_overrides": ["not", "a", "map"]},
+			"bad_schedule": {"enabled": true, "schedule": [{"from": "6 am", "to": "23:00"}]},
+			"disabled": {"enabled": false, "custom": "invalid"}
+		}
+	}`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	globalConfig, err := LoadGlobalConfigFromPath(configPath)
+	if err != nil {
+		t.Fatalf("Expected broken module section not to fail the global config, got %v", err)
+	}
+	if globalConfig.LogLevel != "debug" || !globalConfig.Modules["good"].Enabled {
+		t.Errorf("Expected valid settings to be loaded, got %+v", globalConfig)
+	}
+	if !IsModuleError(globalConfig.ModuleErrors["broken"]) || !IsModuleError(globalConfig.ModuleErrors["disabled"]) ||
+		!IsModuleError(globalConfig.ModuleErrors["bad_schedule"]) {
+		t.Errorf("Expected module errors for broken sections, got %v", globalConfig.ModuleErrors)
+	}
+	if _, exists := globalConfig.ModuleErrors["good"]; exists {
+		t.Error("Expected no module error for valid section")
+	}
+	if !globalConfig.Modules["broken"].Enabled || globalConfig.Modules["disabled"].Enabled {
+		t.Error("Expected enabled flag to be kept for broken sections")
+	}
+
+	_, err = NewLoaderWithPath("broken", configPath).LoadConfig(&struct{}{})
+	if !IsModuleError(err) {
+		t.Errorf("Expected loader to return module error, got %v", err)
+	}
+}
+
+func TestLoadGlobalConfig_Secrets(t *testing.T) {
+	t.Setenv("TEST_MQTT_PASSWORD", "mqtt-secret")
+	t.Setenv("TEST_HTTP_TOKEN", "http-secret")
+
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "config.json")
+	content := `{
+		"http": {"listen": ":9275", "auth": {"bearer_token": "${env:TEST_HTTP_TOKEN}"}},
+		"modules": {
+			"test": {"enabled": true, "custom": {"password": "${env:TEST_MQTT_PASSWORD}"}},
+			"missing": {"enabled": true, "custom": {"password": "${env:TEST_MISSING_SECRET}"}},
+			"disabled": {"enabled": false, "custom": {"password": "${env:TEST_MISSING_SECRET}"}}
+		}
+	}`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	globalConfig, err := LoadGlobalConfigFromPath(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if token := globalConfig.HTTP.Auth.BearerToken; token != "http-secret" {
+		t.Errorf("Expected global secret to be resolved, got %q", token)
+	}
+	if !IsModuleError(globalConfig.ModuleErrors["missing"]) || !globalConfig.Modules["missing"].Enabled {
+		t.Errorf("Expected module error for unresolvable secret, got %v", globalConfig.ModuleErrors)
+	}
+	if _, exists := globalConfig.ModuleErrors["disabled"]; exists {
+		t.Error("Expected secrets of disabled modules not to be resolved")
+	}
+
+	type testConfig struct {
+		BaseConfig
+		Password string `json:"password"`
+	}
+	loaded, err := NewLoaderWithPath("test", configPath).LoadConfig(&testConfig{})
+	if err != nil {
+		t.Fatalf("Failed to load module config: %v", err)
+	}
+	if password := loaded.(*testConfig).Password; password != "mqtt-secret" {
+		t.Errorf("Expected module secret to be resolved, got %q", password)
+	}
+
+	// An unresolvable secret outside of module sections fails the configuration
+	content = `{"http": {"listen": ":9275", "auth": {"bearer_token": "${env:TEST_MISSING_SECRET}"}}}`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := LoadGlobalConfigFromPath(configPath); err == nil || !strings.Contains(err.Error(), "http.auth.bearer_token") {
+		t.Errorf("Expected error locating the unresolvable secret, got %v", err)
+	}
+}
+
+// countingSecrets counts the secrets it returns
+type countingSecrets struct {
+	calls atomic.Int32
+}
+
+func (cs *countingSecrets) Secret(ctx context.Context, ref string) (string, error) {
+	cs.calls.Add(1)
+	return "secret-" + ref, nil
+}
+
+func TestLoadGlobalConfig_SecretsResolvedOnce(t *testing.T) {
+	provider := &countingSecrets{}
+	utils.RegisterSecretsProvider("counting", provider)
+
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "config.json")
+	content := `{
+		"modules": {
+			"first": {"enabled": true, "custom": {"password": "${counting:first}"}},
+			"second": {"enabled": true, "custom": {"password": "${counting:second}"}},
+			"third": {"enabled": true, "custom": {"password": "${counting:third}"}}
+		}
+	}`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	type testConfig struct {
+		BaseConfig
+		Password string `json:"password"`
+	}
+	loadModules := func() {
+		for _, name := range []string{"first", "second", "third"} {
+			loaded, err := NewLoaderWithPath(name, configPath).LoadConfig(&testConfig{})
+			if err != nil {
+				t.Fatalf("Failed to load config of %s: %v", name, err)
+			}
+			if password := loaded.(*testConfig).Password; password != "secret-"+name {
+				t.Errorf("Expected secret of %s to be resolved, got %q", name, password)
+			}
+		}
+	}
+
+	// The loaders of the modules share the secrets resolved by the first one
+	loadModules()
+	if calls := provider.calls.Load(); calls != 3 {
+		t.Errorf("Expected 3 provider calls, got %d", calls)
+	}
+
+	// Loading the configuration resolves the secrets again, the loaders reuse them
+	if _, err := LoadGlobalConfigFromPath(configPath); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	loadModules()
+	if calls := provider.calls.Load(); calls != 6 {
+		t.Errorf("Expected 6 provider calls after reloading, got %d", calls)
+	}
+
+	// A changed configuration file is resolved again
+	content = strings.Replace(content, `"third": {"enabled": true`, `"third": {"enabled": false`, 1)
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := NewLoaderWithPath("first", configPath).LoadConfig(&testConfig{}); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if calls := provider.calls.Load(); calls != 8 {
+		t.Errorf("Expected 8 provider calls after the change, got %d", calls)
+	}
+}
+
+func TestLoadGlobalConfig_Refs(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "config.json")
+	content := `{
+		"modules": {
+			"tasmota": {"enabled": false, "custom": {"broker": "tcp://broker:1883", "username": "agent", "password": "secret"}},
+			"test": {"enabled": true, "custom": {"$ref": "modules.tasmota.custom", "username": "test"}},
+			"copy": {"enabled": true, "custom": {"broker": {"$ref": "modules.test.custom.broker"}}},
+			"missing": {"enabled": true, "custom": {"broker": {"$ref": "modules.tasmota.custom.host"}}},
+			"circular": {"enabled": true, "custom": {"broker": {"$ref": "modules.circular.custom.broker"}}},
+			"disabled": {"enabled": false, "custom": {"broker": {"$ref": "modules.none"}}}
+		}
+	}`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	globalConfig, err := LoadGlobalConfigFromPath(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	for _, name := range []string{"missing", "circular"} {
+		if !IsModuleError(globalConfig.ModuleErrors[name]) || !globalConfig.Modules[name].Enabled {
+			t.Errorf("Expected module error for unresolvable reference in %s, got %v", name, globalConfig.ModuleErrors)
+		}
+	}
+	if _, exists := globalConfig.ModuleErrors["disabled"]; exists {
+		t.Error("Expected references of disabled modules not to be resolved")
+	}
+
+	type testConfig struct {
+		BaseConfig
+		Broker   string `json:"broker"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	loaded, err := NewLoaderWithPath("test", configPath).LoadConfig(&testConfig{})
+	if err != nil {
+		t.Fatalf("Failed to load module config: %v", err)
+	}
+	want := testConfig{Broker: "tcp://broker:1883", Username: "test", Password: "secret"}
+	if got := *loaded.(*testConfig); got.Broker != want.Broker || got.Username != want.Username || got.Password != want.Password {
+		t.Errorf("Expected referenced settings %+v, got %+v", want, got)
+	}
+	if loaded, err = NewLoaderWithPath("copy", configPath).LoadConfig(&testConfig{}); err != nil || loaded.(*testConfig).Broker != want.Broker {
+		t.Errorf("Expected chained reference to be resolved, got %v (%v)", loaded, err)
+	}
+
+	// An unresolvable reference outside of module sections fails the configuration
+	content = `{"http": {"listen": {"$ref": "prometheus.listen"}}}`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := LoadGlobalConfigFromPath(configPath); err == nil || !strings.Contains(err.Error(), "http.listen") {
+		t.Errorf("Expected error locating the unresolvable reference, got %v", err)
+	}
+}
+
+func TestLoader_InvalidCustomSettings(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "config.json")
+	content := `{
+		"modules": {
+			"test": {
+				"custom": {
+					"attempts": "many",
+					"interval": "soon",
+					"ids": 42
+				}
+			}
+		}
+	}`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	type testConfig struct {
+		IDs      []string      `json:"ids"`
+		Attempts int           `json:"attempts"`
+		Interval time.Duration `json:"interval"`
+	}
+
+	_, err := NewLoaderWithPath("test", configPath).LoadConfig(&testConfig{})
+	if !IsModuleError(err) {
+		t.Fatalf("Expected module error, got %v", err)
+	}
+	for _, setting := range []string{"attempts", "interval", "ids"} {
+		if !strings.Contains(err.Error(), "custom setting "+setting) {
+			t.Errorf("Expected error to mention %s, got %v", setting, err)
+		}
+	}
+}
+
+func TestHTTPConfig_Validate(t *testing.T) {
+	valid := HTTPConfig{
+		Listen: ":9275",
+		Auth:   &HTTPAuthConfig{Username: "admin", Password: "secret"},
+		TLS:    &TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected valid configuration, got %v", err)
+	}
+	if err := (HTTPConfig{Listen: "9275"}).Validate(); err == nil {
+		t.Error("Expected error for listen address without port")
+	}
+	if err := (HTTPConfig{Listen: ":9275", Auth: &HTTPAuthConfig{}}).Validate(); err == nil {
+		t.Error("Expected error for auth without credentials")
+	}
+	if err := (HTTPConfig{Listen: ":9275", Auth: &HTTPAuthConfig{Username: "admin"}}).Validate(); err == nil {
+		t.Error("Expected error for username without password")
+	}
+	if err := (HTTPConfig{Listen: ":9275", TLS: &TLSConfig{CertFile: "cert.pem"}}).Validate(); err == nil {
+		t.Error("Expected error for tls without key_file")
+	}
+}
+
+func TestHTTPConfig_ListenAddress(t *testing.T) {
+	tests := map[string]string{
+		":9275":          "127.0.0.1:9275",
+		"0.0.0.0:9275":   "0.0.0.0:9275",
+		"[::1]:9275":     "[::1]:9275",
+		"localhost:9275": "localhost:9275",
+	}
+	for listen, expected := range tests {
+		if got := (HTTPConfig{Listen: listen}).ListenAddress(); got != expected {
+			t.Errorf("ListenAddress(%q) = %q, expected %q", listen, got, expected)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file contains the Duration type used by all duration settings.
+package config
+
+import "github.com/janhuddel/metrics-agent/pkg/duration"
+
+// Duration is a time.Duration that is configured as a string such as "30s" or
+// "10m". It is the Duration of package duration, which the public packages use
+// as well.
+type Duration = duration.Duration
@@ -0,0 +1,93 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file generates an example configuration file, so new users can start
+// from a file listing every module and its settings instead of a blank one.
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// Example returns an example configuration file. moduleConfigs maps module
+// names to their default Config structs, or nil for modules without settings.
+// Every module is disabled, and its custom section lists all settings with
+// their default values, or a zero value for settings without default.
+func Example(moduleConfigs map[string]interface{}) ([]byte, error) {
+	names := make([]string, 0, len(moduleConfigs))
+	for name := range moduleConfigs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	modules := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		module := map[string]interface{}{"enabled": false}
+		if cfg := moduleConfigs[name]; cfg != nil {
+			custom, err := exampleCustom(cfg)
+			if err != nil {
+				return nil, err
+			}
+			module["custom"] = custom
+		}
+		modules[name] = module
+	}
+
+	example := map[string]interface{}{
+		"config_version": CurrentConfigVersion,
+		"log_level":      "info",
+		"modules":        modules,
+	}
+	data, err := json.MarshalIndent(example, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// exampleCustom returns the custom settings of a module with the values of
+// its default config.
+func exampleCustom(cfg interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+
+	properties := customSchema(reflect.TypeOf(cfg))["properties"].(map[string]interface{})
+	custom := make(map[string]interface{}, len(properties))
+	for name, property := range properties {
+		if value, ok := values[name]; ok && value != nil {
+			custom[name] = value
+		} else {
+			// Left out by omitempty or nil
+			custom[name] = zeroValue(property.(map[string]interface{}))
+		}
+	}
+	return custom, nil
+}
+
+// zeroValue returns the JSON zero value of the type described by a schema.
+func zeroValue(schema map[string]interface{}) interface{} {
+	if _, ok := schema["pattern"]; ok {
+		return "0s"
+	}
+	switch schema["type"] {
+	case "boolean":
+		return false
+	case "integer", "number":
+		return 0
+	case "array":
+		return []interface{}{}
+	case "object":
+		return map[string]interface{}{}
+	case "string":
+		return ""
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExample(t *testing.T) {
+	type testModuleConfig struct {
+		BaseConfig
+
+		Broker   string   `json:"broker"`
+		Token    string   `json:"token,omitempty"`
+		Interval Duration `json:"interval,omitempty"`
+		Timeout  Duration `json:"timeout,omitempty"`
+		Devices  []string `json:"devices"`
+		QoS      int      `json:"qos"`
+	}
+
+	data, err := Example(map[string]interface{}{
+		"test": testModuleConfig{Broker: "tcp://localhost:1883", Interval: Duration(time.Minute)},
+	})
+	if err != nil {
+		t.Fatalf("Failed to generate example: %v", err)
+	}
+
+	globalConfig, err := parseGlobalConfig(data)
+	if err != nil {
+		t.Fatalf("Example is not a valid configuration: %v\n%s", err, data)
+	}
+	if globalConfig.ConfigVersion != CurrentConfigVersion {
+		t.Errorf("Expected config_version %d, got %d", CurrentConfigVersion, globalConfig.ConfigVersion)
+	}
+	module, ok := globalConfig.Modules["test"]
+	if !ok || module.Enabled {
+		t.Fatalf("Expected disabled module test, got %+v", globalConfig.Modules)
+	}
+
+	custom := module.Custom
+	expected := map[string]interface{}{
+		"broker":   "tcp://localhost:1883",
+		"token":    "",
+		"interval": "1m0s",
+		"timeout":  "0s",
+		"qos":      0.0,
+	}
+	for name, value := range expected {
+		if custom[name] != value {
+			t.Errorf("Expected %s to be %v, got %v", name, value, custom[name])
+		}
+	}
+	if devices, ok := custom["devices"].([]interface{}); !ok || len(devices) != 0 {
+		t.Errorf("Expected devices to be an empty list, got %v", custom["devices"])
+	}
+}
@@ -0,0 +1,78 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file contains the configuration of the HTTP status server.
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// HTTPConfig configures the HTTP server of the agent, which serves its status
+// and control endpoints.
+type HTTPConfig struct {
+	// Listen is the address of the server, e.g. "127.0.0.1:9275". An address
+	// without host (":9275") binds to localhost only; use "0.0.0.0:9275" to
+	// serve on all interfaces. If not set, no server is started.
+	Listen string `json:"listen,omitempty"`
+
+	// Auth requires clients to authenticate. If not set, requests are not
+	// authenticated, so the server should only be bound to localhost.
+	Auth *HTTPAuthConfig `json:"auth,omitempty"`
+
+	// TLS serves HTTPS instead of plain HTTP.
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+// HTTPAuthConfig configures the authentication of HTTP clients. Either a
+// bearer token or a username and password can be set, or both.
+type HTTPAuthConfig struct {
+	// BearerToken is accepted in an "Authorization: Bearer <token>" header.
+	BearerToken string `json:"bearer_token,omitempty"`
+
+	// Username and Password are accepted as HTTP basic auth.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// TLSConfig configures the certificate of a TLS server.
+type TLSConfig struct {
+	// CertFile is the path of the PEM encoded certificate (chain).
+	CertFile string `json:"cert_file"`
+
+	// KeyFile is the path of the PEM encoded private key.
+	KeyFile string `json:"key_file"`
+}
+
+// ListenAddress returns the address to bind to, with localhost as the host if
+// Listen has none.
+func (c HTTPConfig) ListenAddress() string {
+	host, port, err := net.SplitHostPort(c.Listen)
+	if err != nil || host != "" {
+		return c.Listen
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// Validate checks that the listen address is valid and that authentication
+// and TLS are configured completely.
+func (c HTTPConfig) Validate() error {
+	if c.Listen == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", c.Listen, err)
+	}
+	if auth := c.Auth; auth != nil {
+		if auth.BearerToken == "" && auth.Username == "" && auth.Password == "" {
+			return fmt.Errorf("auth requires a bearer_token or a username and password")
+		}
+		if (auth.Username == "") != (auth.Password == "") {
+			return fmt.Errorf("auth requires both username and password")
+		}
+	}
+	if c.TLS != nil && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+		return fmt.Errorf("tls requires both cert_file and key_file")
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file contains helpers for running multiple named instances of a module.
+package config
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// instanceContextKey is the context key for the current module instance name.
+type instanceContextKey struct{}
+
+// WithInstance returns a context carrying the name of the module instance to run.
+func WithInstance(ctx context.Context, instance string) context.Context {
+	return context.WithValue(ctx, instanceContextKey{}, instance)
+}
+
+// InstanceFromContext returns the module instance name carried by the context.
+// It returns an empty string when the module runs without instances.
+func InstanceFromContext(ctx context.Context) string {
+	instance, _ := ctx.Value(instanceContextKey{}).(string)
+	return instance
+}
+
+// InstanceName returns the module name scoped to an instance (e.g. "tasmota.haus1").
+// Without an instance the module name is returned unchanged.
+func InstanceName(moduleName, instance string) string {
+	if instance == "" {
+		return moduleName
+	}
+	return moduleName + "." + instance
+}
+
+// SplitInstanceName splits an instance-scoped module name (e.g. "tasmota.haus1")
+// into the module name and the instance name. The instance name is empty if the
+// name is not scoped to an instance.
+func SplitInstanceName(name string) (moduleName, instance string) {
+	moduleName, instance, _ = strings.Cut(name, ".")
+	return moduleName, instance
+}
+
+// InstanceNames returns the names of the configured instances in sorted order.
+func (mc ModuleConfig) InstanceNames() []string {
+	names := make([]string, 0, len(mc.Instances))
+	for name := range mc.Instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
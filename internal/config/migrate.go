@@ -0,0 +1,153 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file contains the migrations that upgrade older configuration layouts
+// at load time, so refactoring a module's settings doesn't break existing
+// installations.
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CurrentConfigVersion is the configuration layout of this version of the agent.
+// Configuration files without a config_version have version 0.
+const CurrentConfigVersion = 1
+
+// migration upgrades the raw configuration to version. It returns a description
+// of each change, which is logged as a warning.
+type migration struct {
+	version int
+	apply   func(raw map[string]interface{}) []string
+}
+
+// migrations are applied in order to configurations older than their version.
+// To rename or move a setting, add a migration for CurrentConfigVersion+1 and
+// increment CurrentConfigVersion.
+var migrations = []migration{
+	{version: 1, apply: moveModuleSettingsOutOfCustom},
+}
+
+// MigrateConfig upgrades a raw configuration in place to CurrentConfigVersion
+// and returns the changes made. Configurations of a newer version are left
+// unchanged; a warning is returned for them.
+func MigrateConfig(raw map[string]interface{}) ([]string, error) {
+	version := 0
+	if value, exists := raw["config_version"]; exists {
+		number, ok := value.(float64)
+		if !ok || number != float64(int(number)) || number < 0 {
+			return nil, fmt.Errorf("config_version must be a non-negative integer, got %v", value)
+		}
+		version = int(number)
+	}
+
+	if version > CurrentConfigVersion {
+		return []string{fmt.Sprintf("config_version %d is newer than the supported version %d, some settings may be ignored",
+			version, CurrentConfigVersion)}, nil
+	}
+
+	var changes []string
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		for _, change := range m.apply(raw) {
+			changes = append(changes, fmt.Sprintf("config_version %d: %s", m.version, change))
+		}
+	}
+	raw["config_version"] = float64(CurrentConfigVersion)
+	return changes, nil
+}
+
+// moveModuleSettingsOutOfCustom moves module settings that were put into the
+// custom settings, where they had no effect, to the module section.
+func moveModuleSettingsOutOfCustom(raw map[string]interface{}) []string {
+	var changes []string
+	for _, key := range []string{"enabled", "friendly_name_overrides"} {
+		changes = append(changes, moveOutOfCustom(raw, key)...)
+	}
+	return changes
+}
+
+// moveOutOfCustom moves a custom setting of every module to the module section,
+// unless the module section already contains it.
+func moveOutOfCustom(raw map[string]interface{}, key string) []string {
+	var changes []string
+	for _, name := range moduleNames(raw) {
+		module := moduleSection(raw, name)
+		custom, ok := module["custom"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value, exists := custom[key]
+		if !exists {
+			continue
+		}
+
+		delete(custom, key)
+		if _, exists := module[key]; exists {
+			changes = append(changes, fmt.Sprintf("removed custom setting %s of module %s, it is already set in the module section", key, name))
+			continue
+		}
+		module[key] = value
+		changes = append(changes, fmt.Sprintf("moved custom setting %s of module %s to the module section", key, name))
+	}
+	return changes
+}
+
+// renameCustomSetting renames a custom setting of a module and its instances.
+// A setting that is already set under the new name is not overwritten.
+func renameCustomSetting(raw map[string]interface{}, moduleName, oldKey, newKey string) []string {
+	module := moduleSection(raw, moduleName)
+	if module == nil {
+		return nil
+	}
+
+	sections := map[string]map[string]interface{}{"module " + moduleName: module}
+	if instances, ok := module["instances"].(map[string]interface{}); ok {
+		for instanceName, instance := range instances {
+			if section, ok := instance.(map[string]interface{}); ok {
+				sections["instance "+moduleName+"."+instanceName] = section
+			}
+		}
+	}
+
+	var changes []string
+	for name, section := range sections {
+		custom, ok := section["custom"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value, exists := custom[oldKey]
+		if !exists {
+			continue
+		}
+		delete(custom, oldKey)
+		if _, exists := custom[newKey]; exists {
+			changes = append(changes, fmt.Sprintf("removed custom setting %s of %s, %s is already set", oldKey, name, newKey))
+			continue
+		}
+		custom[newKey] = value
+		changes = append(changes, fmt.Sprintf("renamed custom setting %s of %s to %s", oldKey, name, newKey))
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// moduleNames returns the sorted names of the module sections.
+func moduleNames(raw map[string]interface{}) []string {
+	modules, _ := raw["modules"].(map[string]interface{})
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// moduleSection returns the section of a module, or nil if it doesn't exist.
+func moduleSection(raw map[string]interface{}, name string) map[string]interface{} {
+	modules, _ := raw["modules"].(map[string]interface{})
+	module, _ := modules[name].(map[string]interface{})
+	return module
+}
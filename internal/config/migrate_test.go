@@ -0,0 +1,146 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// parseRaw parses a raw configuration for migration tests
+func parseRaw(t *testing.T, content string) map[string]interface{} {
+	t.Helper()
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &raw); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	return raw
+}
+
+func TestMigrateConfig_MoveOutOfCustom(t *testing.T) {
+	raw := parseRaw(t, `{
+		"modules": {
+			"dwd": {"custom": {"enabled": true, "interval": "5m"}},
+			"nut": {"enabled": false, "custom": {"enabled": true}}
+		}
+	}`)
+
+	changes, err := MigrateConfig(raw)
+	if err != nil {
+		t.Fatalf("Migration failed: %v", err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %v", changes)
+	}
+	if !strings.Contains(changes[0], "moved custom setting enabled of module dwd") {
+		t.Errorf("Unexpected change %q", changes[0])
+	}
+	if !strings.Contains(changes[1], "removed custom setting enabled of module nut") {
+		t.Errorf("Unexpected change %q", changes[1])
+	}
+
+	dwd := moduleSection(raw, "dwd")
+	if dwd["enabled"] != true {
+		t.Errorf("Expected dwd to be enabled, got %v", dwd["enabled"])
+	}
+	if _, exists := dwd["custom"].(map[string]interface{})["enabled"]; exists {
+		t.Errorf("Expected enabled to be removed from custom settings")
+	}
+	if moduleSection(raw, "nut")["enabled"] != false {
+		t.Errorf("Expected the module setting of nut to take precedence")
+	}
+	if raw["config_version"] != float64(CurrentConfigVersion) {
+		t.Errorf("Expected config_version %d, got %v", CurrentConfigVersion, raw["config_version"])
+	}
+}
+
+func TestMigrateConfig_Versions(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		wantChanges int
+		wantErr     bool
+	}{
+		{"current version is not migrated", `{"config_version": 1, "modules": {"dwd": {"custom": {"enabled": true}}}}`, 0, false},
+		{"newer version is warned about", `{"config_version": 99}`, 1, false},
+		{"invalid version", `{"config_version": "one"}`, 0, true},
+		{"no modules", `{}`, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := MigrateConfig(parseRaw(t, tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if len(changes) != tt.wantChanges {
+				t.Errorf("Expected %d changes, got %v", tt.wantChanges, changes)
+			}
+		})
+	}
+}
+
+func TestRenameCustomSetting(t *testing.T) {
+	raw := parseRaw(t, `{
+		"modules": {
+			"opendtu": {
+				"custom": {"web_socket_url": "ws://a/ws"},
+				"instances": {
+					"garage": {"custom": {"web_socket_url": "ws://b/ws", "url": "ws://c/ws"}}
+				}
+			}
+		}
+	}`)
+
+	changes := renameCustomSetting(raw, "opendtu", "web_socket_url", "url")
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %v", changes)
+	}
+
+	module := moduleSection(raw, "opendtu")
+	if custom := module["custom"].(map[string]interface{}); custom["url"] != "ws://a/ws" || len(custom) != 1 {
+		t.Errorf("Expected setting to be renamed, got %v", custom)
+	}
+	instance := module["instances"].(map[string]interface{})["garage"].(map[string]interface{})
+	if custom := instance["custom"].(map[string]interface{}); custom["url"] != "ws://c/ws" || len(custom) != 1 {
+		t.Errorf("Expected existing setting to be kept, got %v", custom)
+	}
+
+	if changes := renameCustomSetting(raw, "unknown", "a", "b"); changes != nil {
+		t.Errorf("Expected no changes for unknown module, got %v", changes)
+	}
+}
+
+func TestLoadGlobalConfig_Migrations(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	content := `{"modules": {"dwd": {"custom": {"enabled": true, "friendly_name_overrides": {"a": "b"}}}}}`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	globalConfig, err := LoadGlobalConfigFromPath(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(globalConfig.Migrations) != 2 {
+		t.Errorf("Expected 2 migrations, got %v", globalConfig.Migrations)
+	}
+	if globalConfig.ConfigVersion != CurrentConfigVersion {
+		t.Errorf("Expected config_version %d, got %d", CurrentConfigVersion, globalConfig.ConfigVersion)
+	}
+	dwd := globalConfig.Modules["dwd"]
+	if !dwd.Enabled || dwd.FriendlyNameOverrides["a"] != "b" {
+		t.Errorf("Expected migrated settings to be applied, got %+v", dwd)
+	}
+
+	// Module loaders see the migrated configuration as well
+	loaded, err := NewLoaderWithPath("dwd", configPath).LoadConfig(&struct {
+		BaseConfig
+	}{})
+	if err != nil {
+		t.Fatalf("Failed to load module config: %v", err)
+	}
+	if overrides := loaded.(*struct{ BaseConfig }).FriendlyNameOverrides; overrides["a"] != "b" {
+		t.Errorf("Expected migrated friendly name overrides in module config, got %v", overrides)
+	}
+}
@@ -0,0 +1,33 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file contains the MQTT options shared by MQTT based modules.
+package config
+
+import "fmt"
+
+// MQTTOptions holds the subscription and session options of MQTT based modules.
+// Modules embed it in their Config, so the options are set next to the other
+// module settings in the "custom" section.
+type MQTTOptions struct {
+	// QoS is the quality of service level used for subscriptions (0, 1 or 2).
+	QoS int `json:"qos"`
+
+	// CleanSession starts a new session on every connect instead of resuming the
+	// persistent session, in which the broker keeps subscriptions and queued messages.
+	CleanSession bool `json:"clean_session"`
+
+	// MaxInFlight is the maximum number of received messages processed
+	// concurrently. 0 means unlimited.
+	MaxInFlight int `json:"max_in_flight"`
+}
+
+// Validate checks that the MQTT options are within their valid ranges.
+func (o MQTTOptions) Validate() error {
+	if o.QoS < 0 || o.QoS > 2 {
+		return fmt.Errorf("qos must be 0, 1 or 2, got %d", o.QoS)
+	}
+	if o.MaxInFlight < 0 {
+		return fmt.Errorf("max_in_flight must not be negative, got %d", o.MaxInFlight)
+	}
+	return nil
+}
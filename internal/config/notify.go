@@ -0,0 +1,23 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file contains the configuration of notifications about broken modules.
+package config
+
+// NotifyConfig configures notifications sent when a module keeps failing.
+// A notification is sent when a module exceeds the restart limit and, if
+// CrashLoopAfter is set, when a module has been failing for that long.
+type NotifyConfig struct {
+	// Webhook is a URL that receives each notification as a JSON POST request.
+	Webhook string `json:"webhook,omitempty"`
+
+	// Command is run for each notification, e.g. ["/usr/local/bin/notify.sh"].
+	// The notification is passed as JSON on stdin and in METRICS_AGENT_* environment variables.
+	Command []string `json:"command,omitempty"`
+
+	// CrashLoopAfter is how long a module must keep failing before a crash loop
+	// is notified (e.g. "10m"). If not set, only exceeding the restart limit is notified.
+	CrashLoopAfter Duration `json:"crash_loop_after,omitempty"`
+
+	// Timeout limits how long a single notification may take (e.g. "10s"). Defaults to "10s".
+	Timeout Duration `json:"timeout,omitempty"`
+}
@@ -0,0 +1,19 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file contains the configuration of the OpenTelemetry (OTLP) export.
+package config
+
+// OTLPConfig configures the export of all metrics to an OpenTelemetry
+// Collector or another OTLP/HTTP receiver, in addition to the line protocol
+// written to stdout.
+type OTLPConfig struct {
+	OutputOptions
+
+	// Endpoint is the OTLP/HTTP metrics URL, e.g. "http://collector:4318/v1/metrics".
+	// If not set, metrics are not exported.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// ResourceAttributes are added to the resource of all exported metrics,
+	// e.g. {"deployment.environment": "home"}.
+	ResourceAttributes map[string]string `json:"resource_attributes,omitempty"`
+}
@@ -0,0 +1,66 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file contains the options shared by network outputs.
+package config
+
+import "fmt"
+
+// Supported compressions of network outputs
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+// OutputOptions holds the batching, compression and retry options shared by
+// network outputs such as OTLP. Outputs embed it in their configuration, so
+// the options are set next to the endpoint.
+type OutputOptions struct {
+	// Headers are sent with every request, e.g. {"Authorization": "Basic ..."}.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Interval is how often buffered metrics are sent. Defaults to "10s".
+	Interval Duration `json:"interval,omitempty"`
+
+	// BatchSize is the maximum number of metrics per request. A full batch is
+	// sent immediately without waiting for the interval. Defaults to 1000.
+	BatchSize int `json:"batch_size,omitempty"`
+
+	// BufferLimit is the maximum number of metrics buffered while the receiver
+	// is unreachable. Further metrics are dropped. Defaults to 10000.
+	BufferLimit int `json:"buffer_limit,omitempty"`
+
+	// Compression of the request body: "gzip" or "none". Defaults to "none".
+	Compression string `json:"compression,omitempty"`
+
+	// Timeout limits how long a single request may take. Defaults to "10s".
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// MaxRetries is how often a request is retried with backoff after a
+	// transport error or a 429 or 5xx response. The batch is kept for the next
+	// interval when all retries failed. Defaults to 3.
+	MaxRetries int `json:"max_retries,omitempty"`
+
+	// Shadow marks a trial output, e.g. a new database tested alongside the
+	// production one: failed requests are neither retried nor kept for the
+	// next interval, and errors are only logged at debug level.
+	Shadow bool `json:"shadow,omitempty"`
+}
+
+// Validate checks that the output options are within their valid ranges.
+func (o OutputOptions) Validate() error {
+	switch o.Compression {
+	case "", CompressionNone, CompressionGzip:
+	default:
+		return fmt.Errorf("compression must be %q or %q, got %q", CompressionGzip, CompressionNone, o.Compression)
+	}
+	if o.BatchSize < 0 {
+		return fmt.Errorf("batch_size must not be negative, got %d", o.BatchSize)
+	}
+	if o.BufferLimit < 0 {
+		return fmt.Errorf("buffer_limit must not be negative, got %d", o.BufferLimit)
+	}
+	if o.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must not be negative, got %d", o.MaxRetries)
+	}
+	return nil
+}
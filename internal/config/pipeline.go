@@ -0,0 +1,330 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file contains the configuration of the metric processing pipeline.
+package config
+
+import "fmt"
+
+// PipelineConfig configures the processors that are applied to every metric
+// before it is written to stdout.
+type PipelineConfig struct {
+	// DeviceAliases maps the identifiers a physical device has in different
+	// modules to one canonical identifier, so their metrics can be joined.
+	// It runs first, so all other processors see the canonical identifier.
+	DeviceAliases *DeviceAliasesConfig `json:"device_aliases,omitempty"`
+
+	// Anonymize replaces device identifiers in tags with pseudonyms. It runs
+	// before all other processors except device aliases, so processor state
+	// never contains the original identifiers.
+	Anonymize *AnonymizeConfig `json:"anonymize,omitempty"`
+
+	// Ranges contains rules for valid value ranges. Range checks run before
+	// spike filtering, so out-of-range values never become a spike baseline.
+	Ranges []RangeRule `json:"ranges,omitempty"`
+
+	// SpikeFilter contains rules for dropping values that jump implausibly
+	// compared to the previous value of the same series.
+	SpikeFilter []SpikeFilterRule `json:"spike_filter,omitempty"`
+
+	// Derivative contains rules for deriving rates or deltas from counter
+	// fields. It runs after filtering, so spikes don't distort the rates.
+	Derivative []DerivativeRule `json:"derivative,omitempty"`
+
+	// Accumulate contains rules for accumulating daily and weekly totals.
+	// Accumulation runs after filtering, so dropped values are not counted.
+	Accumulate []AccumulateRule `json:"accumulate,omitempty"`
+
+	// DailySummary contains rules for summarizing fields per series and day,
+	// e.g. the minimum and maximum temperature of a room. The summaries of a
+	// day are emitted with the first metric after local midnight.
+	DailySummary []DailySummaryRule `json:"daily_summary,omitempty"`
+
+	// Round contains rules for rounding fields to a number of decimals.
+	// Rounding runs last, so derived values and totals are rounded as well.
+	Round []RoundRule `json:"round,omitempty"`
+
+	// Custom contains the compiled-in custom processors to apply, in order
+	// (see package pkg/processor). They run after the built-in processors.
+	Custom []CustomProcessorConfig `json:"custom,omitempty"`
+
+	// TypeChanges handles field values whose type differs from the first value
+	// of the series, e.g. a power reading arriving as string after a firmware
+	// update, which the database rejects: "warn" logs the change, "coerce"
+	// converts the value to the previous type, "drop" drops the field. It runs
+	// after the custom processors. If not set, types are not tracked.
+	TypeChanges string `json:"type_changes,omitempty"`
+
+	// NormalizeTags normalizes tag values such as friendly names, e.g.
+	// "Geschirrspüler " to "geschirrspueler". It runs after the other
+	// processors, so their state uses the original values.
+	NormalizeTags *NormalizeTagsConfig `json:"normalize_tags,omitempty"`
+
+	// ReorderWindow holds the processed metrics for this duration (e.g. "5s")
+	// and writes them sorted by timestamp, for consumers that reject
+	// out-of-order points, e.g. when a module backfills historical data while
+	// others send live data. If not set, metrics are written as they arrive.
+	ReorderWindow Duration `json:"reorder_window,omitempty"`
+
+	// Absence sends a marker when a device stops sending a measurement, so
+	// consumers can tell a missing device from a stalled series. If not set,
+	// no markers are sent.
+	Absence *AbsenceConfig `json:"absence,omitempty"`
+}
+
+// AbsenceConfig configures the markers sent for devices that fell silent.
+type AbsenceConfig struct {
+	// TTL is the silence after which a device counts as absent, e.g. "15m".
+	// If not set, only the measurements listed in Measurements are tracked.
+	TTL Duration `json:"ttl,omitempty"`
+
+	// Measurements sets the TTL of single measurements, e.g. {"climate": "1h"}
+	// for sensors that only report changes.
+	Measurements map[string]Duration `json:"measurements,omitempty"`
+
+	// Field is the field of the marker, which is 0 in the marker and 1 in the
+	// first metric after the device is back. Defaults to "online".
+	Field string `json:"field,omitempty"`
+}
+
+// DeviceAliasesConfig configures the mapping of device identifiers to
+// canonical identifiers, e.g. of a plug that is collected by the tasmota
+// module and, as Home Assistant entity, by the esphome module.
+type DeviceAliasesConfig struct {
+	// Devices maps canonical identifiers to their aliases, e.g.
+	// {"washer": ["tasmota_A1B2C3", "washer_plug"]}.
+	Devices map[string][]string `json:"devices"`
+
+	// Tags are the tags whose values are looked up in the aliases.
+	// Defaults to ["device"].
+	Tags []string `json:"tags,omitempty"`
+
+	// Tag is the tag the canonical identifier is written to. Defaults to "device".
+	Tag string `json:"tag,omitempty"`
+
+	// AliasTag keeps the original value as tag with this name (e.g. "alias"),
+	// so the streams can still be told apart. If not set, it is not kept.
+	AliasTag string `json:"alias_tag,omitempty"`
+}
+
+// AnonymizeConfig configures the pseudonymization of tag values.
+type AnonymizeConfig struct {
+	// Key is the secret of the HMAC computing the pseudonyms. The same key
+	// always yields the same pseudonym for a value, so series stay continuous.
+	Key string `json:"key"`
+
+	// Tags are the tags whose values are replaced. Defaults to ["device"].
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Validate checks that a key is configured, as pseudonyms without a secret
+// can be reversed by hashing all possible MAC addresses.
+func (c AnonymizeConfig) Validate() error {
+	if c.Key == "" {
+		return fmt.Errorf("key is required")
+	}
+	return nil
+}
+
+// NormalizeTagsConfig configures the normalization of tag values. Leading and
+// trailing whitespace is always removed and inner whitespace collapsed.
+type NormalizeTagsConfig struct {
+	// Tags are the tags whose values are normalized. Empty matches all tags.
+	Tags []string `json:"tags,omitempty"`
+
+	// Lowercase converts the values to lower case.
+	Lowercase bool `json:"lowercase,omitempty"`
+
+	// Spaces replaces the spaces within the values, e.g. with "_". If not
+	// set, spaces are kept.
+	Spaces string `json:"spaces,omitempty"`
+
+	// Transliterate replaces umlauts and accented letters with their ASCII
+	// spelling (e.g. "ü" with "ue", "é" with "e") and removes other non-ASCII
+	// characters.
+	Transliterate bool `json:"transliterate,omitempty"`
+}
+
+// RoundRule configures the number of decimals of fields of a measurement.
+type RoundRule struct {
+	// Measurement is the measurement the rule applies to. Empty matches all measurements.
+	Measurement string `json:"measurement,omitempty"`
+
+	// Fields are the fields the rule applies to. Empty matches all float fields.
+	Fields []string `json:"fields,omitempty"`
+
+	// Decimals is the number of decimals to round to. Negative values round to
+	// tens, hundreds and so on.
+	Decimals int `json:"decimals"`
+}
+
+// SpikeFilterRule configures spike filtering for a measurement.
+// A value is rejected if it differs from the previous accepted value of the same
+// series (measurement, tags and field) by more than MaxDelta or MaxChangePercent.
+type SpikeFilterRule struct {
+	// Measurement is the measurement the rule applies to. Empty matches all measurements.
+	Measurement string `json:"measurement,omitempty"`
+
+	// Fields are the fields the rule applies to. Empty matches all numeric fields.
+	Fields []string `json:"fields,omitempty"`
+
+	// MaxDelta is the maximum absolute change between two values. 0 disables the check.
+	MaxDelta float64 `json:"max_delta,omitempty"`
+
+	// MaxChangePercent is the maximum change relative to the previous value in percent.
+	// 0 disables the check. It is not applied if the previous value is 0.
+	MaxChangePercent float64 `json:"max_change_percent,omitempty"`
+
+	// MaxRejects is the number of consecutive rejected values after which a value
+	// is accepted as the new baseline, so that real level changes get through.
+	// Defaults to 3; negative values reject spikes indefinitely.
+	MaxRejects int `json:"max_rejects,omitempty"`
+}
+
+// Range actions for values outside the valid range
+const (
+	RangeActionDrop  = "drop"
+	RangeActionClamp = "clamp"
+)
+
+// Policies for field values changing their type
+const (
+	TypeChangeWarn   = "warn"
+	TypeChangeCoerce = "coerce"
+	TypeChangeDrop   = "drop"
+)
+
+// RangeRule configures the valid value range of fields of a measurement.
+type RangeRule struct {
+	// Measurement is the measurement the rule applies to. Empty matches all measurements.
+	Measurement string `json:"measurement,omitempty"`
+
+	// Fields are the fields the rule applies to. Empty matches all numeric fields.
+	Fields []string `json:"fields,omitempty"`
+
+	// Min is the lowest valid value. Nil means no lower bound.
+	Min *float64 `json:"min,omitempty"`
+
+	// Max is the highest valid value. Nil means no upper bound.
+	Max *float64 `json:"max,omitempty"`
+
+	// Action is applied to values outside the range: "drop" (default) removes
+	// the field, "clamp" replaces the value with the nearest bound.
+	Action string `json:"action,omitempty"`
+}
+
+// Accumulation modes
+const (
+	// AccumulateModePower integrates a power value in W over time into energy in Wh.
+	AccumulateModePower = "power"
+
+	// AccumulateModeCounter sums the increases of a counter (e.g. yield in kWh).
+	// A decreasing value is treated as a counter reset.
+	AccumulateModeCounter = "counter"
+)
+
+// AccumulateRule configures the accumulation of daily and weekly totals for fields
+// of a measurement. The totals are added to the metric as <field>_today and <field>_week.
+type AccumulateRule struct {
+	// Measurement is the measurement the rule applies to. Empty matches all measurements.
+	Measurement string `json:"measurement,omitempty"`
+
+	// Fields are the fields to accumulate. Empty matches all numeric fields.
+	Fields []string `json:"fields,omitempty"`
+
+	// Mode is either "power" or "counter". If not set, fields the module marks
+	// as counters are summed and all others are integrated as power.
+	Mode string `json:"mode,omitempty"`
+
+	// Timezone is the IANA timezone defining day and week boundaries (e.g. "Europe/Berlin").
+	// Defaults to the global timezone.
+	Timezone string `json:"timezone,omitempty"`
+
+	// Interval is how often the totals are added to a series' metrics (e.g. "1m").
+	// The state is persisted at the same cadence. Defaults to "1m".
+	Interval Duration `json:"interval,omitempty"`
+
+	// MaxGap is the longest gap between two power samples that is still integrated (e.g. "10m").
+	// Longer gaps (e.g. while the agent was stopped) are skipped. Defaults to "10m".
+	MaxGap Duration `json:"max_gap,omitempty"`
+}
+
+// Daily summary aggregates
+const (
+	SummaryMin      = "min"      // lowest value of the day
+	SummaryMax      = "max"      // highest value of the day
+	SummaryMean     = "mean"     // average of the values of the day
+	SummaryIncrease = "increase" // increase of a counter, e.g. the energy of the day
+	SummaryIntegral = "integral" // power in W integrated into energy in Wh
+	SummaryUptime   = "uptime"   // percentage of the day the series reported values
+)
+
+// DailySummaryRule configures the daily summary of fields of a measurement.
+// The summaries are emitted as measurement <measurement>_daily with the tags
+// of the series, the timestamp of the start of the day, and a field
+// <field>_<aggregate> per field and aggregate.
+type DailySummaryRule struct {
+	// Measurement is the measurement the rule applies to. Empty matches all measurements.
+	Measurement string `json:"measurement,omitempty"`
+
+	// Fields are the fields to summarize. Empty matches all numeric fields.
+	Fields []string `json:"fields,omitempty"`
+
+	// Aggregates are the aggregates to emit: "min", "max", "mean",
+	// "increase", "integral" and "uptime". Defaults to ["min", "max", "mean"].
+	Aggregates []string `json:"aggregates,omitempty"`
+
+	// Timezone is the IANA timezone defining day boundaries (e.g. "Europe/Berlin").
+	// Defaults to the global timezone.
+	Timezone string `json:"timezone,omitempty"`
+
+	// MaxGap is the longest gap between two values that still counts as
+	// uptime and is integrated (e.g. "10m"). Defaults to "10m".
+	MaxGap Duration `json:"max_gap,omitempty"`
+}
+
+// Derivative modes
+const (
+	// DerivativeModeRate divides the increase of a counter by the elapsed time.
+	DerivativeModeRate = "rate"
+
+	// DerivativeModeDelta reports the increase of a counter since the previous value.
+	DerivativeModeDelta = "delta"
+)
+
+// DerivativeRule configures the derivation of a rate or delta from counter fields
+// of a measurement. The result is added to the metric as <field><suffix>.
+type DerivativeRule struct {
+	// Measurement is the measurement the rule applies to. Empty matches all measurements.
+	Measurement string `json:"measurement,omitempty"`
+
+	// Fields are the counter fields to derive. Empty matches all fields the
+	// module marks as counter or cumulative.
+	Fields []string `json:"fields,omitempty"`
+
+	// Mode is either "rate" (default) or "delta".
+	Mode string `json:"mode,omitempty"`
+
+	// Unit is the time unit of the rate (e.g. "1h" turns an energy total in Wh
+	// into the average power in W). Defaults to "1s".
+	Unit Duration `json:"unit,omitempty"`
+
+	// Factor is multiplied with the result (e.g. 8 turns bytes into bits). Defaults to 1.
+	Factor float64 `json:"factor,omitempty"`
+
+	// Suffix is appended to the field name. Defaults to "_rate" or "_delta".
+	Suffix string `json:"suffix,omitempty"`
+
+	// MaxGap is the longest gap between two values that is still derived (e.g. "10m").
+	// Longer gaps (e.g. while a device was offline) are skipped. Defaults to "10m".
+	MaxGap Duration `json:"max_gap,omitempty"`
+}
+
+// CustomProcessorConfig enables a custom processor registered with package
+// pkg/processor.
+type CustomProcessorConfig struct {
+	// Name is the name the processor is registered under.
+	Name string `json:"name"`
+
+	// Config is passed to the factory of the processor as JSON.
+	Config map[string]interface{} `json:"config,omitempty"`
+}
@@ -0,0 +1,19 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file contains the configuration of the Prometheus endpoint.
+package config
+
+// PrometheusConfig configures an HTTP endpoint that exposes the latest value
+// of each series in the Prometheus text format for scraping.
+type PrometheusConfig struct {
+	// Listen is the address of the endpoint, e.g. ":9273".
+	// If not set, no endpoint is served.
+	Listen string `json:"listen,omitempty"`
+
+	// Path is the URL path of the endpoint. Defaults to "/metrics".
+	Path string `json:"path,omitempty"`
+
+	// StaleAfter is how long a series is exposed after its last update.
+	// Defaults to "5m".
+	StaleAfter Duration `json:"stale_after,omitempty"`
+}
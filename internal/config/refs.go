@@ -0,0 +1,163 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file contains the resolution of references between configuration
+// values such as {"$ref": "modules.tasmota.custom.broker"}, so that modules
+// connecting to the same broker or account don't repeat its settings.
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// refKey is the key of a reference object.
+const refKey = "$ref"
+
+// resolveRefs replaces the references in a raw configuration with copies of
+// the values they point to. Other keys of a reference object override the
+// keys of a referenced object. A reference in a module section that can't be
+// resolved only fails that module, which is returned in moduleErrors; sections
+// of disabled modules are not resolved, but can be referenced. Any other
+// unresolvable reference fails the whole configuration.
+func resolveRefs(raw map[string]interface{}) (moduleErrors map[string]error, err error) {
+	r := &refResolver{raw: raw, resolving: make(map[string]bool)}
+	for key, value := range raw {
+		if key == "modules" {
+			continue
+		}
+		if raw[key], err = r.resolve(value, key); err != nil {
+			return nil, err
+		}
+	}
+
+	modules, _ := raw["modules"].(map[string]interface{})
+	for name, section := range modules {
+		if enabled, _ := moduleSection(raw, name)["enabled"].(bool); !enabled {
+			continue
+		}
+		resolved, err := r.resolve(section, "modules."+name)
+		if err != nil {
+			if moduleErrors == nil {
+				moduleErrors = make(map[string]error)
+			}
+			moduleErrors[name] = &ModuleError{Module: name, Err: err}
+			continue
+		}
+		modules[name] = resolved
+	}
+	return moduleErrors, nil
+}
+
+// refResolver resolves references against the raw configuration. resolving
+// holds the references being resolved to detect cycles.
+type refResolver struct {
+	raw       map[string]interface{}
+	resolving map[string]bool
+}
+
+// resolve resolves the references in a raw value and its children. path
+// locates the value in error messages.
+func (r *refResolver) resolve(value interface{}, path string) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if ref, ok := v[refKey]; ok {
+			return r.resolveRef(v, ref, path)
+		}
+		resolved := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			c, err := r.resolve(child, path+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			resolved[key] = c
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+		for i, child := range v {
+			c, err := r.resolve(child, path+"["+strconv.Itoa(i)+"]")
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = c
+		}
+		return resolved, nil
+	default:
+		return value, nil
+	}
+}
+
+// resolveRef resolves the reference object obj with the reference ref.
+func (r *refResolver) resolveRef(obj map[string]interface{}, ref interface{}, path string) (interface{}, error) {
+	target, ok := ref.(string)
+	if !ok || target == "" {
+		return nil, fmt.Errorf("%s: %s must be a path such as \"modules.tasmota.custom.broker\"", path, refKey)
+	}
+	if r.resolving[target] {
+		return nil, fmt.Errorf("%s: circular reference to %s", path, target)
+	}
+
+	// The referenced value may contain references itself
+	r.resolving[target] = true
+	value, err := r.lookup(target)
+	if err == nil {
+		value, err = r.resolve(value, target)
+	}
+	delete(r.resolving, target)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(obj) == 1 {
+		return value, nil
+	}
+
+	base, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: %s references a %T, only objects can be combined with other keys", path, target, value)
+	}
+	for key, child := range obj {
+		if key == refKey {
+			continue
+		}
+		if base[key], err = r.resolve(child, path+"."+key); err != nil {
+			return nil, err
+		}
+	}
+	return base, nil
+}
+
+// lookup returns the value at a dot-separated path in the raw configuration.
+// Elements of arrays are addressed by their index, e.g. "outputs.0.url".
+// References on the way to the value are resolved.
+func (r *refResolver) lookup(path string) (interface{}, error) {
+	var value interface{} = r.raw
+	for _, key := range strings.Split(path, ".") {
+		if obj, ok := value.(map[string]interface{}); ok {
+			if ref, ok := obj[refKey]; ok {
+				resolved, err := r.resolveRef(obj, ref, path)
+				if err != nil {
+					return nil, err
+				}
+				value = resolved
+			}
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			child, ok := v[key]
+			if !ok {
+				return nil, fmt.Errorf("referenced setting %s not found", path)
+			}
+			value = child
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("referenced setting %s not found", path)
+			}
+			value = v[i]
+		default:
+			return nil, fmt.Errorf("referenced setting %s not found", path)
+		}
+	}
+	return value, nil
+}
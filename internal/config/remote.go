@@ -0,0 +1,205 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file contains the remote configuration source, which lets agents of
+// several households be managed from one central configuration file.
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// remoteFetchTimeout limits a single fetch of the remote configuration
+const remoteFetchTimeout = 30 * time.Second
+
+// maxRemoteConfigSize limits the size of a remote configuration file
+const maxRemoteConfigSize = 1 << 20
+
+// RemoteSource fetches the configuration file from a URL, e.g. a web server or
+// an S3 bucket, and caches it in a local file, which is then used like a local
+// configuration file. The agent keeps running with the cached file while the
+// URL can't be reached.
+//
+// With a public key, the file must be signed: the Ed25519 signature of the
+// file is fetched from the same URL with ".sig" appended to the path, either
+// raw or base64-encoded. Files with a missing or invalid signature are rejected.
+type RemoteSource struct {
+	url       string
+	publicKey ed25519.PublicKey
+	cachePath string
+	client    *http.Client
+}
+
+// NewRemoteSource creates a remote source fetching rawURL into cachePath.
+// publicKey is the base64-encoded Ed25519 public key the file is signed with,
+// or "" to accept unsigned files, which lets whoever can change the file or
+// the response control the agent.
+func NewRemoteSource(rawURL, publicKey, cachePath string) (*RemoteSource, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid configuration URL %q", rawURL)
+	}
+	if cachePath == "" {
+		return nil, fmt.Errorf("no cache path for the remote configuration")
+	}
+
+	source := &RemoteSource{
+		url:       rawURL,
+		cachePath: cachePath,
+		client:    &http.Client{Timeout: remoteFetchTimeout},
+	}
+	if publicKey != "" {
+		key, err := base64.StdEncoding.DecodeString(publicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key: expected %d base64-encoded bytes", ed25519.PublicKeySize)
+		}
+		source.publicKey = key
+	}
+	return source, nil
+}
+
+// CachePath returns the path of the cached configuration file.
+func (r *RemoteSource) CachePath() string {
+	return r.cachePath
+}
+
+// Fetch downloads the configuration file and writes it to the cache file if
+// it changed. It reports whether the cache file was changed. The ETag of the
+// cached file is sent along, so an unchanged file is not downloaded again.
+// A file that is not signed correctly or can't be loaded as configuration is
+// rejected and the cache file is kept.
+func (r *RemoteSource) Fetch(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return false, err
+	}
+	if etag := r.cachedETag(); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch configuration: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("failed to fetch configuration: unexpected status %d", resp.StatusCode)
+	}
+	data, err := readLimited(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("failed to read configuration: %w", err)
+	}
+
+	if r.publicKey != nil {
+		if err := r.verify(ctx, data); err != nil {
+			return false, err
+		}
+	}
+	if _, err := parseGlobalConfig(data); err != nil {
+		return false, fmt.Errorf("invalid remote configuration: %w", err)
+	}
+
+	cached, _ := os.ReadFile(r.cachePath)
+	changed := !bytes.Equal(cached, data)
+	if changed {
+		if err := utils.WriteFileAtomic(r.cachePath, data, 0600); err != nil {
+			return false, fmt.Errorf("failed to cache configuration: %w", err)
+		}
+	}
+	r.storeETag(resp.Header.Get("ETag"))
+	return changed, nil
+}
+
+// verify checks the signature of the configuration file.
+func (r *RemoteSource) verify(ctx context.Context, data []byte) error {
+	signatureURL, err := url.Parse(r.url)
+	if err != nil {
+		return err
+	}
+	signatureURL.Path += ".sig"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, signatureURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch configuration signature: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch configuration signature: unexpected status %d", resp.StatusCode)
+	}
+	signature, err := readLimited(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration signature: %w", err)
+	}
+
+	if len(signature) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+		if err != nil {
+			return fmt.Errorf("invalid configuration signature: %w", err)
+		}
+		signature = decoded
+	}
+	if !ed25519.Verify(r.publicKey, data, signature) {
+		return errors.New("configuration signature does not match the public key")
+	}
+	return nil
+}
+
+// etagPath returns the path of the file the ETag of the cached file is kept in.
+func (r *RemoteSource) etagPath() string {
+	return r.cachePath + ".etag"
+}
+
+// cachedETag returns the ETag of the cached file, or "" if it is unknown or
+// the cache file is missing.
+func (r *RemoteSource) cachedETag() string {
+	if _, err := os.Stat(r.cachePath); err != nil {
+		return ""
+	}
+	etag, err := os.ReadFile(r.etagPath())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(etag))
+}
+
+// storeETag keeps the ETag of the cached file for the next fetch.
+func (r *RemoteSource) storeETag(etag string) {
+	if etag == "" {
+		os.Remove(r.etagPath())
+		return
+	}
+	if err := utils.WriteFileAtomic(r.etagPath(), []byte(etag), 0600); err != nil {
+		utils.Warnf("Failed to store ETag of the remote configuration: %v", err)
+	}
+}
+
+// readLimited reads a response body up to maxRemoteConfigSize.
+func readLimited(body io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, maxRemoteConfigSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxRemoteConfigSize {
+		return nil, fmt.Errorf("larger than %d bytes", maxRemoteConfigSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// configServer serves a configuration file with ETag and its signature
+type configServer struct {
+	mu        sync.Mutex
+	data      []byte
+	etag      string
+	signature []byte
+	requests  int
+}
+
+func (s *configServer) set(data []byte, etag string, signature []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data, s.etag, s.signature = data, etag, signature
+}
+
+func (s *configServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.URL.Path {
+	case "/agent.json":
+		s.requests++
+		if r.Header.Get("If-None-Match") == s.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", s.etag)
+		w.Write(s.data)
+	case "/agent.json.sig":
+		if s.signature == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(s.signature)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRemoteSourceFetch(t *testing.T) {
+	server := &configServer{}
+	server.set([]byte(`{"log_level": "debug"}`), `"v1"`, nil)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	cachePath := filepath.Join(t.TempDir(), "remote-config.json")
+	source, err := NewRemoteSource(ts.URL+"/agent.json", "", cachePath)
+	if err != nil {
+		t.Fatalf("Failed to create remote source: %v", err)
+	}
+
+	changed, err := source.Fetch(context.Background())
+	if err != nil || !changed {
+		t.Fatalf("Expected first fetch to change the cache, got %v, %v", changed, err)
+	}
+	if cfg, err := LoadGlobalConfigFromPath(cachePath); err != nil || cfg.LogLevel != "debug" {
+		t.Errorf("Expected cached configuration to be loadable, got %v, %v", cfg, err)
+	}
+
+	// An unchanged file is not downloaded again
+	if changed, err := source.Fetch(context.Background()); err != nil || changed {
+		t.Errorf("Expected unchanged configuration, got %v, %v", changed, err)
+	}
+
+	server.set([]byte(`{"log_level": "warn"}`), `"v2"`, nil)
+	if changed, err := source.Fetch(context.Background()); err != nil || !changed {
+		t.Errorf("Expected changed configuration, got %v, %v", changed, err)
+	}
+
+	// Invalid files are rejected and the cached file is kept
+	server.set([]byte(`{"log_level": `), `"v3"`, nil)
+	if _, err := source.Fetch(context.Background()); err == nil {
+		t.Error("Expected error for invalid configuration")
+	}
+	if cfg, err := LoadGlobalConfigFromPath(cachePath); err != nil || cfg.LogLevel != "warn" {
+		t.Errorf("Expected the last valid configuration to be kept, got %v, %v", cfg, err)
+	}
+}
+
+func TestRemoteSourceSignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	data := []byte(`{"log_level": "debug"}`)
+
+	server := &configServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	cachePath := filepath.Join(t.TempDir(), "remote-config.json")
+	source, err := NewRemoteSource(ts.URL+"/agent.json", base64.StdEncoding.EncodeToString(publicKey), cachePath)
+	if err != nil {
+		t.Fatalf("Failed to create remote source: %v", err)
+	}
+
+	// Unsigned and wrongly signed files are rejected
+	server.set(data, `"v1"`, nil)
+	if _, err := source.Fetch(context.Background()); err == nil {
+		t.Error("Expected error for missing signature")
+	}
+	server.set(data, `"v1"`, ed25519.Sign(privateKey, []byte(`{"log_level": "error"}`)))
+	if _, err := source.Fetch(context.Background()); err == nil {
+		t.Error("Expected error for wrong signature")
+	}
+	if _, err := os.Stat(cachePath); err == nil {
+		t.Error("Expected no cached file for rejected configurations")
+	}
+
+	// Raw and base64-encoded signatures are accepted
+	server.set(data, `"v1"`, ed25519.Sign(privateKey, data))
+	if changed, err := source.Fetch(context.Background()); err != nil || !changed {
+		t.Errorf("Expected signed configuration to be cached, got %v, %v", changed, err)
+	}
+	os.Remove(cachePath)
+	server.set(data, `"v1"`, []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, data))+"\n"))
+	if changed, err := source.Fetch(context.Background()); err != nil || !changed {
+		t.Errorf("Expected configuration with base64 signature to be cached, got %v, %v", changed, err)
+	}
+}
+
+func TestNewRemoteSourceInvalid(t *testing.T) {
+	for _, tt := range []struct{ url, key string }{
+		{"ftp://example.com/agent.json", ""},
+		{"https:///agent.json", ""},
+		{"https://example.com/agent.json", "not-a-key"},
+	} {
+		if _, err := NewRemoteSource(tt.url, tt.key, "cache.json"); err == nil {
+			t.Errorf("Expected error for URL %q and key %q", tt.url, tt.key)
+		}
+	}
+}
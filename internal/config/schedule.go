@@ -0,0 +1,46 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file contains the collection schedule of a module.
+package config
+
+import (
+	"fmt"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// ScheduleWindow is a daily time window in which an interval-based module
+// collects, e.g. {"from": "06:00", "to": "23:00"}. A window ending before it
+// starts spans midnight.
+type ScheduleWindow struct {
+	// From is the start of the window in the global timezone ("HH:MM").
+	From string `json:"from"`
+
+	// To is the end of the window in the global timezone ("HH:MM", "24:00" for midnight).
+	To string `json:"to"`
+
+	// Interval overrides the module's collection interval within the window.
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// ParseSchedule parses the collection schedule of a module. It returns nil if
+// no windows are configured, which means the module always collects.
+func ParseSchedule(windows []ScheduleWindow) (*utils.Schedule, error) {
+	parsed := make([]utils.ScheduleWindow, 0, len(windows))
+	for _, w := range windows {
+		from, err := utils.ParseTimeOfDay(w.From)
+		if err != nil {
+			return nil, fmt.Errorf("schedule: %w", err)
+		}
+		to, err := utils.ParseTimeOfDay(w.To)
+		if err != nil {
+			return nil, fmt.Errorf("schedule: %w", err)
+		}
+		parsed = append(parsed, utils.ScheduleWindow{From: from, To: to, Interval: w.Interval.Duration()})
+	}
+	schedule, err := utils.NewSchedule(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("schedule: %w", err)
+	}
+	return schedule, nil
+}
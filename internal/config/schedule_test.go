@@ -0,0 +1,33 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	schedule, err := ParseSchedule(nil)
+	if err != nil || schedule != nil {
+		t.Errorf("Expected no schedule without windows, got %v, %v", schedule, err)
+	}
+
+	schedule, err = ParseSchedule([]ScheduleWindow{{From: "06:00", To: "23:00"}, {From: "23:00", To: "06:00", Interval: Duration(30 * time.Minute)}})
+	if err != nil {
+		t.Fatalf("ParseSchedule failed: %v", err)
+	}
+	if !schedule.Active(time.Date(2025, 1, 1, 3, 0, 0, 0, time.Local)) {
+		t.Error("Expected window spanning midnight to be active at 03:00")
+	}
+
+	invalid := [][]ScheduleWindow{
+		{{From: "6", To: "23:00"}},
+		{{From: "06:00", To: "25:00"}},
+		{{From: "06:00", To: "06:00"}},
+		{{From: "06:00", To: "23:00", Interval: Duration(-time.Minute)}},
+	}
+	for _, windows := range invalid {
+		if _, err := ParseSchedule(windows); err == nil {
+			t.Errorf("Expected error for %+v", windows)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file generates a JSON Schema of the configuration file, so editors can
+// validate and autocomplete metrics-agent.json.
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// schemaDraft is the JSON Schema dialect of the generated schema
+const schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+var (
+	durationType       = reflect.TypeOf(time.Duration(0))
+	configDurationType = reflect.TypeOf(Duration(0))
+	baseConfigType     = reflect.TypeOf(BaseConfig{})
+)
+
+// Schema returns a JSON Schema of the configuration file. moduleConfigs maps
+// module names to their Config structs, whose fields describe the module's
+// "custom" settings in the module and instance sections.
+func Schema(moduleConfigs map[string]interface{}) map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(GlobalConfig{}))
+	schema["$schema"] = schemaDraft
+	schema["title"] = "metrics-agent configuration"
+
+	names := make([]string, 0, len(moduleConfigs))
+	for name := range moduleConfigs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	modules := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		modules[name] = moduleSchema(reflect.TypeOf(moduleConfigs[name]))
+	}
+	properties := schema["properties"].(map[string]interface{})
+	// Allow referencing the schema from the configuration file
+	properties["$schema"] = map[string]interface{}{"type": "string"}
+	properties["modules"] = map[string]interface{}{
+		"type":                 "object",
+		"properties":           modules,
+		"additionalProperties": typeSchema(reflect.TypeOf(ModuleConfig{})),
+	}
+	return schema
+}
+
+// moduleSchema returns the schema of a module section whose custom settings
+// are the fields of the module's Config struct.
+func moduleSchema(configType reflect.Type) map[string]interface{} {
+	custom := customSchema(configType)
+
+	instance := typeSchema(reflect.TypeOf(InstanceConfig{}))
+	instance["properties"].(map[string]interface{})["custom"] = custom
+
+	module := typeSchema(reflect.TypeOf(ModuleConfig{}))
+	moduleProperties := module["properties"].(map[string]interface{})
+	moduleProperties["custom"] = custom
+	moduleProperties["instances"] = map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": instance,
+	}
+	return module
+}
+
+// customSchema returns the schema of the custom settings of a module. The
+// embedded BaseConfig holds the module section itself and is left out, as the
+// loader does when applying custom settings.
+func customSchema(configType reflect.Type) map[string]interface{} {
+	for configType.Kind() == reflect.Pointer {
+		configType = configType.Elem()
+	}
+	if configType.Kind() != reflect.Struct {
+		return map[string]interface{}{"type": "object"}
+	}
+
+	properties := make(map[string]interface{})
+	addFields(properties, configType, true)
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// typeSchema returns the schema of a Go type as encoded by encoding/json.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t == durationType || t == configDurationType {
+		return map[string]interface{}{
+			"type":        "string",
+			"description": "Duration, e.g. \"30s\" or \"10m\"",
+			"pattern":     `^(0|([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+)$`,
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		addFields(properties, t, false)
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	default:
+		// interface{} and other types accept any value
+		return map[string]interface{}{}
+	}
+}
+
+// addFields adds the JSON properties of a struct's fields. Embedded structs
+// without a JSON name are inlined. skipBase leaves out embedded BaseConfig.
+func addFields(properties map[string]interface{}, t reflect.Type, skipBase bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			if skipBase && field.Type == baseConfigType {
+				continue
+			}
+			addFields(properties, field.Type, skipBase)
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type)
+	}
+}
+
+// Setting describes a configuration key and its type.
+type Setting struct {
+	Name string
+	Type string // e.g. "string", "duration" or "array of string"
+}
+
+// CustomSettings returns the custom settings of a module, given its Config
+// struct, sorted by name.
+func CustomSettings(cfg interface{}) []Setting {
+	return settings(customSchema(reflect.TypeOf(cfg)))
+}
+
+// ModuleSettings returns the settings every module section supports, sorted by name.
+func ModuleSettings() []Setting {
+	return settings(typeSchema(reflect.TypeOf(ModuleConfig{})))
+}
+
+// settings returns the properties of an object schema sorted by name.
+func settings(schema map[string]interface{}) []Setting {
+	properties, _ := schema["properties"].(map[string]interface{})
+	result := make([]Setting, 0, len(properties))
+	for name, property := range properties {
+		result = append(result, Setting{Name: name, Type: schemaTypeName(property.(map[string]interface{}))})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// schemaTypeName returns a short, readable name of the type described by a schema.
+func schemaTypeName(schema map[string]interface{}) string {
+	if _, ok := schema["pattern"]; ok {
+		return "duration"
+	}
+	switch schema["type"] {
+	case nil:
+		return "any"
+	case "array":
+		return "array of " + schemaTypeName(schema["items"].(map[string]interface{}))
+	case "object":
+		if values, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+			return "map of " + schemaTypeName(values)
+		}
+		return "object"
+	default:
+		return schema["type"].(string)
+	}
+}
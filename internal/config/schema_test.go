@@ -0,0 +1,112 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchema(t *testing.T) {
+	type testModuleConfig struct {
+		BaseConfig
+		MQTTOptions
+
+		Broker   string            `json:"broker"`
+		Timeout  time.Duration     `json:"timeout,omitempty"`
+		Devices  []string          `json:"devices"`
+		Names    map[string]string `json:"names"`
+		Instance string            `json:"-"`
+	}
+
+	schema := Schema(map[string]interface{}{"test": testModuleConfig{}})
+	if schema["$schema"] != schemaDraft {
+		t.Errorf("Expected $schema %s, got %v", schemaDraft, schema["$schema"])
+	}
+
+	properties := schema["properties"].(map[string]interface{})
+	for _, name := range []string{"log_level", "module_restart_limit", "pipeline", "notify", "modules"} {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("Expected global setting %s in schema", name)
+		}
+	}
+	if _, ok := properties["ModuleErrors"]; ok {
+		t.Errorf("Expected fields tagged json:\"-\" to be left out")
+	}
+
+	modules := properties["modules"].(map[string]interface{})["properties"].(map[string]interface{})
+	module := modules["test"].(map[string]interface{})["properties"].(map[string]interface{})
+	for _, name := range []string{"enabled", "friendly_name_overrides", "custom", "instances"} {
+		if _, ok := module[name]; !ok {
+			t.Errorf("Expected module setting %s in schema", name)
+		}
+	}
+
+	custom := module["custom"].(map[string]interface{})["properties"].(map[string]interface{})
+	expected := map[string]string{
+		"broker":        "string",
+		"timeout":       "string",
+		"devices":       "array",
+		"names":         "object",
+		"qos":           "integer",
+		"clean_session": "boolean",
+		"max_in_flight": "integer",
+	}
+	if len(custom) != len(expected) {
+		t.Errorf("Expected custom settings %v, got %v", expected, custom)
+	}
+	for name, typ := range expected {
+		setting, ok := custom[name].(map[string]interface{})
+		if !ok {
+			t.Errorf("Expected custom setting %s in schema", name)
+			continue
+		}
+		if setting["type"] != typ {
+			t.Errorf("Expected custom setting %s to be %s, got %v", name, typ, setting["type"])
+		}
+	}
+
+	instances := module["instances"].(map[string]interface{})["additionalProperties"].(map[string]interface{})
+	instanceCustom := instances["properties"].(map[string]interface{})["custom"].(map[string]interface{})
+	if _, ok := instanceCustom["properties"].(map[string]interface{})["broker"]; !ok {
+		t.Errorf("Expected instance custom settings to match the module's")
+	}
+}
+
+func TestCustomSettings(t *testing.T) {
+	type testModuleConfig struct {
+		BaseConfig
+
+		Broker   string            `json:"broker"`
+		Interval Duration          `json:"interval,omitempty"`
+		Devices  []string          `json:"devices"`
+		Names    map[string]string `json:"names"`
+		Raw      interface{}       `json:"raw"`
+		Instance string            `json:"-"`
+	}
+
+	expected := []Setting{
+		{Name: "broker", Type: "string"},
+		{Name: "devices", Type: "array of string"},
+		{Name: "interval", Type: "duration"},
+		{Name: "names", Type: "map of string"},
+		{Name: "raw", Type: "any"},
+	}
+	settings := CustomSettings(testModuleConfig{})
+	if len(settings) != len(expected) {
+		t.Fatalf("Expected settings %v, got %v", expected, settings)
+	}
+	for i, setting := range settings {
+		if setting != expected[i] {
+			t.Errorf("Expected setting %v, got %v", expected[i], setting)
+		}
+	}
+
+	found := false
+	for _, setting := range ModuleSettings() {
+		if setting.Name == "enabled" && setting.Type == "boolean" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected module setting enabled in %v", ModuleSettings())
+	}
+}
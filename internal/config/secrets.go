@@ -0,0 +1,81 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file contains the resolution of secret references such as
+// "${env:TIBBER_TOKEN}" in the configuration file (see utils.ResolveSecret).
+package config
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// resolveSecrets replaces the secret references in a raw configuration with
+// their secrets. A reference in a module section that can't be resolved only
+// fails that module, which is returned in moduleErrors; sections of disabled
+// modules are not resolved. Any other unresolvable reference fails the
+// whole configuration.
+func resolveSecrets(ctx context.Context, raw map[string]interface{}) (moduleErrors map[string]error, err error) {
+	for key, value := range raw {
+		if key == "modules" {
+			continue
+		}
+		if raw[key], err = resolveSecretValue(ctx, value, key); err != nil {
+			return nil, err
+		}
+	}
+
+	modules, _ := raw["modules"].(map[string]interface{})
+	for name, section := range modules {
+		if enabled, _ := moduleSection(raw, name)["enabled"].(bool); !enabled {
+			continue
+		}
+		resolved, err := resolveSecretValue(ctx, section, "modules."+name)
+		if err != nil {
+			if moduleErrors == nil {
+				moduleErrors = make(map[string]error)
+			}
+			moduleErrors[name] = &ModuleError{Module: name, Err: err}
+			continue
+		}
+		modules[name] = resolved
+	}
+	return moduleErrors, nil
+}
+
+// resolveSecretValue resolves the secret references in a raw value and its
+// children. path locates the value in error messages.
+func resolveSecretValue(ctx context.Context, value interface{}, path string) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		secret, err := utils.ResolveSecret(ctx, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return secret, nil
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			r, err := resolveSecretValue(ctx, child, path+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			resolved[key] = r
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+		for i, child := range v {
+			r, err := resolveSecretValue(ctx, child, path+"["+strconv.Itoa(i)+"]")
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = r
+		}
+		return resolved, nil
+	default:
+		return value, nil
+	}
+}
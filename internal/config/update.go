@@ -0,0 +1,32 @@
+// Package config provides configuration management for the metrics agent.
+//
+// This file contains the configuration of the update check.
+package config
+
+// DefaultUpdateRepository is the GitHub repository releases are looked up in.
+const DefaultUpdateRepository = "janhuddel/metrics-agent"
+
+// UpdateConfig configures checking GitHub releases for a newer version of the
+// agent and, optionally, installing it. Updates are only checked for release
+// builds; development builds are never updated.
+type UpdateConfig struct {
+	// CheckInterval is how often the latest release is looked up (e.g. "24h").
+	// If not set, no updates are checked.
+	CheckInterval Duration `json:"check_interval,omitempty"`
+
+	// AutoInstall downloads a newer release, replaces the binary and exits, so
+	// that telegraf or systemd start the new version. If the new version fails
+	// to start repeatedly, the previous binary is restored.
+	// If not set, a newer release is only logged and shown in the status.
+	// Requires PublicKey.
+	AutoInstall bool `json:"auto_install,omitempty"`
+
+	// PublicKey is the base64-encoded Ed25519 public key release archives
+	// must be signed with. Releases are not installed without it, as the
+	// checksum is downloaded from the same origin as the archive.
+	PublicKey string `json:"public_key,omitempty"`
+
+	// Repository is the GitHub repository ("owner/name") releases are looked up
+	// in. Defaults to "janhuddel/metrics-agent".
+	Repository string `json:"repository,omitempty"`
+}
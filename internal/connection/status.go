@@ -0,0 +1,142 @@
+// Package connection provides the "connection_status" self-metric that modules
+// emit for their upstream connections (MQTT brokers, websockets, HTTP APIs).
+//
+// A Tracker is created per connection and informed about every connect, loss or
+// poll result. It sends a metric only when the state changes, so dashboards can
+// alert on status=0 and count reconnects without being flooded with samples.
+package connection
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/connstate"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// Measurement is the name of the connection status metric
+const Measurement = "connection_status"
+
+// Tracker tracks the state of a single upstream connection and sends a
+// connection_status metric whenever it changes.
+type Tracker struct {
+	module   string
+	endpoint string
+	ch       chan<- metrics.Metric
+
+	mu            sync.Mutex
+	known         bool
+	connected     bool
+	everConnected bool
+	reconnects    int
+}
+
+// NewTracker creates a tracker for the connection of a module to an endpoint.
+// The module name should include the instance (e.g. "tasmota.haus1").
+func NewTracker(module, endpoint string, ch chan<- metrics.Metric) *Tracker {
+	return &Tracker{
+		module:   module,
+		endpoint: endpoint,
+		ch:       ch,
+	}
+}
+
+// SetConnected records the current connection state. A metric is sent on the
+// first call and on every change. Re-establishing a lost connection increments
+// the reconnect counter.
+func (t *Tracker) SetConnected(connected bool) {
+	t.mu.Lock()
+	if t.known && t.connected == connected {
+		t.mu.Unlock()
+		return
+	}
+	if connected {
+		if t.everConnected {
+			t.reconnects++
+		}
+		t.everConnected = true
+	}
+	t.known = true
+	t.connected = connected
+	metric := t.metric()
+	t.mu.Unlock()
+
+	if connected {
+		utils.Infof("[%s] connection to %s established", t.module, t.endpoint)
+	} else {
+		utils.Warnf("[%s] connection to %s lost", t.module, t.endpoint)
+	}
+
+	if t.ch == nil {
+		return
+	}
+	select {
+	case t.ch <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping connection status metric")
+	}
+}
+
+// SetPollResult records the outcome of an HTTP request. The endpoint counts as
+// connected when it answered, even with a client error such as 401, and as lost
+// on transport errors and server errors.
+func (t *Tracker) SetPollResult(resp *http.Response, err error) {
+	t.SetConnected(err == nil && resp != nil && resp.StatusCode < http.StatusInternalServerError)
+}
+
+// HandleWebSocketState is a websocket.StateChangeHandler that reports the
+// connection as established or lost. Transitions between the disconnected
+// states (connecting, reconnecting, ...) are ignored.
+func (t *Tracker) HandleWebSocketState(oldState, newState connstate.State) {
+	if oldState != connstate.Connected && newState != connstate.Connected {
+		return
+	}
+	t.SetConnected(newState == connstate.Connected)
+}
+
+// Stats is a snapshot of the state of a tracked connection.
+type Stats struct {
+	Known      bool // Whether the state was reported at least once
+	Connected  bool // Whether the connection is currently up
+	Reconnects int  // How often a lost connection was re-established
+}
+
+// Stats returns a snapshot of the connection state. It is safe to call while
+// the module reports to the tracker.
+func (t *Tracker) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return Stats{Known: t.known, Connected: t.connected, Reconnects: t.reconnects}
+}
+
+// Connected reports whether the connection is currently up.
+func (t *Tracker) Connected() bool {
+	return t.Stats().Connected
+}
+
+// Reconnects returns how often a lost connection was re-established.
+func (t *Tracker) Reconnects() int {
+	return t.Stats().Reconnects
+}
+
+// metric builds the connection status metric. The caller must hold t.mu.
+func (t *Tracker) metric() metrics.Metric {
+	status := 0
+	if t.connected {
+		status = 1
+	}
+	return metrics.Metric{
+		Name: Measurement,
+		Tags: map[string]string{
+			"module":   t.module,
+			"endpoint": t.endpoint,
+		},
+		Fields: map[string]interface{}{
+			"status":     status,
+			"reconnects": t.reconnects,
+		},
+		Timestamp: time.Now(),
+	}
+}
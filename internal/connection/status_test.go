@@ -0,0 +1,114 @@
+package connection
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/pkg/connstate"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestTracker(t *testing.T) {
+	ch := make(chan metrics.Metric, 10)
+	tracker := NewTracker("tasmota.haus1", "tcp://broker:1883", ch)
+	if stats := tracker.Stats(); stats != (Stats{}) {
+		t.Errorf("Expected unknown state before the first report, got %+v", stats)
+	}
+
+	tracker.SetConnected(true)
+	tracker.SetConnected(true) // unchanged, no metric
+	tracker.SetConnected(false)
+	tracker.SetConnected(true)
+
+	if len(ch) != 3 {
+		t.Fatalf("Expected 3 metrics, got %d", len(ch))
+	}
+
+	expected := []struct {
+		status     int
+		reconnects int
+	}{
+		{1, 0},
+		{0, 0},
+		{1, 1},
+	}
+	for i, want := range expected {
+		metric := <-ch
+		if metric.Name != Measurement {
+			t.Errorf("metric %d: expected name %s, got %s", i, Measurement, metric.Name)
+		}
+		if metric.Tags["module"] != "tasmota.haus1" || metric.Tags["endpoint"] != "tcp://broker:1883" {
+			t.Errorf("metric %d: unexpected tags %v", i, metric.Tags)
+		}
+		if metric.Fields["status"] != want.status || metric.Fields["reconnects"] != want.reconnects {
+			t.Errorf("metric %d: expected status=%d reconnects=%d, got %v", i, want.status, want.reconnects, metric.Fields)
+		}
+	}
+
+	if !tracker.Connected() || tracker.Reconnects() != 1 {
+		t.Errorf("Expected connected with 1 reconnect, got %v/%d", tracker.Connected(), tracker.Reconnects())
+	}
+	if stats := tracker.Stats(); stats != (Stats{Known: true, Connected: true, Reconnects: 1}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestTrackerInitiallyDisconnected(t *testing.T) {
+	ch := make(chan metrics.Metric, 10)
+	tracker := NewTracker("dwd", "https://example.com", ch)
+
+	// The first failure is reported, the first success afterwards is not a reconnect
+	tracker.SetConnected(false)
+	tracker.SetConnected(true)
+
+	<-ch
+	metric := <-ch
+	if metric.Fields["status"] != 1 || metric.Fields["reconnects"] != 0 {
+		t.Errorf("Unexpected fields %v", metric.Fields)
+	}
+}
+
+func TestTrackerPollResult(t *testing.T) {
+	tracker := NewTracker("proxmox", "https://pve:8006", nil)
+
+	tests := []struct {
+		statusCode int
+		err        error
+		connected  bool
+	}{
+		{200, nil, true},
+		{401, nil, true},
+		{503, nil, false},
+		{0, errors.New("connection refused"), false},
+	}
+	for _, tt := range tests {
+		var resp *http.Response
+		if tt.err == nil {
+			resp = &http.Response{StatusCode: tt.statusCode}
+		}
+		tracker.SetPollResult(resp, tt.err)
+		if tracker.Connected() != tt.connected {
+			t.Errorf("status %d, err %v: expected connected=%v", tt.statusCode, tt.err, tt.connected)
+		}
+	}
+}
+
+func TestTrackerWebSocketState(t *testing.T) {
+	ch := make(chan metrics.Metric, 10)
+	tracker := NewTracker("opendtu", "ws://opendtu/livedata", ch)
+
+	tracker.HandleWebSocketState(connstate.Disconnected, connstate.Connecting)
+	tracker.HandleWebSocketState(connstate.Connecting, connstate.Connected)
+	tracker.HandleWebSocketState(connstate.Connected, connstate.Disconnected)
+	tracker.HandleWebSocketState(connstate.Disconnected, connstate.Reconnecting)
+	tracker.HandleWebSocketState(connstate.Reconnecting, connstate.Connecting)
+	tracker.HandleWebSocketState(connstate.Connecting, connstate.Connected)
+
+	if len(ch) != 3 {
+		t.Fatalf("Expected 3 metrics, got %d", len(ch))
+	}
+	if tracker.Reconnects() != 1 {
+		t.Errorf("Expected 1 reconnect, got %d", tracker.Reconnects())
+	}
+}
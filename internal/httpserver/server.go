@@ -0,0 +1,160 @@
+// Package httpserver provides the HTTP server of the agent, which serves its
+// status and control endpoints.
+//
+// The server binds to localhost unless another host is configured explicitly.
+// All endpoints share the configured authentication (bearer token and/or
+// basic auth) and TLS, so endpoints registered with Handle are never exposed
+// without them.
+package httpserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// readHeaderTimeout limits how long clients may take to send request headers
+const readHeaderTimeout = 10 * time.Second
+
+// Server serves the registered endpoints behind the configured authentication.
+type Server struct {
+	cfg    config.HTTPConfig
+	mux    *http.ServeMux
+	server *http.Server
+	addr   net.Addr
+}
+
+// New creates a server from the configuration. It returns nil if no listen
+// address is configured.
+func New(cfg config.HTTPConfig) *Server {
+	if cfg.Listen == "" {
+		return nil
+	}
+	return &Server{cfg: cfg, mux: http.NewServeMux()}
+}
+
+// Handle registers the handler for the pattern. Requests are authenticated
+// before they reach the handler.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	if s == nil {
+		return
+	}
+	s.mux.Handle(pattern, handler)
+}
+
+// HandleFunc registers the handler function for the pattern.
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// ServeHTTP authenticates the request and passes it to the registered handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authenticate(r) {
+		if auth := s.cfg.Auth; auth != nil && auth.Username != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics-agent", charset="UTF-8"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics-agent"`)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// authenticate reports whether the request carries valid credentials. Without
+// configured authentication all requests are accepted.
+func (s *Server) authenticate(r *http.Request) bool {
+	auth := s.cfg.Auth
+	if auth == nil {
+		return true
+	}
+
+	if auth.BearerToken != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && equal(token, auth.BearerToken) {
+			return true
+		}
+	}
+	if auth.Username != "" {
+		if username, password, ok := r.BasicAuth(); ok {
+			// Compare both to not reveal which one was wrong through timing
+			validUsername := equal(username, auth.Username)
+			validPassword := equal(password, auth.Password)
+			return validUsername && validPassword
+		}
+	}
+	return false
+}
+
+// equal compares two secrets in constant time.
+func equal(given, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// Start binds the listen address and serves requests in the background.
+// Errors while serving are logged.
+func (s *Server) Start() error {
+	if s == nil {
+		return nil
+	}
+
+	address := s.cfg.ListenAddress()
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+	s.addr = listener.Addr()
+	s.server = &http.Server{Handler: s, ReadHeaderTimeout: readHeaderTimeout}
+
+	scheme := "http"
+	if s.cfg.TLS != nil {
+		scheme = "https"
+	}
+	if s.cfg.Auth == nil && !isLoopback(listener.Addr()) {
+		utils.Warnf("HTTP server on %s is reachable from the network without authentication", listener.Addr())
+	}
+
+	go utils.WithPanicRecoveryAndContinue("HTTP server", "main", func() {
+		utils.Infof("Serving HTTP endpoints on %s://%s", scheme, listener.Addr())
+		var err error
+		if s.cfg.TLS != nil {
+			err = s.server.ServeTLS(listener, s.cfg.TLS.CertFile, s.cfg.TLS.KeyFile)
+		} else {
+			err = s.server.Serve(listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			utils.Errorf("HTTP server stopped: %v", err)
+		}
+	})
+	return nil
+}
+
+// Addr returns the address the server is bound to, or nil if it was not started.
+func (s *Server) Addr() net.Addr {
+	if s == nil {
+		return nil
+	}
+	return s.addr
+}
+
+// Stop shuts the server down, waiting for active requests until the context
+// expires.
+func (s *Server) Stop(ctx context.Context) error {
+	if s == nil || s.server == nil {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
+
+// isLoopback reports whether the address is a loopback address.
+func isLoopback(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
@@ -0,0 +1,167 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+)
+
+func newTestServer(auth *config.HTTPAuthConfig) *Server {
+	s := New(config.HTTPConfig{Listen: ":0", Auth: auth})
+	s.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	return s
+}
+
+func TestNewWithoutListen(t *testing.T) {
+	s := New(config.HTTPConfig{})
+	if s != nil {
+		t.Fatal("Expected nil server without listen address")
+	}
+	// A nil server ignores all calls
+	s.HandleFunc("/status", func(http.ResponseWriter, *http.Request) {})
+	if err := s.Start(); err != nil {
+		t.Errorf("Expected no error starting a nil server, got %v", err)
+	}
+	if err := s.Stop(context.Background()); err != nil {
+		t.Errorf("Expected no error stopping a nil server, got %v", err)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	auth := &config.HTTPAuthConfig{BearerToken: "token", Username: "admin", Password: "secret"}
+
+	tests := []struct {
+		name     string
+		auth     *config.HTTPAuthConfig
+		prepare  func(r *http.Request)
+		expected int
+	}{
+		{"no auth configured", nil, func(r *http.Request) {}, http.StatusOK},
+		{"missing credentials", auth, func(r *http.Request) {}, http.StatusUnauthorized},
+		{"valid bearer token", auth, func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }, http.StatusOK},
+		{"wrong bearer token", auth, func(r *http.Request) { r.Header.Set("Authorization", "Bearer other") }, http.StatusUnauthorized},
+		{"valid basic auth", auth, func(r *http.Request) { r.SetBasicAuth("admin", "secret") }, http.StatusOK},
+		{"wrong password", auth, func(r *http.Request) { r.SetBasicAuth("admin", "wrong") }, http.StatusUnauthorized},
+		{"basic auth not configured", &config.HTTPAuthConfig{BearerToken: "token"}, func(r *http.Request) { r.SetBasicAuth("", "token") }, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(tt.auth)
+			req := httptest.NewRequest(http.MethodGet, "/status", nil)
+			tt.prepare(req)
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, rec.Code)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected WWW-Authenticate header on unauthorized response")
+			}
+		})
+	}
+}
+
+func TestStartBindsLocalhost(t *testing.T) {
+	s := newTestServer(nil)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer s.Stop(context.Background())
+
+	addr := s.Addr().(*net.TCPAddr)
+	if !addr.IP.IsLoopback() {
+		t.Errorf("Expected server bound to localhost, got %s", addr)
+	}
+
+	resp, err := http.Get("http://" + addr.String() + "/status")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	certFile, keyFile, pool := writeTestCertificate(t)
+	s := New(config.HTTPConfig{Listen: ":0", TLS: &config.TLSConfig{CertFile: certFile, KeyFile: keyFile}})
+	s.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer s.Stop(context.Background())
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.Get("https://" + s.Addr().String() + "/status")
+	if err != nil {
+		t.Fatalf("TLS request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("Expected 200 ok, got %d %q", resp.StatusCode, body)
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and
+// returns the paths of the certificate and key and a pool trusting it.
+func writeTestCertificate(t *testing.T) (string, string, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "metrics-agent"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
@@ -3,26 +3,51 @@ package metricchannel
 
 import (
 	"context"
-	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
 
-	"github.com/janhuddel/metrics-agent/internal/metrics"
+	"github.com/janhuddel/metrics-agent/internal/output"
+	"github.com/janhuddel/metrics-agent/internal/processors"
 	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
 )
 
+// Exporter receives every metric after processing, in addition to the line
+// protocol written to stdout.
+type Exporter interface {
+	Export(m metrics.Metric)
+}
+
 // Channel manages a buffered channel for metrics and handles serialization.
 type Channel struct {
-	metricCh chan metrics.Metric
-	ctx      context.Context
-	cancel   context.CancelFunc
+	metricCh  chan metrics.Metric
+	processor processors.Processor
+	exporters []Exporter
+	output    *utils.LineWriter
+	shards    int
+	shardChs  atomic.Pointer[[]chan metrics.Metric] // set by StartSerializer, read by Stats
+	reorder   *reorderBuffer
+	limits    *limiter
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	// Stats of the writes to the output, updated by all shards
+	writeFailures atomic.Int64
+	writeDuration atomic.Int64 // of the last write in nanoseconds
+	lastWrite     atomic.Int64 // of the last successful write in Unix nanoseconds
 }
 
-// New creates a new metric channel with the specified buffer size.
+// New creates a new metric channel with the specified buffer size. The
+// serializer shards use the same buffer size.
 func New(bufferSize int) *Channel {
 	metricCh := make(chan metrics.Metric, bufferSize)
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &Channel{
 		metricCh: metricCh,
+		output:   utils.Stdout(),
+		shards:   1,
 		ctx:      ctx,
 		cancel:   cancel,
 	}
@@ -33,37 +58,224 @@ func (c *Channel) Get() chan metrics.Metric {
 	return c.metricCh
 }
 
-// StartSerializer starts a goroutine that serializes metrics from the channel
-// and writes them to stdout in Line Protocol format.
+// SetProcessor sets the processor applied to each metric before serialization.
+// It must be called before StartSerializer.
+func (c *Channel) SetProcessor(processor processors.Processor) {
+	c.processor = processor
+}
+
+// AddExporter adds an exporter that receives each processed metric.
+// It must be called before StartSerializer.
+func (c *Channel) AddExporter(exporter Exporter) {
+	c.exporters = append(c.exporters, exporter)
+}
+
+// SetOutput sets the writer the Line Protocol is written to instead of stdout.
+// It must be called before StartSerializer.
+func (c *Channel) SetOutput(output *utils.LineWriter) {
+	c.output = output
+}
+
+// SetShards sets the number of goroutines that process and serialize metrics.
+// Metrics are assigned to a shard by their measurement and device, so the
+// metrics of a series stay in order, while a slow processor or exporter only
+// delays the metrics of its shard until the shard's buffer is full. Values
+// below 1 use a single goroutine. It must be called before StartSerializer.
+func (c *Channel) SetShards(shards int) {
+	c.shards = max(shards, 1)
+}
+
+// SetReorderWindow holds the processed metrics for the window and writes them
+// sorted by timestamp, so metrics with historical timestamps interleaved with
+// live metrics are written in order. Metrics are only ordered within a window,
+// and are delayed by up to the window. Zero disables reordering. It must be
+// called before StartSerializer.
+func (c *Channel) SetReorderWindow(window time.Duration) {
+	c.reorder = nil
+	if window > 0 {
+		c.reorder = newReorderBuffer(window, c.write)
+	}
+}
+
+// SetLimits restricts the size of the written lines. Metrics exceeding a limit
+// are truncated or dropped with a warning, and counted (see LimitStats). It
+// must be called before StartSerializer.
+func (c *Channel) SetLimits(limits Limits) {
+	c.limits = &limiter{limits: limits}
+	if !c.limits.enabled() {
+		c.limits = nil
+	}
+}
+
+// LimitStats returns the number of metrics truncated and dropped because they
+// exceeded the limits set with SetLimits.
+func (c *Channel) LimitStats() (truncated, dropped int64) {
+	if c.limits == nil {
+		return 0, 0
+	}
+	return c.limits.truncated.Load(), c.limits.dropped.Load()
+}
+
+// StartSerializer starts the goroutines that serialize metrics from the channel
+// and write them to stdout in Line Protocol format.
 func (c *Channel) StartSerializer() {
-	go func() {
-		utils.WithPanicRecoveryAndContinue("Metric serializer", "worker", func() {
-			for {
+	if c.reorder != nil {
+		go c.reorder.run(c.ctx.Done())
+	}
+	if c.shards == 1 {
+		// Fast path: serialize directly from the channel without dispatching
+		go c.serialize(c.metricCh)
+		return
+	}
+
+	shards := make([]chan metrics.Metric, c.shards)
+	for i := range shards {
+		shards[i] = make(chan metrics.Metric, cap(c.metricCh))
+		go c.serialize(shards[i])
+	}
+	c.shardChs.Store(&shards)
+	go utils.WithPanicRecoveryAndContinue("Metric dispatcher", "worker", func() {
+		defer func() {
+			for _, shard := range shards {
+				close(shard)
+			}
+		}()
+		for {
+			select {
+			case m, ok := <-c.metricCh:
+				if !ok {
+					// Channel closed, exit
+					return
+				}
 				select {
-				case m, ok := <-c.metricCh:
-					if !ok {
-						// Channel closed, exit
-						return
+				case shards[shardIndex(m, len(shards))] <- m:
+				case <-c.ctx.Done():
+					return
+				}
+			case <-c.ctx.Done():
+				// Context cancelled, exit
+				return
+			}
+		}
+	})
+}
+
+// serialize processes, exports and writes the metrics of a channel until it
+// is closed or the context is cancelled.
+func (c *Channel) serialize(in <-chan metrics.Metric) {
+	utils.WithPanicRecoveryAndContinue("Metric serializer", "worker", func() {
+		for {
+			select {
+			case m, ok := <-in:
+				if !ok {
+					// Channel closed, exit
+					return
+				}
+				if expander, ok := c.processor.(processors.Expander); ok {
+					for _, m := range expander.Expand(m) {
+						c.emit(m)
 					}
-					line, err := m.ToLineProtocolSafe()
-					if err != nil {
-						utils.Errorf("[worker] serialization error: %v", err)
+					continue
+				}
+				if c.processor != nil {
+					var keep bool
+					if m, keep = c.processor.Process(m); !keep {
 						continue
 					}
-					fmt.Println(line) // Write directly to stdout
-				case <-c.ctx.Done():
-					// Context cancelled, exit
-					return
 				}
+				c.emit(m)
+			case <-c.ctx.Done():
+				// Context cancelled, exit
+				return
 			}
-		})
-	}()
+		}
+	})
+}
+
+// emit writes a processed metric, or adds it to the reorder buffer.
+func (c *Channel) emit(m metrics.Metric) {
+	if c.reorder != nil {
+		c.reorder.add(m)
+		return
+	}
+	c.write(m)
+}
+
+// write passes a processed metric to the exporters and writes it as Line Protocol.
+func (c *Channel) write(m metrics.Metric) {
+	if c.limits != nil {
+		var keep bool
+		if m, keep = c.limits.limitFields(m); !keep {
+			return
+		}
+	}
+	for _, exporter := range c.exporters {
+		exporter.Export(m)
+	}
+	line, err := m.ToLineProtocolSafe()
+	if err != nil {
+		utils.Errorf("[worker] serialization error: %v", err)
+		return
+	}
+	if c.limits != nil {
+		var keep bool
+		if line, keep = c.limits.limitLine(m, line); !keep {
+			return
+		}
+	}
+	// Write through the shared line writer to keep lines atomic
+	start := time.Now()
+	err = c.output.WriteLine(line)
+	now := time.Now()
+	c.writeDuration.Store(int64(now.Sub(start)))
+	if err != nil {
+		c.writeFailures.Add(1)
+		utils.Errorf("[worker] write error: %v", err)
+		return
+	}
+	c.lastWrite.Store(now.UnixNano())
+}
+
+// Stats returns the state of the output the Line Protocol is written to. The
+// queued metrics are those in the channel and the shard buffers, waiting to be
+// processed and written; a growing queue means the reader of the output, e.g.
+// Telegraf, doesn't keep up.
+func (c *Channel) Stats() output.Stats {
+	queued := len(c.metricCh)
+	if shards := c.shardChs.Load(); shards != nil {
+		for _, shard := range *shards {
+			queued += len(shard)
+		}
+	}
+	stats := output.Stats{
+		Queued:        queued,
+		Failures:      c.writeFailures.Load(),
+		FlushDuration: time.Duration(c.writeDuration.Load()),
+	}
+	if last := c.lastWrite.Load(); last != 0 {
+		stats.LastSuccess = time.Unix(0, last)
+	}
+	return stats
 }
 
-// Close closes the metric channel and cancels the context.
+// shardIndex returns the shard of a metric, derived from its measurement and
+// device, so all metrics of a series are handled by the same shard.
+func shardIndex(m metrics.Metric, shards int) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(m.Name))
+	hash.Write([]byte{0})
+	hash.Write([]byte(m.Tags["device"]))
+	return int(hash.Sum32() % uint32(shards))
+}
+
+// Close closes the metric channel and cancels the context. Metrics held back
+// for reordering are written before it returns.
 func (c *Channel) Close() {
 	c.cancel()
 	close(c.metricCh)
+	if c.reorder != nil {
+		c.reorder.close()
+	}
 }
 
 // Context returns the context associated with this channel.
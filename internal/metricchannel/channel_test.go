@@ -1,10 +1,15 @@
 package metricchannel
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/janhuddel/metrics-agent/internal/metrics"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
 )
 
 func TestChannel(t *testing.T) {
@@ -77,3 +82,244 @@ func TestChannelClose(t *testing.T) {
 		t.Fatal("Context should be cancelled after Close()")
 	}
 }
+
+// recordingExporter collects exported metric names
+type recordingExporter struct {
+	names chan string
+}
+
+func (r *recordingExporter) Export(m metrics.Metric) {
+	r.names <- m.Name
+}
+
+func TestChannelExporters(t *testing.T) {
+	ch := New(10)
+	defer ch.Close()
+
+	first := &recordingExporter{names: make(chan string, 1)}
+	second := &recordingExporter{names: make(chan string, 1)}
+	ch.AddExporter(first)
+	ch.AddExporter(second)
+	ch.StartSerializer()
+
+	ch.Get() <- metrics.Metric{Name: "test_metric", Fields: map[string]interface{}{"value": 42}}
+
+	for _, exporter := range []*recordingExporter{first, second} {
+		select {
+		case name := <-exporter.names:
+			if name != "test_metric" {
+				t.Errorf("Expected test_metric, got %s", name)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Exporter did not receive the metric")
+		}
+	}
+}
+
+// blockingExporter blocks the metrics of one device until released
+type blockingExporter struct {
+	device   string
+	release  chan struct{}
+	exported chan string
+}
+
+func (b *blockingExporter) Export(m metrics.Metric) {
+	if m.Tags["device"] == b.device {
+		<-b.release
+	}
+	b.exported <- m.Tags["device"]
+}
+
+func TestChannelShards(t *testing.T) {
+	ch := New(10)
+	defer ch.Close()
+
+	// Find a device handled by another shard than the slow one
+	slow := metrics.Metric{Name: "power", Tags: map[string]string{"device": "slow"}, Fields: map[string]interface{}{"value": 1}}
+	fast := metrics.Metric{Name: "power", Fields: map[string]interface{}{"value": 2}}
+	for i := 0; ; i++ {
+		fast.Tags = map[string]string{"device": fmt.Sprintf("fast%d", i)}
+		if shardIndex(fast, 4) != shardIndex(slow, 4) {
+			break
+		}
+	}
+
+	exporter := &blockingExporter{device: "slow", release: make(chan struct{}), exported: make(chan string, 2)}
+	ch.AddExporter(exporter)
+	ch.SetOutput(utils.NewLineWriter(io.Discard))
+	ch.SetShards(4)
+	ch.StartSerializer()
+
+	ch.Get() <- slow
+	ch.Get() <- fast
+	select {
+	case device := <-exporter.exported:
+		if device != fast.Tags["device"] {
+			t.Errorf("Expected metric of %s first, got %s", fast.Tags["device"], device)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected metric of another shard not to wait for the slow export")
+	}
+
+	close(exporter.release)
+	select {
+	case <-exporter.exported:
+	case <-time.After(time.Second):
+		t.Fatal("Expected slow metric to be exported after release")
+	}
+}
+
+func TestShardIndex(t *testing.T) {
+	m := metrics.Metric{Name: "power", Tags: map[string]string{"device": "plug1"}}
+	index := shardIndex(m, 8)
+	if index < 0 || index >= 8 {
+		t.Fatalf("Expected shard between 0 and 7, got %d", index)
+	}
+
+	// Other tags and fields don't move the metrics of a device to another shard
+	m.Tags = map[string]string{"device": "plug1", "friendly": "Plug"}
+	m.Fields = map[string]interface{}{"value": 1}
+	if got := shardIndex(m, 8); got != index {
+		t.Errorf("Expected shard %d for the same device, got %d", index, got)
+	}
+}
+
+// countingExporter counts exported metrics and delays those of one device,
+// like a slow write
+type countingExporter struct {
+	device string
+	delay  time.Duration
+	done   *sync.WaitGroup
+}
+
+func (c *countingExporter) Export(m metrics.Metric) {
+	if m.Tags["device"] == c.device {
+		time.Sleep(c.delay)
+	}
+	c.done.Done()
+}
+
+func BenchmarkSerializer(b *testing.B) {
+	for _, delay := range []time.Duration{0, 50 * time.Microsecond} {
+		for _, shards := range []int{1, 4} {
+			b.Run(fmt.Sprintf("delay=%s/shards=%d", delay, shards), func(b *testing.B) {
+				var done sync.WaitGroup
+				ch := New(100)
+				ch.AddExporter(&countingExporter{device: "device0", delay: delay, done: &done})
+				ch.SetOutput(utils.NewLineWriter(io.Discard))
+				ch.SetShards(shards)
+				ch.StartSerializer()
+				defer ch.Close()
+
+				batch := make([]metrics.Metric, 8)
+				for i := range batch {
+					batch[i] = metrics.Metric{
+						Name:   "electricity",
+						Tags:   map[string]string{"device": fmt.Sprintf("device%d", i)},
+						Fields: map[string]interface{}{"power": 42.5, "sum_power_total": 1234.5},
+					}
+				}
+
+				b.ResetTimer()
+				done.Add(b.N)
+				for i := 0; i < b.N; i++ {
+					ch.Get() <- batch[i%len(batch)]
+				}
+				done.Wait()
+			})
+		}
+	}
+}
+
+// splitter replaces a metric with one metric per field
+type splitter struct{}
+
+func (splitter) Process(m metrics.Metric) (metrics.Metric, bool) {
+	return m, true
+}
+
+func (splitter) Expand(m metrics.Metric) []metrics.Metric {
+	var split []metrics.Metric
+	for field, value := range m.Fields {
+		split = append(split, metrics.Metric{Name: m.Name + "_" + field, Fields: map[string]interface{}{"value": value}})
+	}
+	return split
+}
+
+func TestChannelExpander(t *testing.T) {
+	ch := New(10)
+	defer ch.Close()
+
+	exporter := &recordingExporter{names: make(chan string, 2)}
+	ch.AddExporter(exporter)
+	ch.SetOutput(utils.NewLineWriter(io.Discard))
+	ch.SetProcessor(splitter{})
+	ch.StartSerializer()
+
+	ch.Get() <- metrics.Metric{Name: "power", Fields: map[string]interface{}{"l1": 1, "l2": 2}}
+
+	names := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case name := <-exporter.names:
+			names[name] = true
+		case <-time.After(time.Second):
+			t.Fatal("Expected both expanded metrics to be exported")
+		}
+	}
+	if !names["power_l1"] || !names["power_l2"] {
+		t.Errorf("Expected power_l1 and power_l2, got %v", names)
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestChannelStats(t *testing.T) {
+	ch := New(10)
+	defer ch.Close()
+
+	ch.Get() <- metrics.Metric{Name: "queued", Fields: map[string]interface{}{"value": 1}}
+	if stats := ch.Stats(); stats.Queued != 1 || !stats.LastSuccess.IsZero() {
+		t.Errorf("Expected 1 queued metric before the serializer starts, got %+v", stats)
+	}
+
+	exporter := &recordingExporter{names: make(chan string, 2)}
+	ch.AddExporter(exporter)
+	ch.SetOutput(utils.NewLineWriter(io.Discard))
+	ch.StartSerializer()
+	<-exporter.names
+
+	// The exporters are called before the line is written
+	deadline := time.Now().Add(time.Second)
+	for ch.Stats().LastSuccess.IsZero() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := ch.Stats(); stats.Queued != 0 || stats.LastSuccess.IsZero() || stats.Failures != 0 {
+		t.Errorf("Expected a successful write, got %+v", stats)
+	}
+}
+
+func TestChannelStatsWriteFailure(t *testing.T) {
+	ch := New(10)
+	defer ch.Close()
+
+	exporter := &recordingExporter{names: make(chan string, 1)}
+	ch.AddExporter(exporter)
+	ch.SetOutput(utils.NewLineWriter(failingWriter{}))
+	ch.StartSerializer()
+	ch.Get() <- metrics.Metric{Name: "test_metric", Fields: map[string]interface{}{"value": 1}}
+	<-exporter.names
+
+	deadline := time.Now().Add(time.Second)
+	for ch.Stats().Failures == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := ch.Stats(); stats.Failures != 1 || !stats.LastSuccess.IsZero() {
+		t.Errorf("Expected a failed write, got %+v", stats)
+	}
+}
@@ -0,0 +1,163 @@
+package metricchannel
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// Limits restricts the size of the written Line Protocol. Consumers like
+// telegraf reject overly long lines, and with them the rest of the batch, so
+// a device with a pathological payload, e.g. a huge string field, would
+// otherwise cost the metrics of all other devices. Zero values disable a limit.
+type Limits struct {
+	MaxLineLength   int  // maximum length of a line in bytes
+	MaxFields       int  // maximum number of fields of a metric
+	MaxStringLength int  // maximum length of a string field value in bytes
+	Drop            bool // drop metrics exceeding a limit instead of truncating them
+}
+
+// limiter applies the limits and counts the affected metrics.
+type limiter struct {
+	limits    Limits
+	truncated atomic.Int64
+	dropped   atomic.Int64
+	warned    sync.Map // series already warned about, later ones are logged at debug level
+}
+
+// enabled reports whether any limit is set.
+func (l *limiter) enabled() bool {
+	return l.limits.MaxLineLength > 0 || l.limits.MaxFields > 0 || l.limits.MaxStringLength > 0
+}
+
+// limitFields applies the field count and string length limits. It returns
+// false if the metric is dropped. The fields are copied before truncating,
+// as they may be shared with the module that sent the metric.
+func (l *limiter) limitFields(m metrics.Metric) (metrics.Metric, bool) {
+	maxFields, maxString := l.limits.MaxFields, l.limits.MaxStringLength
+	tooMany := maxFields > 0 && len(m.Fields) > maxFields
+	tooLong := false
+	if maxString > 0 {
+		for _, value := range m.Fields {
+			if s, ok := value.(string); ok && len(s) > maxString {
+				tooLong = true
+				break
+			}
+		}
+	}
+	if !tooMany && !tooLong {
+		return m, true
+	}
+
+	reason := "string field too long"
+	if tooMany {
+		reason = "too many fields"
+	}
+	if l.limits.Drop {
+		l.drop(m, reason)
+		return m, false
+	}
+
+	keys := make([]string, 0, len(m.Fields))
+	for key := range m.Fields {
+		keys = append(keys, key)
+	}
+	if tooMany {
+		// Keep the first fields in the order they are written
+		sort.Strings(keys)
+		keys = keys[:maxFields]
+	}
+	fields := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		value := m.Fields[key]
+		if s, ok := value.(string); ok && maxString > 0 && len(s) > maxString {
+			value = truncateString(s, maxString)
+		}
+		fields[key] = value
+	}
+	m.Fields = fields
+	l.truncate(m, reason)
+	return m, true
+}
+
+// limitLine applies the line length limit to the serialized metric. When
+// truncating, string fields are left out, longest first, until the line fits.
+// It returns false if the metric is dropped.
+func (l *limiter) limitLine(m metrics.Metric, line string) (string, bool) {
+	maxLength := l.limits.MaxLineLength
+	if maxLength <= 0 || len(line) <= maxLength {
+		return line, true
+	}
+	if l.limits.Drop {
+		l.drop(m, "line too long")
+		return "", false
+	}
+
+	var strs []string
+	for key, value := range m.Fields {
+		if _, ok := value.(string); ok {
+			strs = append(strs, key)
+		}
+	}
+	sort.Slice(strs, func(i, j int) bool {
+		return len(m.Fields[strs[i]].(string)) > len(m.Fields[strs[j]].(string))
+	})
+
+	fields := make(map[string]interface{}, len(m.Fields))
+	for key, value := range m.Fields {
+		fields[key] = value
+	}
+	m.Fields = fields
+	for _, key := range strs {
+		delete(m.Fields, key)
+		if len(m.Fields) == 0 {
+			break
+		}
+		var err error
+		if line, err = m.ToLineProtocolSafe(); err != nil {
+			break
+		}
+		if len(line) <= maxLength {
+			l.truncate(m, "line too long")
+			return line, true
+		}
+	}
+	l.drop(m, "line too long")
+	return "", false
+}
+
+// truncateString shortens s to at most n bytes without splitting a character.
+func truncateString(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// truncate counts and logs a truncated metric.
+func (l *limiter) truncate(m metrics.Metric, reason string) {
+	l.truncated.Add(1)
+	l.log(m, "truncating", reason)
+}
+
+// drop counts and logs a dropped metric.
+func (l *limiter) drop(m metrics.Metric, reason string) {
+	l.dropped.Add(1)
+	l.log(m, "dropping", reason)
+}
+
+// log warns about the first affected metric of a series, and logs the
+// following ones at debug level, so a device sending oversized metrics on
+// every poll doesn't flood the log.
+func (l *limiter) log(m metrics.Metric, action, reason string) {
+	series := m.Name + "," + m.Tags["device"]
+	if _, warned := l.warned.LoadOrStore(series, true); warned {
+		utils.Debugf("[worker] %s metric %s of %s: %s", action, m.Name, m.Tags["device"], reason)
+		return
+	}
+	utils.Warnf("[worker] %s metric %s of %s exceeding the line limits: %s (further ones are logged at debug level)", action, m.Name, m.Tags["device"], reason)
+}
@@ -0,0 +1,94 @@
+package metricchannel
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestLimitFields(t *testing.T) {
+	fields := map[string]interface{}{"a": 1, "b": "ünïcode", "c": 3}
+	m := metrics.Metric{Name: "test", Fields: fields}
+
+	l := &limiter{limits: Limits{MaxFields: 2, MaxStringLength: 4}}
+	limited, keep := l.limitFields(m)
+	if !keep {
+		t.Fatal("Expected metric to be kept")
+	}
+	// "ü" and "n" take 3 bytes, "ï" would exceed the limit
+	if len(limited.Fields) != 2 || limited.Fields["a"] != 1 || limited.Fields["b"] != "ün" {
+		t.Errorf("Expected fields a and truncated b, got %v", limited.Fields)
+	}
+	if len(fields) != 3 || fields["b"] != "ünïcode" {
+		t.Errorf("Expected the original fields to be unchanged, got %v", fields)
+	}
+
+	l = &limiter{limits: Limits{MaxFields: 2, Drop: true}}
+	if _, keep := l.limitFields(m); keep {
+		t.Error("Expected metric with too many fields to be dropped")
+	}
+	if l.dropped.Load() != 1 || l.truncated.Load() != 0 {
+		t.Errorf("Expected 1 dropped metric, got %d dropped and %d truncated", l.dropped.Load(), l.truncated.Load())
+	}
+
+	l = &limiter{limits: Limits{MaxFields: 3, MaxStringLength: 100}}
+	if limited, keep := l.limitFields(m); !keep || len(limited.Fields) != 3 || l.truncated.Load() != 0 {
+		t.Errorf("Expected metric within the limits to be unchanged, got %v", limited.Fields)
+	}
+}
+
+func TestLimitLine(t *testing.T) {
+	m := metrics.Metric{Name: "test", Fields: map[string]interface{}{
+		"value":   1,
+		"short":   "ok",
+		"payload": strings.Repeat("x", 100),
+	}}
+	line, _ := m.ToLineProtocolSafe()
+
+	l := &limiter{limits: Limits{MaxLineLength: 50}}
+	limited, keep := l.limitLine(m, line)
+	if !keep || limited != `test short="ok",value=1i` {
+		t.Errorf("Expected the longest string field to be left out, got %q", limited)
+	}
+	if l.truncated.Load() != 1 {
+		t.Errorf("Expected 1 truncated metric, got %d", l.truncated.Load())
+	}
+
+	l = &limiter{limits: Limits{MaxLineLength: 10}}
+	if _, keep := l.limitLine(m, line); keep {
+		t.Error("Expected metric to be dropped if it doesn't fit without string fields")
+	}
+
+	l = &limiter{limits: Limits{MaxLineLength: 50, Drop: true}}
+	if _, keep := l.limitLine(m, line); keep || l.dropped.Load() != 1 {
+		t.Error("Expected metric exceeding the line length to be dropped")
+	}
+}
+
+func TestChannelLimits(t *testing.T) {
+	var buf bytes.Buffer
+	ch := New(10)
+	defer ch.Close()
+	ch.SetOutput(utils.NewLineWriter(&buf))
+	ch.SetLimits(Limits{MaxLineLength: 50})
+	ch.StartSerializer()
+
+	ch.Get() <- metrics.Metric{Name: "huge", Fields: map[string]interface{}{"payload": strings.Repeat("x", 100)}}
+	ch.Get() <- metrics.Metric{Name: "small", Fields: map[string]interface{}{"value": 1}}
+
+	// The metrics are written in order, so the huge one was handled before
+	deadline := time.Now().Add(time.Second)
+	for ch.Stats().LastSuccess.IsZero() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if output := buf.String(); output != "small value=1i\n" {
+		t.Errorf("Expected only the small metric to be written, got %q", output)
+	}
+	if truncated, dropped := ch.LimitStats(); truncated != 0 || dropped != 1 {
+		t.Errorf("Expected 1 dropped metric, got %d dropped and %d truncated", dropped, truncated)
+	}
+}
@@ -0,0 +1,91 @@
+package metricchannel
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// Recent keeps the last metrics emitted by each module in a ring buffer, so it
+// can be checked whether a module is producing data without querying the
+// database.
+type Recent struct {
+	size int
+
+	mu    sync.Mutex
+	rings map[string]*ring
+}
+
+// ring is a fixed-size buffer that overwrites its oldest metric when full.
+type ring struct {
+	metrics []metrics.Metric
+	next    int
+	full    bool
+}
+
+// NewRecent creates a buffer keeping the last size metrics per module. It
+// returns nil if size is not positive.
+func NewRecent(size int) *Recent {
+	if size <= 0 {
+		return nil
+	}
+	return &Recent{size: size, rings: make(map[string]*ring)}
+}
+
+// Record adds a metric emitted by the given module, replacing the module's
+// oldest metric if its buffer is full.
+func (r *Recent) Record(module string, m metrics.Metric) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	buf, ok := r.rings[module]
+	if !ok {
+		buf = &ring{metrics: make([]metrics.Metric, r.size)}
+		r.rings[module] = buf
+	}
+	buf.metrics[buf.next] = m
+	buf.next = (buf.next + 1) % r.size
+	if buf.next == 0 {
+		buf.full = true
+	}
+}
+
+// Get returns the recent metrics of a module, oldest first.
+func (r *Recent) Get(module string) []metrics.Metric {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	buf, ok := r.rings[module]
+	if !ok {
+		return nil
+	}
+	if !buf.full {
+		return append([]metrics.Metric(nil), buf.metrics[:buf.next]...)
+	}
+	result := make([]metrics.Metric, 0, r.size)
+	result = append(result, buf.metrics[buf.next:]...)
+	return append(result, buf.metrics[:buf.next]...)
+}
+
+// Modules returns the sorted names of all modules that emitted metrics.
+func (r *Recent) Modules() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.rings))
+	for name := range r.rings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,53 @@
+package metricchannel
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func recentValues(r *Recent, module string) []interface{} {
+	var values []interface{}
+	for _, m := range r.Get(module) {
+		values = append(values, m.Fields["value"])
+	}
+	return values
+}
+
+func TestRecent(t *testing.T) {
+	r := NewRecent(3)
+	for i := 1; i <= 2; i++ {
+		r.Record("tasmota", metrics.Metric{Name: "power", Fields: map[string]interface{}{"value": i}})
+	}
+	if got := recentValues(r, "tasmota"); !reflect.DeepEqual(got, []interface{}{1, 2}) {
+		t.Errorf("Expected [1 2], got %v", got)
+	}
+
+	// The oldest metrics are overwritten once the buffer is full
+	for i := 3; i <= 5; i++ {
+		r.Record("tasmota", metrics.Metric{Name: "power", Fields: map[string]interface{}{"value": i}})
+	}
+	if got := recentValues(r, "tasmota"); !reflect.DeepEqual(got, []interface{}{3, 4, 5}) {
+		t.Errorf("Expected [3 4 5], got %v", got)
+	}
+
+	r.Record("netatmo", metrics.Metric{Name: "climate", Fields: map[string]interface{}{"value": 21.5}})
+	if got := r.Modules(); !reflect.DeepEqual(got, []string{"netatmo", "tasmota"}) {
+		t.Errorf("Expected modules [netatmo tasmota], got %v", got)
+	}
+	if got := r.Get("dwd"); got != nil {
+		t.Errorf("Expected no metrics for unknown module, got %v", got)
+	}
+}
+
+func TestRecentDisabled(t *testing.T) {
+	r := NewRecent(0)
+	if r != nil {
+		t.Fatal("Expected nil buffer for size 0")
+	}
+	r.Record("tasmota", metrics.Metric{Name: "power"})
+	if r.Get("tasmota") != nil || r.Modules() != nil {
+		t.Error("Expected disabled buffer to keep no metrics")
+	}
+}
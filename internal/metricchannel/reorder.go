@@ -0,0 +1,93 @@
+package metricchannel
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// maxReorderMetrics bounds the metrics held by the reorder buffer. A full
+// buffer is flushed before its window has passed, so a burst of backfilled
+// metrics can't exhaust the memory.
+const maxReorderMetrics = 10000
+
+// reorderBuffer holds processed metrics for a window and emits them sorted by
+// timestamp, so historical metrics of backfilling modules interleaved with live
+// metrics are written in order. Metrics are only ordered within a window.
+type reorderBuffer struct {
+	window time.Duration
+	emit   func(metrics.Metric)
+
+	mu      sync.Mutex
+	pending []metrics.Metric
+	closed  bool
+}
+
+// newReorderBuffer creates a reorder buffer passing the sorted metrics to emit.
+func newReorderBuffer(window time.Duration, emit func(metrics.Metric)) *reorderBuffer {
+	return &reorderBuffer{window: window, emit: emit}
+}
+
+// add holds a metric until the next flush. After the buffer was closed,
+// metrics are emitted directly.
+func (r *reorderBuffer) add(m metrics.Metric) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		r.emit(m)
+		return
+	}
+	r.pending = append(r.pending, m)
+	full := len(r.pending) >= maxReorderMetrics
+	r.mu.Unlock()
+
+	if full {
+		r.flush()
+	}
+}
+
+// flush emits the held metrics sorted by timestamp. Metrics with the same
+// timestamp keep the order they were added in.
+func (r *reorderBuffer) flush() {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = nil
+	// Emitting under the lock keeps concurrent flushes in order
+	defer r.mu.Unlock()
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].Timestamp.Before(pending[j].Timestamp)
+	})
+	for _, m := range pending {
+		r.emit(m)
+	}
+}
+
+// run flushes the buffer every window until done is closed, then flushes the
+// remaining metrics and emits later metrics directly.
+func (r *reorderBuffer) run(done <-chan struct{}) {
+	utils.WithPanicRecoveryAndContinue("Metric reorder", "worker", func() {
+		ticker := time.NewTicker(r.window)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.flush()
+			case <-done:
+				r.close()
+				return
+			}
+		}
+	})
+}
+
+// close flushes the remaining metrics and stops holding back new ones.
+func (r *reorderBuffer) close() {
+	r.flush()
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+}
@@ -0,0 +1,60 @@
+package metricchannel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestChannelReorder(t *testing.T) {
+	ch := New(10)
+	defer ch.Close()
+
+	exporter := &recordingExporter{names: make(chan string, 3)}
+	ch.AddExporter(exporter)
+	ch.SetReorderWindow(50 * time.Millisecond)
+	ch.StartSerializer()
+
+	// A backfilled metric arrives between live metrics
+	now := time.Now()
+	for _, m := range []metrics.Metric{
+		{Name: "live1", Fields: map[string]interface{}{"value": 1}, Timestamp: now},
+		{Name: "backfill", Fields: map[string]interface{}{"value": 2}, Timestamp: now.Add(-time.Hour)},
+		{Name: "live2", Fields: map[string]interface{}{"value": 3}, Timestamp: now.Add(time.Second)},
+	} {
+		ch.Get() <- m
+	}
+
+	for _, expected := range []string{"backfill", "live1", "live2"} {
+		select {
+		case name := <-exporter.names:
+			if name != expected {
+				t.Errorf("Expected %s, got %s", expected, name)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %s to be written after the window", expected)
+		}
+	}
+}
+
+func TestReorderBufferClose(t *testing.T) {
+	var emitted []string
+	buffer := newReorderBuffer(time.Hour, func(m metrics.Metric) {
+		emitted = append(emitted, m.Name)
+	})
+
+	now := time.Now()
+	buffer.add(metrics.Metric{Name: "second", Timestamp: now})
+	buffer.add(metrics.Metric{Name: "first", Timestamp: now.Add(-time.Minute)})
+	if len(emitted) != 0 {
+		t.Fatalf("Expected metrics to be held until the window passed, got %v", emitted)
+	}
+
+	// Closing flushes the held metrics, later ones are written directly
+	buffer.close()
+	buffer.add(metrics.Metric{Name: "late", Timestamp: now.Add(-time.Hour)})
+	if len(emitted) != 3 || emitted[0] != "first" || emitted[1] != "second" || emitted[2] != "late" {
+		t.Errorf("Expected first, second, late, got %v", emitted)
+	}
+}
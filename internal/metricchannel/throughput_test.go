@@ -0,0 +1,98 @@
+package metricchannel
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/processors"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// lineCounter is the sink of the throughput benchmarks. It counts the
+// written lines, one Write per line.
+type lineCounter struct {
+	done *sync.WaitGroup
+}
+
+func (lc lineCounter) Write(p []byte) (int, error) {
+	lc.done.Done()
+	return len(p), nil
+}
+
+// benchmarkPipeline is a pipeline as configured on a typical installation:
+// plausibility filters, derived values, totals, rounding and tag cleanup.
+func benchmarkPipeline() *processors.Pipeline {
+	maxPower := 4000.0
+	return processors.FromConfig(config.PipelineConfig{
+		Ranges:      []config.RangeRule{{Measurement: "electricity", Fields: []string{"power"}, Max: &maxPower}},
+		SpikeFilter: []config.SpikeFilterRule{{Measurement: "climate", MaxDelta: 10}},
+		Derivative:  []config.DerivativeRule{{Measurement: "electricity", Fields: []string{"sum_power_total"}, Unit: config.Duration(time.Hour)}},
+		Accumulate:  []config.AccumulateRule{{Measurement: "electricity", Fields: []string{"power"}}},
+		Round:       []config.RoundRule{{Decimals: 2}},
+		NormalizeTags: &config.NormalizeTagsConfig{
+			Tags: []string{"friendly"}, Lowercase: true, Spaces: "_", Transliterate: true,
+		},
+	})
+}
+
+// benchmarkMetrics returns metrics of the given number of devices, alternating
+// between plugs and climate sensors.
+func benchmarkMetrics(devices int) []metrics.Metric {
+	batch := make([]metrics.Metric, devices)
+	for i := range batch {
+		tags := map[string]string{"device": fmt.Sprintf("device%d", i), "friendly": fmt.Sprintf("Gerät %d", i), "vendor": "tasmota"}
+		if i%2 == 0 {
+			batch[i] = metrics.Metric{
+				Name:   "electricity",
+				Tags:   tags,
+				Fields: map[string]interface{}{"power": 42.5, "sum_power_total": 1234.567, "voltage": 230.1, "current": 0.185},
+			}
+		} else {
+			batch[i] = metrics.Metric{
+				Name:   "climate",
+				Tags:   tags,
+				Fields: map[string]interface{}{"temperature": 21.34, "humidity": 55.0},
+			}
+		}
+	}
+	return batch
+}
+
+// BenchmarkThroughput pushes metrics through the full pipeline, from the
+// module channel through the processors and the serializer to the sink, and
+// reports the throughput in metrics/s. "make bench-check" compares the
+// results with a stored baseline.
+func BenchmarkThroughput(b *testing.B) {
+	for _, pipeline := range []string{"none", "typical"} {
+		for _, shards := range []int{1, 4} {
+			b.Run(fmt.Sprintf("pipeline=%s/shards=%d", pipeline, shards), func(b *testing.B) {
+				var done sync.WaitGroup
+				ch := New(1000)
+				if pipeline == "typical" {
+					ch.SetProcessor(benchmarkPipeline())
+				}
+				ch.SetOutput(utils.NewLineWriter(lineCounter{done: &done}))
+				ch.SetShards(shards)
+				ch.StartSerializer()
+				defer ch.Close()
+
+				batch := benchmarkMetrics(50)
+				start := time.Now()
+				b.ReportAllocs()
+				b.ResetTimer()
+				done.Add(b.N)
+				for i := 0; i < b.N; i++ {
+					m := batch[i%len(batch)]
+					m.Timestamp = start.Add(time.Duration(i) * time.Millisecond)
+					ch.Get() <- m
+				}
+				done.Wait()
+				b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "metrics/s")
+			})
+		}
+	}
+}
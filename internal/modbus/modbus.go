@@ -0,0 +1,265 @@
+// Package modbus reads holding registers via Modbus TCP, in particular of
+// devices implementing the SunSpec information model, such as inverters,
+// meters and batteries of many PV vendors. A SunSpec device lists its models
+// after a "SunS" marker; each model starts with its ID and length, followed
+// by its registers (points).
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+const (
+	// MaxModels bounds the walk through the SunSpec model list
+	MaxModels = 50
+
+	// maxRegisters is the number of registers a single read can return
+	maxRegisters = 125
+
+	// markerHigh and markerLow are the registers of the "SunS" marker
+	markerHigh = 0x5375
+	markerLow  = 0x6E53
+
+	// SunSpec models with integer points and scale factors
+	ModelCommon        = 1
+	ModelInverterFirst = 101 // single phase inverter (101) to three phase inverter (103)
+	ModelInverterLast  = 103
+	ModelStorage       = 124
+	ModelMeterFirst    = 201 // single phase meter (201) to three phase delta meter (204)
+	ModelMeterLast     = 204
+	ModelEnd           = 0xFFFF
+
+	// Values of points the device doesn't implement
+	notImplementedInt16 = -0x8000
+	notImplementedUint  = 0xFFFF
+)
+
+// ErrNoSunSpec is returned if the SunSpec marker is missing at the base address.
+var ErrNoSunSpec = errors.New("no SunSpec marker")
+
+// BaseAddresses are the registers the SunSpec specification allows the
+// marker at, in the order they are commonly used.
+var BaseAddresses = []uint16{40000, 50000, 0}
+
+// Model is a SunSpec model of a device, located by the first register after its header.
+type Model struct {
+	ID      uint16
+	Address uint16
+	Length  uint16
+}
+
+// Exception is a Modbus exception response. The connection remains usable
+// after it, unlike after other errors.
+type Exception struct {
+	Code    byte
+	Address uint16
+	Count   uint16
+}
+
+func (e *Exception) Error() string {
+	return fmt.Sprintf("modbus exception %d reading %d registers at %d", e.Code, e.Count, e.Address)
+}
+
+// Client connects to a Modbus TCP server.
+type Client struct {
+	Address  string
+	UnitID   byte
+	Timeout  time.Duration
+	Instance string // module instance for the audit log
+}
+
+// Conn is a Modbus TCP connection reading holding registers. It is not safe
+// for concurrent use.
+type Conn struct {
+	conn          net.Conn
+	unitID        byte
+	timeout       time.Duration
+	transactionID uint16
+}
+
+// Connect connects to the Modbus TCP server.
+func (c *Client) Connect(ctx context.Context) (*Conn, error) {
+	start := time.Now()
+	conn, err := c.dial(ctx)
+	utils.AuditConnect(c.Instance, "modbus", c.Address, time.Since(start), err)
+	return conn, err
+}
+
+// dial connects to the Modbus TCP server if the destination is allowed
+func (c *Client) dial(ctx context.Context) (*Conn, error) {
+	if err := utils.CheckDestination(ctx, c.Address); err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Timeout: c.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Modbus server %s: %w", c.Address, err)
+	}
+	return &Conn{conn: conn, unitID: c.UnitID, timeout: c.Timeout}, nil
+}
+
+// SetUnitID sets the unit ID of the following requests, e.g. to read
+// several devices behind a gateway over the same connection.
+func (m *Conn) SetUnitID(unitID byte) {
+	m.unitID = unitID
+}
+
+// Close closes the connection.
+func (m *Conn) Close() error {
+	return m.conn.Close()
+}
+
+// SunSpecModels walks the SunSpec model list of the device starting with the
+// marker at base and returns the models up to the end marker.
+func (m *Conn) SunSpecModels(base uint16) ([]Model, error) {
+	marker, err := m.ReadRegisters(base, 2)
+	if err != nil {
+		return nil, err
+	}
+	if marker[0] != markerHigh || marker[1] != markerLow {
+		return nil, fmt.Errorf("%w at register %d", ErrNoSunSpec, base)
+	}
+
+	var models []Model
+	address := base + 2
+	for range MaxModels {
+		header, err := m.ReadRegisters(address, 2)
+		if err != nil {
+			return nil, err
+		}
+		id, length := header[0], header[1]
+		if id == ModelEnd {
+			return models, nil
+		}
+		models = append(models, Model{ID: id, Address: address + 2, Length: length})
+		address += 2 + length
+	}
+	return nil, fmt.Errorf("no end of the SunSpec models after %d models", MaxModels)
+}
+
+// ReadModel reads the registers of a model, in several requests if it is
+// longer than a single read can return.
+func (m *Conn) ReadModel(model Model) ([]uint16, error) {
+	block := make([]uint16, 0, model.Length)
+	for offset := uint16(0); offset < model.Length; offset += maxRegisters {
+		registers, err := m.ReadRegisters(model.Address+offset, min(model.Length-offset, maxRegisters))
+		if err != nil {
+			return nil, err
+		}
+		block = append(block, registers...)
+	}
+	return block, nil
+}
+
+// ReadRegisters reads count holding registers (function 0x03) starting at address.
+func (m *Conn) ReadRegisters(address, count uint16) ([]uint16, error) {
+	if err := m.conn.SetDeadline(time.Now().Add(m.timeout)); err != nil {
+		return nil, err
+	}
+
+	m.transactionID++
+	request := make([]byte, 12)
+	binary.BigEndian.PutUint16(request[0:], m.transactionID)
+	binary.BigEndian.PutUint16(request[2:], 0) // protocol ID
+	binary.BigEndian.PutUint16(request[4:], 6) // length of the remaining bytes
+	request[6] = m.unitID
+	request[7] = 0x03
+	binary.BigEndian.PutUint16(request[8:], address)
+	binary.BigEndian.PutUint16(request[10:], count)
+	if _, err := m.conn.Write(request); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(m.conn, header); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if binary.BigEndian.Uint16(header[0:]) != m.transactionID {
+		return nil, fmt.Errorf("unexpected transaction ID in response")
+	}
+	length := binary.BigEndian.Uint16(header[4:])
+	if length < 3 || length > 3+2*maxRegisters {
+		return nil, fmt.Errorf("invalid response length %d", length)
+	}
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(m.conn, pdu); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if pdu[0] == 0x83 {
+		return nil, &Exception{Code: pdu[1], Address: address, Count: count}
+	}
+	if pdu[0] != 0x03 || int(pdu[1]) != 2*int(count) || len(pdu) != 2+2*int(count) {
+		return nil, fmt.Errorf("unexpected response reading %d registers at %d", count, address)
+	}
+
+	registers := make([]uint16, count)
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16(pdu[2+2*i:])
+	}
+	return registers, nil
+}
+
+// Int16 returns a signed register, nil if not implemented.
+func Int16(register uint16) *float64 {
+	if int16(register) == notImplementedInt16 {
+		return nil
+	}
+	value := float64(int16(register))
+	return &value
+}
+
+// Uint16 returns an unsigned register, nil if not implemented.
+func Uint16(register uint16) *float64 {
+	if register == notImplementedUint {
+		return nil
+	}
+	value := float64(register)
+	return &value
+}
+
+// Acc32 returns an accumulator of two registers, nil if not accumulated.
+func Acc32(high, low uint16) *float64 {
+	accumulated := uint32(high)<<16 | uint32(low)
+	if accumulated == 0 {
+		return nil
+	}
+	value := float64(accumulated)
+	return &value
+}
+
+// Scaled applies a SunSpec scale factor, nil if the value or the factor is not implemented.
+func Scaled(value *float64, scaleFactor uint16) *float64 {
+	factor := int16(scaleFactor)
+	if value == nil || factor == notImplementedInt16 {
+		return nil
+	}
+	// Dividing for negative factors keeps e.g. 87 with factor -1 at exactly 8.7
+	var result float64
+	if factor < 0 {
+		result = *value / math.Pow10(-int(factor))
+	} else {
+		result = *value * math.Pow10(int(factor))
+	}
+	return &result
+}
+
+// String returns a string point of two characters per register, without the
+// trailing NUL padding.
+func String(registers []uint16) string {
+	b := make([]byte, 0, 2*len(registers))
+	for _, register := range registers {
+		b = append(b, byte(register>>8), byte(register))
+	}
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+}
@@ -0,0 +1,64 @@
+package modbus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/testutil"
+)
+
+func TestModels(t *testing.T) {
+	device := testutil.NewSunSpecDevice(50000)
+	device.AddModel(ModelCommon, 66, map[uint16]uint16{0: 0x4142, 1: 0x4300}) // Mn = "ABC"
+	device.AddModel(160, 200, map[uint16]uint16{0: 1, 199: 2})
+	registers := device.End()
+
+	client := Client{Address: testutil.ServeModbus(t, map[byte]map[uint16]uint16{1: registers}), UnitID: 1, Timeout: time.Second}
+	conn, err := client.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.SunSpecModels(40000); err == nil {
+		t.Error("Expected error without marker at 40000")
+	}
+	models, err := conn.SunSpecModels(50000)
+	if err != nil {
+		t.Fatalf("Failed to read models: %v", err)
+	}
+	if len(models) != 2 || models[0] != (Model{ID: 1, Address: 50004, Length: 66}) || models[1].ID != 160 {
+		t.Fatalf("Unexpected models %+v", models)
+	}
+
+	common, err := conn.ReadModel(models[0])
+	if err != nil {
+		t.Fatalf("Failed to read common model: %v", err)
+	}
+	if mn := String(common[0:16]); mn != "ABC" {
+		t.Errorf("Expected manufacturer ABC, got %q", mn)
+	}
+
+	// Models longer than a single read are read in several requests
+	block, err := conn.ReadModel(models[1])
+	if err != nil {
+		t.Fatalf("Failed to read long model: %v", err)
+	}
+	if len(block) != 200 || block[0] != 1 || block[199] != 2 {
+		t.Errorf("Unexpected long model of %d registers", len(block))
+	}
+}
+
+func TestScaled(t *testing.T) {
+	value := 87.0
+	if result := Scaled(&value, 0xFFFF); result == nil || *result != 8.7 {
+		t.Errorf("Expected 8.7, got %v", result)
+	}
+	if result := Scaled(&value, 0x8000); result != nil {
+		t.Errorf("Expected nil for a not implemented scale factor, got %v", *result)
+	}
+	if result := Int16(0x8000); result != nil {
+		t.Errorf("Expected nil for a not implemented value, got %v", *result)
+	}
+}
@@ -0,0 +1,367 @@
+// Package awair provides a metric collection module for Awair indoor air quality
+// monitors (e.g. Awair Element) using their local API.
+// It polls the air-data/latest endpoint of each configured device and emits the
+// climate readings and the air quality readings as separate metrics.
+package awair
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/connection"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// Metric names
+	metricNameClimate    = "climate"
+	metricNameAirQuality = "air_quality"
+
+	// Local API endpoints
+	airDataPath = "/air-data/latest"
+	configPath  = "/settings/config/data"
+)
+
+// Config represents the configuration for the Awair module
+type Config struct {
+	config.BaseConfig
+	Devices  []string        `json:"devices"`            // Addresses of the devices with enabled local API
+	Interval config.Duration `json:"interval,omitempty"` // Polling interval (defaults to 60s)
+	Timeout  config.Duration `json:"timeout,omitempty"`  // HTTP request timeout (defaults to 10s)
+}
+
+// AirData represents the latest readings of a device
+type AirData struct {
+	Timestamp   string   `json:"timestamp"`
+	Score       *float64 `json:"score"`
+	DewPoint    *float64 `json:"dew_point"`
+	Temperature *float64 `json:"temp"`
+	Humidity    *float64 `json:"humid"`
+	CO2         *float64 `json:"co2"`
+	VOC         *float64 `json:"voc"`
+	PM25        *float64 `json:"pm25"`
+	PM10        *float64 `json:"pm10_est"`
+}
+
+// DeviceConfig represents the device settings of the local API
+type DeviceConfig struct {
+	DeviceUUID string `json:"device_uuid"`
+	WifiMAC    string `json:"wifi_mac"`
+	FWVersion  string `json:"fw_version"`
+}
+
+// AwairModule handles polling of Awair devices
+type AwairModule struct {
+	config      Config
+	httpClient  *http.Client
+	metricsCh   chan<- metrics.Metric
+	clock       utils.Clock
+	collections *utils.CollectionLog // last successful collection, nil if not remembered
+	trackers    map[string]*connection.Tracker
+	deviceIDs   map[string]string // device UUID per address
+}
+
+// Run starts the Awair module and begins collecting metrics
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewAwairModule(config)
+	if err != nil {
+		return fmt.Errorf("failed to create Awair module: %w", err)
+	}
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+	module.collections = utils.OpenCollectionLog(config.InstanceName("awair"), module.clock)
+
+	return module.run(ctx)
+}
+
+// Probe validates the Awair configuration and checks that the devices are reachable
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewAwairModule(cfg)
+	if err != nil {
+		return &config.ModuleError{Module: "awair", Err: err}
+	}
+	for _, device := range module.config.Devices {
+		if err := utils.ProbeURL(ctx, deviceURL(device, airDataPath), module.config.Timeout.Duration()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewAwairModule creates a new Awair module instance
+func NewAwairModule(cfg Config) (*AwairModule, error) {
+	utils.Debugf("Creating new Awair module instance")
+
+	if len(cfg.Devices) == 0 {
+		return nil, fmt.Errorf("devices is required but not configured")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(60 * time.Second)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = config.Duration(10 * time.Second)
+	}
+
+	utils.Debugf("Awair module created successfully")
+	return &AwairModule{
+		clock:  utils.SystemClock,
+		config: cfg,
+		httpClient: &http.Client{
+			Timeout:   cfg.Timeout.Duration(),
+			Transport: utils.DeviceTransport(cfg.InstanceName("awair"), nil),
+		},
+		trackers:  make(map[string]*connection.Tracker),
+		deviceIDs: make(map[string]string),
+	}, nil
+}
+
+// DefaultConfig returns the default configuration of the Awair module.
+func DefaultConfig() Config {
+	return Config{
+		Interval: config.Duration(60 * time.Second),
+		Timeout:  config.Duration(10 * time.Second),
+	}
+}
+
+// LoadConfig loads the Awair module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("awair")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
+
+// run executes the main module loop
+func (am *AwairModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Awair module", "main", func() error {
+		interval := am.config.Interval.Duration()
+		ticker := utils.NewScheduledTicker(ctx, interval)
+		defer ticker.Stop()
+
+		// Collect initial data unless outside the collection schedule or skipped,
+		// but not before an interval has passed since the last collection
+		initial := am.collections.Initial(ctx, interval)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-initial:
+				if err := utils.CollectWithRetry(ctx, interval, am.collectData); err != nil {
+					utils.Warnf("Failed to collect initial Awair data: %v", err)
+				} else {
+					am.collections.Record()
+				}
+			case <-ticker.C:
+				if err := utils.CollectWithRetry(ctx, interval, am.collectData); err != nil {
+					utils.Warnf("Failed to collect Awair data: %v", err)
+				} else {
+					am.collections.Record()
+				}
+			}
+		}
+	})
+}
+
+// collectData polls all configured devices.
+// A failing device does not prevent the others from being collected; the last error is returned.
+func (am *AwairModule) collectData(ctx context.Context) error {
+	var lastErr error
+	for _, device := range am.config.Devices {
+		if err := am.collectDevice(ctx, device); err != nil {
+			utils.Warnf("Failed to collect Awair device %s: %v", device, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// collectDevice fetches the latest readings of a device and sends its metrics
+func (am *AwairModule) collectDevice(ctx context.Context, device string) error {
+	return utils.WithPanicRecoveryAndReturnError("Awair data collection", device, func() error {
+		url := deviceURL(device, airDataPath)
+		body, err := am.fetch(ctx, url, am.connection(url))
+		if err != nil {
+			return err
+		}
+
+		var data AirData
+		if err := json.Unmarshal(body, &data); err != nil {
+			return fmt.Errorf("failed to parse air data: %w", err)
+		}
+
+		timestamp, err := time.Parse(time.RFC3339, data.Timestamp)
+		if err != nil {
+			timestamp = am.clock.Now()
+		}
+
+		tags := am.createBaseTags(am.deviceID(ctx, device), device)
+		if fields := climateFields(data); len(fields) > 0 {
+			am.sendMetric(metricNameClimate, tags, fields, timestamp)
+		}
+		if fields := airQualityFields(data); len(fields) > 0 {
+			am.sendMetric(metricNameAirQuality, copyTags(tags), fields, timestamp)
+		}
+		return nil
+	})
+}
+
+// deviceID returns the UUID of a device, falling back to its address if the
+// device settings can't be read. The UUID is only requested once per device.
+func (am *AwairModule) deviceID(ctx context.Context, device string) string {
+	if id, ok := am.deviceIDs[device]; ok {
+		return id
+	}
+
+	body, err := am.fetch(ctx, deviceURL(device, configPath), nil)
+	if err != nil {
+		utils.Debugf("Failed to read settings of Awair device %s: %v", device, err)
+		return device
+	}
+	var settings DeviceConfig
+	if err := json.Unmarshal(body, &settings); err != nil || settings.DeviceUUID == "" {
+		utils.Debugf("Awair device %s reported no device UUID", device)
+		return device
+	}
+
+	am.deviceIDs[device] = settings.DeviceUUID
+	return settings.DeviceUUID
+}
+
+// fetch performs a GET request and returns the response body of a successful request.
+// The result of the request is reported to the tracker unless it is nil.
+func (am *AwairModule) fetch(ctx context.Context, url string, tracker *connection.Tracker) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := am.httpClient.Do(req)
+	if tracker != nil {
+		tracker.SetPollResult(resp, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+// connection returns the tracker for an endpoint, creating it on first use
+func (am *AwairModule) connection(url string) *connection.Tracker {
+	tracker, ok := am.trackers[url]
+	if !ok {
+		tracker = connection.NewTracker(am.config.InstanceName("awair"), url, am.metricsCh)
+		am.trackers[url] = tracker
+	}
+	return tracker
+}
+
+// deviceURL returns the URL of a local API endpoint of a device given as host or URL
+func deviceURL(device, path string) string {
+	if !strings.Contains(device, "://") {
+		device = "http://" + device
+	}
+	return strings.TrimSuffix(device, "/") + path
+}
+
+// climateFields returns the climate readings of a device
+func climateFields(data AirData) map[string]interface{} {
+	fields := make(map[string]interface{})
+	addField(fields, "temperature", data.Temperature)
+	addField(fields, "humidity", data.Humidity)
+	addField(fields, "dew_point", data.DewPoint)
+	addField(fields, "co2", data.CO2)
+	return fields
+}
+
+// airQualityFields returns the air quality readings of a device
+func airQualityFields(data AirData) map[string]interface{} {
+	fields := make(map[string]interface{})
+	addField(fields, "score", data.Score)
+	addField(fields, "co2", data.CO2)
+	addField(fields, "voc", data.VOC)
+	addField(fields, "pm2_5", data.PM25)
+	addField(fields, "pm10", data.PM10)
+	return fields
+}
+
+// addField adds a reading to the fields if the device reported it
+func addField(fields map[string]interface{}, name string, value *float64) {
+	if value != nil {
+		fields[name] = *value
+	}
+}
+
+// copyTags returns a copy of the tags, so that metrics don't share their tag maps
+func copyTags(tags map[string]string) map[string]string {
+	result := make(map[string]string, len(tags))
+	for key, value := range tags {
+		result[key] = value
+	}
+	return result
+}
+
+// createBaseTags creates base tags for a device
+func (am *AwairModule) createBaseTags(deviceID, address string) map[string]string {
+	return map[string]string{
+		"vendor":   "awair",
+		"device":   deviceID,
+		"friendly": am.config.GetFriendlyName(deviceID, "", address),
+	}
+}
+
+// sendMetric validates and sends a metric to the metrics channel
+func (am *AwairModule) sendMetric(name string, tags map[string]string, fields map[string]interface{}, timestamp time.Time) {
+	metric := metrics.Metric{
+		Name:      name,
+		Tags:      tags,
+		Fields:    fields,
+		Timestamp: timestamp,
+	}
+
+	if err := metric.Validate(); err != nil {
+		utils.Warnf("Invalid %s metric for device %s: %v", name, tags["device"], err)
+		return
+	}
+
+	select {
+	case am.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping %s metric for device %s", name, tags["device"])
+	}
+}
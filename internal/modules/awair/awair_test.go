@@ -0,0 +1,144 @@
+package awair
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const sampleAirData = `{"timestamp":"2024-01-01T12:00:00.000Z","score":88,"dew_point":10.2,"temp":21.37,"humid":49.37,"abs_humid":9.2,"co2":483,"co2_est":412,"voc":180,"voc_baseline":37000,"pm25":5,"pm10_est":6}`
+
+const sampleConfig = `{"device_uuid":"awair-element_12345","wifi_mac":"70:88:6B:00:00:01","fw_version":"1.2.8"}`
+
+func TestNewAwairModule(t *testing.T) {
+	tah := utils.NewTestAssertionHelper()
+
+	_, err := NewAwairModule(Config{})
+	tah.AssertError(t, err, "Expected error for missing devices")
+
+	module, err := NewAwairModule(Config{Devices: []string{"192.168.1.60"}})
+	tah.AssertNoError(t, err, "Failed to create Awair module")
+	if module.config.Interval.Duration() != 60*time.Second {
+		t.Errorf("Expected default interval 60s, got %v", module.config.Interval)
+	}
+	if url := deviceURL("192.168.1.60", airDataPath); url != "http://192.168.1.60/air-data/latest" {
+		t.Errorf("Unexpected device URL %s", url)
+	}
+}
+
+func TestCollectData(t *testing.T) {
+	var configRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case airDataPath:
+			fmt.Fprint(w, sampleAirData)
+		case configPath:
+			configRequests++
+			fmt.Fprint(w, sampleConfig)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	module, err := NewAwairModule(Config{
+		BaseConfig: config.BaseConfig{FriendlyNameOverrides: map[string]string{"awair-element_12345": "Wohnzimmer"}},
+		Devices:    []string{server.URL},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.metricsCh = ch
+
+	for i := 0; i < 2; i++ {
+		if err := module.collectData(context.Background()); err != nil {
+			t.Fatalf("collectData failed: %v", err)
+		}
+	}
+	if configRequests != 1 {
+		t.Errorf("Expected device settings to be requested once, got %d", configRequests)
+	}
+
+	// Expect: connection status, climate and air quality metric, then climate and air quality of the second poll
+	collected := drain(ch)
+	if len(collected) != 5 {
+		t.Fatalf("Expected 5 metrics, got %d", len(collected))
+	}
+	if collected[0].Name != "connection_status" {
+		t.Errorf("Expected connection status metric first, got %+v", collected[0])
+	}
+
+	climate, airQuality := collected[1], collected[2]
+	if climate.Name != "climate" || airQuality.Name != "air_quality" {
+		t.Fatalf("Expected climate and air quality metrics, got %s and %s", climate.Name, airQuality.Name)
+	}
+	for _, metric := range []metrics.Metric{climate, airQuality} {
+		if metric.Tags["device"] != "awair-element_12345" || metric.Tags["friendly"] != "Wohnzimmer" || metric.Tags["vendor"] != "awair" {
+			t.Errorf("Unexpected tags %v", metric.Tags)
+		}
+		if !metric.Timestamp.Equal(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)) {
+			t.Errorf("Expected device timestamp, got %v", metric.Timestamp)
+		}
+	}
+	if climate.Fields["temperature"] != 21.37 || climate.Fields["humidity"] != 49.37 || climate.Fields["co2"] != 483.0 {
+		t.Errorf("Unexpected climate fields %v", climate.Fields)
+	}
+	if airQuality.Fields["score"] != 88.0 || airQuality.Fields["voc"] != 180.0 || airQuality.Fields["pm2_5"] != 5.0 || airQuality.Fields["pm10"] != 6.0 {
+		t.Errorf("Unexpected air quality fields %v", airQuality.Fields)
+	}
+}
+
+func TestCollectDataWithoutSettings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != airDataPath {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"timestamp":"invalid","temp":20.5}`)
+	}))
+	defer server.Close()
+
+	module, err := NewAwairModule(Config{Devices: []string{server.URL}})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.metricsCh = ch
+
+	if err := module.collectData(context.Background()); err != nil {
+		t.Fatalf("collectData failed: %v", err)
+	}
+
+	// The address is used as device ID and only the reported readings are sent
+	collected := drain(ch)
+	if len(collected) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(collected))
+	}
+	climate := collected[1]
+	if climate.Name != "climate" || climate.Tags["device"] != server.URL || len(climate.Fields) != 1 {
+		t.Errorf("Unexpected metric %+v", climate)
+	}
+	if climate.Timestamp.IsZero() {
+		t.Error("Expected poll time as timestamp")
+	}
+}
+
+func drain(ch chan metrics.Metric) []metrics.Metric {
+	var result []metrics.Metric
+	for {
+		select {
+		case metric := <-ch:
+			result = append(result, metric)
+		default:
+			return result
+		}
+	}
+}
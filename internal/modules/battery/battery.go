@@ -0,0 +1,293 @@
+// Package battery provides a metric collection module for home battery
+// storage systems.
+// It reads the state of charge, the charge/discharge power and the charge
+// cycles of a sonnenBatterie via its local JSON API or of an E3DC system via
+// the encrypted RSCP protocol.
+package battery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/connection"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// Supported storage systems
+	typeSonnen = "sonnen"
+	typeE3DC   = "e3dc"
+
+	// Metric name
+	metricName = "energy_storage"
+)
+
+// cycleCounterKinds marks the charge cycles as counter
+var cycleCounterKinds = map[string]metrics.Kind{
+	"cycles": metrics.KindCounter,
+}
+
+// Config represents the configuration for the battery module
+type Config struct {
+	config.BaseConfig
+	Type     string          `json:"type"`               // Storage system: "sonnen" or "e3dc"
+	URL      string          `json:"url"`                // Sonnen base URL (e.g. "http://192.168.1.30")
+	Token    string          `json:"token"`              // Sonnen API token (Auth-Token of the JSON API v2)
+	Address  string          `json:"address"`            // E3DC RSCP address (e.g. "192.168.1.31:5033")
+	Username string          `json:"username"`           // E3DC portal username
+	Password string          `json:"password"`           // E3DC portal password
+	RSCPKey  string          `json:"rscp_key"`           // E3DC RSCP password set on the device
+	Interval config.Duration `json:"interval,omitempty"` // Polling interval (defaults to 30s)
+	Timeout  config.Duration `json:"timeout,omitempty"`  // Request timeout (defaults to 10s)
+}
+
+// Reading holds the values read from the storage system. Values the system
+// doesn't report are nil.
+type Reading struct {
+	SOC    *float64 // State of charge in percent
+	Power  *float64 // Battery power in W, positive when charging
+	Cycles *int64   // Full charge cycles since installation
+}
+
+// reader reads the current values from the storage system
+type reader interface {
+	Read(ctx context.Context) (Reading, error)
+}
+
+// BatteryModule handles polling of a battery storage system
+type BatteryModule struct {
+	config      Config
+	device      string
+	reader      reader
+	metricsCh   chan<- metrics.Metric
+	clock       utils.Clock
+	collections *utils.CollectionLog // last successful collection, nil if not remembered
+	tracker     *connection.Tracker
+}
+
+// Run starts the battery module and begins collecting metrics
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewBatteryModule(config)
+	if err != nil {
+		return fmt.Errorf("failed to create battery module: %w", err)
+	}
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+	module.collections = utils.OpenCollectionLog(config.InstanceName("battery"), module.clock)
+
+	return module.run(ctx)
+}
+
+// Probe validates the battery configuration and checks that the storage system is reachable
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewBatteryModule(cfg)
+	if err != nil {
+		return &config.ModuleError{Module: "battery", Err: err}
+	}
+	if module.config.Type == typeE3DC {
+		return utils.ProbeAddress(ctx, module.config.Address, module.config.Timeout.Duration())
+	}
+	return utils.ProbeURL(ctx, module.config.URL, module.config.Timeout.Duration())
+}
+
+// NewBatteryModule creates a new battery module instance
+func NewBatteryModule(cfg Config) (*BatteryModule, error) {
+	utils.Debugf("Creating new battery module instance")
+
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(30 * time.Second)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = config.Duration(10 * time.Second)
+	}
+
+	module := &BatteryModule{
+		clock:  utils.SystemClock,
+		config: cfg,
+	}
+
+	switch cfg.Type {
+	case typeSonnen:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("url is required but not configured")
+		}
+		if cfg.Token == "" {
+			return nil, fmt.Errorf("token is required but not configured")
+		}
+		parsed, err := url.Parse(cfg.URL)
+		if err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid url %q", cfg.URL)
+		}
+		module.config.URL = strings.TrimSuffix(cfg.URL, "/")
+		module.device = parsed.Hostname()
+		module.reader = &SonnenClient{
+			url:   module.config.URL,
+			token: cfg.Token,
+			httpClient: &http.Client{
+				Timeout:   cfg.Timeout.Duration(),
+				Transport: utils.OutboundTransport(cfg.InstanceName("battery"), nil),
+			},
+			onResponse: func(resp *http.Response, err error) { module.connection().SetPollResult(resp, err) },
+		}
+	case typeE3DC:
+		if cfg.Address == "" {
+			return nil, fmt.Errorf("address is required but not configured")
+		}
+		if cfg.Username == "" || cfg.Password == "" || cfg.RSCPKey == "" {
+			return nil, fmt.Errorf("username, password and rscp_key are required but not configured")
+		}
+		if len(cfg.RSCPKey) > 32 {
+			return nil, fmt.Errorf("rscp_key must not be longer than 32 characters")
+		}
+		host, _, err := net.SplitHostPort(cfg.Address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", cfg.Address, err)
+		}
+		module.device = host
+		module.reader = newE3DCClient(cfg.Address, cfg.Username, cfg.Password, cfg.RSCPKey, cfg.Timeout.Duration(), cfg.InstanceName("battery"))
+	case "":
+		return nil, fmt.Errorf("type is required but not configured")
+	default:
+		return nil, fmt.Errorf("unknown type %q, expected %q or %q", cfg.Type, typeSonnen, typeE3DC)
+	}
+
+	utils.Debugf("Battery module created successfully")
+	return module, nil
+}
+
+// DefaultConfig returns the default configuration of the battery module.
+func DefaultConfig() Config {
+	return Config{
+		Interval: config.Duration(30 * time.Second),
+		Timeout:  config.Duration(10 * time.Second),
+	}
+}
+
+// LoadConfig loads the battery module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("battery")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
+
+// run executes the main module loop
+func (bm *BatteryModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Battery module", "main", func() error {
+		ticker := utils.NewScheduledTicker(ctx, bm.config.Interval.Duration())
+		defer ticker.Stop()
+
+		// Collect initial data unless outside the collection schedule or skipped,
+		// but not before an interval has passed since the last collection
+		initial := bm.collections.Initial(ctx, bm.config.Interval.Duration())
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-initial:
+				if err := bm.collectData(ctx); err != nil {
+					utils.Warnf("Failed to collect initial battery data: %v", err)
+				} else {
+					bm.collections.Record()
+				}
+			case <-ticker.C:
+				if err := bm.collectData(ctx); err != nil {
+					utils.Warnf("Failed to collect battery data: %v", err)
+				} else {
+					bm.collections.Record()
+				}
+			}
+		}
+	})
+}
+
+// collectData reads the storage system and sends its metric
+func (bm *BatteryModule) collectData(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Battery data collection", bm.device, func() error {
+		reading, err := bm.reader.Read(ctx)
+		if err != nil {
+			return err
+		}
+		bm.sendMetric(reading, bm.clock.Now())
+		return nil
+	})
+}
+
+// connection returns the tracker for the Sonnen API, creating it on first use
+func (bm *BatteryModule) connection() *connection.Tracker {
+	if bm.tracker == nil {
+		bm.tracker = connection.NewTracker(bm.config.InstanceName("battery"), bm.config.URL, bm.metricsCh)
+	}
+	return bm.tracker
+}
+
+// sendMetric creates and sends the metric of the storage system
+func (bm *BatteryModule) sendMetric(reading Reading, timestamp time.Time) {
+	fields := make(map[string]interface{})
+	if reading.SOC != nil {
+		fields["soc"] = *reading.SOC
+	}
+	if reading.Power != nil {
+		// Split the signed power, so charged and discharged energy can be summed up separately
+		fields["power"] = *reading.Power
+		fields["charge_power"] = max(*reading.Power, 0)
+		fields["discharge_power"] = max(-*reading.Power, 0)
+	}
+	if reading.Cycles != nil {
+		fields["cycles"] = *reading.Cycles
+	}
+
+	if len(fields) == 0 {
+		utils.Warnf("Storage system %s reported no values", bm.device)
+		return
+	}
+
+	metric := metrics.Metric{
+		Name: metricName,
+		Tags: map[string]string{
+			"vendor":   bm.config.Type,
+			"device":   bm.device,
+			"friendly": bm.config.GetFriendlyName(bm.device, bm.device, bm.device),
+		},
+		Fields:     fields,
+		FieldKinds: cycleCounterKinds,
+		Timestamp:  timestamp,
+	}
+
+	if err := metric.Validate(); err != nil {
+		utils.Warnf("Invalid metric for storage system %s: %v", bm.device, err)
+		return
+	}
+
+	select {
+	case bm.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping metric for storage system %s", bm.device)
+	}
+}
@@ -0,0 +1,267 @@
+package battery
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestNewBatteryModule(t *testing.T) {
+	tah := utils.NewTestAssertionHelper()
+
+	_, err := NewBatteryModule(Config{URL: "http://sonnen"})
+	tah.AssertError(t, err, "Expected error for missing type")
+
+	_, err = NewBatteryModule(Config{Type: "byd", URL: "http://byd"})
+	tah.AssertError(t, err, "Expected error for unknown type")
+
+	_, err = NewBatteryModule(Config{Type: "sonnen", URL: "http://sonnen"})
+	tah.AssertError(t, err, "Expected error for missing token")
+
+	_, err = NewBatteryModule(Config{Type: "e3dc", Address: "e3dc:5033", Username: "user", Password: "secret"})
+	tah.AssertError(t, err, "Expected error for missing RSCP key")
+
+	module, err := NewBatteryModule(Config{Type: "sonnen", URL: "http://sonnen/", Token: "token"})
+	tah.AssertNoError(t, err, "Failed to create Sonnen module")
+	if module.config.URL != "http://sonnen" || module.device != "sonnen" {
+		t.Errorf("Unexpected URL %s or device %s", module.config.URL, module.device)
+	}
+
+	module, err = NewBatteryModule(Config{Type: "e3dc", Address: "192.168.1.31:5033", Username: "user", Password: "secret", RSCPKey: "key"})
+	tah.AssertNoError(t, err, "Failed to create E3DC module")
+	if module.device != "192.168.1.31" {
+		t.Errorf("Unexpected device %s", module.device)
+	}
+}
+
+func TestSonnenCollectData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Auth-Token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v2/status":
+			fmt.Fprint(w, `{"USOC":87,"RSOC":89,"Pac_total_W":-1500,"Consumption_W":400}`)
+		case "/api/v2/battery":
+			fmt.Fprint(w, `{"cyclecount":412,"fullchargecapacity":10000}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	module, err := NewBatteryModule(Config{Type: "sonnen", URL: server.URL, Token: "token"})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.metricsCh = ch
+
+	if err := module.collectData(context.Background()); err != nil {
+		t.Fatalf("collectData failed: %v", err)
+	}
+	if status := <-ch; status.Name != "connection_status" {
+		t.Errorf("Expected connection status metric, got %+v", status)
+	}
+	metric := <-ch
+	if metric.Name != "energy_storage" || metric.Tags["vendor"] != "sonnen" {
+		t.Errorf("Unexpected metric: %+v", metric)
+	}
+	expected := map[string]interface{}{
+		"soc":             87.0,
+		"power":           1500.0,
+		"charge_power":    1500.0,
+		"discharge_power": 0.0,
+		"cycles":          int64(412),
+	}
+	for name, value := range expected {
+		if metric.Fields[name] != value {
+			t.Errorf("Expected %s to be %v, got %v", name, value, metric.Fields[name])
+		}
+	}
+}
+
+func TestRijndaelMatchesAES(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	plain := []byte("sixteen byte msg")
+
+	reference, _ := aes.NewCipher(key)
+	expected := make([]byte, 16)
+	reference.Encrypt(expected, plain)
+
+	cipher, err := newRijndael(key, 16)
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+	encrypted := make([]byte, 16)
+	cipher.Encrypt(encrypted, plain)
+	if !bytes.Equal(encrypted, expected) {
+		t.Fatalf("Expected %x, got %x", expected, encrypted)
+	}
+
+	decrypted := make([]byte, 16)
+	cipher.Decrypt(decrypted, encrypted)
+	if !bytes.Equal(decrypted, plain) {
+		t.Errorf("Expected %q after decryption, got %q", plain, decrypted)
+	}
+}
+
+func TestRijndael256KnownAnswer(t *testing.T) {
+	// Rijndael test vector for a 256 bit block and key by Brian Gladman
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfe")
+	plain, _ := hex.DecodeString("3243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c8")
+	expected, _ := hex.DecodeString("a49406115dfb30a40418aafa4869b7c6a886ff31602a7dd19c889dc64f7e4e7a")
+
+	cipher, err := newRijndael(key, 32)
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+	encrypted := make([]byte, 32)
+	cipher.Encrypt(encrypted, plain)
+	if !bytes.Equal(encrypted, expected) {
+		t.Fatalf("Expected %x, got %x", expected, encrypted)
+	}
+	decrypted := make([]byte, 32)
+	cipher.Decrypt(decrypted, expected)
+	if !bytes.Equal(decrypted, plain) {
+		t.Errorf("Expected %x after decryption, got %x", plain, decrypted)
+	}
+}
+
+func TestRijndael256Block(t *testing.T) {
+	cipher, err := newRijndael(bytes.Repeat([]byte{0xFF}, 32), 32)
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+	plain := []byte("a block of thirty-two bytes long")
+	encrypted := make([]byte, 32)
+	cipher.Encrypt(encrypted, plain)
+	if bytes.Equal(encrypted, plain) {
+		t.Fatal("Expected the block to be encrypted")
+	}
+	decrypted := make([]byte, 32)
+	cipher.Decrypt(decrypted, encrypted)
+	if !bytes.Equal(decrypted, plain) {
+		t.Errorf("Expected %q after decryption, got %q", plain, decrypted)
+	}
+}
+
+// serveRSCP answers the authentication and battery requests of an E3DC system
+func serveRSCP(t *testing.T, rscpKey, password string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		client := newE3DCClient("", "", "", rscpKey, time.Second, "")
+		block, _ := newRijndael(client.key, rscpBlockSize)
+		server := newRSCPConn(conn, block, time.Second)
+
+		for {
+			data, err := server.readFrame()
+			if err != nil {
+				return
+			}
+			request, err := decodeRSCPValues(data)
+			if err != nil {
+				return
+			}
+
+			var response []rscpValue
+			if findRSCPValue(request, tagAuthenticationPass) != nil {
+				level := uint64(10)
+				if findRSCPValue(request, tagAuthenticationPass) != password {
+					level = 0
+				}
+				response = []rscpValue{{tag: tagAuthentication, dataType: rscpUChar8, value: level}}
+			} else {
+				response = []rscpValue{
+					{tag: tagBatterySOC, dataType: rscpUChar8, value: uint64(64)},
+					{tag: tagBatteryPower, dataType: rscpInt32, value: int64(-2300)},
+					{tag: 0x03840000, dataType: rscpContainer, value: []rscpValue{
+						{tag: tagBatteryIndex, dataType: rscpUInt16, value: uint64(0)},
+						{tag: tagChargeCycles, dataType: rscpUInt32, value: uint64(250)},
+					}},
+				}
+			}
+			if err := server.writeFrame(encodeRSCPValues(response)); err != nil {
+				return
+			}
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestE3DCCollectData(t *testing.T) {
+	address := serveRSCP(t, "rscp-key", "secret")
+	module, err := NewBatteryModule(Config{Type: "e3dc", Address: address, Username: "user", Password: "secret", RSCPKey: "rscp-key"})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.metricsCh = ch
+
+	if err := module.collectData(context.Background()); err != nil {
+		t.Fatalf("collectData failed: %v", err)
+	}
+	metric := <-ch
+	if metric.Tags["vendor"] != "e3dc" {
+		t.Errorf("Unexpected metric: %+v", metric)
+	}
+	expected := map[string]interface{}{
+		"soc":             64.0,
+		"power":           -2300.0,
+		"charge_power":    0.0,
+		"discharge_power": 2300.0,
+		"cycles":          int64(250),
+	}
+	for name, value := range expected {
+		if metric.Fields[name] != value {
+			t.Errorf("Expected %s to be %v, got %v", name, value, metric.Fields[name])
+		}
+	}
+}
+
+func TestE3DCAuthenticationFailure(t *testing.T) {
+	address := serveRSCP(t, "rscp-key", "secret")
+	module, err := NewBatteryModule(Config{Type: "e3dc", Address: address, Username: "user", Password: "wrong", RSCPKey: "rscp-key"})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	module.metricsCh = make(chan metrics.Metric, 10)
+
+	if err := module.collectData(context.Background()); err == nil {
+		t.Error("Expected authentication with wrong password to fail")
+	}
+}
+
+func TestE3DCWrongKey(t *testing.T) {
+	address := serveRSCP(t, "rscp-key", "secret")
+	module, err := NewBatteryModule(Config{Type: "e3dc", Address: address, Username: "user", Password: "secret", RSCPKey: "other-key"})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	module.metricsCh = make(chan metrics.Metric, 10)
+
+	if err := module.collectData(context.Background()); err == nil {
+		t.Error("Expected a wrong RSCP key to fail")
+	}
+}
@@ -0,0 +1,418 @@
+package battery
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"net"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// RSCP tags read from E3DC systems. Responses have bit 23 set.
+const (
+	tagRequestAuthentication = 0x00000001
+	tagAuthenticationUser    = 0x00000002
+	tagAuthenticationPass    = 0x00000003
+	tagAuthentication        = 0x00800001
+	tagRequestBatterySOC     = 0x01000008
+	tagBatterySOC            = 0x01800008
+	tagRequestBatteryPower   = 0x01000002
+	tagBatteryPower          = 0x01800002
+	tagRequestBatteryData    = 0x03040000
+	tagBatteryIndex          = 0x03040001
+	tagRequestChargeCycles   = 0x03000008
+	tagChargeCycles          = 0x03800008
+)
+
+// RSCP data types
+const (
+	rscpNone      = 0x00
+	rscpBool      = 0x01
+	rscpChar8     = 0x02
+	rscpUChar8    = 0x03
+	rscpInt16     = 0x04
+	rscpUInt16    = 0x05
+	rscpInt32     = 0x06
+	rscpUInt32    = 0x07
+	rscpInt64     = 0x08
+	rscpUInt64    = 0x09
+	rscpFloat32   = 0x0A
+	rscpDouble64  = 0x0B
+	rscpString    = 0x0D
+	rscpContainer = 0x0E
+	rscpError     = 0xFF
+)
+
+const (
+	// rscpBlockSize is the block size of the RSCP encryption
+	rscpBlockSize = 32
+
+	// rscpHeaderSize is the size of a frame header: magic, control, seconds,
+	// nanoseconds and data length
+	rscpHeaderSize = 18
+
+	// rscpMaxFrameSize bounds the size of a response frame
+	rscpMaxFrameSize = 64 * 1024
+
+	// rscpControl is the control word of frames: version 1 with CRC
+	rscpControl = 0x0011
+
+	// rscpControlCRC is the control bit of frames with CRC
+	rscpControlCRC = 0x0010
+)
+
+// rscpSizes are the sizes of the fixed size data types
+var rscpSizes = map[byte]int{
+	rscpBool:     1,
+	rscpChar8:    1,
+	rscpUChar8:   1,
+	rscpInt16:    2,
+	rscpUInt16:   2,
+	rscpInt32:    4,
+	rscpUInt32:   4,
+	rscpInt64:    8,
+	rscpUInt64:   8,
+	rscpFloat32:  4,
+	rscpDouble64: 8,
+	rscpError:    4,
+}
+
+// rscpValue is a tag with its value as sent in an RSCP frame
+type rscpValue struct {
+	tag      uint32
+	dataType byte
+	value    interface{} // nil, bool, int64, uint64, float64, string or []rscpValue
+}
+
+// E3DCClient reads an E3DC system via the encrypted RSCP protocol
+type E3DCClient struct {
+	address  string
+	username string // E3DC portal user
+	password string // E3DC portal password
+	key      []byte // RSCP key padded to 32 bytes
+	timeout  time.Duration
+	instance string // module instance for the audit log
+}
+
+// newE3DCClient creates a client, the RSCP key is padded with 0xFF to 32 bytes
+func newE3DCClient(address, username, password, rscpKey string, timeout time.Duration, instance string) *E3DCClient {
+	key := bytes.Repeat([]byte{0xFF}, 32)
+	copy(key, rscpKey)
+	return &E3DCClient{
+		address:  address,
+		username: username,
+		password: password,
+		key:      key,
+		timeout:  timeout,
+		instance: instance,
+	}
+}
+
+// Read connects to the system, authenticates and reads the battery values
+func (c *E3DCClient) Read(ctx context.Context) (Reading, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return Reading{}, err
+	}
+	defer conn.Close()
+
+	response, err := conn.request([]rscpValue{{
+		tag:      tagRequestAuthentication,
+		dataType: rscpContainer,
+		value: []rscpValue{
+			{tag: tagAuthenticationUser, dataType: rscpString, value: c.username},
+			{tag: tagAuthenticationPass, dataType: rscpString, value: c.password},
+		},
+	}})
+	if err != nil {
+		return Reading{}, fmt.Errorf("authentication failed: %w", err)
+	}
+	if level, ok := findRSCPValue(response, tagAuthentication).(uint64); !ok || level == 0 {
+		return Reading{}, fmt.Errorf("authentication failed, check username and password")
+	}
+
+	response, err = conn.request([]rscpValue{
+		{tag: tagRequestBatterySOC, dataType: rscpNone},
+		{tag: tagRequestBatteryPower, dataType: rscpNone},
+		{tag: tagRequestBatteryData, dataType: rscpContainer, value: []rscpValue{
+			{tag: tagBatteryIndex, dataType: rscpUInt16, value: uint64(0)},
+			{tag: tagRequestChargeCycles, dataType: rscpNone},
+		}},
+	})
+	if err != nil {
+		return Reading{}, err
+	}
+
+	var reading Reading
+	if soc, ok := toFloat(findRSCPValue(response, tagBatterySOC)); ok {
+		reading.SOC = &soc
+	}
+	if power, ok := toFloat(findRSCPValue(response, tagBatteryPower)); ok {
+		reading.Power = &power
+	}
+	if cycles, ok := findRSCPValue(response, tagChargeCycles).(uint64); ok && cycles <= math.MaxInt64 {
+		count := int64(cycles)
+		reading.Cycles = &count
+	}
+	return reading, nil
+}
+
+// findRSCPValue returns the value of the first tag found in the values and their containers
+func findRSCPValue(values []rscpValue, tag uint32) interface{} {
+	for _, v := range values {
+		if v.tag == tag && v.dataType != rscpError {
+			return v.value
+		}
+		if children, ok := v.value.([]rscpValue); ok {
+			if value := findRSCPValue(children, tag); value != nil {
+				return value
+			}
+		}
+	}
+	return nil
+}
+
+// toFloat converts a numeric RSCP value to float64
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// rscpConn is an RSCP connection. The CBC chaining continues across frames in
+// each direction.
+type rscpConn struct {
+	conn      net.Conn
+	cipher    *rijndael
+	encryptIV []byte
+	decryptIV []byte
+	timeout   time.Duration
+}
+
+// dial connects to the RSCP server
+func (c *E3DCClient) dial(ctx context.Context) (*rscpConn, error) {
+	if err := utils.CheckDestination(ctx, c.address); err != nil {
+		return nil, err
+	}
+	block, err := newRijndael(c.key, rscpBlockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.address)
+	utils.AuditConnect(c.instance, "rscp", c.address, time.Since(start), err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to E3DC system %s: %w", c.address, err)
+	}
+	return newRSCPConn(conn, block, c.timeout), nil
+}
+
+// newRSCPConn wraps a connection, both directions start with an IV of 0xFF bytes
+func newRSCPConn(conn net.Conn, block *rijndael, timeout time.Duration) *rscpConn {
+	return &rscpConn{
+		conn:      conn,
+		cipher:    block,
+		encryptIV: bytes.Repeat([]byte{0xFF}, rscpBlockSize),
+		decryptIV: bytes.Repeat([]byte{0xFF}, rscpBlockSize),
+		timeout:   timeout,
+	}
+}
+
+// Close closes the connection
+func (r *rscpConn) Close() error {
+	return r.conn.Close()
+}
+
+// request sends values in a frame and returns the values of the response frame
+func (r *rscpConn) request(values []rscpValue) ([]rscpValue, error) {
+	if err := r.conn.SetDeadline(time.Now().Add(r.timeout)); err != nil {
+		return nil, err
+	}
+	if err := r.writeFrame(encodeRSCPValues(values)); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	data, err := r.readFrame()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return decodeRSCPValues(data)
+}
+
+// writeFrame sends data in an encrypted frame
+func (r *rscpConn) writeFrame(data []byte) error {
+	if len(data) > math.MaxUint16 {
+		return fmt.Errorf("frame data too large")
+	}
+	now := time.Now()
+	frame := make([]byte, rscpHeaderSize, rscpHeaderSize+len(data)+4)
+	frame[0], frame[1] = 0xE3, 0xDC
+	binary.LittleEndian.PutUint16(frame[2:], rscpControl)
+	binary.LittleEndian.PutUint64(frame[4:], uint64(now.Unix()))
+	binary.LittleEndian.PutUint32(frame[12:], uint32(now.Nanosecond()))
+	binary.LittleEndian.PutUint16(frame[16:], uint16(len(data)))
+	frame = append(frame, data...)
+	frame = binary.LittleEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+
+	// Zero padding to the block size
+	if remainder := len(frame) % rscpBlockSize; remainder != 0 {
+		frame = append(frame, make([]byte, rscpBlockSize-remainder)...)
+	}
+	for i := 0; i < len(frame); i += rscpBlockSize {
+		block := frame[i : i+rscpBlockSize]
+		for j := range block {
+			block[j] ^= r.encryptIV[j]
+		}
+		r.cipher.Encrypt(block, block)
+		copy(r.encryptIV, block)
+	}
+	_, err := r.conn.Write(frame)
+	return err
+}
+
+// readFrame reads an encrypted frame and returns its data
+func (r *rscpConn) readFrame() ([]byte, error) {
+	frame, err := r.readBlock()
+	if err != nil {
+		return nil, err
+	}
+	if frame[0] != 0xE3 || frame[1] != 0xDC {
+		return nil, fmt.Errorf("invalid frame, check the RSCP key")
+	}
+	length := rscpHeaderSize + int(binary.LittleEndian.Uint16(frame[16:]))
+	withCRC := binary.LittleEndian.Uint16(frame[2:])&rscpControlCRC != 0
+	if withCRC {
+		length += 4
+	}
+	if length > rscpMaxFrameSize {
+		return nil, fmt.Errorf("frame too large")
+	}
+
+	for len(frame) < length {
+		block, err := r.readBlock()
+		if err != nil {
+			return nil, err
+		}
+		frame = append(frame, block...)
+	}
+	frame = frame[:length]
+
+	if withCRC {
+		crc := binary.LittleEndian.Uint32(frame[length-4:])
+		frame = frame[:length-4]
+		if crc32.ChecksumIEEE(frame) != crc {
+			return nil, fmt.Errorf("invalid frame checksum")
+		}
+	}
+	return frame[rscpHeaderSize:], nil
+}
+
+// readBlock reads and decrypts a single block
+func (r *rscpConn) readBlock() ([]byte, error) {
+	block := make([]byte, rscpBlockSize)
+	if _, err := io.ReadFull(r.conn, block); err != nil {
+		return nil, err
+	}
+	plain := make([]byte, rscpBlockSize)
+	r.cipher.Decrypt(plain, block)
+	for i := range plain {
+		plain[i] ^= r.decryptIV[i]
+	}
+	copy(r.decryptIV, block)
+	return plain, nil
+}
+
+// encodeRSCPValues encodes values as tag, type, length and value
+func encodeRSCPValues(values []rscpValue) []byte {
+	var data []byte
+	for _, v := range values {
+		var value []byte
+		switch v.dataType {
+		case rscpString:
+			value = []byte(v.value.(string))
+		case rscpContainer:
+			value = encodeRSCPValues(v.value.([]rscpValue))
+		case rscpUChar8:
+			value = []byte{byte(v.value.(uint64))}
+		case rscpUInt16:
+			value = binary.LittleEndian.AppendUint16(nil, uint16(v.value.(uint64)))
+		case rscpUInt32:
+			value = binary.LittleEndian.AppendUint32(nil, uint32(v.value.(uint64)))
+		case rscpInt32:
+			value = binary.LittleEndian.AppendUint32(nil, uint32(int32(v.value.(int64))))
+		}
+		data = binary.LittleEndian.AppendUint32(data, v.tag)
+		data = append(data, v.dataType)
+		data = binary.LittleEndian.AppendUint16(data, uint16(len(value)))
+		data = append(data, value...)
+	}
+	return data
+}
+
+// decodeRSCPValues decodes the values of a frame
+func decodeRSCPValues(data []byte) ([]rscpValue, error) {
+	var values []rscpValue
+	for len(data) > 0 {
+		if len(data) < 7 {
+			return nil, fmt.Errorf("truncated value")
+		}
+		v := rscpValue{tag: binary.LittleEndian.Uint32(data), dataType: data[4]}
+		length := int(binary.LittleEndian.Uint16(data[5:]))
+		if len(data) < 7+length {
+			return nil, fmt.Errorf("truncated value of tag 0x%08X", v.tag)
+		}
+		raw := data[7 : 7+length]
+		data = data[7+length:]
+		if size, fixed := rscpSizes[v.dataType]; fixed && length < size {
+			return nil, fmt.Errorf("invalid length %d of tag 0x%08X", length, v.tag)
+		}
+
+		switch v.dataType {
+		case rscpBool:
+			v.value = raw[0] != 0
+		case rscpChar8:
+			v.value = int64(int8(raw[0]))
+		case rscpUChar8:
+			v.value = uint64(raw[0])
+		case rscpInt16:
+			v.value = int64(int16(binary.LittleEndian.Uint16(raw)))
+		case rscpUInt16:
+			v.value = uint64(binary.LittleEndian.Uint16(raw))
+		case rscpInt32:
+			v.value = int64(int32(binary.LittleEndian.Uint32(raw)))
+		case rscpUInt32, rscpError:
+			v.value = uint64(binary.LittleEndian.Uint32(raw))
+		case rscpInt64:
+			v.value = int64(binary.LittleEndian.Uint64(raw))
+		case rscpUInt64:
+			v.value = binary.LittleEndian.Uint64(raw)
+		case rscpFloat32:
+			v.value = float64(math.Float32frombits(binary.LittleEndian.Uint32(raw)))
+		case rscpDouble64:
+			v.value = math.Float64frombits(binary.LittleEndian.Uint64(raw))
+		case rscpString:
+			v.value = string(raw)
+		case rscpContainer:
+			children, err := decodeRSCPValues(raw)
+			if err != nil {
+				return nil, err
+			}
+			v.value = children
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
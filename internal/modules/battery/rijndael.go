@@ -0,0 +1,190 @@
+package battery
+
+import "fmt"
+
+// rijndael is the Rijndael block cipher with a 256 bit key and a block size of
+// 128 bits (AES-256) or 256 bits. E3DC encrypts RSCP with 256 bit blocks,
+// which crypto/aes doesn't support.
+type rijndael struct {
+	nb     int       // block size in 32 bit words
+	rounds int       // number of rounds
+	keys   [][4]byte // expanded key, nb words per round
+}
+
+// rijndaelRounds is the number of rounds for a 256 bit key with 128 or 256 bit blocks
+const rijndaelRounds = 14
+
+var (
+	sbox    [256]byte
+	invSbox [256]byte
+)
+
+func init() {
+	// Generate the S-box from the multiplicative inverse in GF(2^8) followed by
+	// the affine transformation, walking p through all elements with generator 3
+	var p, q byte = 1, 1
+	for {
+		p = p ^ mul2(p)
+		q ^= q << 1
+		q ^= q << 2
+		q ^= q << 4
+		if q&0x80 != 0 {
+			q ^= 0x09
+		}
+		value := q ^ rotl8(q, 1) ^ rotl8(q, 2) ^ rotl8(q, 3) ^ rotl8(q, 4) ^ 0x63
+		sbox[p] = value
+		invSbox[value] = p
+		if p == 1 {
+			break
+		}
+	}
+	sbox[0] = 0x63
+	invSbox[0x63] = 0
+}
+
+// newRijndael creates a cipher for a 32 byte key and a block size of 16 or 32 bytes
+func newRijndael(key []byte, blockSize int) (*rijndael, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid key size %d", len(key))
+	}
+	if blockSize != 16 && blockSize != 32 {
+		return nil, fmt.Errorf("invalid block size %d", blockSize)
+	}
+
+	const nk = 8
+	r := &rijndael{nb: blockSize / 4, rounds: rijndaelRounds}
+	r.keys = make([][4]byte, r.nb*(r.rounds+1))
+	for i := 0; i < nk; i++ {
+		copy(r.keys[i][:], key[4*i:])
+	}
+	var rcon byte = 1
+	for i := nk; i < len(r.keys); i++ {
+		temp := r.keys[i-1]
+		switch i % nk {
+		case 0:
+			temp = [4]byte{sbox[temp[1]] ^ rcon, sbox[temp[2]], sbox[temp[3]], sbox[temp[0]]}
+			rcon = mul2(rcon)
+		case 4:
+			temp = [4]byte{sbox[temp[0]], sbox[temp[1]], sbox[temp[2]], sbox[temp[3]]}
+		}
+		for j := range temp {
+			r.keys[i][j] = r.keys[i-nk][j] ^ temp[j]
+		}
+	}
+	return r, nil
+}
+
+// BlockSize returns the block size in bytes
+func (r *rijndael) BlockSize() int {
+	return 4 * r.nb
+}
+
+// Encrypt encrypts a single block from src into dst
+func (r *rijndael) Encrypt(dst, src []byte) {
+	state := make([]byte, r.BlockSize())
+	copy(state, src)
+
+	r.addRoundKey(state, 0)
+	for round := 1; round <= r.rounds; round++ {
+		for i := range state {
+			state[i] = sbox[state[i]]
+		}
+		r.shiftRows(state, false)
+		if round != r.rounds {
+			mixColumns(state, false)
+		}
+		r.addRoundKey(state, round)
+	}
+	copy(dst, state)
+}
+
+// Decrypt decrypts a single block from src into dst
+func (r *rijndael) Decrypt(dst, src []byte) {
+	state := make([]byte, r.BlockSize())
+	copy(state, src)
+
+	r.addRoundKey(state, r.rounds)
+	for round := r.rounds - 1; round >= 0; round-- {
+		r.shiftRows(state, true)
+		for i := range state {
+			state[i] = invSbox[state[i]]
+		}
+		r.addRoundKey(state, round)
+		if round != 0 {
+			mixColumns(state, true)
+		}
+	}
+	copy(dst, state)
+}
+
+// addRoundKey adds the key of a round to the state
+func (r *rijndael) addRoundKey(state []byte, round int) {
+	for c := 0; c < r.nb; c++ {
+		for row := 0; row < 4; row++ {
+			state[4*c+row] ^= r.keys[round*r.nb+c][row]
+		}
+	}
+}
+
+// shiftRows rotates the rows of the state by the offsets of the block size
+func (r *rijndael) shiftRows(state []byte, inverse bool) {
+	offsets := [4]int{0, 1, 2, 3}
+	if r.nb == 8 {
+		offsets = [4]int{0, 1, 3, 4}
+	}
+	shifted := make([]byte, len(state))
+	for c := 0; c < r.nb; c++ {
+		for row := 0; row < 4; row++ {
+			from := (c + offsets[row]) % r.nb
+			if inverse {
+				from = (c - offsets[row] + r.nb) % r.nb
+			}
+			shifted[4*c+row] = state[4*from+row]
+		}
+	}
+	copy(state, shifted)
+}
+
+// mixColumns mixes each column of the state
+func mixColumns(state []byte, inverse bool) {
+	for c := 0; c < len(state); c += 4 {
+		a0, a1, a2, a3 := state[c], state[c+1], state[c+2], state[c+3]
+		if inverse {
+			state[c] = mul(a0, 14) ^ mul(a1, 11) ^ mul(a2, 13) ^ mul(a3, 9)
+			state[c+1] = mul(a0, 9) ^ mul(a1, 14) ^ mul(a2, 11) ^ mul(a3, 13)
+			state[c+2] = mul(a0, 13) ^ mul(a1, 9) ^ mul(a2, 14) ^ mul(a3, 11)
+			state[c+3] = mul(a0, 11) ^ mul(a1, 13) ^ mul(a2, 9) ^ mul(a3, 14)
+		} else {
+			state[c] = mul2(a0) ^ mul(a1, 3) ^ a2 ^ a3
+			state[c+1] = a0 ^ mul2(a1) ^ mul(a2, 3) ^ a3
+			state[c+2] = a0 ^ a1 ^ mul2(a2) ^ mul(a3, 3)
+			state[c+3] = mul(a0, 3) ^ a1 ^ a2 ^ mul2(a3)
+		}
+	}
+}
+
+// mul2 multiplies by x in GF(2^8)
+func mul2(b byte) byte {
+	if b&0x80 != 0 {
+		return b<<1 ^ 0x1B
+	}
+	return b << 1
+}
+
+// mul multiplies two elements of GF(2^8)
+func mul(a, b byte) byte {
+	var result byte
+	for b != 0 {
+		if b&1 != 0 {
+			result ^= a
+		}
+		a = mul2(a)
+		b >>= 1
+	}
+	return result
+}
+
+// rotl8 rotates a byte left
+func rotl8(b byte, shift uint) byte {
+	return b<<shift | b>>(8-shift)
+}
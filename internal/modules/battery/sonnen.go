@@ -0,0 +1,82 @@
+package battery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// sonnenStatus is the response of the Sonnen status endpoint
+type sonnenStatus struct {
+	USOC      *float64 `json:"USOC"`        // state of charge as shown to the user in percent
+	PacTotalW *float64 `json:"Pac_total_W"` // battery inverter AC power in W, positive when discharging
+}
+
+// sonnenBattery is the response of the Sonnen battery endpoint
+type sonnenBattery struct {
+	CycleCount *float64 `json:"cyclecount"` // reported as float by some firmware versions
+}
+
+// SonnenClient reads a sonnenBatterie via its local JSON API (v2)
+type SonnenClient struct {
+	url        string
+	token      string
+	httpClient *http.Client
+	onResponse func(*http.Response, error) // called with the result of each request, nil to ignore
+}
+
+// Read reads the status and the cycle count of the battery
+func (c *SonnenClient) Read(ctx context.Context) (Reading, error) {
+	var status sonnenStatus
+	if err := c.get(ctx, "/api/v2/status", &status); err != nil {
+		return Reading{}, fmt.Errorf("failed to read status: %w", err)
+	}
+
+	reading := Reading{SOC: status.USOC}
+	if status.PacTotalW != nil {
+		power := -*status.PacTotalW
+		reading.Power = &power
+	}
+
+	// The cycle count is optional, older firmware doesn't report it
+	var battery sonnenBattery
+	if err := c.get(ctx, "/api/v2/battery", &battery); err != nil {
+		utils.Debugf("Failed to read Sonnen battery data: %v", err)
+	} else if battery.CycleCount != nil {
+		cycles := int64(*battery.CycleCount)
+		reading.Cycles = &cycles
+	}
+
+	return reading, nil
+}
+
+// get sends an authenticated request and decodes the JSON response into result
+func (c *SonnenClient) get(ctx context.Context, path string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Auth-Token", c.token)
+
+	resp, err := c.httpClient.Do(req)
+	if c.onResponse != nil {
+		c.onResponse(resp, err)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
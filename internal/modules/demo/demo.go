@@ -1,26 +1,114 @@
-// Package demo provides a demonstration metric collection module.
-// It generates sample metrics at regular intervals for testing purposes.
+// Package demo provides a demonstration metric collection module. It either
+// generates sample metrics for testing the agent, or simulates a household
+// with a PV system, a fridge and an electric car for trying dashboards
+// without the hardware.
 package demo
 
 import (
 	"context"
+	"fmt"
 	"math/rand/v2"
 	"os"
 	"time"
 
-	"github.com/janhuddel/metrics-agent/internal/metrics"
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
 )
 
-// Run generates demo metrics every 5 seconds and sends them through the channel.
+// Scenarios
+const (
+	scenarioSample    = "sample"    // random demo_metric values
+	scenarioHousehold = "household" // simulated household electricity
+)
+
+// Config represents the configuration for the demo module
+type Config struct {
+	config.BaseConfig
+	Scenario  string          `json:"scenario,omitempty"`  // "sample" (default) or "household"
+	Interval  config.Duration `json:"interval,omitempty"`  // Reporting interval (defaults to 5s)
+	Latitude  float64         `json:"latitude,omitempty"`  // Location of the simulated PV system (defaults to Berlin)
+	Longitude float64         `json:"longitude,omitempty"` // Location of the simulated PV system (defaults to Berlin)
+	PVPeak    float64         `json:"pv_peak,omitempty"`   // Peak power of the simulated PV system in W (defaults to 8000)
+	Seed      uint64          `json:"seed,omitempty"`      // Seed of the simulation, 0 for a different household on every start
+}
+
+// DefaultConfig returns the default configuration of the demo module.
+func DefaultConfig() Config {
+	return Config{
+		Scenario:  scenarioSample,
+		Interval:  config.Duration(5 * time.Second),
+		Latitude:  52.52,
+		Longitude: 13.40,
+		PVPeak:    8000,
+	}
+}
+
+// LoadConfig loads the demo module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("demo")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
+
+// validate checks the configuration and applies defaults
+func validate(cfg *Config) error {
+	switch cfg.Scenario {
+	case "":
+		cfg.Scenario = scenarioSample
+	case scenarioSample, scenarioHousehold:
+	default:
+		return fmt.Errorf("scenario must be %q or %q, got %q", scenarioSample, scenarioHousehold, cfg.Scenario)
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(5 * time.Second)
+	}
+	if cfg.Latitude < -90 || cfg.Latitude > 90 || cfg.Longitude < -180 || cfg.Longitude > 180 {
+		return fmt.Errorf("invalid location %v, %v", cfg.Latitude, cfg.Longitude)
+	}
+	if cfg.PVPeak < 0 {
+		return fmt.Errorf("pv_peak must not be negative")
+	}
+	return nil
+}
+
+// Run generates demo metrics every interval and sends them through the channel.
 // It runs until the context is cancelled.
-// Panic simulation: If file "/tmp/metrics-agent-panic-demo" exists, the module will panic.
 func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	if err := validate(&cfg); err != nil {
+		return err
+	}
+	if cfg.Scenario == scenarioHousehold {
+		return runHousehold(ctx, ch, cfg)
+	}
+	return runSample(ctx, ch, cfg)
+}
+
+// runSample generates a random demo_metric every interval.
+// Panic simulation: If file "/tmp/metrics-agent-panic-demo" exists, the module will panic.
+func runSample(ctx context.Context, ch chan<- metrics.Metric, cfg Config) error {
 	host, _ := os.Hostname()
-	ticker := time.NewTicker(5 * time.Second)
+	clock := utils.ClockFromContext(ctx)
+	ticker := time.NewTicker(cfg.Interval.Duration())
 	defer ticker.Stop()
 
 	// Send first metric immediately on start
-	ch <- makeMetric(host)
+	ch <- makeMetric(host, clock.Now())
 
 	for {
 		select {
@@ -31,13 +119,13 @@ func Run(ctx context.Context, ch chan<- metrics.Metric) error {
 			if _, err := os.Stat("/tmp/metrics-agent-panic-demo"); err == nil {
 				panic("Demo module panic triggered by /tmp/metrics-agent-panic-demo file")
 			}
-			ch <- makeMetric(host)
+			ch <- makeMetric(host, clock.Now())
 		}
 	}
 }
 
 // makeMetric creates a demo metric with random values.
-func makeMetric(host string) metrics.Metric {
+func makeMetric(host string, timestamp time.Time) metrics.Metric {
 	return metrics.Metric{
 		Name: "demo_metric",
 		Tags: map[string]string{
@@ -47,6 +135,6 @@ func makeMetric(host string) metrics.Metric {
 		Fields: map[string]interface{}{
 			"value": 10 + rand.IntN(90),
 		},
-		Timestamp: time.Now(),
+		Timestamp: timestamp,
 	}
 }
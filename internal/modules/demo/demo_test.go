@@ -5,8 +5,10 @@ import (
 	"testing"
 	"time"
 
-	"github.com/janhuddel/metrics-agent/internal/metrics"
 	"github.com/janhuddel/metrics-agent/internal/modules/demo"
+	"github.com/janhuddel/metrics-agent/internal/testutil"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
 )
 
 // TestDemoModulePublishesMetrics tests that the demo module publishes metrics correctly.
@@ -35,3 +37,20 @@ func TestDemoModulePublishesMetrics(t *testing.T) {
 		t.Fatal("no metric received within 2s")
 	}
 }
+
+// TestDemoModuleUsesClock tests that the demo module takes timestamps from the clock in the context.
+func TestDemoModuleUsesClock(t *testing.T) {
+	sink := testutil.NewCollectingSink(t)
+	clock := testutil.NewClock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+	ctx, cancel := context.WithCancel(utils.WithClock(context.Background(), clock))
+	defer cancel()
+
+	go func() {
+		_ = demo.Run(ctx, sink.Chan())
+	}()
+
+	m := sink.WaitFor(t, 1)[0]
+	if !m.Timestamp.Equal(clock.Now()) {
+		t.Errorf("Expected timestamp %v from the clock, got %v", clock.Now(), m.Timestamp)
+	}
+}
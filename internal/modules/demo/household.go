@@ -0,0 +1,297 @@
+package demo
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// Simulated devices of the household scenario
+const (
+	devicePV      = "demo_pv"
+	deviceFridge  = "demo_fridge"
+	deviceWallbox = "demo_wallbox"
+	deviceGrid    = "demo_grid"
+)
+
+// wallboxPower is the charging power of the simulated wallbox (11 kW, three phases)
+const wallboxPower = 11000.0
+
+// household simulates the electricity of a household with a PV system, a
+// fridge and an electric car, reported like the energy modules do, so that
+// dashboards built on the agent can be tried without the hardware.
+type household struct {
+	config Config
+	rng    *rand.Rand
+	last   time.Time // time of the last step, zero before the first
+
+	clouds float64 // share of the PV peak reaching the panels, drifts slowly
+
+	fridgeOn    bool
+	fridgeUntil time.Time // end of the current compressor cycle or pause
+
+	session    charging // plan of the current day
+	sessionDay time.Time
+
+	// Energy in kWh
+	pvToday, pvTotal         float64
+	fridgeTotal              float64
+	wallboxTotal             float64
+	importTotal, exportTotal float64
+	importToday, exportToday float64
+	day                      time.Time // start of the day of the today totals
+}
+
+// charging is the charging session of the car on a day.
+type charging struct {
+	arrival time.Time // zero if the car isn't charged that day
+	target  float64   // energy charged in kWh
+	charged float64   // energy charged so far in kWh
+}
+
+// newHousehold creates a household simulation. Its totals start at plausible
+// meter readings of a few years of operation.
+func newHousehold(cfg Config) *household {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	rng := rand.New(rand.NewPCG(seed, seed))
+	return &household{
+		config:       cfg,
+		rng:          rng,
+		clouds:       0.5 + rng.Float64()/2,
+		pvTotal:      15000 + rng.Float64()*10000,
+		fridgeTotal:  800 + rng.Float64()*400,
+		wallboxTotal: 5000 + rng.Float64()*5000,
+		importTotal:  20000 + rng.Float64()*10000,
+		exportTotal:  10000 + rng.Float64()*5000,
+	}
+}
+
+// runHousehold reports the simulated household every interval until the
+// context is cancelled.
+func runHousehold(ctx context.Context, ch chan<- metrics.Metric, cfg Config) error {
+	clock := utils.ClockFromContext(ctx)
+	h := newHousehold(cfg)
+	ticker := time.NewTicker(cfg.Interval.Duration())
+	defer ticker.Stop()
+
+	for {
+		for _, metric := range h.step(clock.Now()) {
+			select {
+			case ch <- metric:
+			default:
+				utils.Warnf("Metrics channel is full, dropping metric for %s", metric.Tags["device"])
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// step advances the simulation to now and returns the metrics of all devices.
+func (h *household) step(now time.Time) []metrics.Metric {
+	dt := time.Duration(0)
+	if !h.last.IsZero() && now.After(h.last) {
+		dt = now.Sub(h.last)
+	}
+	h.last = now
+
+	local := now.In(utils.Location())
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	if !day.Equal(h.day) {
+		h.day = day
+		h.pvToday, h.importToday, h.exportToday = 0, 0, 0
+	}
+
+	pv := h.pvPower(now, dt)
+	fridge := h.fridgePower(now)
+	wallbox := h.wallboxPower(local, dt)
+	consumption := h.baseLoad(local) + fridge + wallbox
+	grid := consumption - pv
+
+	hours := dt.Hours()
+	h.pvToday += pv * hours / 1000
+	h.pvTotal += pv * hours / 1000
+	h.fridgeTotal += fridge * hours / 1000
+	h.wallboxTotal += wallbox * hours / 1000
+	gridImport, gridExport := max(grid, 0), max(-grid, 0)
+	h.importToday += gridImport * hours / 1000
+	h.importTotal += gridImport * hours / 1000
+	h.exportToday += gridExport * hours / 1000
+	h.exportTotal += gridExport * hours / 1000
+
+	return []metrics.Metric{
+		h.metric(devicePV, "PV", now, map[string]interface{}{
+			"power":           round(pv, 1),
+			"sum_power_today": round(h.pvToday, 3),
+			"sum_power_total": round(h.pvTotal, 3),
+		}),
+		h.metric(deviceFridge, "Kühlschrank", now, map[string]interface{}{
+			"power":           round(fridge, 1),
+			"sum_power_total": round(h.fridgeTotal, 3),
+		}),
+		h.metric(deviceWallbox, "Wallbox", now, map[string]interface{}{
+			"power":           round(wallbox, 1),
+			"charging":        wallbox > 0,
+			"session_energy":  round(h.session.charged, 3),
+			"sum_power_total": round(h.wallboxTotal, 3),
+		}),
+		h.metric(deviceGrid, "Hausanschluss", now, map[string]interface{}{
+			"power":               round(consumption, 1),
+			"grid_power":          round(grid, 1),
+			"grid_import_power":   round(gridImport, 1),
+			"grid_export_power":   round(gridExport, 1),
+			"sum_power_today":     round(h.importToday, 3),
+			"sum_power_today_out": round(h.exportToday, 3),
+			"sum_power_total":     round(h.importTotal, 3),
+			"sum_power_total_out": round(h.exportTotal, 3),
+		}),
+	}
+}
+
+// metric creates an electricity metric of a simulated device.
+func (h *household) metric(device, friendly string, now time.Time, fields map[string]interface{}) metrics.Metric {
+	return metrics.Metric{
+		Name: "electricity",
+		Tags: map[string]string{
+			"vendor":   "demo",
+			"device":   device,
+			"friendly": h.config.GetFriendlyName(device, "", friendly),
+		},
+		Fields:     fields,
+		FieldKinds: metrics.ElectricityKinds,
+		Timestamp:  now,
+	}
+}
+
+// pvPower returns the PV power in W, following the sun elevation at the
+// configured location and drifting clouds.
+func (h *household) pvPower(now time.Time, dt time.Duration) float64 {
+	// Clouds drift by a random walk, faster over longer steps
+	h.clouds += h.rng.NormFloat64() * 0.03 * math.Sqrt(dt.Minutes())
+	h.clouds = min(max(h.clouds, 0.15), 1)
+
+	elevation := sunElevation(now, h.config.Latitude, h.config.Longitude)
+	if elevation <= 0 {
+		return 0
+	}
+	// Short-term flicker of passing clouds
+	flicker := 1 - 0.1*h.rng.Float64()*(1-h.clouds)
+	return h.config.PVPeak * math.Sin(elevation) * h.clouds * flicker
+}
+
+// sunElevation returns the elevation of the sun in radians at a location,
+// accurate to about a degree, which is plenty for a simulation.
+func sunElevation(t time.Time, latitude, longitude float64) float64 {
+	t = t.UTC()
+	dayOfYear := float64(t.YearDay())
+	declination := -23.44 * math.Pi / 180 * math.Cos(2*math.Pi/365*(dayOfYear+10))
+
+	// Equation of time in minutes, the deviation of the solar time from the mean time
+	b := 2 * math.Pi / 364 * (dayOfYear - 81)
+	equation := 9.87*math.Sin(2*b) - 7.53*math.Cos(b) - 1.5*math.Sin(b)
+
+	solarHours := float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600 + longitude/15 + equation/60
+	hourAngle := (solarHours - 12) * 15 * math.Pi / 180
+
+	lat := latitude * math.Pi / 180
+	sin := math.Sin(lat)*math.Sin(declination) + math.Cos(lat)*math.Cos(declination)*math.Cos(hourAngle)
+	return math.Asin(sin)
+}
+
+// fridgePower returns the power of the fridge in W. The compressor runs for
+// 12 to 18 minutes and pauses for 25 to 40 minutes.
+func (h *household) fridgePower(now time.Time) float64 {
+	if h.fridgeUntil.IsZero() {
+		// Start somewhere within a cycle
+		h.fridgeOn = h.rng.IntN(3) == 0
+		h.fridgeUntil = now.Add(time.Duration(h.rng.IntN(20)) * time.Minute)
+	}
+	for !now.Before(h.fridgeUntil) {
+		h.fridgeOn = !h.fridgeOn
+		if h.fridgeOn {
+			h.fridgeUntil = h.fridgeUntil.Add(12*time.Minute + time.Duration(h.rng.IntN(6*60))*time.Second)
+		} else {
+			h.fridgeUntil = h.fridgeUntil.Add(25*time.Minute + time.Duration(h.rng.IntN(15*60))*time.Second)
+		}
+	}
+	if h.fridgeOn {
+		return 85 + h.rng.Float64()*10
+	}
+	return 1.5
+}
+
+// wallboxPower returns the charging power of the car in W. On weekdays the
+// car arrives in the evening, on weekends it is charged around noon on some
+// days, and it is charged until the energy used that day is back.
+func (h *household) wallboxPower(local time.Time, dt time.Duration) float64 {
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	if !day.Equal(h.sessionDay) {
+		h.sessionDay = day
+		h.session = h.planSession(day)
+	}
+
+	s := &h.session
+	if s.arrival.IsZero() || local.Before(s.arrival) || s.charged >= s.target {
+		return 0
+	}
+	// The step that ends the session charges only the remaining energy
+	if hours := dt.Hours(); hours > 0 {
+		s.charged = min(s.charged+wallboxPower*hours/1000, s.target)
+	}
+	if s.charged >= s.target {
+		return 0
+	}
+	return wallboxPower * (0.97 + 0.03*h.rng.Float64())
+}
+
+// planSession plans the charging session of a day.
+func (h *household) planSession(day time.Time) charging {
+	weekend := day.Weekday() == time.Saturday || day.Weekday() == time.Sunday
+	if weekend && h.rng.IntN(2) == 0 {
+		return charging{}
+	}
+	arrival := day.Add(17*time.Hour + time.Duration(h.rng.IntN(150))*time.Minute)
+	if weekend {
+		arrival = day.Add(11*time.Hour + time.Duration(h.rng.IntN(300))*time.Minute)
+	}
+	return charging{arrival: arrival, target: 8 + h.rng.Float64()*27}
+}
+
+// baseLoad returns the consumption of the household without the simulated
+// devices in W: standby at night, peaks for breakfast and in the evening.
+func (h *household) baseLoad(local time.Time) float64 {
+	hour := float64(local.Hour()) + float64(local.Minute())/60
+	load := 150.0
+	switch {
+	case hour >= 6.5 && hour < 8.5:
+		load += 700
+	case hour >= 8.5 && hour < 17:
+		load += 200
+	case hour >= 17 && hour < 22:
+		load += 500
+	case hour >= 22 && hour < 23.5:
+		load += 200
+	}
+	// Appliances switching on and off
+	if h.rng.IntN(20) == 0 {
+		load += 1000 + h.rng.Float64()*1000
+	}
+	return load + h.rng.NormFloat64()*30
+}
+
+// round rounds a value to the given number of decimals.
+func round(value float64, decimals int) float64 {
+	factor := math.Pow(10, float64(decimals))
+	return math.Round(value*factor) / factor
+}
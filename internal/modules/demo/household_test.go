@@ -0,0 +1,117 @@
+package demo
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+func TestSunElevation(t *testing.T) {
+	tests := []struct {
+		name     string
+		time     time.Time
+		min, max float64 // degrees
+	}{
+		{"Berlin summer noon", time.Date(2026, 6, 21, 11, 10, 0, 0, time.UTC), 59, 62},
+		{"Berlin winter noon", time.Date(2026, 12, 21, 11, 10, 0, 0, time.UTC), 13, 15},
+		{"Berlin midnight", time.Date(2026, 6, 21, 23, 10, 0, 0, time.UTC), -16, -12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			elevation := sunElevation(tt.time, 52.52, 13.40) * 180 / math.Pi
+			if elevation < tt.min || elevation > tt.max {
+				t.Errorf("Expected elevation between %v and %v, got %v", tt.min, tt.max, elevation)
+			}
+		})
+	}
+}
+
+func TestHouseholdDay(t *testing.T) {
+	if err := utils.SetTimezone("UTC"); err != nil {
+		t.Fatal(err)
+	}
+	defer utils.SetTimezone("")
+
+	cfg := DefaultConfig()
+	cfg.Seed = 42
+	h := newHousehold(cfg)
+
+	// A Wednesday in summer, simulated in steps of a minute
+	start := time.Date(2026, 6, 17, 0, 0, 0, 0, time.UTC)
+	var fridgeOn, charging int
+	var pvTotal, importTotal float64
+	var lastPV map[string]interface{}
+	for minute := 0; minute < 24*60; minute++ {
+		now := start.Add(time.Duration(minute) * time.Minute)
+		ms := h.step(now)
+		if len(ms) != 4 {
+			t.Fatalf("Expected 4 metrics, got %d", len(ms))
+		}
+		for _, m := range ms {
+			if err := m.Validate(); err != nil {
+				t.Fatalf("Invalid metric %+v: %v", m, err)
+			}
+		}
+		pv, fridge, wallbox, grid := ms[0].Fields, ms[1].Fields, ms[2].Fields, ms[3].Fields
+
+		if hour := now.Hour(); (hour < 2 || hour >= 22) && pv["power"].(float64) != 0 {
+			t.Errorf("Expected no PV power at %v, got %v", now, pv["power"])
+		}
+		if fridge["power"].(float64) > 50 {
+			fridgeOn++
+		}
+		if wallbox["charging"].(bool) {
+			charging++
+			if now.Hour() < 17 || wallbox["power"].(float64) < 10000 {
+				t.Errorf("Unexpected charging at %v with %v W", now, wallbox["power"])
+			}
+		}
+		balance := grid["power"].(float64) - pv["power"].(float64) - grid["grid_power"].(float64)
+		if math.Abs(balance) > 0.2 {
+			t.Errorf("Expected consumption minus PV to be the grid power at %v: %+v, %+v", now, grid, pv)
+		}
+		if pv["sum_power_total"].(float64) < pvTotal || grid["sum_power_total"].(float64) < importTotal {
+			t.Errorf("Expected totals to increase at %v", now)
+		}
+		pvTotal, importTotal = pv["sum_power_total"].(float64), grid["sum_power_total"].(float64)
+		lastPV = pv
+	}
+
+	if today := lastPV["sum_power_today"].(float64); today < 15 || today > 60 {
+		t.Errorf("Expected a summer day yield of 15 to 60 kWh, got %v", today)
+	}
+	if share := float64(fridgeOn) / (24 * 60); share < 0.2 || share > 0.5 {
+		t.Errorf("Expected the fridge compressor to run 20%% to 50%% of the day, got %v", share)
+	}
+	// 8 to 35 kWh at 11 kW take 44 to 191 minutes
+	if charging < 40 || charging > 195 {
+		t.Errorf("Expected an evening charging session, got %d minutes", charging)
+	}
+	if h.session.charged != h.session.target {
+		t.Errorf("Expected the session to charge %v kWh, got %v", h.session.target, h.session.charged)
+	}
+
+	// The today totals start again at midnight
+	ms := h.step(start.Add(24 * time.Hour))
+	if today := ms[0].Fields["sum_power_today"].(float64); today != 0 {
+		t.Errorf("Expected PV yield of the new day to start at 0, got %v", today)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Config{}
+	if err := validate(&cfg); err != nil || cfg.Scenario != scenarioSample || cfg.Interval.Duration() != 5*time.Second {
+		t.Errorf("Expected defaults, got %+v (%v)", cfg, err)
+	}
+	for _, cfg := range []Config{
+		{Scenario: "factory"},
+		{Scenario: scenarioHousehold, Latitude: 91},
+		{Scenario: scenarioHousehold, PVPeak: -1},
+	} {
+		if err := validate(&cfg); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
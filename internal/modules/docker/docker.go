@@ -0,0 +1,466 @@
+// Package docker provides a metric collection module for Docker containers.
+// It queries the Docker Engine API, usually via the local Docker socket, and
+// reports CPU, memory and network usage as well as the restart count of each
+// container on the host.
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/connection"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// defaultHost is the Docker socket of Linux hosts
+	defaultHost = "unix:///var/run/docker.sock"
+
+	// Metric name
+	metricName = "container"
+)
+
+// containerCounterKinds are the kinds of the network fields, which Docker
+// reports as totals since the container was started
+var containerCounterKinds = map[string]metrics.Kind{
+	"net_in":  metrics.KindCounter,
+	"net_out": metrics.KindCounter,
+}
+
+// Config represents the configuration for the Docker module
+type Config struct {
+	config.BaseConfig
+	Host           string          `json:"host"`                      // Docker Engine address (defaults to "unix:///var/run/docker.sock", or e.g. "tcp://192.168.1.5:2375")
+	Containers     []string        `json:"containers"`                // Container names to monitor (defaults to all containers)
+	IncludeStopped bool            `json:"include_stopped,omitempty"` // Also report stopped containers
+	Interval       config.Duration `json:"interval,omitempty"`        // Polling interval (defaults to 30s)
+	Timeout        config.Duration `json:"timeout,omitempty"`         // Request timeout (defaults to 10s)
+}
+
+// Container represents a container in the container list
+type Container struct {
+	ID     string   `json:"Id"`
+	Names  []string `json:"Names"`
+	Image  string   `json:"Image"`
+	State  string   `json:"State"`
+	Status string   `json:"Status"`
+}
+
+// Name returns the container name without the leading slash
+func (c Container) Name() string {
+	if len(c.Names) == 0 {
+		return c.ID
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// ContainerInspect holds the inspected details of a container
+type ContainerInspect struct {
+	RestartCount int64 `json:"RestartCount"`
+	State        struct {
+		StartedAt time.Time `json:"StartedAt"`
+	} `json:"State"`
+}
+
+// ContainerStats is the single stats sample of a container
+type ContainerStats struct {
+	CPUStats struct {
+		CPUUsage struct {
+			TotalUsage uint64 `json:"total_usage"`
+		} `json:"cpu_usage"`
+		SystemCPUUsage uint64 `json:"system_cpu_usage"`
+		OnlineCPUs     int    `json:"online_cpus"`
+	} `json:"cpu_stats"`
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Limit uint64            `json:"limit"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+	Networks map[string]struct {
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+	} `json:"networks"`
+}
+
+// cpuSample is the CPU time of a container and the host at a poll
+type cpuSample struct {
+	container uint64
+	system    uint64
+}
+
+// DockerModule handles polling of the Docker Engine API
+type DockerModule struct {
+	config      Config
+	baseURL     string
+	httpClient  *http.Client
+	metricsCh   chan<- metrics.Metric
+	clock       utils.Clock
+	collections *utils.CollectionLog // last successful collection, nil if not remembered
+	tracker     *connection.Tracker
+
+	mu      sync.Mutex
+	samples map[string]cpuSample // last CPU sample by container ID
+}
+
+// Run starts the Docker module and begins collecting metrics
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewDockerModule(config)
+	if err != nil {
+		return fmt.Errorf("failed to create Docker module: %w", err)
+	}
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+	module.collections = utils.OpenCollectionLog(config.InstanceName("docker"), module.clock)
+
+	return module.run(ctx)
+}
+
+// Probe validates the Docker configuration and checks that the Docker Engine is reachable
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewDockerModule(cfg)
+	if err != nil {
+		return &config.ModuleError{Module: "docker", Err: err}
+	}
+	return module.ping(ctx)
+}
+
+// NewDockerModule creates a new Docker module instance
+func NewDockerModule(cfg Config) (*DockerModule, error) {
+	utils.Debugf("Creating new Docker module instance")
+
+	if cfg.Host == "" {
+		cfg.Host = defaultHost
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(30 * time.Second)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = config.Duration(10 * time.Second)
+	}
+
+	host, err := url.Parse(cfg.Host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host %q: %w", cfg.Host, err)
+	}
+
+	module := &DockerModule{
+		clock:   utils.SystemClock,
+		config:  cfg,
+		samples: make(map[string]cpuSample),
+	}
+
+	switch host.Scheme {
+	case "unix":
+		// The local socket isn't network egress, so it bypasses the allowlist
+		socket := host.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		module.baseURL = "http://docker"
+		module.httpClient = &http.Client{Timeout: cfg.Timeout.Duration(), Transport: transport}
+	case "tcp", "http":
+		if host.Host == "" {
+			return nil, fmt.Errorf("invalid host %q: address is missing", cfg.Host)
+		}
+		module.baseURL = "http://" + host.Host
+		module.httpClient = &http.Client{
+			Timeout:   cfg.Timeout.Duration(),
+			Transport: utils.OutboundTransport(cfg.InstanceName("docker"), nil),
+		}
+	default:
+		return nil, fmt.Errorf("unsupported host %q, expected unix:// or tcp://", cfg.Host)
+	}
+
+	utils.Debugf("Docker module created successfully")
+	return module, nil
+}
+
+// DefaultConfig returns the default configuration of the Docker module.
+func DefaultConfig() Config {
+	return Config{
+		Host:     defaultHost,
+		Interval: config.Duration(30 * time.Second),
+		Timeout:  config.Duration(10 * time.Second),
+	}
+}
+
+// LoadConfig loads the Docker module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("docker")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
+
+// run executes the main module loop
+func (dm *DockerModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Docker module", "main", func() error {
+		interval := dm.config.Interval.Duration()
+		ticker := utils.NewScheduledTicker(ctx, interval)
+		defer ticker.Stop()
+
+		// Collect initial data unless outside the collection schedule or skipped,
+		// but not before an interval has passed since the last collection
+		initial := dm.collections.Initial(ctx, interval)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-initial:
+				if err := utils.CollectWithRetry(ctx, interval, dm.collectData); err != nil {
+					utils.Warnf("Failed to collect initial Docker data: %v", err)
+				} else {
+					dm.collections.Record()
+				}
+			case <-ticker.C:
+				if err := utils.CollectWithRetry(ctx, interval, dm.collectData); err != nil {
+					utils.Warnf("Failed to collect Docker data: %v", err)
+				} else {
+					dm.collections.Record()
+				}
+			}
+		}
+	})
+}
+
+// ping checks that the Docker Engine answers
+func (dm *DockerModule) ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dm.baseURL+"/_ping", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := dm.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("docker engine %s is not reachable: %w", dm.config.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("docker engine %s answered with status %d: %s", dm.config.Host, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// collectData lists the containers and sends a metric for each of them
+func (dm *DockerModule) collectData(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Docker data collection", "engine", func() error {
+		path := "/containers/json"
+		if dm.config.IncludeStopped {
+			path += "?all=true"
+		}
+		var containers []Container
+		if err := dm.get(ctx, path, &containers, true); err != nil {
+			return fmt.Errorf("failed to list containers: %w", err)
+		}
+
+		timestamp := dm.clock.Now()
+		seen := make(map[string]bool, len(containers))
+		for _, container := range dm.selectContainers(containers) {
+			seen[container.ID] = true
+			if err := dm.collectContainer(ctx, container, timestamp); err != nil {
+				utils.Warnf("Failed to collect container %s: %v", container.Name(), err)
+			}
+		}
+
+		// Forget the CPU samples of removed containers
+		dm.mu.Lock()
+		for id := range dm.samples {
+			if !seen[id] {
+				delete(dm.samples, id)
+			}
+		}
+		dm.mu.Unlock()
+
+		return nil
+	})
+}
+
+// selectContainers filters the containers by the configured names, keeping all containers if none are configured
+func (dm *DockerModule) selectContainers(containers []Container) []Container {
+	if len(dm.config.Containers) == 0 {
+		return containers
+	}
+
+	available := make(map[string]Container, len(containers))
+	for _, container := range containers {
+		available[container.Name()] = container
+	}
+
+	selected := make([]Container, 0, len(dm.config.Containers))
+	for _, name := range dm.config.Containers {
+		if container, exists := available[name]; exists {
+			selected = append(selected, container)
+		} else {
+			utils.Debugf("Configured container %s not found", name)
+		}
+	}
+	return selected
+}
+
+// collectContainer reads the restart count and, for running containers, the stats of a container
+func (dm *DockerModule) collectContainer(ctx context.Context, container Container, timestamp time.Time) error {
+	var inspect ContainerInspect
+	if err := dm.get(ctx, "/containers/"+container.ID+"/json", &inspect, false); err != nil {
+		return err
+	}
+
+	running := container.State == "running"
+	fields := map[string]interface{}{
+		"status":        container.State,
+		"running":       running,
+		"restart_count": inspect.RestartCount,
+	}
+
+	if running {
+		var stats ContainerStats
+		if err := dm.get(ctx, "/containers/"+container.ID+"/stats?stream=false&one-shot=true", &stats, false); err != nil {
+			return err
+		}
+		dm.addStatsFields(container.ID, stats, fields)
+		if !inspect.State.StartedAt.IsZero() {
+			fields["uptime"] = int64(timestamp.Sub(inspect.State.StartedAt).Seconds())
+		}
+	}
+
+	dm.sendContainerMetric(container, fields, timestamp)
+	return nil
+}
+
+// addStatsFields adds the CPU, memory and network fields of a stats sample.
+// The CPU usage is computed from the previous poll, so it is missing on the first one.
+func (dm *DockerModule) addStatsFields(id string, stats ContainerStats, fields map[string]interface{}) {
+	sample := cpuSample{container: stats.CPUStats.CPUUsage.TotalUsage, system: stats.CPUStats.SystemCPUUsage}
+	dm.mu.Lock()
+	previous, exists := dm.samples[id]
+	dm.samples[id] = sample
+	dm.mu.Unlock()
+
+	if exists && sample.system > previous.system && sample.container >= previous.container {
+		cpus := stats.CPUStats.OnlineCPUs
+		if cpus <= 0 {
+			cpus = 1
+		}
+		containerDelta := float64(sample.container - previous.container)
+		systemDelta := float64(sample.system - previous.system)
+		fields["cpu_usage"] = containerDelta / systemDelta * float64(cpus) * 100
+	}
+
+	// Like the docker CLI, the page cache doesn't count as used memory
+	memory := stats.MemoryStats
+	used := memory.Usage
+	if cache, exists := memory.Stats["inactive_file"]; exists && cache < used {
+		used -= cache
+	} else if cache, exists := memory.Stats["total_inactive_file"]; exists && cache < used {
+		used -= cache
+	}
+	fields["memory_used"] = used
+	if memory.Limit > 0 {
+		fields["memory_limit"] = memory.Limit
+	}
+
+	if stats.Networks != nil {
+		var in, out uint64
+		for _, network := range stats.Networks {
+			in += network.RxBytes
+			out += network.TxBytes
+		}
+		fields["net_in"] = in
+		fields["net_out"] = out
+	}
+}
+
+// get sends a request to the Docker Engine and decodes the JSON response into result.
+// Only the container list reports the connection status, so a poll counts once.
+func (dm *DockerModule) get(ctx context.Context, path string, result interface{}, track bool) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dm.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := dm.httpClient.Do(req)
+	if track {
+		dm.connection().SetPollResult(resp, err)
+	}
+	if err != nil {
+		return fmt.Errorf("API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse API response: %w", err)
+	}
+	return nil
+}
+
+// connection returns the tracker for the Docker Engine, creating it on first use
+func (dm *DockerModule) connection() *connection.Tracker {
+	if dm.tracker == nil {
+		dm.tracker = connection.NewTracker(dm.config.InstanceName("docker"), dm.config.Host, dm.metricsCh)
+	}
+	return dm.tracker
+}
+
+// sendContainerMetric creates and sends a metric for a container
+func (dm *DockerModule) sendContainerMetric(container Container, fields map[string]interface{}, timestamp time.Time) {
+	name := container.Name()
+	metric := metrics.Metric{
+		Name: metricName,
+		Tags: map[string]string{
+			"vendor":   "docker",
+			"device":   name,
+			"friendly": dm.config.GetFriendlyName(name, "", name),
+			"image":    container.Image,
+		},
+		Fields:     fields,
+		FieldKinds: containerCounterKinds,
+		Timestamp:  timestamp,
+	}
+
+	if err := metric.Validate(); err != nil {
+		utils.Warnf("Invalid metric for container %s: %v", name, err)
+		return
+	}
+
+	select {
+	case dm.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping metric for container %s", name)
+	}
+}
@@ -0,0 +1,134 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestNewDockerModule(t *testing.T) {
+	tah := utils.NewTestAssertionHelper()
+
+	_, err := NewDockerModule(Config{Host: "ssh://docker-host"})
+	tah.AssertError(t, err, "Expected error for unsupported host")
+
+	_, err = NewDockerModule(Config{Host: "tcp://"})
+	tah.AssertError(t, err, "Expected error for missing address")
+
+	module, err := NewDockerModule(Config{})
+	tah.AssertNoError(t, err, "Failed to create Docker module")
+	if module.config.Host != defaultHost || module.baseURL != "http://docker" {
+		t.Errorf("Unexpected host %s or base URL %s", module.config.Host, module.baseURL)
+	}
+
+	module, err = NewDockerModule(Config{Host: "tcp://192.168.1.5:2375"})
+	tah.AssertNoError(t, err, "Failed to create Docker module")
+	if module.baseURL != "http://192.168.1.5:2375" {
+		t.Errorf("Unexpected base URL %s", module.baseURL)
+	}
+}
+
+// serveDocker serves a fake Docker Engine API on a unix socket
+func serveDocker(t *testing.T, cpuUsage *uint64) string {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/json":
+			fmt.Fprint(w, `[
+				{"Id":"abc","Names":["/homeassistant"],"Image":"ghcr.io/home-assistant/home-assistant:stable","State":"running"},
+				{"Id":"def","Names":["/influxdb"],"Image":"influxdb:2","State":"restarting"}
+			]`)
+		case "/containers/abc/json":
+			fmt.Fprintf(w, `{"RestartCount":0,"State":{"StartedAt":%q}}`, time.Now().Add(-time.Hour).Format(time.RFC3339Nano))
+		case "/containers/def/json":
+			fmt.Fprint(w, `{"RestartCount":7,"State":{"StartedAt":"0001-01-01T00:00:00Z"}}`)
+		case "/containers/abc/stats":
+			*cpuUsage += 2000000000
+			fmt.Fprintf(w, `{
+				"cpu_stats":{"cpu_usage":{"total_usage":%d},"system_cpu_usage":%d,"online_cpus":4},
+				"memory_stats":{"usage":500000000,"limit":2000000000,"stats":{"inactive_file":100000000}},
+				"networks":{"eth0":{"rx_bytes":1000,"tx_bytes":2000},"eth1":{"rx_bytes":10,"tx_bytes":20}}
+			}`, *cpuUsage, *cpuUsage*10)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return "unix://" + socket
+}
+
+func TestCollectData(t *testing.T) {
+	var cpuUsage uint64
+	module, err := NewDockerModule(Config{Host: serveDocker(t, &cpuUsage)})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.metricsCh = ch
+
+	if err := module.collectData(context.Background()); err != nil {
+		t.Fatalf("collectData failed: %v", err)
+	}
+	if len(ch) != 3 {
+		t.Fatalf("Expected 3 metrics (connection status, 2 containers), got %d", len(ch))
+	}
+	<-ch
+
+	running := <-ch
+	if running.Name != "container" || running.Tags["device"] != "homeassistant" || running.Tags["image"] != "ghcr.io/home-assistant/home-assistant:stable" {
+		t.Errorf("Unexpected metric: %+v", running)
+	}
+	if running.Fields["memory_used"] != uint64(400000000) || running.Fields["net_in"] != uint64(1010) || running.Fields["net_out"] != uint64(2020) {
+		t.Errorf("Unexpected fields: %v", running.Fields)
+	}
+	if _, exists := running.Fields["cpu_usage"]; exists {
+		t.Error("Expected no CPU usage without a previous sample")
+	}
+	if uptime, ok := running.Fields["uptime"].(int64); !ok || uptime < 3599 {
+		t.Errorf("Expected an uptime of an hour, got %v", running.Fields["uptime"])
+	}
+
+	restarting := <-ch
+	if restarting.Fields["running"] != false || restarting.Fields["restart_count"] != int64(7) || restarting.Fields["status"] != "restarting" {
+		t.Errorf("Unexpected fields of restarting container: %v", restarting.Fields)
+	}
+
+	// The CPU usage is computed from the previous sample: 2s of 20s on 4 CPUs
+	if err := module.collectData(context.Background()); err != nil {
+		t.Fatalf("collectData failed: %v", err)
+	}
+	running = <-ch
+	if running.Fields["cpu_usage"] != 40.0 {
+		t.Errorf("Expected CPU usage 40, got %v", running.Fields["cpu_usage"])
+	}
+}
+
+func TestSelectContainers(t *testing.T) {
+	module, err := NewDockerModule(Config{Containers: []string{"influxdb", "missing"}})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	selected := module.selectContainers([]Container{
+		{ID: "abc", Names: []string{"/homeassistant"}},
+		{ID: "def", Names: []string{"/influxdb"}},
+	})
+	if len(selected) != 1 || selected[0].ID != "def" {
+		t.Errorf("Expected only influxdb, got %+v", selected)
+	}
+}
@@ -0,0 +1,366 @@
+// Package dwd provides a metric collection module for official weather warnings
+// published by the Deutscher Wetterdienst (DWD) as open data.
+// It polls the warning feed for the configured warn cells and emits the current
+// warning level per region as well as an event metric for each new warning.
+package dwd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/connection"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// Metric names
+	metricNameLevel = "weather_warning"
+	metricNameEvent = "weather_warning_event"
+
+	// jsonpPrefix is the JSONP callback wrapping the DWD warning feed
+	jsonpPrefix = "warnWetter.loadWarnings("
+)
+
+// Config represents the configuration for the DWD module
+type Config struct {
+	config.BaseConfig
+	WarnCellIDs []string        `json:"warn_cell_ids"`      // DWD warn cell IDs to monitor (e.g. "105315000")
+	URL         string          `json:"url,omitempty"`      // Warning feed URL
+	Interval    config.Duration `json:"interval,omitempty"` // Polling interval (defaults to 10m)
+	Timeout     config.Duration `json:"timeout,omitempty"`  // HTTP request timeout (defaults to 30s)
+}
+
+// WarningFeed represents the DWD warning feed
+type WarningFeed struct {
+	Time     int64                `json:"time"`
+	Warnings map[string][]Warning `json:"warnings"`
+}
+
+// Warning represents a single DWD weather warning
+type Warning struct {
+	State       string `json:"state"`
+	Type        int    `json:"type"`
+	Level       int    `json:"level"`
+	Start       int64  `json:"start"`
+	End         *int64 `json:"end"`
+	RegionName  string `json:"regionName"`
+	Event       string `json:"event"`
+	Headline    string `json:"headline"`
+	Description string `json:"description"`
+	Instruction string `json:"instruction"`
+}
+
+// key returns an identifier that is stable for the lifetime of a warning
+func (w Warning) key(cellID string) string {
+	return fmt.Sprintf("%s/%d/%s/%d", cellID, w.Type, w.Event, w.Start)
+}
+
+// DWDModule handles polling of the DWD warning feed
+type DWDModule struct {
+	config      Config
+	httpClient  *http.Client
+	metricsCh   chan<- metrics.Metric
+	clock       utils.Clock
+	collections *utils.CollectionLog // last successful collection, nil if not remembered
+	tracker     *connection.Tracker
+	seen        map[string]bool
+	regions     map[string]string // region name per warn cell, kept after its warnings end
+}
+
+// Run starts the DWD module and begins collecting metrics
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewDWDModule(config)
+	if err != nil {
+		return fmt.Errorf("failed to create DWD module: %w", err)
+	}
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+	module.collections = utils.OpenCollectionLog(config.InstanceName("dwd"), module.clock)
+
+	return module.run(ctx)
+}
+
+// Probe validates the DWD configuration and checks that the warning feed is reachable
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewDWDModule(cfg)
+	if err != nil {
+		return &config.ModuleError{Module: "dwd", Err: err}
+	}
+	return utils.ProbeURL(ctx, module.config.URL, module.config.Timeout.Duration())
+}
+
+// NewDWDModule creates a new DWD module instance
+func NewDWDModule(cfg Config) (*DWDModule, error) {
+	utils.Debugf("Creating new DWD module instance")
+
+	if len(cfg.WarnCellIDs) == 0 {
+		return nil, fmt.Errorf("warn_cell_ids is required but not configured")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(10 * time.Minute)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = config.Duration(30 * time.Second)
+	}
+
+	utils.Debugf("DWD module created successfully")
+	return &DWDModule{
+		clock:  utils.SystemClock,
+		config: cfg,
+		httpClient: &http.Client{
+			Timeout:   cfg.Timeout.Duration(),
+			Transport: utils.OutboundTransport(cfg.InstanceName("dwd"), nil),
+		},
+		seen:    make(map[string]bool),
+		regions: make(map[string]string),
+	}, nil
+}
+
+// DefaultConfig returns the default configuration of the DWD module.
+func DefaultConfig() Config {
+	return Config{
+		URL:      "https://www.dwd.de/DWD/warnungen/warnapp/json/warnings.json",
+		Interval: config.Duration(10 * time.Minute),
+		Timeout:  config.Duration(30 * time.Second),
+	}
+}
+
+// LoadConfig loads the DWD module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("dwd")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
+
+// run executes the main module loop
+func (dm *DWDModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("DWD module", "main", func() error {
+		interval := dm.config.Interval.Duration()
+		ticker := utils.NewScheduledTicker(ctx, interval)
+		defer ticker.Stop()
+
+		// Collect initial data unless outside the collection schedule or skipped,
+		// but not before an interval has passed since the last collection
+		initial := dm.collections.Initial(ctx, interval)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-initial:
+				if err := utils.CollectWithRetry(ctx, interval, dm.collectData); err != nil {
+					utils.Warnf("Failed to collect initial warnings: %v", err)
+				} else {
+					dm.collections.Record()
+				}
+			case <-ticker.C:
+				if err := utils.CollectWithRetry(ctx, interval, dm.collectData); err != nil {
+					utils.Warnf("Failed to collect warnings: %v", err)
+				} else {
+					dm.collections.Record()
+				}
+			}
+		}
+	})
+}
+
+// collectData fetches the warning feed and sends metrics for the configured warn cells
+func (dm *DWDModule) collectData(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("DWD data collection", "api", func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, dm.config.URL, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := dm.httpClient.Do(req)
+		dm.connection().SetPollResult(resp, err)
+		if err != nil {
+			return fmt.Errorf("API request failed: %w", err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read response body: %w", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		}
+
+		feed, err := ParseWarningFeed(body)
+		if err != nil {
+			return err
+		}
+
+		dm.processFeed(feed, dm.clock.Now())
+		return nil
+	})
+}
+
+// connection returns the tracker for the warning feed, creating it on first use
+func (dm *DWDModule) connection() *connection.Tracker {
+	if dm.tracker == nil {
+		dm.tracker = connection.NewTracker(dm.config.InstanceName("dwd"), dm.config.URL, dm.metricsCh)
+	}
+	return dm.tracker
+}
+
+// ParseWarningFeed parses the DWD warning feed, stripping the JSONP wrapper if present
+func ParseWarningFeed(data []byte) (*WarningFeed, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte(jsonpPrefix)) {
+		data = bytes.TrimPrefix(data, []byte(jsonpPrefix))
+		data = bytes.TrimSuffix(data, []byte(";"))
+		data = bytes.TrimSuffix(data, []byte(")"))
+	}
+
+	var feed WarningFeed
+	if err := json.Unmarshal(data, &feed); err != nil {
+		return nil, fmt.Errorf("failed to parse warning feed: %w", err)
+	}
+	return &feed, nil
+}
+
+// processFeed sends a level metric for each configured warn cell and an event metric for new warnings
+func (dm *DWDModule) processFeed(feed *WarningFeed, now time.Time) {
+	active := make(map[string]bool)
+
+	for _, cellID := range dm.config.WarnCellIDs {
+		dm.rememberRegion(cellID, feed.Warnings[cellID])
+		warnings := activeWarnings(feed.Warnings[cellID], now)
+		dm.sendLevelMetric(cellID, warnings, now)
+
+		for _, warning := range warnings {
+			key := warning.key(cellID)
+			active[key] = true
+			if !dm.seen[key] {
+				dm.sendEventMetric(cellID, warning)
+			}
+		}
+	}
+
+	// Forget warnings that are no longer active so the map does not grow unbounded
+	dm.seen = active
+}
+
+// rememberRegion caches the region name of a warn cell from its warnings, so the
+// friendly name of the cell stays the same while no warning is active
+func (dm *DWDModule) rememberRegion(cellID string, warnings []Warning) {
+	for _, warning := range warnings {
+		if warning.RegionName != "" {
+			dm.regions[cellID] = warning.RegionName
+			return
+		}
+	}
+}
+
+// activeWarnings returns the warnings that are in effect at the given time, highest level first
+func activeWarnings(warnings []Warning, now time.Time) []Warning {
+	nowMillis := now.UnixMilli()
+	result := make([]Warning, 0, len(warnings))
+	for _, warning := range warnings {
+		if warning.Start > nowMillis || (warning.End != nil && *warning.End < nowMillis) {
+			continue
+		}
+		result = append(result, warning)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Level > result[j].Level
+	})
+	return result
+}
+
+// sendLevelMetric sends the current warning level for a warn cell.
+// A level of 0 is reported when no warning is active so that dashboards show the all-clear.
+func (dm *DWDModule) sendLevelMetric(cellID string, warnings []Warning, timestamp time.Time) {
+	fields := map[string]interface{}{
+		"level": 0,
+		"count": len(warnings),
+	}
+
+	if len(warnings) > 0 {
+		highest := warnings[0]
+		fields["level"] = highest.Level
+		fields["type"] = highest.Type
+		fields["event"] = highest.Event
+		fields["headline"] = highest.Headline
+	}
+
+	dm.sendMetric(metricNameLevel, dm.createBaseTags(cellID), fields, timestamp)
+}
+
+// sendEventMetric sends a metric for a newly issued warning
+func (dm *DWDModule) sendEventMetric(cellID string, warning Warning) {
+	fields := map[string]interface{}{
+		"level":       warning.Level,
+		"type":        warning.Type,
+		"event":       warning.Event,
+		"headline":    warning.Headline,
+		"description": warning.Description,
+	}
+	if warning.End != nil {
+		fields["end"] = *warning.End / 1000
+	}
+
+	dm.sendMetric(metricNameEvent, dm.createBaseTags(cellID), fields, time.UnixMilli(warning.Start))
+}
+
+// createBaseTags creates base tags for a warn cell. The friendly name is taken from
+// the configured overrides or else from the cached region name of the cell.
+func (dm *DWDModule) createBaseTags(cellID string) map[string]string {
+	return map[string]string{
+		"vendor":   "dwd",
+		"device":   cellID,
+		"friendly": dm.config.GetFriendlyName(cellID, dm.regions[cellID], cellID),
+	}
+}
+
+// sendMetric validates and sends a metric to the metrics channel
+func (dm *DWDModule) sendMetric(name string, tags map[string]string, fields map[string]interface{}, timestamp time.Time) {
+	metric := metrics.Metric{
+		Name:      name,
+		Tags:      tags,
+		Fields:    fields,
+		Timestamp: timestamp,
+	}
+
+	if err := metric.Validate(); err != nil {
+		utils.Warnf("Invalid %s metric for warn cell %s: %v", name, tags["device"], err)
+		return
+	}
+
+	select {
+	case dm.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping %s metric for warn cell %s", name, tags["device"])
+	}
+}
@@ -0,0 +1,164 @@
+package dwd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const sampleFeed = `warnWetter.loadWarnings({"time":1700000000000,"warnings":{
+	"105315000":[
+		{"state":"Nordrhein-Westfalen","type":1,"level":2,"start":1700000000000,"end":4102444800000,"regionName":"Stadt Köln","event":"WINDBÖEN","headline":"Amtliche WARNUNG vor WINDBÖEN","description":"Es treten Windböen auf."},
+		{"state":"Nordrhein-Westfalen","type":0,"level":3,"start":1700000000000,"end":4102444800000,"regionName":"Stadt Köln","event":"STARKES GEWITTER","headline":"Amtliche WARNUNG vor STARKEM GEWITTER","description":"Es treten Gewitter auf."},
+		{"state":"Nordrhein-Westfalen","type":4,"level":1,"start":1600000000000,"end":1600003600000,"regionName":"Stadt Köln","event":"NEBEL","headline":"Amtliche WARNUNG vor NEBEL","description":"Abgelaufen."}
+	]
+},"vorabInformation":{},"copyright":"Copyright Deutscher Wetterdienst"});`
+
+func TestNewDWDModule(t *testing.T) {
+	tah := utils.NewTestAssertionHelper()
+
+	_, err := NewDWDModule(Config{})
+	tah.AssertError(t, err, "Expected error for missing warn_cell_ids")
+
+	module, err := NewDWDModule(Config{WarnCellIDs: []string{"105315000"}})
+	tah.AssertNoError(t, err, "Failed to create DWD module")
+	if module.config.Interval.Duration() != 10*time.Minute {
+		t.Errorf("Expected default interval 10m, got %v", module.config.Interval)
+	}
+}
+
+func TestParseWarningFeed(t *testing.T) {
+	feed, err := ParseWarningFeed([]byte(sampleFeed))
+	if err != nil {
+		t.Fatalf("Failed to parse feed: %v", err)
+	}
+	if len(feed.Warnings["105315000"]) != 3 {
+		t.Errorf("Expected 3 warnings, got %d", len(feed.Warnings["105315000"]))
+	}
+
+	// Plain JSON without JSONP wrapper is accepted as well
+	if _, err := ParseWarningFeed([]byte(`{"time":1,"warnings":{}}`)); err != nil {
+		t.Errorf("Failed to parse plain JSON feed: %v", err)
+	}
+
+	if _, err := ParseWarningFeed([]byte(`not json`)); err == nil {
+		t.Error("Expected error for invalid feed")
+	}
+}
+
+func TestCollectData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, sampleFeed)
+	}))
+	defer server.Close()
+
+	module, err := NewDWDModule(Config{WarnCellIDs: []string{"105315000", "999999999"}, URL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.metricsCh = ch
+
+	if err := module.collectData(context.Background()); err != nil {
+		t.Fatalf("collectData failed: %v", err)
+	}
+
+	// Expect: connection status, level metric for cell 1, two event metrics, level metric for cell 2
+	collected := drain(ch)
+	if len(collected) != 5 {
+		t.Fatalf("Expected 5 metrics, got %d", len(collected))
+	}
+	if collected[0].Name != "connection_status" || collected[0].Fields["status"] != 1 {
+		t.Errorf("Expected connection status metric first, got %+v", collected[0])
+	}
+	collected = collected[1:]
+
+	level := collected[0]
+	if level.Name != "weather_warning" || level.Tags["device"] != "105315000" {
+		t.Errorf("Unexpected level metric: %+v", level)
+	}
+	if level.Fields["level"] != 3 || level.Fields["count"] != 2 {
+		t.Errorf("Expected level 3 with 2 active warnings, got %v", level.Fields)
+	}
+	if level.Tags["friendly"] != "Stadt Köln" {
+		t.Errorf("Expected friendly 'Stadt Köln', got '%s'", level.Tags["friendly"])
+	}
+
+	for _, event := range collected[1:3] {
+		if event.Name != "weather_warning_event" {
+			t.Errorf("Expected event metric, got %s", event.Name)
+		}
+	}
+
+	clear := collected[3]
+	if clear.Tags["device"] != "999999999" || clear.Fields["level"] != 0 {
+		t.Errorf("Expected all-clear metric for second cell, got %+v", clear)
+	}
+
+	// A second poll must not repeat the event metrics
+	if err := module.collectData(context.Background()); err != nil {
+		t.Fatalf("collectData failed: %v", err)
+	}
+	if repeated := drain(ch); len(repeated) != 2 {
+		t.Errorf("Expected only 2 level metrics on second poll, got %d", len(repeated))
+	}
+}
+
+func TestProcessFeed(t *testing.T) {
+	module, err := NewDWDModule(Config{WarnCellIDs: []string{"105315000"}})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.metricsCh = ch
+
+	now := time.UnixMilli(1700000000000)
+	end := now.Add(2 * time.Hour).UnixMilli()
+	future := Warning{Type: 2, Level: 2, Start: now.Add(time.Hour).UnixMilli(), End: &end, RegionName: "Stadt Köln", Event: "FROST"}
+
+	// A warning that has not started yet is neither active nor reported as new
+	module.processFeed(&WarningFeed{Warnings: map[string][]Warning{"105315000": {future}}}, now)
+	collected := drain(ch)
+	if len(collected) != 1 {
+		t.Fatalf("Expected only the level metric for a future warning, got %d metrics", len(collected))
+	}
+	if collected[0].Fields["level"] != 0 || collected[0].Fields["count"] != 0 {
+		t.Errorf("Expected all-clear while the warning has not started, got %v", collected[0].Fields)
+	}
+	if collected[0].Tags["friendly"] != "Stadt Köln" {
+		t.Errorf("Expected friendly 'Stadt Köln', got '%s'", collected[0].Tags["friendly"])
+	}
+
+	// Once started the warning is active and reported
+	module.processFeed(&WarningFeed{Warnings: map[string][]Warning{"105315000": {future}}}, now.Add(90*time.Minute))
+	collected = drain(ch)
+	if len(collected) != 2 || collected[0].Fields["level"] != 2 || collected[1].Name != "weather_warning_event" {
+		t.Errorf("Expected level 2 and an event metric once the warning started, got %+v", collected)
+	}
+
+	// The friendly name stays the same after the warning has ended
+	module.processFeed(&WarningFeed{Warnings: map[string][]Warning{}}, now.Add(3*time.Hour))
+	collected = drain(ch)
+	if len(collected) != 1 || collected[0].Tags["friendly"] != "Stadt Köln" {
+		t.Errorf("Expected all-clear metric with friendly 'Stadt Köln', got %+v", collected)
+	}
+}
+
+// drain returns all metrics currently buffered in the channel
+func drain(ch chan metrics.Metric) []metrics.Metric {
+	var result []metrics.Metric
+	for {
+		select {
+		case m := <-ch:
+			result = append(result, m)
+		default:
+			return result
+		}
+	}
+}
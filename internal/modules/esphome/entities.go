@@ -0,0 +1,298 @@
+package esphome
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+)
+
+// Supported entity components
+const (
+	componentSensor       = "sensor"
+	componentBinarySensor = "binary_sensor"
+	componentSwitch       = "switch"
+)
+
+// MappingRule maps discovered entities to a measurement and field.
+// Empty match settings match all entities; the first matching rule is applied.
+type MappingRule struct {
+	Component   string `json:"component,omitempty"`    // Entity component, e.g. "sensor" or "binary_sensor"
+	DeviceClass string `json:"device_class,omitempty"` // Device class, e.g. "temperature"
+	Unit        string `json:"unit,omitempty"`         // Unit of measurement, e.g. "W"
+	Entity      string `json:"entity,omitempty"`       // Glob pattern on the object ID, e.g. "*_power"
+
+	Measurement string `json:"measurement"`     // Measurement of matching entities
+	Field       string `json:"field,omitempty"` // Field name (defaults to the device class or object ID)
+}
+
+// Validate checks that the rule has a measurement and a valid entity pattern.
+func (r MappingRule) Validate() error {
+	if r.Measurement == "" {
+		return fmt.Errorf("measurement is required")
+	}
+	if _, err := path.Match(r.Entity, ""); err != nil {
+		return fmt.Errorf("invalid entity pattern %q: %w", r.Entity, err)
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to an entity
+func (r MappingRule) matches(entity *Entity) bool {
+	if r.Component != "" && r.Component != entity.Component {
+		return false
+	}
+	if r.DeviceClass != "" && r.DeviceClass != entity.DeviceClass {
+		return false
+	}
+	if r.Unit != "" && r.Unit != entity.Unit {
+		return false
+	}
+	if r.Entity != "" {
+		if matched, _ := path.Match(r.Entity, entity.ObjectID); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// DefaultMappings returns the default mapping rules: climate and electricity
+// readings get the measurements used by the other modules, all other entities
+// are sent as "esphome" measurement.
+func DefaultMappings() []MappingRule {
+	var rules []MappingRule
+	for _, deviceClass := range []string{"temperature", "humidity", "pressure", "carbon_dioxide", "pm25", "pm10", "volatile_organic_compounds"} {
+		rules = append(rules, MappingRule{Component: componentSensor, DeviceClass: deviceClass, Measurement: "climate"})
+	}
+	for _, deviceClass := range []string{"power", "energy", "voltage", "current", "power_factor", "frequency"} {
+		rules = append(rules, MappingRule{Component: componentSensor, DeviceClass: deviceClass, Measurement: "electricity"})
+	}
+	return append(rules, MappingRule{Measurement: "esphome"})
+}
+
+// DiscoveryConfig represents a Home Assistant MQTT discovery config as published by ESPHome.
+// ESPHome uses the abbreviated keys, the long keys are accepted as well.
+type DiscoveryConfig struct {
+	BaseTopic   string          `json:"~"`
+	Name        string          `json:"name"`
+	StateTopic  string          `json:"stat_t"`
+	Unit        string          `json:"unit_of_meas"`
+	DeviceClass string          `json:"dev_cla"`
+	Device      DiscoveryDevice `json:"dev"`
+
+	LongStateTopic  string          `json:"state_topic"`
+	LongUnit        string          `json:"unit_of_measurement"`
+	LongDeviceClass string          `json:"device_class"`
+	LongDevice      DiscoveryDevice `json:"device"`
+}
+
+// DiscoveryDevice represents the device block of a discovery config
+type DiscoveryDevice struct {
+	Identifiers     json.RawMessage `json:"ids"`
+	Name            string          `json:"name"`
+	SWVersion       string          `json:"sw"`
+	Model           string          `json:"mdl"`
+	LongIdentifiers json.RawMessage `json:"identifiers"`
+	LongSWVersion   string          `json:"sw_version"`
+	LongModel       string          `json:"model"`
+}
+
+// identifier returns the first identifier of the device, which is a string or a list of strings
+func (d DiscoveryDevice) identifier() string {
+	raw := d.Identifiers
+	if len(raw) == 0 {
+		raw = d.LongIdentifiers
+	}
+	var id string
+	if err := json.Unmarshal(raw, &id); err == nil {
+		return id
+	}
+	var ids []string
+	if err := json.Unmarshal(raw, &ids); err == nil && len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+// Entity represents a discovered ESPHome entity
+type Entity struct {
+	Component   string
+	NodeID      string
+	ObjectID    string
+	Name        string
+	DeviceID    string
+	DeviceName  string
+	DeviceClass string
+	Unit        string
+	Model       string
+	Firmware    string
+	StateTopic  string
+	Measurement string
+	Field       string
+}
+
+// parseEntity parses the discovery config of an entity published on
+// <prefix>/<component>/<node_id>/<object_id>/config.
+// It returns nil without error for entities that are not from ESPHome or not supported.
+func parseEntity(topic string, payload []byte) (*Entity, error) {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 5 {
+		return nil, fmt.Errorf("unexpected discovery topic %s", topic)
+	}
+	component, nodeID, objectID := parts[len(parts)-4], parts[len(parts)-3], parts[len(parts)-2]
+	if component != componentSensor && component != componentBinarySensor && component != componentSwitch {
+		return nil, nil
+	}
+
+	var cfg DiscoveryConfig
+	if err := json.Unmarshal(payload, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse discovery config: %w", err)
+	}
+	device := cfg.Device
+	if len(device.Identifiers) == 0 && device.Name == "" {
+		device = cfg.LongDevice
+	}
+	swVersion := firstNonEmpty(device.SWVersion, device.LongSWVersion)
+	if !strings.Contains(strings.ToLower(swVersion), "esphome") {
+		return nil, nil
+	}
+
+	stateTopic := firstNonEmpty(cfg.StateTopic, cfg.LongStateTopic)
+	if stateTopic == "" {
+		return nil, fmt.Errorf("discovery config on %s has no state topic", topic)
+	}
+	// "~" abbreviates the base topic at the start or end of topics
+	if cfg.BaseTopic != "" {
+		if strings.HasPrefix(stateTopic, "~") {
+			stateTopic = cfg.BaseTopic + stateTopic[1:]
+		} else if strings.HasSuffix(stateTopic, "~") {
+			stateTopic = stateTopic[:len(stateTopic)-1] + cfg.BaseTopic
+		}
+	}
+
+	deviceID := device.identifier()
+	if deviceID == "" {
+		deviceID = nodeID
+	}
+	return &Entity{
+		Component:   component,
+		NodeID:      nodeID,
+		ObjectID:    objectID,
+		Name:        cfg.Name,
+		DeviceID:    deviceID,
+		DeviceName:  firstNonEmpty(device.Name, nodeID),
+		DeviceClass: firstNonEmpty(cfg.DeviceClass, cfg.LongDeviceClass),
+		Unit:        firstNonEmpty(cfg.Unit, cfg.LongUnit),
+		Model:       firstNonEmpty(device.Model, device.LongModel),
+		Firmware:    swVersion,
+		StateTopic:  stateTopic,
+	}, nil
+}
+
+// applyMapping sets the measurement and field of an entity from the first matching rule.
+// It returns false if no rule matches.
+func (e *Entity) applyMapping(rules []MappingRule) bool {
+	for _, rule := range rules {
+		if !rule.matches(e) {
+			continue
+		}
+		e.Measurement = rule.Measurement
+		e.Field = firstNonEmpty(rule.Field, e.DeviceClass, e.ObjectID)
+		return true
+	}
+	return false
+}
+
+// parseState converts the state payload of an entity to a field value.
+// Sensors report numbers, binary sensors and switches "ON" and "OFF".
+func (e *Entity) parseState(payload []byte) (interface{}, error) {
+	state := strings.TrimSpace(string(payload))
+	if e.Component == componentSensor {
+		value, err := strconv.ParseFloat(state, 64)
+		if err != nil || math.IsNaN(value) {
+			return nil, fmt.Errorf("invalid sensor state %q", state)
+		}
+		return value, nil
+	}
+
+	if value, ok := config.BinaryStates.Lookup(state); ok {
+		return value, nil
+	}
+	return nil, fmt.Errorf("invalid state %q", state)
+}
+
+// EntityRegistry holds the discovered entities by discovery topic and state topic.
+type EntityRegistry struct {
+	mu          sync.RWMutex
+	byDiscovery map[string]*Entity
+	byState     map[string]*Entity
+}
+
+// NewEntityRegistry creates an empty entity registry.
+func NewEntityRegistry() *EntityRegistry {
+	return &EntityRegistry{
+		byDiscovery: make(map[string]*Entity),
+		byState:     make(map[string]*Entity),
+	}
+}
+
+// Store adds or replaces the entity of a discovery topic. It returns the state topic of
+// the replaced entity if it differs from the new one, so it can be unsubscribed.
+func (r *EntityRegistry) Store(discoveryTopic string, entity *Entity) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previousTopic := ""
+	if previous, ok := r.byDiscovery[discoveryTopic]; ok && previous.StateTopic != entity.StateTopic {
+		previousTopic = previous.StateTopic
+		delete(r.byState, previous.StateTopic)
+	}
+	r.byDiscovery[discoveryTopic] = entity
+	r.byState[entity.StateTopic] = entity
+	return previousTopic
+}
+
+// Remove removes the entity of a discovery topic and returns its state topic,
+// or "" if the topic had no entity.
+func (r *EntityRegistry) Remove(discoveryTopic string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entity, ok := r.byDiscovery[discoveryTopic]
+	if !ok {
+		return ""
+	}
+	delete(r.byDiscovery, discoveryTopic)
+	delete(r.byState, entity.StateTopic)
+	return entity.StateTopic
+}
+
+// ByStateTopic returns the entity publishing on a state topic.
+func (r *EntityRegistry) ByStateTopic(stateTopic string) (*Entity, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entity, ok := r.byState[stateTopic]
+	return entity, ok
+}
+
+// Count returns the number of discovered entities.
+func (r *EntityRegistry) Count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.byDiscovery)
+}
+
+// firstNonEmpty returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
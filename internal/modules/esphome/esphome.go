@@ -0,0 +1,380 @@
+// Package esphome provides a metric collection module for ESPHome devices.
+// It connects to the MQTT broker the devices publish to, discovers their sensors,
+// binary sensors and switches from the Home Assistant MQTT discovery configs
+// and emits their states mapped to measurements and fields by configurable rules.
+package esphome
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/connection"
+	mqttselftest "github.com/janhuddel/metrics-agent/internal/selftest/mqtt"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// Config holds the configuration for the ESPHome module.
+type Config struct {
+	config.BaseConfig
+
+	// MQTT subscription and session options (qos, clean_session, max_in_flight)
+	config.MQTTOptions
+
+	Broker          string          `json:"broker"`           // MQTT broker address (e.g., "tcp://localhost:1883")
+	Username        string          `json:"username"`         // MQTT username (optional)
+	Password        string          `json:"password"`         // MQTT password (optional)
+	ClientID        string          `json:"client_id"`        // MQTT client ID (optional, defaults to hostname)
+	Timeout         config.Duration `json:"timeout"`          // Connection timeout (defaults to 30s)
+	KeepAlive       config.Duration `json:"keep_alive"`       // Keep-alive interval (defaults to 60s)
+	DiscoveryPrefix string          `json:"discovery_prefix"` // Discovery prefix of the devices (defaults to "homeassistant")
+
+	// Mappings map entities to measurements and fields; the first matching rule is applied
+	// and entities without matching rule are ignored (defaults to DefaultMappings)
+	Mappings []MappingRule `json:"mappings,omitempty"`
+}
+
+// ESPHomeModule handles the MQTT connection and entity discovery.
+type ESPHomeModule struct {
+	config        Config
+	client        mqtt.Client
+	entities      *EntityRegistry
+	metricsCh     chan<- metrics.Metric
+	inFlight      utils.Semaphore // Limits concurrently processed messages
+	clock         utils.Clock
+	inventory     *utils.ModuleInventory // Receives the metadata of discovered devices
+	subscribed    map[string]bool
+	subscribedMux sync.Mutex
+}
+
+// Run starts the ESPHome module and begins collecting metrics.
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module := NewESPHomeModule(config)
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+	module.inventory = utils.InventoryFromContext(ctx)
+
+	return module.run(ctx)
+}
+
+// Probe checks that the MQTT broker is reachable.
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	return utils.ProbeURL(ctx, cfg.Broker, cfg.Timeout.Duration())
+}
+
+// SelfTest checks that the MQTT broker accepts the configured credentials
+func SelfTest(ctx context.Context) []utils.Check {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return []utils.Check{{Name: "configuration", Err: err}}
+	}
+	return []utils.Check{mqttselftest.CheckLogin(ctx, cfg.Broker, cfg.Username, cfg.Password, cfg.Timeout.Duration())}
+}
+
+// NewESPHomeModule creates a new ESPHome module instance.
+func NewESPHomeModule(cfg Config) *ESPHomeModule {
+	utils.Debugf("Creating new ESPHome module instance")
+
+	if cfg.DiscoveryPrefix == "" {
+		cfg.DiscoveryPrefix = "homeassistant"
+	}
+	if len(cfg.Mappings) == 0 {
+		cfg.Mappings = DefaultMappings()
+	}
+
+	utils.Debugf("ESPHome module created successfully")
+	return &ESPHomeModule{
+		config:     cfg,
+		entities:   NewEntityRegistry(),
+		inFlight:   utils.NewSemaphore(cfg.MaxInFlight),
+		clock:      utils.SystemClock,
+		subscribed: make(map[string]bool),
+	}
+}
+
+// DefaultConfig returns the default configuration of the ESPHome module.
+func DefaultConfig() Config {
+	return Config{
+		MQTTOptions: config.MQTTOptions{
+			QoS: 1,
+		},
+		Broker:          "tcp://localhost:1883",
+		Timeout:         config.Duration(30 * time.Second),
+		KeepAlive:       config.Duration(60 * time.Second),
+		DiscoveryPrefix: "homeassistant",
+		Mappings:        DefaultMappings(),
+	}
+}
+
+// LoadConfig loads the ESPHome module configuration, scoped to the given instance if set.
+// An invalid configuration is returned as a *config.ModuleError.
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("esphome")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	cfg := *loadedConfig.(*Config)
+	if err := cfg.MQTTOptions.Validate(); err != nil {
+		return cfg, &config.ModuleError{Module: "esphome", Err: err}
+	}
+	for i, rule := range cfg.Mappings {
+		if err := rule.Validate(); err != nil {
+			return cfg, &config.ModuleError{Module: "esphome", Err: fmt.Errorf("mappings[%d]: %w", i, err)}
+		}
+	}
+	return cfg, nil
+}
+
+// run executes the main module loop.
+func (em *ESPHomeModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("ESPHome module", "main", func() error {
+		if err := em.connect(ctx); err != nil {
+			return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+		}
+		defer em.disconnect()
+
+		discoveryTopic := em.config.DiscoveryPrefix + "/+/+/+/config"
+		if err := em.subscribe(ctx, discoveryTopic, em.handleDiscoveryMessage); err != nil {
+			return fmt.Errorf("failed to subscribe to discovery topic: %w", err)
+		}
+		utils.Debugf("Subscribed to discovery topic: %s", discoveryTopic)
+
+		<-ctx.Done()
+		return nil
+	})
+}
+
+// connect establishes the connection to the MQTT broker with context cancellation support.
+func (em *ESPHomeModule) connect(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("MQTT connect", "broker", func() error {
+		clientID := em.config.ClientID
+		if clientID == "" {
+			hostname, _ := os.Hostname()
+			clientID = hostname + "-" + em.config.InstanceName("esphome")
+		}
+
+		tracker := connection.NewTracker(em.config.InstanceName("esphome"), em.config.Broker, em.metricsCh)
+		if err := utils.CheckDestination(ctx, em.config.Broker); err != nil {
+			utils.AuditConnect(em.config.InstanceName("esphome"), "mqtt", em.config.Broker, 0, err)
+			tracker.SetConnected(false)
+			return err
+		}
+
+		opts := mqtt.NewClientOptions()
+		opts.AddBroker(em.config.Broker)
+		opts.SetClientID(clientID)
+		opts.SetUsername(em.config.Username)
+		opts.SetPassword(em.config.Password)
+		opts.SetConnectTimeout(em.config.Timeout.Duration())
+		opts.SetAutoReconnect(true)
+		opts.SetResumeSubs(true)
+		opts.SetCleanSession(em.config.CleanSession)
+		opts.SetKeepAlive(em.config.KeepAlive.Duration())
+		opts.SetMaxReconnectInterval(5 * time.Minute)
+		opts.SetConnectRetryInterval(10 * time.Second)
+		opts.SetOrderMatters(false)
+		opts.SetProtocolVersion(4)
+
+		opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+			utils.WithPanicRecoveryAndContinue("MQTT connection lost handler", "broker", func() {
+				utils.Errorf("MQTT connection lost: %v", err)
+				tracker.SetConnected(false)
+			})
+		})
+
+		opts.SetOnConnectHandler(func(client mqtt.Client) {
+			utils.WithPanicRecoveryAndContinue("MQTT reconnect handler", "broker", func() {
+				utils.Infof("Connected to MQTT broker: %s", em.config.Broker)
+				utils.AuditConnect(em.config.InstanceName("esphome"), "mqtt", em.config.Broker, 0, nil)
+				tracker.SetConnected(true)
+			})
+		})
+
+		em.client = mqtt.NewClient(opts)
+
+		connChan := make(chan error, 1)
+		go func() {
+			token := em.client.Connect()
+			connChan <- token.Error()
+		}()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-connChan:
+			if err != nil {
+				utils.AuditConnect(em.config.InstanceName("esphome"), "mqtt", em.config.Broker, 0, err)
+				tracker.SetConnected(false)
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// subscribe subscribes to an MQTT topic with context cancellation support.
+func (em *ESPHomeModule) subscribe(ctx context.Context, topic string, handler func(topic string, payload []byte)) error {
+	return utils.WithPanicRecoveryAndReturnError("MQTT subscribe", "broker", func() error {
+		subChan := make(chan error, 1)
+		go func() {
+			token := em.client.Subscribe(topic, byte(em.config.QoS), em.messageHandler(handler))
+			subChan <- token.Error()
+		}()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-subChan:
+			return err
+		}
+	})
+}
+
+// messageHandler adapts a handler to the MQTT client, processing at most max_in_flight messages concurrently.
+func (em *ESPHomeModule) messageHandler(handler func(topic string, payload []byte)) mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
+		em.inFlight.Do(func() {
+			handler(msg.Topic(), msg.Payload())
+		})
+	}
+}
+
+// disconnect closes the MQTT connection.
+func (em *ESPHomeModule) disconnect() {
+	utils.WithPanicRecoveryAndContinue("MQTT disconnect", "broker", func() {
+		if em.client != nil && em.client.IsConnected() {
+			em.client.Disconnect(250)
+		}
+	})
+}
+
+// handleDiscoveryMessage registers or removes the entity of a discovery config.
+// An empty payload removes the entity.
+func (em *ESPHomeModule) handleDiscoveryMessage(topic string, payload []byte) {
+	utils.WithPanicRecoveryAndContinue("Discovery message handler", topic, func() {
+		if len(payload) == 0 {
+			if stateTopic := em.entities.Remove(topic); stateTopic != "" {
+				utils.Infof("ESPHome entity removed: %s", topic)
+				em.unsubscribeState(stateTopic)
+			}
+			return
+		}
+
+		entity, err := parseEntity(topic, payload)
+		if err != nil {
+			utils.Warnf("Failed to parse ESPHome discovery config: %v", err)
+			return
+		}
+		if entity == nil {
+			return
+		}
+		if !entity.applyMapping(em.config.Mappings) {
+			utils.Debugf("No mapping for ESPHome entity %s/%s, ignoring it", entity.NodeID, entity.ObjectID)
+			return
+		}
+
+		if previousTopic := em.entities.Store(topic, entity); previousTopic != "" {
+			em.unsubscribeState(previousTopic)
+		}
+		em.inventory.Report(entity.DeviceID, utils.DeviceMetadata{Model: entity.Model, Firmware: entity.Firmware})
+		utils.Debugf("Discovered ESPHome entity %s/%s as %s.%s", entity.NodeID, entity.ObjectID, entity.Measurement, entity.Field)
+		em.subscribeState(entity.StateTopic)
+	})
+}
+
+// subscribeState subscribes to the state topic of an entity unless already subscribed.
+func (em *ESPHomeModule) subscribeState(stateTopic string) {
+	em.subscribedMux.Lock()
+	if em.subscribed[stateTopic] {
+		em.subscribedMux.Unlock()
+		return
+	}
+	em.subscribed[stateTopic] = true
+	em.subscribedMux.Unlock()
+
+	if em.client == nil {
+		return
+	}
+	token := em.client.Subscribe(stateTopic, byte(em.config.QoS), em.messageHandler(em.handleStateMessage))
+
+	// Handle the subscription result asynchronously to avoid blocking the message handler
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			em.subscribedMux.Lock()
+			delete(em.subscribed, stateTopic)
+			em.subscribedMux.Unlock()
+			utils.Errorf("Failed to subscribe to state topic %s: %v", stateTopic, token.Error())
+		}
+	}()
+}
+
+// unsubscribeState unsubscribes from the state topic of a removed entity.
+func (em *ESPHomeModule) unsubscribeState(stateTopic string) {
+	em.subscribedMux.Lock()
+	delete(em.subscribed, stateTopic)
+	em.subscribedMux.Unlock()
+
+	if em.client != nil {
+		em.client.Unsubscribe(stateTopic)
+	}
+}
+
+// handleStateMessage sends the state of an entity as metric.
+func (em *ESPHomeModule) handleStateMessage(topic string, payload []byte) {
+	utils.WithPanicRecoveryAndContinue("State message handler", topic, func() {
+		entity, ok := em.entities.ByStateTopic(topic)
+		if !ok {
+			return
+		}
+
+		value, err := entity.parseState(payload)
+		if err != nil {
+			utils.Debugf("Skipping state of ESPHome entity %s/%s: %v", entity.NodeID, entity.ObjectID, err)
+			return
+		}
+
+		metric := metrics.Metric{
+			Name: entity.Measurement,
+			Tags: map[string]string{
+				"vendor":   "esphome",
+				"device":   entity.DeviceID,
+				"friendly": em.config.GetFriendlyName(entity.DeviceID, entity.DeviceName, entity.NodeID),
+				"entity":   entity.ObjectID,
+			},
+			Fields:    map[string]interface{}{entity.Field: value},
+			Timestamp: em.clock.Now(),
+		}
+		if err := metric.Validate(); err != nil {
+			utils.Warnf("Invalid %s metric for ESPHome entity %s/%s: %v", metric.Name, entity.NodeID, entity.ObjectID, err)
+			return
+		}
+
+		select {
+		case em.metricsCh <- metric:
+		default:
+			utils.Warnf("Metrics channel is full, dropping metric for ESPHome entity %s/%s", entity.NodeID, entity.ObjectID)
+		}
+	})
+}
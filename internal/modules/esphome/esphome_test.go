@@ -0,0 +1,140 @@
+package esphome
+
+import (
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/testutil"
+)
+
+const temperatureConfig = `{"dev_cla":"temperature","unit_of_meas":"°C","stat_cla":"measurement","name":"Temperature",
+	"stat_t":"livingroom/sensor/temperature/state","avty_t":"livingroom/status","uniq_id":"ESPsensortemperature",
+	"dev":{"ids":"a0b1c2d3e4f5","name":"Living Room","sw":"2024.6.1 (ESPHome)","mdl":"esp32dev","mf":"espressif"}}`
+
+const powerConfig = `{"device_class":"power","unit_of_measurement":"W","name":"Power",
+	"state_topic":"~/sensor/power/state","~":"plug",
+	"device":{"identifiers":["f5e4d3c2b1a0"],"name":"Plug","sw_version":"esphome v2023.12.0"}}`
+
+const motionConfig = `{"dev_cla":"motion","name":"Motion","stat_t":"livingroom/binary_sensor/motion/state",
+	"dev":{"ids":"a0b1c2d3e4f5","name":"Living Room","sw":"2024.6.1 (ESPHome)"}}`
+
+const zigbeeConfig = `{"dev_cla":"temperature","stat_t":"zigbee2mqtt/sensor","dev":{"ids":["0x00158d"],"name":"Sensor","sw":"zigbee2mqtt 1.35"}}`
+
+func TestParseEntity(t *testing.T) {
+	entity, err := parseEntity("homeassistant/sensor/livingroom/temperature/config", []byte(temperatureConfig))
+	if err != nil || entity == nil {
+		t.Fatalf("Failed to parse entity: %v", err)
+	}
+	if entity.DeviceID != "a0b1c2d3e4f5" || entity.DeviceName != "Living Room" || entity.DeviceClass != "temperature" ||
+		entity.Unit != "°C" || entity.StateTopic != "livingroom/sensor/temperature/state" || entity.ObjectID != "temperature" {
+		t.Errorf("Unexpected entity %+v", entity)
+	}
+	if entity.Model != "esp32dev" || entity.Firmware != "2024.6.1 (ESPHome)" {
+		t.Errorf("Expected device metadata, got model %q and firmware %q", entity.Model, entity.Firmware)
+	}
+
+	// Long keys, identifier lists and the base topic abbreviation are supported
+	entity, err = parseEntity("homeassistant/sensor/plug/power/config", []byte(powerConfig))
+	if err != nil || entity == nil {
+		t.Fatalf("Failed to parse entity: %v", err)
+	}
+	if entity.DeviceID != "f5e4d3c2b1a0" || entity.DeviceClass != "power" || entity.StateTopic != "plug/sensor/power/state" {
+		t.Errorf("Unexpected entity %+v", entity)
+	}
+
+	// Entities of other integrations and unsupported components are ignored
+	if entity, err := parseEntity("homeassistant/sensor/0x00158d/temperature/config", []byte(zigbeeConfig)); err != nil || entity != nil {
+		t.Errorf("Expected non-ESPHome entity to be ignored, got %+v, %v", entity, err)
+	}
+	if entity, err := parseEntity("homeassistant/light/livingroom/led/config", []byte(temperatureConfig)); err != nil || entity != nil {
+		t.Errorf("Expected light to be ignored, got %+v, %v", entity, err)
+	}
+
+	if _, err := parseEntity("homeassistant/sensor/livingroom/temperature/config", []byte("{")); err == nil {
+		t.Error("Expected error for invalid config")
+	}
+}
+
+func TestApplyMapping(t *testing.T) {
+	tests := []struct {
+		entity      Entity
+		rules       []MappingRule
+		measurement string
+		field       string
+	}{
+		{Entity{Component: "sensor", DeviceClass: "temperature", ObjectID: "temp"}, DefaultMappings(), "climate", "temperature"},
+		{Entity{Component: "sensor", DeviceClass: "power", ObjectID: "power"}, DefaultMappings(), "electricity", "power"},
+		{Entity{Component: "binary_sensor", DeviceClass: "motion", ObjectID: "motion"}, DefaultMappings(), "esphome", "motion"},
+		{Entity{Component: "sensor", ObjectID: "wifi_signal"}, DefaultMappings(), "esphome", "wifi_signal"},
+		{
+			Entity{Component: "sensor", Unit: "dBm", ObjectID: "wifi_signal"},
+			[]MappingRule{{Unit: "dBm", Measurement: "wifi", Field: "rssi"}},
+			"wifi", "rssi",
+		},
+		{
+			Entity{Component: "sensor", ObjectID: "solar_power"},
+			[]MappingRule{{Entity: "*_power", Measurement: "pv"}},
+			"pv", "solar_power",
+		},
+	}
+
+	for _, tt := range tests {
+		entity := tt.entity
+		if !entity.applyMapping(tt.rules) {
+			t.Errorf("Expected a rule to match %+v", tt.entity)
+			continue
+		}
+		if entity.Measurement != tt.measurement || entity.Field != tt.field {
+			t.Errorf("Expected %s.%s for %+v, got %s.%s", tt.measurement, tt.field, tt.entity, entity.Measurement, entity.Field)
+		}
+	}
+
+	entity := Entity{Component: "sensor", ObjectID: "uptime"}
+	if entity.applyMapping([]MappingRule{{Entity: "temp*", Measurement: "climate"}}) {
+		t.Error("Expected no rule to match")
+	}
+}
+
+func TestMappingRuleValidate(t *testing.T) {
+	if err := (MappingRule{Measurement: "climate", Entity: "temp_*"}).Validate(); err != nil {
+		t.Errorf("Expected valid rule, got %v", err)
+	}
+	if err := (MappingRule{}).Validate(); err == nil {
+		t.Error("Expected error for missing measurement")
+	}
+	if err := (MappingRule{Measurement: "climate", Entity: "["}).Validate(); err == nil {
+		t.Error("Expected error for invalid pattern")
+	}
+}
+
+func TestHandleMessages(t *testing.T) {
+	sink := testutil.NewCollectingSink(t)
+	module := NewESPHomeModule(Config{BaseConfig: config.BaseConfig{FriendlyNameOverrides: map[string]string{"a0b1c2d3e4f5": "Wohnzimmer"}}})
+	module.metricsCh = sink.Chan()
+	module.clock = testutil.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	module.handleDiscoveryMessage("homeassistant/sensor/livingroom/temperature/config", []byte(temperatureConfig))
+	module.handleDiscoveryMessage("homeassistant/binary_sensor/livingroom/motion/config", []byte(motionConfig))
+	if module.entities.Count() != 2 {
+		t.Fatalf("Expected 2 entities, got %d", module.entities.Count())
+	}
+
+	module.handleStateMessage("livingroom/sensor/temperature/state", []byte("21.5"))
+	module.handleStateMessage("livingroom/sensor/temperature/state", []byte("nan"))
+	module.handleStateMessage("livingroom/binary_sensor/motion/state", []byte("ON"))
+	module.handleStateMessage("unknown/sensor/x/state", []byte("1"))
+
+	testutil.AssertLines(t, sink.WaitFor(t, 2),
+		`climate,device=a0b1c2d3e4f5,entity=temperature,friendly=Wohnzimmer,vendor=esphome temperature=21.500000`,
+		`esphome,device=a0b1c2d3e4f5,entity=motion,friendly=Wohnzimmer,vendor=esphome motion=1i`,
+	)
+
+	// An empty discovery config removes the entity
+	module.handleDiscoveryMessage("homeassistant/sensor/livingroom/temperature/config", nil)
+	module.handleStateMessage("livingroom/sensor/temperature/state", []byte("22"))
+	if module.entities.Count() != 1 {
+		t.Errorf("Expected 1 entity after removal, got %d", module.entities.Count())
+	}
+	sink.ExpectCount(t, 2, 0)
+}
@@ -2,25 +2,23 @@
 // It allows dynamic registration and execution of different metric collection
 // modules through a unified interface.
 //
-// This file handles the initialization and registration of all available modules.
+// This file holds the global registry. Each module registers itself from its own
+// register_<module>.go file, which is guarded by a build tag named after the module.
+// Without module tags all modules are compiled in; building with e.g.
+// -tags "tasmota opendtu" compiles in only the tagged modules and their dependencies.
 package modules
 
-import (
-	"github.com/janhuddel/metrics-agent/internal/modules/demo"
-	"github.com/janhuddel/metrics-agent/internal/modules/netatmo"
-	"github.com/janhuddel/metrics-agent/internal/modules/opendtu"
-	"github.com/janhuddel/metrics-agent/internal/modules/tasmota"
-)
+import "fmt"
 
 // Global is the global registry instance used throughout the application.
 // It contains all registered metric collection modules.
 var Global = NewRegistry()
 
-func init() {
-	// Register all available modules
-	// Note: The demo module is enabled for testing signal handling
-	Global.Register("demo", demo.Run)
-	Global.Register("tasmota", tasmota.Run)
-	Global.Register("netatmo", netatmo.Run)
-	Global.Register("opendtu", opendtu.Run)
+// must panics on an error registering a module. Registrations run in init
+// functions, where a name registered twice is a programming error that must
+// stop the agent instead of silently replacing a module.
+func must(err error) {
+	if err != nil {
+		panic(fmt.Sprintf("module registration failed: %v", err))
+	}
 }
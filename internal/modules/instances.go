@@ -0,0 +1,124 @@
+// Package modules provides a registry system for metric collection modules.
+//
+// This file handles running multiple named instances of a module, e.g. one
+// netatmo instance per household, each scoped by its own tags.
+package modules
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// RunInstances runs all configured instances of a module concurrently.
+// Each instance receives its name through the context (see config.InstanceFromContext)
+// and its tags are added to every metric it produces. When the first instance stops,
+// the remaining instances are cancelled and its error is returned.
+func (r *Registry) RunInstances(ctx context.Context, name string, instances map[string]config.InstanceConfig, ch chan<- metrics.Metric) error {
+	fn, err := r.Get(name)
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		return fmt.Errorf("no instances configured for module: %s", name)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errCh := make(chan error, len(instances))
+	var wg sync.WaitGroup
+	for instance, instanceConfig := range instances {
+		wg.Add(1)
+		go func(instance string, instanceConfig config.InstanceConfig) {
+			defer wg.Done()
+			errCh <- runInstance(ctx, fn, name, instance, instanceConfig.Tags, ch)
+		}(instance, instanceConfig)
+	}
+
+	// The first instance to stop ends the module run
+	err = <-errCh
+	cancel()
+	wg.Wait()
+	return err
+}
+
+// RunInstance runs a single named instance of a module.
+// The instance name is passed through the context (see config.InstanceFromContext)
+// and the given tags are added to every metric the instance produces.
+// Running each instance separately lets the caller supervise and restart
+// instances independently of each other.
+func (r *Registry) RunInstance(ctx context.Context, name, instance string, tags map[string]string, ch chan<- metrics.Metric) error {
+	fn, err := r.Get(name)
+	if err != nil {
+		return err
+	}
+	if instance == "" {
+		return fmt.Errorf("no instance name given for module: %s", name)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // stops the metric forwarder
+	return runInstance(ctx, fn, name, instance, tags, ch)
+}
+
+// runInstance runs a single module instance with its metrics scoped by the instance tags.
+func runInstance(ctx context.Context, fn ModuleFunc, name, instance string, tags map[string]string, ch chan<- metrics.Metric) error {
+	instanceName := config.InstanceName(name, instance)
+	utils.Infof("[%s] starting instance", instanceName)
+
+	// The instance channel is not closed, as modules may still send from
+	// background goroutines after returning. Forwarding stops with the context.
+	// It has the buffer size of ch, so the buffer configured for the module applies.
+	instanceCh := make(chan metrics.Metric, cap(ch))
+	go forwardMetrics(ctx, instanceCh, ch, tags)
+
+	err := utils.WithPanicRecoveryAndReturnError("Module execution", instanceName, func() error {
+		return fn(utils.WithModule(config.WithInstance(ctx, instance), instanceName), instanceCh)
+	})
+	if err != nil {
+		return fmt.Errorf("instance %s: %w", instanceName, err)
+	}
+	utils.Infof("[%s] instance stopped", instanceName)
+	return nil
+}
+
+// forwardMetrics forwards metrics from in to out, adding the given tags to each metric.
+func forwardMetrics(ctx context.Context, in <-chan metrics.Metric, out chan<- metrics.Metric, tags map[string]string) {
+	utils.WithPanicRecoveryAndContinue("Instance metric forwarder", "modules", func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case metric := <-in:
+				select {
+				case out <- scopeMetric(metric, tags):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	})
+}
+
+// scopeMetric returns a copy of the metric with the instance tags added.
+// Instance tags take precedence over tags set by the module.
+func scopeMetric(metric metrics.Metric, tags map[string]string) metrics.Metric {
+	if len(tags) == 0 {
+		return metric
+	}
+
+	scoped := make(map[string]string, len(metric.Tags)+len(tags))
+	for key, value := range metric.Tags {
+		scoped[key] = value
+	}
+	for key, value := range tags {
+		scoped[key] = value
+	}
+	metric.Tags = scoped
+	return metric
+}
@@ -0,0 +1,136 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestRunInstances(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("test", func(ctx context.Context, ch chan<- metrics.Metric) error {
+		ch <- metrics.Metric{
+			Name:   "test",
+			Tags:   map[string]string{"device": config.InstanceFromContext(ctx), "home": "module"},
+			Fields: map[string]interface{}{"value": 1},
+		}
+		<-ctx.Done()
+		return nil
+	})
+
+	ch := make(chan metrics.Metric, 10)
+	instances := map[string]config.InstanceConfig{
+		"haus1": {Tags: map[string]string{"home": "haus1"}},
+		"haus2": {Tags: map[string]string{"home": "haus2"}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- registry.RunInstances(ctx, "test", instances, ch) }()
+
+	received := make(map[string]string)
+	for len(received) < len(instances) {
+		select {
+		case m := <-ch:
+			received[m.Tags["device"]] = m.Tags["home"]
+		case <-time.After(time.Second):
+			t.Fatalf("Expected metrics from all instances, got %v", received)
+		}
+	}
+	for device, home := range received {
+		if expected := instances[device].Tags["home"]; home != expected {
+			t.Errorf("Expected instance %s to be tagged home=%s, got %s", device, expected, home)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Expected no error after cancellation, got %v", err)
+	}
+}
+
+func TestRunInstancesFailure(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("test", func(ctx context.Context, ch chan<- metrics.Metric) error {
+		if config.InstanceFromContext(ctx) == "failing" {
+			return errors.New("boom")
+		}
+		<-ctx.Done()
+		return nil
+	})
+
+	instances := map[string]config.InstanceConfig{"haus1": {}, "failing": {}}
+	err := registry.RunInstances(context.Background(), "test", instances, make(chan metrics.Metric, 1))
+	if err == nil || err.Error() != "instance test.failing: boom" {
+		t.Errorf("Expected error of failing instance, got %v", err)
+	}
+}
+
+func TestRunInstancesErrors(t *testing.T) {
+	registry := NewRegistry()
+	ch := make(chan metrics.Metric, 1)
+
+	if err := registry.RunInstances(context.Background(), "unknown", map[string]config.InstanceConfig{"a": {}}, ch); err == nil {
+		t.Error("Expected error for unknown module")
+	}
+
+	registry.Register("test", func(ctx context.Context, ch chan<- metrics.Metric) error { return nil })
+	if err := registry.RunInstances(context.Background(), "test", nil, ch); err == nil {
+		t.Error("Expected error for missing instances")
+	}
+}
+
+func TestRunInstance(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("test", func(ctx context.Context, ch chan<- metrics.Metric) error {
+		ch <- metrics.Metric{
+			Name:   "test",
+			Tags:   map[string]string{"device": config.InstanceFromContext(ctx)},
+			Fields: map[string]interface{}{"value": 1},
+		}
+		<-ctx.Done()
+		return nil
+	})
+
+	ch := make(chan metrics.Metric, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- registry.RunInstance(ctx, "test", "haus1", map[string]string{"home": "haus1"}, ch) }()
+
+	select {
+	case m := <-ch:
+		if m.Tags["device"] != "haus1" || m.Tags["home"] != "haus1" {
+			t.Errorf("Unexpected tags: %v", m.Tags)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected metric from instance")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Expected no error after cancellation, got %v", err)
+	}
+
+	if err := registry.RunInstance(context.Background(), "test", "", nil, ch); err == nil {
+		t.Error("Expected error for missing instance name")
+	}
+	if err := registry.RunInstance(context.Background(), "unknown", "haus1", nil, ch); err == nil {
+		t.Error("Expected error for unknown module")
+	}
+}
+
+func TestScopeMetric(t *testing.T) {
+	original := metrics.Metric{Name: "test", Tags: map[string]string{"device": "d1"}}
+	scoped := scopeMetric(original, map[string]string{"home": "haus1"})
+
+	if scoped.Tags["home"] != "haus1" || scoped.Tags["device"] != "d1" {
+		t.Errorf("Unexpected scoped tags: %v", scoped.Tags)
+	}
+	if _, exists := original.Tags["home"]; exists {
+		t.Error("Scoping must not modify the original tags")
+	}
+}
@@ -0,0 +1,126 @@
+package knx
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// decodeDPT decodes the value of a group telegram according to its datapoint type,
+// given as "<main>.<sub>" (e.g. "9.001") or just the main number (e.g. "9").
+// Values of DPTs that fit into 6 bits (e.g. 1.x) are passed in the first byte of data.
+func decodeDPT(dpt string, data []byte) (interface{}, error) {
+	main, sub, err := parseDPT(dpt)
+	if err != nil {
+		return nil, err
+	}
+
+	switch main {
+	case 1: // Boolean (switch, alarm, ...)
+		if err := expectLength(dpt, data, 1); err != nil {
+			return nil, err
+		}
+		return int(data[0] & 0x01), nil
+	case 5: // 8-bit unsigned, scaled to 0..100% for 5.001 and 0..360° for 5.003
+		if err := expectLength(dpt, data, 1); err != nil {
+			return nil, err
+		}
+		switch sub {
+		case 1:
+			return float64(data[0]) * 100 / 255, nil
+		case 3:
+			return float64(data[0]) * 360 / 255, nil
+		}
+		return int(data[0]), nil
+	case 6: // 8-bit signed
+		if err := expectLength(dpt, data, 1); err != nil {
+			return nil, err
+		}
+		return int(int8(data[0])), nil
+	case 7: // 16-bit unsigned
+		if err := expectLength(dpt, data, 2); err != nil {
+			return nil, err
+		}
+		return int(binary.BigEndian.Uint16(data)), nil
+	case 8: // 16-bit signed
+		if err := expectLength(dpt, data, 2); err != nil {
+			return nil, err
+		}
+		return int(int16(binary.BigEndian.Uint16(data))), nil
+	case 9: // 16-bit float (temperature, humidity, lux, ...)
+		if err := expectLength(dpt, data, 2); err != nil {
+			return nil, err
+		}
+		return decodeFloat16(data), nil
+	case 12: // 32-bit unsigned (counters)
+		if err := expectLength(dpt, data, 4); err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint32(data)), nil
+	case 13: // 32-bit signed (e.g. 13.010 active energy in Wh)
+		if err := expectLength(dpt, data, 4); err != nil {
+			return nil, err
+		}
+		return int64(int32(binary.BigEndian.Uint32(data))), nil
+	case 14: // 32-bit IEEE float (e.g. 14.056 power in W)
+		if err := expectLength(dpt, data, 4); err != nil {
+			return nil, err
+		}
+		value := math.Float32frombits(binary.BigEndian.Uint32(data))
+		if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+			return nil, fmt.Errorf("invalid DPT %s value", dpt)
+		}
+		return float64(value), nil
+	}
+	return nil, fmt.Errorf("unsupported DPT %s", dpt)
+}
+
+// parseDPT splits a datapoint type into main and sub number. The sub number is 0 if not given.
+func parseDPT(dpt string) (int, int, error) {
+	mainPart, subPart, hasSub := strings.Cut(strings.TrimPrefix(strings.ToUpper(dpt), "DPT"), ".")
+	main, err := strconv.Atoi(mainPart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid DPT %q", dpt)
+	}
+	sub := 0
+	if hasSub {
+		if sub, err = strconv.Atoi(subPart); err != nil {
+			return 0, 0, fmt.Errorf("invalid DPT %q", dpt)
+		}
+	}
+	return main, sub, nil
+}
+
+// supportedDPT reports whether a datapoint type can be decoded
+func supportedDPT(dpt string) error {
+	main, _, err := parseDPT(dpt)
+	if err != nil {
+		return err
+	}
+	switch main {
+	case 1, 5, 6, 7, 8, 9, 12, 13, 14:
+		return nil
+	}
+	return fmt.Errorf("unsupported DPT %s", dpt)
+}
+
+// decodeFloat16 decodes a KNX 2-byte float: (0.01 * M) * 2^E with a 12-bit two's complement mantissa
+func decodeFloat16(data []byte) float64 {
+	raw := binary.BigEndian.Uint16(data)
+	exponent := int((raw >> 11) & 0x0f)
+	mantissa := int(raw & 0x07ff)
+	if raw&0x8000 != 0 {
+		mantissa -= 2048
+	}
+	return math.Round(0.01*float64(mantissa)*math.Pow(2, float64(exponent))*100) / 100
+}
+
+// expectLength checks that the telegram carries the number of bytes of the datapoint type
+func expectLength(dpt string, data []byte, length int) error {
+	if len(data) != length {
+		return fmt.Errorf("DPT %s expects %d bytes, got %d", dpt, length, len(data))
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+// Package knx provides a metric collection module for KNX installations.
+// It monitors the bus via a KNXnet/IP gateway, either through a tunnelling
+// connection or by listening to routing multicast, and converts the telegrams
+// of configured group addresses into metrics using their datapoint types.
+package knx
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/connection"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// Connection modes
+const (
+	ModeTunnel  = "tunnel"
+	ModeRouting = "routing"
+)
+
+// Config represents the configuration for the KNX module
+type Config struct {
+	config.BaseConfig
+	Mode              string               `json:"mode"`                         // "tunnel" (default) or "routing"
+	Gateway           string               `json:"gateway,omitempty"`            // KNXnet/IP gateway address for tunnelling (port defaults to 3671)
+	MulticastGroup    string               `json:"multicast_group,omitempty"`    // Routing multicast group (defaults to 224.0.23.12:3671)
+	GroupAddresses    []GroupAddressConfig `json:"group_addresses"`              // Group addresses to convert into metrics
+	Timeout           config.Duration      `json:"timeout,omitempty"`            // Request timeout (defaults to 5s)
+	HeartbeatInterval config.Duration      `json:"heartbeat_interval,omitempty"` // Tunnel connection check interval (defaults to 60s)
+}
+
+// GroupAddressConfig maps a group address to a measurement field
+type GroupAddressConfig struct {
+	Address     string `json:"address"`               // Group address, e.g. "1/2/3"
+	DPT         string `json:"dpt"`                   // Datapoint type, e.g. "9.001"
+	Measurement string `json:"measurement,omitempty"` // Measurement (defaults to "knx")
+	Field       string `json:"field,omitempty"`       // Field name (defaults to "value")
+	Device      string `json:"device,omitempty"`      // Device tag (defaults to the group address)
+}
+
+// KNXModule handles monitoring of the KNX bus
+type KNXModule struct {
+	config    Config
+	addresses map[string]GroupAddressConfig // by group address in three level notation
+	metricsCh chan<- metrics.Metric
+	clock     utils.Clock
+}
+
+// Run starts the KNX module and begins collecting metrics
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewKNXModule(config)
+	if err != nil {
+		return fmt.Errorf("failed to create KNX module: %w", err)
+	}
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+
+	return module.run(ctx)
+}
+
+// Probe validates the KNX configuration and connects to the gateway in tunnelling mode
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewKNXModule(cfg)
+	if err != nil {
+		return &config.ModuleError{Module: "knx", Err: err}
+	}
+	if module.config.Mode != ModeTunnel {
+		return nil
+	}
+	tunnel, err := dialTunnel(ctx, module.config.Gateway, module.config.Timeout.Duration())
+	if err != nil {
+		return err
+	}
+	return tunnel.Close()
+}
+
+// NewKNXModule creates a new KNX module instance
+func NewKNXModule(cfg Config) (*KNXModule, error) {
+	utils.Debugf("Creating new KNX module instance")
+
+	if cfg.Mode == "" {
+		cfg.Mode = ModeTunnel
+	}
+	switch cfg.Mode {
+	case ModeTunnel:
+		if cfg.Gateway == "" {
+			return nil, fmt.Errorf("gateway is required in tunnel mode")
+		}
+	case ModeRouting:
+		if cfg.MulticastGroup == "" {
+			cfg.MulticastGroup = "224.0.23.12:3671"
+		}
+	default:
+		return nil, fmt.Errorf("mode must be %q or %q, got %q", ModeTunnel, ModeRouting, cfg.Mode)
+	}
+	if len(cfg.GroupAddresses) == 0 {
+		return nil, fmt.Errorf("group_addresses is required but not configured")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = config.Duration(5 * time.Second)
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = config.Duration(60 * time.Second)
+	}
+
+	addresses := make(map[string]GroupAddressConfig, len(cfg.GroupAddresses))
+	for _, ga := range cfg.GroupAddresses {
+		address, err := parseGroupAddress(ga.Address)
+		if err != nil {
+			return nil, err
+		}
+		if err := supportedDPT(ga.DPT); err != nil {
+			return nil, fmt.Errorf("group address %s: %w", ga.Address, err)
+		}
+		key := formatGroupAddress(address)
+		if ga.Measurement == "" {
+			ga.Measurement = "knx"
+		}
+		if ga.Field == "" {
+			ga.Field = "value"
+		}
+		if ga.Device == "" {
+			ga.Device = key
+		}
+		addresses[key] = ga
+	}
+
+	utils.Debugf("KNX module created successfully")
+	return &KNXModule{
+		config:    cfg,
+		addresses: addresses,
+		clock:     utils.SystemClock,
+	}, nil
+}
+
+// DefaultConfig returns the default configuration of the KNX module.
+func DefaultConfig() Config {
+	return Config{
+		Mode:              ModeTunnel,
+		MulticastGroup:    "224.0.23.12:3671",
+		Timeout:           config.Duration(5 * time.Second),
+		HeartbeatInterval: config.Duration(60 * time.Second),
+	}
+}
+
+// LoadConfig loads the KNX module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("knx")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
+
+// run monitors the bus until the context is cancelled or the connection is lost.
+// A lost connection is returned as error, so that the module is restarted.
+func (km *KNXModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("KNX module", "main", func() error {
+		if km.config.Mode == ModeRouting {
+			utils.Infof("Listening for KNX routing indications on %s", km.config.MulticastGroup)
+			if err := receiveRouting(ctx, km.config.MulticastGroup, km.handleTelegram); ctx.Err() == nil {
+				return err
+			}
+			return nil
+		}
+
+		tracker := connection.NewTracker(km.config.InstanceName("knx"), km.config.Gateway, km.metricsCh)
+		tunnel, err := dialTunnel(ctx, km.config.Gateway, km.config.Timeout.Duration())
+		utils.AuditConnect(km.config.InstanceName("knx"), "knx", km.config.Gateway, 0, err)
+		tracker.SetConnected(err == nil)
+		if err != nil {
+			return fmt.Errorf("failed to connect to KNX gateway: %w", err)
+		}
+		defer tunnel.Close()
+		utils.Infof("Connected to KNX gateway %s", km.config.Gateway)
+
+		err = tunnel.receive(ctx, km.config.HeartbeatInterval.Duration(), km.handleTelegram)
+		if ctx.Err() != nil {
+			return nil
+		}
+		tracker.SetConnected(false)
+		return err
+	})
+}
+
+// handleTelegram sends the value of a group write or response telegram of a configured group address
+func (km *KNXModule) handleTelegram(telegram Telegram) {
+	utils.WithPanicRecoveryAndContinue("KNX telegram handler", telegram.Destination, func() {
+		if telegram.Service != apciGroupWrite && telegram.Service != apciGroupResponse {
+			return
+		}
+		ga, ok := km.addresses[telegram.Destination]
+		if !ok {
+			return
+		}
+
+		value, err := decodeDPT(ga.DPT, telegram.Data)
+		if err != nil {
+			utils.Warnf("Failed to decode telegram for group address %s: %v", telegram.Destination, err)
+			return
+		}
+
+		metric := metrics.Metric{
+			Name: ga.Measurement,
+			Tags: map[string]string{
+				"vendor":   "knx",
+				"device":   ga.Device,
+				"friendly": km.config.GetFriendlyName(ga.Device, "", ga.Device),
+			},
+			Fields:    map[string]interface{}{ga.Field: value},
+			Timestamp: km.clock.Now(),
+		}
+		if err := metric.Validate(); err != nil {
+			utils.Warnf("Invalid %s metric for group address %s: %v", ga.Measurement, telegram.Destination, err)
+			return
+		}
+
+		select {
+		case km.metricsCh <- metric:
+		default:
+			utils.Warnf("Metrics channel is full, dropping metric for group address %s", telegram.Destination)
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package knx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/testutil"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// groupFrame builds a cEMI L_Data.ind frame of a group write from 1.1.5 to the group address.
+// A single data byte with small set is sent in the APCI byte like 6-bit values on the bus.
+func groupFrame(t *testing.T, address string, data []byte, small bool) []byte {
+	ga, err := parseGroupAddress(address)
+	if err != nil {
+		t.Fatalf("Invalid group address: %v", err)
+	}
+	frame := []byte{cemiLDataInd, 0x00, 0xbc, 0xe0, 0x11, 0x05, byte(ga >> 8), byte(ga)}
+	if small {
+		return append(frame, 0x01, 0x00, 0x80|data[0])
+	}
+	frame = append(frame, byte(len(data)+1), 0x00, 0x80)
+	return append(frame, data...)
+}
+
+func TestNewKNXModule(t *testing.T) {
+	tah := utils.NewTestAssertionHelper()
+	addresses := []GroupAddressConfig{{Address: "1/2/3", DPT: "9.001"}}
+
+	_, err := NewKNXModule(Config{GroupAddresses: addresses})
+	tah.AssertError(t, err, "Expected error for missing gateway")
+
+	_, err = NewKNXModule(Config{Mode: "usb", Gateway: "192.168.1.20", GroupAddresses: addresses})
+	tah.AssertError(t, err, "Expected error for invalid mode")
+
+	_, err = NewKNXModule(Config{Gateway: "192.168.1.20", GroupAddresses: []GroupAddressConfig{{Address: "1/2/3", DPT: "16.000"}}})
+	tah.AssertError(t, err, "Expected error for unsupported DPT")
+
+	_, err = NewKNXModule(Config{Gateway: "192.168.1.20", GroupAddresses: []GroupAddressConfig{{Address: "32/0/0", DPT: "1.001"}}})
+	tah.AssertError(t, err, "Expected error for invalid group address")
+
+	module, err := NewKNXModule(Config{Mode: ModeRouting, GroupAddresses: []GroupAddressConfig{{Address: "2563", DPT: "9.001"}}})
+	tah.AssertNoError(t, err, "Failed to create KNX module")
+	ga, ok := module.addresses["1/2/3"]
+	if !ok || ga.Measurement != "knx" || ga.Field != "value" || ga.Device != "1/2/3" {
+		t.Errorf("Expected defaults for group address, got %+v", module.addresses)
+	}
+	if module.config.MulticastGroup != "224.0.23.12:3671" {
+		t.Errorf("Expected default multicast group, got %s", module.config.MulticastGroup)
+	}
+}
+
+func TestGroupAddress(t *testing.T) {
+	for input, expected := range map[string]string{"1/2/3": "1/2/3", "1/515": "1/2/3", "2563": "1/2/3", "31/7/255": "31/7/255"} {
+		address, err := parseGroupAddress(input)
+		if err != nil {
+			t.Errorf("Failed to parse %s: %v", input, err)
+			continue
+		}
+		if formatted := formatGroupAddress(address); formatted != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, input, formatted)
+		}
+	}
+	for _, input := range []string{"", "1/8/0", "1/2/256", "a/b/c", "1/2/3/4"} {
+		if _, err := parseGroupAddress(input); err == nil {
+			t.Errorf("Expected error for %q", input)
+		}
+	}
+}
+
+func TestDecodeDPT(t *testing.T) {
+	tests := []struct {
+		dpt      string
+		data     []byte
+		expected interface{}
+	}{
+		{"1.001", []byte{0x01}, 1},
+		{"5.001", []byte{0xff}, 100.0},
+		{"5.010", []byte{0x2a}, 42},
+		{"6", []byte{0xfe}, -2},
+		{"7.001", []byte{0x01, 0x00}, 256},
+		{"8.001", []byte{0xff, 0xff}, -1},
+		{"9.001", []byte{0x0c, 0x29}, 21.3},
+		{"9.001", []byte{0x87, 0x9c}, -1.0},
+		{"DPT9.004", []byte{0x4c, 0x1b}, 5381.12},
+		{"12.001", []byte{0x00, 0x01, 0x00, 0x00}, int64(65536)},
+		{"13.010", []byte{0xff, 0xff, 0xff, 0xfe}, int64(-2)},
+		{"14.056", []byte{0x44, 0x9a, 0x50, 0x00}, 1234.5},
+	}
+	for _, tt := range tests {
+		value, err := decodeDPT(tt.dpt, tt.data)
+		if err != nil {
+			t.Errorf("Failed to decode DPT %s: %v", tt.dpt, err)
+			continue
+		}
+		if value != tt.expected {
+			t.Errorf("Expected %v (%T) for DPT %s, got %v (%T)", tt.expected, tt.expected, tt.dpt, value, value)
+		}
+	}
+
+	if _, err := decodeDPT("9.001", []byte{0x01}); err == nil {
+		t.Error("Expected error for wrong data length")
+	}
+	if _, err := decodeDPT("16.000", []byte{0x01}); err == nil {
+		t.Error("Expected error for unsupported DPT")
+	}
+}
+
+func TestParseCEMI(t *testing.T) {
+	telegram, ok, err := parseCEMI(groupFrame(t, "1/2/3", []byte{0x0c, 0x29}, false))
+	if err != nil || !ok {
+		t.Fatalf("Failed to parse frame: %v", err)
+	}
+	if telegram.Source != "1.1.5" || telegram.Destination != "1/2/3" || telegram.Service != apciGroupWrite ||
+		len(telegram.Data) != 2 || telegram.Data[0] != 0x0c {
+		t.Errorf("Unexpected telegram %+v", telegram)
+	}
+
+	telegram, ok, err = parseCEMI(groupFrame(t, "0/0/1", []byte{0x01}, true))
+	if err != nil || !ok || len(telegram.Data) != 1 || telegram.Data[0] != 0x01 {
+		t.Errorf("Unexpected telegram %+v, %v", telegram, err)
+	}
+
+	// Frames to individual addresses are ignored
+	frame := groupFrame(t, "1/2/3", []byte{0x01}, true)
+	frame[3] = 0x60
+	if _, ok, err := parseCEMI(frame); ok || err != nil {
+		t.Errorf("Expected individual frame to be ignored, got %v, %v", ok, err)
+	}
+
+	if _, _, err := parseCEMI([]byte{cemiLDataInd, 0x00, 0xbc}); err == nil {
+		t.Error("Expected error for truncated frame")
+	}
+}
+
+func TestTunnel(t *testing.T) {
+	gateway, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer gateway.Close()
+
+	acks := make(chan byte, 10)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := gateway.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			service, body, err := parsePacket(buf[:n])
+			if err != nil {
+				continue
+			}
+			switch service {
+			case serviceConnectRequest:
+				gateway.WriteTo(packet(serviceConnectResponse, []byte{0x07, 0x00}, natHPAI, []byte{0x04, 0x04, 0x11, 0xff}), addr)
+				// Send a telegram twice (repeated frame) and one of an unknown group address
+				frame := groupFrame(t, "1/2/3", []byte{0x0c, 0x29}, false)
+				gateway.WriteTo(packet(serviceTunnellingRequest, []byte{0x04, 0x07, 0x00, 0x00}, frame), addr)
+				gateway.WriteTo(packet(serviceTunnellingRequest, []byte{0x04, 0x07, 0x00, 0x00}, frame), addr)
+				gateway.WriteTo(packet(serviceTunnellingRequest, []byte{0x04, 0x07, 0x01, 0x00}, groupFrame(t, "5/5/5", []byte{0x01}, true)), addr)
+			case serviceTunnellingAck:
+				acks <- body[2]
+			case serviceConnectionStateRequest:
+				gateway.WriteTo(packet(serviceDisconnectRequest, []byte{0x07, 0x00}, natHPAI), addr)
+			}
+		}
+	}()
+
+	module, err := NewKNXModule(Config{
+		BaseConfig:     config.BaseConfig{FriendlyNameOverrides: map[string]string{"wohnzimmer": "Wohnzimmer"}},
+		Gateway:        gateway.LocalAddr().String(),
+		GroupAddresses: []GroupAddressConfig{{Address: "1/2/3", DPT: "9.001", Measurement: "climate", Field: "temperature", Device: "wohnzimmer"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	module.config.HeartbeatInterval = config.Duration(200 * time.Millisecond)
+	sink := testutil.NewCollectingSink(t)
+	module.metricsCh = sink.Chan()
+
+	// The gateway closes the connection on the first heartbeat
+	err = module.run(context.Background())
+	if err == nil || errors.Is(err, context.Canceled) {
+		t.Errorf("Expected error for closed connection, got %v", err)
+	}
+
+	for _, seq := range []byte{0, 0, 1} {
+		select {
+		case ack := <-acks:
+			if ack != seq {
+				t.Errorf("Expected ack for sequence %d, got %d", seq, ack)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Missing ack for sequence %d", seq)
+		}
+	}
+
+	// Expect: connection status, climate metric, connection status. The repeated
+	// frame and the telegram of the unknown group address are not sent
+	sink.WaitFor(t, 3)
+	testutil.AssertLines(t, sink.Named("climate"), `climate,device=wohnzimmer,friendly=Wohnzimmer,vendor=knx temperature=21.300000`)
+}
@@ -0,0 +1,360 @@
+package knx
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// KNXnet/IP service types
+const (
+	serviceConnectRequest          = 0x0205
+	serviceConnectResponse         = 0x0206
+	serviceConnectionStateRequest  = 0x0207
+	serviceConnectionStateResponse = 0x0208
+	serviceDisconnectRequest       = 0x0209
+	serviceDisconnectResponse      = 0x020a
+	serviceTunnellingRequest       = 0x0420
+	serviceTunnellingAck           = 0x0421
+	serviceRoutingIndication       = 0x0530
+
+	headerSize = 6
+)
+
+// cEMI message codes of received group telegrams
+const (
+	cemiLDataInd = 0x29
+	cemiLDataCon = 0x2e
+)
+
+// Group services (APCI)
+const (
+	apciGroupRead     = 0x0
+	apciGroupResponse = 0x1
+	apciGroupWrite    = 0x2
+)
+
+// Telegram represents a group telegram received from the bus
+type Telegram struct {
+	Source      string // Individual address of the sender, e.g. "1.1.5"
+	Destination string // Group address, e.g. "1/2/3"
+	Service     int    // apciGroupRead, apciGroupResponse or apciGroupWrite
+	Data        []byte // Payload; 6-bit values are passed as a single byte
+}
+
+// parseGroupAddress parses a three level ("1/2/3"), two level ("1/515") or
+// raw group address into its 16-bit value
+func parseGroupAddress(address string) (uint16, error) {
+	parts := strings.Split(address, "/")
+	values := make([]int, len(parts))
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 {
+			return 0, fmt.Errorf("invalid group address %q", address)
+		}
+		values[i] = value
+	}
+
+	switch {
+	case len(values) == 3 && values[0] < 32 && values[1] < 8 && values[2] < 256:
+		return uint16(values[0]<<11 | values[1]<<8 | values[2]), nil
+	case len(values) == 2 && values[0] < 32 && values[1] < 2048:
+		return uint16(values[0]<<11 | values[1]), nil
+	case len(values) == 1 && values[0] < 65536:
+		return uint16(values[0]), nil
+	}
+	return 0, fmt.Errorf("invalid group address %q", address)
+}
+
+// formatGroupAddress formats a group address in three level notation
+func formatGroupAddress(address uint16) string {
+	return fmt.Sprintf("%d/%d/%d", address>>11, (address>>8)&0x07, address&0xff)
+}
+
+// formatIndividualAddress formats an individual address as area.line.device
+func formatIndividualAddress(address uint16) string {
+	return fmt.Sprintf("%d.%d.%d", address>>12, (address>>8)&0x0f, address&0xff)
+}
+
+// parseCEMI parses a cEMI L_Data frame. It returns false for frames that are
+// no group telegrams (e.g. frames to individual addresses).
+func parseCEMI(frame []byte) (Telegram, bool, error) {
+	if len(frame) < 2 {
+		return Telegram{}, false, fmt.Errorf("cEMI frame too short")
+	}
+	if frame[0] != cemiLDataInd && frame[0] != cemiLDataCon {
+		return Telegram{}, false, nil
+	}
+
+	// Skip the additional information
+	offset := 2 + int(frame[1])
+	if len(frame) < offset+8 {
+		return Telegram{}, false, fmt.Errorf("cEMI frame too short")
+	}
+	ctrl2 := frame[offset+1]
+	source := binary.BigEndian.Uint16(frame[offset+2:])
+	destination := binary.BigEndian.Uint16(frame[offset+4:])
+	length := int(frame[offset+6])
+	tpdu := frame[offset+7:]
+	if ctrl2&0x80 == 0 {
+		return Telegram{}, false, nil
+	}
+	if length < 1 || len(tpdu) < length+1 {
+		return Telegram{}, false, fmt.Errorf("cEMI frame too short for data length %d", length)
+	}
+
+	telegram := Telegram{
+		Source:      formatIndividualAddress(source),
+		Destination: formatGroupAddress(destination),
+		Service:     int(tpdu[0]&0x03)<<2 | int(tpdu[1]>>6),
+	}
+	if length == 1 {
+		telegram.Data = []byte{tpdu[1] & 0x3f}
+	} else {
+		telegram.Data = append([]byte{}, tpdu[2:length+1]...)
+	}
+	return telegram, true, nil
+}
+
+// packet builds a KNXnet/IP packet with header
+func packet(service uint16, body ...[]byte) []byte {
+	length := headerSize
+	for _, part := range body {
+		length += len(part)
+	}
+	result := make([]byte, headerSize, length)
+	result[0] = 0x06
+	result[1] = 0x10
+	binary.BigEndian.PutUint16(result[2:], service)
+	binary.BigEndian.PutUint16(result[4:], uint16(length))
+	for _, part := range body {
+		result = append(result, part...)
+	}
+	return result
+}
+
+// parsePacket validates the header of a KNXnet/IP packet and returns service type and body
+func parsePacket(data []byte) (uint16, []byte, error) {
+	if len(data) < headerSize || data[0] != 0x06 || data[1] != 0x10 {
+		return 0, nil, fmt.Errorf("invalid KNXnet/IP packet")
+	}
+	length := int(binary.BigEndian.Uint16(data[4:]))
+	if length < headerSize || length > len(data) {
+		return 0, nil, fmt.Errorf("invalid KNXnet/IP packet length %d", length)
+	}
+	return binary.BigEndian.Uint16(data[2:]), data[headerSize:length], nil
+}
+
+// natHPAI is the host protocol address information "0.0.0.0:0", which asks the
+// gateway to reply to the address the request came from (NAT mode)
+var natHPAI = []byte{0x08, 0x01, 0, 0, 0, 0, 0, 0}
+
+// tunnel is a KNXnet/IP tunnelling connection to a gateway
+type tunnel struct {
+	conn      net.Conn
+	channel   byte
+	timeout   time.Duration
+	lastSeq   int
+	heartbeat chan struct{}
+}
+
+// dialTunnel connects to a gateway and establishes a link layer tunnel
+func dialTunnel(ctx context.Context, gateway string, timeout time.Duration) (*tunnel, error) {
+	if _, _, err := net.SplitHostPort(gateway); err != nil {
+		gateway = net.JoinHostPort(gateway, "3671")
+	}
+	if err := utils.CheckDestination(ctx, gateway); err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "udp", gateway)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to gateway %s: %w", gateway, err)
+	}
+
+	t := &tunnel{conn: conn, timeout: timeout, lastSeq: -1, heartbeat: make(chan struct{}, 1)}
+
+	// Connection request data block: tunnel connection on the link layer
+	cri := []byte{0x04, 0x04, 0x02, 0x00}
+	body, err := t.request(packet(serviceConnectRequest, natHPAI, natHPAI, cri), serviceConnectResponse)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connect request failed: %w", err)
+	}
+	if len(body) < 2 {
+		conn.Close()
+		return nil, fmt.Errorf("invalid connect response")
+	}
+	if body[1] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("gateway rejected connection with status 0x%02x", body[1])
+	}
+	t.channel = body[0]
+	return t, nil
+}
+
+// request sends a packet and waits for the response of the given service type
+func (t *tunnel) request(data []byte, responseService uint16) ([]byte, error) {
+	if err := t.conn.SetDeadline(time.Now().Add(t.timeout)); err != nil {
+		return nil, err
+	}
+	defer t.conn.SetDeadline(time.Time{})
+	if _, err := t.conn.Write(data); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1024)
+	for {
+		n, err := t.conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		service, body, err := parsePacket(buf[:n])
+		if err != nil {
+			continue
+		}
+		if service == responseService {
+			return body, nil
+		}
+	}
+}
+
+// Close disconnects the tunnel
+func (t *tunnel) Close() error {
+	t.conn.Write(packet(serviceDisconnectRequest, []byte{t.channel, 0}, natHPAI))
+	return t.conn.Close()
+}
+
+// receive reads group telegrams and passes them to handle until the context is cancelled
+// or the connection is lost. The connection is checked with a heartbeat every interval.
+func (t *tunnel) receive(ctx context.Context, heartbeatInterval time.Duration, handle func(Telegram)) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- t.readLoop(handle)
+	}()
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	// deadline is set while a connection state request is unanswered
+	var deadline <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errCh:
+			return err
+		case <-t.heartbeat:
+			deadline = nil
+		case <-deadline:
+			return fmt.Errorf("gateway did not answer the heartbeat")
+		case <-ticker.C:
+			if _, err := t.conn.Write(packet(serviceConnectionStateRequest, []byte{t.channel, 0}, natHPAI)); err != nil {
+				return fmt.Errorf("heartbeat failed: %w", err)
+			}
+			if deadline == nil {
+				deadline = time.After(t.timeout)
+			}
+		}
+	}
+}
+
+// readLoop reads packets, acknowledges tunnelling requests and passes group telegrams to handle
+func (t *tunnel) readLoop(handle func(Telegram)) error {
+	buf := make([]byte, 1024)
+	for {
+		n, err := t.conn.Read(buf)
+		if err != nil {
+			return fmt.Errorf("connection lost: %w", err)
+		}
+		service, body, err := parsePacket(buf[:n])
+		if err != nil {
+			utils.Debugf("Ignoring invalid KNXnet/IP packet: %v", err)
+			continue
+		}
+
+		switch service {
+		case serviceTunnellingRequest:
+			if len(body) < 4 || int(body[0]) > len(body) || body[1] != t.channel {
+				continue
+			}
+			seq := int(body[2])
+			t.conn.Write(packet(serviceTunnellingAck, []byte{0x04, t.channel, body[2], 0x00}))
+			// Repeated frames are acknowledged again but only processed once
+			if seq == t.lastSeq {
+				continue
+			}
+			t.lastSeq = seq
+			t.handleFrame(body[body[0]:], handle)
+		case serviceConnectionStateResponse:
+			if len(body) >= 2 && body[1] != 0 {
+				return fmt.Errorf("gateway reported connection state 0x%02x", body[1])
+			}
+			select {
+			case t.heartbeat <- struct{}{}:
+			default:
+			}
+		case serviceDisconnectRequest:
+			t.conn.Write(packet(serviceDisconnectResponse, []byte{t.channel, 0}))
+			return fmt.Errorf("gateway closed the connection")
+		}
+	}
+}
+
+// handleFrame parses a cEMI frame and passes group telegrams to handle
+func (t *tunnel) handleFrame(frame []byte, handle func(Telegram)) {
+	telegram, ok, err := parseCEMI(frame)
+	if err != nil {
+		utils.Debugf("Ignoring invalid cEMI frame: %v", err)
+		return
+	}
+	if ok {
+		handle(telegram)
+	}
+}
+
+// receiveRouting joins the KNXnet/IP routing multicast group and passes group
+// telegrams to handle until the context is cancelled
+func receiveRouting(ctx context.Context, group string, handle func(Telegram)) error {
+	addr, err := net.ResolveUDPAddr("udp4", group)
+	if err != nil {
+		return fmt.Errorf("invalid multicast group %q: %w", group, err)
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, addr)
+	if err != nil {
+		return fmt.Errorf("failed to join multicast group %s: %w", group, err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 1024)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to receive routing indications: %w", err)
+		}
+		service, body, err := parsePacket(buf[:n])
+		if err != nil || service != serviceRoutingIndication {
+			continue
+		}
+		telegram, ok, err := parseCEMI(body)
+		if err != nil {
+			utils.Debugf("Ignoring invalid cEMI frame: %v", err)
+			continue
+		}
+		if ok {
+			handle(telegram)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+// Package kostal provides a metric collection module for Kostal Plenticore
+// (and the identical Steca coolcept fleX) hybrid inverters.
+// It reads AC/DC power, battery state of charge and grid power either from the
+// local REST API, which requires a session login with the plant owner
+// password, or via SunSpec Modbus TCP.
+package kostal
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/connection"
+	"github.com/janhuddel/metrics-agent/internal/modbus"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// Protocols to read the inverter with
+	protocolREST   = "rest"
+	protocolModbus = "modbus"
+
+	// Metric name
+	metricName = "electricity"
+)
+
+// energyCounterKinds are the kinds of the energy totals, which the inverter
+// reports since its installation
+var energyCounterKinds = map[string]metrics.Kind{
+	"yield_total":       metrics.KindCounter,
+	"grid_import_total": metrics.KindCounter,
+	"grid_export_total": metrics.KindCounter,
+}
+
+// Config represents the configuration for the Kostal module
+type Config struct {
+	config.BaseConfig
+	Protocol           string          `json:"protocol,omitempty"`             // "rest" (default) or "modbus"
+	URL                string          `json:"url"`                            // Inverter base URL for the REST API (e.g. "http://192.168.1.20")
+	Password           string          `json:"password"`                       // Plant owner password for the REST API
+	Address            string          `json:"address"`                        // Modbus TCP address (e.g. "192.168.1.20:1502")
+	UnitID             int             `json:"unit_id,omitempty"`              // Modbus unit ID (defaults to 71)
+	InsecureSkipVerify bool            `json:"insecure_skip_verify,omitempty"` // Skip TLS verification for self-signed certificates
+	Interval           config.Duration `json:"interval,omitempty"`             // Polling interval (defaults to 30s)
+	Timeout            config.Duration `json:"timeout,omitempty"`              // Request timeout (defaults to 10s)
+}
+
+// Reading holds the values read from the inverter. Values the inverter
+// doesn't report are nil.
+type Reading struct {
+	ACPower         *float64 // AC output power in W
+	DCPower         *float64 // DC input power of all strings in W
+	GridPower       *float64 // Power at the grid connection point in W, positive when importing
+	HomePower       *float64 // Home consumption in W
+	BatterySOC      *float64 // Battery state of charge in percent
+	BatteryPower    *float64 // Battery power in W, positive when discharging
+	YieldTotal      *float64 // AC energy produced since installation in Wh
+	GridImportTotal *float64 // Energy imported from the grid in Wh
+	GridExportTotal *float64 // Energy exported to the grid in Wh
+}
+
+// reader reads the current values from the inverter
+type reader interface {
+	Read(ctx context.Context) (Reading, error)
+}
+
+// KostalModule handles polling of a Kostal inverter
+type KostalModule struct {
+	config      Config
+	device      string
+	reader      reader
+	metricsCh   chan<- metrics.Metric
+	clock       utils.Clock
+	collections *utils.CollectionLog // last successful collection, nil if not remembered
+	tracker     *connection.Tracker
+}
+
+// Run starts the Kostal module and begins collecting metrics
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewKostalModule(config)
+	if err != nil {
+		return fmt.Errorf("failed to create Kostal module: %w", err)
+	}
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+	module.collections = utils.OpenCollectionLog(config.InstanceName("kostal"), module.clock)
+
+	return module.run(ctx)
+}
+
+// Probe validates the Kostal configuration and checks that the inverter is reachable
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewKostalModule(cfg)
+	if err != nil {
+		return &config.ModuleError{Module: "kostal", Err: err}
+	}
+	if module.config.Protocol == protocolModbus {
+		return utils.ProbeAddress(ctx, module.config.Address, module.config.Timeout.Duration())
+	}
+	return utils.ProbeURL(ctx, module.config.URL, module.config.Timeout.Duration())
+}
+
+// NewKostalModule creates a new Kostal module instance
+func NewKostalModule(cfg Config) (*KostalModule, error) {
+	utils.Debugf("Creating new Kostal module instance")
+
+	if cfg.Protocol == "" {
+		cfg.Protocol = protocolREST
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(30 * time.Second)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = config.Duration(10 * time.Second)
+	}
+
+	module := &KostalModule{
+		clock:  utils.SystemClock,
+		config: cfg,
+	}
+
+	switch cfg.Protocol {
+	case protocolREST:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("url is required but not configured")
+		}
+		if cfg.Password == "" {
+			return nil, fmt.Errorf("password is required but not configured")
+		}
+		parsed, err := url.Parse(cfg.URL)
+		if err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid url %q", cfg.URL)
+		}
+		cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+		module.config.URL = cfg.URL
+		module.device = parsed.Hostname()
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if cfg.InsecureSkipVerify {
+			utils.Warnf("TLS certificate verification is disabled for Kostal inverter %s", cfg.URL)
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		module.reader = &RESTClient{
+			url:      cfg.URL,
+			password: cfg.Password,
+			httpClient: &http.Client{
+				Timeout:   cfg.Timeout.Duration(),
+				Transport: utils.OutboundTransport(cfg.InstanceName("kostal"), transport),
+			},
+			onResponse: func(resp *http.Response, err error) { module.connection().SetPollResult(resp, err) },
+		}
+	case protocolModbus:
+		if cfg.Address == "" {
+			return nil, fmt.Errorf("address is required but not configured")
+		}
+		host, _, err := net.SplitHostPort(cfg.Address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", cfg.Address, err)
+		}
+		if cfg.UnitID <= 0 {
+			cfg.UnitID = 71
+		}
+		if cfg.UnitID > 255 {
+			return nil, fmt.Errorf("unit_id must be between 1 and 255, got %d", cfg.UnitID)
+		}
+		module.config.UnitID = cfg.UnitID
+		module.device = host
+		module.reader = &ModbusClient{client: modbus.Client{
+			Address:  cfg.Address,
+			UnitID:   byte(cfg.UnitID),
+			Timeout:  cfg.Timeout.Duration(),
+			Instance: cfg.InstanceName("kostal"),
+		}}
+	default:
+		return nil, fmt.Errorf("unknown protocol %q, expected %q or %q", cfg.Protocol, protocolREST, protocolModbus)
+	}
+
+	utils.Debugf("Kostal module created successfully")
+	return module, nil
+}
+
+// DefaultConfig returns the default configuration of the Kostal module.
+func DefaultConfig() Config {
+	return Config{
+		Protocol: protocolREST,
+		UnitID:   71,
+		Interval: config.Duration(30 * time.Second),
+		Timeout:  config.Duration(10 * time.Second),
+	}
+}
+
+// LoadConfig loads the Kostal module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("kostal")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
+
+// run executes the main module loop
+func (km *KostalModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Kostal module", "main", func() error {
+		interval := km.config.Interval.Duration()
+		ticker := utils.NewScheduledTicker(ctx, interval)
+		defer ticker.Stop()
+
+		// Collect initial data unless outside the collection schedule or skipped,
+		// but not before an interval has passed since the last collection
+		initial := km.collections.Initial(ctx, interval)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-initial:
+				if err := utils.CollectWithRetry(ctx, interval, km.collectData); err != nil {
+					utils.Warnf("Failed to collect initial Kostal data: %v", err)
+				} else {
+					km.collections.Record()
+				}
+			case <-ticker.C:
+				if err := utils.CollectWithRetry(ctx, interval, km.collectData); err != nil {
+					utils.Warnf("Failed to collect Kostal data: %v", err)
+				} else {
+					km.collections.Record()
+				}
+			}
+		}
+	})
+}
+
+// collectData reads the inverter and sends its metric
+func (km *KostalModule) collectData(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Kostal data collection", km.device, func() error {
+		reading, err := km.reader.Read(ctx)
+		if err != nil {
+			return err
+		}
+		km.sendMetric(reading, km.clock.Now())
+		return nil
+	})
+}
+
+// connection returns the tracker for the REST API, creating it on first use
+func (km *KostalModule) connection() *connection.Tracker {
+	if km.tracker == nil {
+		km.tracker = connection.NewTracker(km.config.InstanceName("kostal"), km.config.URL, km.metricsCh)
+	}
+	return km.tracker
+}
+
+// sendMetric creates and sends the metric of the inverter
+func (km *KostalModule) sendMetric(reading Reading, timestamp time.Time) {
+	fields := make(map[string]interface{})
+	setField := func(name string, value *float64) {
+		if value != nil {
+			fields[name] = *value
+		}
+	}
+
+	setField("power", reading.ACPower)
+	setField("dc_power", reading.DCPower)
+	setField("grid_power", reading.GridPower)
+	setField("home_power", reading.HomePower)
+	setField("battery_soc", reading.BatterySOC)
+	setField("battery_power", reading.BatteryPower)
+	setField("yield_total", reading.YieldTotal)
+	setField("grid_import_total", reading.GridImportTotal)
+	setField("grid_export_total", reading.GridExportTotal)
+
+	// Split the signed grid power, so import and export can be summed up separately
+	if reading.GridPower != nil {
+		fields["grid_import_power"] = max(*reading.GridPower, 0)
+		fields["grid_export_power"] = max(-*reading.GridPower, 0)
+	}
+
+	if len(fields) == 0 {
+		utils.Warnf("Kostal inverter %s reported no values", km.device)
+		return
+	}
+
+	metric := metrics.Metric{
+		Name: metricName,
+		Tags: map[string]string{
+			"vendor":   "kostal",
+			"device":   km.device,
+			"friendly": km.config.GetFriendlyName(km.device, km.device, km.device),
+		},
+		Fields:     fields,
+		FieldKinds: energyCounterKinds,
+		Timestamp:  timestamp,
+	}
+
+	if err := metric.Validate(); err != nil {
+		utils.Warnf("Invalid metric for Kostal inverter %s: %v", km.device, err)
+		return
+	}
+
+	select {
+	case km.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping metric for Kostal inverter %s", km.device)
+	}
+}
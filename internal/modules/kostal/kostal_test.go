@@ -0,0 +1,232 @@
+package kostal
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/internal/testutil"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestNewKostalModule(t *testing.T) {
+	tah := utils.NewTestAssertionHelper()
+
+	_, err := NewKostalModule(Config{Password: "secret"})
+	tah.AssertError(t, err, "Expected error for missing url")
+
+	_, err = NewKostalModule(Config{URL: "http://plenticore"})
+	tah.AssertError(t, err, "Expected error for missing password")
+
+	_, err = NewKostalModule(Config{Protocol: "modbus"})
+	tah.AssertError(t, err, "Expected error for missing address")
+
+	_, err = NewKostalModule(Config{Protocol: "sml", Address: "plenticore:1502"})
+	tah.AssertError(t, err, "Expected error for unknown protocol")
+
+	module, err := NewKostalModule(Config{URL: "http://plenticore/", Password: "secret"})
+	tah.AssertNoError(t, err, "Failed to create REST module")
+	if module.config.URL != "http://plenticore" || module.device != "plenticore" {
+		t.Errorf("Unexpected URL %s or device %s", module.config.URL, module.device)
+	}
+
+	module, err = NewKostalModule(Config{Protocol: "modbus", Address: "192.168.1.20:1502"})
+	tah.AssertNoError(t, err, "Failed to create Modbus module")
+	if module.config.UnitID != 71 || module.device != "192.168.1.20" {
+		t.Errorf("Unexpected unit ID %d or device %s", module.config.UnitID, module.device)
+	}
+}
+
+// fakePlenticore is a REST API server performing the server side of the login
+type fakePlenticore struct {
+	password    string
+	salt        []byte
+	rounds      int
+	authMessage string
+	keys        scramKeys
+	token       string
+	sessions    int
+}
+
+func (f *fakePlenticore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	switch r.URL.Path {
+	case "/api/v1/auth/start":
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		salt := base64.StdEncoding.EncodeToString(f.salt)
+		f.authMessage = fmt.Sprintf("n=%s,r=%s,r=server,s=%s,i=%d,c=biws,r=server", body["username"], body["nonce"], salt, f.rounds)
+		f.keys, _ = newSCRAMKeys(f.password, f.salt, f.rounds)
+		fmt.Fprintf(w, `{"nonce":"server","transactionId":"tx","salt":%q,"rounds":%d}`, salt, f.rounds)
+	case "/api/v1/auth/finish":
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		proof, _ := base64.StdEncoding.DecodeString(body["proof"])
+		if !hmac.Equal(proof, f.keys.proof(f.authMessage)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		signature := base64.StdEncoding.EncodeToString(f.keys.serverSignature(f.authMessage))
+		fmt.Fprintf(w, `{"token":%q,"signature":%q}`, f.token, signature)
+	case "/api/v1/auth/create_session":
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		iv, _ := base64.StdEncoding.DecodeString(body["iv"])
+		tag, _ := base64.StdEncoding.DecodeString(body["tag"])
+		payload, _ := base64.StdEncoding.DecodeString(body["payload"])
+		block, _ := aes.NewCipher(f.keys.sessionKey(f.authMessage))
+		gcm, _ := cipher.NewGCMWithNonceSize(block, 16)
+		token, err := gcm.Open(nil, iv, append(payload, tag...), nil)
+		if err != nil || string(token) != f.token {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.sessions++
+		fmt.Fprintf(w, `{"sessionId":"session%d"}`, f.sessions)
+	case "/api/v1/processdata":
+		// The first session expires after one request
+		if r.Header.Get("Authorization") != fmt.Sprintf("Session session%d", f.sessions) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		fmt.Fprint(w, `[
+			{"moduleid":"devices:local","processdata":[{"id":"Dc_P","unit":"W","value":5100},{"id":"Grid_P","unit":"W","value":-2400},{"id":"Home_P","unit":"W","value":900}]},
+			{"moduleid":"devices:local:ac","processdata":[{"id":"P","unit":"W","value":4800}]},
+			{"moduleid":"devices:local:battery","processdata":[{"id":"SoC","unit":"%","value":87},{"id":"P","unit":"W","value":-1500}]},
+			{"moduleid":"scb:statistic:EnergyFlow","processdata":[{"id":"Statistic:Yield:Total","unit":"Wh","value":12345678}]}
+		]`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestRESTCollectData(t *testing.T) {
+	fake := &fakePlenticore{password: "secret", salt: []byte("0123456789ab"), rounds: 29000, token: "token"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	module, err := NewKostalModule(Config{URL: server.URL, Password: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.metricsCh = ch
+
+	if err := module.collectData(context.Background()); err != nil {
+		t.Fatalf("collectData failed: %v", err)
+	}
+	if status := <-ch; status.Name != "connection_status" {
+		t.Errorf("Expected connection status metric, got %+v", status)
+	}
+	metric := <-ch
+	if metric.Name != "electricity" || metric.Tags["vendor"] != "kostal" {
+		t.Errorf("Unexpected metric: %+v", metric)
+	}
+	expected := map[string]interface{}{
+		"power":             4800.0,
+		"dc_power":          5100.0,
+		"grid_power":        -2400.0,
+		"grid_import_power": 0.0,
+		"grid_export_power": 2400.0,
+		"home_power":        900.0,
+		"battery_soc":       87.0,
+		"battery_power":     -1500.0,
+		"yield_total":       12345678.0,
+	}
+	for name, value := range expected {
+		if metric.Fields[name] != value {
+			t.Errorf("Expected %s to be %v, got %v", name, value, metric.Fields[name])
+		}
+	}
+
+	// An expired session is replaced by a new login
+	fake.sessions++
+	if err := module.collectData(context.Background()); err != nil {
+		t.Fatalf("collectData with expired session failed: %v", err)
+	}
+	if fake.sessions != 3 {
+		t.Errorf("Expected a new session after the expired one, got %d sessions", fake.sessions)
+	}
+}
+
+func TestRESTWrongPassword(t *testing.T) {
+	fake := &fakePlenticore{password: "secret", salt: []byte("0123456789ab"), rounds: 1000, token: "token"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	module, err := NewKostalModule(Config{URL: server.URL, Password: "wrong"})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	module.metricsCh = make(chan metrics.Metric, 10)
+
+	if err := module.collectData(context.Background()); err == nil {
+		t.Error("Expected login with wrong password to fail")
+	}
+}
+
+func TestModbusCollectData(t *testing.T) {
+	device := testutil.NewSunSpecDevice(40000)
+	device.AddModel(1, 66, nil)
+	device.AddModel(103, 50, map[uint16]uint16{
+		12: 4800, 13: 0, // W
+		22: 0x00BC, 23: 0x614E, 24: 0, // WH = 12345678
+		29: 510, 30: 1, // DCW = 5100
+	})
+	device.AddModel(124, 24, map[uint16]uint16{6: 8700, 20: 0xFFFE}) // ChaState = 87.00
+	device.AddModel(203, 105, map[uint16]uint16{
+		16: 0xFFF6, 20: 2, // W = -1000
+		36: 0, 37: 500, 44: 0, 45: 300, 52: 1, // TotWhExp = 5000, TotWhImp = 3000
+	})
+	registers := device.End()
+
+	module, err := NewKostalModule(Config{Protocol: "modbus", Address: testutil.ServeModbus(t, map[byte]map[uint16]uint16{71: registers})})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.metricsCh = ch
+
+	if err := module.collectData(context.Background()); err != nil {
+		t.Fatalf("collectData failed: %v", err)
+	}
+	metric := <-ch
+	expected := map[string]interface{}{
+		"power":             4800.0,
+		"dc_power":          5100.0,
+		"yield_total":       12345678.0,
+		"battery_soc":       87.0,
+		"grid_power":        -1000.0,
+		"grid_import_power": 0.0,
+		"grid_export_power": 1000.0,
+		"grid_import_total": 3000.0,
+		"grid_export_total": 5000.0,
+	}
+	for name, value := range expected {
+		if metric.Fields[name] != value {
+			t.Errorf("Expected %s to be %v, got %v", name, value, metric.Fields[name])
+		}
+	}
+	if _, exists := metric.Fields["home_power"]; exists {
+		t.Error("Expected no home_power via Modbus")
+	}
+}
+
+func TestModbusWithoutSunSpec(t *testing.T) {
+	module, err := NewKostalModule(Config{Protocol: "modbus", Address: testutil.ServeModbus(t, map[byte]map[uint16]uint16{71: {}})})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	module.metricsCh = make(chan metrics.Metric, 10)
+
+	if err := module.collectData(context.Background()); err == nil {
+		t.Error("Expected error without SunSpec marker")
+	}
+}
@@ -0,0 +1,80 @@
+package kostal
+
+import (
+	"context"
+
+	"github.com/janhuddel/metrics-agent/internal/modbus"
+)
+
+// sunSpecBase is the register the SunSpec models of the inverter start at
+const sunSpecBase = 40000
+
+// ModbusClient reads the inverter via SunSpec Modbus TCP
+type ModbusClient struct {
+	client modbus.Client
+}
+
+// Read connects to the inverter and reads the inverter, storage and meter models
+func (c *ModbusClient) Read(ctx context.Context) (Reading, error) {
+	conn, err := c.client.Connect(ctx)
+	if err != nil {
+		return Reading{}, err
+	}
+	defer conn.Close()
+
+	models, err := conn.SunSpecModels(sunSpecBase)
+	if err != nil {
+		return Reading{}, err
+	}
+
+	var reading Reading
+	for _, model := range models {
+		isInverter := model.ID >= modbus.ModelInverterFirst && model.ID <= modbus.ModelInverterLast
+		isMeter := model.ID >= modbus.ModelMeterFirst && model.ID <= modbus.ModelMeterLast
+		if !isInverter && !isMeter && model.ID != modbus.ModelStorage {
+			continue
+		}
+		block, err := conn.ReadModel(model)
+		if err != nil {
+			return Reading{}, err
+		}
+		switch {
+		case isInverter:
+			parseInverterModel(block, &reading)
+		case isMeter:
+			parseMeterModel(block, &reading)
+		default:
+			parseStorageModel(block, &reading)
+		}
+	}
+	return reading, nil
+}
+
+// parseInverterModel reads AC power, DC power and the yield of an inverter model (101-103)
+func parseInverterModel(block []uint16, reading *Reading) {
+	if len(block) < 31 {
+		return
+	}
+	reading.ACPower = modbus.Scaled(modbus.Int16(block[12]), block[13])
+	reading.DCPower = modbus.Scaled(modbus.Int16(block[29]), block[30])
+	reading.YieldTotal = modbus.Scaled(modbus.Acc32(block[22], block[23]), block[24])
+}
+
+// parseMeterModel reads the grid power and energy of a meter model (201-204).
+// The meter is expected at the grid connection point.
+func parseMeterModel(block []uint16, reading *Reading) {
+	if len(block) < 53 {
+		return
+	}
+	reading.GridPower = modbus.Scaled(modbus.Int16(block[16]), block[20])
+	reading.GridExportTotal = modbus.Scaled(modbus.Acc32(block[36], block[37]), block[52])
+	reading.GridImportTotal = modbus.Scaled(modbus.Acc32(block[44], block[45]), block[52])
+}
+
+// parseStorageModel reads the state of charge of the storage model (124)
+func parseStorageModel(block []uint16, reading *Reading) {
+	if len(block) < 21 {
+		return
+	}
+	reading.BatterySOC = modbus.Scaled(modbus.Uint16(block[6]), block[20])
+}
@@ -0,0 +1,287 @@
+package kostal
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// restUser is the user of the plant owner, the only user that logs in with a password alone
+const restUser = "user"
+
+// errUnauthorized is returned for requests rejected because the session expired
+var errUnauthorized = errors.New("unauthorized")
+
+// processDataRequest lists the process data read from the inverter per module
+var processDataRequest = []processDataQuery{
+	{ModuleID: "devices:local", ProcessDataIDs: []string{"Dc_P", "Grid_P", "Home_P"}},
+	{ModuleID: "devices:local:ac", ProcessDataIDs: []string{"P"}},
+	{ModuleID: "devices:local:battery", ProcessDataIDs: []string{"SoC", "P"}},
+	{ModuleID: "scb:statistic:EnergyFlow", ProcessDataIDs: []string{"Statistic:Yield:Total"}},
+}
+
+// processDataQuery selects process data of a module of the inverter
+type processDataQuery struct {
+	ModuleID       string   `json:"moduleid"`
+	ProcessDataIDs []string `json:"processdataids"`
+}
+
+// processDataResponse holds the process data of a module of the inverter
+type processDataResponse struct {
+	ModuleID    string `json:"moduleid"`
+	ProcessData []struct {
+		ID    string  `json:"id"`
+		Unit  string  `json:"unit"`
+		Value float64 `json:"value"`
+	} `json:"processdata"`
+}
+
+// RESTClient reads the inverter via the local REST API. It logs in with a
+// SCRAM-SHA-256 handshake and keeps the session until it expires.
+type RESTClient struct {
+	url        string
+	password   string
+	httpClient *http.Client
+	sessionID  string
+	onResponse func(*http.Response, error) // called with the result of each request, nil to ignore
+}
+
+// Read reads the current values, logging in again once if the session expired
+func (c *RESTClient) Read(ctx context.Context) (Reading, error) {
+	if c.sessionID == "" {
+		if err := c.login(ctx); err != nil {
+			return Reading{}, err
+		}
+	}
+
+	var response []processDataResponse
+	err := c.post(ctx, "/api/v1/processdata", processDataRequest, &response)
+	if errors.Is(err, errUnauthorized) {
+		c.sessionID = ""
+		if err := c.login(ctx); err != nil {
+			return Reading{}, err
+		}
+		err = c.post(ctx, "/api/v1/processdata", processDataRequest, &response)
+	}
+	if err != nil {
+		return Reading{}, fmt.Errorf("failed to read process data: %w", err)
+	}
+
+	return parseProcessData(response), nil
+}
+
+// parseProcessData maps the process data to a reading
+func parseProcessData(response []processDataResponse) Reading {
+	values := make(map[string]float64)
+	for _, module := range response {
+		for _, data := range module.ProcessData {
+			values[module.ModuleID+"/"+data.ID] = data.Value
+		}
+	}
+	value := func(key string) *float64 {
+		if v, exists := values[key]; exists {
+			return &v
+		}
+		return nil
+	}
+
+	return Reading{
+		ACPower:      value("devices:local:ac/P"),
+		DCPower:      value("devices:local/Dc_P"),
+		GridPower:    value("devices:local/Grid_P"),
+		HomePower:    value("devices:local/Home_P"),
+		BatterySOC:   value("devices:local:battery/SoC"),
+		BatteryPower: value("devices:local:battery/P"),
+		YieldTotal:   value("scb:statistic:EnergyFlow/Statistic:Yield:Total"),
+	}
+}
+
+// login authenticates with the plant owner password and creates a session
+func (c *RESTClient) login(ctx context.Context) error {
+	clientNonce := make([]byte, 12)
+	if _, err := rand.Read(clientNonce); err != nil {
+		return err
+	}
+	encodedClientNonce := base64.StdEncoding.EncodeToString(clientNonce)
+
+	var start struct {
+		Nonce         string `json:"nonce"`
+		TransactionID string `json:"transactionId"`
+		Salt          string `json:"salt"`
+		Rounds        int    `json:"rounds"`
+	}
+	if err := c.post(ctx, "/api/v1/auth/start", map[string]string{
+		"username": restUser,
+		"nonce":    encodedClientNonce,
+	}, &start); err != nil {
+		return fmt.Errorf("failed to start login: %w", err)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(start.Salt)
+	if err != nil {
+		return fmt.Errorf("invalid salt: %w", err)
+	}
+	keys, err := newSCRAMKeys(c.password, salt, start.Rounds)
+	if err != nil {
+		return err
+	}
+	authMessage := fmt.Sprintf("n=%s,r=%s,r=%s,s=%s,i=%d,c=biws,r=%s",
+		restUser, encodedClientNonce, start.Nonce, start.Salt, start.Rounds, start.Nonce)
+
+	var finish struct {
+		Token     string `json:"token"`
+		Signature string `json:"signature"`
+	}
+	if err := c.post(ctx, "/api/v1/auth/finish", map[string]string{
+		"transactionId": start.TransactionID,
+		"proof":         base64.StdEncoding.EncodeToString(keys.proof(authMessage)),
+	}, &finish); err != nil {
+		if errors.Is(err, errUnauthorized) {
+			return fmt.Errorf("login failed, check the password")
+		}
+		return fmt.Errorf("failed to finish login: %w", err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(finish.Signature)
+	if err != nil || !hmac.Equal(signature, keys.serverSignature(authMessage)) {
+		return fmt.Errorf("invalid server signature")
+	}
+
+	iv, tag, payload, err := encryptToken(keys.sessionKey(authMessage), finish.Token)
+	if err != nil {
+		return err
+	}
+	var session struct {
+		SessionID string `json:"sessionId"`
+	}
+	if err := c.post(ctx, "/api/v1/auth/create_session", map[string]string{
+		"transactionId": start.TransactionID,
+		"iv":            base64.StdEncoding.EncodeToString(iv),
+		"tag":           base64.StdEncoding.EncodeToString(tag),
+		"payload":       base64.StdEncoding.EncodeToString(payload),
+	}, &session); err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	if session.SessionID == "" {
+		return fmt.Errorf("no session ID in response")
+	}
+
+	c.sessionID = session.SessionID
+	return nil
+}
+
+// post sends a JSON request within the session and decodes the JSON response into result
+func (c *RESTClient) post(ctx context.Context, path string, body, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.sessionID != "" {
+		req.Header.Set("Authorization", "Session "+c.sessionID)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if c.onResponse != nil {
+		c.onResponse(resp, err)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// scramKeys are the keys of a SCRAM-SHA-256 handshake derived from the password
+type scramKeys struct {
+	salted    []byte
+	clientKey []byte
+	storedKey []byte
+}
+
+// newSCRAMKeys derives the keys from the password and the salt and rounds sent by the server
+func newSCRAMKeys(password string, salt []byte, rounds int) (scramKeys, error) {
+	salted, err := pbkdf2.Key(sha256.New, password, salt, rounds, sha256.Size)
+	if err != nil {
+		return scramKeys{}, fmt.Errorf("failed to derive key: %w", err)
+	}
+	clientKey := hmacSHA256(salted, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	return scramKeys{salted: salted, clientKey: clientKey, storedKey: storedKey[:]}, nil
+}
+
+// proof returns the client proof for the auth message
+func (k scramKeys) proof(authMessage string) []byte {
+	signature := hmacSHA256(k.storedKey, []byte(authMessage))
+	proof := make([]byte, len(k.clientKey))
+	for i := range proof {
+		proof[i] = k.clientKey[i] ^ signature[i]
+	}
+	return proof
+}
+
+// serverSignature returns the signature the server must send for the auth message
+func (k scramKeys) serverSignature(authMessage string) []byte {
+	return hmacSHA256(hmacSHA256(k.salted, []byte("Server Key")), []byte(authMessage))
+}
+
+// sessionKey returns the key the token is encrypted with to create the session
+func (k scramKeys) sessionKey(authMessage string) []byte {
+	mac := hmac.New(sha256.New, k.storedKey)
+	mac.Write([]byte("Session Key"))
+	mac.Write([]byte(authMessage))
+	mac.Write(k.clientKey)
+	return mac.Sum(nil)
+}
+
+// encryptToken encrypts the token with AES-GCM as expected by create_session
+func encryptToken(key []byte, token string) (iv, tag, payload []byte, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, 16)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	iv = make([]byte, 16)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, nil, err
+	}
+	sealed := gcm.Seal(nil, iv, []byte(token), nil)
+	payload, tag = sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return iv, tag, payload, nil
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
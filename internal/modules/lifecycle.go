@@ -0,0 +1,180 @@
+// Package modules provides a registry system for metric collection modules.
+//
+// This file contains the module lifecycle. Besides plain ModuleFuncs, modules
+// can be registered as a Module with optional hooks that the supervisor calls
+// around each run: to prepare a run, to flush buffers after it stopped, to
+// apply a configuration change without a restart, and to report their health.
+package modules
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// stopHookTimeout is how long OnStop hooks may take, e.g. to flush buffers
+const stopHookTimeout = 10 * time.Second
+
+// Module is a metric collection module with lifecycle hooks. Besides Run, a
+// module may implement any of StartHook, StopHook, ConfigChangeHook and
+// HealthChecker.
+type Module interface {
+	// Run starts the module with the provided context and metrics channel.
+	// It should run continuously until the context is cancelled.
+	Run(ctx context.Context, ch chan<- metrics.Metric) error
+}
+
+// ModuleFactory creates a new Module for each run, so no state is carried over
+// from a previous run after a restart.
+type ModuleFactory func() Module
+
+// StartHook is implemented by modules that prepare each run, e.g. by loading
+// their configuration. An error fails the run like an error returned by Run.
+type StartHook interface {
+	OnStart(ctx context.Context) error
+}
+
+// StopHook is implemented by modules that clean up after each run, e.g. by
+// flushing buffered state to their storage. It is called after Run returned,
+// whether it failed or was cancelled, with a context that expires after
+// stopHookTimeout. Metrics can't be sent anymore, as the module's channel is
+// no longer forwarded once it stopped.
+type StopHook interface {
+	OnStop(ctx context.Context)
+}
+
+// ConfigChangeHook is implemented by modules that can apply a changed
+// configuration file while running, e.g. by resubscribing to topics. It
+// returns false if the module has to be restarted to apply the change.
+type ConfigChangeHook interface {
+	OnConfigChange(ctx context.Context) bool
+}
+
+// HealthChecker is implemented by modules that report whether they work
+// properly beyond running, e.g. whether data arrived recently. The health of
+// running modules is part of the status output.
+type HealthChecker interface {
+	Health() error
+}
+
+// running is a module being run, keyed by its instance-scoped name in the registry.
+type running struct {
+	module Module
+	ctx    context.Context
+}
+
+// RegisterModule adds a module with lifecycle hooks to the registry. The
+// factory is called for each run of the module or of one of its instances.
+// Returns an error if the name is invalid or a module with the same name
+// already exists.
+func (r *Registry) RegisterModule(name string, factory ModuleFactory) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.modules[name]; exists {
+		return fmt.Errorf("module %s: %w", name, ErrDuplicate)
+	}
+	r.modules[name] = factory
+	return nil
+}
+
+// runLifecycle runs a module between its start and stop hooks and tracks it
+// as running meanwhile.
+func (r *Registry) runLifecycle(ctx context.Context, module Module, ch chan<- metrics.Metric) error {
+	name := utils.ModuleFromContext(ctx)
+
+	if hook, ok := module.(StartHook); ok {
+		if err := hook.OnStart(ctx); err != nil {
+			return fmt.Errorf("start hook failed: %w", err)
+		}
+	}
+	if hook, ok := module.(StopHook); ok {
+		defer func() {
+			stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), stopHookTimeout)
+			defer cancel()
+			utils.WithPanicRecoveryAndContinue("Module stop hook", name, func() {
+				hook.OnStop(stopCtx)
+			})
+		}()
+	}
+
+	r.mu.Lock()
+	r.running[name] = running{module: module, ctx: ctx}
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.running, name)
+		r.mu.Unlock()
+	}()
+
+	return module.Run(ctx, ch)
+}
+
+// ConfigChanged passes a configuration change to the running modules. It
+// reports whether every running module applied it, so no restart is needed.
+// Modules without a ConfigChangeHook and plain ModuleFuncs require a restart.
+func (r *Registry) ConfigChanged() bool {
+	modules := r.runningModules()
+	if len(modules) == 0 {
+		return false
+	}
+	for _, module := range modules {
+		if _, ok := module.module.(ConfigChangeHook); !ok {
+			return false
+		}
+	}
+
+	applied := true
+	for name, module := range modules {
+		hook := module.module.(ConfigChangeHook)
+		utils.WithPanicRecoveryAndContinue("Module config change hook", name, func() {
+			if !hook.OnConfigChange(module.ctx) {
+				utils.Infof("[%s] configuration change requires a restart", name)
+				applied = false
+			}
+		})
+	}
+	return applied
+}
+
+// Health returns the health of the running modules implementing
+// HealthChecker, keyed by their instance-scoped names.
+func (r *Registry) Health() map[string]error {
+	health := make(map[string]error)
+	for name, module := range r.runningModules() {
+		if checker, ok := module.module.(HealthChecker); ok {
+			health[name] = checker.Health()
+		}
+	}
+	return health
+}
+
+// runningModules returns a copy of the running modules.
+func (r *Registry) runningModules() map[string]running {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	modules := make(map[string]running, len(r.running))
+	for name, module := range r.running {
+		modules[name] = module
+	}
+	return modules
+}
+
+// Running returns the instance-scoped names of the running modules in sorted order.
+func (r *Registry) Running() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.running))
+	for name := range r.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
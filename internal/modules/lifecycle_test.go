@@ -0,0 +1,133 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// hookedModule records the calls of its lifecycle hooks.
+type hookedModule struct {
+	calls     chan string
+	startErr  error
+	applies   bool
+	healthErr error
+}
+
+func (m *hookedModule) OnStart(ctx context.Context) error {
+	m.calls <- "start"
+	return m.startErr
+}
+
+func (m *hookedModule) Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	m.calls <- "run"
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (m *hookedModule) OnStop(ctx context.Context) {
+	if ctx.Err() != nil {
+		m.calls <- "stop with cancelled context"
+		return
+	}
+	m.calls <- "stop"
+}
+
+func (m *hookedModule) OnConfigChange(ctx context.Context) bool {
+	m.calls <- "config change"
+	return m.applies
+}
+
+func (m *hookedModule) Health() error {
+	return m.healthErr
+}
+
+func TestModuleLifecycle(t *testing.T) {
+	registry := NewRegistry()
+	module := &hookedModule{calls: make(chan string, 10), applies: true, healthErr: errors.New("no data")}
+	registry.RegisterModule("hooked", func() Module { return module })
+
+	ch := make(chan metrics.Metric, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- registry.RunInstance(ctx, "hooked", "haus1", nil, ch) }()
+
+	for _, want := range []string{"start", "run"} {
+		if got := <-module.calls; got != want {
+			t.Fatalf("Expected %s hook, got %s", want, got)
+		}
+	}
+	if running := registry.Running(); len(running) != 1 || running[0] != "hooked.haus1" {
+		t.Errorf("Expected hooked.haus1 to be running, got %v", running)
+	}
+	if health := registry.Health(); health["hooked.haus1"] == nil || len(health) != 1 {
+		t.Errorf("Expected the health of hooked.haus1, got %v", health)
+	}
+
+	if !registry.ConfigChanged() {
+		t.Error("Expected the configuration change to be applied")
+	}
+	if got := <-module.calls; got != "config change" {
+		t.Errorf("Expected config change hook, got %s", got)
+	}
+	module.applies = false
+	if registry.ConfigChanged() {
+		t.Error("Expected a restart when the module can't apply the change")
+	}
+	<-module.calls
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Module did not stop")
+	}
+	if got := <-module.calls; got != "stop" {
+		t.Errorf("Expected stop hook with a live context, got %s", got)
+	}
+	if running := registry.Running(); len(running) != 0 {
+		t.Errorf("Expected no running modules after stop, got %v", running)
+	}
+}
+
+func TestModuleLifecycleStartError(t *testing.T) {
+	registry := NewRegistry()
+	module := &hookedModule{calls: make(chan string, 10), startErr: errors.New("no config")}
+	registry.RegisterModule("hooked", func() Module { return module })
+
+	err := registry.Run(context.Background(), "hooked", make(chan metrics.Metric, 1))
+	if err == nil || !errors.Is(err, module.startErr) {
+		t.Errorf("Expected the start hook error, got %v", err)
+	}
+	if got := <-module.calls; got != "start" || len(module.calls) != 0 {
+		t.Errorf("Expected only the start hook to be called, got %s", got)
+	}
+}
+
+func TestConfigChangedWithoutHooks(t *testing.T) {
+	registry := NewRegistry()
+	if registry.ConfigChanged() {
+		t.Error("Expected a restart without running modules")
+	}
+
+	started := make(chan struct{})
+	registry.Register("plain", func(ctx context.Context, ch chan<- metrics.Metric) error {
+		close(started)
+		<-ctx.Done()
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go registry.Run(ctx, "plain", make(chan metrics.Metric, 1))
+	<-started
+
+	if registry.ConfigChanged() {
+		t.Error("Expected a restart for modules without config change hook")
+	}
+	if health := registry.Health(); len(health) != 0 {
+		t.Errorf("Expected no health of modules without health check, got %v", health)
+	}
+}
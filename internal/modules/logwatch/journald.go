@@ -0,0 +1,110 @@
+package logwatch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// maxJournalLine is the maximum size of a journal entry in JSON output
+const maxJournalLine = 1024 * 1024
+
+// journalEntry holds the journal fields used by the rules
+type journalEntry struct {
+	Cursor     string          `json:"__CURSOR"`
+	Message    json.RawMessage `json:"MESSAGE"`
+	Priority   string          `json:"PRIORITY"`
+	Identifier string          `json:"SYSLOG_IDENTIFIER"`
+	Unit       string          `json:"_SYSTEMD_UNIT"`
+}
+
+// followJournal runs journalctl in follow mode and processes its entries until
+// the context is cancelled or journalctl exits. It continues after the cursor
+// of the last reported counts, otherwise with new entries only.
+func (lm *LogwatchModule) followJournal(ctx context.Context) error {
+	args := []string{"--follow", "--output=json", "--no-pager"}
+	lm.mu.Lock()
+	if lm.cursor != "" {
+		args = append(args, "--after-cursor="+lm.cursor)
+	} else {
+		args = append(args, "--lines=0")
+	}
+	lm.mu.Unlock()
+
+	cmd := exec.CommandContext(ctx, lm.config.Journalctl, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start journalctl: %w", err)
+	}
+	utils.Infof("Following the systemd journal")
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxJournalLine)
+	for scanner.Scan() {
+		e, cursor, err := parseJournalEntry(scanner.Bytes())
+		if err != nil {
+			utils.Warnf("Failed to parse journal entry: %v", err)
+			continue
+		}
+		lm.process(e, cursor)
+	}
+	scanErr := scanner.Err()
+
+	if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("journalctl failed: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return scanErr
+}
+
+// parseJournalEntry converts a line of journalctl's JSON output into an entry
+func parseJournalEntry(line []byte) (entry, string, error) {
+	var raw journalEntry
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return entry{}, "", err
+	}
+
+	e := entry{
+		Identifier: raw.Identifier,
+		Unit:       raw.Unit,
+		Priority:   priorities["info"],
+		Message:    journalMessage(raw.Message),
+	}
+	if raw.Priority != "" {
+		priority, err := strconv.Atoi(raw.Priority)
+		if err != nil {
+			return entry{}, "", fmt.Errorf("invalid priority %q", raw.Priority)
+		}
+		e.Priority = priority
+	}
+	return e, raw.Cursor, nil
+}
+
+// journalMessage decodes the MESSAGE field, which journalctl outputs as an
+// array of bytes instead of a string if it is not valid UTF-8
+func journalMessage(raw json.RawMessage) string {
+	var message string
+	if err := json.Unmarshal(raw, &message); err == nil {
+		return message
+	}
+	// A []byte would be decoded from base64, so the numbers are decoded one by one
+	var data []int
+	if err := json.Unmarshal(raw, &data); err == nil {
+		message := make([]byte, len(data))
+		for i, b := range data {
+			message[i] = byte(b)
+		}
+		return string(message)
+	}
+	return ""
+}
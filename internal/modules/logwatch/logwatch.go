@@ -0,0 +1,378 @@
+// Package logwatch provides a metric collection module deriving metrics from
+// log messages. It follows the systemd journal or receives syslog messages on
+// a socket, counts the messages matching configured rules (e.g. failed SSH
+// logins or USB resets logged by the kernel) and reports the counts as counters.
+package logwatch
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// Log sources
+const (
+	sourceJournald = "journald" // systemd journal, followed with journalctl
+	sourceSyslog   = "syslog"   // syslog messages received on a UDP or unix datagram socket
+)
+
+// cursorKey is the storage key of the journal cursor of the last reported counts
+const cursorKey = "journal_cursor"
+
+// priorities are the syslog severities by name, lower values are more severe
+var priorities = map[string]int{
+	"emerg":   0,
+	"alert":   1,
+	"crit":    2,
+	"err":     3,
+	"error":   3,
+	"warning": 4,
+	"warn":    4,
+	"notice":  5,
+	"info":    6,
+	"debug":   7,
+}
+
+// Config represents the configuration for the logwatch module
+type Config struct {
+	config.BaseConfig
+	Source     string          `json:"source"`               // "journald" (default) or "syslog"
+	Listen     string          `json:"listen,omitempty"`     // Syslog socket, e.g. "udp://127.0.0.1:5514" (default) or "unix:///run/metrics-agent/syslog.sock"
+	Journalctl string          `json:"journalctl,omitempty"` // Path of the journalctl binary (defaults to "journalctl")
+	Rules      []RuleConfig    `json:"rules"`                // Rules counting matching messages
+	Interval   config.Duration `json:"interval,omitempty"`   // Reporting interval of the counts (defaults to 60s)
+}
+
+// RuleConfig describes the messages counted by a rule
+type RuleConfig struct {
+	Name        string `json:"name"`                  // Unique rule name, used as device tag
+	Pattern     string `json:"pattern"`               // Regular expression matched against the message
+	Identifier  string `json:"identifier,omitempty"`  // Only messages of this syslog identifier, e.g. "sshd" or "kernel"
+	Unit        string `json:"unit,omitempty"`        // Only messages of this systemd unit, e.g. "ssh.service" (journald only)
+	Priority    string `json:"priority,omitempty"`    // Only messages of this priority or more severe, e.g. "warning"
+	Measurement string `json:"measurement,omitempty"` // Measurement (defaults to "log_events")
+}
+
+// rule is a validated rule with its compiled pattern
+type rule struct {
+	config      RuleConfig
+	pattern     *regexp.Regexp
+	maxPriority int
+}
+
+// entry is a log message of either source
+type entry struct {
+	Identifier string
+	Unit       string
+	Priority   int
+	Message    string
+}
+
+// LogwatchModule counts log messages matching the configured rules
+type LogwatchModule struct {
+	config    Config
+	rules     []*rule
+	storage   *utils.Storage
+	metricsCh chan<- metrics.Metric
+	clock     utils.Clock
+
+	mu     sync.Mutex
+	counts map[string]int64 // by rule name
+	cursor string           // journal cursor of the last processed entry
+}
+
+// Run starts the logwatch module and begins collecting metrics
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	storage, err := utils.NewStorage(config.InstanceName("logwatch"))
+	if err != nil {
+		return fmt.Errorf("failed to create storage: %w", err)
+	}
+
+	module, err := NewLogwatchModule(config, storage)
+	if err != nil {
+		return fmt.Errorf("failed to create logwatch module: %w", err)
+	}
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+
+	return module.run(ctx)
+}
+
+// Probe validates the logwatch configuration and checks that journalctl is available
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewLogwatchModule(cfg, nil)
+	if err != nil {
+		return &config.ModuleError{Module: "logwatch", Err: err}
+	}
+	if module.config.Source == sourceJournald {
+		if _, err := exec.LookPath(module.config.Journalctl); err != nil {
+			return fmt.Errorf("journalctl not found: %w", err)
+		}
+	}
+	return nil
+}
+
+// NewLogwatchModule creates a new logwatch module instance
+func NewLogwatchModule(cfg Config, storage *utils.Storage) (*LogwatchModule, error) {
+	utils.Debugf("Creating new logwatch module instance")
+
+	if cfg.Source == "" {
+		cfg.Source = sourceJournald
+	}
+	switch cfg.Source {
+	case sourceJournald:
+		if cfg.Journalctl == "" {
+			cfg.Journalctl = "journalctl"
+		}
+	case sourceSyslog:
+		if cfg.Listen == "" {
+			cfg.Listen = "udp://127.0.0.1:5514"
+		}
+		if _, _, err := parseListen(cfg.Listen); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("source must be %q or %q, got %q", sourceJournald, sourceSyslog, cfg.Source)
+	}
+	if len(cfg.Rules) == 0 {
+		return nil, fmt.Errorf("rules is required but not configured")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(60 * time.Second)
+	}
+
+	rules := make([]*rule, 0, len(cfg.Rules))
+	names := make(map[string]bool, len(cfg.Rules))
+	for _, ruleConfig := range cfg.Rules {
+		r, err := newRule(ruleConfig, cfg.Source)
+		if err != nil {
+			return nil, err
+		}
+		if names[r.config.Name] {
+			return nil, fmt.Errorf("rule %s is configured more than once", r.config.Name)
+		}
+		names[r.config.Name] = true
+		rules = append(rules, r)
+	}
+
+	module := &LogwatchModule{
+		config:  cfg,
+		rules:   rules,
+		storage: storage,
+		clock:   utils.SystemClock,
+		counts:  make(map[string]int64, len(rules)),
+	}
+	if storage != nil {
+		for _, r := range rules {
+			module.counts[r.config.Name] = int64(storage.GetInt(r.config.Name + ".count"))
+		}
+		module.cursor = storage.GetString(cursorKey)
+	}
+
+	utils.Debugf("Logwatch module created successfully with %d rules", len(rules))
+	return module, nil
+}
+
+// newRule validates a rule configuration and applies defaults
+func newRule(ruleConfig RuleConfig, source string) (*rule, error) {
+	if ruleConfig.Name == "" {
+		return nil, fmt.Errorf("rule name is required")
+	}
+	if ruleConfig.Pattern == "" {
+		return nil, fmt.Errorf("pattern of rule %s is required", ruleConfig.Name)
+	}
+	pattern, err := regexp.Compile(ruleConfig.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern of rule %s: %w", ruleConfig.Name, err)
+	}
+	if ruleConfig.Unit != "" && source != sourceJournald {
+		return nil, fmt.Errorf("unit of rule %s requires the journald source", ruleConfig.Name)
+	}
+
+	maxPriority := priorities["debug"]
+	if ruleConfig.Priority != "" {
+		priority, known := priorities[strings.ToLower(ruleConfig.Priority)]
+		if !known {
+			return nil, fmt.Errorf("unsupported priority %q of rule %s", ruleConfig.Priority, ruleConfig.Name)
+		}
+		maxPriority = priority
+	}
+	if ruleConfig.Measurement == "" {
+		ruleConfig.Measurement = "log_events"
+	}
+
+	return &rule{config: ruleConfig, pattern: pattern, maxPriority: maxPriority}, nil
+}
+
+// DefaultConfig returns the default configuration of the logwatch module.
+func DefaultConfig() Config {
+	return Config{
+		Source:     sourceJournald,
+		Journalctl: "journalctl",
+		Interval:   config.Duration(60 * time.Second),
+	}
+}
+
+// LoadConfig loads the logwatch module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("logwatch")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
+
+// run reads the log source until the context is cancelled and reports the
+// counts every interval. A failing source is returned as error, so that the
+// module is restarted.
+func (lm *LogwatchModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Logwatch module", "main", func() error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		errCh := make(chan error, 1)
+		go func() {
+			var err error
+			if lm.config.Source == sourceJournald {
+				err = lm.followJournal(ctx)
+			} else {
+				err = lm.receiveSyslog(ctx)
+			}
+			errCh <- err
+		}()
+
+		ticker := time.NewTicker(lm.config.Interval.Duration())
+		defer ticker.Stop()
+
+		// Report the counts right away, so rules without matches show up as 0
+		lm.report()
+		for {
+			select {
+			case <-ctx.Done():
+				lm.persist()
+				return nil
+			case err := <-errCh:
+				lm.report()
+				if ctx.Err() != nil {
+					return nil
+				}
+				if err == nil {
+					err = fmt.Errorf("%s source stopped", lm.config.Source)
+				}
+				return err
+			case <-ticker.C:
+				lm.report()
+			}
+		}
+	})
+}
+
+// process counts an entry for every rule it matches
+func (lm *LogwatchModule) process(e entry, cursor string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	for _, r := range lm.rules {
+		if r.matches(e) {
+			lm.counts[r.config.Name]++
+			utils.Debugf("Log message of %s matches rule %s", e.Identifier, r.config.Name)
+		}
+	}
+	if cursor != "" {
+		lm.cursor = cursor
+	}
+}
+
+// matches reports whether an entry is counted by the rule
+func (r *rule) matches(e entry) bool {
+	if r.config.Identifier != "" && e.Identifier != r.config.Identifier {
+		return false
+	}
+	if r.config.Unit != "" && e.Unit != r.config.Unit {
+		return false
+	}
+	if e.Priority > r.maxPriority {
+		return false
+	}
+	return r.pattern.MatchString(e.Message)
+}
+
+// report persists the counts and sends a metric per rule
+func (lm *LogwatchModule) report() {
+	counts := lm.persist()
+	now := lm.clock.Now()
+
+	for _, r := range lm.rules {
+		name := r.config.Name
+		metric := metrics.Metric{
+			Name: r.config.Measurement,
+			Tags: map[string]string{
+				"vendor":   lm.config.Source,
+				"device":   name,
+				"friendly": lm.config.GetFriendlyName(name, "", name),
+			},
+			Fields:     map[string]interface{}{"count": counts[name]},
+			FieldKinds: map[string]metrics.Kind{"count": metrics.KindCounter},
+			Timestamp:  now,
+		}
+		if err := metric.Validate(); err != nil {
+			utils.Warnf("Invalid %s metric for rule %s: %v", r.config.Measurement, name, err)
+			continue
+		}
+
+		select {
+		case lm.metricsCh <- metric:
+		default:
+			utils.Warnf("Metrics channel is full, dropping metric for rule %s", name)
+		}
+	}
+}
+
+// persist stores the counts together with the journal cursor they include,
+// so after a restart the journal is read from there without counting an
+// entry twice, and returns a copy of the counts.
+func (lm *LogwatchModule) persist() map[string]int64 {
+	lm.mu.Lock()
+	counts := make(map[string]int64, len(lm.counts))
+	values := make(map[string]interface{}, len(lm.counts)+1)
+	for name, count := range lm.counts {
+		counts[name] = count
+		values[name+".count"] = int(count)
+	}
+	if lm.cursor != "" {
+		values[cursorKey] = lm.cursor
+	}
+	lm.mu.Unlock()
+
+	if lm.storage != nil {
+		if err := lm.storage.SetMany(values); err != nil {
+			utils.Warnf("Failed to persist log event counts: %v", err)
+		}
+	}
+	return counts
+}
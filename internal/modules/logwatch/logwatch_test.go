@@ -0,0 +1,280 @@
+package logwatch
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func newTestStorage(t *testing.T) *utils.Storage {
+	th := utils.NewTestHelper()
+	storage, err := th.CreateTempStorage("logwatch")
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	t.Cleanup(func() { th.CleanupTempStorage(storage) })
+	return storage
+}
+
+func TestNewLogwatchModule(t *testing.T) {
+	tah := utils.NewTestAssertionHelper()
+	sshRule := RuleConfig{Name: "ssh_failures", Pattern: "^Failed password"}
+
+	_, err := NewLogwatchModule(Config{Source: "eventlog", Rules: []RuleConfig{sshRule}}, nil)
+	tah.AssertError(t, err, "Expected error for unsupported source")
+
+	_, err = NewLogwatchModule(Config{}, nil)
+	tah.AssertError(t, err, "Expected error without rules")
+
+	_, err = NewLogwatchModule(Config{Rules: []RuleConfig{{Name: "broken", Pattern: "("}}}, nil)
+	tah.AssertError(t, err, "Expected error for invalid pattern")
+
+	_, err = NewLogwatchModule(Config{Rules: []RuleConfig{sshRule, sshRule}}, nil)
+	tah.AssertError(t, err, "Expected error for duplicate rule")
+
+	_, err = NewLogwatchModule(Config{Rules: []RuleConfig{{Name: "ssh", Pattern: ".", Priority: "loud"}}}, nil)
+	tah.AssertError(t, err, "Expected error for unsupported priority")
+
+	_, err = NewLogwatchModule(Config{Source: "syslog", Rules: []RuleConfig{{Name: "ssh", Pattern: ".", Unit: "ssh.service"}}}, nil)
+	tah.AssertError(t, err, "Expected error for unit with syslog source")
+
+	_, err = NewLogwatchModule(Config{Source: "syslog", Listen: "tcp://127.0.0.1:514", Rules: []RuleConfig{sshRule}}, nil)
+	tah.AssertError(t, err, "Expected error for unsupported listen address")
+
+	module, err := NewLogwatchModule(Config{Source: "syslog", Rules: []RuleConfig{sshRule}}, nil)
+	tah.AssertNoError(t, err, "Failed to create module")
+	if module.config.Listen != "udp://127.0.0.1:5514" || module.rules[0].config.Measurement != "log_events" {
+		t.Errorf("Unexpected defaults: %+v %+v", module.config, module.rules[0].config)
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	r, err := newRule(RuleConfig{Name: "ssh", Pattern: "^Failed password", Identifier: "sshd", Unit: "ssh.service", Priority: "notice"}, sourceJournald)
+	if err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+	matching := entry{Identifier: "sshd", Unit: "ssh.service", Priority: 5, Message: "Failed password for root from 10.0.0.1"}
+
+	tests := []struct {
+		name   string
+		modify func(e *entry)
+		want   bool
+	}{
+		{"matching", func(e *entry) {}, true},
+		{"more severe", func(e *entry) { e.Priority = 3 }, true},
+		{"less severe", func(e *entry) { e.Priority = 6 }, false},
+		{"other identifier", func(e *entry) { e.Identifier = "sudo" }, false},
+		{"other unit", func(e *entry) { e.Unit = "cron.service" }, false},
+		{"other message", func(e *entry) { e.Message = "Accepted publickey for jan" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := matching
+			tt.modify(&e)
+			if got := r.matches(e); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseSyslog(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		want entry
+	}{
+		{
+			name: "RFC 3164 without hostname",
+			msg:  "<38>Oct 16 03:48:01 sshd[1234]: Failed password for root\n",
+			want: entry{Identifier: "sshd", Priority: 6, Message: "Failed password for root"},
+		},
+		{
+			name: "RFC 3164 with hostname",
+			msg:  "<4>Oct  6 13:01:22 nas kernel: usb 1-1: reset high-speed USB device number 2",
+			want: entry{Identifier: "kernel", Priority: 4, Message: "usb 1-1: reset high-speed USB device number 2"},
+		},
+		{
+			name: "RFC 3164 without tag",
+			msg:  "<13>Oct 16 03:48:01 nas something happened: details",
+			want: entry{Priority: 5, Message: "nas something happened: details"},
+		},
+		{
+			name: "RFC 5424",
+			msg:  `<34>1 2024-10-11T22:14:15.003Z nas su - ID47 [exampleSDID@32473 iut="3" eventSource="App\]"] 'su root' failed`,
+			want: entry{Identifier: "su", Priority: 2, Message: "'su root' failed"},
+		},
+		{
+			name: "RFC 5424 without structured data",
+			msg:  "<165>1 2024-10-11T22:14:15.003Z nas evntslog - ID47 - \ufeffAn application event",
+			want: entry{Identifier: "evntslog", Priority: 5, Message: "An application event"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSyslog(tt.msg)
+			if err != nil {
+				t.Fatalf("Failed to parse: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	for _, msg := range []string{"no priority", "<>1 -", "<999>Oct 16 03:48:01 sshd: x"} {
+		if _, err := parseSyslog(msg); err == nil {
+			t.Errorf("Expected error for %q", msg)
+		}
+	}
+}
+
+func TestParseJournalEntry(t *testing.T) {
+	// "Failed \xff" is not valid UTF-8, so journalctl outputs it as a byte array
+	e, cursor, err := parseJournalEntry([]byte(`{"__CURSOR":"s=1;i=2","PRIORITY":"3","SYSLOG_IDENTIFIER":"kernel","MESSAGE":[70,97,105,108,101,100,32,255]}`))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if cursor != "s=1;i=2" || e.Priority != 3 || e.Identifier != "kernel" || e.Message != "Failed \xff" {
+		t.Errorf("Unexpected entry %+v with cursor %s", e, cursor)
+	}
+
+	e, _, err = parseJournalEntry([]byte(`{"MESSAGE":"hello","_SYSTEMD_UNIT":"ssh.service"}`))
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if e.Priority != 6 || e.Unit != "ssh.service" || e.Message != "hello" {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+}
+
+func TestFollowJournal(t *testing.T) {
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	journalctl := filepath.Join(dir, "journalctl")
+	script := `#!/bin/sh
+echo "$@" > ` + argsFile + `
+echo '{"__CURSOR":"c1","PRIORITY":"5","SYSLOG_IDENTIFIER":"sshd","MESSAGE":"Failed password for root"}'
+echo 'not json'
+echo '{"__CURSOR":"c2","PRIORITY":"6","SYSLOG_IDENTIFIER":"sshd","MESSAGE":"Accepted publickey for jan"}'
+`
+	if err := os.WriteFile(journalctl, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+
+	storage := newTestStorage(t)
+	if err := storage.Set(cursorKey, "c0"); err != nil {
+		t.Fatalf("Failed to set cursor: %v", err)
+	}
+	module, err := NewLogwatchModule(Config{
+		Journalctl: journalctl,
+		Rules:      []RuleConfig{{Name: "ssh_failures", Pattern: "^Failed password", Identifier: "sshd"}},
+	}, storage)
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+
+	if err := module.followJournal(context.Background()); err != nil {
+		t.Fatalf("followJournal failed: %v", err)
+	}
+	args, _ := os.ReadFile(argsFile)
+	if !strings.Contains(string(args), "--after-cursor=c0") {
+		t.Errorf("Expected journalctl to continue after the stored cursor, got %q", args)
+	}
+	if module.counts["ssh_failures"] != 1 || module.cursor != "c2" {
+		t.Errorf("Expected 1 match up to cursor c2, got %v up to %s", module.counts, module.cursor)
+	}
+}
+
+func TestReportPersistsCounts(t *testing.T) {
+	storage := newTestStorage(t)
+	cfg := Config{Rules: []RuleConfig{
+		{Name: "ssh_failures", Pattern: "^Failed password"},
+		{Name: "usb_resets", Pattern: "reset .* USB device", Identifier: "kernel", Measurement: "kernel_events"},
+	}}
+	module, err := NewLogwatchModule(cfg, storage)
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 2)
+	module.metricsCh = ch
+
+	module.process(entry{Identifier: "sshd", Priority: 6, Message: "Failed password for root"}, "c1")
+	module.process(entry{Identifier: "sshd", Priority: 6, Message: "Failed password for admin"}, "c2")
+	module.report()
+
+	ssh, usb := <-ch, <-ch
+	if ssh.Name != "log_events" || ssh.Tags["device"] != "ssh_failures" || ssh.Tags["vendor"] != "journald" || ssh.Fields["count"] != int64(2) {
+		t.Errorf("Unexpected metric: %+v", ssh)
+	}
+	if ssh.FieldKind("count") != metrics.KindCounter {
+		t.Error("Expected count to be a counter")
+	}
+	if usb.Name != "kernel_events" || usb.Fields["count"] != int64(0) {
+		t.Errorf("Expected rule without matches to report 0, got %+v", usb)
+	}
+
+	// A restarted module continues with the persisted counts and cursor
+	restarted, err := NewLogwatchModule(cfg, storage)
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	if restarted.counts["ssh_failures"] != 2 || restarted.cursor != "c2" {
+		t.Errorf("Expected persisted count 2 up to cursor c2, got %v up to %s", restarted.counts, restarted.cursor)
+	}
+}
+
+func TestReceiveSyslog(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "syslog.sock")
+	module, err := NewLogwatchModule(Config{
+		Source: "syslog",
+		Listen: "unix://" + socket,
+		Rules:  []RuleConfig{{Name: "usb_resets", Pattern: "reset .* USB device", Identifier: "kernel", Priority: "warning"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- module.receiveSyslog(ctx) }()
+
+	var conn net.Conn
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("unixgram", socket); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("<4>Oct  6 13:01:22 kernel: usb 1-1: reset high-speed USB device number 2"))
+	conn.Write([]byte("<6>Oct  6 13:01:23 kernel: usb 1-1: reset high-speed USB device number 3"))
+	for i := 0; i < 100; i++ {
+		module.mu.Lock()
+		count := module.counts["usb_resets"]
+		module.mu.Unlock()
+		if count == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Expected no error after cancellation, got %v", err)
+	}
+	if module.counts["usb_resets"] != 1 {
+		t.Errorf("Expected 1 match of sufficient priority, got %d", module.counts["usb_resets"])
+	}
+}
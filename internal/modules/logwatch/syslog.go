@@ -0,0 +1,184 @@
+package logwatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// maxSyslogMessage is the maximum size of a received syslog datagram
+const maxSyslogMessage = 64 * 1024
+
+// parseListen splits the syslog listen address into the network and address
+// for net.ListenPacket
+func parseListen(listen string) (string, string, error) {
+	u, err := url.Parse(listen)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid listen address %q: %w", listen, err)
+	}
+	switch u.Scheme {
+	case "udp":
+		if u.Host == "" {
+			return "", "", fmt.Errorf("listen address %q has no host and port", listen)
+		}
+		return "udp", u.Host, nil
+	case "unix":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("listen address %q has no socket path", listen)
+		}
+		return "unixgram", u.Path, nil
+	default:
+		return "", "", fmt.Errorf("listen address must start with udp:// or unix://, got %q", listen)
+	}
+}
+
+// receiveSyslog processes the syslog messages received on the listen socket
+// until the context is cancelled
+func (lm *LogwatchModule) receiveSyslog(ctx context.Context) error {
+	network, address, err := parseListen(lm.config.Listen)
+	if err != nil {
+		return err
+	}
+	if network == "unixgram" {
+		// Remove the socket left behind by a previous run
+		if err := os.Remove(address); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen for syslog messages: %w", err)
+	}
+	defer conn.Close()
+	if network == "unixgram" {
+		defer os.Remove(address)
+	}
+	utils.Infof("Listening for syslog messages on %s", lm.config.Listen)
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to receive syslog message: %w", err)
+		}
+		e, err := parseSyslog(string(buf[:n]))
+		if err != nil {
+			utils.Debugf("Ignoring syslog message: %v", err)
+			continue
+		}
+		lm.process(e, "")
+	}
+}
+
+// parseSyslog parses a syslog message in RFC 5424 or RFC 3164 format. The
+// hostname is optional in RFC 3164, as messages sent to a local socket usually
+// omit it.
+func parseSyslog(msg string) (entry, error) {
+	msg = strings.TrimRight(msg, "\r\n\x00")
+	if !strings.HasPrefix(msg, "<") {
+		return entry{}, fmt.Errorf("missing priority")
+	}
+	end := strings.IndexByte(msg, '>')
+	if end < 2 || end > 4 {
+		return entry{}, fmt.Errorf("invalid priority")
+	}
+	pri, err := strconv.Atoi(msg[1:end])
+	if err != nil || pri > 191 {
+		return entry{}, fmt.Errorf("invalid priority %q", msg[1:end])
+	}
+	e := entry{Priority: pri % 8}
+	msg = msg[end+1:]
+
+	if strings.HasPrefix(msg, "1 ") {
+		return parseRFC5424(e, msg[2:]), nil
+	}
+	return parseRFC3164(e, msg), nil
+}
+
+// parseRFC5424 parses the header after the version:
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func parseRFC5424(e entry, msg string) entry {
+	parts := strings.SplitN(msg, " ", 6)
+	if len(parts) < 6 {
+		e.Message = msg
+		return e
+	}
+	if parts[2] != "-" {
+		e.Identifier = parts[2]
+	}
+	e.Message = skipStructuredData(parts[5])
+	return e
+}
+
+// skipStructuredData returns the message after the structured data elements
+func skipStructuredData(msg string) string {
+	if strings.HasPrefix(msg, "-") {
+		msg = msg[1:]
+	}
+	for strings.HasPrefix(msg, "[") {
+		end := strings.IndexByte(msg, ']')
+		// Escaped brackets inside parameter values
+		for end > 0 && msg[end-1] == '\\' {
+			next := strings.IndexByte(msg[end+1:], ']')
+			if next < 0 {
+				return ""
+			}
+			end += next + 1
+		}
+		if end < 0 {
+			return ""
+		}
+		msg = msg[end+1:]
+	}
+	msg = strings.TrimPrefix(msg, " ")
+	// The message may start with a UTF-8 byte order mark
+	return strings.TrimPrefix(msg, "\ufeff")
+}
+
+// parseRFC3164 parses "Mmm dd hh:mm:ss [HOSTNAME] TAG[PID]: MSG"
+func parseRFC3164(e entry, msg string) entry {
+	if len(msg) > len(time.Stamp) && msg[len(time.Stamp)] == ' ' {
+		if _, err := time.Parse(time.Stamp, msg[:len(time.Stamp)]); err == nil {
+			msg = msg[len(time.Stamp)+1:]
+		}
+	}
+
+	tagEnd := strings.Index(msg, ": ")
+	if tagEnd < 0 {
+		e.Message = msg
+		return e
+	}
+	header := msg[:tagEnd]
+	if strings.Contains(header, " ") {
+		// The first word is the hostname
+		header = header[strings.IndexByte(header, ' ')+1:]
+	}
+	if strings.Contains(header, " ") {
+		// No tag, the colon is part of the message
+		e.Message = msg
+		return e
+	}
+	if pid := strings.IndexByte(header, '['); pid >= 0 {
+		header = header[:pid]
+	}
+	e.Identifier = header
+	e.Message = msg[tagEnd+2:]
+	return e
+}
@@ -0,0 +1,210 @@
+package lorawan
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Payload decoders
+const (
+	decoderDecoded    = "decoded"     // Payload decoded by the network server (TTN payload formatter, ChirpStack codec)
+	decoderCayenneLPP = "cayenne_lpp" // Cayenne Low Power Payload
+	decoderTemplate   = "template"    // Byte layout of the device configuration
+)
+
+// TemplateField describes a value at a fixed position of the raw payload
+type TemplateField struct {
+	Name         string  `json:"name"`          // Metric field name
+	Offset       int     `json:"offset"`        // Byte offset in the payload
+	Type         string  `json:"type"`          // int8, uint8, int16, uint16, int32, uint32 or float32
+	LittleEndian bool    `json:"little_endian"` // Byte order (defaults to big endian)
+	Scale        float64 `json:"scale"`         // Factor applied to the raw value (defaults to 1)
+	Add          float64 `json:"add"`           // Added after scaling, e.g. -40 for an offset temperature
+}
+
+// templateSizes are the sizes of the template field types
+var templateSizes = map[string]int{
+	"int8":    1,
+	"uint8":   1,
+	"int16":   2,
+	"uint16":  2,
+	"int32":   4,
+	"uint32":  4,
+	"float32": 4,
+}
+
+// validateTemplate checks the fields of a template
+func validateTemplate(template []TemplateField) error {
+	if len(template) == 0 {
+		return fmt.Errorf("template is required for the template decoder")
+	}
+	for _, field := range template {
+		if field.Name == "" {
+			return fmt.Errorf("template field name is required")
+		}
+		if _, known := templateSizes[field.Type]; !known {
+			return fmt.Errorf("unsupported type %q of template field %s", field.Type, field.Name)
+		}
+		if field.Offset < 0 {
+			return fmt.Errorf("offset of template field %s must not be negative", field.Name)
+		}
+	}
+	return nil
+}
+
+// decodeTemplate reads the template fields from the payload. Fields beyond the
+// end of the payload are left out, so shorter uplinks still deliver the fields they contain.
+func decodeTemplate(payload []byte, template []TemplateField) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for _, field := range template {
+		size := templateSizes[field.Type]
+		if field.Offset+size > len(payload) {
+			continue
+		}
+		raw := payload[field.Offset : field.Offset+size]
+
+		var order binary.ByteOrder = binary.BigEndian
+		if field.LittleEndian {
+			order = binary.LittleEndian
+		}
+
+		var value float64
+		switch field.Type {
+		case "int8":
+			value = float64(int8(raw[0]))
+		case "uint8":
+			value = float64(raw[0])
+		case "int16":
+			value = float64(int16(order.Uint16(raw)))
+		case "uint16":
+			value = float64(order.Uint16(raw))
+		case "int32":
+			value = float64(int32(order.Uint32(raw)))
+		case "uint32":
+			value = float64(order.Uint32(raw))
+		case "float32":
+			value = float64(math.Float32frombits(order.Uint32(raw)))
+		}
+
+		scale := field.Scale
+		if scale == 0 {
+			scale = 1
+		}
+		fields[field.Name] = value*scale + field.Add
+	}
+	return fields
+}
+
+// lppType describes a Cayenne LPP data type
+type lppType struct {
+	name    string
+	size    int     // size of each value in bytes
+	count   int     // number of values, e.g. 3 for the axes of an accelerometer
+	divisor float64 // raw value per unit, dividing keeps e.g. 272 / 10 at exactly 27.2
+	signed  bool
+}
+
+// lppTypes are the Cayenne LPP data types by their type ID
+var lppTypes = map[byte]lppType{
+	0:   {name: "digital_input", size: 1, count: 1, divisor: 1},
+	1:   {name: "digital_output", size: 1, count: 1, divisor: 1},
+	2:   {name: "analog_input", size: 2, count: 1, divisor: 100, signed: true},
+	3:   {name: "analog_output", size: 2, count: 1, divisor: 100, signed: true},
+	101: {name: "illuminance", size: 2, count: 1, divisor: 1},
+	102: {name: "presence", size: 1, count: 1, divisor: 1},
+	103: {name: "temperature", size: 2, count: 1, divisor: 10, signed: true},
+	104: {name: "humidity", size: 1, count: 1, divisor: 2},
+	113: {name: "accelerometer", size: 2, count: 3, divisor: 1000, signed: true},
+	115: {name: "barometer", size: 2, count: 1, divisor: 10},
+	116: {name: "voltage", size: 2, count: 1, divisor: 100},
+	117: {name: "current", size: 2, count: 1, divisor: 1000},
+	120: {name: "percentage", size: 1, count: 1, divisor: 1},
+	121: {name: "altitude", size: 2, count: 1, divisor: 1, signed: true},
+	125: {name: "concentration", size: 2, count: 1, divisor: 1},
+	128: {name: "power", size: 2, count: 1, divisor: 1},
+	130: {name: "distance", size: 4, count: 1, divisor: 1000},
+	131: {name: "energy", size: 4, count: 1, divisor: 1000},
+	134: {name: "gyrometer", size: 2, count: 3, divisor: 100, signed: true},
+	136: {name: "gps", size: 3, count: 3, divisor: 10000, signed: true},
+	142: {name: "switch", size: 1, count: 1, divisor: 1},
+}
+
+// lppAxes are the field suffixes of types with several values
+var lppAxes = map[string][]string{
+	"accelerometer": {"x", "y", "z"},
+	"gyrometer":     {"x", "y", "z"},
+	"gps":           {"latitude", "longitude", "altitude"},
+}
+
+// decodeCayenneLPP decodes a Cayenne LPP payload into fields named after the
+// type and the channel, e.g. "temperature_1".
+func decodeCayenneLPP(payload []byte) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	for len(payload) > 0 {
+		if len(payload) < 2 {
+			return nil, fmt.Errorf("truncated Cayenne LPP payload")
+		}
+		channel, typeID := payload[0], payload[1]
+		dataType, known := lppTypes[typeID]
+		if !known {
+			return nil, fmt.Errorf("unsupported Cayenne LPP type %d on channel %d", typeID, channel)
+		}
+		size := dataType.size * dataType.count
+		if len(payload) < 2+size {
+			return nil, fmt.Errorf("truncated Cayenne LPP value of type %s on channel %d", dataType.name, channel)
+		}
+		data := payload[2 : 2+size]
+		payload = payload[2+size:]
+
+		name := dataType.name + "_" + strconv.Itoa(int(channel))
+		for i := 0; i < dataType.count; i++ {
+			divisor := dataType.divisor
+			// The GPS altitude has a resolution of 0.01 m instead of 0.0001°
+			if dataType.name == "gps" && i == 2 {
+				divisor = 100
+			}
+			value := lppValue(data[i*dataType.size:(i+1)*dataType.size], dataType.signed) / divisor
+			if dataType.count == 1 {
+				fields[name] = value
+			} else {
+				fields[name+"_"+lppAxes[dataType.name][i]] = value
+			}
+		}
+	}
+	return fields, nil
+}
+
+// lppValue reads a big endian value of 1 to 4 bytes
+func lppValue(data []byte, signed bool) float64 {
+	var raw uint32
+	for _, b := range data {
+		raw = raw<<8 | uint32(b)
+	}
+	if signed {
+		bits := uint(8 * len(data))
+		if raw&(1<<(bits-1)) != 0 {
+			return float64(int64(raw) - int64(1)<<bits)
+		}
+	}
+	return float64(raw)
+}
+
+// flattenDecoded converts the numbers and booleans of a payload decoded by the
+// network server into fields. Nested objects are joined with an underscore,
+// e.g. {"soil": {"moisture": 30}} becomes "soil_moisture".
+func flattenDecoded(prefix string, decoded map[string]interface{}, fields map[string]interface{}) {
+	for key, value := range decoded {
+		name := key
+		if prefix != "" {
+			name = prefix + "_" + key
+		}
+		switch v := value.(type) {
+		case float64, bool:
+			fields[name] = v
+		case map[string]interface{}:
+			flattenDecoded(name, v, fields)
+		}
+	}
+}
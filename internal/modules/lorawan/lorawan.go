@@ -0,0 +1,515 @@
+// Package lorawan provides a metric collection module for LoRaWAN devices.
+// It subscribes to the uplinks of The Things Network (TTN) or a ChirpStack
+// instance via MQTT, decodes the payloads with the decoder configured per
+// device and reports the sensor values together with the radio quality.
+package lorawan
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/connection"
+	mqttselftest "github.com/janhuddel/metrics-agent/internal/selftest/mqtt"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// Network servers
+	networkTTN        = "ttn"
+	networkChirpStack = "chirpstack"
+
+	// Default uplink topics of the network servers
+	topicTTN        = "v3/+/devices/+/up"
+	topicChirpStack = "application/+/device/+/event/up"
+
+	// defaultMeasurement is the metric name of devices without a configured measurement
+	defaultMeasurement = "lorawan"
+
+	metricSendTimeout = 1 * time.Second
+)
+
+// Config represents the configuration for the LoRaWAN module
+type Config struct {
+	config.BaseConfig
+	config.MQTTOptions // qos, clean_session, max_in_flight
+
+	Broker      string          `json:"broker"`      // MQTT broker address (e.g., "mqtts://eu1.cloud.thethings.network:8883")
+	Username    string          `json:"username"`    // MQTT username (TTN: "<application>@ttn")
+	Password    string          `json:"password"`    // MQTT password (TTN: API key)
+	ClientID    string          `json:"client_id"`   // MQTT client ID (optional, defaults to hostname)
+	Timeout     config.Duration `json:"timeout"`     // Connection timeout (defaults to 30s)
+	Network     string          `json:"network"`     // "ttn" or "chirpstack" (defaults to ttn)
+	Topic       string          `json:"topic"`       // Uplink topic (defaults to the topic of the network)
+	Decoder     string          `json:"decoder"`     // Decoder of devices without one: "decoded", "cayenne_lpp" or "template"
+	Measurement string          `json:"measurement"` // Metric name of devices without one (defaults to "lorawan")
+	Devices     []DeviceConfig  `json:"devices"`     // Device specific settings, other devices use the defaults
+}
+
+// DeviceConfig holds the settings of a single device
+type DeviceConfig struct {
+	ID          string          `json:"id"`          // TTN device ID or ChirpStack device name
+	Decoder     string          `json:"decoder"`     // Payload decoder (defaults to the module decoder)
+	Measurement string          `json:"measurement"` // Metric name (defaults to the module measurement)
+	Template    []TemplateField `json:"template"`    // Byte layout for the template decoder
+}
+
+// Uplink is an uplink message of a device, independent of the network server
+type Uplink struct {
+	DeviceID string
+	FPort    int
+	FCnt     int64
+	Payload  []byte                 // raw application payload
+	Decoded  map[string]interface{} // payload decoded by the network server, nil if not decoded
+	RSSI     *float64               // best RSSI of the receiving gateways
+	SNR      *float64               // best SNR of the receiving gateways
+	Time     time.Time              // time the network server received the uplink, zero if unknown
+}
+
+// LoRaWANModule handles the MQTT subscription and payload decoding
+type LoRaWANModule struct {
+	config    Config
+	client    mqtt.Client
+	metricsCh chan<- metrics.Metric
+	clock     utils.Clock
+	devices   map[string]DeviceConfig // keyed by device ID
+	inFlight  utils.Semaphore         // Limits concurrently processed messages
+}
+
+// Run starts the LoRaWAN module and begins collecting metrics
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewLoRaWANModule(config)
+	if err != nil {
+		return fmt.Errorf("failed to create LoRaWAN module: %w", err)
+	}
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+
+	return module.run(ctx)
+}
+
+// Probe validates the LoRaWAN configuration and checks that the MQTT broker is reachable
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	if _, err := NewLoRaWANModule(cfg); err != nil {
+		return &config.ModuleError{Module: "lorawan", Err: err}
+	}
+	return utils.ProbeURL(ctx, cfg.Broker, cfg.Timeout.Duration())
+}
+
+// SelfTest checks that the MQTT broker accepts the configured credentials
+func SelfTest(ctx context.Context) []utils.Check {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return []utils.Check{{Name: "configuration", Err: err}}
+	}
+	return []utils.Check{mqttselftest.CheckLogin(ctx, cfg.Broker, cfg.Username, cfg.Password, cfg.Timeout.Duration())}
+}
+
+// NewLoRaWANModule creates a new LoRaWAN module instance
+func NewLoRaWANModule(cfg Config) (*LoRaWANModule, error) {
+	utils.Debugf("Creating new LoRaWAN module instance")
+
+	if err := cfg.MQTTOptions.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.Network {
+	case "", networkTTN:
+		cfg.Network = networkTTN
+		if cfg.Topic == "" {
+			cfg.Topic = topicTTN
+		}
+	case networkChirpStack:
+		if cfg.Topic == "" {
+			cfg.Topic = topicChirpStack
+		}
+	default:
+		return nil, fmt.Errorf("unsupported network %q, expected %q or %q", cfg.Network, networkTTN, networkChirpStack)
+	}
+	if cfg.Decoder == "" {
+		cfg.Decoder = decoderDecoded
+	}
+	if cfg.Decoder == decoderTemplate {
+		return nil, fmt.Errorf("the template decoder can only be set per device")
+	}
+	if err := validateDecoder(cfg.Decoder); err != nil {
+		return nil, err
+	}
+	if cfg.Measurement == "" {
+		cfg.Measurement = defaultMeasurement
+	}
+
+	devices := make(map[string]DeviceConfig, len(cfg.Devices))
+	for _, device := range cfg.Devices {
+		if device.ID == "" {
+			return nil, fmt.Errorf("device id is required")
+		}
+		if _, exists := devices[device.ID]; exists {
+			return nil, fmt.Errorf("device %s is configured more than once", device.ID)
+		}
+		if device.Decoder == "" {
+			device.Decoder = cfg.Decoder
+		}
+		if err := validateDecoder(device.Decoder); err != nil {
+			return nil, fmt.Errorf("device %s: %w", device.ID, err)
+		}
+		if device.Decoder == decoderTemplate {
+			if err := validateTemplate(device.Template); err != nil {
+				return nil, fmt.Errorf("device %s: %w", device.ID, err)
+			}
+		}
+		if device.Measurement == "" {
+			device.Measurement = cfg.Measurement
+		}
+		devices[device.ID] = device
+	}
+
+	utils.Debugf("LoRaWAN module created successfully with %d configured devices", len(devices))
+	return &LoRaWANModule{
+		clock:    utils.SystemClock,
+		config:   cfg,
+		devices:  devices,
+		inFlight: utils.NewSemaphore(cfg.MaxInFlight),
+	}, nil
+}
+
+// validateDecoder checks that a decoder is known
+func validateDecoder(decoder string) error {
+	switch decoder {
+	case decoderDecoded, decoderCayenneLPP, decoderTemplate:
+		return nil
+	}
+	return fmt.Errorf("unsupported decoder %q", decoder)
+}
+
+// DefaultConfig returns the default configuration of the LoRaWAN module.
+func DefaultConfig() Config {
+	return Config{
+		MQTTOptions: config.MQTTOptions{
+			QoS:          1,
+			CleanSession: true, // Subscriptions are recreated in the connect handler
+		},
+		Broker:      "tcp://localhost:1883",
+		Timeout:     config.Duration(30 * time.Second),
+		Network:     networkTTN,
+		Decoder:     decoderDecoded,
+		Measurement: defaultMeasurement,
+	}
+}
+
+// LoadConfig loads the LoRaWAN module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("lorawan")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
+
+// run executes the main module loop
+func (lm *LoRaWANModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("LoRaWAN module", "main", func() error {
+		if err := lm.connect(ctx); err != nil {
+			return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+		}
+		defer func() {
+			if lm.client.IsConnected() {
+				lm.client.Disconnect(250)
+			}
+		}()
+
+		<-ctx.Done()
+		return nil
+	})
+}
+
+// connect establishes the MQTT connection; the subscription is (re)created in the connect handler
+func (lm *LoRaWANModule) connect(ctx context.Context) error {
+	clientID := lm.config.ClientID
+	if clientID == "" {
+		hostname, _ := os.Hostname()
+		clientID = hostname + "-" + lm.config.InstanceName("lorawan")
+	}
+
+	tracker := connection.NewTracker(lm.config.InstanceName("lorawan"), lm.config.Broker, lm.metricsCh)
+	if err := utils.CheckDestination(ctx, lm.config.Broker); err != nil {
+		utils.AuditConnect(lm.config.InstanceName("lorawan"), "mqtt", lm.config.Broker, 0, err)
+		tracker.SetConnected(false)
+		return err
+	}
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(lm.config.Broker)
+	opts.SetClientID(clientID)
+	opts.SetUsername(lm.config.Username)
+	opts.SetPassword(lm.config.Password)
+	opts.SetConnectTimeout(lm.config.Timeout.Duration())
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(5 * time.Minute)
+	opts.SetCleanSession(lm.config.CleanSession)
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		utils.Errorf("MQTT connection lost: %v", err)
+		tracker.SetConnected(false)
+	})
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		utils.WithPanicRecoveryAndContinue("MQTT connect handler", "broker", func() {
+			utils.Infof("Connected to MQTT broker: %s", lm.config.Broker)
+			utils.AuditConnect(lm.config.InstanceName("lorawan"), "mqtt", lm.config.Broker, 0, nil)
+			tracker.SetConnected(true)
+			token := client.Subscribe(lm.config.Topic, byte(lm.config.QoS), lm.handleMessage)
+			go func() {
+				if token.Wait() && token.Error() != nil {
+					utils.Errorf("Failed to subscribe to uplink topic %s: %v", lm.config.Topic, token.Error())
+				} else {
+					utils.Debugf("Subscribed to uplink topic: %s", lm.config.Topic)
+				}
+			}()
+		})
+	})
+
+	lm.client = mqtt.NewClient(opts)
+
+	connChan := make(chan error, 1)
+	go func() {
+		token := lm.client.Connect()
+		token.Wait()
+		connChan <- token.Error()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-connChan:
+		if err != nil {
+			utils.AuditConnect(lm.config.InstanceName("lorawan"), "mqtt", lm.config.Broker, 0, err)
+			tracker.SetConnected(false)
+		}
+		return err
+	}
+}
+
+// handleMessage is the MQTT message handler for uplinks
+func (lm *LoRaWANModule) handleMessage(client mqtt.Client, msg mqtt.Message) {
+	lm.inFlight.Do(func() {
+		utils.WithPanicRecoveryAndContinue("LoRaWAN message handler", msg.Topic(), func() {
+			lm.processMessage(msg.Payload())
+		})
+	})
+}
+
+// processMessage parses an uplink, decodes its payload and emits a metric
+func (lm *LoRaWANModule) processMessage(payload []byte) {
+	var (
+		uplink Uplink
+		err    error
+	)
+	if lm.config.Network == networkChirpStack {
+		uplink, err = parseChirpStackUplink(payload)
+	} else {
+		uplink, err = parseTTNUplink(payload)
+	}
+	if err != nil {
+		utils.Warnf("Ignoring invalid uplink: %v", err)
+		return
+	}
+
+	device, configured := lm.devices[uplink.DeviceID]
+	if !configured {
+		device = DeviceConfig{ID: uplink.DeviceID, Decoder: lm.config.Decoder, Measurement: lm.config.Measurement}
+	}
+
+	fields, err := decodeUplink(uplink, device)
+	if err != nil {
+		utils.Warnf("Failed to decode uplink of LoRaWAN device %s: %v", uplink.DeviceID, err)
+		return
+	}
+	if len(fields) == 0 {
+		utils.Debugf("Uplink of LoRaWAN device %s contains no values", uplink.DeviceID)
+		return
+	}
+
+	// Radio quality of the uplink, e.g. to find sensors at the edge of the coverage
+	if uplink.RSSI != nil {
+		fields["rssi"] = *uplink.RSSI
+	}
+	if uplink.SNR != nil {
+		fields["snr"] = *uplink.SNR
+	}
+	fields["frame_counter"] = uplink.FCnt
+
+	timestamp := uplink.Time
+	if timestamp.IsZero() {
+		timestamp = lm.clock.Now()
+	}
+	lm.sendMetric(device, fields, timestamp)
+}
+
+// decodeUplink decodes the payload of an uplink with the decoder of the device
+func decodeUplink(uplink Uplink, device DeviceConfig) (map[string]interface{}, error) {
+	switch device.Decoder {
+	case decoderCayenneLPP:
+		return decodeCayenneLPP(uplink.Payload)
+	case decoderTemplate:
+		return decodeTemplate(uplink.Payload, device.Template), nil
+	default:
+		if uplink.Decoded == nil {
+			return nil, fmt.Errorf("uplink has no decoded payload, configure a payload formatter or another decoder")
+		}
+		fields := make(map[string]interface{})
+		flattenDecoded("", uplink.Decoded, fields)
+		return fields, nil
+	}
+}
+
+// ttnUplink is the uplink message of The Things Stack (v3)
+type ttnUplink struct {
+	EndDeviceIDs struct {
+		DeviceID string `json:"device_id"`
+	} `json:"end_device_ids"`
+	ReceivedAt    time.Time `json:"received_at"`
+	UplinkMessage *struct {
+		FPort          int                    `json:"f_port"`
+		FCnt           int64                  `json:"f_cnt"`
+		FRMPayload     string                 `json:"frm_payload"`
+		DecodedPayload map[string]interface{} `json:"decoded_payload"`
+		RxMetadata     []rxMetadata           `json:"rx_metadata"`
+	} `json:"uplink_message"`
+}
+
+// chirpStackUplink is the uplink event of ChirpStack (v4)
+type chirpStackUplink struct {
+	DeviceInfo struct {
+		DeviceName string `json:"deviceName"`
+		DevEUI     string `json:"devEui"`
+	} `json:"deviceInfo"`
+	Time   time.Time              `json:"time"`
+	FPort  int                    `json:"fPort"`
+	FCnt   int64                  `json:"fCnt"`
+	Data   string                 `json:"data"`
+	Object map[string]interface{} `json:"object"`
+	RxInfo []rxMetadata           `json:"rxInfo"`
+}
+
+// rxMetadata is the reception of an uplink by a gateway
+type rxMetadata struct {
+	RSSI *float64 `json:"rssi"`
+	SNR  *float64 `json:"snr"`
+}
+
+// parseTTNUplink parses an uplink message of The Things Stack
+func parseTTNUplink(payload []byte) (Uplink, error) {
+	var message ttnUplink
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return Uplink{}, err
+	}
+	if message.EndDeviceIDs.DeviceID == "" || message.UplinkMessage == nil {
+		return Uplink{}, fmt.Errorf("message has no device ID or uplink")
+	}
+	raw, err := base64.StdEncoding.DecodeString(message.UplinkMessage.FRMPayload)
+	if err != nil {
+		return Uplink{}, fmt.Errorf("invalid frm_payload: %w", err)
+	}
+
+	uplink := Uplink{
+		DeviceID: message.EndDeviceIDs.DeviceID,
+		FPort:    message.UplinkMessage.FPort,
+		FCnt:     message.UplinkMessage.FCnt,
+		Payload:  raw,
+		Decoded:  message.UplinkMessage.DecodedPayload,
+		Time:     message.ReceivedAt,
+	}
+	uplink.RSSI, uplink.SNR = bestReception(message.UplinkMessage.RxMetadata)
+	return uplink, nil
+}
+
+// parseChirpStackUplink parses an uplink event of ChirpStack. Devices are
+// identified by their name, or their DevEUI if they have none.
+func parseChirpStackUplink(payload []byte) (Uplink, error) {
+	var message chirpStackUplink
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return Uplink{}, err
+	}
+	deviceID := message.DeviceInfo.DeviceName
+	if deviceID == "" {
+		deviceID = message.DeviceInfo.DevEUI
+	}
+	if deviceID == "" {
+		return Uplink{}, fmt.Errorf("message has no device name or DevEUI")
+	}
+	raw, err := base64.StdEncoding.DecodeString(message.Data)
+	if err != nil {
+		return Uplink{}, fmt.Errorf("invalid data: %w", err)
+	}
+
+	uplink := Uplink{
+		DeviceID: deviceID,
+		FPort:    message.FPort,
+		FCnt:     message.FCnt,
+		Payload:  raw,
+		Decoded:  message.Object,
+		Time:     message.Time,
+	}
+	uplink.RSSI, uplink.SNR = bestReception(message.RxInfo)
+	return uplink, nil
+}
+
+// bestReception returns the RSSI and SNR of the gateway with the best RSSI
+func bestReception(receptions []rxMetadata) (*float64, *float64) {
+	var best *rxMetadata
+	for i := range receptions {
+		if receptions[i].RSSI != nil && (best == nil || *receptions[i].RSSI > *best.RSSI) {
+			best = &receptions[i]
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	return best.RSSI, best.SNR
+}
+
+// sendMetric creates and sends a metric for a device
+func (lm *LoRaWANModule) sendMetric(device DeviceConfig, fields map[string]interface{}, timestamp time.Time) {
+	metric := metrics.Metric{
+		Name: device.Measurement,
+		Tags: map[string]string{
+			"vendor":   "lorawan",
+			"device":   device.ID,
+			"friendly": lm.config.GetFriendlyName(device.ID, "", device.ID),
+		},
+		Fields:    fields,
+		Timestamp: timestamp,
+	}
+
+	if err := metric.Validate(); err != nil {
+		utils.Warnf("Invalid metric for LoRaWAN device %s: %v", device.ID, err)
+		return
+	}
+
+	select {
+	case lm.metricsCh <- metric:
+	case <-time.After(metricSendTimeout):
+		utils.Warnf("Metric channel full, dropping metric for LoRaWAN device %s", device.ID)
+	}
+}
@@ -0,0 +1,163 @@
+package lorawan
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestNewLoRaWANModule(t *testing.T) {
+	tah := utils.NewTestAssertionHelper()
+
+	_, err := NewLoRaWANModule(Config{Network: "helium"})
+	tah.AssertError(t, err, "Expected error for unsupported network")
+
+	_, err = NewLoRaWANModule(Config{Decoder: "javascript"})
+	tah.AssertError(t, err, "Expected error for unsupported decoder")
+
+	_, err = NewLoRaWANModule(Config{Devices: []DeviceConfig{{ID: "garden", Decoder: "template"}}})
+	tah.AssertError(t, err, "Expected error for template decoder without template")
+
+	_, err = NewLoRaWANModule(Config{Devices: []DeviceConfig{{ID: "garden", Decoder: "template", Template: []TemplateField{{Name: "moisture", Type: "int24"}}}}})
+	tah.AssertError(t, err, "Expected error for unsupported template type")
+
+	module, err := NewLoRaWANModule(Config{Network: "chirpstack", Devices: []DeviceConfig{{ID: "garden"}}})
+	tah.AssertNoError(t, err, "Failed to create module")
+	if module.config.Topic != topicChirpStack || module.devices["garden"].Decoder != decoderDecoded || module.devices["garden"].Measurement != "lorawan" {
+		t.Errorf("Unexpected defaults: %+v %+v", module.config, module.devices["garden"])
+	}
+}
+
+func TestProcessTTNUplink(t *testing.T) {
+	module, err := NewLoRaWANModule(Config{Devices: []DeviceConfig{{ID: "soil-1", Decoder: "cayenne_lpp", Measurement: "climate"}}})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 1)
+	module.metricsCh = ch
+
+	// Temperature 27.2 °C on channel 1, humidity 41 % on channel 2
+	module.processMessage([]byte(`{
+		"end_device_ids": {"device_id": "soil-1", "application_ids": {"application_id": "garden"}},
+		"received_at": "2024-05-01T12:00:00.123Z",
+		"uplink_message": {
+			"f_port": 1, "f_cnt": 42, "frm_payload": "AWcBEAJoUg==",
+			"rx_metadata": [{"rssi": -112, "snr": -3.5}, {"rssi": -97, "snr": 6.25}]
+		}
+	}`))
+
+	metric := <-ch
+	if metric.Name != "climate" || metric.Tags["device"] != "soil-1" || metric.Tags["vendor"] != "lorawan" {
+		t.Errorf("Unexpected metric: %+v", metric)
+	}
+	expected := map[string]interface{}{
+		"temperature_1": 27.2,
+		"humidity_2":    41.0,
+		"rssi":          -97.0,
+		"snr":           6.25,
+		"frame_counter": int64(42),
+	}
+	for name, value := range expected {
+		if metric.Fields[name] != value {
+			t.Errorf("Expected %s to be %v, got %v", name, value, metric.Fields[name])
+		}
+	}
+	if !metric.Timestamp.Equal(time.Date(2024, 5, 1, 12, 0, 0, 123000000, time.UTC)) {
+		t.Errorf("Expected the receive time as timestamp, got %v", metric.Timestamp)
+	}
+}
+
+func TestProcessChirpStackUplink(t *testing.T) {
+	module, err := NewLoRaWANModule(Config{Network: "chirpstack"})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 1)
+	module.metricsCh = ch
+
+	module.processMessage([]byte(`{
+		"deviceInfo": {"deviceName": "", "devEui": "0102030405060708"},
+		"fCnt": 7, "fPort": 2, "data": "AQ==",
+		"object": {"battery": 3.6, "soil": {"moisture": 31, "dry": false}, "label": "bed"},
+		"rxInfo": [{"rssi": -80, "snr": 9}]
+	}`))
+
+	metric := <-ch
+	if metric.Name != "lorawan" || metric.Tags["device"] != "0102030405060708" {
+		t.Errorf("Unexpected metric: %+v", metric)
+	}
+	expected := map[string]interface{}{
+		"battery":       3.6,
+		"soil_moisture": 31.0,
+		"soil_dry":      false,
+		"rssi":          -80.0,
+	}
+	for name, value := range expected {
+		if metric.Fields[name] != value {
+			t.Errorf("Expected %s to be %v, got %v", name, value, metric.Fields[name])
+		}
+	}
+	if _, exists := metric.Fields["label"]; exists {
+		t.Error("Expected strings of the decoded payload to be skipped")
+	}
+}
+
+func TestProcessUplinkWithoutDecodedPayload(t *testing.T) {
+	module, err := NewLoRaWANModule(Config{})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 1)
+	module.metricsCh = ch
+
+	module.processMessage([]byte(`{"end_device_ids": {"device_id": "soil-1"}, "uplink_message": {"f_cnt": 1, "frm_payload": "AQ=="}}`))
+	if len(ch) != 0 {
+		t.Errorf("Expected no metric without decoded payload, got %+v", <-ch)
+	}
+}
+
+func TestDecodeTemplate(t *testing.T) {
+	template := []TemplateField{
+		{Name: "temperature", Offset: 0, Type: "int16", Scale: 0.01},
+		{Name: "battery", Offset: 2, Type: "uint16", LittleEndian: true, Scale: 0.001},
+		{Name: "moisture", Offset: 4, Type: "uint8", Add: -10},
+		{Name: "missing", Offset: 5, Type: "uint32"},
+	}
+	fields := decodeTemplate([]byte{0xFF, 0x38, 0x10, 0x0E, 0x37}, template)
+
+	if value := fields["temperature"].(float64); math.Abs(value+2) > 1e-9 {
+		t.Errorf("Expected temperature -2, got %v", value)
+	}
+	if value := fields["battery"].(float64); math.Abs(value-3.6) > 1e-9 {
+		t.Errorf("Expected battery 3.6, got %v", value)
+	}
+	if fields["moisture"] != 45.0 {
+		t.Errorf("Expected moisture 45, got %v", fields["moisture"])
+	}
+	if _, exists := fields["missing"]; exists {
+		t.Error("Expected fields beyond the payload to be left out")
+	}
+}
+
+func TestDecodeCayenneLPP(t *testing.T) {
+	// GPS on channel 1: 42.3519°, -87.9094°, 10 m
+	fields, err := decodeCayenneLPP([]byte{0x01, 0x88, 0x06, 0x76, 0x5F, 0xF2, 0x96, 0x0A, 0x00, 0x03, 0xE8})
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	for name, expected := range map[string]float64{"gps_1_latitude": 42.3519, "gps_1_longitude": -87.9094, "gps_1_altitude": 10} {
+		if value := fields[name].(float64); math.Abs(value-expected) > 1e-9 {
+			t.Errorf("Expected %s to be %v, got %v", name, expected, value)
+		}
+	}
+
+	if _, err := decodeCayenneLPP([]byte{0x01, 0x67, 0x01}); err == nil {
+		t.Error("Expected error for truncated payload")
+	}
+	if _, err := decodeCayenneLPP([]byte{0x01, 0xFE, 0x01}); err == nil {
+		t.Error("Expected error for unsupported type")
+	}
+}
@@ -0,0 +1,519 @@
+// Package meter provides a metric collection module for water and gas meters.
+// It subscribes to MQTT topics delivering either absolute meter readings
+// (e.g. from AI-on-the-edge devices) or pulses from reed contacts and converts
+// them into cumulative "water"/"gas" measurements.
+package meter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/connection"
+	mqttselftest "github.com/janhuddel/metrics-agent/internal/selftest/mqtt"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// Meter sources
+	sourceValue   = "value"   // Payload is the absolute meter reading (AI-on-the-edge)
+	sourcePulse   = "pulse"   // Every message is a single pulse
+	sourceCounter = "counter" // Payload is a cumulative pulse count of the device
+
+	// Meter types (also used as metric names)
+	meterTypeWater = "water"
+	meterTypeGas   = "gas"
+
+	metricSendTimeout = 1 * time.Second
+)
+
+// Config represents the configuration for the meter module
+type Config struct {
+	config.BaseConfig
+	config.MQTTOptions // qos, clean_session, max_in_flight
+
+	Broker   string          `json:"broker"`    // MQTT broker address (e.g., "tcp://localhost:1883")
+	Username string          `json:"username"`  // MQTT username (optional)
+	Password string          `json:"password"`  // MQTT password (optional)
+	ClientID string          `json:"client_id"` // MQTT client ID (optional, defaults to hostname)
+	Timeout  config.Duration `json:"timeout"`   // Connection timeout (defaults to 30s)
+	Meters   []MeterConfig   `json:"meters"`    // Meters to ingest
+}
+
+// MeterConfig describes a single water or gas meter
+type MeterConfig struct {
+	Name           string          `json:"name"`             // Unique meter identifier, used as device tag
+	Type           string          `json:"type"`             // "water" or "gas"
+	Topic          string          `json:"topic"`            // MQTT topic delivering readings or pulses
+	Source         string          `json:"source"`           // "value", "pulse" or "counter"
+	VolumePerPulse float64         `json:"volume_per_pulse"` // Volume per pulse in m³ (pulse/counter sources)
+	InitialValue   float64         `json:"initial_value"`    // Meter reading in m³ when pulse counting started
+	Debounce       config.Duration `json:"debounce"`         // Minimum time between two pulses (pulse source)
+	MaxDelta       float64         `json:"max_delta"`        // Maximum plausible increase per reading in m³ (value source, 0 = unlimited)
+}
+
+// meterState tracks the runtime state of a meter
+type meterState struct {
+	config    MeterConfig
+	debounce  time.Duration
+	lastPulse time.Time
+}
+
+// MeterModule handles MQTT subscriptions and counter bookkeeping
+type MeterModule struct {
+	config    Config
+	client    mqtt.Client
+	storage   *utils.Storage
+	metricsCh chan<- metrics.Metric
+	clock     utils.Clock
+	meters    map[string]*meterState // keyed by topic
+	inFlight  utils.Semaphore        // Limits concurrently processed messages
+	mu        sync.Mutex
+}
+
+// Run starts the meter module and begins collecting metrics
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	storage, err := utils.NewStorage(config.InstanceName("meter"))
+	if err != nil {
+		return fmt.Errorf("failed to create storage: %w", err)
+	}
+
+	module, err := NewMeterModule(config, storage)
+	if err != nil {
+		return fmt.Errorf("failed to create meter module: %w", err)
+	}
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+
+	return module.run(ctx)
+}
+
+// Probe validates the meter configuration and checks that the MQTT broker is reachable
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	if _, err := NewMeterModule(cfg, nil); err != nil {
+		return &config.ModuleError{Module: "meter", Err: err}
+	}
+	return utils.ProbeURL(ctx, cfg.Broker, cfg.Timeout.Duration())
+}
+
+// SelfTest checks that the MQTT broker accepts the configured credentials
+func SelfTest(ctx context.Context) []utils.Check {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return []utils.Check{{Name: "configuration", Err: err}}
+	}
+	return []utils.Check{mqttselftest.CheckLogin(ctx, cfg.Broker, cfg.Username, cfg.Password, cfg.Timeout.Duration())}
+}
+
+// NewMeterModule creates a new meter module instance
+func NewMeterModule(cfg Config, storage *utils.Storage) (*MeterModule, error) {
+	utils.Debugf("Creating new meter module instance")
+
+	if len(cfg.Meters) == 0 {
+		return nil, fmt.Errorf("meters is required but not configured")
+	}
+	if err := cfg.MQTTOptions.Validate(); err != nil {
+		return nil, err
+	}
+
+	meters := make(map[string]*meterState, len(cfg.Meters))
+	for _, meterConfig := range cfg.Meters {
+		state, err := newMeterState(meterConfig)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := meters[meterConfig.Topic]; exists {
+			return nil, fmt.Errorf("topic %s is configured for more than one meter", meterConfig.Topic)
+		}
+		meters[meterConfig.Topic] = state
+	}
+
+	utils.Debugf("Meter module created successfully with %d meters", len(meters))
+	return &MeterModule{
+		clock:    utils.SystemClock,
+		config:   cfg,
+		storage:  storage,
+		meters:   meters,
+		inFlight: utils.NewSemaphore(cfg.MaxInFlight),
+	}, nil
+}
+
+// newMeterState validates a meter configuration and applies defaults
+func newMeterState(meterConfig MeterConfig) (*meterState, error) {
+	if meterConfig.Name == "" {
+		return nil, fmt.Errorf("meter name is required")
+	}
+	if meterConfig.Topic == "" {
+		return nil, fmt.Errorf("topic is required for meter %s", meterConfig.Name)
+	}
+
+	switch meterConfig.Type {
+	case meterTypeWater, meterTypeGas:
+	default:
+		return nil, fmt.Errorf("unsupported type %q for meter %s", meterConfig.Type, meterConfig.Name)
+	}
+
+	switch meterConfig.Source {
+	case "":
+		meterConfig.Source = sourceValue
+	case sourceValue, sourcePulse, sourceCounter:
+	default:
+		return nil, fmt.Errorf("unsupported source %q for meter %s", meterConfig.Source, meterConfig.Name)
+	}
+
+	if meterConfig.Source != sourceValue && meterConfig.VolumePerPulse <= 0 {
+		return nil, fmt.Errorf("volume_per_pulse is required for meter %s", meterConfig.Name)
+	}
+
+	state := &meterState{config: meterConfig}
+	if meterConfig.Debounce < 0 {
+		return nil, fmt.Errorf("invalid debounce for meter %s: %v", meterConfig.Name, meterConfig.Debounce)
+	}
+	state.debounce = meterConfig.Debounce.Duration()
+
+	return state, nil
+}
+
+// DefaultConfig returns the default configuration of the meter module.
+func DefaultConfig() Config {
+	return Config{
+		MQTTOptions: config.MQTTOptions{
+			QoS:          1,
+			CleanSession: true, // Subscriptions are recreated in the connect handler
+		},
+		Broker:  "tcp://localhost:1883",
+		Timeout: config.Duration(30 * time.Second),
+	}
+}
+
+// LoadConfig loads the meter module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("meter")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
+
+// run executes the main module loop
+func (mm *MeterModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Meter module", "main", func() error {
+		if err := mm.connect(ctx); err != nil {
+			return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+		}
+		defer func() {
+			if mm.client.IsConnected() {
+				mm.client.Disconnect(250)
+			}
+		}()
+
+		<-ctx.Done()
+		return nil
+	})
+}
+
+// connect establishes the MQTT connection; subscriptions are (re)created in the connect handler
+func (mm *MeterModule) connect(ctx context.Context) error {
+	clientID := mm.config.ClientID
+	if clientID == "" {
+		hostname, _ := os.Hostname()
+		clientID = hostname + "-" + mm.config.InstanceName("meter")
+	}
+
+	tracker := connection.NewTracker(mm.config.InstanceName("meter"), mm.config.Broker, mm.metricsCh)
+	if err := utils.CheckDestination(ctx, mm.config.Broker); err != nil {
+		utils.AuditConnect(mm.config.InstanceName("meter"), "mqtt", mm.config.Broker, 0, err)
+		tracker.SetConnected(false)
+		return err
+	}
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(mm.config.Broker)
+	opts.SetClientID(clientID)
+	opts.SetUsername(mm.config.Username)
+	opts.SetPassword(mm.config.Password)
+	opts.SetConnectTimeout(mm.config.Timeout.Duration())
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(5 * time.Minute)
+	opts.SetCleanSession(mm.config.CleanSession)
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		utils.Errorf("MQTT connection lost: %v", err)
+		tracker.SetConnected(false)
+	})
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		utils.WithPanicRecoveryAndContinue("MQTT connect handler", "broker", func() {
+			utils.Infof("Connected to MQTT broker: %s", mm.config.Broker)
+			utils.AuditConnect(mm.config.InstanceName("meter"), "mqtt", mm.config.Broker, 0, nil)
+			tracker.SetConnected(true)
+			for topic := range mm.meters {
+				token := client.Subscribe(topic, byte(mm.config.QoS), mm.handleMessage)
+				go func(topic string) {
+					if token.Wait() && token.Error() != nil {
+						utils.Errorf("Failed to subscribe to meter topic %s: %v", topic, token.Error())
+					} else {
+						utils.Debugf("Subscribed to meter topic: %s", topic)
+					}
+				}(topic)
+			}
+		})
+	})
+
+	mm.client = mqtt.NewClient(opts)
+
+	connChan := make(chan error, 1)
+	go func() {
+		token := mm.client.Connect()
+		token.Wait()
+		connChan <- token.Error()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-connChan:
+		if err != nil {
+			utils.AuditConnect(mm.config.InstanceName("meter"), "mqtt", mm.config.Broker, 0, err)
+			tracker.SetConnected(false)
+		}
+		return err
+	}
+}
+
+// handleMessage is the MQTT message handler for all meter topics
+func (mm *MeterModule) handleMessage(client mqtt.Client, msg mqtt.Message) {
+	received := mm.clock.Now() // Pulse debouncing uses the arrival time, not the time processing starts
+	mm.inFlight.Do(func() {
+		utils.WithPanicRecoveryAndContinue("Meter message handler", msg.Topic(), func() {
+			mm.processMessage(msg.Topic(), msg.Payload(), received)
+		})
+	})
+}
+
+// processMessage updates the meter bound to the topic and emits a metric
+func (mm *MeterModule) processMessage(topic string, payload []byte, now time.Time) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	state, exists := mm.meters[topic]
+	if !exists {
+		utils.Debugf("Ignoring message for unknown meter topic: %s", topic)
+		return
+	}
+
+	var (
+		fields map[string]interface{}
+		err    error
+	)
+	switch state.config.Source {
+	case sourcePulse:
+		fields, err = mm.processPulse(state, now)
+	case sourceCounter:
+		fields, err = mm.processCounter(state, payload)
+	default:
+		fields, err = mm.processValue(state, payload)
+	}
+
+	if err != nil {
+		utils.Warnf("Ignoring reading for meter %s: %v", state.config.Name, err)
+		return
+	}
+	if fields == nil {
+		return
+	}
+
+	mm.sendMetric(state, fields, now)
+}
+
+// processValue handles absolute meter readings and rejects implausible values
+func (mm *MeterModule) processValue(state *meterState, payload []byte) (map[string]interface{}, error) {
+	value, err := parseReading(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	key := state.config.Name + ".value"
+	if mm.storage.Exists(key) {
+		last := mm.storage.GetFloat64(key)
+		if value < last {
+			return nil, fmt.Errorf("reading %.4f is lower than last reading %.4f", value, last)
+		}
+		if state.config.MaxDelta > 0 && value-last > state.config.MaxDelta {
+			return nil, fmt.Errorf("reading %.4f exceeds max_delta from last reading %.4f", value, last)
+		}
+	}
+
+	if err := mm.storage.Set(key, value); err != nil {
+		utils.Warnf("Failed to persist reading for meter %s: %v", state.config.Name, err)
+	}
+
+	return map[string]interface{}{"volume_total": value}, nil
+}
+
+// processPulse counts a single debounced pulse
+func (mm *MeterModule) processPulse(state *meterState, now time.Time) (map[string]interface{}, error) {
+	if state.debounce > 0 && !state.lastPulse.IsZero() && now.Sub(state.lastPulse) < state.debounce {
+		utils.Debugf("Debounced pulse for meter %s", state.config.Name)
+		return nil, nil
+	}
+	state.lastPulse = now
+
+	var pulses int
+	if err := mm.storage.Update(state.config.Name+".pulses", func(old interface{}) interface{} {
+		pulses = utils.IntValue(old) + 1
+		return pulses
+	}); err != nil {
+		utils.Warnf("Failed to persist pulses for meter %s: %v", state.config.Name, err)
+	}
+
+	return mm.pulseFields(state, pulses), nil
+}
+
+// processCounter handles cumulative pulse counts, carrying the total across device counter resets
+func (mm *MeterModule) processCounter(state *meterState, payload []byte) (map[string]interface{}, error) {
+	reading, err := parseCount(payload)
+	if err != nil {
+		return nil, err
+	}
+	count := int(reading)
+
+	pulsesKey := state.config.Name + ".pulses"
+	lastKey := state.config.Name + ".counter_last"
+
+	pulses := mm.storage.GetInt(pulsesKey)
+	if mm.storage.Exists(lastKey) {
+		last := mm.storage.GetInt(lastKey)
+		if count >= last {
+			pulses += count - last
+		} else {
+			// Device counter was reset (e.g. reboot), continue counting from zero
+			utils.Infof("Counter reset detected for meter %s (%d -> %d)", state.config.Name, last, count)
+			pulses += count
+		}
+	}
+
+	// Persist both in one write, so a crash can't count the same pulses twice
+	if err := mm.storage.SetMany(map[string]interface{}{pulsesKey: pulses, lastKey: count}); err != nil {
+		utils.Warnf("Failed to persist pulses for meter %s: %v", state.config.Name, err)
+	}
+
+	return mm.pulseFields(state, pulses), nil
+}
+
+// pulseFields converts a pulse total into metric fields
+func (mm *MeterModule) pulseFields(state *meterState, pulses int) map[string]interface{} {
+	return map[string]interface{}{
+		"pulses":       pulses,
+		"volume_total": state.config.InitialValue + float64(pulses)*state.config.VolumePerPulse,
+	}
+}
+
+// parseReading extracts a numeric reading from a plain or JSON payload.
+func parseReading(payload []byte) (float64, error) {
+	number, err := parseNumber(payload)
+	if err != nil {
+		return 0, err
+	}
+	return number.Float64()
+}
+
+// parseCount extracts a cumulative count from a plain or JSON payload. The count
+// is parsed as integer, so counts beyond 2^53 keep their precision instead of
+// being rounded by a float64; a fraction is truncated.
+func parseCount(payload []byte) (int64, error) {
+	number, err := parseNumber(payload)
+	if err != nil {
+		return 0, err
+	}
+	if count, err := number.Int64(); err == nil {
+		return count, nil
+	}
+	value, err := number.Float64()
+	if err != nil {
+		return 0, err
+	}
+	return int64(value), nil
+}
+
+// parseNumber extracts a number from a plain or JSON payload as it was sent.
+// AI-on-the-edge JSON payloads report the value as a string and set "error" on failed readings.
+func parseNumber(payload []byte) (json.Number, error) {
+	text := strings.TrimSpace(string(payload))
+	if _, err := strconv.ParseFloat(text, 64); err == nil {
+		return json.Number(text), nil
+	}
+
+	var data map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return "", fmt.Errorf("unsupported payload: %s", text)
+	}
+
+	if errText, ok := data["error"].(string); ok && errText != "" && !strings.EqualFold(errText, "no error") {
+		return "", fmt.Errorf("device reported error: %s", errText)
+	}
+
+	switch value := data["value"].(type) {
+	case json.Number:
+		return value, nil
+	case string:
+		value = strings.TrimSpace(value)
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "", fmt.Errorf("invalid value: %s", value)
+		}
+		return json.Number(value), nil
+	default:
+		return "", fmt.Errorf("payload has no value field: %s", text)
+	}
+}
+
+// sendMetric creates and sends a metric for a meter
+func (mm *MeterModule) sendMetric(state *meterState, fields map[string]interface{}, timestamp time.Time) {
+	metric := metrics.Metric{
+		Name: state.config.Type,
+		Tags: map[string]string{
+			"vendor":   "meter",
+			"device":   state.config.Name,
+			"friendly": mm.config.GetFriendlyName(state.config.Name, "", state.config.Name),
+		},
+		Fields:    fields,
+		Kind:      metrics.KindCounter, // meters only report their running total
+		Timestamp: timestamp,
+	}
+
+	if err := metric.Validate(); err != nil {
+		utils.Warnf("Invalid metric for meter %s: %v", state.config.Name, err)
+		return
+	}
+
+	select {
+	case mm.metricsCh <- metric:
+	case <-time.After(metricSendTimeout):
+		utils.Warnf("Metric channel full, dropping metric for meter %s", state.config.Name)
+	}
+}
@@ -0,0 +1,178 @@
+package meter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// newTestModule creates a meter module with temporary storage and a buffered metrics channel
+func newTestModule(t *testing.T, meters ...MeterConfig) (*MeterModule, chan metrics.Metric) {
+	t.Helper()
+
+	th := utils.NewTestHelper()
+	storage, err := th.CreateTempStorage("meter")
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	t.Cleanup(func() { th.CleanupTempStorage(storage) })
+
+	module, err := NewMeterModule(Config{Meters: meters}, storage)
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.metricsCh = ch
+	return module, ch
+}
+
+func TestNewMeterModuleValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		meter MeterConfig
+	}{
+		{"missing name", MeterConfig{Type: "water", Topic: "t"}},
+		{"missing topic", MeterConfig{Name: "m", Type: "water"}},
+		{"invalid type", MeterConfig{Name: "m", Type: "power", Topic: "t"}},
+		{"invalid source", MeterConfig{Name: "m", Type: "gas", Topic: "t", Source: "gpio"}},
+		{"pulse without volume", MeterConfig{Name: "m", Type: "gas", Topic: "t", Source: "pulse"}},
+		{"invalid debounce", MeterConfig{Name: "m", Type: "gas", Topic: "t", Source: "pulse", VolumePerPulse: 0.01, Debounce: config.Duration(-time.Second)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewMeterModule(Config{Meters: []MeterConfig{tt.meter}}, nil); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+
+	if _, err := NewMeterModule(Config{}, nil); err == nil {
+		t.Error("Expected error when no meters are configured")
+	}
+
+	valid := MeterConfig{Name: "m", Type: "water", Topic: "t"}
+	invalidQoS := Config{MQTTOptions: config.MQTTOptions{QoS: 3}, Meters: []MeterConfig{valid}}
+	if _, err := NewMeterModule(invalidQoS, nil); err == nil {
+		t.Error("Expected error for invalid qos")
+	}
+}
+
+func TestProcessValue(t *testing.T) {
+	module, ch := newTestModule(t, MeterConfig{Name: "watermeter", Type: "water", Topic: "watermeter/main/json", MaxDelta: 1})
+	now := time.Now()
+
+	module.processMessage("watermeter/main/json", []byte(`{"value":"123.4560","raw":"00123.4560","error":"no error","rate":"0.001"}`), now)
+	m := <-ch
+	if m.Name != "water" || m.Tags["device"] != "watermeter" {
+		t.Errorf("Unexpected metric: %+v", m)
+	}
+	if m.Fields["volume_total"] != 123.456 {
+		t.Errorf("Expected volume_total 123.456, got %v", m.Fields["volume_total"])
+	}
+
+	// Decreasing, implausible and failed readings are dropped
+	module.processMessage("watermeter/main/json", []byte(`123.1`), now)
+	module.processMessage("watermeter/main/json", []byte(`200`), now)
+	module.processMessage("watermeter/main/json", []byte(`{"value":"","error":"Neg. Rate - Read: 123.1"}`), now)
+	if len(ch) != 0 {
+		t.Errorf("Expected invalid readings to be dropped, got %d metrics", len(ch))
+	}
+
+	module.processMessage("watermeter/main/json", []byte(`123.5`), now)
+	if m := <-ch; m.Fields["volume_total"] != 123.5 {
+		t.Errorf("Expected volume_total 123.5, got %v", m.Fields["volume_total"])
+	}
+}
+
+func TestProcessPulseDebounce(t *testing.T) {
+	module, ch := newTestModule(t, MeterConfig{
+		Name: "gasmeter", Type: "gas", Topic: "gas/pulse", Source: "pulse",
+		VolumePerPulse: 0.01, InitialValue: 1000, Debounce: config.Duration(500 * time.Millisecond),
+	})
+	start := time.Now()
+
+	module.processMessage("gas/pulse", []byte("1"), start)
+	module.processMessage("gas/pulse", []byte("1"), start.Add(100*time.Millisecond)) // bounce
+	module.processMessage("gas/pulse", []byte("1"), start.Add(time.Second))
+
+	if len(ch) != 2 {
+		t.Fatalf("Expected 2 metrics after debounce, got %d", len(ch))
+	}
+	<-ch
+	m := <-ch
+	if m.Name != "gas" || m.Fields["pulses"] != 2 {
+		t.Errorf("Expected 2 pulses, got %v", m.Fields["pulses"])
+	}
+	if total := m.Fields["volume_total"].(float64); math.Abs(total-1000.02) > 1e-9 {
+		t.Errorf("Expected volume_total 1000.02, got %v", total)
+	}
+}
+
+func TestProcessCounterReset(t *testing.T) {
+	module, ch := newTestModule(t, MeterConfig{
+		Name: "water", Type: "water", Topic: "tele/counter", Source: "counter", VolumePerPulse: 0.001,
+	})
+	now := time.Now()
+
+	for _, payload := range []string{"100", "150", "5", "10"} {
+		module.processMessage("tele/counter", []byte(payload), now)
+	}
+
+	var last metrics.Metric
+	for len(ch) > 0 {
+		last = <-ch
+	}
+	// 0 (first reading sets the baseline) + 50 + 5 (after reset) + 5 = 60 pulses
+	if last.Fields["pulses"] != 60 {
+		t.Errorf("Expected 60 pulses, got %v", last.Fields["pulses"])
+	}
+}
+
+func TestParseReading(t *testing.T) {
+	tests := []struct {
+		payload  string
+		expected float64
+		wantErr  bool
+	}{
+		{"42.5", 42.5, false},
+		{" 7 \n", 7, false},
+		{`{"value": 3.25}`, 3.25, false},
+		{`{"value": "3.25", "error": "no error"}`, 3.25, false},
+		{`{"value": "3.25", "error": "Rate too high"}`, 0, true},
+		{`{"raw": "1"}`, 0, true},
+		{"ON", 0, true},
+	}
+
+	for _, tt := range tests {
+		value, err := parseReading([]byte(tt.payload))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseReading(%q) error = %v, wantErr %v", tt.payload, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && value != tt.expected {
+			t.Errorf("parseReading(%q) = %v, expected %v", tt.payload, value, tt.expected)
+		}
+	}
+}
+
+func TestParseCount(t *testing.T) {
+	// Counts beyond 2^53 can't be represented exactly as float64
+	count, err := parseCount([]byte("9007199254740993"))
+	if err != nil || count != 9007199254740993 {
+		t.Errorf("Expected exact count 9007199254740993, got %d (%v)", count, err)
+	}
+	if count, err := parseCount([]byte(`{"value": 9007199254740993}`)); err != nil || count != 9007199254740993 {
+		t.Errorf("Expected exact count from JSON, got %d (%v)", count, err)
+	}
+	if count, err := parseCount([]byte(`{"value": "12.7"}`)); err != nil || count != 12 {
+		t.Errorf("Expected truncated count 12, got %d (%v)", count, err)
+	}
+	if _, err := parseCount([]byte("ON")); err == nil {
+		t.Error("Expected error for non-numeric payload")
+	}
+}
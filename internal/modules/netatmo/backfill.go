@@ -0,0 +1,183 @@
+package netatmo
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// stationsDataEndpoint lists the weather stations with their current values
+	stationsDataEndpoint = "/api/getstationsdata"
+
+	// measureEndpoint returns the measurement history of a station or module
+	measureEndpoint = "/api/getmeasure"
+
+	// measureLimit is the maximum number of measurements getmeasure returns per request
+	measureLimit = 1024
+)
+
+// measureTypes are the measurements backfilled per module type, in the order
+// of the values returned by getmeasure
+var measureTypes = map[string][]string{
+	"NAMain":    {"Temperature", "Humidity", "CO2", "Noise", "Pressure"},               // Indoor base station
+	"NAModule1": {"Temperature", "Humidity"},                                           // Outdoor module
+	"NAModule4": {"Temperature", "Humidity", "CO2"},                                    // Additional indoor module
+	"NHC":       {"Temperature", "Humidity", "CO2", "Noise", "Pressure", "health_idx"}, // Healthy Home Coach
+}
+
+// MeasureData represents the response of the getmeasure endpoint with
+// optimize=false: values by Unix timestamp, null if not measured
+type MeasureData struct {
+	Body   map[string][]*float64 `json:"body"`
+	Status string                `json:"status"`
+}
+
+// backfillStart returns the start of the history to backfill: the last
+// collection of the previous run, but at most the backfill window ago. On the
+// first run (last is zero) the whole window is backfilled.
+func (nm *NetatmoModule) backfillStart(last time.Time) time.Time {
+	start := nm.clock.Now().Add(-nm.config.Backfill.Duration())
+	if last.After(start) {
+		return last
+	}
+	return start
+}
+
+// backfill sends the measurements of all weather stations, their modules and
+// home coaches taken since the given time, so downtime doesn't leave holes.
+func (nm *NetatmoModule) backfill(ctx context.Context, data *StationData, since time.Time) {
+	for _, device := range data.Body.Devices {
+		// Home coaches have a name instead of a station name
+		stationName := device.StationName
+		if stationName == "" {
+			stationName = device.Name
+		}
+		name := nm.config.GetFriendlyName(device.ID, stationName, stationName)
+		nm.backfillModule(ctx, device.ID, "", device.Type, name, since, device.DashboardData.TimeUTC)
+
+		for _, module := range device.Modules {
+			moduleName := nm.config.GetFriendlyName(module.ID, module.ModuleName, module.ModuleName)
+			nm.backfillModule(ctx, device.ID, module.ID, module.Type, moduleName, since, module.DashboardData.TimeUTC)
+		}
+	}
+}
+
+// backfillModule sends the measurements of a station (moduleID empty) or module
+// taken after since and before its current values, which are sent as usual.
+// Long windows are requested in pages of measureLimit measurements.
+func (nm *NetatmoModule) backfillModule(ctx context.Context, deviceID, moduleID, moduleType, friendlyName string, since time.Time, current int64) {
+	types, ok := measureTypes[moduleType]
+	if !ok {
+		return
+	}
+	id := deviceID
+	if moduleID != "" {
+		id = moduleID
+	}
+
+	sent := 0
+	begin := since.Unix() + 1
+	for begin < current {
+		query := url.Values{}
+		query.Set("device_id", deviceID)
+		if moduleID != "" {
+			query.Set("module_id", moduleID)
+		}
+		query.Set("scale", "max")
+		query.Set("type", strings.Join(types, ","))
+		query.Set("date_begin", strconv.FormatInt(begin, 10))
+		query.Set("date_end", strconv.FormatInt(current-1, 10))
+		query.Set("limit", strconv.Itoa(measureLimit))
+		query.Set("optimize", "false")
+
+		var measures MeasureData
+		if err := nm.get(ctx, measureEndpoint+"?"+query.Encode(), &measures); err != nil {
+			utils.Warnf("Failed to backfill measurements of %s: %v", friendlyName, err)
+			break
+		}
+		if measures.Status != "ok" {
+			utils.Warnf("Failed to backfill measurements of %s: API returned non-ok status: %s", friendlyName, measures.Status)
+			break
+		}
+
+		page := measureMetrics(id, friendlyName, types, &measures)
+		for _, metric := range page {
+			select {
+			case nm.metricsCh <- metric:
+			case <-ctx.Done():
+				return
+			}
+		}
+		sent += len(page)
+		if len(measures.Body) < measureLimit || len(page) == 0 {
+			break
+		}
+		begin = page[len(page)-1].Timestamp.Unix() + 1
+	}
+
+	if sent > 0 {
+		utils.Infof("Backfilled %d measurements of %s since %s", sent, friendlyName, since.Format(time.RFC3339))
+	}
+}
+
+// measureMetrics converts a getmeasure response into climate metrics ordered
+// by time. types are the requested measurement types.
+func measureMetrics(deviceID, friendlyName string, types []string, measures *MeasureData) []metrics.Metric {
+	timestamps := make([]int64, 0, len(measures.Body))
+	for key := range measures.Body {
+		timestamp, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			utils.Debugf("Ignoring measurement with invalid timestamp %q", key)
+			continue
+		}
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	result := make([]metrics.Metric, 0, len(timestamps))
+	for _, timestamp := range timestamps {
+		values := measures.Body[strconv.FormatInt(timestamp, 10)]
+		var data Dashboard
+		for i, value := range values {
+			if i >= len(types) || value == nil {
+				continue
+			}
+			setMeasure(&data, types[i], *value)
+		}
+		if metric, ok := deviceMetric(deviceID, friendlyName, &data, time.Unix(timestamp, 0)); ok {
+			result = append(result, metric)
+		}
+	}
+	return result
+}
+
+// setMeasure sets the dashboard value of a measurement type.
+func setMeasure(data *Dashboard, measureType string, value float64) {
+	switch measureType {
+	case "Temperature":
+		data.Temperature = &value
+	case "Humidity":
+		humidity := int(value)
+		data.Humidity = &humidity
+	case "CO2":
+		co2 := int(value)
+		data.CO2 = &co2
+	case "Noise":
+		noise := int(value)
+		data.Noise = &noise
+	case "Pressure":
+		data.Pressure = &value
+	case "health_idx":
+		index := int(value)
+		data.HealthIdx = &index
+	default:
+		utils.Debugf("Ignoring unknown measurement type %s", measureType)
+	}
+}
@@ -0,0 +1,124 @@
+package netatmo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/testutil"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestMeasureMetrics(t *testing.T) {
+	var measures MeasureData
+	payload := `{"status": "ok", "body": {
+		"1760608800": [21.5, 48, 612, 38, 1015.2],
+		"1760608500": [21.4, 47, null, 37, 1015.1],
+		"invalid": [1, 2, 3, 4, 5]
+	}}`
+	if err := json.Unmarshal([]byte(payload), &measures); err != nil {
+		t.Fatalf("Failed to parse measures: %v", err)
+	}
+
+	result := measureMetrics("70:ee:50:00:00:10", "Indoor", measureTypes["NAMain"], &measures)
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(result))
+	}
+
+	first := result[0]
+	if !first.Timestamp.Equal(time.Unix(1760608500, 0)) {
+		t.Errorf("Expected metrics ordered by time, got %v first", first.Timestamp)
+	}
+	if first.Name != "climate" || first.Tags["device"] != "70:ee:50:00:00:10" || first.Tags["friendly"] != "Indoor" {
+		t.Errorf("Expected climate metric of the station, got %v", first)
+	}
+	if !metrics.IsMissing(first.Fields["co2"]) {
+		t.Errorf("Expected missing values to be marked as missing, got %v", first.Fields)
+	}
+	fields := metrics.ResolveMissing(result[1].Fields, metrics.MissingOmit)
+	expected := map[string]interface{}{"temperature": 21.5, "humidity": 48, "co2": 612, "noise": 38, "pressure": 1015.2}
+	for field, value := range expected {
+		if fields[field] != value {
+			t.Errorf("Expected %s %v, got %v", field, value, fields[field])
+		}
+	}
+}
+
+func TestBackfillPages(t *testing.T) {
+	const step = 300 // Netatmo stations measure every 5 minutes
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		query := r.URL.Query()
+		if r.URL.Path != measureEndpoint || query.Get("module_id") != "02:00:00:00:00:20" || query.Get("type") != "Temperature,Humidity" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		begin, _ := strconv.ParseInt(query.Get("date_begin"), 10, 64)
+		end, _ := strconv.ParseInt(query.Get("date_end"), 10, 64)
+
+		body := make(map[string][]float64)
+		for ts := (begin + step - 1) / step * step; ts <= end && len(body) < measureLimit; ts += step {
+			body[strconv.FormatInt(ts, 10)] = []float64{12.5, 80}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "body": body})
+	}))
+	defer server.Close()
+
+	now := time.Unix(1760608800, 0)
+	module, err := NewNetatmoModule(Config{
+		BaseConfig:   config.BaseConfig{Instance: "backfill-test"},
+		ClientID:     "backfill_test_client",
+		ClientSecret: "secret",
+		Backfill:     config.Duration(7 * 24 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	module.oauth2.Storage().Set("oauth2_token", map[string]interface{}{
+		"access_token":  "token",
+		"refresh_token": "refresh",
+		"expires_at":    time.Now().Add(time.Hour).Format(time.RFC3339),
+		"client_id":     "backfill_test_client",
+	})
+	module.baseURL = server.URL
+	module.clock = testutil.NewClock(now)
+	metricsCh := make(chan metrics.Metric, 2*measureLimit)
+	module.metricsCh = metricsCh
+
+	// Without a previous collection, the whole window is backfilled
+	since := module.backfillStart(time.Time{})
+	if !since.Equal(now.Add(-7 * 24 * time.Hour)) {
+		t.Errorf("Expected backfill to start a week ago, got %v", since)
+	}
+	// A recent previous collection limits the backfill to the gap
+	if start := module.backfillStart(now.Add(-time.Hour)); !start.Equal(now.Add(-time.Hour)) {
+		t.Errorf("Expected backfill to start at the last collection, got %v", start)
+	}
+
+	// 1500 measurements don't fit into a single request
+	since = now.Add(-1500 * step * time.Second)
+	module.backfillModule(context.Background(), "70:ee:50:00:00:10", "02:00:00:00:00:20", "NAModule1", "Outdoor", since, now.Unix())
+
+	if requests != 2 {
+		t.Errorf("Expected 2 requests, got %d", requests)
+	}
+	// The connection tracker reports the API status on the same channel
+	var backfilled []metrics.Metric
+	for len(metricsCh) > 0 {
+		if m := <-metricsCh; m.Name == "climate" {
+			backfilled = append(backfilled, m)
+		}
+	}
+	if len(backfilled) != 1499 {
+		t.Fatalf("Expected 1499 backfilled measurements before the current one, got %d", len(backfilled))
+	}
+	first := backfilled[0]
+	if first.Tags["device"] != "02:00:00:00:00:20" || *first.Fields["temperature"].(*float64) != 12.5 || first.Timestamp.Unix() <= since.Unix() {
+		t.Errorf("Unexpected first backfilled metric %v", first)
+	}
+}
@@ -0,0 +1,177 @@
+package netatmo
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// metricNameHeating is the measurement of thermostats and valves
+	metricNameHeating = "heating"
+
+	// homesDataEndpoint lists the homes with their rooms and modules
+	homesDataEndpoint = "/api/homesdata"
+)
+
+// HomesData represents the response of the homesdata endpoint
+type HomesData struct {
+	Body struct {
+		Homes []Home `json:"homes"`
+	} `json:"body"`
+	Status string `json:"status"`
+}
+
+// Home represents a Netatmo home with its rooms and modules
+type Home struct {
+	ID      string       `json:"id"`
+	Name    string       `json:"name"`
+	Rooms   []HomeRoom   `json:"rooms"`
+	Modules []HomeModule `json:"modules"`
+}
+
+// HomeRoom represents a room of a home
+type HomeRoom struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// HomeModule represents a module of a home (thermostat, valve, relay, gateway)
+type HomeModule struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// HomeStatus represents the response of the homestatus endpoint
+type HomeStatus struct {
+	Body struct {
+		Home struct {
+			ID      string         `json:"id"`
+			Rooms   []RoomStatus   `json:"rooms"`
+			Modules []ModuleStatus `json:"modules"`
+		} `json:"home"`
+	} `json:"body"`
+	Status string `json:"status"`
+}
+
+// RoomStatus represents the current heating state of a room
+type RoomStatus struct {
+	ID                  string   `json:"id"`
+	Reachable           bool     `json:"reachable"`
+	MeasuredTemperature *float64 `json:"therm_measured_temperature"`
+	SetpointTemperature *float64 `json:"therm_setpoint_temperature"`
+	SetpointMode        string   `json:"therm_setpoint_mode"`
+	HeatingPowerRequest *int     `json:"heating_power_request"` // Heating demand of the room's valves in percent
+}
+
+// ModuleStatus represents the current state of a thermostat or valve
+type ModuleStatus struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	Reachable    *bool  `json:"reachable"`
+	BoilerStatus *bool  `json:"boiler_status"` // Reported by thermostats and boiler relays
+	BatteryState string `json:"battery_state"`
+}
+
+// collectHeating fetches the homes and the heating state of their rooms and
+// thermostats and sends metrics
+func (nm *NetatmoModule) collectHeating(ctx context.Context, endpoint string) error {
+	var homesData HomesData
+	if err := nm.get(ctx, homesDataEndpoint, &homesData); err != nil {
+		return err
+	}
+	if homesData.Status != "ok" {
+		return fmt.Errorf("API returned non-ok status: %s", homesData.Status)
+	}
+
+	for _, home := range homesData.Body.Homes {
+		// Homes without thermostats or valves have no rooms with heating data
+		if len(home.Rooms) == 0 {
+			continue
+		}
+
+		var status HomeStatus
+		if err := nm.get(ctx, endpoint+"?home_id="+url.QueryEscape(home.ID), &status); err != nil {
+			return err
+		}
+		if status.Status != "ok" {
+			return fmt.Errorf("API returned non-ok status for home %s: %s", home.Name, status.Status)
+		}
+		nm.processHomeStatus(&home, &status, nm.clock.Now())
+	}
+	return nil
+}
+
+// processHomeStatus sends a heating metric for each room with a setpoint and
+// for each module reporting the boiler status
+func (nm *NetatmoModule) processHomeStatus(home *Home, status *HomeStatus, timestamp time.Time) {
+	roomNames := make(map[string]string, len(home.Rooms))
+	for _, room := range home.Rooms {
+		roomNames[room.ID] = room.Name
+	}
+	moduleNames := make(map[string]string, len(home.Modules))
+	for _, module := range home.Modules {
+		moduleNames[module.ID] = module.Name
+	}
+
+	for _, room := range status.Body.Home.Rooms {
+		// Rooms without setpoint have no thermostat or valve
+		if room.SetpointTemperature == nil {
+			continue
+		}
+
+		fields := map[string]interface{}{
+			"setpoint":       *room.SetpointTemperature,
+			"reachable":      room.Reachable,
+			"temperature":    room.MeasuredTemperature,
+			"heating_demand": room.HeatingPowerRequest,
+		}
+		if room.SetpointMode != "" {
+			fields["setpoint_mode"] = room.SetpointMode
+		}
+
+		nm.sendHeatingMetric(home.Name, room.ID, roomNames[room.ID], fields, timestamp)
+	}
+
+	for _, module := range status.Body.Home.Modules {
+		if module.BoilerStatus == nil {
+			continue
+		}
+		boilerStatus := 0
+		if *module.BoilerStatus {
+			boilerStatus = 1
+		}
+		fields := map[string]interface{}{"boiler_status": boilerStatus}
+		if module.Reachable != nil {
+			fields["reachable"] = *module.Reachable
+		}
+
+		nm.sendHeatingMetric(home.Name, module.ID, moduleNames[module.ID], fields, timestamp)
+	}
+}
+
+// sendHeatingMetric sends a heating metric for a room or module
+func (nm *NetatmoModule) sendHeatingMetric(homeName, deviceID, name string, fields map[string]interface{}, timestamp time.Time) {
+	metric := metrics.Metric{
+		Name: metricNameHeating,
+		Tags: map[string]string{
+			"vendor":   "netatmo",
+			"home":     homeName,
+			"device":   deviceID,
+			"friendly": nm.config.GetFriendlyName(deviceID, name, deviceID),
+		},
+		Fields:    fields,
+		Timestamp: timestamp,
+	}
+
+	select {
+	case nm.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping heating metric for device %s", deviceID)
+	}
+}
@@ -0,0 +1,78 @@
+package netatmo
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestProcessHomeStatus(t *testing.T) {
+	module, err := NewNetatmoModule(Config{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Scope:        "read_thermostat",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Netatmo module: %v", err)
+	}
+	module.config.FriendlyNameOverrides = map[string]string{"2255031728": "Bath"}
+	metricsCh := make(chan metrics.Metric, 10)
+	module.metricsCh = metricsCh
+
+	home := Home{
+		ID:   "5e1e",
+		Name: "Haus",
+		Rooms: []HomeRoom{
+			{ID: "2255031727", Name: "Living Room"},
+			{ID: "2255031728", Name: "Bathroom"},
+			{ID: "2255031729", Name: "Hallway"},
+		},
+		Modules: []HomeModule{{ID: "70:ee:50:00:00:10", Name: "Thermostat", Type: "NATherm1"}},
+	}
+
+	var status HomeStatus
+	payload := `{"status": "ok", "body": {"home": {"id": "5e1e",
+		"rooms": [
+			{"id": "2255031727", "reachable": true, "therm_measured_temperature": 20.5, "therm_setpoint_temperature": 21, "therm_setpoint_mode": "schedule", "heating_power_request": 40},
+			{"id": "2255031728", "reachable": false, "therm_setpoint_temperature": 23.5, "therm_setpoint_mode": "manual"},
+			{"id": "2255031729", "reachable": true}
+		],
+		"modules": [
+			{"id": "70:ee:50:00:00:10", "type": "NATherm1", "reachable": true, "boiler_status": true},
+			{"id": "09:00:00:00:00:20", "type": "NRV", "reachable": true, "battery_state": "full"}
+		]}}}`
+	if err := json.Unmarshal([]byte(payload), &status); err != nil {
+		t.Fatalf("Failed to parse home status: %v", err)
+	}
+
+	module.processHomeStatus(&home, &status, time.Now())
+
+	if len(metricsCh) != 3 {
+		t.Fatalf("Expected 3 heating metrics (2 rooms, 1 boiler), got %d", len(metricsCh))
+	}
+
+	living := <-metricsCh
+	if living.Name != metricNameHeating || living.Tags["friendly"] != "Living Room" || living.Tags["home"] != "Haus" {
+		t.Errorf("Unexpected room metric %s %v", living.Name, living.Tags)
+	}
+	fields := metrics.ResolveMissing(living.Fields, metrics.MissingOmit)
+	if fields["setpoint"] != 21.0 || fields["temperature"] != 20.5 ||
+		fields["heating_demand"] != 40 || fields["setpoint_mode"] != "schedule" {
+		t.Errorf("Unexpected room fields %v", fields)
+	}
+
+	bath := <-metricsCh
+	if bath.Tags["friendly"] != "Bath" || bath.Fields["reachable"] != false {
+		t.Errorf("Expected friendly name override and unreachable room, got %v %v", bath.Tags, bath.Fields)
+	}
+	if !metrics.IsMissing(bath.Fields["temperature"]) {
+		t.Error("Expected missing temperature for room without measurement")
+	}
+
+	boiler := <-metricsCh
+	if boiler.Tags["device"] != "70:ee:50:00:00:10" || boiler.Tags["friendly"] != "Thermostat" || boiler.Fields["boiler_status"] != 1 {
+		t.Errorf("Unexpected boiler metric %v %v", boiler.Tags, boiler.Fields)
+	}
+}
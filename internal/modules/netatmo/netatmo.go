@@ -3,33 +3,61 @@ package netatmo
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/janhuddel/metrics-agent/internal/config"
-	"github.com/janhuddel/metrics-agent/internal/metrics"
+	"github.com/janhuddel/metrics-agent/internal/connection"
 	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
 )
 
 // Config represents the configuration for the Netatmo module
 type Config struct {
 	config.BaseConfig
-	ClientID     string `json:"client_id"`
-	ClientSecret string `json:"client_secret"`
-	Timeout      string `json:"timeout"`
-	Interval     string `json:"interval"`
-	Hostname     string `json:"hostname"` // Optional hostname/IP for OAuth redirect URI
+	ClientID     string          `json:"client_id"`
+	ClientSecret string          `json:"client_secret"`
+	Timeout      config.Duration `json:"timeout"`
+	Interval     config.Duration `json:"interval"`
+	Hostname     string          `json:"hostname"` // Optional hostname/IP for OAuth redirect URI
+	Scope        string          `json:"scope"`    // Space-separated OAuth scopes (defaults to read_station)
+	Backfill     config.Duration `json:"backfill"` // Lookback window backfilled from the measurement history on startup, 0 disables
+}
+
+// product is a Netatmo product line whose data is read from an endpoint if
+// the configured scope grants access to it
+type product struct {
+	scope    string
+	endpoint string
+	collect  func(nm *NetatmoModule, ctx context.Context, endpoint string) error
+}
+
+// products are the supported product lines
+var products = []product{
+	{scope: "read_station", endpoint: stationsDataEndpoint, collect: (*NetatmoModule).collectDevices},
+	{scope: "read_homecoach", endpoint: "/api/gethomecoachsdata", collect: (*NetatmoModule).collectDevices},
+	{scope: "read_thermostat", endpoint: "/api/homestatus", collect: (*NetatmoModule).collectHeating},
 }
 
 // NetatmoModule handles Netatmo API authentication and data collection
 type NetatmoModule struct {
-	config     Config
-	httpClient *http.Client
-	baseURL    string
-	oauth2     *utils.OAuth2Client
-	metricsCh  chan<- metrics.Metric
+	config      Config
+	httpClient  *http.Client
+	baseURL     string
+	oauth2      *utils.OAuth2Client
+	metricsCh   chan<- metrics.Metric
+	clock       utils.Clock
+	collections *utils.CollectionLog // last successful collection, nil if not remembered
+	tracker     *connection.Tracker
+
+	// backfillSince is the start of the history sent on the first collection
+	// of a run: the last collection of the previous run, limited to the
+	// backfill window
+	backfillSince time.Time
 }
 
 // StationData represents the response from the Netatmo API
@@ -44,6 +72,7 @@ type StationData struct {
 type Device struct {
 	ID            string    `json:"_id"`
 	StationName   string    `json:"station_name"`
+	Name          string    `json:"name"` // Name of a home coach
 	ModuleName    string    `json:"module_name"`
 	Type          string    `json:"type"`
 	DashboardData Dashboard `json:"dashboard_data"`
@@ -69,67 +98,70 @@ type Place struct {
 	Location []float64 `json:"location"`
 }
 
-// Dashboard represents the sensor data from a device/module
+// Dashboard represents the sensor data from a device/module. The measured
+// values are pointers, so values the device doesn't report (nil) can be told
+// apart from real zeros such as 0 °C.
 type Dashboard struct {
-	TimeUTC          int64   `json:"time_utc"`
-	Temperature      float64 `json:"Temperature"`
-	Humidity         int     `json:"Humidity"`
-	CO2              int     `json:"CO2"`
-	Noise            int     `json:"Noise"`
-	Pressure         float64 `json:"Pressure"`
-	AbsolutePressure float64 `json:"AbsolutePressure"`
-	MinTemp          float64 `json:"min_temp"`
-	MaxTemp          float64 `json:"max_temp"`
-	DateMinTemp      int64   `json:"date_min_temp"`
-	DateMaxTemp      int64   `json:"date_max_temp"`
-	TempTrend        string  `json:"temp_trend"`
-	PressureTrend    string  `json:"pressure_trend"`
-	Rain             float64 `json:"Rain"`
-	Rain1            float64 `json:"rain_1"`
-	Rain24           float64 `json:"rain_24"`
-	DateRain         int64   `json:"date_rain"`
-	WindStrength     int     `json:"WindStrength"`
-	WindAngle        int     `json:"WindAngle"`
-	GustStrength     int     `json:"GustStrength"`
-	GustAngle        int     `json:"GustAngle"`
-	DateWind         int64   `json:"date_wind"`
-	MaxWindStr       int     `json:"max_wind_str"`
-	MaxWindAngle     int     `json:"max_wind_angle"`
-	DateMaxWindStr   int64   `json:"date_max_wind_str"`
+	TimeUTC          int64    `json:"time_utc"`
+	Temperature      *float64 `json:"Temperature"`
+	Humidity         *int     `json:"Humidity"`
+	CO2              *int     `json:"CO2"`
+	Noise            *int     `json:"Noise"`
+	Pressure         *float64 `json:"Pressure"`
+	AbsolutePressure float64  `json:"AbsolutePressure"`
+	MinTemp          float64  `json:"min_temp"`
+	MaxTemp          float64  `json:"max_temp"`
+	DateMinTemp      int64    `json:"date_min_temp"`
+	DateMaxTemp      int64    `json:"date_max_temp"`
+	TempTrend        string   `json:"temp_trend"`
+	PressureTrend    string   `json:"pressure_trend"`
+	Rain             float64  `json:"Rain"`
+	Rain1            float64  `json:"rain_1"`
+	Rain24           float64  `json:"rain_24"`
+	DateRain         int64    `json:"date_rain"`
+	WindStrength     int      `json:"WindStrength"`
+	WindAngle        int      `json:"WindAngle"`
+	GustStrength     int      `json:"GustStrength"`
+	GustAngle        int      `json:"GustAngle"`
+	DateWind         int64    `json:"date_wind"`
+	MaxWindStr       int      `json:"max_wind_str"`
+	MaxWindAngle     int      `json:"max_wind_angle"`
+	DateMaxWindStr   int64    `json:"date_max_wind_str"`
+	HealthIdx        *int     `json:"health_idx"` // Home coach air quality, 0 (healthy) to 4 (unhealthy)
 }
 
 // NewNetatmoModule creates a new Netatmo module instance
-func NewNetatmoModule(config Config) (*NetatmoModule, error) {
+func NewNetatmoModule(cfg Config) (*NetatmoModule, error) {
 	utils.Debugf("Creating new Netatmo module instance")
 	timeout := 30 * time.Second
-	if config.Timeout != "" {
-		if parsed, err := time.ParseDuration(config.Timeout); err == nil {
-			timeout = parsed
-		}
+	if cfg.Timeout > 0 {
+		timeout = cfg.Timeout.Duration()
 	}
 	utils.Debugf("Netatmo module timeout set to: %v", timeout)
 
 	// Create OAuth2 client
 	oauth2Config := utils.OAuth2Config{
-		ClientID:     config.ClientID,
-		ClientSecret: config.ClientSecret,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
 		AuthURL:      "https://api.netatmo.com/oauth2/authorize",
 		TokenURL:     "https://api.netatmo.com/oauth2/token",
-		Scope:        "read_station",
+		Scope:        cfg.Scope,
 		State:        "netatmo_auth",
-		Hostname:     config.Hostname,
+		Hostname:     cfg.Hostname,
 	}
 
-	oauth2Client, err := utils.NewOAuth2Client(oauth2Config, "netatmo")
+	oauth2Client, err := utils.NewOAuth2Client(oauth2Config, cfg.InstanceName("netatmo"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create OAuth2 client: %w", err)
 	}
 
 	utils.Debugf("Netatmo module created successfully")
 	return &NetatmoModule{
-		config: config,
+		clock:  utils.SystemClock,
+		config: cfg,
 		httpClient: &http.Client{
-			Timeout: timeout,
+			Timeout:   timeout,
+			Transport: utils.OutboundTransport(cfg.InstanceName("netatmo"), nil),
 		},
 		baseURL: "https://api.netatmo.com",
 		oauth2:  oauth2Client,
@@ -138,16 +170,72 @@ func NewNetatmoModule(config Config) (*NetatmoModule, error) {
 
 // Run starts the Netatmo module and begins collecting metrics
 func Run(ctx context.Context, ch chan<- metrics.Metric) error {
-	config := LoadConfig()
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	if err := config.validateScope(); err != nil {
+		return err
+	}
 	module, err := NewNetatmoModule(config)
 	if err != nil {
 		return fmt.Errorf("failed to create Netatmo module: %w", err)
 	}
 	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+	module.collections = utils.NewCollectionLog(module.oauth2.Storage(), module.clock)
 
 	return module.run(ctx)
 }
 
+// Probe validates the Netatmo configuration and checks that the Netatmo API is reachable
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return &config.ModuleError{Module: "netatmo", Err: fmt.Errorf("client_id and client_secret are required but not configured")}
+	}
+	if err := cfg.validateScope(); err != nil {
+		return err
+	}
+	return utils.ProbeURL(ctx, "https://api.netatmo.com", 10*time.Second)
+}
+
+// SelfTest checks the stored OAuth token and, if it is still valid, that the
+// API accepts it
+func SelfTest(ctx context.Context) []utils.Check {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return []utils.Check{{Name: "configuration", Err: err}}
+	}
+	if err := cfg.validateScope(); err != nil {
+		return []utils.Check{{Name: "configuration", Err: err}}
+	}
+	module, err := NewNetatmoModule(cfg)
+	if err != nil {
+		return []utils.Check{{Name: "configuration", Err: err}}
+	}
+
+	tokenCheck, accessToken := module.oauth2.TokenCheck()
+	checks := []utils.Check{tokenCheck}
+	if accessToken == "" {
+		return checks
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, module.baseURL+cfg.products()[0].endpoint, nil)
+	if err != nil {
+		return append(checks, utils.Check{Name: "API access", Err: err})
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := module.httpClient.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	return append(checks, utils.APICheck("API access", resp, err))
+}
+
 // run executes the main module loop
 func (nm *NetatmoModule) run(ctx context.Context) error {
 	return utils.WithPanicRecoveryAndReturnError("Netatmo module", "main", func() error {
@@ -158,28 +246,36 @@ func (nm *NetatmoModule) run(ctx context.Context) error {
 
 		// Set up ticker for data collection
 		interval := 5 * time.Minute
-		if nm.config.Interval != "" {
-			if parsed, err := time.ParseDuration(nm.config.Interval); err == nil {
-				interval = parsed
-			}
+		if nm.config.Interval > 0 {
+			interval = nm.config.Interval.Duration()
 		}
 
-		ticker := time.NewTicker(interval)
+		ticker := utils.NewScheduledTicker(ctx, interval)
 		defer ticker.Stop()
 
-		// Collect initial data
-		if err := nm.collectData(ctx); err != nil {
-			utils.Warnf("Failed to collect initial data: %v", err)
+		// Collect initial data unless outside the collection schedule or skipped,
+		// but not before an interval has passed since the last collection
+		initial := nm.collections.Initial(ctx, interval)
+		if nm.config.Backfill > 0 {
+			nm.backfillSince = nm.backfillStart(nm.collections.Last())
 		}
 
 		// Main collection loop
 		for {
 			select {
 			case <-ctx.Done():
-				return ctx.Err()
+				return nil
+			case <-initial:
+				if err := utils.CollectWithRetry(ctx, interval, nm.collectData); err != nil {
+					utils.Warnf("Failed to collect initial data: %v", err)
+				} else {
+					nm.collections.Record()
+				}
 			case <-ticker.C:
-				if err := nm.collectData(ctx); err != nil {
+				if err := utils.CollectWithRetry(ctx, interval, nm.collectData); err != nil {
 					utils.Warnf("Failed to collect data: %v", err)
+				} else {
+					nm.collections.Record()
 				}
 			}
 		}
@@ -208,52 +304,92 @@ func (nm *NetatmoModule) authenticate(ctx context.Context) error {
 	})
 }
 
-// collectData fetches data from Netatmo API and sends metrics
+// collectData fetches the data of all products granted by the scope and sends metrics
 func (nm *NetatmoModule) collectData(ctx context.Context) error {
-	return utils.WithPanicRecoveryAndReturnError("Netatmo data collection", "api", func() error {
-		// Create request
-		req, err := http.NewRequest("GET", nm.baseURL+"/api/getstationsdata", nil)
+	var errs []error
+	for _, p := range nm.config.products() {
+		err := utils.WithPanicRecoveryAndReturnError("Netatmo data collection", "api", func() error {
+			return p.collect(nm, ctx, p.endpoint)
+		})
 		if err != nil {
-			return err
+			errs = append(errs, fmt.Errorf("%s: %w", p.endpoint, err))
 		}
+	}
+	// The history is only sent once per run
+	nm.backfillSince = time.Time{}
+	return errors.Join(errs...)
+}
 
-		// Use OAuth2Client's authenticated request method (handles retries automatically)
-		resp, err := nm.oauth2.AuthenticatedRequest(ctx, nm.httpClient, req)
-		if err != nil {
-			return fmt.Errorf("API request failed: %w", err)
-		}
-		defer resp.Body.Close()
+// collectDevices fetches weather stations or home coaches and sends metrics
+func (nm *NetatmoModule) collectDevices(ctx context.Context, endpoint string) error {
+	var stationData StationData
+	if err := nm.get(ctx, endpoint, &stationData); err != nil {
+		return err
+	}
+	if stationData.Status != "ok" {
+		return fmt.Errorf("API returned non-ok status: %s", stationData.Status)
+	}
 
-		// Handle non-200 responses (after retries)
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
-		}
+	// Fill the gap since the previous run before sending the current values
+	if !nm.backfillSince.IsZero() {
+		nm.backfill(ctx, &stationData, nm.backfillSince)
+	}
 
-		// Parse response
-		var stationData StationData
-		if err := json.NewDecoder(resp.Body).Decode(&stationData); err != nil {
-			return fmt.Errorf("failed to parse API response: %w", err)
-		}
+	// Process the data and send metrics
+	nm.processStationData(&stationData)
+	return nil
+}
 
-		if stationData.Status != "ok" {
-			return fmt.Errorf("API returned non-ok status: %s", stationData.Status)
-		}
+// get requests an API endpoint and decodes the JSON response into result
+func (nm *NetatmoModule) get(ctx context.Context, endpoint string, result interface{}) error {
+	// Create request
+	req, err := http.NewRequest("GET", nm.baseURL+endpoint, nil)
+	if err != nil {
+		return err
+	}
 
-		// Process the data and send metrics
-		nm.processStationData(&stationData)
+	// Use OAuth2Client's authenticated request method (handles retries automatically)
+	resp, err := nm.oauth2.AuthenticatedRequest(ctx, nm.httpClient, req)
+	nm.connection().SetPollResult(resp, err)
+	if err != nil {
+		return fmt.Errorf("API request failed: %w", err)
+	}
+	defer resp.Body.Close()
 
-		return nil
-	})
+	// Handle non-200 responses (after retries)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse API response: %w", err)
+	}
+	return nil
+}
+
+// connection returns the tracker for the Netatmo API, creating it on first use
+func (nm *NetatmoModule) connection() *connection.Tracker {
+	if nm.tracker == nil {
+		nm.tracker = connection.NewTracker(nm.config.InstanceName("netatmo"), nm.baseURL, nm.metricsCh)
+	}
+	return nm.tracker
 }
 
 // processStationData processes the station data and sends metrics
 func (nm *NetatmoModule) processStationData(data *StationData) {
+	if len(data.Body.Devices) == 0 {
+		return
+	}
 	timestamp := time.Unix(data.Body.Devices[0].DashboardData.TimeUTC, 0)
 
 	for _, device := range data.Body.Devices {
-		// Get friendly name for the device
-		friendlyName := nm.config.GetFriendlyName(device.ID, device.StationName, device.StationName)
+		// Get friendly name for the device; home coaches have a name instead of a station name
+		name := device.StationName
+		if name == "" {
+			name = device.Name
+		}
+		friendlyName := nm.config.GetFriendlyName(device.ID, name, name)
 
 		// Process main station data
 		nm.sendDeviceMetrics(device.ID, friendlyName, &device.DashboardData, timestamp)
@@ -268,6 +404,21 @@ func (nm *NetatmoModule) processStationData(data *StationData) {
 
 // sendDeviceMetrics sends metrics for a specific device/module
 func (nm *NetatmoModule) sendDeviceMetrics(deviceID string, friendlyName string, data *Dashboard, timestamp time.Time) {
+	metric, ok := deviceMetric(deviceID, friendlyName, data, timestamp)
+	if !ok {
+		return
+	}
+
+	select {
+	case nm.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping metric for device %s", deviceID)
+	}
+}
+
+// deviceMetric returns the climate metric of a device/module. It returns false
+// if the data contains no values.
+func deviceMetric(deviceID string, friendlyName string, data *Dashboard, timestamp time.Time) (metrics.Metric, bool) {
 	// Create base tags
 	tags := map[string]string{
 		"vendor":   "netatmo",
@@ -275,68 +426,80 @@ func (nm *NetatmoModule) sendDeviceMetrics(deviceID string, friendlyName string,
 		"friendly": friendlyName,
 	}
 
-	// Create fields map
-	fields := make(map[string]interface{})
-
-	// Add temperature if available
-	if data.Temperature != 0 {
-		fields["temperature"] = data.Temperature
+	// Values the device doesn't report are nil and marked as missing, while
+	// zeros such as 0 °C outside or a health index of 0 are written
+	fields := map[string]interface{}{
+		"temperature":  data.Temperature,
+		"humidity":     data.Humidity,
+		"co2":          data.CO2,
+		"noise":        data.Noise,
+		"pressure":     data.Pressure,
+		"health_index": data.HealthIdx,
 	}
 
-	// Add humidity if available
-	if data.Humidity != 0 {
-		fields["humidity"] = data.Humidity
+	// Only send metrics if we have data
+	if len(metrics.ResolveMissing(fields, metrics.MissingOmit)) == 0 {
+		return metrics.Metric{}, false
 	}
+	return metrics.Metric{
+		Name:      "climate",
+		Tags:      tags,
+		Fields:    fields,
+		Timestamp: timestamp,
+	}, true
+}
 
-	// Add CO2 if available
-	if data.CO2 != 0 {
-		fields["co2"] = data.CO2
+// products returns the supported products granted by the configured scope
+func (c Config) products() []product {
+	granted := make(map[string]bool)
+	for _, scope := range strings.Fields(c.Scope) {
+		granted[scope] = true
 	}
-
-	// Add noise if available
-	if data.Noise != 0 {
-		fields["noise"] = data.Noise
+	var result []product
+	for _, p := range products {
+		if granted[p.scope] {
+			result = append(result, p)
+		}
 	}
+	return result
+}
 
-	// Add pressure if available
-	if data.Pressure != 0 {
-		fields["pressure"] = data.Pressure
+// validateScope checks that the scope grants access to at least one supported product
+func (c Config) validateScope() error {
+	if len(c.products()) > 0 {
+		return nil
 	}
-
-	// Only send metrics if we have data
-	if len(fields) > 0 {
-		metric := metrics.Metric{
-			Name:      "climate",
-			Tags:      tags,
-			Fields:    fields,
-			Timestamp: timestamp,
-		}
-
-		select {
-		case nm.metricsCh <- metric:
-		default:
-			utils.Warnf("Metrics channel is full, dropping metric for device %s", deviceID)
-		}
+	supported := make([]string, 0, len(products))
+	for _, p := range products {
+		supported = append(supported, p.scope)
 	}
+	return &config.ModuleError{Module: "netatmo", Err: fmt.Errorf("scope %q contains none of the supported scopes %s", c.Scope, strings.Join(supported, ", "))}
 }
 
-// LoadConfig loads the Netatmo module configuration
-func LoadConfig() Config {
-	defaultConfig := Config{
-		Timeout:  "30s",
-		Interval: "5m",
+// DefaultConfig returns the default configuration of the Netatmo module.
+func DefaultConfig() Config {
+	return Config{
+		Timeout:  config.Duration(30 * time.Second),
+		Interval: config.Duration(5 * time.Minute),
+		Scope:    "read_station",
+		Backfill: config.Duration(24 * time.Hour),
 	}
+}
+
+// LoadConfig loads the Netatmo module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
 
 	loader := config.NewLoader("netatmo")
+	loader.SetInstance(instance)
 	if config.GlobalConfigPath != "" {
 		loader.SetConfigPath(config.GlobalConfigPath)
 	}
 
 	loadedConfig, err := loader.LoadConfig(&defaultConfig)
 	if err != nil {
-		utils.Warnf("Failed to load Netatmo configuration: %v", err)
-		return defaultConfig
+		return defaultConfig, err
 	}
 
-	return *loadedConfig.(*Config)
+	return *loadedConfig.(*Config), nil
 }
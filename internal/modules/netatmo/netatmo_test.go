@@ -1,26 +1,30 @@
 package netatmo
 
 import (
+	"encoding/json"
+	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
-	"github.com/janhuddel/metrics-agent/internal/metrics"
+	"github.com/janhuddel/metrics-agent/internal/config"
 	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
 )
 
 func TestNetatmoModule(t *testing.T) {
 	tah := utils.NewTestAssertionHelper()
 
 	// Create a test configuration
-	config := Config{
+	cfg := Config{
 		ClientID:     "test_client_id",
 		ClientSecret: "test_client_secret",
-		Timeout:      "10s",
-		Interval:     "1m",
+		Timeout:      config.Duration(10 * time.Second),
+		Interval:     config.Duration(time.Minute),
 	}
 
 	// Create module instance
-	module, err := NewNetatmoModule(config)
+	module, err := NewNetatmoModule(cfg)
 	tah.AssertNoError(t, err, "Failed to create Netatmo module")
 
 	// Verify configuration is set correctly
@@ -28,7 +32,7 @@ func TestNetatmoModule(t *testing.T) {
 		t.Errorf("Expected ClientID to be 'test_client_id', got '%s'", module.config.ClientID)
 	}
 
-	if module.config.Interval != "1m" {
+	if module.config.Interval.Duration() != time.Minute {
 		t.Errorf("Expected Interval to be '1m', got '%s'", module.config.Interval)
 	}
 
@@ -45,14 +49,17 @@ func TestNetatmoModule(t *testing.T) {
 
 func TestLoadConfig(t *testing.T) {
 	// Test loading default configuration
-	config := LoadConfig()
+	config, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 
 	// Verify default values
-	if config.Timeout != "30s" {
+	if config.Timeout.Duration() != 30*time.Second {
 		t.Errorf("Expected default timeout to be '30s', got '%s'", config.Timeout)
 	}
 
-	if config.Interval != "5m" {
+	if config.Interval.Duration() != 5*time.Minute {
 		t.Errorf("Expected default interval to be '5m', got '%s'", config.Interval)
 	}
 }
@@ -73,11 +80,12 @@ func TestSendDeviceMetrics(t *testing.T) {
 	module.metricsCh = metricsCh
 
 	// Create test dashboard data
+	temperature, humidity, co2, pressure := 22.5, 65, 450, 1013.25
 	dashboard := &Dashboard{
-		Temperature: 22.5,
-		Humidity:    65,
-		CO2:         450,
-		Pressure:    1013.25,
+		Temperature: &temperature,
+		Humidity:    &humidity,
+		CO2:         &co2,
+		Pressure:    &pressure,
 	}
 
 	// Send metrics
@@ -99,19 +107,20 @@ func TestSendDeviceMetrics(t *testing.T) {
 		}
 
 		// Check fields
-		if temp, ok := metric.Fields["temperature"]; !ok || temp != 22.5 {
+		fields := metrics.ResolveMissing(metric.Fields, metrics.MissingOmit)
+		if temp, ok := fields["temperature"]; !ok || temp != 22.5 {
 			t.Errorf("Expected temperature field to be 22.5, got %v", temp)
 		}
 
-		if humidity, ok := metric.Fields["humidity"]; !ok || humidity != 65 {
+		if humidity, ok := fields["humidity"]; !ok || humidity != 65 {
 			t.Errorf("Expected humidity field to be 65, got %v", humidity)
 		}
 
-		if co2, ok := metric.Fields["co2"]; !ok || co2 != 450 {
+		if co2, ok := fields["co2"]; !ok || co2 != 450 {
 			t.Errorf("Expected co2 field to be 450, got %v", co2)
 		}
 
-		if pressure, ok := metric.Fields["pressure"]; !ok || pressure != 1013.25 {
+		if pressure, ok := fields["pressure"]; !ok || pressure != 1013.25 {
 			t.Errorf("Expected pressure field to be 1013.25, got %v", pressure)
 		}
 
@@ -136,3 +145,88 @@ func TestRunWithCancellation(t *testing.T) {
 	err := Run(ctx, metricsCh)
 	tah.AssertError(t, err, "Expected Run to return an error due to authentication failure")
 }
+
+func TestConfigProducts(t *testing.T) {
+	tests := []struct {
+		scope     string
+		endpoints []string
+	}{
+		{"read_station", []string{"/api/getstationsdata"}},
+		{"read_station read_homecoach", []string{"/api/getstationsdata", "/api/gethomecoachsdata"}},
+		{"read_homecoach read_camera", []string{"/api/gethomecoachsdata"}},
+		{"read_thermostat", []string{"/api/homestatus"}},
+		{"read_camera", nil},
+	}
+
+	for _, tt := range tests {
+		cfg := Config{Scope: tt.scope}
+		var endpoints []string
+		for _, p := range cfg.products() {
+			endpoints = append(endpoints, p.endpoint)
+		}
+		if fmt.Sprint(endpoints) != fmt.Sprint(tt.endpoints) {
+			t.Errorf("Scope %q: expected endpoints %v, got %v", tt.scope, tt.endpoints, endpoints)
+		}
+		if err := cfg.validateScope(); (err == nil) != (len(tt.endpoints) > 0) {
+			t.Errorf("Scope %q: unexpected validation result %v", tt.scope, err)
+		}
+		if err := cfg.validateScope(); err != nil && !config.IsModuleError(err) {
+			t.Errorf("Scope %q: expected module error, got %v", tt.scope, err)
+		}
+	}
+}
+
+func TestProcessHomeCoachData(t *testing.T) {
+	module, err := NewNetatmoModule(Config{ClientID: "id", ClientSecret: "secret", Scope: "read_homecoach"})
+	if err != nil {
+		t.Fatalf("Failed to create Netatmo module: %v", err)
+	}
+	metricsCh := make(chan metrics.Metric, 10)
+	module.metricsCh = metricsCh
+
+	// Devices without data must not fail processing
+	module.processStationData(&StationData{})
+	if len(metricsCh) != 0 {
+		t.Fatalf("Expected no metrics for empty response, got %d", len(metricsCh))
+	}
+
+	var data StationData
+	payload := `{"status": "ok", "body": {"devices": [{"_id": "70:ee:50:00:00:01", "name": "Bedroom", "type": "NHC",
+		"dashboard_data": {"time_utc": 1700000000, "Temperature": 21.3, "CO2": 812, "Humidity": 48, "Noise": 35, "Pressure": 1012.4, "health_idx": 0}}]}}`
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		t.Fatalf("Failed to parse home coach data: %v", err)
+	}
+	module.processStationData(&data)
+
+	metric := <-metricsCh
+	if metric.Tags["friendly"] != "Bedroom" {
+		t.Errorf("Expected friendly name from home coach name, got %q", metric.Tags["friendly"])
+	}
+	if fields := metrics.ResolveMissing(metric.Fields, metrics.MissingOmit); fields["co2"] != 812 || fields["health_index"] != 0 {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+}
+
+func TestDeviceMetricZeroValues(t *testing.T) {
+	// An outdoor module at 0 °C reports real zeros, which must not be dropped
+	var data Dashboard
+	if err := json.Unmarshal([]byte(`{"time_utc": 1700000000, "Temperature": 0, "Humidity": 0}`), &data); err != nil {
+		t.Fatalf("Failed to parse dashboard data: %v", err)
+	}
+	metric, ok := deviceMetric("02:00:00:00:00:01", "Outdoor", &data, time.Unix(data.TimeUTC, 0))
+	if !ok {
+		t.Fatal("Expected metric for zero values")
+	}
+	expected := map[string]interface{}{"temperature": 0.0, "humidity": 0}
+	if fields := metrics.ResolveMissing(metric.Fields, metrics.MissingOmit); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected fields %v, got %v", expected, fields)
+	}
+	if !metrics.IsMissing(metric.Fields["co2"]) {
+		t.Errorf("Expected co2 to be marked as missing, got %v", metric.Fields["co2"])
+	}
+
+	// No metric is sent if the module reports no values
+	if _, ok := deviceMetric("02:00:00:00:00:01", "Outdoor", &Dashboard{TimeUTC: 1700000000}, time.Now()); ok {
+		t.Error("Expected no metric without values")
+	}
+}
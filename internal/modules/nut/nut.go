@@ -0,0 +1,475 @@
+// Package nut provides a metric collection module for UPS devices managed by
+// Network UPS Tools (NUT).
+// It connects to a NUT server (upsd) via its TCP protocol and reports load,
+// battery charge, runtime and status of each UPS.
+package nut
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// numericVariables maps NUT variable names to metric field names
+var numericVariables = map[string]string{
+	"ups.load":              "load",
+	"ups.realpower":         "power",
+	"ups.temperature":       "temperature",
+	"battery.charge":        "battery_charge",
+	"battery.runtime":       "battery_runtime",
+	"battery.voltage":       "battery_voltage",
+	"input.voltage":         "input_voltage",
+	"input.frequency":       "input_frequency",
+	"output.voltage":        "output_voltage",
+	"ups.realpower.nominal": "power_nominal",
+}
+
+// Config represents the configuration for the NUT module
+type Config struct {
+	config.BaseConfig
+	Address  string          `json:"address"`            // NUT server address (defaults to localhost:3493)
+	UPSNames []string        `json:"ups_names"`          // UPS names to monitor (defaults to all UPSes on the server)
+	Username string          `json:"username"`           // NUT username (optional)
+	Password string          `json:"password"`           // NUT password (optional)
+	Interval config.Duration `json:"interval,omitempty"` // Polling interval (defaults to 30s)
+	Timeout  config.Duration `json:"timeout,omitempty"`  // Connection timeout (defaults to 10s)
+}
+
+// UPS represents a UPS and its variables as reported by the NUT server
+type UPS struct {
+	Name        string
+	Description string
+	Variables   map[string]string
+}
+
+// NUTModule handles polling of a NUT server
+type NUTModule struct {
+	config      Config
+	metricsCh   chan<- metrics.Metric
+	clock       utils.Clock
+	collections *utils.CollectionLog // last successful collection, nil if not remembered
+}
+
+// Run starts the NUT module and begins collecting metrics
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module := NewNUTModule(config)
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+	module.collections = utils.OpenCollectionLog(config.InstanceName("nut"), module.clock)
+
+	return module.run(ctx)
+}
+
+// Probe checks that the NUT server is reachable
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module := NewNUTModule(cfg)
+	return utils.ProbeAddress(ctx, module.config.Address, module.config.Timeout.Duration())
+}
+
+// NewNUTModule creates a new NUT module instance
+func NewNUTModule(cfg Config) *NUTModule {
+	utils.Debugf("Creating new NUT module instance")
+
+	if cfg.Address == "" {
+		cfg.Address = "localhost:3493"
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(30 * time.Second)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = config.Duration(10 * time.Second)
+	}
+
+	utils.Debugf("NUT module created successfully")
+	return &NUTModule{
+		clock:  utils.SystemClock,
+		config: cfg,
+	}
+}
+
+// DefaultConfig returns the default configuration of the NUT module.
+func DefaultConfig() Config {
+	return Config{
+		Address:  "localhost:3493",
+		Interval: config.Duration(30 * time.Second),
+		Timeout:  config.Duration(10 * time.Second),
+	}
+}
+
+// LoadConfig loads the NUT module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("nut")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
+
+// run executes the main module loop
+func (nm *NUTModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("NUT module", "main", func() error {
+		ticker := utils.NewScheduledTicker(ctx, nm.config.Interval.Duration())
+		defer ticker.Stop()
+
+		// Collect initial data unless outside the collection schedule or skipped,
+		// but not before an interval has passed since the last collection
+		initial := nm.collections.Initial(ctx, nm.config.Interval.Duration())
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-initial:
+				if err := nm.collectData(ctx); err != nil {
+					utils.Warnf("Failed to collect initial UPS data: %v", err)
+				} else {
+					nm.collections.Record()
+				}
+			case <-ticker.C:
+				if err := nm.collectData(ctx); err != nil {
+					utils.Warnf("Failed to collect UPS data: %v", err)
+				} else {
+					nm.collections.Record()
+				}
+			}
+		}
+	})
+}
+
+// collectData queries the NUT server and sends a metric for each UPS
+func (nm *NUTModule) collectData(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("NUT data collection", nm.config.Address, func() error {
+		start := time.Now()
+		client, err := Dial(ctx, nm.config.Address, nm.config.Timeout.Duration())
+		utils.AuditConnect(nm.config.InstanceName("nut"), "nut", nm.config.Address, time.Since(start), err)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		if nm.config.Username != "" {
+			if err := client.Login(nm.config.Username, nm.config.Password); err != nil {
+				return err
+			}
+		}
+
+		upsList, err := client.ListUPS()
+		if err != nil {
+			return err
+		}
+
+		for _, ups := range nm.selectUPS(upsList) {
+			variables, err := client.ListVariables(ups.Name)
+			if err != nil {
+				utils.Warnf("Failed to read variables for UPS %s: %v", ups.Name, err)
+				continue
+			}
+			ups.Variables = variables
+			nm.sendUPSMetric(ups, nm.clock.Now())
+		}
+
+		return nil
+	})
+}
+
+// selectUPS filters the UPS list by the configured names, keeping all UPSes if none are configured
+func (nm *NUTModule) selectUPS(upsList []UPS) []UPS {
+	if len(nm.config.UPSNames) == 0 {
+		return upsList
+	}
+
+	available := make(map[string]UPS, len(upsList))
+	for _, ups := range upsList {
+		available[ups.Name] = ups
+	}
+
+	selected := make([]UPS, 0, len(nm.config.UPSNames))
+	for _, name := range nm.config.UPSNames {
+		if ups, exists := available[name]; exists {
+			selected = append(selected, ups)
+		} else {
+			utils.Warnf("Configured UPS %s not found on NUT server %s", name, nm.config.Address)
+		}
+	}
+	return selected
+}
+
+// sendUPSMetric creates and sends a metric for a UPS
+func (nm *NUTModule) sendUPSMetric(ups UPS, timestamp time.Time) {
+	fields := make(map[string]interface{})
+
+	for variable, field := range numericVariables {
+		if raw, exists := ups.Variables[variable]; exists {
+			if value, err := strconv.ParseFloat(raw, 64); err == nil {
+				fields[field] = value
+			}
+		}
+	}
+
+	if status, exists := ups.Variables["ups.status"]; exists {
+		flags := strings.Fields(status)
+		fields["status"] = status
+		fields["online"] = containsFlag(flags, "OL")
+		fields["on_battery"] = containsFlag(flags, "OB")
+		fields["low_battery"] = containsFlag(flags, "LB")
+	}
+
+	if len(fields) == 0 {
+		return
+	}
+
+	metric := metrics.Metric{
+		Name: "ups",
+		Tags: map[string]string{
+			"vendor":   "nut",
+			"device":   ups.Name,
+			"friendly": nm.config.GetFriendlyName(ups.Name, ups.Description, ups.Name),
+		},
+		Fields:    fields,
+		Timestamp: timestamp,
+	}
+
+	if err := metric.Validate(); err != nil {
+		utils.Warnf("Invalid metric for UPS %s: %v", ups.Name, err)
+		return
+	}
+
+	select {
+	case nm.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping metric for UPS %s", ups.Name)
+	}
+}
+
+// containsFlag checks whether a status flag is present
+func containsFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// Client is a minimal client for the NUT network protocol
+type Client struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+// Dial connects to a NUT server
+func Dial(ctx context.Context, address string, timeout time.Duration) (*Client, error) {
+	if err := utils.CheckDestination(ctx, address); err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NUT server %s: %w", address, err)
+	}
+
+	return &Client{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: timeout,
+	}, nil
+}
+
+// Close logs out and closes the connection
+func (c *Client) Close() error {
+	_ = c.send("LOGOUT")
+	return c.conn.Close()
+}
+
+// Login authenticates with the NUT server
+func (c *Client) Login(username, password string) error {
+	quotedUsername, err := quote(username)
+	if err != nil {
+		return fmt.Errorf("invalid username: %w", err)
+	}
+	quotedPassword, err := quote(password)
+	if err != nil {
+		return fmt.Errorf("invalid password: %w", err)
+	}
+
+	if _, err := c.command("USERNAME " + quotedUsername); err != nil {
+		return fmt.Errorf("USERNAME failed: %w", err)
+	}
+	if _, err := c.command("PASSWORD " + quotedPassword); err != nil {
+		return fmt.Errorf("PASSWORD failed: %w", err)
+	}
+	return nil
+}
+
+// ListUPS returns all UPSes known to the server
+func (c *Client) ListUPS() ([]UPS, error) {
+	lines, err := c.list("UPS", "")
+	if err != nil {
+		return nil, err
+	}
+
+	upsList := make([]UPS, 0, len(lines))
+	for _, line := range lines {
+		// UPS <upsname> "<description>"
+		parts := splitQuoted(line)
+		if len(parts) < 2 || parts[0] != "UPS" {
+			continue
+		}
+		ups := UPS{Name: parts[1]}
+		if len(parts) > 2 {
+			ups.Description = parts[2]
+		}
+		upsList = append(upsList, ups)
+	}
+	return upsList, nil
+}
+
+// ListVariables returns all variables of a UPS
+func (c *Client) ListVariables(upsName string) (map[string]string, error) {
+	lines, err := c.list("VAR", upsName)
+	if err != nil {
+		return nil, err
+	}
+
+	variables := make(map[string]string, len(lines))
+	for _, line := range lines {
+		// VAR <upsname> <varname> "<value>"
+		parts := splitQuoted(line)
+		if len(parts) < 4 || parts[0] != "VAR" {
+			continue
+		}
+		variables[parts[2]] = parts[3]
+	}
+	return variables, nil
+}
+
+// list executes a LIST command and returns the lines between BEGIN and END
+func (c *Client) list(kind, upsName string) ([]string, error) {
+	query := strings.TrimSpace(kind + " " + upsName)
+	first, err := c.command("LIST " + query)
+	if err != nil {
+		return nil, fmt.Errorf("LIST %s failed: %w", query, err)
+	}
+	if first != "BEGIN LIST "+query {
+		return nil, fmt.Errorf("unexpected response to LIST %s: %s", query, first)
+	}
+
+	var lines []string
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "END LIST "+query {
+			return lines, nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+// command sends a command and returns the first response line
+func (c *Client) command(cmd string) (string, error) {
+	if err := c.send(cmd); err != nil {
+		return "", err
+	}
+	line, err := c.readLine()
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(line, "ERR ") {
+		return "", fmt.Errorf("server error: %s", strings.TrimPrefix(line, "ERR "))
+	}
+	return line, nil
+}
+
+// send writes a single command line to the server
+func (c *Client) send(cmd string) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(c.conn, "%s\n", cmd)
+	return err
+}
+
+// readLine reads a single response line from the server
+func (c *Client) readLine() (string, error) {
+	if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return "", err
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// splitQuoted splits a protocol line on spaces, keeping quoted strings together
+func splitQuoted(line string) []string {
+	var parts []string
+	var current strings.Builder
+	inQuotes := false
+	escaped := false
+	hasToken := false // true once a token was started, so empty quoted values are kept
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && inQuotes:
+			escaped = true
+		case r == '"':
+			inQuotes = !inQuotes
+			hasToken = true
+		case r == ' ' && !inQuotes:
+			if hasToken {
+				parts = append(parts, current.String())
+				current.Reset()
+				hasToken = false
+			}
+		default:
+			current.WriteRune(r)
+			hasToken = true
+		}
+	}
+	if hasToken {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+// quote quotes a command argument, escaping double quotes and backslashes as
+// the NUT protocol requires. Line breaks can't be sent and are rejected.
+func quote(value string) (string, error) {
+	if strings.ContainsAny(value, "\r\n") {
+		return "", fmt.Errorf("must not contain line breaks")
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+	return `"` + escaped + `"`, nil
+}
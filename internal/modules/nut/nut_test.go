@@ -0,0 +1,165 @@
+package nut
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// startFakeServer starts a minimal NUT server answering LIST UPS and LIST VAR commands
+func startFakeServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleFakeConn(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func handleFakeConn(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		switch line := scanner.Text(); {
+		case strings.HasPrefix(line, "USERNAME"), strings.HasPrefix(line, "PASSWORD"):
+			fmt.Fprint(conn, "OK\n")
+		case line == "LIST UPS":
+			fmt.Fprint(conn, "BEGIN LIST UPS\nUPS eaton \"Eaton Ellipse \\\"ECO\\\"\"\nUPS apc \"APC Back-UPS\"\nEND LIST UPS\n")
+		case line == "LIST VAR eaton":
+			fmt.Fprint(conn, "BEGIN LIST VAR eaton\n"+
+				"VAR eaton ups.load \"23\"\n"+
+				"VAR eaton battery.charge \"100\"\n"+
+				"VAR eaton battery.runtime \"1800\"\n"+
+				"VAR eaton ups.status \"OL CHRG\"\n"+
+				"VAR eaton ups.mfr \"EATON\"\n"+
+				"END LIST VAR eaton\n")
+		case line == "LIST VAR apc":
+			fmt.Fprint(conn, "ERR DRIVER-NOT-CONNECTED\n")
+		case line == "LOGOUT":
+			fmt.Fprint(conn, "OK Goodbye\n")
+			return
+		default:
+			fmt.Fprint(conn, "ERR UNKNOWN-COMMAND\n")
+		}
+	}
+}
+
+func TestNewNUTModuleDefaults(t *testing.T) {
+	module := NewNUTModule(Config{})
+	if module.config.Address != "localhost:3493" {
+		t.Errorf("Expected default address localhost:3493, got %s", module.config.Address)
+	}
+	if module.config.Interval.Duration() != 30*time.Second {
+		t.Errorf("Expected default interval 30s, got %v", module.config.Interval)
+	}
+}
+
+func TestCollectData(t *testing.T) {
+	address := startFakeServer(t)
+
+	module := NewNUTModule(Config{Address: address, Username: "monuser", Password: "secret", Timeout: config.Duration(time.Second)})
+	ch := make(chan metrics.Metric, 10)
+	module.metricsCh = ch
+
+	if err := module.collectData(context.Background()); err != nil {
+		t.Fatalf("collectData failed: %v", err)
+	}
+
+	// Only the eaton UPS delivers variables, the apc driver is disconnected
+	if len(ch) != 1 {
+		t.Fatalf("Expected 1 metric, got %d", len(ch))
+	}
+
+	m := <-ch
+	if m.Name != "ups" {
+		t.Errorf("Expected metric name 'ups', got '%s'", m.Name)
+	}
+	if m.Tags["device"] != "eaton" || m.Tags["friendly"] != `Eaton Ellipse "ECO"` {
+		t.Errorf("Unexpected tags: %v", m.Tags)
+	}
+	if m.Fields["load"] != 23.0 || m.Fields["battery_charge"] != 100.0 || m.Fields["battery_runtime"] != 1800.0 {
+		t.Errorf("Unexpected numeric fields: %v", m.Fields)
+	}
+	if m.Fields["online"] != true || m.Fields["on_battery"] != false || m.Fields["status"] != "OL CHRG" {
+		t.Errorf("Unexpected status fields: %v", m.Fields)
+	}
+	if _, exists := m.Fields["ups.mfr"]; exists {
+		t.Error("Non-numeric variables should not be reported")
+	}
+}
+
+func TestSelectUPS(t *testing.T) {
+	module := NewNUTModule(Config{UPSNames: []string{"apc", "missing"}})
+	selected := module.selectUPS([]UPS{{Name: "eaton"}, {Name: "apc"}})
+	if len(selected) != 1 || selected[0].Name != "apc" {
+		t.Errorf("Expected only apc to be selected, got %v", selected)
+	}
+}
+
+func TestSplitQuoted(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{`VAR ups ups.load "23"`, []string{"VAR", "ups", "ups.load", "23"}},
+		{`UPS ups "Description with spaces"`, []string{"UPS", "ups", "Description with spaces"}},
+		{`VAR ups ups.serial ""`, []string{"VAR", "ups", "ups.serial", ""}},
+		{`UPS ups "Quoted \"name\""`, []string{"UPS", "ups", `Quoted "name"`}},
+	}
+
+	for _, tt := range tests {
+		result := splitQuoted(tt.line)
+		if strings.Join(result, "|") != strings.Join(tt.expected, "|") || len(result) != len(tt.expected) {
+			t.Errorf("splitQuoted(%q) = %q, expected %q", tt.line, result, tt.expected)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{`monuser`, `"monuser"`},
+		{`pass word`, `"pass word"`},
+		{`se"cr\et`, `"se\"cr\\et"`},
+		{``, `""`},
+	}
+
+	for _, tt := range tests {
+		result, err := quote(tt.value)
+		if err != nil || result != tt.expected {
+			t.Errorf("quote(%q) = %q, %v, expected %q", tt.value, result, err, tt.expected)
+		}
+		// The quoted value is read back unchanged
+		if parts := splitQuoted("PASSWORD " + result); len(parts) != 2 || parts[1] != tt.value {
+			t.Errorf("splitQuoted(%q) = %q, expected value %q", result, parts, tt.value)
+		}
+	}
+
+	for _, value := range []string{"pass\nLIST UPS", "pass\r"} {
+		if _, err := quote(value); err == nil {
+			t.Errorf("Expected error for line break in %q", value)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package opendtu
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/websocket"
+)
+
+// liveDataStatusPath is the REST endpoint of the OpenDTU returning the same
+// live data as the websocket
+const liveDataStatusPath = "/api/livedata/status"
+
+// defaultFallbackURL derives the live data REST endpoint from the websocket URL,
+// e.g. ws://opendtu.local/livedata becomes http://opendtu.local/api/livedata/status.
+func defaultFallbackURL(websocketURL string) (string, error) {
+	u, err := url.Parse(websocketURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid web_socket_url: %w", err)
+	}
+	switch u.Scheme {
+	case "wss", "https":
+		u.Scheme = "https"
+	default:
+		u.Scheme = "http"
+	}
+	u.Path = liveDataStatusPath
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// trackWebSocketState records since when the websocket is down.
+func (om *OpendtuModule) trackWebSocketState(state websocket.ConnectionState) {
+	om.stateMu.Lock()
+	defer om.stateMu.Unlock()
+
+	if state == websocket.StateConnected {
+		om.disconnectedSince = time.Time{}
+	} else if om.disconnectedSince.IsZero() {
+		om.disconnectedSince = om.clock.Now()
+	}
+}
+
+// FallbackDue reports whether the websocket has been down for longer than
+// fallback_after, so live data is polled from the REST endpoint instead.
+// Activation and deactivation of the fallback are logged once.
+func (om *OpendtuModule) FallbackDue() bool {
+	threshold := om.config.FallbackAfter.Duration()
+
+	om.stateMu.Lock()
+	defer om.stateMu.Unlock()
+
+	due := threshold > 0 && !om.disconnectedSince.IsZero() &&
+		om.clock.Now().Sub(om.disconnectedSince) >= threshold
+	if due != om.fallbackActive {
+		if due {
+			utils.Warnf("OpenDTU websocket down since %s, polling %s", om.disconnectedSince.Format(time.RFC3339), om.fallbackURL)
+		} else {
+			utils.Infof("OpenDTU websocket connected again, stopped polling %s", om.fallbackURL)
+		}
+		om.fallbackActive = due
+	}
+	return due
+}
+
+// runFallback polls the REST endpoint while the websocket is down.
+func (om *OpendtuModule) runFallback(ctx context.Context) {
+	if om.config.FallbackAfter.Duration() <= 0 {
+		return
+	}
+	interval := om.config.FallbackInterval.Duration()
+	if interval <= 0 {
+		interval = DefaultConfig().FallbackInterval.Duration()
+	}
+
+	utils.WithPanicRecoveryAndContinue("OpenDTU REST fallback", om.config.InstanceName("opendtu"), func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !om.FallbackDue() {
+					continue
+				}
+				if err := om.PollFallback(ctx); err != nil {
+					utils.Warnf("Failed to poll OpenDTU live data: %v", err)
+				}
+			}
+		}
+	})
+}
+
+// PollFallback fetches the live data from the REST endpoint and creates metrics
+// from it like from a websocket message. Inverters listed without their AC
+// values, as newer firmware does, are fetched one by one.
+func (om *OpendtuModule) PollFallback(ctx context.Context) error {
+	body, err := om.fetchLiveData(ctx, "")
+	if err != nil {
+		return err
+	}
+	if err := om.processMessage(body); err != nil {
+		return err
+	}
+
+	var status WebSocketMessage
+	if err := json.Unmarshal(body, &status); err != nil {
+		return fmt.Errorf("failed to parse live data: %w", err)
+	}
+	for _, inverter := range status.Inverters {
+		if _, ok := inverter.AC["0"]; ok {
+			continue
+		}
+		details, err := om.fetchLiveData(ctx, inverter.Serial)
+		if err != nil {
+			utils.Warnf("Failed to poll live data of inverter %s: %v", inverter.Serial, err)
+			continue
+		}
+		if err := om.processMessage(details); err != nil {
+			utils.Warnf("Failed to process live data of inverter %s: %v", inverter.Serial, err)
+		}
+	}
+	return nil
+}
+
+// fetchLiveData requests the live data of all inverters, or with their
+// details of a single inverter if serial is set.
+func (om *OpendtuModule) fetchLiveData(ctx context.Context, serial string) ([]byte, error) {
+	endpoint := om.fallbackURL
+	if serial != "" {
+		endpoint += "?inv=" + url.QueryEscape(serial)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := om.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch live data: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP request failed with status: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return body, nil
+}
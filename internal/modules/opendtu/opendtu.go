@@ -4,25 +4,44 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/janhuddel/metrics-agent/internal/config"
-	"github.com/janhuddel/metrics-agent/internal/metrics"
+	"github.com/janhuddel/metrics-agent/internal/connection"
 	"github.com/janhuddel/metrics-agent/internal/utils"
-	"github.com/janhuddel/metrics-agent/internal/websocket"
+	"github.com/janhuddel/metrics-agent/internal/wsclient"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+	"github.com/janhuddel/metrics-agent/pkg/websocket"
 )
 
 // Config represents the configuration for the Opendtu module
 type Config struct {
 	config.BaseConfig
-	WebSocketURL         string        `json:"web_socket_url"`
-	ReconnectInterval    time.Duration `json:"reconnect_interval,omitempty"`
-	MaxReconnectAttempts int           `json:"max_reconnect_attempts,omitempty"`
-	ConnectionTimeout    time.Duration `json:"connection_timeout,omitempty"`
-	ReadTimeout          time.Duration `json:"read_timeout,omitempty"`
-	WriteTimeout         time.Duration `json:"write_timeout,omitempty"`
-	MaxBackoffInterval   time.Duration `json:"max_backoff_interval,omitempty"`
-	BackoffMultiplier    float64       `json:"backoff_multiplier,omitempty"`
+	WebSocketURL         string          `json:"web_socket_url"`
+	ReconnectInterval    config.Duration `json:"reconnect_interval,omitempty"`
+	MaxReconnectAttempts int             `json:"max_reconnect_attempts,omitempty"`
+	ConnectionTimeout    config.Duration `json:"connection_timeout,omitempty"`
+	ReadTimeout          config.Duration `json:"read_timeout,omitempty"`
+	WriteTimeout         config.Duration `json:"write_timeout,omitempty"`
+	MaxBackoffInterval   config.Duration `json:"max_backoff_interval,omitempty"`
+	BackoffMultiplier    float64         `json:"backoff_multiplier,omitempty"`
+
+	// Timezone is the IANA timezone the inverters reset YieldDay in (e.g. "Europe/Berlin").
+	// Defaults to the global timezone.
+	Timezone string `json:"timezone,omitempty"`
+
+	// REST fallback used while the websocket is down
+	FallbackURL      string          `json:"fallback_url,omitempty"`      // Live data REST endpoint (defaults to /api/livedata/status on the websocket host)
+	FallbackAfter    config.Duration `json:"fallback_after,omitempty"`    // Websocket downtime before polling starts (defaults to 1m, 0 disables)
+	FallbackInterval config.Duration `json:"fallback_interval,omitempty"` // Polling interval while the fallback is active (defaults to 10s)
+}
+
+// yieldDayState holds the last YieldDay value reported by an inverter
+type yieldDayState struct {
+	value     float64
+	timestamp time.Time
 }
 
 // MeasurementValue represents a single measurement with value, unit, and decimal places
@@ -104,58 +123,138 @@ type OpendtuModule struct {
 	config    Config
 	wsClient  *websocket.Client
 	metricsCh chan<- metrics.Metric
+	clock     utils.Clock
+	location  *time.Location
+	yieldDays map[string]yieldDayState
+	tracker   *connection.Tracker
+
+	// REST fallback state, see fallback.go
+	httpClient        *http.Client
+	fallbackURL       string
+	stateMu           sync.Mutex
+	disconnectedSince time.Time // Zero while the websocket is connected
+	fallbackActive    bool
+	processMu         sync.Mutex // Serializes websocket messages and REST polls
 }
 
 func Run(ctx context.Context, ch chan<- metrics.Metric) error {
-	config := LoadConfig()
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
 	module, err := NewOpendtuModule(config)
 	if err != nil {
 		return fmt.Errorf("failed to create Opendtu module: %w", err)
 	}
 	module.metricsCh = ch
+	module.SetClock(utils.ClockFromContext(ctx))
 
 	return module.run(ctx)
 }
 
+// Probe validates the Opendtu configuration and checks that the OpenDTU is reachable
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	if _, err := NewOpendtuModule(cfg); err != nil {
+		return &config.ModuleError{Module: "opendtu", Err: err}
+	}
+	return utils.ProbeURL(ctx, cfg.WebSocketURL, cfg.ConnectionTimeout.Duration())
+}
+
+// SelfTest checks that the websocket handshake with the OpenDTU succeeds
+func SelfTest(ctx context.Context) []utils.Check {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return []utils.Check{{Name: "configuration", Err: err}}
+	}
+	check := utils.Check{Name: "websocket handshake"}
+	client, err := wsclient.New(ctx, websocket.Config{
+		URL:               cfg.WebSocketURL,
+		ConnectionTimeout: cfg.ConnectionTimeout,
+	}, func([]byte) error { return nil })
+	if err != nil {
+		check.Err = err
+		return []utils.Check{check}
+	}
+	if check.Err = client.Probe(ctx, nil); check.Err == nil {
+		check.Detail = "connected to " + cfg.WebSocketURL
+	}
+	return []utils.Check{check}
+}
+
 // NewOpendtuModule creates a new Opendtu module instance
-func NewOpendtuModule(config Config) (*OpendtuModule, error) {
+func NewOpendtuModule(cfg Config) (*OpendtuModule, error) {
 	utils.Debugf("Creating new Opendtu module instance")
 
-	websocketURL := config.WebSocketURL
+	websocketURL := cfg.WebSocketURL
 	if websocketURL == "" {
 		return nil, fmt.Errorf("web_socket_url is required but not configured")
 	}
 
+	location := utils.Location()
+	if cfg.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
+
+	fallbackURL := cfg.FallbackURL
+	if fallbackURL == "" {
+		var err error
+		if fallbackURL, err = defaultFallbackURL(websocketURL); err != nil {
+			return nil, err
+		}
+	}
+
 	utils.Debugf("Opendtu module created successfully")
 	return &OpendtuModule{
-		config: config,
+		clock:     utils.SystemClock,
+		config:    cfg,
+		location:  location,
+		yieldDays: make(map[string]yieldDayState),
+		httpClient: &http.Client{
+			Timeout:   cfg.ConnectionTimeout.Duration(),
+			Transport: utils.DeviceTransport(cfg.InstanceName("opendtu"), nil),
+		},
+		fallbackURL: fallbackURL,
 	}, nil
 }
 
-// LoadConfig loads the Opendtu module configuration
-func LoadConfig() Config {
-	defaultConfig := Config{
-		ReconnectInterval:    5 * time.Second,
+// DefaultConfig returns the default configuration of the Opendtu module.
+func DefaultConfig() Config {
+	return Config{
+		ReconnectInterval:    config.Duration(5 * time.Second),
 		MaxReconnectAttempts: 10,
-		ConnectionTimeout:    10 * time.Second,
-		ReadTimeout:          30 * time.Second,
-		WriteTimeout:         10 * time.Second,
-		MaxBackoffInterval:   60 * time.Second,
+		ConnectionTimeout:    config.Duration(10 * time.Second),
+		ReadTimeout:          config.Duration(30 * time.Second),
+		WriteTimeout:         config.Duration(10 * time.Second),
+		MaxBackoffInterval:   config.Duration(60 * time.Second),
 		BackoffMultiplier:    2.0,
+		FallbackAfter:        config.Duration(time.Minute),
+		FallbackInterval:     config.Duration(10 * time.Second),
 	}
+}
+
+// LoadConfig loads the Opendtu module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
 
 	loader := config.NewLoader("opendtu")
+	loader.SetInstance(instance)
 	if config.GlobalConfigPath != "" {
 		loader.SetConfigPath(config.GlobalConfigPath)
 	}
 
 	loadedConfig, err := loader.LoadConfig(&defaultConfig)
 	if err != nil {
-		utils.Warnf("Failed to load Opendtu configuration: %v", err)
-		return defaultConfig
+		return defaultConfig, err
 	}
 
-	return *loadedConfig.(*Config)
+	return *loadedConfig.(*Config), nil
 }
 
 // run executes the main module loop with robust reconnection handling
@@ -173,17 +272,45 @@ func (om *OpendtuModule) run(ctx context.Context) error {
 	}
 
 	// Create websocket client with message handler
-	wsClient, err := websocket.NewClient(wsConfig, om.processMessage)
+	wsClient, err := wsclient.New(ctx, wsConfig, om.processMessage)
 	if err != nil {
 		return fmt.Errorf("failed to create websocket client: %w", err)
 	}
+	wsClient.SetStateChangeHandler(om.handleStateChange)
+
+	// The websocket counts as down until it connected for the first time
+	om.stateMu.Lock()
+	om.disconnectedSince = om.clock.Now()
+	om.stateMu.Unlock()
+	go om.runFallback(ctx)
+
+	// Run the websocket client; stopping with the context is no error
+	if err := wsClient.Run(ctx); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
 
-	// Run the websocket client
-	return wsClient.Run(ctx)
+// handleStateChange reports the connection to the OpenDTU as established or lost
+func (om *OpendtuModule) handleStateChange(oldState, newState websocket.ConnectionState) {
+	utils.Debugf("OpenDTU connection state changed: %s -> %s", oldState, newState)
+	om.connection().HandleWebSocketState(oldState, newState)
+	om.trackWebSocketState(newState)
+}
+
+// connection returns the tracker for the websocket connection, creating it on first use
+func (om *OpendtuModule) connection() *connection.Tracker {
+	if om.tracker == nil {
+		om.tracker = connection.NewTracker(om.config.InstanceName("opendtu"), om.config.WebSocketURL, om.metricsCh)
+	}
+	return om.tracker
 }
 
 // processMessage parses a websocket message and creates metrics from the payload
 func (om *OpendtuModule) processMessage(message []byte) error {
+	om.processMu.Lock()
+	defer om.processMu.Unlock()
+
 	// Parse the JSON message
 	var wsMessage WebSocketMessage
 	if err := json.Unmarshal(message, &wsMessage); err != nil {
@@ -196,7 +323,7 @@ func (om *OpendtuModule) processMessage(message []byte) error {
 
 // createMetricsFromPayload creates metrics from the websocket message payload
 func (om *OpendtuModule) createMetricsFromPayload(wsMessage WebSocketMessage) error {
-	timestamp := time.Now()
+	timestamp := om.clock.Now()
 
 	// Process inverter-specific metrics
 	for _, inverter := range wsMessage.Inverters {
@@ -213,6 +340,11 @@ func (om *OpendtuModule) SetMetricsChannel(ch chan<- metrics.Metric) {
 	om.metricsCh = ch
 }
 
+// SetClock sets the clock the module takes timestamps and the websocket downtime from
+func (om *OpendtuModule) SetClock(clock utils.Clock) {
+	om.clock = clock
+}
+
 // GetConfig returns the module configuration (for testing)
 func (om *OpendtuModule) GetConfig() Config {
 	return om.config
@@ -223,6 +355,11 @@ func (om *OpendtuModule) ProcessMessage(message []byte) error {
 	return om.processMessage(message)
 }
 
+// HandleStateChange handles a websocket connection state change (public method for testing)
+func (om *OpendtuModule) HandleStateChange(oldState, newState websocket.ConnectionState) {
+	om.handleStateChange(oldState, newState)
+}
+
 // CreateInverterMetrics creates metrics for a specific inverter (public method for testing)
 func (om *OpendtuModule) CreateInverterMetrics(inverter InverterData, timestamp time.Time) error {
 	return om.createInverterMetrics(inverter, timestamp)
@@ -260,10 +397,11 @@ func (om *OpendtuModule) createInverterMetrics(inverter InverterData, timestamp
 
 	// Create and send the metric
 	metric := metrics.Metric{
-		Name:      "electricity",
-		Tags:      tags,
-		Fields:    fields,
-		Timestamp: timestamp,
+		Name:       "electricity",
+		Tags:       tags,
+		Fields:     fields,
+		FieldKinds: metrics.ElectricityKinds,
+		Timestamp:  timestamp,
 	}
 
 	// Validate the metric before sending
@@ -279,5 +417,49 @@ func (om *OpendtuModule) createInverterMetrics(inverter InverterData, timestamp
 		utils.Warnf("Metrics channel is full, dropping inverter metric")
 	}
 
+	om.trackYieldDay(inverter.Serial, tags, phase0.YieldDay.Value, timestamp)
 	return nil
 }
+
+// trackYieldDay detects the daily YieldDay reset of an inverter. When YieldDay drops
+// after the day changed in the inverter's timezone, the last value before the reset
+// is sent as the final daily total, timestamped at the end of the day it belongs to.
+// Drops within the same day (e.g. glitches while the inverter is unreachable) are ignored.
+func (om *OpendtuModule) trackYieldDay(serial string, tags map[string]string, value float64, timestamp time.Time) {
+	last, exists := om.yieldDays[serial]
+	if exists && value < last.value {
+		if sameDay(last.timestamp, timestamp, om.location) {
+			return
+		}
+		om.sendDailyTotal(tags, last)
+	}
+	om.yieldDays[serial] = yieldDayState{value: value, timestamp: timestamp}
+}
+
+// sendDailyTotal sends the final YieldDay value of a day.
+func (om *OpendtuModule) sendDailyTotal(tags map[string]string, last yieldDayState) {
+	local := last.timestamp.In(om.location)
+	endOfDay := time.Date(local.Year(), local.Month(), local.Day(), 23, 59, 59, 0, om.location)
+
+	metric := metrics.Metric{
+		Name:      "electricity",
+		Tags:      tags,
+		Fields:    map[string]interface{}{"sum_power_day_final": last.value},
+		Kind:      metrics.KindCumulative,
+		Timestamp: endOfDay,
+	}
+	utils.Debugf("Inverter %s: YieldDay reset detected, final total of %s: %v", tags["device"], local.Format("2006-01-02"), last.value)
+
+	select {
+	case om.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping daily total metric")
+	}
+}
+
+// sameDay reports whether both times fall on the same day in the given location.
+func sameDay(a, b time.Time, location *time.Location) bool {
+	y1, m1, d1 := a.In(location).Date()
+	y2, m2, d2 := b.In(location).Date()
+	return y1 == y2 && m1 == m2 && d1 == d2
+}
@@ -1,14 +1,21 @@
 package opendtu_test
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
-	"github.com/janhuddel/metrics-agent/internal/metrics"
+	"github.com/janhuddel/metrics-agent/internal/config"
 	"github.com/janhuddel/metrics-agent/internal/modules/opendtu"
+	"github.com/janhuddel/metrics-agent/internal/testutil"
 	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+	"github.com/janhuddel/metrics-agent/pkg/websocket"
 )
 
 // TestLoadConfig tests the configuration loading functionality.
@@ -16,7 +23,8 @@ func TestLoadConfig(t *testing.T) {
 	tah := utils.NewTestAssertionHelper()
 
 	// Test loading default configuration
-	config := opendtu.LoadConfig()
+	config, err := opendtu.LoadConfig("")
+	tah.AssertNoError(t, err, "Expected default configuration to load")
 
 	// Verify that config is returned (even if empty)
 	tah.AssertNotNil(t, config, "Expected config to be returned")
@@ -718,3 +726,190 @@ func TestInverterDataStruct(t *testing.T) {
 		t.Errorf("Expected LimitRelative 100, got %d", inverter.LimitRelative)
 	}
 }
+
+func TestYieldDayRollover(t *testing.T) {
+	tah := utils.NewTestAssertionHelper()
+
+	module, err := opendtu.NewOpendtuModule(opendtu.Config{
+		WebSocketURL: "ws://localhost:8080/ws",
+		Timezone:     "Europe/Berlin",
+	})
+	tah.AssertNoError(t, err, "Failed to create module")
+
+	metricsCh := make(chan metrics.Metric, 10)
+	module.SetMetricsChannel(metricsCh)
+
+	send := func(yieldDay float64, timestamp time.Time) []metrics.Metric {
+		inverter := opendtu.InverterData{
+			Serial: "1234567890",
+			Name:   "Test Inverter",
+			AC: map[string]opendtu.ACMeasurement{
+				"0": {YieldDay: opendtu.MeasurementValue{Value: yieldDay}},
+			},
+		}
+		tah.AssertNoError(t, module.CreateInverterMetrics(inverter, timestamp), "Expected metric creation to succeed")
+
+		var sent []metrics.Metric
+		for len(metricsCh) > 0 {
+			sent = append(sent, <-metricsCh)
+		}
+		return sent
+	}
+
+	send(5000, time.Date(2026, 10, 14, 15, 0, 0, 0, time.UTC))
+	send(5200, time.Date(2026, 10, 14, 17, 0, 0, 0, time.UTC))
+
+	// A drop on the same day is a glitch and doesn't end the day
+	if sent := send(0, time.Date(2026, 10, 14, 18, 0, 0, 0, time.UTC)); len(sent) != 1 {
+		t.Fatalf("Expected no daily total for drop within the day, got %d metrics", len(sent))
+	}
+
+	// The reset after midnight in Berlin emits the final total of the previous day
+	sent := send(10, time.Date(2026, 10, 15, 5, 0, 0, 0, time.UTC))
+	if len(sent) != 2 {
+		t.Fatalf("Expected inverter metric and daily total, got %d metrics", len(sent))
+	}
+	total := sent[1]
+	if total.Fields["sum_power_day_final"] != 5200.0 {
+		t.Errorf("Expected final daily total 5200, got %v", total.Fields["sum_power_day_final"])
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	if expected := time.Date(2026, 10, 14, 23, 59, 59, 0, berlin); !total.Timestamp.Equal(expected) {
+		t.Errorf("Expected daily total at %v, got %v", expected, total.Timestamp)
+	}
+	if total.Tags["device"] != "1234567890" {
+		t.Errorf("Expected device tag, got %v", total.Tags)
+	}
+}
+
+func TestNewOpendtuModuleInvalidTimezone(t *testing.T) {
+	_, err := opendtu.NewOpendtuModule(opendtu.Config{WebSocketURL: "ws://localhost:8080/ws", Timezone: "Mars/Olympus"})
+	if err == nil {
+		t.Error("Expected error for invalid timezone")
+	}
+}
+
+func TestHandleStateChange(t *testing.T) {
+	module, err := opendtu.NewOpendtuModule(opendtu.Config{WebSocketURL: "ws://localhost:8080/ws"})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.SetMetricsChannel(ch)
+
+	module.HandleStateChange(websocket.StateDisconnected, websocket.StateConnecting)
+	module.HandleStateChange(websocket.StateConnecting, websocket.StateConnected)
+	module.HandleStateChange(websocket.StateConnected, websocket.StateDisconnected)
+	module.HandleStateChange(websocket.StateDisconnected, websocket.StateReconnecting)
+
+	if len(ch) != 2 {
+		t.Fatalf("Expected 2 connection status metrics, got %d", len(ch))
+	}
+	for _, want := range []int{1, 0} {
+		metric := <-ch
+		if metric.Name != "connection_status" || metric.Tags["module"] != "opendtu" || metric.Tags["endpoint"] != "ws://localhost:8080/ws" {
+			t.Errorf("Unexpected metric %+v", metric)
+		}
+		if metric.Fields["status"] != want {
+			t.Errorf("Expected status=%d, got %v", want, metric.Fields["status"])
+		}
+	}
+}
+
+func TestFallbackDue(t *testing.T) {
+	module, err := opendtu.NewOpendtuModule(opendtu.Config{
+		WebSocketURL:  "ws://localhost:8080/livedata",
+		FallbackAfter: config.Duration(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	clock := testutil.NewClock(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	module.SetClock(clock)
+	module.SetMetricsChannel(make(chan metrics.Metric, 10))
+
+	module.HandleStateChange(websocket.StateConnected, websocket.StateReconnecting)
+	clock.Advance(59 * time.Second)
+	if module.FallbackDue() {
+		t.Error("Expected no fallback before the threshold")
+	}
+	clock.Advance(time.Second)
+	if !module.FallbackDue() {
+		t.Error("Expected fallback once the websocket is down for the threshold")
+	}
+
+	// Further state changes while down don't restart the downtime
+	module.HandleStateChange(websocket.StateReconnecting, websocket.StateConnecting)
+	if !module.FallbackDue() {
+		t.Error("Expected fallback to stay active while reconnecting")
+	}
+
+	module.HandleStateChange(websocket.StateConnecting, websocket.StateConnected)
+	if module.FallbackDue() {
+		t.Error("Expected no fallback once the websocket is connected")
+	}
+}
+
+func TestPollFallback(t *testing.T) {
+	inverter := `{"serial": "%s", "name": "Roof", "AC": {"0": {"Power": {"v": 480.5}, "Voltage": {"v": 231}, "Current": {"v": 2.1}, "YieldDay": {"v": 1200}, "YieldTotal": {"v": 3400.5}}}}`
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/livedata/status" {
+			http.NotFound(w, r)
+			return
+		}
+		queries = append(queries, r.URL.RawQuery)
+		if serial := r.URL.Query().Get("inv"); serial != "" {
+			fmt.Fprintf(w, `{"inverters": [`+inverter+`]}`, serial)
+			return
+		}
+		// Newer firmware lists inverters without their values
+		fmt.Fprintf(w, `{"inverters": [`+inverter+`, {"serial": "222", "name": "Garage"}]}`, "111")
+	}))
+	defer server.Close()
+
+	module, err := opendtu.NewOpendtuModule(opendtu.Config{
+		WebSocketURL: "ws" + strings.TrimPrefix(server.URL, "http") + "/livedata",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.SetMetricsChannel(ch)
+
+	if err := module.PollFallback(context.Background()); err != nil {
+		t.Fatalf("PollFallback failed: %v", err)
+	}
+	if len(queries) != 2 || queries[1] != "inv=222" {
+		t.Errorf("Expected the status and the details of inverter 222 to be requested, got %q", queries)
+	}
+	if len(ch) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(ch))
+	}
+	for _, device := range []string{"111", "222"} {
+		metric := <-ch
+		if metric.Tags["device"] != device || metric.Fields["power"] != 480.5 {
+			t.Errorf("Unexpected metric %+v", metric)
+		}
+	}
+}
+
+func TestPollFallbackError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	module, err := opendtu.NewOpendtuModule(opendtu.Config{
+		WebSocketURL: "ws://localhost:8080/livedata",
+		FallbackURL:  server.URL + "/api/livedata/status",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	module.SetMetricsChannel(make(chan metrics.Metric, 10))
+
+	if err := module.PollFallback(context.Background()); err == nil {
+		t.Error("Expected an error for a failed request")
+	}
+}
@@ -0,0 +1,319 @@
+// Package proxmox provides a metric collection module for Proxmox VE hosts.
+// It queries the Proxmox cluster resources API and reports CPU, memory, disk and
+// network statistics for each node, virtual machine and container.
+package proxmox
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/connection"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// Resource types reported by the cluster resources API
+	resourceTypeNode = "node"
+	resourceTypeQemu = "qemu"
+	resourceTypeLXC  = "lxc"
+
+	// Metric names
+	metricNameGuest = "virtual_machine"
+	metricNameNode  = "hypervisor"
+)
+
+// guestCounterKinds are the kinds of the traffic fields, which Proxmox reports
+// as totals since the guest was started
+var guestCounterKinds = map[string]metrics.Kind{
+	"net_in":     metrics.KindCounter,
+	"net_out":    metrics.KindCounter,
+	"disk_read":  metrics.KindCounter,
+	"disk_write": metrics.KindCounter,
+}
+
+// Config represents the configuration for the Proxmox module
+type Config struct {
+	config.BaseConfig
+	URL                string          `json:"url"`                            // Proxmox API base URL (e.g. "https://pve.local:8006")
+	TokenID            string          `json:"token_id"`                       // API token ID (e.g. "monitoring@pve!metrics")
+	TokenSecret        string          `json:"token_secret"`                   // API token secret
+	InsecureSkipVerify bool            `json:"insecure_skip_verify,omitempty"` // Skip TLS verification for self-signed certificates
+	Interval           config.Duration `json:"interval,omitempty"`             // Polling interval (defaults to 60s)
+	Timeout            config.Duration `json:"timeout,omitempty"`              // HTTP request timeout (defaults to 30s)
+}
+
+// ResourcesResponse represents the response from the cluster resources endpoint
+type ResourcesResponse struct {
+	Data []Resource `json:"data"`
+}
+
+// Resource represents a node, VM or container in the cluster
+type Resource struct {
+	ID        string  `json:"id"`
+	Type      string  `json:"type"`
+	Node      string  `json:"node"`
+	VMID      int     `json:"vmid"`
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	CPU       float64 `json:"cpu"`
+	MaxCPU    float64 `json:"maxcpu"`
+	Mem       int64   `json:"mem"`
+	MaxMem    int64   `json:"maxmem"`
+	Disk      int64   `json:"disk"`
+	MaxDisk   int64   `json:"maxdisk"`
+	Uptime    int64   `json:"uptime"`
+	NetIn     int64   `json:"netin"`
+	NetOut    int64   `json:"netout"`
+	DiskRead  int64   `json:"diskread"`
+	DiskWrite int64   `json:"diskwrite"`
+	Template  int     `json:"template"`
+}
+
+// ProxmoxModule handles polling of the Proxmox API
+type ProxmoxModule struct {
+	config      Config
+	httpClient  *http.Client
+	metricsCh   chan<- metrics.Metric
+	clock       utils.Clock
+	collections *utils.CollectionLog // last successful collection, nil if not remembered
+	tracker     *connection.Tracker
+}
+
+// Run starts the Proxmox module and begins collecting metrics
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewProxmoxModule(config)
+	if err != nil {
+		return fmt.Errorf("failed to create Proxmox module: %w", err)
+	}
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+	module.collections = utils.OpenCollectionLog(config.InstanceName("proxmox"), module.clock)
+
+	return module.run(ctx)
+}
+
+// Probe validates the Proxmox configuration and checks that the Proxmox API is reachable
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewProxmoxModule(cfg)
+	if err != nil {
+		return &config.ModuleError{Module: "proxmox", Err: err}
+	}
+	return utils.ProbeURL(ctx, module.config.URL, module.config.Timeout.Duration())
+}
+
+// NewProxmoxModule creates a new Proxmox module instance
+func NewProxmoxModule(cfg Config) (*ProxmoxModule, error) {
+	utils.Debugf("Creating new Proxmox module instance")
+
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("url is required but not configured")
+	}
+	if cfg.TokenID == "" || cfg.TokenSecret == "" {
+		return nil, fmt.Errorf("token_id and token_secret are required but not configured")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(60 * time.Second)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = config.Duration(30 * time.Second)
+	}
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.InsecureSkipVerify {
+		utils.Warnf("TLS certificate verification is disabled for Proxmox API %s", cfg.URL)
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	utils.Debugf("Proxmox module created successfully")
+	return &ProxmoxModule{
+		clock:  utils.SystemClock,
+		config: cfg,
+		httpClient: &http.Client{
+			Timeout:   cfg.Timeout.Duration(),
+			Transport: utils.OutboundTransport(cfg.InstanceName("proxmox"), transport),
+		},
+	}, nil
+}
+
+// DefaultConfig returns the default configuration of the Proxmox module.
+func DefaultConfig() Config {
+	return Config{
+		Interval: config.Duration(60 * time.Second),
+		Timeout:  config.Duration(30 * time.Second),
+	}
+}
+
+// LoadConfig loads the Proxmox module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("proxmox")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
+
+// run executes the main module loop
+func (pm *ProxmoxModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Proxmox module", "main", func() error {
+		interval := pm.config.Interval.Duration()
+		ticker := utils.NewScheduledTicker(ctx, interval)
+		defer ticker.Stop()
+
+		// Collect initial data unless outside the collection schedule or skipped,
+		// but not before an interval has passed since the last collection
+		initial := pm.collections.Initial(ctx, interval)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-initial:
+				if err := utils.CollectWithRetry(ctx, interval, pm.collectData); err != nil {
+					utils.Warnf("Failed to collect initial Proxmox data: %v", err)
+				} else {
+					pm.collections.Record()
+				}
+			case <-ticker.C:
+				if err := utils.CollectWithRetry(ctx, interval, pm.collectData); err != nil {
+					utils.Warnf("Failed to collect Proxmox data: %v", err)
+				} else {
+					pm.collections.Record()
+				}
+			}
+		}
+	})
+}
+
+// collectData fetches the cluster resources and sends metrics for nodes and guests
+func (pm *ProxmoxModule) collectData(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Proxmox data collection", "api", func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, pm.config.URL+"/api2/json/cluster/resources", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", pm.config.TokenID, pm.config.TokenSecret))
+
+		resp, err := pm.httpClient.Do(req)
+		pm.connection().SetPollResult(resp, err)
+		if err != nil {
+			return fmt.Errorf("API request failed: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		}
+
+		var response ResourcesResponse
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			return fmt.Errorf("failed to parse API response: %w", err)
+		}
+
+		timestamp := pm.clock.Now()
+		for _, resource := range response.Data {
+			switch resource.Type {
+			case resourceTypeNode:
+				pm.sendResourceMetric(metricNameNode, resource.Node, resource.Node, resource, timestamp)
+			case resourceTypeQemu, resourceTypeLXC:
+				if resource.Template == 1 {
+					continue
+				}
+				deviceID := strconv.Itoa(resource.VMID)
+				pm.sendResourceMetric(metricNameGuest, deviceID, resource.Name, resource, timestamp)
+			}
+		}
+
+		return nil
+	})
+}
+
+// connection returns the tracker for the Proxmox API, creating it on first use
+func (pm *ProxmoxModule) connection() *connection.Tracker {
+	if pm.tracker == nil {
+		pm.tracker = connection.NewTracker(pm.config.InstanceName("proxmox"), pm.config.URL, pm.metricsCh)
+	}
+	return pm.tracker
+}
+
+// sendResourceMetric creates and sends a metric for a node or guest
+func (pm *ProxmoxModule) sendResourceMetric(name, deviceID, defaultName string, resource Resource, timestamp time.Time) {
+	tags := map[string]string{
+		"vendor":   "proxmox",
+		"device":   deviceID,
+		"friendly": pm.config.GetFriendlyName(deviceID, defaultName, deviceID),
+		"node":     resource.Node,
+	}
+	if resource.Type != resourceTypeNode {
+		tags["type"] = resource.Type
+	}
+
+	running := resource.Status == "running" || resource.Status == "online"
+	fields := map[string]interface{}{
+		"status":  resource.Status,
+		"running": running,
+	}
+
+	// Stopped guests and offline nodes only report their status
+	if running {
+		fields["cpu_usage"] = resource.CPU * 100
+		fields["cpus"] = resource.MaxCPU
+		fields["memory_used"] = resource.Mem
+		fields["memory_total"] = resource.MaxMem
+		fields["disk_used"] = resource.Disk
+		fields["disk_total"] = resource.MaxDisk
+		fields["uptime"] = resource.Uptime
+		if resource.Type != resourceTypeNode {
+			fields["net_in"] = resource.NetIn
+			fields["net_out"] = resource.NetOut
+			fields["disk_read"] = resource.DiskRead
+			fields["disk_write"] = resource.DiskWrite
+		}
+	}
+
+	metric := metrics.Metric{
+		Name:       name,
+		Tags:       tags,
+		Fields:     fields,
+		FieldKinds: guestCounterKinds,
+		Timestamp:  timestamp,
+	}
+
+	if err := metric.Validate(); err != nil {
+		utils.Warnf("Invalid metric for Proxmox resource %s: %v", resource.ID, err)
+		return
+	}
+
+	select {
+	case pm.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping metric for Proxmox resource %s", resource.ID)
+	}
+}
@@ -0,0 +1,109 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestNewProxmoxModule(t *testing.T) {
+	tah := utils.NewTestAssertionHelper()
+
+	_, err := NewProxmoxModule(Config{TokenID: "id", TokenSecret: "secret"})
+	tah.AssertError(t, err, "Expected error for missing url")
+
+	_, err = NewProxmoxModule(Config{URL: "https://pve:8006"})
+	tah.AssertError(t, err, "Expected error for missing token")
+
+	module, err := NewProxmoxModule(Config{URL: "https://pve:8006/", TokenID: "id", TokenSecret: "secret"})
+	tah.AssertNoError(t, err, "Failed to create Proxmox module")
+	if module.config.URL != "https://pve:8006" {
+		t.Errorf("Expected trailing slash to be removed, got %s", module.config.URL)
+	}
+}
+
+func TestCollectData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api2/json/cluster/resources" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "PVEAPIToken=monitoring@pve!metrics=secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"data":[
+			{"id":"node/pve","type":"node","node":"pve","status":"online","cpu":0.05,"maxcpu":8,"mem":4000,"maxmem":16000,"disk":100,"maxdisk":1000,"uptime":3600},
+			{"id":"qemu/100","type":"qemu","node":"pve","vmid":100,"name":"homeassistant","status":"running","cpu":0.25,"maxcpu":2,"mem":1000,"maxmem":2000,"netin":10,"netout":20,"uptime":60},
+			{"id":"lxc/101","type":"lxc","node":"pve","vmid":101,"name":"influxdb","status":"stopped","maxcpu":1,"maxmem":512},
+			{"id":"qemu/9000","type":"qemu","node":"pve","vmid":9000,"name":"template","status":"stopped","template":1},
+			{"id":"storage/pve/local","type":"storage","node":"pve","status":"available"}
+		]}`)
+	}))
+	defer server.Close()
+
+	module, err := NewProxmoxModule(Config{URL: server.URL, TokenID: "monitoring@pve!metrics", TokenSecret: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.metricsCh = ch
+
+	if err := module.collectData(context.Background()); err != nil {
+		t.Fatalf("collectData failed: %v", err)
+	}
+
+	if len(ch) != 4 {
+		t.Fatalf("Expected 4 metrics (connection status, node, VM, container), got %d", len(ch))
+	}
+
+	if status := <-ch; status.Name != "connection_status" || status.Fields["status"] != 1 {
+		t.Errorf("Unexpected connection status metric: %+v", status)
+	}
+
+	node := <-ch
+	if node.Name != "hypervisor" || node.Tags["device"] != "pve" {
+		t.Errorf("Unexpected node metric: %+v", node)
+	}
+	if node.Fields["cpu_usage"] != 5.0 || node.Fields["running"] != true {
+		t.Errorf("Unexpected node fields: %v", node.Fields)
+	}
+
+	vm := <-ch
+	if vm.Name != "virtual_machine" || vm.Tags["device"] != "100" || vm.Tags["friendly"] != "homeassistant" || vm.Tags["type"] != "qemu" {
+		t.Errorf("Unexpected VM metric: %+v", vm)
+	}
+	if vm.Fields["cpu_usage"] != 25.0 || vm.Fields["memory_used"] != int64(1000) || vm.Fields["net_out"] != int64(20) {
+		t.Errorf("Unexpected VM fields: %v", vm.Fields)
+	}
+
+	container := <-ch
+	if container.Tags["type"] != "lxc" || container.Fields["running"] != false {
+		t.Errorf("Unexpected container metric: %+v", container)
+	}
+	if _, exists := container.Fields["cpu_usage"]; exists {
+		t.Error("Stopped guests should only report their status")
+	}
+}
+
+func TestCollectDataUnauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	module, err := NewProxmoxModule(Config{URL: server.URL, TokenID: "id", TokenSecret: "wrong"})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	module.metricsCh = make(chan metrics.Metric, 1)
+
+	if err := module.collectData(context.Background()); err == nil {
+		t.Error("Expected error for unauthorized request")
+	}
+}
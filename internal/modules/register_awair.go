@@ -0,0 +1,12 @@
+//go:build awair || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/awair"
+
+func init() {
+	must(Global.Register("awair", awair.Run))
+	must(Global.RegisterProbe("awair", awair.Probe))
+	must(Global.RegisterConfig("awair", awair.DefaultConfig()))
+	must(Global.RegisterInfo("awair", "Air quality and climate of Awair monitors via their local API", "climate", "air_quality"))
+}
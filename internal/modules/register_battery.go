@@ -0,0 +1,12 @@
+//go:build battery || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/battery"
+
+func init() {
+	must(Global.Register("battery", battery.Run))
+	must(Global.RegisterProbe("battery", battery.Probe))
+	must(Global.RegisterConfig("battery", battery.DefaultConfig()))
+	must(Global.RegisterInfo("battery", "Battery storage systems (sonnenBatterie, E3DC)", "energy_storage"))
+}
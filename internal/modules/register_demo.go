@@ -0,0 +1,11 @@
+//go:build demo || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/demo"
+
+func init() {
+	must(Global.Register("demo", demo.Run))
+	must(Global.RegisterConfig("demo", demo.DefaultConfig()))
+	must(Global.RegisterInfo("demo", "Sample metrics or a simulated household for testing the agent and trying dashboards", "demo_metric", "electricity"))
+}
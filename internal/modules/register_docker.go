@@ -0,0 +1,12 @@
+//go:build docker || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/docker"
+
+func init() {
+	must(Global.Register("docker", docker.Run))
+	must(Global.RegisterProbe("docker", docker.Probe))
+	must(Global.RegisterConfig("docker", docker.DefaultConfig()))
+	must(Global.RegisterInfo("docker", "CPU, memory, network and restarts of Docker containers on the host", "container"))
+}
@@ -0,0 +1,12 @@
+//go:build dwd || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/dwd"
+
+func init() {
+	must(Global.Register("dwd", dwd.Run))
+	must(Global.RegisterProbe("dwd", dwd.Probe))
+	must(Global.RegisterConfig("dwd", dwd.DefaultConfig()))
+	must(Global.RegisterInfo("dwd", "Official weather warnings of the Deutscher Wetterdienst (DWD)", "weather_warning", "weather_warning_event"))
+}
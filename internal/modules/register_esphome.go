@@ -0,0 +1,13 @@
+//go:build esphome || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/esphome"
+
+func init() {
+	must(Global.Register("esphome", esphome.Run))
+	must(Global.RegisterProbe("esphome", esphome.Probe))
+	must(Global.RegisterSelfTest("esphome", esphome.SelfTest))
+	must(Global.RegisterConfig("esphome", esphome.DefaultConfig()))
+	must(Global.RegisterInfo("esphome", "Sensors, binary sensors and switches of ESPHome devices via MQTT", "climate", "electricity", "esphome"))
+}
@@ -0,0 +1,12 @@
+//go:build knx || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/knx"
+
+func init() {
+	must(Global.Register("knx", knx.Run))
+	must(Global.RegisterProbe("knx", knx.Probe))
+	must(Global.RegisterConfig("knx", knx.DefaultConfig()))
+	must(Global.RegisterInfo("knx", "Group telegrams of a KNX installation via a KNXnet/IP gateway", "knx"))
+}
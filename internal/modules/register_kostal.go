@@ -0,0 +1,12 @@
+//go:build kostal || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/kostal"
+
+func init() {
+	must(Global.Register("kostal", kostal.Run))
+	must(Global.RegisterProbe("kostal", kostal.Probe))
+	must(Global.RegisterConfig("kostal", kostal.DefaultConfig()))
+	must(Global.RegisterInfo("kostal", "Kostal Plenticore and Steca coolcept fleX inverters via REST API or SunSpec Modbus", "electricity"))
+}
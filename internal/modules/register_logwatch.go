@@ -0,0 +1,12 @@
+//go:build logwatch || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/logwatch"
+
+func init() {
+	must(Global.Register("logwatch", logwatch.Run))
+	must(Global.RegisterProbe("logwatch", logwatch.Probe))
+	must(Global.RegisterConfig("logwatch", logwatch.DefaultConfig()))
+	must(Global.RegisterInfo("logwatch", "Counts of journald or syslog messages matching configured rules", "log_events"))
+}
@@ -0,0 +1,13 @@
+//go:build lorawan || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/lorawan"
+
+func init() {
+	must(Global.Register("lorawan", lorawan.Run))
+	must(Global.RegisterProbe("lorawan", lorawan.Probe))
+	must(Global.RegisterSelfTest("lorawan", lorawan.SelfTest))
+	must(Global.RegisterConfig("lorawan", lorawan.DefaultConfig()))
+	must(Global.RegisterInfo("lorawan", "LoRaWAN sensors via The Things Network or ChirpStack MQTT uplinks", "lorawan"))
+}
@@ -0,0 +1,13 @@
+//go:build meter || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/meter"
+
+func init() {
+	must(Global.Register("meter", meter.Run))
+	must(Global.RegisterProbe("meter", meter.Probe))
+	must(Global.RegisterSelfTest("meter", meter.SelfTest))
+	must(Global.RegisterConfig("meter", meter.DefaultConfig()))
+	must(Global.RegisterInfo("meter", "Water and gas meter readings and pulses via MQTT", "water", "gas"))
+}
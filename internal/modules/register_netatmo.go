@@ -0,0 +1,13 @@
+//go:build netatmo || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/netatmo"
+
+func init() {
+	must(Global.Register("netatmo", netatmo.Run))
+	must(Global.RegisterProbe("netatmo", netatmo.Probe))
+	must(Global.RegisterSelfTest("netatmo", netatmo.SelfTest))
+	must(Global.RegisterConfig("netatmo", netatmo.DefaultConfig()))
+	must(Global.RegisterInfo("netatmo", "Netatmo weather stations, Healthy Home Coaches and thermostats", "climate", "heating"))
+}
@@ -0,0 +1,12 @@
+//go:build nut || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/nut"
+
+func init() {
+	must(Global.Register("nut", nut.Run))
+	must(Global.RegisterProbe("nut", nut.Probe))
+	must(Global.RegisterConfig("nut", nut.DefaultConfig()))
+	must(Global.RegisterInfo("nut", "UPS devices managed by Network UPS Tools (NUT)", "ups"))
+}
@@ -0,0 +1,13 @@
+//go:build opendtu || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/opendtu"
+
+func init() {
+	must(Global.Register("opendtu", opendtu.Run))
+	must(Global.RegisterProbe("opendtu", opendtu.Probe))
+	must(Global.RegisterSelfTest("opendtu", opendtu.SelfTest))
+	must(Global.RegisterConfig("opendtu", opendtu.DefaultConfig()))
+	must(Global.RegisterInfo("opendtu", "Solar inverters via the OpenDTU websocket API", "electricity"))
+}
@@ -0,0 +1,12 @@
+//go:build proxmox || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/proxmox"
+
+func init() {
+	must(Global.Register("proxmox", proxmox.Run))
+	must(Global.RegisterProbe("proxmox", proxmox.Probe))
+	must(Global.RegisterConfig("proxmox", proxmox.DefaultConfig()))
+	must(Global.RegisterInfo("proxmox", "Nodes, virtual machines and containers of Proxmox VE hosts", "hypervisor", "virtual_machine"))
+}
@@ -0,0 +1,12 @@
+//go:build roborock || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/roborock"
+
+func init() {
+	must(Global.Register("roborock", roborock.Run))
+	must(Global.RegisterProbe("roborock", roborock.Probe))
+	must(Global.RegisterConfig("roborock", roborock.DefaultConfig()))
+	must(Global.RegisterInfo("roborock", "Roborock robot vacuums via the local miIO protocol", "vacuum"))
+}
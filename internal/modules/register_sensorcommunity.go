@@ -0,0 +1,12 @@
+//go:build sensorcommunity || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/sensorcommunity"
+
+func init() {
+	must(Global.Register("sensorcommunity", sensorcommunity.Run))
+	must(Global.RegisterProbe("sensorcommunity", sensorcommunity.Probe))
+	must(Global.RegisterConfig("sensorcommunity", sensorcommunity.DefaultConfig()))
+	must(Global.RegisterInfo("sensorcommunity", "Particulate matter sensors running the sensor.community firmware", "air_quality"))
+}
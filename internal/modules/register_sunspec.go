@@ -0,0 +1,12 @@
+//go:build sunspec || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/sunspec"
+
+func init() {
+	must(Global.Register("sunspec", sunspec.Run))
+	must(Global.RegisterProbe("sunspec", sunspec.Probe))
+	must(Global.RegisterConfig("sunspec", sunspec.DefaultConfig()))
+	must(Global.RegisterInfo("sunspec", "Auto-discovered SunSpec inverters, meters and batteries via Modbus TCP", "electricity", "energy_storage"))
+}
@@ -0,0 +1,13 @@
+//go:build tasmota || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/tasmota"
+
+func init() {
+	must(Global.Register("tasmota", tasmota.Run))
+	must(Global.RegisterProbe("tasmota", tasmota.Probe))
+	must(Global.RegisterSelfTest("tasmota", tasmota.SelfTest))
+	must(Global.RegisterConfig("tasmota", tasmota.DefaultConfig()))
+	must(Global.RegisterInfo("tasmota", "Energy readings of Tasmota devices via MQTT", "electricity", "device_status"))
+}
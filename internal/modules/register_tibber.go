@@ -0,0 +1,13 @@
+//go:build tibber || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/tibber"
+
+func init() {
+	must(Global.Register("tibber", tibber.Run))
+	must(Global.RegisterProbe("tibber", tibber.Probe))
+	must(Global.RegisterSelfTest("tibber", tibber.SelfTest))
+	must(Global.RegisterConfig("tibber", tibber.DefaultConfig()))
+	must(Global.RegisterInfo("tibber", "Electricity prices of Tibber or aWATTar and Tibber Pulse live consumption", "electricity_price", "electricity"))
+}
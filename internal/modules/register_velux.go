@@ -0,0 +1,13 @@
+//go:build velux || !only_selected
+
+package modules
+
+import "github.com/janhuddel/metrics-agent/internal/modules/velux"
+
+func init() {
+	must(Global.Register("velux", velux.Run))
+	must(Global.RegisterProbe("velux", velux.Probe))
+	must(Global.RegisterSelfTest("velux", velux.SelfTest))
+	must(Global.RegisterConfig("velux", velux.DefaultConfig()))
+	must(Global.RegisterInfo("velux", "VELUX ACTIVE windows, shutters and blinds and their rain sensor", "window_covering", "rain"))
+}
@@ -7,14 +7,20 @@
 // - Unified module execution interface
 // - Panic recovery for module execution
 // - Configurable module support
+// - Module lifecycle hooks (see lifecycle.go)
 package modules
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
-	"github.com/janhuddel/metrics-agent/internal/metrics"
+	"github.com/janhuddel/metrics-agent/internal/config"
 	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
 )
 
 // ModuleFunc represents a function that runs a metric collection module.
@@ -22,6 +28,23 @@ import (
 // The function should run continuously until the context is cancelled.
 type ModuleFunc func(ctx context.Context, ch chan<- metrics.Metric) error
 
+// Run runs the module function, so a ModuleFunc is a Module without hooks.
+func (fn ModuleFunc) Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	return fn(ctx, ch)
+}
+
+// ProbeFunc represents a function that quickly validates a module's configuration
+// and checks that its upstream endpoints are reachable. It is called at startup,
+// before the module runs, and must return promptly when the context is cancelled.
+// Configuration problems should be returned as *config.ModuleError.
+type ProbeFunc func(ctx context.Context) error
+
+// SelfTestFunc represents a function that checks a module's upstream access in
+// more depth than its probe, e.g. that credentials are accepted. It is run by
+// "metrics-agent selftest" after the probe passed. It may send requests that
+// count against API quotas, but must not wait for user interaction.
+type SelfTestFunc func(ctx context.Context) []utils.Check
+
 // ConfigurableModule represents a module that can be configured.
 // Modules implementing this interface can receive configuration data
 // before being started.
@@ -35,45 +58,241 @@ type ConfigurableModule interface {
 	Run(ctx context.Context, ch chan<- metrics.Metric) error
 }
 
+// ErrDuplicate is returned when something is registered twice for a module.
+var ErrDuplicate = errors.New("already registered")
+
+// ModuleInfo describes a registered module for the "modules" command and the
+// HTTP status endpoint.
+type ModuleInfo struct {
+	// Name is the name the module is registered and configured under.
+	Name string `json:"name"`
+
+	// Description is a short description of what the module collects.
+	Description string `json:"description,omitempty"`
+
+	// Measurements are the names of the metrics the module sends by default.
+	Measurements []string `json:"measurements,omitempty"`
+
+	// Probe and SelfTest report whether the module has a startup probe and
+	// self-test checks.
+	Probe    bool `json:"probe"`
+	SelfTest bool `json:"self_test"`
+}
+
 // Registry holds all available metric collection modules.
 // It provides thread-safe access to registered modules and their execution.
 type Registry struct {
-	modules map[string]ModuleFunc
+	mu      sync.RWMutex
+	modules map[string]ModuleFactory
+	probes  map[string]ProbeFunc
+	tests   map[string]SelfTestFunc
+	configs map[string]interface{}
+	infos   map[string]ModuleInfo
+	running map[string]running
 }
 
 // NewRegistry creates a new module registry.
 func NewRegistry() *Registry {
 	return &Registry{
-		modules: make(map[string]ModuleFunc),
+		modules: make(map[string]ModuleFactory),
+		probes:  make(map[string]ProbeFunc),
+		tests:   make(map[string]SelfTestFunc),
+		configs: make(map[string]interface{}),
+		infos:   make(map[string]ModuleInfo),
+		running: make(map[string]running),
 	}
 }
 
 // Register adds a module to the registry.
-// If a module with the same name already exists, it will be overwritten.
-func (r *Registry) Register(name string, fn ModuleFunc) {
-	r.modules[name] = fn
+// Returns an error if the name is invalid or a module with the same name
+// already exists.
+func (r *Registry) Register(name string, fn ModuleFunc) error {
+	return r.RegisterModule(name, func() Module { return fn })
+}
+
+// RegisterProbe adds an optional startup probe for a module.
+// Returns an error if a probe for the module already exists.
+func (r *Registry) RegisterProbe(name string, fn ProbeFunc) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.probes[name]; exists {
+		return fmt.Errorf("probe of module %s: %w", name, ErrDuplicate)
+	}
+	r.probes[name] = fn
+	return nil
+}
+
+// RegisterSelfTest adds optional self-test checks for a module.
+// Returns an error if checks for the module already exist.
+func (r *Registry) RegisterSelfTest(name string, fn SelfTestFunc) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.tests[name]; exists {
+		return fmt.Errorf("self-test of module %s: %w", name, ErrDuplicate)
+	}
+	r.tests[name] = fn
+	return nil
+}
+
+// RegisterConfig records the default Config struct of a module, whose fields
+// are the module's custom settings. It is used to generate the configuration
+// schema and the example configuration.
+// Returns an error if a Config struct for the module already exists.
+func (r *Registry) RegisterConfig(name string, cfg interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.configs[name]; exists {
+		return fmt.Errorf("configuration of module %s: %w", name, ErrDuplicate)
+	}
+	r.configs[name] = cfg
+	return nil
+}
+
+// RegisterInfo records a short description of a module and the measurements
+// it sends by default, shown by Describe.
+// Returns an error if a description for the module already exists.
+func (r *Registry) RegisterInfo(name, description string, measurements ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.infos[name]; exists {
+		return fmt.Errorf("description of module %s: %w", name, ErrDuplicate)
+	}
+	r.infos[name] = ModuleInfo{Description: description, Measurements: measurements}
+	return nil
+}
+
+// validateName checks that a module name can be configured: it must not be
+// empty and must not contain ".", which separates instance names.
+func validateName(name string) error {
+	if name == "" {
+		return errors.New("module name is empty")
+	}
+	if strings.Contains(name, ".") {
+		return fmt.Errorf("module name %q must not contain '.'", name)
+	}
+	return nil
+}
+
+// Configs returns the registered Config structs by module name.
+func (r *Registry) Configs() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	configs := make(map[string]interface{}, len(r.configs))
+	for name, cfg := range r.configs {
+		configs[name] = cfg
+	}
+	return configs
+}
+
+// HasProbe reports whether a probe is registered for the module.
+func (r *Registry) HasProbe(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, exists := r.probes[name]
+	return exists
+}
+
+// Probe runs the probe of a module. Modules without a probe pass.
+// Panics are recovered and returned as errors.
+func (r *Registry) Probe(ctx context.Context, name string) error {
+	r.mu.RLock()
+	fn, exists := r.probes[name]
+	r.mu.RUnlock()
+	if !exists {
+		return nil
+	}
+
+	ctx = utils.WithModule(ctx, config.InstanceName(name, config.InstanceFromContext(ctx)))
+	return utils.WithPanicRecoveryAndReturnError("Module probe", name, func() error {
+		return fn(ctx)
+	})
 }
 
-// Get retrieves a module function by name.
+// SelfTest runs the self-test checks of a module. Modules without checks
+// return none. A panic is recovered and returned as failed check.
+func (r *Registry) SelfTest(ctx context.Context, name string) []utils.Check {
+	r.mu.RLock()
+	fn, exists := r.tests[name]
+	r.mu.RUnlock()
+	if !exists {
+		return nil
+	}
+
+	ctx = utils.WithModule(ctx, config.InstanceName(name, config.InstanceFromContext(ctx)))
+	var checks []utils.Check
+	err := utils.WithPanicRecoveryAndReturnError("Module self-test", name, func() error {
+		checks = fn(ctx)
+		return nil
+	})
+	if err != nil {
+		checks = append(checks, utils.Check{Name: "self-test", Err: err})
+	}
+	return checks
+}
+
+// Get retrieves a module function by name. The function runs a new instance
+// of the module between its lifecycle hooks (see RegisterModule).
 // Returns an error if the module is not found.
 func (r *Registry) Get(name string) (ModuleFunc, error) {
-	fn, exists := r.modules[name]
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	factory, exists := r.modules[name]
 	if !exists {
 		return nil, fmt.Errorf("unknown module: %s", name)
 	}
-	return fn, nil
+	return func(ctx context.Context, ch chan<- metrics.Metric) error {
+		return r.runLifecycle(ctx, factory(), ch)
+	}, nil
 }
 
-// List returns all registered module names.
-// The order of names is not guaranteed as it depends on map iteration.
+// List returns all registered module names in alphabetical order.
 func (r *Registry) List() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	names := make([]string, 0, len(r.modules))
 	for name := range r.modules {
 		names = append(names, name)
 	}
+	sort.Strings(names)
 	return names
 }
 
+// Describe returns the description of a registered module.
+// Returns an error if the module is not found.
+func (r *Registry) Describe(name string) (ModuleInfo, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, exists := r.modules[name]; !exists {
+		return ModuleInfo{}, fmt.Errorf("unknown module: %s", name)
+	}
+	return r.describe(name), nil
+}
+
+// DescribeAll returns the descriptions of all registered modules in
+// alphabetical order of their names.
+func (r *Registry) DescribeAll() []ModuleInfo {
+	names := r.List()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	infos := make([]ModuleInfo, 0, len(names))
+	for _, name := range names {
+		if _, exists := r.modules[name]; exists {
+			infos = append(infos, r.describe(name))
+		}
+	}
+	return infos
+}
+
+// describe builds the description of a module. The caller must hold r.mu.
+func (r *Registry) describe(name string) ModuleInfo {
+	info := r.infos[name]
+	info.Name = name
+	info.Measurements = append([]string(nil), info.Measurements...)
+	_, info.Probe = r.probes[name]
+	_, info.SelfTest = r.tests[name]
+	return info
+}
+
 // Run executes a module by name.
 // It retrieves the module function and runs it with the provided context and channel.
 // Panics are recovered and returned as errors to ensure the application remains stable.
@@ -85,6 +304,6 @@ func (r *Registry) Run(ctx context.Context, name string, ch chan<- metrics.Metri
 
 	// Execute module with panic recovery
 	return utils.WithPanicRecoveryAndReturnError("Module execution", name, func() error {
-		return fn(ctx, ch)
+		return fn(utils.WithModule(ctx, name), ch)
 	})
 }
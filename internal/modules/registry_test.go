@@ -0,0 +1,168 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestRegistryProbe(t *testing.T) {
+	registry := NewRegistry()
+
+	// Modules without a probe pass
+	if registry.HasProbe("plain") {
+		t.Error("Expected no probe for unregistered module")
+	}
+	if err := registry.Probe(context.Background(), "plain"); err != nil {
+		t.Errorf("Expected nil error without probe, got %v", err)
+	}
+
+	var gotInstance, gotModule string
+	registry.RegisterProbe("failing", func(ctx context.Context) error {
+		gotInstance = config.InstanceFromContext(ctx)
+		gotModule = utils.ModuleFromContext(ctx)
+		return errors.New("unreachable")
+	})
+	if !registry.HasProbe("failing") {
+		t.Error("Expected probe to be registered")
+	}
+	err := registry.Probe(config.WithInstance(context.Background(), "haus1"), "failing")
+	if err == nil || err.Error() != "unreachable" {
+		t.Errorf("Expected probe error, got %v", err)
+	}
+	if gotInstance != "haus1" || gotModule != "failing.haus1" {
+		t.Errorf("Expected instance haus1 of module failing in probe context, got %q, %q", gotInstance, gotModule)
+	}
+
+	// A panicking probe is reported as an error
+	registry.RegisterProbe("panicking", func(ctx context.Context) error {
+		panic("boom")
+	})
+	if err := registry.Probe(context.Background(), "panicking"); err == nil {
+		t.Error("Expected error from panicking probe")
+	}
+}
+
+func TestRegistrySelfTest(t *testing.T) {
+	registry := NewRegistry()
+	if checks := registry.SelfTest(context.Background(), "plain"); len(checks) != 0 {
+		t.Errorf("Expected no checks for unregistered module, got %v", checks)
+	}
+
+	var gotModule string
+	registry.RegisterSelfTest("api", func(ctx context.Context) []utils.Check {
+		gotModule = utils.ModuleFromContext(ctx)
+		return []utils.Check{{Name: "token"}, {Name: "quota", Err: errors.New("exhausted")}}
+	})
+	checks := registry.SelfTest(config.WithInstance(context.Background(), "haus1"), "api")
+	if len(checks) != 2 || !checks[0].Passed() || checks[1].Passed() {
+		t.Errorf("Expected one passed and one failed check, got %v", checks)
+	}
+	if gotModule != "api.haus1" {
+		t.Errorf("Expected module api.haus1 in self-test context, got %q", gotModule)
+	}
+
+	// A panicking self-test is reported as failed check
+	registry.RegisterSelfTest("panicking", func(ctx context.Context) []utils.Check {
+		panic("boom")
+	})
+	if checks := registry.SelfTest(context.Background(), "panicking"); len(checks) != 1 || checks[0].Passed() {
+		t.Errorf("Expected failed check from panicking self-test, got %v", checks)
+	}
+}
+
+func TestRegistryConfigs(t *testing.T) {
+	type testConfig struct {
+		Broker string `json:"broker"`
+	}
+
+	registry := NewRegistry()
+	registry.RegisterConfig("test", testConfig{})
+
+	configs := registry.Configs()
+	if _, ok := configs["test"].(testConfig); !ok || len(configs) != 1 {
+		t.Errorf("Expected the registered config, got %v", configs)
+	}
+
+	// The returned map is a copy
+	delete(configs, "test")
+	if len(registry.Configs()) != 1 {
+		t.Error("Expected registry configs to be unaffected by changes to the returned map")
+	}
+}
+
+func TestRegistryRunModuleContext(t *testing.T) {
+	registry := NewRegistry()
+	modules := make(chan string, 2)
+	registry.Register("test", func(ctx context.Context, ch chan<- metrics.Metric) error {
+		modules <- utils.ModuleFromContext(ctx)
+		return nil
+	})
+
+	ch := make(chan metrics.Metric, 1)
+	if err := registry.Run(context.Background(), "test", ch); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if err := registry.RunInstance(context.Background(), "test", "haus1", nil, ch); err != nil {
+		t.Fatalf("RunInstance failed: %v", err)
+	}
+	for _, want := range []string{"test", "test.haus1"} {
+		if got := <-modules; got != want {
+			t.Errorf("Expected module %q in context, got %q", want, got)
+		}
+	}
+}
+
+func TestRegistryRejectsDuplicates(t *testing.T) {
+	registry := NewRegistry()
+	run := func(ctx context.Context, ch chan<- metrics.Metric) error { return nil }
+	probe := func(ctx context.Context) error { return nil }
+
+	if err := registry.Register("test", run); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := registry.Register("test", run); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Expected ErrDuplicate for a second module, got %v", err)
+	}
+	if err := registry.RegisterProbe("test", probe); err != nil {
+		t.Fatalf("RegisterProbe failed: %v", err)
+	}
+	if err := registry.RegisterProbe("test", probe); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Expected ErrDuplicate for a second probe, got %v", err)
+	}
+	for _, name := range []string{"", "test.haus1"} {
+		if err := registry.Register(name, run); err == nil {
+			t.Errorf("Expected error for module name %q", name)
+		}
+	}
+}
+
+func TestRegistryDescribe(t *testing.T) {
+	registry := NewRegistry()
+	run := func(ctx context.Context, ch chan<- metrics.Metric) error { return nil }
+	registry.Register("weather", run)
+	registry.Register("air", run)
+	registry.RegisterProbe("weather", func(ctx context.Context) error { return nil })
+	registry.RegisterInfo("weather", "Weather warnings", "weather_warning")
+
+	if _, err := registry.Describe("unknown"); err == nil {
+		t.Error("Expected error for an unknown module")
+	}
+	info, err := registry.Describe("weather")
+	if err != nil {
+		t.Fatalf("Describe failed: %v", err)
+	}
+	if info.Name != "weather" || info.Description != "Weather warnings" || !info.Probe || info.SelfTest ||
+		len(info.Measurements) != 1 || info.Measurements[0] != "weather_warning" {
+		t.Errorf("Unexpected description: %+v", info)
+	}
+
+	infos := registry.DescribeAll()
+	if len(infos) != 2 || infos[0].Name != "air" || infos[1].Name != "weather" {
+		t.Errorf("Expected descriptions in alphabetical order, got %+v", infos)
+	}
+}
@@ -0,0 +1,262 @@
+package roborock
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+const (
+	// miioPort is the UDP port of the local miIO protocol
+	miioPort = "54321"
+
+	// miioHeaderSize is the size of the packet header
+	miioHeaderSize = 32
+
+	// miioMagic starts every packet
+	miioMagic = 0x2131
+)
+
+// miioError is an error returned by the device
+type miioError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *miioError) Error() string {
+	return fmt.Sprintf("device error %d: %s", e.Code, e.Message)
+}
+
+// miioClient talks to a device using the local miIO protocol: JSON-RPC over UDP,
+// encrypted with AES-128-CBC using a key derived from the device token.
+type miioClient struct {
+	conn     net.Conn
+	token    []byte
+	timeout  time.Duration
+	deviceID uint32
+	stamp    uint32
+	nextID   int
+}
+
+// dialMiio opens a UDP socket to a device and performs the handshake.
+// The port defaults to 54321.
+func dialMiio(ctx context.Context, address, token string, timeout time.Duration) (*miioClient, error) {
+	tokenBytes, err := hex.DecodeString(strings.TrimSpace(token))
+	if err != nil || len(tokenBytes) != 16 {
+		return nil, fmt.Errorf("invalid token: expected 32 hex characters")
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, miioPort)
+	}
+	if err := utils.CheckDestination(ctx, address); err != nil {
+		return nil, err
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+
+	client := &miioClient{conn: conn, token: tokenBytes, timeout: timeout, nextID: 1}
+	if err := client.handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Close closes the socket
+func (c *miioClient) Close() error {
+	return c.conn.Close()
+}
+
+// handshake sends the hello packet, which returns the device ID and the current stamp of the device
+func (c *miioClient) handshake() error {
+	hello := make([]byte, miioHeaderSize)
+	for i := range hello {
+		hello[i] = 0xff
+	}
+	binary.BigEndian.PutUint16(hello[0:], miioMagic)
+	binary.BigEndian.PutUint16(hello[2:], miioHeaderSize)
+
+	response, err := c.roundTrip(hello)
+	if err != nil {
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+	c.deviceID = binary.BigEndian.Uint32(response[8:])
+	c.stamp = binary.BigEndian.Uint32(response[12:])
+	return nil
+}
+
+// call invokes a method on the device and decodes its result into result
+func (c *miioClient) call(method string, params interface{}, result interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	id := c.nextID
+	c.nextID++
+
+	request, err := json.Marshal(map[string]interface{}{"id": id, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	c.stamp++
+	packet, err := encodeMiioPacket(c.token, c.deviceID, c.stamp, request)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.roundTrip(packet)
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", method, err)
+	}
+	payload, err := decodeMiioPacket(c.token, response)
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", method, err)
+	}
+
+	var reply struct {
+		ID     int             `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *miioError      `json:"error"`
+	}
+	if err := json.Unmarshal(payload, &reply); err != nil {
+		return fmt.Errorf("%s failed: invalid response: %w", method, err)
+	}
+	if reply.Error != nil {
+		return fmt.Errorf("%s failed: %w", method, reply.Error)
+	}
+	if reply.ID != id {
+		return fmt.Errorf("%s failed: response for request %d, expected %d", method, reply.ID, id)
+	}
+	if err := json.Unmarshal(reply.Result, result); err != nil {
+		return fmt.Errorf("%s failed: unexpected result: %w", method, err)
+	}
+	return nil
+}
+
+// roundTrip sends a packet and waits for the response packet
+func (c *miioClient) roundTrip(packet []byte) ([]byte, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.Write(packet); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 4096)
+	n, err := c.conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	if n < miioHeaderSize || binary.BigEndian.Uint16(buf) != miioMagic {
+		return nil, fmt.Errorf("invalid response packet")
+	}
+	return buf[:n], nil
+}
+
+// encodeMiioPacket encrypts the payload and builds a packet with header and checksum
+func encodeMiioPacket(token []byte, deviceID, stamp uint32, payload []byte) ([]byte, error) {
+	encrypted, err := miioEncrypt(token, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	packet := make([]byte, miioHeaderSize+len(encrypted))
+	binary.BigEndian.PutUint16(packet[0:], miioMagic)
+	binary.BigEndian.PutUint16(packet[2:], uint16(len(packet)))
+	binary.BigEndian.PutUint32(packet[8:], deviceID)
+	binary.BigEndian.PutUint32(packet[12:], stamp)
+	copy(packet[miioHeaderSize:], encrypted)
+
+	// The checksum is calculated with the token in place of the checksum
+	copy(packet[16:], token)
+	checksum := md5.Sum(packet)
+	copy(packet[16:], checksum[:])
+	return packet, nil
+}
+
+// decodeMiioPacket verifies the checksum of a packet and returns its decrypted payload
+func decodeMiioPacket(token []byte, packet []byte) ([]byte, error) {
+	if len(packet) < miioHeaderSize {
+		return nil, fmt.Errorf("packet too short")
+	}
+	length := int(binary.BigEndian.Uint16(packet[2:]))
+	if length < miioHeaderSize || length > len(packet) {
+		return nil, fmt.Errorf("invalid packet length %d", length)
+	}
+	packet = packet[:length]
+
+	verify := make([]byte, len(packet))
+	copy(verify, packet)
+	copy(verify[16:], token)
+	checksum := md5.Sum(verify)
+	if !bytes.Equal(checksum[:], packet[16:32]) {
+		return nil, fmt.Errorf("checksum mismatch, check the token")
+	}
+
+	payload, err := miioDecrypt(token, packet[miioHeaderSize:])
+	if err != nil {
+		return nil, err
+	}
+	// Devices terminate the JSON payload with a null byte
+	return bytes.TrimRight(payload, "\x00"), nil
+}
+
+// miioCipher returns the cipher and IV derived from the token:
+// key = md5(token), iv = md5(key + token)
+func miioCipher(token []byte) (cipher.Block, []byte, error) {
+	key := md5.Sum(token)
+	iv := md5.Sum(append(key[:], token...))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, iv[:], nil
+}
+
+// miioEncrypt encrypts a payload with PKCS#7 padding
+func miioEncrypt(token []byte, payload []byte) ([]byte, error) {
+	block, iv, err := miioCipher(token)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(payload)%aes.BlockSize
+	padded := append(append([]byte{}, payload...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+	return encrypted, nil
+}
+
+// miioDecrypt decrypts a payload and removes its PKCS#7 padding
+func miioDecrypt(token []byte, encrypted []byte) ([]byte, error) {
+	if len(encrypted) == 0 || len(encrypted)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid encrypted payload length %d", len(encrypted))
+	}
+	block, iv, err := miioCipher(token)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, encrypted)
+
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, fmt.Errorf("invalid padding")
+	}
+	return decrypted[:len(decrypted)-padding], nil
+}
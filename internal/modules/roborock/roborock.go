@@ -0,0 +1,341 @@
+// Package roborock provides a metric collection module for Roborock (and other
+// Xiaomi compatible) robot vacuums.
+// It polls the devices via the local miIO protocol and reports battery level,
+// cleaning state and the area cleaned.
+package roborock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/connection"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// Metric names
+	metricNameVacuum = "vacuum"
+
+	// mm2PerM2 converts the areas reported by the device (mm²) to m²
+	mm2PerM2 = 1000000
+)
+
+// stateNames maps the state codes of the vacuum to readable names
+var stateNames = map[int]string{
+	1:   "starting",
+	2:   "charger_disconnected",
+	3:   "idle",
+	4:   "remote_control",
+	5:   "cleaning",
+	6:   "returning_home",
+	7:   "manual_mode",
+	8:   "charging",
+	9:   "charging_problem",
+	10:  "paused",
+	11:  "spot_cleaning",
+	12:  "error",
+	13:  "shutting_down",
+	14:  "updating",
+	15:  "docking",
+	16:  "going_to_target",
+	17:  "zoned_cleaning",
+	18:  "segment_cleaning",
+	100: "charging_complete",
+	101: "offline",
+}
+
+// Config represents the configuration for the Roborock module
+type Config struct {
+	config.BaseConfig
+	Devices  []DeviceConfig  `json:"devices"`            // Vacuums to monitor
+	Interval config.Duration `json:"interval,omitempty"` // Polling interval (defaults to 60s)
+	Timeout  config.Duration `json:"timeout,omitempty"`  // Request timeout (defaults to 5s)
+}
+
+// DeviceConfig represents the connection settings of a vacuum
+type DeviceConfig struct {
+	Address string `json:"address"` // IP address or hostname, optionally with port (defaults to 54321)
+	Token   string `json:"token"`   // Device token (32 hex characters)
+}
+
+// Status represents the result of the get_status command
+type Status struct {
+	State      int   `json:"state"`
+	Battery    int   `json:"battery"`
+	CleanTime  int64 `json:"clean_time"`
+	CleanArea  int64 `json:"clean_area"`
+	ErrorCode  int   `json:"error_code"`
+	InCleaning int   `json:"in_cleaning"`
+	FanPower   int   `json:"fan_power"`
+}
+
+// CleanSummary represents the lifetime totals of a vacuum
+type CleanSummary struct {
+	CleanTime  int64 `json:"clean_time"`
+	CleanArea  int64 `json:"clean_area"`
+	CleanCount int64 `json:"clean_count"`
+}
+
+// UnmarshalJSON accepts the object returned by current firmware as well as the
+// array [time, area, count, records] returned by older firmware
+func (s *CleanSummary) UnmarshalJSON(data []byte) error {
+	var values []json.RawMessage
+	if err := json.Unmarshal(data, &values); err == nil {
+		if len(values) < 3 {
+			return fmt.Errorf("clean summary has %d values, expected at least 3", len(values))
+		}
+		for i, target := range []*int64{&s.CleanTime, &s.CleanArea, &s.CleanCount} {
+			if err := json.Unmarshal(values[i], target); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	type summary CleanSummary
+	return json.Unmarshal(data, (*summary)(s))
+}
+
+// RoborockModule handles polling of robot vacuums
+type RoborockModule struct {
+	config      Config
+	metricsCh   chan<- metrics.Metric
+	clock       utils.Clock
+	collections *utils.CollectionLog // last successful collection, nil if not remembered
+	trackers    map[string]*connection.Tracker
+}
+
+// Run starts the Roborock module and begins collecting metrics
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewRoborockModule(config)
+	if err != nil {
+		return fmt.Errorf("failed to create Roborock module: %w", err)
+	}
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+	module.collections = utils.OpenCollectionLog(config.InstanceName("roborock"), module.clock)
+
+	return module.run(ctx)
+}
+
+// Probe validates the Roborock configuration and performs the handshake with each vacuum
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewRoborockModule(cfg)
+	if err != nil {
+		return &config.ModuleError{Module: "roborock", Err: err}
+	}
+	for _, device := range module.config.Devices {
+		client, err := dialMiio(ctx, device.Address, device.Token, module.config.Timeout.Duration())
+		if err != nil {
+			return fmt.Errorf("%s not reachable: %w", device.Address, err)
+		}
+		client.Close()
+	}
+	return nil
+}
+
+// NewRoborockModule creates a new Roborock module instance
+func NewRoborockModule(cfg Config) (*RoborockModule, error) {
+	utils.Debugf("Creating new Roborock module instance")
+
+	if len(cfg.Devices) == 0 {
+		return nil, fmt.Errorf("devices is required but not configured")
+	}
+	for _, device := range cfg.Devices {
+		if device.Address == "" || device.Token == "" {
+			return nil, fmt.Errorf("address and token are required for each device")
+		}
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(60 * time.Second)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = config.Duration(5 * time.Second)
+	}
+
+	utils.Debugf("Roborock module created successfully")
+	return &RoborockModule{
+		clock:    utils.SystemClock,
+		config:   cfg,
+		trackers: make(map[string]*connection.Tracker),
+	}, nil
+}
+
+// DefaultConfig returns the default configuration of the Roborock module.
+func DefaultConfig() Config {
+	return Config{
+		Interval: config.Duration(60 * time.Second),
+		Timeout:  config.Duration(5 * time.Second),
+	}
+}
+
+// LoadConfig loads the Roborock module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("roborock")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
+
+// run executes the main module loop
+func (rm *RoborockModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Roborock module", "main", func() error {
+		ticker := utils.NewScheduledTicker(ctx, rm.config.Interval.Duration())
+		defer ticker.Stop()
+
+		// Collect initial data unless outside the collection schedule or skipped,
+		// but not before an interval has passed since the last collection
+		initial := rm.collections.Initial(ctx, rm.config.Interval.Duration())
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-initial:
+				if err := rm.collectData(ctx); err != nil {
+					utils.Warnf("Failed to collect initial vacuum status: %v", err)
+				} else {
+					rm.collections.Record()
+				}
+			case <-ticker.C:
+				if err := rm.collectData(ctx); err != nil {
+					utils.Warnf("Failed to collect vacuum status: %v", err)
+				} else {
+					rm.collections.Record()
+				}
+			}
+		}
+	})
+}
+
+// collectData polls all configured vacuums.
+// A failing vacuum does not prevent the others from being collected; the last error is returned.
+func (rm *RoborockModule) collectData(ctx context.Context) error {
+	var lastErr error
+	for _, device := range rm.config.Devices {
+		if err := rm.collectDevice(ctx, device); err != nil {
+			utils.Warnf("Failed to collect vacuum %s: %v", device.Address, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// collectDevice queries the status and cleaning totals of a vacuum and sends its metric
+func (rm *RoborockModule) collectDevice(ctx context.Context, device DeviceConfig) error {
+	return utils.WithPanicRecoveryAndReturnError("Roborock data collection", device.Address, func() error {
+		client, err := dialMiio(ctx, device.Address, device.Token, rm.config.Timeout.Duration())
+		rm.connection(device.Address).SetConnected(err == nil)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		var statuses []Status
+		if err := client.call("get_status", nil, &statuses); err != nil {
+			return err
+		}
+		if len(statuses) == 0 {
+			return fmt.Errorf("get_status returned no status")
+		}
+
+		// Older firmware doesn't support the summary, so the status is sent without totals
+		var summary *CleanSummary
+		if err := client.call("get_clean_summary", nil, &summary); err != nil {
+			utils.Debugf("Failed to get clean summary of vacuum %s: %v", device.Address, err)
+			summary = nil
+		}
+
+		rm.sendVacuumMetric(strconv.FormatUint(uint64(client.deviceID), 10), device.Address, statuses[0], summary, rm.clock.Now())
+		return nil
+	})
+}
+
+// connection returns the tracker for a vacuum, creating it on first use
+func (rm *RoborockModule) connection(address string) *connection.Tracker {
+	tracker, ok := rm.trackers[address]
+	if !ok {
+		tracker = connection.NewTracker(rm.config.InstanceName("roborock"), address, rm.metricsCh)
+		rm.trackers[address] = tracker
+	}
+	return tracker
+}
+
+// sendVacuumMetric sends the status of a vacuum
+func (rm *RoborockModule) sendVacuumMetric(deviceID, address string, status Status, summary *CleanSummary, timestamp time.Time) {
+	stateName, ok := stateNames[status.State]
+	if !ok {
+		stateName = "unknown"
+	}
+
+	fields := map[string]interface{}{
+		"battery":    status.Battery,
+		"state":      status.State,
+		"state_name": stateName,
+		"cleaning":   status.InCleaning != 0,
+		"clean_area": float64(status.CleanArea) / mm2PerM2,
+		"clean_time": status.CleanTime,
+		"error_code": status.ErrorCode,
+		"fan_power":  status.FanPower,
+	}
+	if summary != nil {
+		fields["total_clean_area"] = float64(summary.CleanArea) / mm2PerM2
+		fields["total_clean_time"] = summary.CleanTime
+		fields["clean_count"] = summary.CleanCount
+	}
+
+	rm.sendMetric(metricNameVacuum, rm.createBaseTags(deviceID, address), fields, timestamp)
+}
+
+// createBaseTags creates base tags for a vacuum
+func (rm *RoborockModule) createBaseTags(deviceID, address string) map[string]string {
+	return map[string]string{
+		"vendor":   "roborock",
+		"device":   deviceID,
+		"friendly": rm.config.GetFriendlyName(deviceID, "", address),
+	}
+}
+
+// sendMetric validates and sends a metric to the metrics channel
+func (rm *RoborockModule) sendMetric(name string, tags map[string]string, fields map[string]interface{}, timestamp time.Time) {
+	metric := metrics.Metric{
+		Name:      name,
+		Tags:      tags,
+		Fields:    fields,
+		Timestamp: timestamp,
+	}
+
+	if err := metric.Validate(); err != nil {
+		utils.Warnf("Invalid %s metric for vacuum %s: %v", name, tags["device"], err)
+		return
+	}
+
+	select {
+	case rm.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping %s metric for vacuum %s", name, tags["device"])
+	}
+}
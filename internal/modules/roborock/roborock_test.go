@@ -0,0 +1,202 @@
+package roborock
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const testToken = "00112233445566778899aabbccddeeff"
+
+// fakeVacuum is a miIO device answering get_status and get_clean_summary
+type fakeVacuum struct {
+	conn    net.PacketConn
+	token   []byte
+	summary string // raw result of get_clean_summary, an error if empty
+}
+
+func newFakeVacuum(t *testing.T, summary string) *fakeVacuum {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	token, _ := hex.DecodeString(testToken)
+	vacuum := &fakeVacuum{conn: conn, token: token, summary: summary}
+	go vacuum.serve()
+	t.Cleanup(func() { conn.Close() })
+	return vacuum
+}
+
+func (v *fakeVacuum) serve() {
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := v.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		packet := buf[:n]
+
+		if binary.BigEndian.Uint32(packet[4:]) == 0xffffffff {
+			hello := make([]byte, miioHeaderSize)
+			copy(hello, packet)
+			binary.BigEndian.PutUint32(hello[4:], 0)
+			binary.BigEndian.PutUint32(hello[8:], 123456789)
+			binary.BigEndian.PutUint32(hello[12:], 1000)
+			v.conn.WriteTo(hello, addr)
+			continue
+		}
+
+		payload, err := decodeMiioPacket(v.token, packet)
+		if err != nil {
+			continue
+		}
+		var request struct {
+			ID     int    `json:"id"`
+			Method string `json:"method"`
+		}
+		json.Unmarshal(payload, &request)
+
+		var reply string
+		switch {
+		case request.Method == "get_status":
+			reply = `{"result":[{"msg_ver":2,"state":5,"battery":87,"clean_time":1076,"clean_area":17490000,"error_code":0,"in_cleaning":1,"fan_power":102}],"id":` + itoa(request.ID) + `}`
+		case request.Method == "get_clean_summary" && v.summary != "":
+			reply = `{"result":` + v.summary + `,"id":` + itoa(request.ID) + `}`
+		default:
+			reply = `{"error":{"code":-32601,"message":"Method not found."},"id":` + itoa(request.ID) + `}`
+		}
+		response, _ := encodeMiioPacket(v.token, 123456789, 1001, append([]byte(reply), 0))
+		v.conn.WriteTo(response, addr)
+	}
+}
+
+func itoa(i int) string {
+	data, _ := json.Marshal(i)
+	return string(data)
+}
+
+func TestNewRoborockModule(t *testing.T) {
+	tah := utils.NewTestAssertionHelper()
+
+	_, err := NewRoborockModule(Config{})
+	tah.AssertError(t, err, "Expected error for missing devices")
+
+	_, err = NewRoborockModule(Config{Devices: []DeviceConfig{{Address: "192.168.1.70"}}})
+	tah.AssertError(t, err, "Expected error for missing token")
+
+	module, err := NewRoborockModule(Config{Devices: []DeviceConfig{{Address: "192.168.1.70", Token: testToken}}})
+	tah.AssertNoError(t, err, "Failed to create Roborock module")
+	if module.config.Interval.Duration() != 60*time.Second {
+		t.Errorf("Expected default interval 60s, got %v", module.config.Interval)
+	}
+}
+
+func TestMiioPacket(t *testing.T) {
+	token, _ := hex.DecodeString(testToken)
+	payload := []byte(`{"id":1,"method":"get_status","params":[]}`)
+
+	packet, err := encodeMiioPacket(token, 42, 7, payload)
+	if err != nil {
+		t.Fatalf("Failed to encode packet: %v", err)
+	}
+	if int(binary.BigEndian.Uint16(packet[2:])) != len(packet) || binary.BigEndian.Uint32(packet[8:]) != 42 {
+		t.Errorf("Unexpected packet header %x", packet[:miioHeaderSize])
+	}
+
+	decoded, err := decodeMiioPacket(token, packet)
+	if err != nil {
+		t.Fatalf("Failed to decode packet: %v", err)
+	}
+	if string(decoded) != string(payload) {
+		t.Errorf("Expected %s, got %s", payload, decoded)
+	}
+
+	otherToken, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100")
+	if _, err := decodeMiioPacket(otherToken, packet); err == nil {
+		t.Error("Expected checksum error for wrong token")
+	}
+}
+
+func TestCleanSummary(t *testing.T) {
+	for _, data := range []string{
+		`[3600, 50000000, 12, [1, 2, 3]]`,
+		`{"clean_time":3600,"clean_area":50000000,"clean_count":12,"dust_collection_count":3,"records":[1,2,3]}`,
+	} {
+		var summary CleanSummary
+		if err := json.Unmarshal([]byte(data), &summary); err != nil {
+			t.Fatalf("Failed to parse %s: %v", data, err)
+		}
+		if summary != (CleanSummary{CleanTime: 3600, CleanArea: 50000000, CleanCount: 12}) {
+			t.Errorf("Unexpected summary %+v for %s", summary, data)
+		}
+	}
+}
+
+func TestCollectData(t *testing.T) {
+	for _, summary := range []string{`[3600, 50000000, 12, []]`, ""} {
+		vacuum := newFakeVacuum(t, summary)
+
+		module, err := NewRoborockModule(Config{Devices: []DeviceConfig{{Address: vacuum.conn.LocalAddr().String(), Token: testToken}}})
+		if err != nil {
+			t.Fatalf("Failed to create module: %v", err)
+		}
+		ch := make(chan metrics.Metric, 10)
+		module.metricsCh = ch
+
+		if err := module.collectData(context.Background()); err != nil {
+			t.Fatalf("collectData failed: %v", err)
+		}
+
+		// Expect: connection status, vacuum metric
+		collected := drain(ch)
+		if len(collected) != 2 {
+			t.Fatalf("Expected 2 metrics, got %d", len(collected))
+		}
+		if collected[0].Name != "connection_status" || collected[0].Fields["status"] != 1 {
+			t.Errorf("Expected connection status metric first, got %+v", collected[0])
+		}
+
+		metric := collected[1]
+		if metric.Name != "vacuum" || metric.Tags["device"] != "123456789" || metric.Tags["vendor"] != "roborock" {
+			t.Errorf("Unexpected metric %+v", metric)
+		}
+		if metric.Fields["battery"] != 87 || metric.Fields["state_name"] != "cleaning" || metric.Fields["cleaning"] != true ||
+			metric.Fields["clean_area"] != 17.49 || metric.Fields["clean_time"] != int64(1076) {
+			t.Errorf("Unexpected fields %v", metric.Fields)
+		}
+		if _, ok := metric.Fields["total_clean_area"]; ok != (summary != "") {
+			t.Errorf("Expected totals only with clean summary, got %v", metric.Fields)
+		}
+	}
+}
+
+func TestCollectDataInvalidToken(t *testing.T) {
+	module, err := NewRoborockModule(Config{Devices: []DeviceConfig{{Address: "127.0.0.1", Token: "invalid"}}})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	module.metricsCh = make(chan metrics.Metric, 10)
+
+	if err := module.collectData(context.Background()); err == nil {
+		t.Error("Expected error for invalid token")
+	}
+}
+
+func drain(ch chan metrics.Metric) []metrics.Metric {
+	var result []metrics.Metric
+	for {
+		select {
+		case metric := <-ch:
+			result = append(result, metric)
+		default:
+			return result
+		}
+	}
+}
@@ -0,0 +1,450 @@
+// Package sensorcommunity provides a metric collection module for particulate
+// matter sensors running the sensor.community (formerly Luftdaten) firmware.
+// It polls the /data.json endpoint of local sensors or the sensor.community API
+// and emits an air quality metric with PM10, PM2.5, temperature and humidity.
+package sensorcommunity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/connection"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// Metric names
+	metricNameAirQuality = "air_quality"
+
+	// apiTimeLayout is the timestamp format of the sensor.community API (UTC)
+	apiTimeLayout = "2006-01-02 15:04:05"
+)
+
+// fieldNames maps the value types reported by the firmware and the API to field names.
+// Sensor specific prefixes (e.g. "SDS_P1", "BME280_temperature") are stripped before the lookup.
+var fieldNames = map[string]string{
+	"P0":          "pm1",
+	"P1":          "pm10",
+	"P2":          "pm2_5",
+	"P4":          "pm4",
+	"temperature": "temperature",
+	"humidity":    "humidity",
+	"pressure":    "pressure",
+	"signal":      "signal",
+}
+
+// Config represents the configuration for the sensor.community module
+type Config struct {
+	config.BaseConfig
+	Sensors   []string            `json:"sensors,omitempty"`    // Addresses of local sensors whose /data.json is polled
+	SensorIDs []int               `json:"sensor_ids,omitempty"` // Sensor IDs fetched from the sensor.community API
+	APIURL    string              `json:"api_url,omitempty"`    // sensor.community API URL
+	Locations map[string]Location `json:"locations,omitempty"`  // Geolocation per sensor address or ID
+	GeoTags   bool                `json:"geo_tags,omitempty"`   // Tag API sensors with the location reported by the API
+	Interval  config.Duration     `json:"interval,omitempty"`   // Polling interval (defaults to 2m30s)
+	Timeout   config.Duration     `json:"timeout,omitempty"`    // HTTP request timeout (defaults to 10s)
+}
+
+// Location represents the geolocation of a sensor
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// SensorData represents the data.json document of the sensor firmware
+type SensorData struct {
+	SoftwareVersion  string      `json:"software_version"`
+	Age              string      `json:"age"`
+	SensorDataValues []DataValue `json:"sensordatavalues"`
+}
+
+// DataValue represents a single reading of a sensor
+type DataValue struct {
+	ValueType string `json:"value_type"`
+	Value     string `json:"value"`
+}
+
+// APIMeasurement represents a measurement returned by the sensor.community API
+type APIMeasurement struct {
+	Timestamp        string      `json:"timestamp"`
+	Location         APILocation `json:"location"`
+	SensorDataValues []DataValue `json:"sensordatavalues"`
+}
+
+// APILocation represents the location of a sensor as reported by the API
+type APILocation struct {
+	Latitude  string `json:"latitude"`
+	Longitude string `json:"longitude"`
+}
+
+// SensorCommunityModule handles polling of sensor.community sensors
+type SensorCommunityModule struct {
+	config      Config
+	httpClient  *http.Client // local sensors, rate limited per device
+	apiClient   *http.Client // sensor.community API
+	metricsCh   chan<- metrics.Metric
+	clock       utils.Clock
+	collections *utils.CollectionLog // last successful collection, nil if not remembered
+	trackers    map[string]*connection.Tracker
+}
+
+// Run starts the sensor.community module and begins collecting metrics
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewSensorCommunityModule(config)
+	if err != nil {
+		return fmt.Errorf("failed to create sensor.community module: %w", err)
+	}
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+	module.collections = utils.OpenCollectionLog(config.InstanceName("sensorcommunity"), module.clock)
+
+	return module.run(ctx)
+}
+
+// Probe validates the sensor.community configuration and checks that the sensors are reachable
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewSensorCommunityModule(cfg)
+	if err != nil {
+		return &config.ModuleError{Module: "sensorcommunity", Err: err}
+	}
+	for _, sensor := range module.config.Sensors {
+		if err := utils.ProbeURL(ctx, dataURL(sensor), module.config.Timeout.Duration()); err != nil {
+			return err
+		}
+	}
+	if len(module.config.SensorIDs) > 0 {
+		return utils.ProbeURL(ctx, module.config.APIURL, module.config.Timeout.Duration())
+	}
+	return nil
+}
+
+// NewSensorCommunityModule creates a new sensor.community module instance
+func NewSensorCommunityModule(cfg Config) (*SensorCommunityModule, error) {
+	utils.Debugf("Creating new sensor.community module instance")
+
+	if len(cfg.Sensors) == 0 && len(cfg.SensorIDs) == 0 {
+		return nil, fmt.Errorf("sensors or sensor_ids is required but not configured")
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = DefaultConfig().APIURL
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(150 * time.Second)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = config.Duration(10 * time.Second)
+	}
+
+	utils.Debugf("sensor.community module created successfully")
+	return &SensorCommunityModule{
+		clock:  utils.SystemClock,
+		config: cfg,
+		httpClient: &http.Client{
+			Timeout:   cfg.Timeout.Duration(),
+			Transport: utils.DeviceTransport(cfg.InstanceName("sensorcommunity"), nil),
+		},
+		apiClient: &http.Client{
+			Timeout:   cfg.Timeout.Duration(),
+			Transport: utils.OutboundTransport(cfg.InstanceName("sensorcommunity"), nil),
+		},
+		trackers: make(map[string]*connection.Tracker),
+	}, nil
+}
+
+// DefaultConfig returns the default configuration of the sensor.community module.
+func DefaultConfig() Config {
+	return Config{
+		APIURL:   "https://data.sensor.community/airrohr/v1/sensor/",
+		Interval: config.Duration(150 * time.Second),
+		Timeout:  config.Duration(10 * time.Second),
+	}
+}
+
+// LoadConfig loads the sensor.community module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("sensorcommunity")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
+
+// run executes the main module loop
+func (sm *SensorCommunityModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("sensor.community module", "main", func() error {
+		interval := sm.config.Interval.Duration()
+		ticker := utils.NewScheduledTicker(ctx, interval)
+		defer ticker.Stop()
+
+		// Collect initial data unless outside the collection schedule or skipped,
+		// but not before an interval has passed since the last collection
+		initial := sm.collections.Initial(ctx, interval)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-initial:
+				if err := utils.CollectWithRetry(ctx, interval, sm.collectData); err != nil {
+					utils.Warnf("Failed to collect initial air quality data: %v", err)
+				} else {
+					sm.collections.Record()
+				}
+			case <-ticker.C:
+				if err := utils.CollectWithRetry(ctx, interval, sm.collectData); err != nil {
+					utils.Warnf("Failed to collect air quality data: %v", err)
+				} else {
+					sm.collections.Record()
+				}
+			}
+		}
+	})
+}
+
+// collectData polls all configured sensors.
+// A failing sensor does not prevent the others from being collected; the last error is returned.
+func (sm *SensorCommunityModule) collectData(ctx context.Context) error {
+	var lastErr error
+	for _, sensor := range sm.config.Sensors {
+		if err := sm.collectSensor(ctx, sensor); err != nil {
+			utils.Warnf("Failed to collect sensor %s: %v", sensor, err)
+			lastErr = err
+		}
+	}
+	for _, id := range sm.config.SensorIDs {
+		if err := sm.collectAPISensor(ctx, id); err != nil {
+			utils.Warnf("Failed to collect sensor %d from API: %v", id, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// collectSensor fetches the data.json document of a local sensor and sends its readings
+func (sm *SensorCommunityModule) collectSensor(ctx context.Context, sensor string) error {
+	return utils.WithPanicRecoveryAndReturnError("sensor.community data collection", sensor, func() error {
+		body, err := sm.fetch(ctx, sm.httpClient, dataURL(sensor))
+		if err != nil {
+			return err
+		}
+
+		var data SensorData
+		if err := json.Unmarshal(body, &data); err != nil {
+			return fmt.Errorf("failed to parse sensor data: %w", err)
+		}
+
+		fields := parseDataValues(data.SensorDataValues)
+		if len(fields) == 0 {
+			return fmt.Errorf("sensor reported no readings")
+		}
+
+		tags := sm.createBaseTags(sensor)
+		if location, ok := sm.config.Locations[sensor]; ok {
+			addLocationTags(tags, location)
+		}
+		sm.sendMetric(tags, fields, sm.clock.Now())
+		return nil
+	})
+}
+
+// collectAPISensor fetches the latest measurement of a sensor from the sensor.community API
+func (sm *SensorCommunityModule) collectAPISensor(ctx context.Context, id int) error {
+	return utils.WithPanicRecoveryAndReturnError("sensor.community data collection", "api", func() error {
+		body, err := sm.fetch(ctx, sm.apiClient, sm.apiURL(id))
+		if err != nil {
+			return err
+		}
+
+		var measurements []APIMeasurement
+		if err := json.Unmarshal(body, &measurements); err != nil {
+			return fmt.Errorf("failed to parse API response: %w", err)
+		}
+		measurement, timestamp, ok := latestMeasurement(measurements)
+		if !ok {
+			return fmt.Errorf("API returned no measurements")
+		}
+
+		fields := parseDataValues(measurement.SensorDataValues)
+		if len(fields) == 0 {
+			return fmt.Errorf("sensor reported no readings")
+		}
+
+		device := strconv.Itoa(id)
+		tags := sm.createBaseTags(device)
+		if location, ok := sm.config.Locations[device]; ok {
+			addLocationTags(tags, location)
+		} else if location, ok := measurement.Location.parse(); ok && sm.config.GeoTags {
+			addLocationTags(tags, location)
+		}
+		sm.sendMetric(tags, fields, timestamp)
+		return nil
+	})
+}
+
+// fetch performs a GET request and returns the response body of a successful request
+func (sm *SensorCommunityModule) fetch(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	sm.connection(url).SetPollResult(resp, err)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+// connection returns the tracker for an endpoint, creating it on first use
+func (sm *SensorCommunityModule) connection(url string) *connection.Tracker {
+	tracker, ok := sm.trackers[url]
+	if !ok {
+		tracker = connection.NewTracker(sm.config.InstanceName("sensorcommunity"), url, sm.metricsCh)
+		sm.trackers[url] = tracker
+	}
+	return tracker
+}
+
+// apiURL returns the API URL of a sensor
+func (sm *SensorCommunityModule) apiURL(id int) string {
+	return strings.TrimSuffix(sm.config.APIURL, "/") + "/" + strconv.Itoa(id) + "/"
+}
+
+// dataURL returns the data.json URL of a local sensor given as host or URL
+func dataURL(sensor string) string {
+	if !strings.Contains(sensor, "://") {
+		sensor = "http://" + sensor
+	}
+	if strings.HasSuffix(sensor, ".json") {
+		return sensor
+	}
+	return strings.TrimSuffix(sensor, "/") + "/data.json"
+}
+
+// parseDataValues converts the readings of a sensor to metric fields.
+// Unknown value types and values that are not numeric are skipped.
+func parseDataValues(values []DataValue) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for _, value := range values {
+		valueType := value.ValueType
+		if i := strings.LastIndex(valueType, "_"); i >= 0 {
+			valueType = valueType[i+1:]
+		}
+		name, ok := fieldNames[valueType]
+		if !ok {
+			continue
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(value.Value), 64)
+		if err != nil {
+			continue
+		}
+		if name == "pressure" {
+			// Reported in Pa, converted to hPa
+			number /= 100
+		}
+		fields[name] = number
+	}
+	return fields
+}
+
+// latestMeasurement returns the most recent measurement and its timestamp
+func latestMeasurement(measurements []APIMeasurement) (APIMeasurement, time.Time, bool) {
+	var latest APIMeasurement
+	var latestTime time.Time
+	found := false
+	for _, measurement := range measurements {
+		timestamp, err := time.ParseInLocation(apiTimeLayout, measurement.Timestamp, time.UTC)
+		if err != nil {
+			continue
+		}
+		if !found || timestamp.After(latestTime) {
+			latest, latestTime, found = measurement, timestamp, true
+		}
+	}
+	return latest, latestTime, found
+}
+
+// parse converts the location reported by the API, which is encoded as strings
+func (l APILocation) parse() (Location, bool) {
+	latitude, err := strconv.ParseFloat(l.Latitude, 64)
+	if err != nil {
+		return Location{}, false
+	}
+	longitude, err := strconv.ParseFloat(l.Longitude, 64)
+	if err != nil {
+		return Location{}, false
+	}
+	return Location{Latitude: latitude, Longitude: longitude}, true
+}
+
+// addLocationTags adds the latitude and longitude tags of a sensor location
+func addLocationTags(tags map[string]string, location Location) {
+	tags["latitude"] = strconv.FormatFloat(location.Latitude, 'f', -1, 64)
+	tags["longitude"] = strconv.FormatFloat(location.Longitude, 'f', -1, 64)
+}
+
+// createBaseTags creates base tags for a sensor
+func (sm *SensorCommunityModule) createBaseTags(device string) map[string]string {
+	return map[string]string{
+		"vendor":   "sensorcommunity",
+		"device":   device,
+		"friendly": sm.config.GetFriendlyName(device, "", device),
+	}
+}
+
+// sendMetric validates and sends an air quality metric to the metrics channel
+func (sm *SensorCommunityModule) sendMetric(tags map[string]string, fields map[string]interface{}, timestamp time.Time) {
+	metric := metrics.Metric{
+		Name:      metricNameAirQuality,
+		Tags:      tags,
+		Fields:    fields,
+		Timestamp: timestamp,
+	}
+
+	if err := metric.Validate(); err != nil {
+		utils.Warnf("Invalid %s metric for sensor %s: %v", metricNameAirQuality, tags["device"], err)
+		return
+	}
+
+	select {
+	case sm.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping %s metric for sensor %s", metricNameAirQuality, tags["device"])
+	}
+}
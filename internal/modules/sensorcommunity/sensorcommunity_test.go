@@ -0,0 +1,209 @@
+package sensorcommunity
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const sampleData = `{"software_version":"NRZ-2020-133","age":"42","sensordatavalues":[
+	{"value_type":"SDS_P1","value":"12.40"},
+	{"value_type":"SDS_P2","value":"7.10"},
+	{"value_type":"BME280_temperature","value":"21.30"},
+	{"value_type":"BME280_humidity","value":"48.20"},
+	{"value_type":"BME280_pressure","value":"101325.00"},
+	{"value_type":"samples","value":"871234"},
+	{"value_type":"max_micro","value":"20034"},
+	{"value_type":"signal","value":"-67"}
+]}`
+
+const sampleAPI = `[
+	{"timestamp":"2024-01-01 12:00:00","location":{"latitude":"52.52","longitude":"13.405"},"sensordatavalues":[{"value_type":"P1","value":"20.0"},{"value_type":"P2","value":"10.0"}]},
+	{"timestamp":"2024-01-01 12:02:30","location":{"latitude":"52.52","longitude":"13.405"},"sensordatavalues":[{"value_type":"P1","value":"22.5"},{"value_type":"P2","value":"11.5"}]}
+]`
+
+func TestNewSensorCommunityModule(t *testing.T) {
+	tah := utils.NewTestAssertionHelper()
+
+	_, err := NewSensorCommunityModule(Config{})
+	tah.AssertError(t, err, "Expected error for missing sensors")
+
+	module, err := NewSensorCommunityModule(Config{SensorIDs: []int{12345}})
+	tah.AssertNoError(t, err, "Failed to create sensor.community module")
+	if module.config.Interval.Duration() != 150*time.Second {
+		t.Errorf("Expected default interval 2m30s, got %v", module.config.Interval)
+	}
+	if module.apiURL(12345) != "https://data.sensor.community/airrohr/v1/sensor/12345/" {
+		t.Errorf("Unexpected API URL %s", module.apiURL(12345))
+	}
+}
+
+func TestDataURL(t *testing.T) {
+	tests := map[string]string{
+		"192.168.1.50":                  "http://192.168.1.50/data.json",
+		"http://airrohr.local/":         "http://airrohr.local/data.json",
+		"http://192.168.1.50/data.json": "http://192.168.1.50/data.json",
+	}
+	for sensor, expected := range tests {
+		if got := dataURL(sensor); got != expected {
+			t.Errorf("dataURL(%q) = %q, expected %q", sensor, got, expected)
+		}
+	}
+}
+
+func TestParseDataValues(t *testing.T) {
+	fields := parseDataValues([]DataValue{
+		{ValueType: "SDS_P1", Value: "12.40"},
+		{ValueType: "PMS_P0", Value: "3"},
+		{ValueType: "temperature", Value: "20.5"},
+		{ValueType: "BME280_pressure", Value: "100000"},
+		{ValueType: "humidity", Value: "n/a"},
+		{ValueType: "samples", Value: "100"},
+	})
+
+	expected := map[string]float64{"pm10": 12.4, "pm1": 3, "temperature": 20.5, "pressure": 1000}
+	if len(fields) != len(expected) {
+		t.Fatalf("Expected %d fields, got %v", len(expected), fields)
+	}
+	for name, value := range expected {
+		if fields[name] != value {
+			t.Errorf("Expected %s=%v, got %v", name, value, fields[name])
+		}
+	}
+}
+
+func TestCollectSensor(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/data.json" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, sampleData)
+	}))
+	defer server.Close()
+
+	sensor := strings.TrimPrefix(server.URL, "http://")
+	module, err := NewSensorCommunityModule(Config{
+		Sensors:   []string{sensor},
+		Locations: map[string]Location{sensor: {Latitude: 50.94, Longitude: 6.96}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.metricsCh = ch
+
+	if err := module.collectData(context.Background()); err != nil {
+		t.Fatalf("collectData failed: %v", err)
+	}
+
+	// Expect: connection status, air quality metric
+	collected := drain(ch)
+	if len(collected) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(collected))
+	}
+	if collected[0].Name != "connection_status" {
+		t.Errorf("Expected connection status metric first, got %+v", collected[0])
+	}
+
+	metric := collected[1]
+	if metric.Name != "air_quality" || metric.Tags["device"] != sensor || metric.Tags["vendor"] != "sensorcommunity" {
+		t.Errorf("Unexpected metric %+v", metric)
+	}
+	if metric.Tags["latitude"] != "50.94" || metric.Tags["longitude"] != "6.96" {
+		t.Errorf("Expected location tags, got %v", metric.Tags)
+	}
+	if metric.Fields["pm10"] != 12.4 || metric.Fields["pm2_5"] != 7.1 || metric.Fields["temperature"] != 21.3 ||
+		metric.Fields["humidity"] != 48.2 || metric.Fields["pressure"] != 1013.25 || metric.Fields["signal"] != -67.0 {
+		t.Errorf("Unexpected fields %v", metric.Fields)
+	}
+	if _, ok := metric.Fields["samples"]; ok {
+		t.Errorf("Expected samples to be skipped, got %v", metric.Fields)
+	}
+}
+
+func TestCollectAPISensor(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sensor/12345/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, sampleAPI)
+	}))
+	defer server.Close()
+
+	for _, geoTags := range []bool{false, true} {
+		module, err := NewSensorCommunityModule(Config{SensorIDs: []int{12345}, APIURL: server.URL + "/sensor", GeoTags: geoTags})
+		if err != nil {
+			t.Fatalf("Failed to create module: %v", err)
+		}
+		ch := make(chan metrics.Metric, 10)
+		module.metricsCh = ch
+
+		if err := module.collectData(context.Background()); err != nil {
+			t.Fatalf("collectData failed: %v", err)
+		}
+
+		collected := drain(ch)
+		if len(collected) != 2 {
+			t.Fatalf("Expected 2 metrics, got %d", len(collected))
+		}
+		metric := collected[1]
+		if metric.Tags["device"] != "12345" || metric.Fields["pm10"] != 22.5 || metric.Fields["pm2_5"] != 11.5 {
+			t.Errorf("Expected latest measurement, got %+v", metric)
+		}
+		if !metric.Timestamp.Equal(time.Date(2024, 1, 1, 12, 2, 30, 0, time.UTC)) {
+			t.Errorf("Expected API timestamp, got %v", metric.Timestamp)
+		}
+		if _, ok := metric.Tags["latitude"]; ok != geoTags {
+			t.Errorf("Expected location tags only with geo_tags, got %v", metric.Tags)
+		}
+	}
+}
+
+func TestCollectDataContinuesAfterFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, sampleData)
+	}))
+	defer server.Close()
+
+	module, err := NewSensorCommunityModule(Config{Sensors: []string{"127.0.0.1:1", server.URL}})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.metricsCh = ch
+
+	if err := module.collectData(context.Background()); err == nil {
+		t.Error("Expected error for unreachable sensor")
+	}
+
+	var airQuality int
+	for _, metric := range drain(ch) {
+		if metric.Name == "air_quality" {
+			airQuality++
+		}
+	}
+	if airQuality != 1 {
+		t.Errorf("Expected 1 air quality metric for the reachable sensor, got %d", airQuality)
+	}
+}
+
+func drain(ch chan metrics.Metric) []metrics.Metric {
+	var result []metrics.Metric
+	for {
+		select {
+		case metric := <-ch:
+			result = append(result, metric)
+		default:
+			return result
+		}
+	}
+}
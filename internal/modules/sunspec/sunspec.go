@@ -0,0 +1,478 @@
+// Package sunspec provides a module reading inverters, meters and batteries
+// that implement the SunSpec information model via Modbus TCP. The devices
+// and their models are discovered automatically, so no register map has to be
+// configured.
+package sunspec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/modbus"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// defaultPort is the Modbus TCP port used for hosts without port
+	defaultPort = "502"
+
+	// Metric names
+	metricElectricity = "electricity"
+	metricStorage     = "energy_storage"
+)
+
+// defaultUnitIDs are the unit IDs SunSpec devices commonly answer on: 1 (most
+// inverters and SolarEdge), 71 (Kostal) and 126 (SMA)
+var defaultUnitIDs = []int{1, 71, 126}
+
+// energyCounterKinds are the kinds of the energy totals, which the devices
+// report since their installation
+var energyCounterKinds = map[string]metrics.Kind{
+	"yield_total":  metrics.KindCounter,
+	"import_total": metrics.KindCounter,
+	"export_total": metrics.KindCounter,
+}
+
+// Config represents the configuration for the SunSpec module
+type Config struct {
+	config.BaseConfig
+	Hosts    []string        `json:"hosts"`              // Modbus TCP addresses (e.g. "192.168.1.20" or "192.168.1.20:1502")
+	UnitIDs  []int           `json:"unit_ids,omitempty"` // Unit IDs to scan (defaults to 1, 71 and 126)
+	Interval config.Duration `json:"interval,omitempty"` // Polling interval (defaults to 30s)
+	Timeout  config.Duration `json:"timeout,omitempty"`  // Request timeout (defaults to 5s)
+}
+
+// Device is a SunSpec device discovered on a host. A unit ID can hold several
+// devices, e.g. an inverter and its meters, each starting with a common model.
+type Device struct {
+	UnitID       byte
+	ID           string // serial number, or host and unit ID if the device has none
+	Manufacturer string
+	Model        string
+	Version      string
+	Models       []modbus.Model // inverter, meter and storage models of the device
+}
+
+// host is a configured Modbus TCP host and the devices discovered on it
+type host struct {
+	address string
+	name    string
+	devices []Device // nil until discovered
+}
+
+// SunspecModule handles polling of SunSpec devices
+type SunspecModule struct {
+	config      Config
+	hosts       []*host
+	unitIDs     []byte
+	metricsCh   chan<- metrics.Metric
+	clock       utils.Clock
+	collections *utils.CollectionLog   // last successful collection, nil if not remembered
+	inventory   *utils.ModuleInventory // Receives the metadata of discovered devices
+}
+
+// Run starts the SunSpec module and begins collecting metrics
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewSunspecModule(config)
+	if err != nil {
+		return fmt.Errorf("failed to create SunSpec module: %w", err)
+	}
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+	module.collections = utils.OpenCollectionLog(config.InstanceName("sunspec"), module.clock)
+	module.inventory = utils.InventoryFromContext(ctx)
+
+	return module.run(ctx)
+}
+
+// Probe validates the SunSpec configuration and checks that the hosts are reachable
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewSunspecModule(cfg)
+	if err != nil {
+		return &config.ModuleError{Module: "sunspec", Err: err}
+	}
+	for _, h := range module.hosts {
+		if err := utils.ProbeAddress(ctx, h.address, module.config.Timeout.Duration()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewSunspecModule creates a new SunSpec module instance
+func NewSunspecModule(cfg Config) (*SunspecModule, error) {
+	utils.Debugf("Creating new SunSpec module instance")
+
+	if len(cfg.Hosts) == 0 {
+		return nil, fmt.Errorf("hosts are required but not configured")
+	}
+	if len(cfg.UnitIDs) == 0 {
+		cfg.UnitIDs = defaultUnitIDs
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(30 * time.Second)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = config.Duration(5 * time.Second)
+	}
+
+	module := &SunspecModule{
+		clock:  utils.SystemClock,
+		config: cfg,
+	}
+
+	for _, address := range cfg.Hosts {
+		name, _, err := net.SplitHostPort(address)
+		if err != nil {
+			name = address
+			address = net.JoinHostPort(address, defaultPort)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("invalid host %q", address)
+		}
+		module.hosts = append(module.hosts, &host{address: address, name: name})
+	}
+	for _, unitID := range cfg.UnitIDs {
+		if unitID < 1 || unitID > 255 {
+			return nil, fmt.Errorf("unit_ids must be between 1 and 255, got %d", unitID)
+		}
+		module.unitIDs = append(module.unitIDs, byte(unitID))
+	}
+
+	utils.Debugf("SunSpec module created successfully")
+	return module, nil
+}
+
+// DefaultConfig returns the default configuration of the SunSpec module.
+func DefaultConfig() Config {
+	return Config{
+		UnitIDs:  defaultUnitIDs,
+		Interval: config.Duration(30 * time.Second),
+		Timeout:  config.Duration(5 * time.Second),
+	}
+}
+
+// LoadConfig loads the SunSpec module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("sunspec")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
+
+// run executes the main module loop
+func (sm *SunspecModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("SunSpec module", "main", func() error {
+		interval := sm.config.Interval.Duration()
+		ticker := utils.NewScheduledTicker(ctx, interval)
+		defer ticker.Stop()
+
+		// Collect initial data unless outside the collection schedule or skipped,
+		// but not before an interval has passed since the last collection
+		initial := sm.collections.Initial(ctx, interval)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-initial:
+				if err := utils.CollectWithRetry(ctx, interval, sm.collectData); err != nil {
+					utils.Warnf("Failed to collect initial SunSpec data: %v", err)
+				} else {
+					sm.collections.Record()
+				}
+			case <-ticker.C:
+				if err := utils.CollectWithRetry(ctx, interval, sm.collectData); err != nil {
+					utils.Warnf("Failed to collect SunSpec data: %v", err)
+				} else {
+					sm.collections.Record()
+				}
+			}
+		}
+	})
+}
+
+// collectData reads the devices of all hosts. A host failing doesn't keep
+// the others from being read; an error is only returned if all hosts failed,
+// so a retry doesn't send the metrics of the other hosts twice.
+func (sm *SunspecModule) collectData(ctx context.Context) error {
+	var errs []error
+	for _, h := range sm.hosts {
+		err := utils.WithPanicRecoveryAndReturnError("SunSpec data collection", h.name, func() error {
+			return sm.collectHost(ctx, h)
+		})
+		if err != nil {
+			utils.Warnf("Failed to read SunSpec host %s: %v", h.name, err)
+			errs = append(errs, fmt.Errorf("host %s: %w", h.name, err))
+		}
+	}
+	if len(errs) == len(sm.hosts) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// collectHost reads the devices of a host, discovering them first if not yet
+// known. The devices are discovered again after a read error, as it may be
+// caused by a changed setup, e.g. a replaced inverter.
+func (sm *SunspecModule) collectHost(ctx context.Context, h *host) error {
+	client := modbus.Client{
+		Address:  h.address,
+		Timeout:  sm.config.Timeout.Duration(),
+		Instance: sm.config.InstanceName("sunspec"),
+	}
+	conn, err := client.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if h.devices == nil {
+		devices, err := discoverDevices(conn, h.name, sm.unitIDs)
+		if err != nil {
+			return err
+		}
+		h.devices = devices
+		for _, device := range devices {
+			utils.Infof("Discovered SunSpec device %s (%s %s) on %s, unit %d", device.ID, device.Manufacturer, device.Model, h.name, device.UnitID)
+			sm.inventory.Report(device.ID, utils.DeviceMetadata{
+				Model:    device.Manufacturer + " " + device.Model,
+				IP:       h.name,
+				Firmware: device.Version,
+			})
+		}
+	}
+
+	timestamp := sm.clock.Now()
+	for _, device := range h.devices {
+		conn.SetUnitID(device.UnitID)
+		for _, model := range device.Models {
+			block, err := conn.ReadModel(model)
+			if err != nil {
+				h.devices = nil
+				return fmt.Errorf("failed to read model %d of device %s: %w", model.ID, device.ID, err)
+			}
+			sm.sendMetric(device, model.ID, block, timestamp)
+		}
+	}
+	return nil
+}
+
+// discoverDevices scans the unit IDs of a host for SunSpec devices. Units
+// without SunSpec models or answering with a Modbus exception, e.g. because
+// they don't exist behind a gateway, are skipped.
+func discoverDevices(conn *modbus.Conn, hostName string, unitIDs []byte) ([]Device, error) {
+	var devices []Device
+	for _, unitID := range unitIDs {
+		conn.SetUnitID(unitID)
+		models, err := unitModels(conn)
+		if err != nil {
+			if isNoSunSpec(err) {
+				utils.Debugf("No SunSpec device on %s, unit %d: %v", hostName, unitID, err)
+				continue
+			}
+			return nil, err
+		}
+
+		var device *Device
+		for _, model := range models {
+			if model.ID == modbus.ModelCommon {
+				common, err := conn.ReadModel(model)
+				if err != nil {
+					return nil, err
+				}
+				devices = append(devices, parseCommonModel(common, hostName, unitID))
+				device = &devices[len(devices)-1]
+				continue
+			}
+			if device == nil || !isSupportedModel(model.ID) {
+				continue
+			}
+			device.Models = append(device.Models, model)
+		}
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no SunSpec device found on unit IDs %v", unitIDs)
+	}
+	return devices, nil
+}
+
+// unitModels returns the SunSpec models of the current unit, trying the base
+// addresses in turn
+func unitModels(conn *modbus.Conn) ([]modbus.Model, error) {
+	var err error
+	for _, base := range modbus.BaseAddresses {
+		var models []modbus.Model
+		models, err = conn.SunSpecModels(base)
+		if err == nil || !isNoSunSpec(err) {
+			return models, err
+		}
+	}
+	return nil, err
+}
+
+// isNoSunSpec reports whether an error shows that the unit has no SunSpec
+// models at an address, in contrast to a failed connection
+func isNoSunSpec(err error) bool {
+	var exception *modbus.Exception
+	return errors.Is(err, modbus.ErrNoSunSpec) || errors.As(err, &exception)
+}
+
+// parseCommonModel reads the identification of a device from its common model (1)
+func parseCommonModel(block []uint16, hostName string, unitID byte) Device {
+	device := Device{UnitID: unitID}
+	if len(block) >= 64 {
+		device.Manufacturer = modbus.String(block[0:16])
+		device.Model = modbus.String(block[16:32])
+		device.Version = modbus.String(block[40:48])
+		device.ID = modbus.String(block[48:64])
+	}
+	if device.ID == "" {
+		device.ID = hostName + "_" + strconv.Itoa(int(unitID))
+	}
+	return device
+}
+
+// isSupportedModel reports whether the module maps the registers of a model
+func isSupportedModel(id uint16) bool {
+	return isInverterModel(id) || isMeterModel(id) || id == modbus.ModelStorage
+}
+
+func isInverterModel(id uint16) bool {
+	return id >= modbus.ModelInverterFirst && id <= modbus.ModelInverterLast
+}
+
+func isMeterModel(id uint16) bool {
+	return id >= modbus.ModelMeterFirst && id <= modbus.ModelMeterLast
+}
+
+// inverterFields maps an inverter model (101-103)
+func inverterFields(block []uint16) map[string]*float64 {
+	if len(block) < 37 {
+		return nil
+	}
+	return map[string]*float64{
+		"power":       modbus.Scaled(modbus.Int16(block[12]), block[13]),
+		"current":     modbus.Scaled(modbus.Uint16(block[0]), block[4]),
+		"voltage":     modbus.Scaled(modbus.Uint16(block[8]), block[11]),
+		"frequency":   modbus.Scaled(modbus.Uint16(block[14]), block[15]),
+		"yield_total": modbus.Scaled(modbus.Acc32(block[22], block[23]), block[24]),
+		"dc_current":  modbus.Scaled(modbus.Uint16(block[25]), block[26]),
+		"dc_voltage":  modbus.Scaled(modbus.Uint16(block[27]), block[28]),
+		"dc_power":    modbus.Scaled(modbus.Int16(block[29]), block[30]),
+		"temperature": modbus.Scaled(modbus.Int16(block[31]), block[35]),
+		"state":       modbus.Uint16(block[36]),
+	}
+}
+
+// meterFields maps a meter model (201-204). The power is positive when
+// importing from the grid.
+func meterFields(block []uint16) map[string]*float64 {
+	if len(block) < 53 {
+		return nil
+	}
+	fields := map[string]*float64{
+		"power":        modbus.Scaled(modbus.Int16(block[16]), block[20]),
+		"current":      modbus.Scaled(modbus.Int16(block[0]), block[4]),
+		"voltage":      modbus.Scaled(modbus.Int16(block[5]), block[13]),
+		"frequency":    modbus.Scaled(modbus.Int16(block[14]), block[15]),
+		"export_total": modbus.Scaled(modbus.Acc32(block[36], block[37]), block[52]),
+		"import_total": modbus.Scaled(modbus.Acc32(block[44], block[45]), block[52]),
+	}
+	// Split the signed power, so import and export can be summed up separately
+	if power := fields["power"]; power != nil {
+		importPower, exportPower := max(*power, 0), max(-*power, 0)
+		fields["import_power"] = &importPower
+		fields["export_power"] = &exportPower
+	}
+	return fields
+}
+
+// storageFields maps the storage model (124)
+func storageFields(block []uint16) map[string]*float64 {
+	if len(block) < 23 {
+		return nil
+	}
+	return map[string]*float64{
+		"soc":           modbus.Scaled(modbus.Uint16(block[6]), block[20]),
+		"voltage":       modbus.Scaled(modbus.Uint16(block[8]), block[22]),
+		"charge_status": modbus.Uint16(block[9]),
+	}
+}
+
+// sendMetric creates and sends the metric of a model of a device
+func (sm *SunspecModule) sendMetric(device Device, modelID uint16, block []uint16, timestamp time.Time) {
+	name := metricElectricity
+	tags := map[string]string{
+		"vendor":   "sunspec",
+		"device":   device.ID,
+		"friendly": sm.config.GetFriendlyName(device.ID, device.Manufacturer+" "+device.Model, device.ID),
+	}
+
+	var values map[string]*float64
+	switch {
+	case isInverterModel(modelID):
+		values = inverterFields(block)
+		tags["type"] = "inverter"
+	case isMeterModel(modelID):
+		values = meterFields(block)
+		tags["type"] = "meter"
+	default:
+		values = storageFields(block)
+		name = metricStorage
+	}
+
+	fields := make(map[string]interface{})
+	for field, value := range values {
+		if value != nil {
+			fields[field] = *value
+		}
+	}
+	if len(fields) == 0 {
+		utils.Warnf("SunSpec device %s reported no values in model %d", device.ID, modelID)
+		return
+	}
+
+	metric := metrics.Metric{
+		Name:       name,
+		Tags:       tags,
+		Fields:     fields,
+		FieldKinds: energyCounterKinds,
+		Timestamp:  timestamp,
+	}
+
+	if err := metric.Validate(); err != nil {
+		utils.Warnf("Invalid metric for SunSpec device %s: %v", device.ID, err)
+		return
+	}
+
+	select {
+	case sm.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping metric for SunSpec device %s", device.ID)
+	}
+}
@@ -0,0 +1,155 @@
+package sunspec
+
+import (
+	"context"
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/internal/testutil"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// commonModel returns the points of a common model (1) with the given identification
+func commonModel(manufacturer, model, serial string) map[uint16]uint16 {
+	points := make(map[uint16]uint16)
+	for offset, s := range map[uint16]string{0: manufacturer, 16: model, 48: serial} {
+		for i := 0; i < len(s); i += 2 {
+			register := uint16(s[i]) << 8
+			if i+1 < len(s) {
+				register |= uint16(s[i+1])
+			}
+			points[offset+uint16(i/2)] = register
+		}
+	}
+	return points
+}
+
+func TestNewSunspecModule(t *testing.T) {
+	tah := utils.NewTestAssertionHelper()
+
+	_, err := NewSunspecModule(Config{})
+	tah.AssertError(t, err, "Expected error for missing hosts")
+
+	_, err = NewSunspecModule(Config{Hosts: []string{"inverter"}, UnitIDs: []int{0}})
+	tah.AssertError(t, err, "Expected error for invalid unit ID")
+
+	module, err := NewSunspecModule(Config{Hosts: []string{"192.168.1.20", "192.168.1.21:1502"}})
+	tah.AssertNoError(t, err, "Failed to create module")
+	if module.hosts[0].address != "192.168.1.20:502" || module.hosts[1].address != "192.168.1.21:1502" || module.hosts[1].name != "192.168.1.21" {
+		t.Errorf("Unexpected hosts %+v %+v", module.hosts[0], module.hosts[1])
+	}
+	if len(module.unitIDs) != 3 {
+		t.Errorf("Expected the default unit IDs, got %v", module.unitIDs)
+	}
+}
+
+func TestCollectData(t *testing.T) {
+	// Unit 1: inverter with a meter behind it, each with its own common model
+	inverter := testutil.NewSunSpecDevice(40000)
+	inverter.AddModel(1, 66, commonModel("SolarEdge", "SE8K", "7E1234"))
+	inverter.AddModel(103, 50, map[uint16]uint16{
+		0: 215, 4: 0xFFFE, // A = 2.15
+		8: 2301, 11: 0xFFFF, // PhVphA = 230.1
+		12: 4800, 13: 0, // W
+		14: 5001, 15: 0xFFFE, // Hz = 50.01
+		22: 0x00BC, 23: 0x614E, 24: 0, // WH = 12345678
+		25: 0xFFFF, 26: 0, // DCA not implemented
+		29: 510, 30: 1, // DCW = 5100
+		31: 452, 35: 0xFFFF, // TmpCab = 45.2
+		36: 4, // St = MPPT
+	})
+	inverter.AddModel(1, 66, commonModel("SolarEdge", "WND-3Y", ""))
+	inverter.AddModel(203, 105, map[uint16]uint16{
+		16: 0xFFF6, 20: 2, // W = -1000
+		36: 0, 37: 500, 44: 0, 45: 300, 52: 1, // TotWhExp = 5000, TotWhImp = 3000
+	})
+	inverter.AddModel(160, 20, nil) // MPPT model, not mapped
+
+	// Unit 126: battery at the alternative base address
+	battery := testutil.NewSunSpecDevice(50000)
+	battery.AddModel(1, 66, commonModel("BYD", "HVS", "B42"))
+	battery.AddModel(124, 24, map[uint16]uint16{6: 8700, 20: 0xFFFE, 8: 4032, 22: 0xFFFF, 9: 4}) // 87 %, 403.2 V, charging
+
+	address := testutil.ServeModbus(t, map[byte]map[uint16]uint16{1: inverter.End(), 126: battery.End()})
+	module, err := NewSunspecModule(Config{Hosts: []string{address}})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.metricsCh = ch
+
+	if err := module.collectData(context.Background()); err != nil {
+		t.Fatalf("collectData failed: %v", err)
+	}
+	devices := module.hosts[0].devices
+	if len(devices) != 3 || devices[0].ID != "7E1234" || devices[2].UnitID != 126 {
+		t.Fatalf("Unexpected devices %+v", devices)
+	}
+	if meterID := "127.0.0.1_1"; devices[1].ID != meterID {
+		t.Errorf("Expected meter without serial to be %s, got %s", meterID, devices[1].ID)
+	}
+
+	if len(ch) != 3 {
+		t.Fatalf("Expected 3 metrics, got %d", len(ch))
+	}
+	tests := []struct {
+		name     string
+		tags     map[string]string
+		expected map[string]interface{}
+	}{
+		{"electricity", map[string]string{"device": "7E1234", "friendly": "SolarEdge SE8K", "type": "inverter"}, map[string]interface{}{
+			"power":       4800.0,
+			"current":     2.15,
+			"voltage":     230.1,
+			"frequency":   50.01,
+			"yield_total": 12345678.0,
+			"dc_power":    5100.0,
+			"temperature": 45.2,
+			"state":       4.0,
+		}},
+		{"electricity", map[string]string{"device": "127.0.0.1_1", "type": "meter"}, map[string]interface{}{
+			"power":        -1000.0,
+			"import_power": 0.0,
+			"export_power": 1000.0,
+			"import_total": 3000.0,
+			"export_total": 5000.0,
+		}},
+		{"energy_storage", map[string]string{"device": "B42", "friendly": "BYD HVS"}, map[string]interface{}{
+			"soc":           87.0,
+			"voltage":       403.2,
+			"charge_status": 4.0,
+		}},
+	}
+	for _, tt := range tests {
+		metric := <-ch
+		if metric.Name != tt.name || metric.Tags["vendor"] != "sunspec" {
+			t.Errorf("Unexpected metric %s with tags %v", metric.Name, metric.Tags)
+		}
+		for tag, value := range tt.tags {
+			if metric.Tags[tag] != value {
+				t.Errorf("Expected tag %s to be %s, got %s", tag, value, metric.Tags[tag])
+			}
+		}
+		for field, value := range tt.expected {
+			if metric.Fields[field] != value {
+				t.Errorf("Expected %s of %s to be %v, got %v", field, metric.Tags["device"], value, metric.Fields[field])
+			}
+		}
+	}
+}
+
+func TestCollectDataWithoutDevices(t *testing.T) {
+	address := testutil.ServeModbus(t, map[byte]map[uint16]uint16{1: {}})
+	module, err := NewSunspecModule(Config{Hosts: []string{address}})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	module.metricsCh = make(chan metrics.Metric, 10)
+
+	if err := module.collectData(context.Background()); err == nil {
+		t.Error("Expected error without SunSpec devices")
+	}
+	if module.hosts[0].devices != nil {
+		t.Error("Expected devices to be discovered again")
+	}
+}
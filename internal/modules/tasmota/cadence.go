@@ -0,0 +1,134 @@
+package tasmota
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// sensorTimeLayout is the layout of the "Time" field of tele/SENSOR messages,
+	// the device's local time without zone
+	sensorTimeLayout = "2006-01-02T15:04:05"
+
+	// minTelePeriod is the shortest tele period Tasmota accepts
+	minTelePeriod = 10 * time.Second
+)
+
+// cadence is the tele/SENSOR message cadence of a device.
+type cadence struct {
+	last   time.Time     // Device time of the last message
+	period time.Duration // Expected interval, learned or configured
+	missed int           // Messages missed since the device was discovered
+}
+
+// CadenceTracker detects missed tele/SENSOR messages per device. Devices send
+// sensor data every tele period; a longer gap between the device times of
+// consecutive messages means messages got lost between device and agent.
+type CadenceTracker struct {
+	mu      sync.Mutex
+	period  time.Duration // Configured tele period, 0 to learn it per device
+	devices map[string]*cadence
+}
+
+// NewCadenceTracker creates a cadence tracker. If period is 0, the tele period
+// of each device is learned as the shortest interval between its messages.
+func NewCadenceTracker(period time.Duration) *CadenceTracker {
+	return &CadenceTracker{
+		period:  period,
+		devices: make(map[string]*cadence),
+	}
+}
+
+// Observe records a message of a device sent at the given device time. It
+// returns the number of messages missed before it and the total missed since
+// the device was first seen.
+func (ct *CadenceTracker) Observe(topic string, sent time.Time) (missed, total int) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	c, exists := ct.devices[topic]
+	if !exists {
+		ct.devices[topic] = &cadence{last: sent, period: ct.period}
+		return 0, 0
+	}
+
+	gap := sent.Sub(c.last)
+	if gap <= 0 {
+		// Redelivered, out of order, or the device clock was set back
+		if gap < 0 {
+			c.last = sent
+		}
+		return 0, c.missed
+	}
+	c.last = sent
+
+	if ct.period == 0 && (c.period == 0 || gap < c.period) {
+		c.period = max(gap, minTelePeriod)
+	}
+	if c.period == 0 {
+		return 0, c.missed
+	}
+
+	// Allow jitter of half a period before counting a message as missed
+	missed = int(math.Round(float64(gap)/float64(c.period))) - 1
+	if missed > 0 {
+		c.missed += missed
+	}
+	return max(missed, 0), c.missed
+}
+
+// Remove forgets the cadence of a device, e.g. when it expired.
+func (ct *CadenceTracker) Remove(topic string) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	delete(ct.devices, topic)
+}
+
+// sensorTime returns the device time of a tele/SENSOR message, or the given
+// receive time if the message has no valid "Time" field.
+func sensorTime(sensorData map[string]any, received time.Time) time.Time {
+	value, ok := sensorData["Time"].(string)
+	if !ok {
+		return received
+	}
+	sent, err := time.Parse(sensorTimeLayout, value)
+	if err != nil {
+		return received
+	}
+	return sent
+}
+
+// trackCadence records a sensor message of a device and sends its missed
+// message counter when messages were lost before it.
+func (tm *TasmotaModule) trackCadence(device *DeviceInfo, sensorData map[string]any) {
+	missed, total := tm.cadence.Observe(device.T, sensorTime(sensorData, tm.clock.Now()))
+	if missed == 0 {
+		return
+	}
+	utils.Debugf("Tasmota device %s: %d sensor messages missed", device.T, missed)
+
+	if tm.metricsCh == nil {
+		return
+	}
+	metric := metrics.Metric{
+		Name: metricNameDeviceStatus,
+		Tags: map[string]string{
+			"vendor":   "tasmota",
+			"device":   device.T,
+			"friendly": tm.config.GetFriendlyName(device, ""),
+		},
+		Fields:    map[string]interface{}{"missed_messages": total},
+		Kind:      metrics.KindCumulative,
+		Timestamp: tm.clock.Now(),
+	}
+
+	select {
+	case tm.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping missed messages metric for %s", device.T)
+	}
+}
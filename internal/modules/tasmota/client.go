@@ -0,0 +1,24 @@
+package tasmota
+
+import (
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTTClient is the part of the paho MQTT client used by the module, so that
+// tests can inject a fake client instead of connecting to a broker.
+type MQTTClient interface {
+	Connect() mqtt.Token
+	Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token
+	Unsubscribe(topics ...string) mqtt.Token
+	IsConnected() bool
+	Disconnect(quiesce uint)
+}
+
+// ClientFactory creates the MQTT client from the options set up by the module,
+// including its connection handlers.
+type ClientFactory func(opts *mqtt.ClientOptions) MQTTClient
+
+// newPahoClient creates a paho MQTT client connecting to the broker.
+func newPahoClient(opts *mqtt.ClientOptions) MQTTClient {
+	return mqtt.NewClient(opts)
+}
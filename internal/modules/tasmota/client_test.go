@@ -0,0 +1,179 @@
+package tasmota_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/connection"
+	"github.com/janhuddel/metrics-agent/internal/modules/tasmota"
+	"github.com/janhuddel/metrics-agent/internal/testutil"
+)
+
+const (
+	discoveryTopic = "tasmota/discovery/48551917E7AE/config"
+	sensorTopic    = "tele/tasmota_17E7AE/SENSOR"
+)
+
+// startWithFakeClient runs a module connected to a fake MQTT client until the
+// test finishes, and waits for the discovery subscription.
+func startWithFakeClient(t *testing.T) (*tasmota.TasmotaModule, *testutil.MQTTClient, *testutil.CollectingSink) {
+	t.Helper()
+
+	cfg := tasmota.DefaultConfig()
+	cfg.Broker = "tcp://localhost:1883"
+	cfg.ClientID = "test-client"
+	module := tasmota.NewTasmotaModule(cfg)
+	sink := testutil.NewCollectingSink(t)
+	module.SetMetricsChannel(sink.Chan())
+
+	client := testutil.NewMQTTClient()
+	module.SetClientFactory(func(opts *mqtt.ClientOptions) tasmota.MQTTClient {
+		return client.New(opts)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- module.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Expected no error after cancellation, got %v", err)
+		}
+		if client.IsConnected() {
+			t.Error("Expected client to be disconnected")
+		}
+	})
+
+	deadline := time.Now().Add(testutil.DefaultTimeout)
+	for !client.Subscribed("tasmota/discovery/+/config") {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected module to subscribe to the discovery topic")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return module, client, sink
+}
+
+// TestRunWithFakeClient tests discovery, sensor messages and the subscription
+// bookkeeping against a fake MQTT client.
+func TestRunWithFakeClient(t *testing.T) {
+	module, client, sink := startWithFakeClient(t)
+
+	status := sink.WaitFor(t, 1)[0]
+	if status.Name != connection.Measurement || status.Fields["status"] != 1 {
+		t.Errorf("Expected connected status, got %+v", status)
+	}
+	sink.Reset()
+
+	payload := []byte(`{"t":"tasmota_17E7AE","dn":"plug","md":"Nous A1T"}`)
+	if n := client.Deliver(discoveryTopic, payload); n != 1 {
+		t.Fatalf("Expected discovery message to be delivered once, got %d", n)
+	}
+	if !client.Subscribed(sensorTopic) {
+		t.Fatalf("Expected subscription of sensor topic, got %v", client.Subscriptions())
+	}
+	if _, exists := module.DeviceManager().GetDevice("tasmota_17E7AE"); !exists {
+		t.Error("Expected discovered device to be stored")
+	}
+
+	// A retained re-delivery doesn't subscribe again
+	client.Deliver(discoveryTopic, payload)
+	if subscriptions := client.Subscriptions(); len(subscriptions) != 2 {
+		t.Errorf("Expected discovery and sensor subscription, got %v", subscriptions)
+	}
+
+	client.Deliver(sensorTopic, []byte(`{"ENERGY":{"Power":150.5}}`))
+	sink.WaitFor(t, 2) // device status and electricity
+	electricity := sink.Named("electricity")
+	if len(electricity) != 1 || electricity[0].Fields["power"] != 150.5 {
+		t.Errorf("Expected electricity metric with power 150.5, got %+v", electricity)
+	}
+}
+
+// TestReconnectWithFakeClient tests that the connection state is reported and
+// the subscriptions are kept across a reconnect.
+func TestReconnectWithFakeClient(t *testing.T) {
+	_, client, sink := startWithFakeClient(t)
+	client.Deliver(discoveryTopic, []byte(`{"t":"tasmota_17E7AE","dn":"plug"}`))
+	sink.WaitFor(t, 2) // connection and device status
+	sink.Reset()
+
+	client.LoseConnection(errors.New("broker restarted"))
+	if n := client.Deliver(sensorTopic, []byte(`{"ENERGY":{"Power":1}}`)); n != 0 {
+		t.Error("Expected no delivery while disconnected")
+	}
+	client.Reconnect()
+
+	statuses := sink.WaitFor(t, 2)
+	if statuses[0].Fields["status"] != 0 || statuses[1].Fields["status"] != 1 || statuses[1].Fields["reconnects"] != 1 {
+		t.Errorf("Expected lost and re-established connection, got %+v", statuses)
+	}
+	if client.Connects() != 2 {
+		t.Errorf("Expected 2 connects, got %d", client.Connects())
+	}
+
+	// The persistent session keeps the sensor subscription of the device
+	if n := client.Deliver(sensorTopic, []byte(`{"ENERGY":{"Power":2}}`)); n != 1 {
+		t.Errorf("Expected sensor message to be delivered after reconnect, got %d deliveries", n)
+	}
+	sink.WaitFor(t, 3)
+}
+
+// TestSubscriptionBookkeeping tests that failed subscriptions are retried on
+// the next discovery, and that a topic change moves the subscription.
+func TestSubscriptionBookkeeping(t *testing.T) {
+	module, client, _ := startWithFakeClient(t)
+
+	client.FailSubscribe(sensorTopic, errors.New("not authorized"))
+	client.Deliver(discoveryTopic, []byte(`{"t":"tasmota_17E7AE","dn":"plug"}`))
+
+	// The failed subscription is forgotten, so the next discovery retries it
+	deadline := time.Now().Add(testutil.DefaultTimeout)
+	for subscribed(module, sensorTopic) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected failed subscription to be forgotten")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	client.FailSubscribe(sensorTopic, nil)
+	client.Deliver(discoveryTopic, []byte(`{"t":"tasmota_17E7AE","dn":"plug","ip":"10.0.0.2"}`))
+	if !client.Subscribed(sensorTopic) || !subscribed(module, sensorTopic) {
+		t.Fatal("Expected sensor topic to be subscribed on the next discovery")
+	}
+
+	// A device changing its topic is moved to the new sensor topic
+	client.Deliver(discoveryTopic, []byte(`{"t":"tasmota_kitchen","dn":"plug"}`))
+	if client.Subscribed(sensorTopic) || subscribed(module, sensorTopic) {
+		t.Error("Expected subscription of the previous topic to be removed")
+	}
+	if !client.Subscribed("tele/tasmota_kitchen/SENSOR") {
+		t.Errorf("Expected subscription of the new topic, got %v", client.Subscriptions())
+	}
+}
+
+// TestConnectFailureWithFakeClient tests that a failed connect ends the module.
+func TestConnectFailureWithFakeClient(t *testing.T) {
+	module := tasmota.NewTasmotaModule(tasmota.Config{Broker: "tcp://localhost:1883", Timeout: config.Duration(time.Second)})
+	module.SetMetricsChannel(testutil.NewCollectingSink(t).Chan())
+
+	client := testutil.NewMQTTClient()
+	client.FailConnect(errors.New("connection refused"))
+	module.SetClientFactory(func(opts *mqtt.ClientOptions) tasmota.MQTTClient {
+		return client.New(opts)
+	})
+
+	if err := module.Run(context.Background()); err == nil {
+		t.Error("Expected error for failed connect")
+	}
+}
+
+// subscribed reports whether the module tracks a subscription of the topic.
+func subscribed(module *tasmota.TasmotaModule, topic string) bool {
+	module.SubscriptionMux.RLock()
+	defer module.SubscriptionMux.RUnlock()
+	return module.SubscribedTopics[topic]
+}
@@ -0,0 +1,143 @@
+package tasmota
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// cachedEnergyTotals are the energy totals last fetched from a multi-channel device.
+type cachedEnergyTotals struct {
+	ip     string               // Address the totals are fetched from
+	totals *EnergyTotalResponse // Nil until a fetch succeeded
+}
+
+// EnergyTotalCache keeps the energy totals of the known multi-channel devices,
+// so sensor messages are processed without an HTTP request to the device.
+// The totals are refreshed for all devices at once by a background job.
+type EnergyTotalCache struct {
+	mu      sync.Mutex
+	devices map[string]*cachedEnergyTotals // Keyed by device topic
+}
+
+// NewEnergyTotalCache creates an empty energy total cache.
+func NewEnergyTotalCache() *EnergyTotalCache {
+	return &EnergyTotalCache{devices: make(map[string]*cachedEnergyTotals)}
+}
+
+// get returns the cached totals of a device and whether the device is known.
+// The address of a known device is updated, e.g. after a DHCP change.
+func (c *EnergyTotalCache) get(device *DeviceInfo) (*EnergyTotalResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, exists := c.devices[device.T]
+	if !exists {
+		return nil, false
+	}
+	cached.ip = device.IP
+	return cached.totals, true
+}
+
+// set stores the totals of a device; nil totals register the device only.
+func (c *EnergyTotalCache) set(topic, ip string, totals *EnergyTotalResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, exists := c.devices[topic]
+	if !exists {
+		cached = &cachedEnergyTotals{}
+		c.devices[topic] = cached
+	}
+	cached.ip = ip
+	if totals != nil {
+		cached.totals = totals
+	}
+}
+
+// Remove forgets the totals of a device, e.g. when it expired.
+func (c *EnergyTotalCache) Remove(topic string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.devices, topic)
+}
+
+// addresses returns the addresses of the known devices by topic.
+func (c *EnergyTotalCache) addresses() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	addresses := make(map[string]string, len(c.devices))
+	for topic, cached := range c.devices {
+		addresses[topic] = cached.ip
+	}
+	return addresses
+}
+
+// forgetDevice removes the cached energy totals and tags of a device.
+func (sp *SensorProcessor) forgetDevice(topic string) {
+	if sp == nil {
+		return
+	}
+	sp.tags.Remove(topic)
+	if sp.energyTotals != nil {
+		sp.energyTotals.Remove(topic)
+	}
+}
+
+// energyTotalsOf returns the energy totals of a multi-channel device. Without
+// a cache they are fetched with every sensor message; otherwise the cached
+// totals are used and only the first message of a device fetches them.
+func (sp *SensorProcessor) energyTotalsOf(device *DeviceInfo) (*EnergyTotalResponse, error) {
+	if sp.energyTotals == nil {
+		return sp.fetchEnergyTotals(context.Background(), device.IP)
+	}
+	if totals, known := sp.energyTotals.get(device); known {
+		return totals, nil
+	}
+
+	totals, err := sp.fetchEnergyTotals(context.Background(), device.IP)
+	// Register the device even if the fetch failed, so the refresh retries it
+	sp.energyTotals.set(device.T, device.IP, totals)
+	return totals, err
+}
+
+// refreshEnergyTotals fetches the energy totals of all known multi-channel
+// devices into the cache. Requests to each device are spaced out by the
+// device rate limit of the HTTP client.
+func (sp *SensorProcessor) refreshEnergyTotals(ctx context.Context) {
+	if sp.energyTotals == nil {
+		return
+	}
+	for topic, ip := range sp.energyTotals.addresses() {
+		if ctx.Err() != nil {
+			return
+		}
+		totals, err := sp.fetchEnergyTotals(ctx, ip)
+		if err != nil {
+			utils.Warnf("Failed to refresh energy totals for device %s: %v", topic, err)
+			continue
+		}
+		sp.energyTotals.set(topic, ip, totals)
+	}
+}
+
+// runEnergyTotalRefresh refreshes the cached energy totals every interval
+// until ctx is cancelled.
+func (sp *SensorProcessor) runEnergyTotalRefresh(ctx context.Context, interval time.Duration) {
+	if sp.energyTotals == nil || interval <= 0 {
+		return
+	}
+	utils.WithPanicRecoveryAndContinue("Energy total refresh", "devices", func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sp.refreshEnergyTotals(ctx)
+			}
+		}
+	})
+}
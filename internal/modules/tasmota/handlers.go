@@ -1,17 +1,26 @@
 package tasmota
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"sync"
+	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
 )
 
 // handleDiscoveryMessage processes incoming device discovery messages.
 func (tm *TasmotaModule) handleDiscoveryMessage(client mqtt.Client, msg mqtt.Message) {
 	utils.WithPanicRecoveryAndContinue("Discovery message handler", "unknown", func() {
+		// Retained configs are re-delivered on every reconnect; skip them if nothing changed
+		if tm.deviceMgr.ConfigUnchanged(msg.Topic(), msg.Payload()) {
+			utils.Debugf("Skipping unchanged discovery config on %s", msg.Topic())
+			return
+		}
+
 		var device DeviceInfo
 		if err := json.Unmarshal(msg.Payload(), &device); err != nil {
 			utils.Errorf("Failed to parse device discovery message: %v", err)
@@ -19,7 +28,21 @@ func (tm *TasmotaModule) handleDiscoveryMessage(client mqtt.Client, msg mqtt.Mes
 		}
 
 		// Store device info
-		tm.deviceMgr.StoreDevice(&device)
+		if tm.deviceMgr.StoreDevice(&device) {
+			tm.sendDeviceStatus(&device, true)
+		}
+		tm.inventory.Report(device.T, utils.DeviceMetadata{Model: device.MD, IP: device.IP, Firmware: device.SW})
+
+		// A device whose topic changed is moved to the new sensor topic
+		previousTopic := tm.deviceMgr.SetConfig(msg.Topic(), msg.Payload(), device.T)
+		if previousTopic != "" && previousTopic != device.T {
+			utils.Infof("Tasmota device %s changed topic from %s to %s", device.DN, previousTopic, device.T)
+			tm.deviceMgr.RemoveDevice(previousTopic)
+			tm.inventory.Remove(previousTopic)
+			tm.cadence.Remove(previousTopic)
+			tm.processor.forgetDevice(previousTopic)
+			tm.unsubscribeFromSensorData(previousTopic)
+		}
 
 		utils.Infof("Discovered Tasmota device: %s (%s) at %s", device.DN, device.T, device.IP)
 
@@ -42,7 +65,7 @@ func (tm *TasmotaModule) subscribeToSensorData(deviceTopic string) {
 	tm.SubscribedTopics[sensorTopic] = true
 	tm.SubscriptionMux.Unlock()
 
-	token := tm.client.Subscribe(sensorTopic, 1, tm.createSensorHandler(deviceTopic))
+	token := tm.client.Subscribe(sensorTopic, byte(tm.config.QoS), tm.limit(tm.createSensorHandler(deviceTopic)))
 
 	// Handle subscription result asynchronously to avoid blocking the message handler
 	go func() {
@@ -65,6 +88,15 @@ func (tm *TasmotaModule) createSensorHandler(deviceTopic string) mqtt.MessageHan
 	}
 }
 
+// limit wraps a message handler so that at most max_in_flight messages are processed concurrently.
+func (tm *TasmotaModule) limit(handler mqtt.MessageHandler) mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
+		tm.inFlight.Do(func() {
+			handler(client, msg)
+		})
+	}
+}
+
 // handleSensorMessage processes incoming sensor data messages.
 func (tm *TasmotaModule) handleSensorMessage(deviceTopic string, msg mqtt.Message) {
 	utils.WithPanicRecoveryAndContinue("Sensor message handler", deviceTopic, func() {
@@ -75,6 +107,7 @@ func (tm *TasmotaModule) handleSensorMessage(deviceTopic string, msg mqtt.Messag
 			utils.Warnf("Received sensor data for unknown device: %s", deviceTopic)
 			return
 		}
+		tm.deviceMgr.MarkSeen(deviceTopic)
 
 		// Parse sensor data (this is a generic JSON object)
 		var sensorData map[string]interface{}
@@ -85,27 +118,182 @@ func (tm *TasmotaModule) handleSensorMessage(deviceTopic string, msg mqtt.Messag
 
 		// Process sensor data and create metrics
 		tm.processor.ProcessSensorData(device, sensorData)
+		tm.trackCadence(device, sensorData)
 	})
 }
 
+// expireDevices removes devices that sent neither discovery nor sensor data within
+// the configured expiry, unsubscribes from their sensor topics and reports their removal.
+func (tm *TasmotaModule) expireDevices(now time.Time) {
+	utils.WithPanicRecoveryAndContinue("Device expiry", "devices", func() {
+		for _, device := range tm.deviceMgr.ExpireDevices(now.Add(-tm.config.DeviceExpiry.Duration())) {
+			utils.Infof("Tasmota device %s (%s) expired, not seen for %v", device.DN, device.T, tm.config.DeviceExpiry)
+			tm.unsubscribeFromSensorData(device.T)
+			tm.inventory.Remove(device.T)
+			tm.cadence.Remove(device.T)
+			tm.processor.forgetDevice(device.T)
+			tm.sendDeviceStatus(device, false)
+		}
+	})
+}
+
+// unsubscribeFromSensorData removes the sensor data subscription of a device.
+func (tm *TasmotaModule) unsubscribeFromSensorData(deviceTopic string) {
+	sensorTopic := fmt.Sprintf("tele/%s/SENSOR", deviceTopic)
+
+	tm.SubscriptionMux.Lock()
+	subscribed := tm.SubscribedTopics[sensorTopic]
+	delete(tm.SubscribedTopics, sensorTopic)
+	tm.SubscriptionMux.Unlock()
+
+	if !subscribed || tm.client == nil {
+		return
+	}
+
+	token := tm.client.Unsubscribe(sensorTopic)
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			utils.Errorf("Failed to unsubscribe from sensor topic %s: %v", sensorTopic, token.Error())
+		} else {
+			utils.Debugf("Unsubscribed from sensor topic: %s", sensorTopic)
+		}
+	}()
+}
+
+// sendDeviceStatus sends a device_status metric when a device appears or disappears.
+func (tm *TasmotaModule) sendDeviceStatus(device *DeviceInfo, present bool) {
+	if tm.metricsCh == nil {
+		return
+	}
+
+	value := 0
+	if present {
+		value = 1
+	}
+	metric := metrics.Metric{
+		Name: metricNameDeviceStatus,
+		Tags: map[string]string{
+			"vendor":   "tasmota",
+			"device":   device.T,
+			"friendly": tm.config.GetFriendlyName(device, ""),
+		},
+		Fields:    map[string]interface{}{"present": value},
+		Timestamp: tm.clock.Now(),
+	}
+
+	select {
+	case tm.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping device status metric for %s", device.T)
+	}
+}
+
+// discoveryConfig is the last discovery config received on a discovery topic.
+type discoveryConfig struct {
+	hash        [sha256.Size]byte
+	deviceTopic string
+}
+
 // DeviceManager handles device storage and retrieval.
 type DeviceManager struct {
 	devices    map[string]*DeviceInfo
+	lastSeen   map[string]time.Time
+	configs    map[string]discoveryConfig // keyed by discovery topic
 	devicesMux sync.RWMutex
+	clock      utils.Clock
 }
 
 // NewDeviceManager creates a new device manager.
 func NewDeviceManager() *DeviceManager {
 	return &DeviceManager{
-		devices: make(map[string]*DeviceInfo),
+		devices:  make(map[string]*DeviceInfo),
+		lastSeen: make(map[string]time.Time),
+		configs:  make(map[string]discoveryConfig),
+		clock:    utils.SystemClock,
+	}
+}
+
+// ConfigUnchanged reports whether the discovery config on a discovery topic equals the
+// last one received and its device is still known. An unchanged config marks the device as seen.
+func (dm *DeviceManager) ConfigUnchanged(discoveryTopic string, payload []byte) bool {
+	dm.devicesMux.Lock()
+	defer dm.devicesMux.Unlock()
+
+	config, exists := dm.configs[discoveryTopic]
+	if !exists || config.hash != sha256.Sum256(payload) {
+		return false
 	}
+	if _, known := dm.devices[config.deviceTopic]; !known {
+		return false
+	}
+	dm.lastSeen[config.deviceTopic] = dm.clock.Now()
+	return true
+}
+
+// SetConfig records the discovery config received on a discovery topic and returns
+// the device topic of the previous config, or "" if there was none.
+func (dm *DeviceManager) SetConfig(discoveryTopic string, payload []byte, deviceTopic string) string {
+	dm.devicesMux.Lock()
+	defer dm.devicesMux.Unlock()
+
+	previous := dm.configs[discoveryTopic].deviceTopic
+	dm.configs[discoveryTopic] = discoveryConfig{
+		hash:        sha256.Sum256(payload),
+		deviceTopic: deviceTopic,
+	}
+	return previous
+}
+
+// RemoveDevice removes a device by topic.
+func (dm *DeviceManager) RemoveDevice(topic string) {
+	dm.devicesMux.Lock()
+	defer dm.devicesMux.Unlock()
+	delete(dm.devices, topic)
+	delete(dm.lastSeen, topic)
 }
 
-// StoreDevice stores device information.
-func (dm *DeviceManager) StoreDevice(device *DeviceInfo) {
+// StoreDevice stores device information and marks the device as seen.
+// It reports whether the device was not known before.
+func (dm *DeviceManager) StoreDevice(device *DeviceInfo) bool {
 	dm.devicesMux.Lock()
 	defer dm.devicesMux.Unlock()
+	_, known := dm.devices[device.T]
 	dm.devices[device.T] = device
+	dm.lastSeen[device.T] = dm.clock.Now()
+	return !known
+}
+
+// MarkSeen records that a device has just sent data.
+func (dm *DeviceManager) MarkSeen(topic string) {
+	dm.devicesMux.Lock()
+	defer dm.devicesMux.Unlock()
+	if _, exists := dm.devices[topic]; exists {
+		dm.lastSeen[topic] = dm.clock.Now()
+	}
+}
+
+// LastSeen returns when a device last sent discovery or sensor data.
+func (dm *DeviceManager) LastSeen(topic string) (time.Time, bool) {
+	dm.devicesMux.RLock()
+	defer dm.devicesMux.RUnlock()
+	seen, exists := dm.lastSeen[topic]
+	return seen, exists
+}
+
+// ExpireDevices removes all devices not seen since the given time and returns them.
+func (dm *DeviceManager) ExpireDevices(before time.Time) []*DeviceInfo {
+	dm.devicesMux.Lock()
+	defer dm.devicesMux.Unlock()
+
+	var expired []*DeviceInfo
+	for topic, seen := range dm.lastSeen {
+		if seen.Before(before) {
+			expired = append(expired, dm.devices[topic])
+			delete(dm.devices, topic)
+			delete(dm.lastSeen, topic)
+		}
+	}
+	return expired
 }
 
 // GetDevice retrieves device information by topic.
@@ -1,15 +1,18 @@
 package tasmota
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/janhuddel/metrics-agent/internal/metrics"
 	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
 )
 
 // Constants for field processing and conversions
@@ -28,7 +31,8 @@ const (
 	sensorTypeMT175  = "MT175"
 
 	// Metric names
-	metricNameElectricity = "electricity"
+	metricNameElectricity  = "electricity"
+	metricNameDeviceStatus = "device_status"
 
 	// Conversion factors
 	currentToMilliAmps = 1000.0 // Convert A to mAh
@@ -49,11 +53,68 @@ type EnergyTotalResponse struct {
 }
 
 // FieldProcessor handles field extraction and conversion operations
-type FieldProcessor struct{}
+type FieldProcessor struct {
+	lenientNumbers bool // Parse numbers sent as strings
+}
+
+// NewFieldProcessor creates a new field processor. With lenientNumbers, numbers
+// sent as strings are parsed, see parseNumber.
+func NewFieldProcessor(lenientNumbers bool) *FieldProcessor {
+	return &FieldProcessor{lenientNumbers: lenientNumbers}
+}
+
+// normalizeNumbers replaces numbers sent as strings in sensor data, also within
+// arrays, by their float64 values. Other strings are kept. It does nothing
+// unless lenient number parsing is enabled.
+func (fp *FieldProcessor) normalizeNumbers(data map[string]any) {
+	if !fp.lenientNumbers {
+		return
+	}
+	for key, value := range data {
+		switch v := value.(type) {
+		case string:
+			if number, ok := parseNumber(v); ok {
+				data[key] = number
+			}
+		case []any:
+			for i, item := range v {
+				if s, isString := item.(string); isString {
+					if number, ok := parseNumber(s); ok {
+						v[i] = number
+					}
+				}
+			}
+		}
+	}
+}
 
-// NewFieldProcessor creates a new field processor
-func NewFieldProcessor() *FieldProcessor {
-	return &FieldProcessor{}
+// parseNumber parses a number sent as string, with a dot or a comma as decimal
+// separator. If both occur, the last one is the decimal separator and the other
+// one separates thousands ("1.234,5" and "1,234.5" are 1234.5). A separator
+// occurring more than once separates thousands ("1,234,567").
+func parseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	comma, dot := strings.LastIndex(s, ","), strings.LastIndex(s, ".")
+	switch {
+	case comma >= 0 && dot >= 0:
+		if comma > dot {
+			s = strings.ReplaceAll(s, ".", "")
+			s = strings.Replace(s, ",", ".", 1)
+		} else {
+			s = strings.ReplaceAll(s, ",", "")
+		}
+	case strings.Count(s, ",") > 1:
+		s = strings.ReplaceAll(s, ",", "")
+	case strings.Count(s, ".") > 1:
+		s = strings.ReplaceAll(s, ".", "")
+	case comma >= 0:
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false
+	}
+	return value, true
 }
 
 // convertCurrentToMilliAmps converts current from Amperes to milliAmperes
@@ -106,13 +167,30 @@ func (fp *FieldProcessor) addEnergyFields(fields map[string]any, data map[string
 	}
 }
 
-// createBaseTags creates base tags for a device with optional suffix
-func (sp *SensorProcessor) createBaseTags(device *DeviceInfo, suffix string) map[string]string {
+// createBaseTags creates the base tags of a device, or of a channel of a
+// multi-channel device if channel >= 0
+func (sp *SensorProcessor) createBaseTags(device *DeviceInfo, channel int) map[string]string {
+	resolved := sp.tags.get(device, channel, sp.resolveTags)
 	return map[string]string{
 		"vendor":   "tasmota",
-		"device":   device.T + suffix,
-		"friendly": sp.config.GetFriendlyName(device, suffix),
+		"device":   resolved.device,
+		"friendly": resolved.friendly,
+	}
+}
+
+// resolveTags resolves the device ID and friendly name of a device or channel.
+// Channels are suffixed with their index, or with their configured name,
+// which then also serves as friendly name.
+func (sp *SensorProcessor) resolveTags(device *DeviceInfo, channel int) deviceTags {
+	if channel < 0 {
+		return deviceTags{device: device.T, friendly: sp.config.GetFriendlyName(device, "")}
+	}
+	if name := sp.config.ChannelName(device.T, channel); name != "" {
+		id := device.T + "." + name
+		return deviceTags{device: id, friendly: sp.config.BaseConfig.GetFriendlyName(id, name, name)}
 	}
+	suffix := "." + strconv.Itoa(channel)
+	return deviceTags{device: device.T + suffix, friendly: sp.config.GetFriendlyName(device, suffix)}
 }
 
 // SensorProcessor handles sensor data processing and metric creation.
@@ -121,27 +199,43 @@ type SensorProcessor struct {
 	config         *Config
 	fieldProcessor *FieldProcessor
 	httpClient     *http.Client
+	energyTotals   *EnergyTotalCache // Nil to fetch energy totals with every sensor message
+	tags           *TagCache
+	clock          utils.Clock
 }
 
-// NewSensorProcessor creates a new sensor processor.
-func NewSensorProcessor(metricsCh chan<- metrics.Metric, config *Config) *SensorProcessor {
+// NewSensorProcessor creates a new sensor processor. The energy totals of
+// multi-channel devices are cached if energy_total_interval is set.
+func NewSensorProcessor(metricsCh chan<- metrics.Metric, cfg *Config) *SensorProcessor {
+	var energyTotals *EnergyTotalCache
+	if cfg.EnergyTotalInterval > 0 {
+		energyTotals = NewEnergyTotalCache()
+	}
 	return &SensorProcessor{
 		metricsCh:      metricsCh,
-		config:         config,
-		fieldProcessor: NewFieldProcessor(),
+		config:         cfg,
+		fieldProcessor: NewFieldProcessor(cfg.LenientNumbers),
 		httpClient: &http.Client{
-			Timeout: httpTimeout,
+			Timeout:   httpTimeout,
+			Transport: utils.DeviceTransport(cfg.InstanceName("tasmota"), nil),
 		},
+		energyTotals: energyTotals,
+		tags:         NewTagCache(),
+		clock:        utils.SystemClock,
 	}
 }
 
 // ProcessSensorData extracts metrics from sensor data.
 func (sp *SensorProcessor) ProcessSensorData(device *DeviceInfo, sensorData map[string]any) {
 	utils.WithPanicRecoveryAndContinue("Sensor processor", device.T, func() {
-		timestamp := time.Now()
+		timestamp := sp.clock.Now()
 
 		// Find and process the sensor types
 		for sensorType, data := range sensorData {
+			if sensorFields, ok := data.(map[string]any); ok {
+				sp.fieldProcessor.normalizeNumbers(sensorFields)
+			}
+
 			switch sensorType {
 			case sensorTypeEnergy:
 				if energyData, ok := data.(map[string]any); ok {
@@ -185,7 +279,7 @@ func (sp *SensorProcessor) processEnergySensor(device *DeviceInfo, sensorType st
 // processMT175Sensor processes the MT175 sensor type.
 func (sp *SensorProcessor) processMT175Sensor(device *DeviceInfo, sensorType string, mt175Data map[string]any, timestamp time.Time) {
 	utils.WithPanicRecoveryAndContinue("Sensor type processor", device.T, func() {
-		tags := sp.createBaseTags(device, "")
+		tags := sp.createBaseTags(device, -1)
 
 		powerValue, exists := mt175Data[fieldPower]
 		if !exists {
@@ -212,7 +306,7 @@ func (sp *SensorProcessor) processMT175Sensor(device *DeviceInfo, sensorType str
 // processSingleChannelEnergy processes energy data for single-channel devices.
 func (sp *SensorProcessor) processSingleChannelEnergy(device *DeviceInfo, data map[string]any, powerValue float64, timestamp time.Time) {
 	// Create base tags for this sensor
-	tags := sp.createBaseTags(device, "")
+	tags := sp.createBaseTags(device, -1)
 
 	fields := map[string]any{
 		"power": powerValue,
@@ -237,8 +331,8 @@ func (sp *SensorProcessor) processSingleChannelEnergy(device *DeviceInfo, data m
 
 // processMultiChannelEnergy processes energy data for multi-channel devices.
 func (sp *SensorProcessor) processMultiChannelEnergy(device *DeviceInfo, data map[string]any, powerData []any, timestamp time.Time) {
-	// Fetch energy totals via HTTP for multi-channel devices
-	energyTotals, err := sp.fetchEnergyTotals(device)
+	// Energy totals of multi-channel devices are only available via HTTP
+	energyTotals, err := sp.energyTotalsOf(device)
 	if err != nil {
 		utils.Warnf("Failed to fetch energy totals for device %s: %v", device.T, err)
 	}
@@ -255,10 +349,12 @@ func (sp *SensorProcessor) processMultiChannelEnergy(device *DeviceInfo, data ma
 
 // processMultiChannelElement processes a single channel element for multi-channel devices.
 func (sp *SensorProcessor) processMultiChannelElement(device *DeviceInfo, data map[string]any, powerFloat float64, index int, energyTotals *EnergyTotalResponse, timestamp time.Time) {
-	suffix := "." + fmt.Sprintf("%d", index)
+	if !sp.config.IncludesChannel(device.T, index) {
+		return
+	}
 
 	// Create base tags for this sensor
-	tags := sp.createBaseTags(device, suffix)
+	tags := sp.createBaseTags(device, index)
 
 	fields := map[string]any{
 		"power": powerFloat,
@@ -284,10 +380,11 @@ func (sp *SensorProcessor) processMultiChannelElement(device *DeviceInfo, data m
 // sendPowerMetric sends a single power metric to the metrics channel.
 func (sp *SensorProcessor) sendPowerMetric(device *DeviceInfo, tags map[string]string, fields map[string]any, timestamp time.Time) {
 	metric := metrics.Metric{
-		Name:      metricNameElectricity,
-		Tags:      tags,
-		Fields:    fields,
-		Timestamp: timestamp,
+		Name:       metricNameElectricity,
+		Tags:       tags,
+		Fields:     fields,
+		FieldKinds: metrics.ElectricityKinds,
+		Timestamp:  timestamp,
 	}
 
 	// Validate metric before sending to prevent serialization errors
@@ -306,10 +403,14 @@ func (sp *SensorProcessor) sendPowerMetric(device *DeviceInfo, tags map[string]s
 }
 
 // fetchEnergyTotals fetches energy totals from a multi-channel device via HTTP
-func (sp *SensorProcessor) fetchEnergyTotals(device *DeviceInfo) (*EnergyTotalResponse, error) {
-	url := fmt.Sprintf("http://%s/cm?cmnd=EnergyTotal", device.IP)
+func (sp *SensorProcessor) fetchEnergyTotals(ctx context.Context, ip string) (*EnergyTotalResponse, error) {
+	url := fmt.Sprintf("http://%s/cm?cmnd=EnergyTotal", ip)
 
-	resp, err := sp.httpClient.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := sp.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch energy totals: %w", err)
 	}
@@ -0,0 +1,85 @@
+package tasmota
+
+import (
+	"sync"
+)
+
+// maxTagCacheEntries bounds the tag cache. When it is full, it is cleared
+// instead of evicting single entries, as the tags are cheap to resolve again.
+const maxTagCacheEntries = 4096
+
+// deviceTags are the resolved device and friendly tags of a device or channel.
+type deviceTags struct {
+	device   string
+	friendly string
+}
+
+// tagCacheKey identifies a device or, for channel >= 0, a channel of a
+// multi-channel device. It avoids building the suffixed device ID per message.
+type tagCacheKey struct {
+	topic   string
+	channel int
+}
+
+// cachedTags are resolved tags together with the discovery names they were
+// resolved from.
+type cachedTags struct {
+	deviceTags
+	dn string
+	fn string
+}
+
+// TagCache keeps the resolved tags of the devices, so sensor messages don't
+// resolve friendly names and build device IDs from strings every time. An
+// entry is resolved again when the device announces other names. The cache
+// belongs to a sensor processor, which is created anew when the configuration
+// is reloaded, so changed friendly name overrides take effect.
+type TagCache struct {
+	mu      sync.Mutex
+	entries map[tagCacheKey]cachedTags
+}
+
+// NewTagCache creates an empty tag cache.
+func NewTagCache() *TagCache {
+	return &TagCache{entries: make(map[tagCacheKey]cachedTags)}
+}
+
+// get returns the tags of a device or channel, calling resolve on a miss.
+func (c *TagCache) get(device *DeviceInfo, channel int, resolve func(*DeviceInfo, int) deviceTags) deviceTags {
+	fn := ""
+	if len(device.FN) > 0 {
+		fn = device.FN[0]
+	}
+	key := tagCacheKey{topic: device.T, channel: channel}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, exists := c.entries[key]; exists && cached.dn == device.DN && cached.fn == fn {
+		return cached.deviceTags
+	}
+
+	tags := resolve(device, channel)
+	if len(c.entries) >= maxTagCacheEntries {
+		clear(c.entries)
+	}
+	c.entries[key] = cachedTags{deviceTags: tags, dn: device.DN, fn: fn}
+	return tags
+}
+
+// Remove forgets the tags of a device and its channels, e.g. when it expired.
+func (c *TagCache) Remove(topic string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if key.topic == topic {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Len returns the number of cached entries.
+func (c *TagCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
@@ -8,45 +8,83 @@ import (
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/janhuddel/metrics-agent/internal/metrics"
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/connection"
+	mqttselftest "github.com/janhuddel/metrics-agent/internal/selftest/mqtt"
 	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
 )
 
+// deviceExpiryCheckInterval is how often devices are checked for expiry
+const deviceExpiryCheckInterval = time.Minute
+
 // TasmotaModule handles MQTT connections and device discovery.
 type TasmotaModule struct {
 	config           Config
-	client           mqtt.Client
+	client           MQTTClient
+	newClient        ClientFactory // Creates the MQTT client, replaced by tests
 	deviceMgr        *DeviceManager
 	processor        *SensorProcessor
 	metricsCh        chan<- metrics.Metric
 	SubscribedTopics map[string]bool // Public for testing
 	SubscriptionMux  sync.RWMutex    // Public for testing
+	inFlight         utils.Semaphore // Limits concurrently processed messages
+	cadence          *CadenceTracker // Detects missed sensor messages
+	clock            utils.Clock
+	inventory        *utils.ModuleInventory // Receives the metadata of discovered devices
 }
 
 // NewTasmotaModule creates a new Tasmota module instance.
-func NewTasmotaModule(config Config) *TasmotaModule {
+func NewTasmotaModule(cfg Config) *TasmotaModule {
 	utils.Debugf("Creating new Tasmota module instance")
 	utils.Debugf("Loaded Tasmota config: Broker=%s, KeepAlive=%v, PingTimeout=%v, Timeout=%v",
-		config.Broker, config.KeepAlive, config.PingTimeout, config.Timeout)
+		cfg.Broker, cfg.KeepAlive, cfg.PingTimeout, cfg.Timeout)
 
 	utils.Debugf("Tasmota module created successfully")
 	return &TasmotaModule{
-		config:           config,
+		config:           cfg,
 		deviceMgr:        NewDeviceManager(),
 		SubscribedTopics: make(map[string]bool),
+		inFlight:         utils.NewSemaphore(cfg.MaxInFlight),
+		cadence:          NewCadenceTracker(cfg.TelePeriod.Duration()),
+		clock:            utils.SystemClock,
+		newClient:        newPahoClient,
 	}
 }
 
 // Run starts the Tasmota module and begins collecting metrics.
 func Run(ctx context.Context, ch chan<- metrics.Metric) error {
-	config := LoadConfig()
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
 	module := NewTasmotaModule(config)
 	module.metricsCh = ch
 	module.processor = NewSensorProcessor(ch, &config)
+	module.SetClock(utils.ClockFromContext(ctx))
+	module.inventory = utils.InventoryFromContext(ctx)
 
 	return module.run(ctx)
 }
 
+// Probe checks that the MQTT broker is reachable.
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	return utils.ProbeURL(ctx, cfg.Broker, cfg.Timeout.Duration())
+}
+
+// SelfTest checks that the MQTT broker accepts the configured credentials
+func SelfTest(ctx context.Context) []utils.Check {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return []utils.Check{{Name: "configuration", Err: err}}
+	}
+	return []utils.Check{mqttselftest.CheckLogin(ctx, cfg.Broker, cfg.Username, cfg.Password, cfg.Timeout.Duration())}
+}
+
 // run executes the main module loop.
 func (tm *TasmotaModule) run(ctx context.Context) error {
 	return utils.WithPanicRecoveryAndReturnError("Tasmota module", "main", func() error {
@@ -58,14 +96,30 @@ func (tm *TasmotaModule) run(ctx context.Context) error {
 
 		// Subscribe to discovery topic with context cancellation support
 		discoveryTopic := "tasmota/discovery/+/config"
-		if err := tm.subscribeWithContext(ctx, discoveryTopic, 1, tm.handleDiscoveryMessage); err != nil {
+		if err := tm.subscribeWithContext(ctx, discoveryTopic, byte(tm.config.QoS), tm.limit(tm.handleDiscoveryMessage)); err != nil {
 			return fmt.Errorf("failed to subscribe to discovery topic: %w", err)
 		}
 		utils.Debugf("Subscribed to discovery topic: %s", discoveryTopic)
 
-		// Wait for context cancellation
-		<-ctx.Done()
-		return ctx.Err()
+		// Keep the energy totals of multi-channel devices up to date
+		go tm.processor.runEnergyTotalRefresh(ctx, tm.config.EnergyTotalInterval.Duration())
+
+		if tm.config.DeviceExpiry <= 0 {
+			<-ctx.Done()
+			return nil
+		}
+
+		// Periodically remove devices that stopped reporting
+		ticker := time.NewTicker(min(tm.config.DeviceExpiry.Duration(), deviceExpiryCheckInterval))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case now := <-ticker.C:
+				tm.expireDevices(now)
+			}
+		}
 	})
 }
 
@@ -76,43 +130,17 @@ func (tm *TasmotaModule) connectWithContext(ctx context.Context) error {
 		clientID := tm.config.ClientID
 		if clientID == "" {
 			hostname, _ := os.Hostname()
-			clientID = hostname + "-tasmota"
+			clientID = hostname + "-" + tm.config.InstanceName("tasmota")
 		}
 
-		opts := mqtt.NewClientOptions()
-		opts.AddBroker(tm.config.Broker)
-		opts.SetClientID(clientID)
-		opts.SetUsername(tm.config.Username)
-		opts.SetPassword(tm.config.Password)
-		opts.SetConnectTimeout(tm.config.Timeout)
-		opts.SetAutoReconnect(true)
-		opts.SetResumeSubs(true)    // Resume subscriptions after reconnection
-		opts.SetCleanSession(false) // Use persistent session to maintain subscriptions
-		opts.SetKeepAlive(tm.config.KeepAlive)
-		opts.SetPingTimeout(tm.config.PingTimeout)
-		opts.SetMaxReconnectInterval(5 * time.Minute)  // Limit max reconnect interval
-		opts.SetConnectRetryInterval(10 * time.Second) // Retry connection every 10 seconds
-		opts.SetOrderMatters(false)                    // Allow out-of-order message processing
-		opts.SetProtocolVersion(4)                     // Use MQTT 3.1.1 protocol
-
-		// Set connection lost handler with panic recovery
-		opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
-			utils.WithPanicRecoveryAndContinue("MQTT connection lost handler", "broker", func() {
-				utils.Errorf("MQTT connection lost: %v", err)
-				// Note: AutoReconnect is enabled, so the client will automatically attempt to reconnect
-				// Subscriptions will be restored due to SetResumeSubs(true) and SetCleanSession(false)
-			})
-		})
-
-		// Set reconnect handler with panic recovery
-		opts.SetOnConnectHandler(func(client mqtt.Client) {
-			utils.WithPanicRecoveryAndContinue("MQTT reconnect handler", "broker", func() {
-				utils.Infof("Connected to MQTT broker: %s", tm.config.Broker)
-				// Note: Subscriptions will be automatically restored due to SetResumeSubs(true)
-			})
-		})
+		tracker := connection.NewTracker(tm.config.InstanceName("tasmota"), tm.config.Broker, tm.metricsCh)
+		if err := utils.CheckDestination(ctx, tm.config.Broker); err != nil {
+			utils.AuditConnect(tm.config.InstanceName("tasmota"), "mqtt", tm.config.Broker, 0, err)
+			tracker.SetConnected(false)
+			return err
+		}
 
-		tm.client = mqtt.NewClient(opts)
+		tm.client = tm.newClient(tm.clientOptions(clientID, tracker))
 
 		// Use context-aware connection with timeout
 		connChan := make(chan error, 1)
@@ -126,6 +154,8 @@ func (tm *TasmotaModule) connectWithContext(ctx context.Context) error {
 			return ctx.Err()
 		case err := <-connChan:
 			if err != nil {
+				utils.AuditConnect(tm.config.InstanceName("tasmota"), "mqtt", tm.config.Broker, 0, err)
+				tracker.SetConnected(false)
 				return err
 			}
 		}
@@ -141,44 +171,20 @@ func (tm *TasmotaModule) connect() error {
 		clientID := tm.config.ClientID
 		if clientID == "" {
 			hostname, _ := os.Hostname()
-			clientID = hostname + "-tasmota"
+			clientID = hostname + "-" + tm.config.InstanceName("tasmota")
 		}
 
-		opts := mqtt.NewClientOptions()
-		opts.AddBroker(tm.config.Broker)
-		opts.SetClientID(clientID)
-		opts.SetUsername(tm.config.Username)
-		opts.SetPassword(tm.config.Password)
-		opts.SetConnectTimeout(tm.config.Timeout)
-		opts.SetAutoReconnect(true)
-		opts.SetResumeSubs(true)    // Resume subscriptions after reconnection
-		opts.SetCleanSession(false) // Use persistent session to maintain subscriptions
-		opts.SetKeepAlive(tm.config.KeepAlive)
-		opts.SetPingTimeout(tm.config.PingTimeout)
-		opts.SetMaxReconnectInterval(5 * time.Minute)  // Limit max reconnect interval
-		opts.SetConnectRetryInterval(10 * time.Second) // Retry connection every 10 seconds
-		opts.SetOrderMatters(false)                    // Allow out-of-order message processing
-		opts.SetProtocolVersion(4)                     // Use MQTT 3.1.1 protocol
-
-		// Set connection lost handler with panic recovery
-		opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
-			utils.WithPanicRecoveryAndContinue("MQTT connection lost handler", "broker", func() {
-				utils.Errorf("MQTT connection lost: %v", err)
-				// Note: AutoReconnect is enabled, so the client will automatically attempt to reconnect
-				// Subscriptions will be restored due to SetResumeSubs(true) and SetCleanSession(false)
-			})
-		})
-
-		// Set reconnect handler with panic recovery
-		opts.SetOnConnectHandler(func(client mqtt.Client) {
-			utils.WithPanicRecoveryAndContinue("MQTT reconnect handler", "broker", func() {
-				utils.Infof("Connected to MQTT broker: %s", tm.config.Broker)
-				// Note: Subscriptions will be automatically restored due to SetResumeSubs(true)
-			})
-		})
+		tracker := connection.NewTracker(tm.config.InstanceName("tasmota"), tm.config.Broker, tm.metricsCh)
+		if err := utils.CheckDestination(context.Background(), tm.config.Broker); err != nil {
+			utils.AuditConnect(tm.config.InstanceName("tasmota"), "mqtt", tm.config.Broker, 0, err)
+			tracker.SetConnected(false)
+			return err
+		}
 
-		tm.client = mqtt.NewClient(opts)
+		tm.client = tm.newClient(tm.clientOptions(clientID, tracker))
 		if token := tm.client.Connect(); token.Wait() && token.Error() != nil {
+			utils.AuditConnect(tm.config.InstanceName("tasmota"), "mqtt", tm.config.Broker, 0, token.Error())
+			tracker.SetConnected(false)
 			return token.Error()
 		}
 
@@ -186,6 +192,48 @@ func (tm *TasmotaModule) connect() error {
 	})
 }
 
+// clientOptions returns the options of the MQTT client, with handlers
+// reporting the connection state to the tracker.
+func (tm *TasmotaModule) clientOptions(clientID string, tracker *connection.Tracker) *mqtt.ClientOptions {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(tm.config.Broker)
+	opts.SetClientID(clientID)
+	opts.SetUsername(tm.config.Username)
+	opts.SetPassword(tm.config.Password)
+	opts.SetConnectTimeout(tm.config.Timeout.Duration())
+	opts.SetAutoReconnect(true)
+	opts.SetResumeSubs(true) // Resume subscriptions after reconnection
+	opts.SetCleanSession(tm.config.CleanSession)
+	opts.SetKeepAlive(tm.config.KeepAlive.Duration())
+	opts.SetPingTimeout(tm.config.PingTimeout.Duration())
+	opts.SetMaxReconnectInterval(5 * time.Minute)  // Limit max reconnect interval
+	opts.SetConnectRetryInterval(10 * time.Second) // Retry connection every 10 seconds
+	opts.SetOrderMatters(false)                    // Allow out-of-order message processing
+	opts.SetProtocolVersion(4)                     // Use MQTT 3.1.1 protocol
+
+	// Set connection lost handler with panic recovery
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		utils.WithPanicRecoveryAndContinue("MQTT connection lost handler", "broker", func() {
+			utils.Errorf("MQTT connection lost: %v", err)
+			tracker.SetConnected(false)
+			// Note: AutoReconnect is enabled, so the client will automatically attempt to reconnect
+			// Subscriptions will be restored due to SetResumeSubs(true) and SetCleanSession(false)
+		})
+	})
+
+	// Set reconnect handler with panic recovery
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		utils.WithPanicRecoveryAndContinue("MQTT reconnect handler", "broker", func() {
+			utils.Infof("Connected to MQTT broker: %s", tm.config.Broker)
+			utils.AuditConnect(tm.config.InstanceName("tasmota"), "mqtt", tm.config.Broker, 0, nil)
+			tracker.SetConnected(true)
+			// Note: Subscriptions will be automatically restored due to SetResumeSubs(true)
+		})
+	})
+
+	return opts
+}
+
 // subscribeWithContext subscribes to an MQTT topic with context cancellation support.
 func (tm *TasmotaModule) subscribeWithContext(ctx context.Context, topic string, qos byte, callback mqtt.MessageHandler) error {
 	return utils.WithPanicRecoveryAndReturnError("MQTT subscribe", "broker", func() error {
@@ -216,6 +264,38 @@ func (tm *TasmotaModule) disconnect() {
 
 // Public methods for testing
 
+// Run is a public method for testing the main module loop, e.g. with a fake
+// MQTT client (see SetClientFactory).
+func (tm *TasmotaModule) Run(ctx context.Context) error {
+	return tm.run(ctx)
+}
+
+// SetClientFactory sets the factory the MQTT client is created with. It is
+// used for testing without a broker.
+func (tm *TasmotaModule) SetClientFactory(factory ClientFactory) {
+	tm.newClient = factory
+}
+
+// ExpireDevices is a public method for testing device expiry.
+func (tm *TasmotaModule) ExpireDevices(now time.Time) {
+	tm.expireDevices(now)
+}
+
+// DeviceManager returns the device manager for testing.
+func (tm *TasmotaModule) DeviceManager() *DeviceManager {
+	return tm.deviceMgr
+}
+
+// TrackCadence is a public method for testing missed message detection.
+func (tm *TasmotaModule) TrackCadence(device *DeviceInfo, sensorData map[string]interface{}) {
+	tm.trackCadence(device, sensorData)
+}
+
+// RefreshEnergyTotals is a public method for testing the refresh of cached energy totals.
+func (tm *TasmotaModule) RefreshEnergyTotals(ctx context.Context) {
+	tm.processor.refreshEnergyTotals(ctx)
+}
+
 // ProcessSensorData is a public method for testing sensor data processing.
 func (tm *TasmotaModule) ProcessSensorData(device *DeviceInfo, sensorData map[string]interface{}) {
 	tm.processor.ProcessSensorData(device, sensorData)
@@ -226,7 +306,18 @@ func (tm *TasmotaModule) SetMetricsChannel(ch chan<- metrics.Metric) {
 	tm.metricsCh = ch
 	if tm.processor == nil {
 		tm.processor = NewSensorProcessor(ch, &tm.config)
+		tm.processor.clock = tm.clock
 	} else {
 		tm.processor.SetMetricsChannel(ch)
 	}
 }
+
+// SetClock sets the clock the module, its device manager and its sensor
+// processor take timestamps from. It is used for testing.
+func (tm *TasmotaModule) SetClock(clock utils.Clock) {
+	tm.clock = clock
+	tm.deviceMgr.clock = clock
+	if tm.processor != nil {
+		tm.processor.clock = clock
+	}
+}
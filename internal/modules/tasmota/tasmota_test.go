@@ -1,13 +1,21 @@
 package tasmota_test
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/janhuddel/metrics-agent/internal/metrics"
+	"github.com/janhuddel/metrics-agent/internal/config"
 	"github.com/janhuddel/metrics-agent/internal/modules/tasmota"
+	"github.com/janhuddel/metrics-agent/internal/testutil"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
 )
 
 // TestDefaultConfig tests the default configuration creation.
@@ -57,13 +65,13 @@ func TestDeviceInfoParsing(t *testing.T) {
 
 // TestTasmotaModuleCreation tests creating a new Tasmota module.
 func TestTasmotaModuleCreation(t *testing.T) {
-	config := tasmota.Config{
+	cfg := tasmota.Config{
 		Broker:   "tcp://localhost:1883",
 		ClientID: "test-client",
-		Timeout:  5 * time.Second,
+		Timeout:  config.Duration(5 * time.Second),
 	}
 
-	module := tasmota.NewTasmotaModule(config)
+	module := tasmota.NewTasmotaModule(cfg)
 	if module == nil {
 		t.Fatal("Expected module to be created")
 	}
@@ -115,6 +123,93 @@ func TestDeviceManager(t *testing.T) {
 	}
 }
 
+// TestDeviceExpiry tests that stale devices are removed and reported.
+func TestDeviceExpiry(t *testing.T) {
+	ch := make(chan metrics.Metric, 10)
+	module := tasmota.NewTasmotaModule(tasmota.Config{DeviceExpiry: config.Duration(time.Hour)})
+	module.SetMetricsChannel(ch)
+	clock := testutil.NewClock(time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC))
+	module.SetClock(clock)
+
+	deviceMgr := module.DeviceManager()
+	if !deviceMgr.StoreDevice(&tasmota.DeviceInfo{T: "tasmota_OLD", DN: "old-plug"}) {
+		t.Error("Expected new device to be reported as new")
+	}
+	if deviceMgr.StoreDevice(&tasmota.DeviceInfo{T: "tasmota_OLD", DN: "old-plug"}) {
+		t.Error("Expected known device not to be reported as new")
+	}
+	deviceMgr.StoreDevice(&tasmota.DeviceInfo{T: "tasmota_NEW", DN: "new-plug"})
+
+	if _, exists := deviceMgr.LastSeen("tasmota_OLD"); !exists {
+		t.Fatal("Expected last seen time for stored device")
+	}
+
+	// Only the device that reported after the cutoff is kept
+	module.SubscribedTopics["tele/tasmota_OLD/SENSOR"] = true
+	clock.Advance(time.Minute)
+	cutoff := clock.Now()
+	clock.Advance(time.Minute)
+	deviceMgr.MarkSeen("tasmota_NEW")
+	module.ExpireDevices(cutoff.Add(time.Hour))
+
+	if _, exists := deviceMgr.GetDevice("tasmota_OLD"); exists {
+		t.Error("Expected stale device to be removed")
+	}
+	if module.SubscribedTopics["tele/tasmota_OLD/SENSOR"] {
+		t.Error("Expected subscription of stale device to be removed")
+	}
+	if _, exists := deviceMgr.GetDevice("tasmota_NEW"); !exists {
+		t.Error("Expected recently seen device to be kept")
+	}
+
+	if len(ch) != 1 {
+		t.Fatalf("Expected 1 device status metric, got %d", len(ch))
+	}
+	metric := <-ch
+	if metric.Name != "device_status" || metric.Tags["device"] != "tasmota_OLD" || metric.Fields["present"] != 0 {
+		t.Errorf("Unexpected device status metric: %+v", metric)
+	}
+	if !metric.Timestamp.Equal(clock.Now()) {
+		t.Errorf("Expected timestamp %v from the clock, got %v", clock.Now(), metric.Timestamp)
+	}
+}
+
+// TestDiscoveryConfigTracking tests that unchanged discovery configs are detected.
+func TestDiscoveryConfigTracking(t *testing.T) {
+	deviceMgr := tasmota.NewDeviceManager()
+	discoveryTopic := "tasmota/discovery/48551917E7AE/config"
+	payload := []byte(`{"t":"tasmota_17E7AE","dn":"plug"}`)
+
+	if deviceMgr.ConfigUnchanged(discoveryTopic, payload) {
+		t.Error("Expected first config to be new")
+	}
+	deviceMgr.StoreDevice(&tasmota.DeviceInfo{T: "tasmota_17E7AE", DN: "plug"})
+	if previous := deviceMgr.SetConfig(discoveryTopic, payload, "tasmota_17E7AE"); previous != "" {
+		t.Errorf("Expected no previous topic, got %q", previous)
+	}
+
+	// A retained re-delivery is skipped
+	if !deviceMgr.ConfigUnchanged(discoveryTopic, payload) {
+		t.Error("Expected re-delivered config to be unchanged")
+	}
+
+	// A changed config is processed and reports the previous topic
+	changed := []byte(`{"t":"tasmota_kitchen","dn":"plug"}`)
+	if deviceMgr.ConfigUnchanged(discoveryTopic, changed) {
+		t.Error("Expected changed config to be detected")
+	}
+	if previous := deviceMgr.SetConfig(discoveryTopic, changed, "tasmota_kitchen"); previous != "tasmota_17E7AE" {
+		t.Errorf("Expected previous topic tasmota_17E7AE, got %q", previous)
+	}
+
+	// An unchanged config of a removed device is processed again
+	deviceMgr.StoreDevice(&tasmota.DeviceInfo{T: "tasmota_kitchen", DN: "plug"})
+	deviceMgr.RemoveDevice("tasmota_kitchen")
+	if deviceMgr.ConfigUnchanged(discoveryTopic, changed) {
+		t.Error("Expected config of removed device to be processed again")
+	}
+}
+
 // TestSensorDataProcessing tests processing of sensor data.
 func TestSensorDataProcessing(t *testing.T) {
 	device := &tasmota.DeviceInfo{
@@ -133,39 +228,23 @@ func TestSensorDataProcessing(t *testing.T) {
 		},
 	}
 
-	// Create a test channel
-	ch := make(chan metrics.Metric, 10)
+	// Create a test sink
+	sink := testutil.NewCollectingSink(t)
 
 	// Create module and set metrics channel
-	config := tasmota.Config{
+	cfg := tasmota.Config{
 		Broker:   "tcp://localhost:1883",
 		ClientID: "test-client",
-		Timeout:  5 * time.Second,
+		Timeout:  config.Duration(5 * time.Second),
 	}
-	module := tasmota.NewTasmotaModule(config)
-	module.SetMetricsChannel(ch)
+	module := tasmota.NewTasmotaModule(cfg)
+	module.SetMetricsChannel(sink.Chan())
 
 	// Process sensor data
 	module.ProcessSensorData(device, sensorData)
 
-	// Collect metrics
-	var metrics []metrics.Metric
-	timeout := time.After(2 * time.Second)
-
-	for {
-		select {
-		case metric := <-ch:
-			metrics = append(metrics, metric)
-		case <-timeout:
-			goto done
-		}
-	}
-done:
-
 	// Verify we got exactly 1 metric for ENERGY sensor
-	if len(metrics) != 1 {
-		t.Errorf("Expected 1 metric for ENERGY sensor, got %d", len(metrics))
-	}
+	metrics := sink.ExpectCount(t, 1, 100*time.Millisecond)
 
 	// Verify metric structure
 	if len(metrics) > 0 {
@@ -204,35 +283,19 @@ func TestEnergySensorPowerArrayHandling(t *testing.T) {
 			},
 		}
 
-		ch := make(chan metrics.Metric, 10)
-		config := tasmota.Config{
+		sink := testutil.NewCollectingSink(t)
+		cfg := tasmota.Config{
 			Broker:   "tcp://localhost:1883",
 			ClientID: "test-client",
-			Timeout:  5 * time.Second,
+			Timeout:  config.Duration(5 * time.Second),
 		}
-		module := tasmota.NewTasmotaModule(config)
-		module.SetMetricsChannel(ch)
+		module := tasmota.NewTasmotaModule(cfg)
+		module.SetMetricsChannel(sink.Chan())
 
 		module.ProcessSensorData(device, sensorData)
 
-		// Collect metrics
-		var metrics []metrics.Metric
-		timeout := time.After(1 * time.Second)
-
-		for {
-			select {
-			case metric := <-ch:
-				metrics = append(metrics, metric)
-			case <-timeout:
-				goto done1
-			}
-		}
-	done1:
-
 		// Should have exactly 1 metric
-		if len(metrics) != 1 {
-			t.Errorf("Expected 1 metric for single power value, got %d", len(metrics))
-		}
+		metrics := sink.ExpectCount(t, 1, 100*time.Millisecond)
 
 		if len(metrics) > 0 {
 			metric := metrics[0]
@@ -256,35 +319,19 @@ func TestEnergySensorPowerArrayHandling(t *testing.T) {
 			},
 		}
 
-		ch := make(chan metrics.Metric, 10)
-		config := tasmota.Config{
+		sink := testutil.NewCollectingSink(t)
+		cfg := tasmota.Config{
 			Broker:   "tcp://localhost:1883",
 			ClientID: "test-client",
-			Timeout:  5 * time.Second,
+			Timeout:  config.Duration(5 * time.Second),
 		}
-		module := tasmota.NewTasmotaModule(config)
-		module.SetMetricsChannel(ch)
+		module := tasmota.NewTasmotaModule(cfg)
+		module.SetMetricsChannel(sink.Chan())
 
 		module.ProcessSensorData(device, sensorData)
 
-		// Collect metrics
-		var metrics []metrics.Metric
-		timeout := time.After(1 * time.Second)
-
-		for {
-			select {
-			case metric := <-ch:
-				metrics = append(metrics, metric)
-			case <-timeout:
-				goto done2
-			}
-		}
-	done2:
-
 		// Should have exactly 3 metrics (one for each array element)
-		if len(metrics) != 3 {
-			t.Errorf("Expected 3 metrics for power array, got %d", len(metrics))
-		}
+		metrics := sink.ExpectCount(t, 3, 100*time.Millisecond)
 
 		// Verify each metric
 		expectedValues := []float64{100.0, 200.5, 75.3}
@@ -311,47 +358,131 @@ func TestEnergySensorPowerArrayHandling(t *testing.T) {
 			},
 		}
 
-		ch := make(chan metrics.Metric, 10)
-		config := tasmota.Config{
+		sink := testutil.NewCollectingSink(t)
+		cfg := tasmota.Config{
 			Broker:   "tcp://localhost:1883",
 			ClientID: "test-client",
-			Timeout:  5 * time.Second,
+			Timeout:  config.Duration(5 * time.Second),
 		}
-		module := tasmota.NewTasmotaModule(config)
-		module.SetMetricsChannel(ch)
+		module := tasmota.NewTasmotaModule(cfg)
+		module.SetMetricsChannel(sink.Chan())
 
 		module.ProcessSensorData(device, sensorData)
 
-		// Collect metrics
-		var metrics []metrics.Metric
-		timeout := time.After(1 * time.Second)
+		// Should have no metrics when Power field is missing
+		sink.ExpectNone(t, 100*time.Millisecond)
+	})
+}
 
-		for {
-			select {
-			case metric := <-ch:
-				metrics = append(metrics, metric)
-			case <-timeout:
-				goto done3
-			}
-		}
-	done3:
+// TestChannelSelection tests that configured channels are filtered and named.
+func TestChannelSelection(t *testing.T) {
+	device := &tasmota.DeviceInfo{
+		T:  "tasmota_4CH",
+		DN: "power-strip",
+		IP: "127.0.0.1:1", // energy totals cannot be fetched
+	}
+	sensorData := map[string]interface{}{
+		"ENERGY": map[string]interface{}{
+			"Power": []interface{}{100.0, 200.0, 300.0},
+		},
+	}
 
-		// Should have no metrics when Power field is missing
-		if len(metrics) != 0 {
-			t.Errorf("Expected 0 metrics when Power field is missing, got %d", len(metrics))
-		}
+	ch := make(chan metrics.Metric, 10)
+	module := tasmota.NewTasmotaModule(tasmota.Config{
+		Channels: map[string]tasmota.ChannelConfig{
+			"tasmota_4CH": {
+				Include: []int{0, 2},
+				Names:   map[int]string{0: "Waschmaschine"},
+			},
+		},
 	})
+	module.SetMetricsChannel(ch)
+
+	module.ProcessSensorData(device, sensorData)
+
+	if len(ch) != 2 {
+		t.Fatalf("Expected 2 metrics for included channels, got %d", len(ch))
+	}
+	named := <-ch
+	if named.Tags["device"] != "tasmota_4CH.Waschmaschine" || named.Tags["friendly"] != "Waschmaschine" {
+		t.Errorf("Unexpected tags for named channel: %v", named.Tags)
+	}
+	unnamed := <-ch
+	if unnamed.Tags["device"] != "tasmota_4CH.2" || unnamed.Fields["power"] != 300.0 {
+		t.Errorf("Unexpected metric for unnamed channel: %+v", unnamed)
+	}
+}
+
+// TestEnergyTotalCache tests that the energy totals of multi-channel devices
+// are fetched once and then refreshed in the background.
+func TestEnergyTotalCache(t *testing.T) {
+	utils.SetDeviceRateLimit(0)
+	t.Cleanup(func() { utils.SetDeviceRateLimit(utils.DefaultDeviceRequestsPerSecond) })
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		fmt.Fprintf(w, `{"EnergyTotal":{"Today":[%d,2],"Total":[10,20]}}`, n)
+	}))
+	defer server.Close()
+
+	device := &tasmota.DeviceInfo{
+		T:  "tasmota_2CH",
+		DN: "power-strip",
+		IP: strings.TrimPrefix(server.URL, "http://"),
+	}
+	sensorData := map[string]interface{}{
+		"ENERGY": map[string]interface{}{
+			"Power": []interface{}{100.0, 200.0},
+		},
+	}
+
+	ch := make(chan metrics.Metric, 10)
+	module := tasmota.NewTasmotaModule(tasmota.Config{EnergyTotalInterval: config.Duration(time.Minute)})
+	module.SetMetricsChannel(ch)
+
+	todayOfFirstChannel := func() interface{} {
+		t.Helper()
+		if len(ch) != 2 {
+			t.Fatalf("Expected 2 metrics, got %d", len(ch))
+		}
+		first := <-ch
+		<-ch
+		return first.Fields["sum_power_today"]
+	}
+
+	// The first message fetches the totals, further messages use the cache
+	module.ProcessSensorData(device, sensorData)
+	if today := todayOfFirstChannel(); today != 1000.0 {
+		t.Errorf("Expected sum_power_today 1000, got %v", today)
+	}
+	module.ProcessSensorData(device, sensorData)
+	if today := todayOfFirstChannel(); today != 1000.0 {
+		t.Errorf("Expected cached sum_power_today 1000, got %v", today)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("Expected 1 request before the refresh, got %d", requests.Load())
+	}
+
+	module.RefreshEnergyTotals(context.Background())
+	module.ProcessSensorData(device, sensorData)
+	if today := todayOfFirstChannel(); today != 2000.0 {
+		t.Errorf("Expected refreshed sum_power_today 2000, got %v", today)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("Expected 2 requests after the refresh, got %d", requests.Load())
+	}
 }
 
 // TestSubscriptionTracking tests that duplicate subscriptions are prevented.
 func TestSubscriptionTracking(t *testing.T) {
-	config := tasmota.Config{
+	cfg := tasmota.Config{
 		Broker:   "tcp://localhost:1883",
 		ClientID: "test-client",
-		Timeout:  5 * time.Second,
+		Timeout:  config.Duration(5 * time.Second),
 	}
 
-	module := tasmota.NewTasmotaModule(config)
+	module := tasmota.NewTasmotaModule(cfg)
 
 	// Verify initial state
 	if module.SubscribedTopics == nil {
@@ -379,3 +510,182 @@ func TestSubscriptionTracking(t *testing.T) {
 		t.Error("Expected topic to be marked as subscribed")
 	}
 }
+
+// TestCadenceTracker tests the detection of missed sensor messages.
+func TestCadenceTracker(t *testing.T) {
+	start := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	t.Run("LearnedPeriod", func(t *testing.T) {
+		tracker := tasmota.NewCadenceTracker(0)
+		steps := []struct {
+			offset        time.Duration
+			missed, total int
+		}{
+			{0, 0, 0},
+			{5 * time.Minute, 0, 0},  // Learns the period
+			{10 * time.Minute, 0, 0}, // On time
+			{16 * time.Minute, 0, 0}, // Jitter
+			{30 * time.Minute, 2, 2}, // Two messages lost
+			{30 * time.Minute, 0, 2}, // Duplicate
+			{45 * time.Minute, 2, 4}, // Two more lost
+		}
+		for _, step := range steps {
+			missed, total := tracker.Observe("tasmota_A", start.Add(step.offset))
+			if missed != step.missed || total != step.total {
+				t.Errorf("At %v: expected %d missed, %d total, got %d, %d", step.offset, step.missed, step.total, missed, total)
+			}
+		}
+	})
+
+	t.Run("ConfiguredPeriod", func(t *testing.T) {
+		tracker := tasmota.NewCadenceTracker(time.Minute)
+		tracker.Observe("tasmota_A", start)
+		if missed, _ := tracker.Observe("tasmota_A", start.Add(4*time.Minute)); missed != 3 {
+			t.Errorf("Expected 3 missed messages, got %d", missed)
+		}
+
+		// A removed device starts over
+		tracker.Remove("tasmota_A")
+		if _, total := tracker.Observe("tasmota_A", start.Add(10*time.Minute)); total != 0 {
+			t.Errorf("Expected the missed messages of a removed device to be reset, got %d", total)
+		}
+	})
+}
+
+// TestMissedMessagesMetric tests that missed sensor messages are reported per device.
+func TestMissedMessagesMetric(t *testing.T) {
+	ch := make(chan metrics.Metric, 10)
+	module := tasmota.NewTasmotaModule(tasmota.Config{TelePeriod: config.Duration(5 * time.Minute)})
+	module.SetMetricsChannel(ch)
+	device := &tasmota.DeviceInfo{T: "tasmota_17E7AE", DN: "plug"}
+
+	for _, sent := range []string{"2026-10-15T12:00:00", "2026-10-15T12:05:01", "2026-10-15T12:20:00"} {
+		module.TrackCadence(device, map[string]interface{}{"Time": sent})
+	}
+
+	if len(ch) != 1 {
+		t.Fatalf("Expected 1 missed messages metric, got %d", len(ch))
+	}
+	metric := <-ch
+	if metric.Name != "device_status" || metric.Tags["device"] != device.T || metric.Kind != metrics.KindCumulative {
+		t.Errorf("Unexpected metric %+v", metric)
+	}
+	if metric.Fields["missed_messages"] != 2 {
+		t.Errorf("Expected missed_messages=2, got %v", metric.Fields["missed_messages"])
+	}
+}
+
+// TestLenientNumbers tests parsing of numbers sent as strings.
+func TestLenientNumbers(t *testing.T) {
+	device := &tasmota.DeviceInfo{T: "tasmota_SCRIPT", DN: "script"}
+	sensorData := func() map[string]interface{} {
+		return map[string]interface{}{
+			"Time": "2026-10-15T12:00:00",
+			"ENERGY": map[string]interface{}{
+				"Power":   "3,14",
+				"Voltage": " 230.5 ",
+				"Current": "0,5",
+				"Total":   "1.234,5",
+				"Today":   "n/a",
+			},
+		}
+	}
+
+	t.Run("Enabled", func(t *testing.T) {
+		sink := testutil.NewCollectingSink(t)
+		module := tasmota.NewTasmotaModule(tasmota.Config{LenientNumbers: true})
+		module.SetMetricsChannel(sink.Chan())
+
+		module.ProcessSensorData(device, sensorData())
+
+		metric := sink.ExpectCount(t, 1, 100*time.Millisecond)[0]
+		expected := map[string]interface{}{
+			"power":           3.14,
+			"voltage":         230.5,
+			"current":         500.0,
+			"sum_power_total": 1234.5 * 1000,
+			"sum_power_today": "n/a",
+		}
+		for field, want := range expected {
+			if metric.Fields[field] != want {
+				t.Errorf("Expected %s=%v, got %v (%T)", field, want, metric.Fields[field], metric.Fields[field])
+			}
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		sink := testutil.NewCollectingSink(t)
+		module := tasmota.NewTasmotaModule(tasmota.Config{})
+		module.SetMetricsChannel(sink.Chan())
+
+		module.ProcessSensorData(device, sensorData())
+
+		sink.ExpectCount(t, 0, 100*time.Millisecond)
+	})
+}
+
+// TestFriendlyNameChange tests that cached tags are resolved again when a
+// device announces another name.
+func TestFriendlyNameChange(t *testing.T) {
+	device := &tasmota.DeviceInfo{T: "tasmota_17E7AE", DN: "plug", FN: []string{"Geschirrspüler"}}
+	sensorData := map[string]interface{}{
+		"ENERGY": map[string]interface{}{"Power": 150.5},
+	}
+
+	ch := make(chan metrics.Metric, 10)
+	module := tasmota.NewTasmotaModule(tasmota.Config{})
+	module.SetMetricsChannel(ch)
+
+	for _, friendly := range []string{"Geschirrspüler", "Geschirrspüler", "Spülmaschine"} {
+		device.FN = []string{friendly}
+		module.ProcessSensorData(device, sensorData)
+		if len(ch) != 1 {
+			t.Fatalf("Expected 1 metric, got %d", len(ch))
+		}
+		if m := <-ch; m.Tags["friendly"] != friendly {
+			t.Errorf("Expected friendly tag '%s', got '%s'", friendly, m.Tags["friendly"])
+		}
+	}
+}
+
+// BenchmarkProcessSensorData measures the processing of a sensor message of a
+// multi-channel device, including the resolution of its tags.
+func BenchmarkProcessSensorData(b *testing.B) {
+	utils.SetDeviceRateLimit(0)
+	b.Cleanup(func() { utils.SetDeviceRateLimit(utils.DefaultDeviceRequestsPerSecond) })
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"EnergyTotal":{"Today":[1,2,3,4],"Total":[10,20,30,40]}}`)
+	}))
+	defer server.Close()
+
+	device := &tasmota.DeviceInfo{
+		T:  "tasmota_4CH",
+		DN: "power-strip",
+		FN: []string{"Steckdosenleiste"},
+		IP: strings.TrimPrefix(server.URL, "http://"),
+	}
+	sensorData := map[string]interface{}{
+		"ENERGY": map[string]interface{}{
+			"Power":   []interface{}{100.0, 200.0, 300.0, 400.0},
+			"Voltage": 230.0,
+		},
+	}
+
+	ch := make(chan metrics.Metric, 4)
+	module := tasmota.NewTasmotaModule(tasmota.Config{
+		Channels: map[string]tasmota.ChannelConfig{
+			"tasmota_4CH": {Names: map[int]string{0: "Waschmaschine"}},
+		},
+		EnergyTotalInterval: config.Duration(time.Hour),
+	})
+	module.SetMetricsChannel(ch)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		module.ProcessSensorData(device, sensorData)
+		for len(ch) > 0 {
+			<-ch
+		}
+	}
+}
@@ -3,6 +3,7 @@
 package tasmota
 
 import (
+	"slices"
 	"time"
 
 	"github.com/janhuddel/metrics-agent/internal/config"
@@ -13,14 +14,42 @@ type Config struct {
 	// Embed the base configuration for common functionality
 	config.BaseConfig
 
+	// MQTT subscription and session options (qos, clean_session, max_in_flight)
+	config.MQTTOptions
+
 	// Tasmota-specific settings
-	Broker      string        `json:"broker"`       // MQTT broker address (e.g., "tcp://localhost:1883")
-	Username    string        `json:"username"`     // MQTT username (optional)
-	Password    string        `json:"password"`     // MQTT password (optional)
-	ClientID    string        `json:"client_id"`    // MQTT client ID (optional, defaults to hostname)
-	Timeout     time.Duration `json:"timeout"`      // Connection timeout (defaults to 30s)
-	KeepAlive   time.Duration `json:"keep_alive"`   // Keep-alive interval (defaults to 60s)
-	PingTimeout time.Duration `json:"ping_timeout"` // Ping timeout (defaults to 10s)
+	Broker      string          `json:"broker"`       // MQTT broker address (e.g., "tcp://localhost:1883")
+	Username    string          `json:"username"`     // MQTT username (optional)
+	Password    string          `json:"password"`     // MQTT password (optional)
+	ClientID    string          `json:"client_id"`    // MQTT client ID (optional, defaults to hostname)
+	Timeout     config.Duration `json:"timeout"`      // Connection timeout (defaults to 30s)
+	KeepAlive   config.Duration `json:"keep_alive"`   // Keep-alive interval (defaults to 60s)
+	PingTimeout config.Duration `json:"ping_timeout"` // Ping timeout (defaults to 10s)
+
+	// DeviceExpiry removes devices that sent no discovery or sensor data for this long (0 disables expiry)
+	DeviceExpiry config.Duration `json:"device_expiry,omitempty"`
+
+	// TelePeriod is the interval devices send sensor data in, used to detect missed messages
+	// (0 learns it per device from the shortest interval between messages)
+	TelePeriod config.Duration `json:"tele_period,omitempty"`
+
+	// LenientNumbers accepts numbers sent as strings, with a comma or a dot as decimal separator
+	// (e.g. "3,14" from custom scripts or older firmware)
+	LenientNumbers bool `json:"lenient_numbers,omitempty"`
+
+	// EnergyTotalInterval is how often the energy totals of multi-channel devices are fetched
+	// in the background for all devices; sensor messages use the cached totals
+	// (0 fetches them with every sensor message instead)
+	EnergyTotalInterval config.Duration `json:"energy_total_interval,omitempty"`
+
+	// Channels selects and names the power channels of multi-channel devices, keyed by device topic
+	Channels map[string]ChannelConfig `json:"channels,omitempty"`
+}
+
+// ChannelConfig holds the channel settings of a multi-channel device.
+type ChannelConfig struct {
+	Include []int          `json:"include,omitempty"` // Channel indices to emit (defaults to all)
+	Names   map[int]string `json:"names,omitempty"`   // Channel index to name, replacing the ".<index>" device suffix
 }
 
 // DeviceInfo represents a discovered Tasmota device.
@@ -60,13 +89,19 @@ func DefaultConfig() Config {
 		BaseConfig: config.BaseConfig{
 			FriendlyNameOverrides: make(map[string]string),
 		},
+		MQTTOptions: config.MQTTOptions{
+			QoS:          1,
+			CleanSession: false, // Use persistent session to maintain subscriptions
+		},
 		Broker:      "tcp://localhost:1883",
 		Username:    "",
 		Password:    "",
 		ClientID:    "",
-		Timeout:     30 * time.Second,
-		KeepAlive:   60 * time.Second,
-		PingTimeout: 10 * time.Second,
+		Timeout:     config.Duration(30 * time.Second),
+		KeepAlive:   config.Duration(60 * time.Second),
+		PingTimeout: config.Duration(10 * time.Second),
+
+		EnergyTotalInterval: config.Duration(time.Minute),
 	}
 }
 
@@ -79,16 +114,36 @@ func (c *Config) GetFriendlyName(device *DeviceInfo, suffix string) string {
 	return c.BaseConfig.GetFriendlyName(device.T+suffix, deviceFriendlyName, device.DN)
 }
 
+// IncludesChannel reports whether a channel of a multi-channel device should be emitted.
+func (c *Config) IncludesChannel(deviceTopic string, index int) bool {
+	channels, exists := c.Channels[deviceTopic]
+	if !exists || len(channels.Include) == 0 {
+		return true
+	}
+	return slices.Contains(channels.Include, index)
+}
+
+// ChannelName returns the configured name of a channel, or "" if it has none.
+func (c *Config) ChannelName(deviceTopic string, index int) string {
+	return c.Channels[deviceTopic].Names[index]
+}
+
 // LoadConfig loads configuration using the centralized configuration system.
-func LoadConfig() Config {
+// If instance is set, the settings of that module instance are applied as well.
+// An invalid configuration is returned as a *config.ModuleError.
+func LoadConfig(instance string) (Config, error) {
 	loader := config.NewLoader("tasmota")
+	loader.SetInstance(instance)
 	defaultConfig := DefaultConfig()
 
 	loadedConfig, err := loader.LoadConfig(&defaultConfig)
 	if err != nil {
-		// If loading fails, return default config
-		return defaultConfig
+		return defaultConfig, err
 	}
 
-	return *loadedConfig.(*Config)
+	cfg := *loadedConfig.(*Config)
+	if err := cfg.MQTTOptions.Validate(); err != nil {
+		return cfg, &config.ModuleError{Module: "tasmota", Err: err}
+	}
+	return cfg, nil
 }
@@ -0,0 +1,705 @@
+// Package tibber provides a metric collection module for dynamic electricity prices
+// and live consumption data.
+// Prices are polled from the Tibber GraphQL API or the aWATTar market data API,
+// live consumption is received via the Tibber Pulse GraphQL subscription over websocket.
+package tibber
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/connection"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/internal/wsclient"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+	"github.com/janhuddel/metrics-agent/pkg/websocket"
+)
+
+const (
+	// Price sources
+	priceSourceTibber  = "tibber"
+	priceSourceAwattar = "awattar"
+
+	// Metric names
+	metricNamePrice       = "electricity_price"
+	metricNameElectricity = "electricity"
+
+	// graphql-transport-ws protocol settings
+	subscriptionProtocol = "graphql-transport-ws"
+	subscriptionID       = "1"
+
+	// userAgent is sent with every request as required by the Tibber API
+	userAgent = "metrics-agent"
+)
+
+// liveKinds are the field kinds of the live measurement metrics
+var liveKinds = map[string]metrics.Kind{
+	"sum_power_total":     metrics.KindCounter,
+	"sum_power_total_out": metrics.KindCounter,
+	"sum_power_today":     metrics.KindCumulative,
+	"sum_power_today_out": metrics.KindCumulative,
+	"cost_today":          metrics.KindCumulative,
+}
+
+// Config represents the configuration for the Tibber module
+type Config struct {
+	config.BaseConfig
+	Token             string          `json:"token"`                        // Tibber API access token
+	HomeID            string          `json:"home_id"`                      // Tibber home ID (required for Tibber prices and live data)
+	PriceSource       string          `json:"price_source,omitempty"`       // "tibber" (default) or "awattar"
+	APIURL            string          `json:"api_url,omitempty"`            // Tibber GraphQL endpoint
+	AwattarURL        string          `json:"awattar_url,omitempty"`        // aWATTar market data endpoint
+	PriceInterval     config.Duration `json:"price_interval,omitempty"`     // Price polling interval (defaults to 15m)
+	LiveMeasurement   bool            `json:"live_measurement,omitempty"`   // Enable Tibber Pulse live consumption
+	Timeout           config.Duration `json:"timeout,omitempty"`            // HTTP request timeout (defaults to 30s)
+	ReconnectInterval config.Duration `json:"reconnect_interval,omitempty"` // Websocket reconnect interval
+}
+
+// PriceInfo represents a single price entry from the Tibber API
+type PriceInfo struct {
+	Total    float64 `json:"total"`
+	Energy   float64 `json:"energy"`
+	Tax      float64 `json:"tax"`
+	StartsAt string  `json:"startsAt"`
+	Level    string  `json:"level"`
+	Currency string  `json:"currency"`
+}
+
+// PriceResponse represents the GraphQL response for the current price query
+type PriceResponse struct {
+	Data struct {
+		Viewer struct {
+			Home struct {
+				CurrentSubscription struct {
+					PriceInfo struct {
+						Current PriceInfo `json:"current"`
+					} `json:"priceInfo"`
+				} `json:"currentSubscription"`
+			} `json:"home"`
+		} `json:"viewer"`
+	} `json:"data"`
+	Errors []GraphQLError `json:"errors"`
+}
+
+// SubscriptionURLResponse represents the GraphQL response for the websocket subscription URL query
+type SubscriptionURLResponse struct {
+	Data struct {
+		Viewer struct {
+			WebsocketSubscriptionURL string `json:"websocketSubscriptionUrl"`
+		} `json:"viewer"`
+	} `json:"data"`
+	Errors []GraphQLError `json:"errors"`
+}
+
+// GraphQLError represents an error returned by a GraphQL endpoint
+type GraphQLError struct {
+	Message string `json:"message"`
+}
+
+// AwattarResponse represents the response from the aWATTar market data API
+type AwattarResponse struct {
+	Data []AwattarPrice `json:"data"`
+}
+
+// AwattarPrice represents a single market price slot from aWATTar
+type AwattarPrice struct {
+	StartTimestamp int64   `json:"start_timestamp"`
+	EndTimestamp   int64   `json:"end_timestamp"`
+	MarketPrice    float64 `json:"marketprice"`
+	Unit           string  `json:"unit"`
+}
+
+// LiveMeasurement represents a Tibber Pulse live measurement
+type LiveMeasurement struct {
+	Timestamp              string   `json:"timestamp"`
+	Power                  float64  `json:"power"`
+	PowerProduction        *float64 `json:"powerProduction"`
+	AccumulatedConsumption *float64 `json:"accumulatedConsumption"`
+	AccumulatedProduction  *float64 `json:"accumulatedProduction"`
+	AccumulatedCost        *float64 `json:"accumulatedCost"`
+	LastMeterConsumption   *float64 `json:"lastMeterConsumption"`
+	LastMeterProduction    *float64 `json:"lastMeterProduction"`
+	Currency               string   `json:"currency"`
+}
+
+// subscriptionMessage represents a graphql-transport-ws protocol message
+type subscriptionMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// TibberModule handles price polling and live consumption collection
+type TibberModule struct {
+	config      Config
+	httpClient  *http.Client
+	metricsCh   chan<- metrics.Metric
+	clock       utils.Clock
+	collections *utils.CollectionLog // last successful collection, nil if not remembered
+
+	// trackers holds one connection tracker per endpoint (price API, live websocket)
+	trackersMu sync.Mutex
+	trackers   map[string]*connection.Tracker
+
+	// live is the client of the live measurement subscription, set before it runs
+	live *websocket.Client
+}
+
+// Run starts the Tibber module and begins collecting metrics
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewTibberModule(config)
+	if err != nil {
+		return fmt.Errorf("failed to create Tibber module: %w", err)
+	}
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+	module.collections = utils.OpenCollectionLog(config.InstanceName("tibber"), module.clock)
+
+	return module.run(ctx)
+}
+
+// Probe validates the Tibber configuration and checks that the price API is reachable
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewTibberModule(cfg)
+	if err != nil {
+		return &config.ModuleError{Module: "tibber", Err: err}
+	}
+
+	apiURL := module.config.APIURL
+	if module.config.PriceSource == priceSourceAwattar {
+		apiURL = module.config.AwattarURL
+	}
+	return utils.ProbeURL(ctx, apiURL, module.config.Timeout.Duration())
+}
+
+// SelfTest checks that the Tibber API accepts the token and, with live
+// measurement enabled, that the subscription handshake succeeds
+func SelfTest(ctx context.Context) []utils.Check {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return []utils.Check{{Name: "configuration", Err: err}}
+	}
+	module, err := NewTibberModule(cfg)
+	if err != nil {
+		return []utils.Check{{Name: "configuration", Err: err}}
+	}
+	if module.config.PriceSource != priceSourceTibber && !module.config.LiveMeasurement {
+		return nil
+	}
+
+	check, wsURL := module.tokenCheck(ctx)
+	checks := []utils.Check{check}
+	if module.config.LiveMeasurement && wsURL != "" {
+		checks = append(checks, module.subscriptionCheck(ctx, wsURL))
+	}
+	return checks
+}
+
+// tokenCheck queries the websocket subscription URL to check that the API
+// accepts the token. It also returns the URL if the check passed.
+func (tm *TibberModule) tokenCheck(ctx context.Context) (utils.Check, string) {
+	body, err := json.Marshal(map[string]string{"query": `{ viewer { websocketSubscriptionUrl } }`})
+	if err != nil {
+		return utils.Check{Name: "API token", Err: err}, ""
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tm.config.APIURL, bytes.NewReader(body))
+	if err != nil {
+		return utils.Check{Name: "API token", Err: err}, ""
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+tm.config.Token)
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := tm.httpClient.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	check := utils.APICheck("API token", resp, err)
+	if !check.Passed() {
+		return check, ""
+	}
+
+	var response SubscriptionURLResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		check.Err = fmt.Errorf("failed to parse API response: %w", err)
+		return check, ""
+	}
+	if len(response.Errors) > 0 {
+		check.Err = fmt.Errorf("API returned error: %s", response.Errors[0].Message)
+		check.Hint = "Check the token at developer.tibber.com"
+		return check, ""
+	}
+	return check, response.Data.Viewer.WebsocketSubscriptionURL
+}
+
+// subscriptionCheck connects to the live measurement websocket and waits for
+// the server to acknowledge the connection with the token. It doesn't subscribe.
+func (tm *TibberModule) subscriptionCheck(ctx context.Context, wsURL string) utils.Check {
+	check := utils.Check{Name: "live measurement", Hint: "Check that a Tibber Pulse is connected to the home"}
+	client, err := wsclient.New(ctx, websocket.Config{
+		URL:               wsURL,
+		Protocol:          subscriptionProtocol,
+		ConnectionTimeout: tm.config.Timeout,
+		ReadTimeout:       tm.config.Timeout,
+		Headers:           map[string]string{"User-Agent": userAgent},
+	}, func([]byte) error { return nil })
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	client.SetConnectHandler(tm.initConnection)
+
+	check.Err = client.Probe(ctx, func(message []byte) (bool, error) {
+		var msg subscriptionMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			return false, fmt.Errorf("failed to parse websocket message: %w", err)
+		}
+		switch msg.Type {
+		case "connection_ack":
+			return true, nil
+		case "error", "connection_error":
+			return false, fmt.Errorf("subscription error: %s", string(msg.Payload))
+		}
+		return false, nil
+	})
+	if check.Err == nil {
+		check.Detail = "handshake with " + wsURL
+	}
+	return check
+}
+
+// NewTibberModule creates a new Tibber module instance
+func NewTibberModule(cfg Config) (*TibberModule, error) {
+	utils.Debugf("Creating new Tibber module instance")
+
+	switch cfg.PriceSource {
+	case "":
+		cfg.PriceSource = priceSourceTibber
+	case priceSourceTibber, priceSourceAwattar:
+	default:
+		return nil, fmt.Errorf("unsupported price_source: %s", cfg.PriceSource)
+	}
+
+	needsTibber := cfg.PriceSource == priceSourceTibber || cfg.LiveMeasurement
+	if needsTibber && cfg.Token == "" {
+		return nil, fmt.Errorf("token is required but not configured")
+	}
+	if needsTibber && cfg.HomeID == "" {
+		return nil, fmt.Errorf("home_id is required but not configured")
+	}
+
+	if cfg.PriceInterval <= 0 {
+		cfg.PriceInterval = config.Duration(15 * time.Minute)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = config.Duration(30 * time.Second)
+	}
+
+	utils.Debugf("Tibber module created successfully")
+	return &TibberModule{
+		clock:  utils.SystemClock,
+		config: cfg,
+		httpClient: &http.Client{
+			Timeout:   cfg.Timeout.Duration(),
+			Transport: utils.OutboundTransport(cfg.InstanceName("tibber"), nil),
+		},
+	}, nil
+}
+
+// DefaultConfig returns the default configuration of the Tibber module.
+func DefaultConfig() Config {
+	return Config{
+		PriceSource:       priceSourceTibber,
+		APIURL:            "https://api.tibber.com/v1-beta/gql",
+		AwattarURL:        "https://api.awattar.de/v1/marketdata",
+		PriceInterval:     config.Duration(15 * time.Minute),
+		Timeout:           config.Duration(30 * time.Second),
+		ReconnectInterval: config.Duration(5 * time.Second),
+	}
+}
+
+// LoadConfig loads the Tibber module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("tibber")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
+
+// run executes the main module loop
+func (tm *TibberModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Tibber module", "main", func() error {
+		liveErrCh := make(chan error, 1)
+		if tm.config.LiveMeasurement {
+			go func() {
+				liveErrCh <- tm.runLiveMeasurement(ctx)
+			}()
+		}
+
+		interval := tm.config.PriceInterval.Duration()
+		ticker := utils.NewScheduledTicker(ctx, interval)
+		defer ticker.Stop()
+
+		// Collect initial prices unless outside the collection schedule or skipped,
+		// but not before an interval has passed since the last collection
+		initial := tm.collections.Initial(ctx, interval)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case err := <-liveErrCh:
+				return fmt.Errorf("live measurement stopped: %w", err)
+			case <-initial:
+				if err := utils.CollectWithRetry(ctx, interval, tm.collectPrice); err != nil {
+					utils.Warnf("Failed to collect initial price: %v", err)
+				} else {
+					tm.collections.Record()
+				}
+			case <-ticker.C:
+				if err := utils.CollectWithRetry(ctx, interval, tm.collectPrice); err != nil {
+					utils.Warnf("Failed to collect price: %v", err)
+				} else {
+					tm.collections.Record()
+				}
+			}
+		}
+	})
+}
+
+// collectPrice fetches the current price from the configured source and sends a metric
+func (tm *TibberModule) collectPrice(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Tibber price collection", tm.config.PriceSource, func() error {
+		switch tm.config.PriceSource {
+		case priceSourceAwattar:
+			return tm.collectAwattarPrice(ctx)
+		default:
+			return tm.collectTibberPrice(ctx)
+		}
+	})
+}
+
+// collectTibberPrice queries the current price from the Tibber GraphQL API
+func (tm *TibberModule) collectTibberPrice(ctx context.Context) error {
+	query := `query($id: ID!) { viewer { home(id: $id) { currentSubscription { priceInfo { current { total energy tax startsAt level currency } } } } } }`
+
+	var response PriceResponse
+	if err := tm.graphQLQuery(ctx, query, map[string]interface{}{"id": tm.config.HomeID}, &response); err != nil {
+		return err
+	}
+	if len(response.Errors) > 0 {
+		return fmt.Errorf("API returned error: %s", response.Errors[0].Message)
+	}
+
+	current := response.Data.Viewer.Home.CurrentSubscription.PriceInfo.Current
+	timestamp := tm.clock.Now()
+	if parsed, err := time.Parse(time.RFC3339, current.StartsAt); err == nil {
+		timestamp = parsed
+	}
+
+	fields := map[string]interface{}{
+		"total":  current.Total,
+		"energy": current.Energy,
+		"tax":    current.Tax,
+	}
+	if current.Level != "" {
+		fields["level"] = current.Level
+	}
+
+	tags := tm.createBaseTags(priceSourceTibber, tm.config.HomeID, "Tibber")
+	if current.Currency != "" {
+		tags["currency"] = current.Currency
+	}
+
+	tm.sendMetric(metricNamePrice, tags, fields, nil, timestamp)
+	return nil
+}
+
+// collectAwattarPrice fetches the current market price from the aWATTar API
+func (tm *TibberModule) collectAwattarPrice(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tm.config.AwattarURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := tm.httpClient.Do(req)
+	tm.connection(tm.config.AwattarURL).SetPollResult(resp, err)
+	if err != nil {
+		return fmt.Errorf("API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var response AwattarResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("failed to parse API response: %w", err)
+	}
+
+	current, ok := currentAwattarPrice(response.Data, tm.clock.Now())
+	if !ok {
+		return fmt.Errorf("no market price available for the current time")
+	}
+
+	// aWATTar reports prices in Eur/MWh, convert to Eur/kWh to match Tibber
+	fields := map[string]interface{}{
+		"energy": current.MarketPrice / 1000,
+		"total":  current.MarketPrice / 1000,
+	}
+	tags := tm.createBaseTags(priceSourceAwattar, priceSourceAwattar, "aWATTar")
+	tags["currency"] = "EUR"
+
+	tm.sendMetric(metricNamePrice, tags, fields, nil, time.UnixMilli(current.StartTimestamp))
+	return nil
+}
+
+// currentAwattarPrice returns the market price slot covering the given time
+func currentAwattarPrice(prices []AwattarPrice, now time.Time) (AwattarPrice, bool) {
+	nowMillis := now.UnixMilli()
+	for _, price := range prices {
+		if price.StartTimestamp <= nowMillis && nowMillis < price.EndTimestamp {
+			return price, true
+		}
+	}
+	return AwattarPrice{}, false
+}
+
+// graphQLRequest is the body of a GraphQL request. Values like the home ID
+// are passed as variables instead of being interpolated into the query.
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+// graphQLQuery executes a GraphQL query against the Tibber API and decodes the response
+func (tm *TibberModule) graphQLQuery(ctx context.Context, query string, variables map[string]interface{}, target interface{}) error {
+	body, err := json.Marshal(graphQLRequest{Query: query, Variables: variables})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tm.config.APIURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+tm.config.Token)
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := tm.httpClient.Do(req)
+	tm.connection(tm.config.APIURL).SetPollResult(resp, err)
+	if err != nil {
+		return fmt.Errorf("API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return fmt.Errorf("failed to parse API response: %w", err)
+	}
+	return nil
+}
+
+// runLiveMeasurement subscribes to Tibber Pulse live measurements over websocket
+func (tm *TibberModule) runLiveMeasurement(ctx context.Context) error {
+	var response SubscriptionURLResponse
+	if err := tm.graphQLQuery(ctx, `{ viewer { websocketSubscriptionUrl } }`, nil, &response); err != nil {
+		return fmt.Errorf("failed to query websocket subscription URL: %w", err)
+	}
+	if len(response.Errors) > 0 {
+		return fmt.Errorf("API returned error: %s", response.Errors[0].Message)
+	}
+
+	wsURL := response.Data.Viewer.WebsocketSubscriptionURL
+	if wsURL == "" {
+		return fmt.Errorf("API returned no websocket subscription URL")
+	}
+
+	wsConfig := websocket.Config{
+		URL:               wsURL,
+		ReconnectInterval: tm.config.ReconnectInterval,
+		Protocol:          subscriptionProtocol,
+		Headers:           map[string]string{"User-Agent": userAgent},
+	}
+
+	wsClient, err := wsclient.New(ctx, wsConfig, tm.processMessage)
+	if err != nil {
+		return fmt.Errorf("failed to create websocket client: %w", err)
+	}
+	wsClient.SetConnectHandler(tm.initConnection)
+	wsClient.SetStateChangeHandler(tm.connection(wsURL).HandleWebSocketState)
+	tm.live = wsClient
+
+	return wsClient.Run(ctx)
+}
+
+// connection returns the tracker for an endpoint, creating it on first use
+func (tm *TibberModule) connection(endpoint string) *connection.Tracker {
+	tm.trackersMu.Lock()
+	defer tm.trackersMu.Unlock()
+
+	tracker, exists := tm.trackers[endpoint]
+	if !exists {
+		if tm.trackers == nil {
+			tm.trackers = make(map[string]*connection.Tracker)
+		}
+		tracker = connection.NewTracker(tm.config.InstanceName("tibber"), endpoint, tm.metricsCh)
+		tm.trackers[endpoint] = tracker
+	}
+	return tracker
+}
+
+// initConnection starts the graphql-transport-ws handshake. The subscription
+// is started once the server acknowledged the connection, see processMessage.
+func (tm *TibberModule) initConnection(c *websocket.Client) error {
+	payload, err := json.Marshal(map[string]string{"token": tm.config.Token})
+	if err != nil {
+		return err
+	}
+	return tm.sendSubscriptionMessage(c, subscriptionMessage{Type: "connection_init", Payload: payload})
+}
+
+// subscribe starts the live measurement subscription on an acknowledged connection
+func (tm *TibberModule) subscribe(c *websocket.Client) error {
+	if c == nil {
+		return fmt.Errorf("live measurement client is not running")
+	}
+	query := `subscription($homeId: ID!) { liveMeasurement(homeId: $homeId) { timestamp power powerProduction accumulatedConsumption accumulatedProduction accumulatedCost lastMeterConsumption lastMeterProduction currency } }`
+	subscribePayload, err := json.Marshal(graphQLRequest{Query: query, Variables: map[string]interface{}{"homeId": tm.config.HomeID}})
+	if err != nil {
+		return err
+	}
+	return tm.sendSubscriptionMessage(c, subscriptionMessage{ID: subscriptionID, Type: "subscribe", Payload: subscribePayload})
+}
+
+// sendSubscriptionMessage encodes and sends a graphql-transport-ws message
+func (tm *TibberModule) sendSubscriptionMessage(c *websocket.Client, message subscriptionMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return c.Send(data)
+}
+
+// processMessage handles a graphql-transport-ws message from the subscription
+func (tm *TibberModule) processMessage(message []byte) error {
+	var msg subscriptionMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return fmt.Errorf("failed to parse websocket message: %w", err)
+	}
+
+	switch msg.Type {
+	case "connection_ack":
+		utils.Infof("Tibber live measurement connection acknowledged, subscribing")
+		return tm.subscribe(tm.live)
+	case "next":
+		var payload struct {
+			Data struct {
+				LiveMeasurement LiveMeasurement `json:"liveMeasurement"`
+			} `json:"data"`
+			Errors []GraphQLError `json:"errors"`
+		}
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to parse live measurement: %w", err)
+		}
+		if len(payload.Errors) > 0 {
+			return fmt.Errorf("subscription returned error: %s", payload.Errors[0].Message)
+		}
+		tm.createLiveMetric(payload.Data.LiveMeasurement)
+		return nil
+	case "error":
+		return fmt.Errorf("subscription error: %s", string(msg.Payload))
+	case "complete":
+		// The connection stays open without subscription, so subscribe again
+		utils.Warnf("Tibber live measurement subscription completed by server, subscribing again")
+		return tm.subscribe(tm.live)
+	default:
+		utils.Debugf("Ignoring Tibber websocket message of type %s", msg.Type)
+		return nil
+	}
+}
+
+// createLiveMetric creates an electricity metric from a live measurement
+func (tm *TibberModule) createLiveMetric(live LiveMeasurement) {
+	timestamp := tm.clock.Now()
+	if parsed, err := time.Parse(time.RFC3339, live.Timestamp); err == nil {
+		timestamp = parsed
+	}
+
+	// Values the meter doesn't report are nil and marked as missing
+	fields := map[string]interface{}{
+		"power":               live.Power,
+		"power_production":    live.PowerProduction,
+		"sum_power_today":     live.AccumulatedConsumption,
+		"sum_power_today_out": live.AccumulatedProduction,
+		"sum_power_total":     live.LastMeterConsumption,
+		"sum_power_total_out": live.LastMeterProduction,
+		"cost_today":          live.AccumulatedCost,
+	}
+
+	tm.sendMetric(metricNameElectricity, tm.createBaseTags(priceSourceTibber, tm.config.HomeID, "Tibber Pulse"), fields, liveKinds, timestamp)
+}
+
+// createBaseTags creates the common tags for a metric
+func (tm *TibberModule) createBaseTags(vendor, deviceID, defaultName string) map[string]string {
+	return map[string]string{
+		"vendor":   vendor,
+		"device":   deviceID,
+		"friendly": tm.config.GetFriendlyName(deviceID, "", defaultName),
+	}
+}
+
+// sendMetric validates and sends a metric with the given field kinds to the metrics channel
+func (tm *TibberModule) sendMetric(name string, tags map[string]string, fields map[string]interface{}, kinds map[string]metrics.Kind, timestamp time.Time) {
+	metric := metrics.Metric{
+		Name:       name,
+		Tags:       tags,
+		Fields:     fields,
+		FieldKinds: kinds,
+		Timestamp:  timestamp,
+	}
+
+	if err := metric.Validate(); err != nil {
+		utils.Warnf("Invalid %s metric: %v", name, err)
+		return
+	}
+
+	select {
+	case tm.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping %s metric", name)
+	}
+}
@@ -0,0 +1,338 @@
+package tibber
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+	"golang.org/x/net/websocket"
+)
+
+func TestNewTibberModule(t *testing.T) {
+	tah := utils.NewTestAssertionHelper()
+
+	t.Run("TibberRequiresToken", func(t *testing.T) {
+		_, err := NewTibberModule(Config{HomeID: "home-1"})
+		tah.AssertError(t, err, "Expected error for missing token")
+	})
+
+	t.Run("TibberRequiresHomeID", func(t *testing.T) {
+		_, err := NewTibberModule(Config{Token: "token"})
+		tah.AssertError(t, err, "Expected error for missing home_id")
+	})
+
+	t.Run("AwattarWithoutToken", func(t *testing.T) {
+		module, err := NewTibberModule(Config{PriceSource: "awattar"})
+		tah.AssertNoError(t, err, "aWATTar prices should not require a token")
+		if module.config.PriceInterval.Duration() != 15*time.Minute {
+			t.Errorf("Expected default price interval 15m, got %v", module.config.PriceInterval)
+		}
+	})
+
+	t.Run("UnsupportedPriceSource", func(t *testing.T) {
+		_, err := NewTibberModule(Config{PriceSource: "unknown"})
+		tah.AssertError(t, err, "Expected error for unsupported price source")
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	config, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if config.PriceSource != "tibber" {
+		t.Errorf("Expected default price source 'tibber', got '%s'", config.PriceSource)
+	}
+	if config.PriceInterval.Duration() != 15*time.Minute {
+		t.Errorf("Expected default price interval 15m, got %v", config.PriceInterval)
+	}
+}
+
+func TestCollectTibberPrice(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"viewer":{"home":{"currentSubscription":{"priceInfo":{"current":{
+			"total":0.3012,"energy":0.1512,"tax":0.15,"startsAt":"2024-01-01T12:00:00.000+01:00","level":"NORMAL","currency":"EUR"}}}}}}}`)
+	}))
+	defer server.Close()
+
+	module, err := NewTibberModule(Config{Token: "secret", HomeID: "home-1", APIURL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 2)
+	module.metricsCh = ch
+
+	if err := module.collectPrice(context.Background()); err != nil {
+		t.Fatalf("collectPrice failed: %v", err)
+	}
+
+	if status := <-ch; status.Name != "connection_status" {
+		t.Errorf("Expected connection status metric first, got %+v", status)
+	}
+	m := <-ch
+	if m.Name != "electricity_price" {
+		t.Errorf("Expected metric name 'electricity_price', got '%s'", m.Name)
+	}
+	if m.Tags["vendor"] != "tibber" || m.Tags["device"] != "home-1" || m.Tags["currency"] != "EUR" {
+		t.Errorf("Unexpected tags: %v", m.Tags)
+	}
+	if m.Fields["total"] != 0.3012 {
+		t.Errorf("Expected total 0.3012, got %v", m.Fields["total"])
+	}
+	if m.Fields["level"] != "NORMAL" {
+		t.Errorf("Expected level NORMAL, got %v", m.Fields["level"])
+	}
+	if m.Timestamp.Unix() != 1704106800 {
+		t.Errorf("Expected timestamp from startsAt, got %v", m.Timestamp)
+	}
+}
+
+func TestCollectTibberPriceHomeIDVariable(t *testing.T) {
+	homeID := `home"){ injected }`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request graphQLRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		if strings.Contains(request.Query, homeID) {
+			t.Errorf("Expected home ID not to be part of the query, got %q", request.Query)
+		}
+		if request.Variables["id"] != homeID {
+			t.Errorf("Expected home ID as variable, got %v", request.Variables)
+		}
+		fmt.Fprint(w, `{"data":{"viewer":{"home":{"currentSubscription":{"priceInfo":{"current":{
+			"total":0.3,"startsAt":"2024-01-01T12:00:00.000+01:00","currency":"EUR"}}}}}}}`)
+	}))
+	defer server.Close()
+
+	module, err := NewTibberModule(Config{Token: "secret", HomeID: homeID, APIURL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	module.metricsCh = make(chan metrics.Metric, 2)
+
+	if err := module.collectPrice(context.Background()); err != nil {
+		t.Fatalf("collectPrice failed: %v", err)
+	}
+}
+
+func TestSelfTestChecks(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/subscriptions"
+
+	mux.HandleFunc("/gql", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("X-RateLimit-Remaining", "99")
+		fmt.Fprintf(w, `{"data":{"viewer":{"websocketSubscriptionUrl":%q}}}`, wsURL)
+	})
+	mux.Handle("/subscriptions", websocket.Handler(func(ws *websocket.Conn) {
+		var msg subscriptionMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return
+		}
+		var payload map[string]string
+		json.Unmarshal(msg.Payload, &payload)
+		if msg.Type == "connection_init" && payload["token"] == "secret" {
+			websocket.JSON.Send(ws, subscriptionMessage{Type: "connection_ack"})
+		} else {
+			websocket.JSON.Send(ws, subscriptionMessage{Type: "connection_error", Payload: json.RawMessage(`"unauthorized"`)})
+		}
+	}))
+
+	module, err := NewTibberModule(Config{Token: "secret", HomeID: "home-1", APIURL: server.URL + "/gql", Timeout: config.Duration(time.Second)})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	check, url := module.tokenCheck(context.Background())
+	if !check.Passed() || url != wsURL || check.Detail != "99 requests remaining" {
+		t.Fatalf("Expected token check to pass, got %+v, %s", check, url)
+	}
+	if check := module.subscriptionCheck(context.Background(), url); !check.Passed() {
+		t.Errorf("Expected subscription check to pass, got %v", check.Err)
+	}
+
+	module.config.Token = "wrong"
+	if check, url := module.tokenCheck(context.Background()); check.Passed() || url != "" || check.Remediation() == "" {
+		t.Errorf("Expected token check to fail with hint, got %+v", check)
+	}
+	if check := module.subscriptionCheck(context.Background(), wsURL); check.Passed() {
+		t.Error("Expected subscription check to fail for wrong token")
+	}
+}
+
+func TestCollectAwattarPrice(t *testing.T) {
+	now := time.Now()
+	start := now.Truncate(time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(AwattarResponse{Data: []AwattarPrice{
+			{StartTimestamp: start.Add(-time.Hour).UnixMilli(), EndTimestamp: start.UnixMilli(), MarketPrice: 50, Unit: "Eur/MWh"},
+			{StartTimestamp: start.UnixMilli(), EndTimestamp: start.Add(time.Hour).UnixMilli(), MarketPrice: 123.4, Unit: "Eur/MWh"},
+		}})
+	}))
+	defer server.Close()
+
+	module, err := NewTibberModule(Config{PriceSource: "awattar", AwattarURL: server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 2)
+	module.metricsCh = ch
+
+	if err := module.collectPrice(context.Background()); err != nil {
+		t.Fatalf("collectPrice failed: %v", err)
+	}
+
+	if status := <-ch; status.Name != "connection_status" {
+		t.Errorf("Expected connection status metric first, got %+v", status)
+	}
+	m := <-ch
+	if m.Tags["vendor"] != "awattar" {
+		t.Errorf("Expected vendor awattar, got %s", m.Tags["vendor"])
+	}
+	if total, ok := m.Fields["total"].(float64); !ok || total < 0.1233 || total > 0.1235 {
+		t.Errorf("Expected total 0.1234 Eur/kWh, got %v", m.Fields["total"])
+	}
+	if !m.Timestamp.Equal(time.UnixMilli(start.UnixMilli())) {
+		t.Errorf("Expected timestamp %v, got %v", start, m.Timestamp)
+	}
+}
+
+func TestProcessMessage(t *testing.T) {
+	module, err := NewTibberModule(Config{Token: "secret", HomeID: "home-1", LiveMeasurement: true})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 1)
+	module.metricsCh = ch
+
+	t.Run("LiveMeasurement", func(t *testing.T) {
+		message := `{"id":"1","type":"next","payload":{"data":{"liveMeasurement":{
+			"timestamp":"2024-01-01T12:00:00.000+01:00","power":1234,"powerProduction":0,
+			"accumulatedConsumption":5.5,"accumulatedCost":1.65,"lastMeterConsumption":12345.6,"currency":"EUR"}}}}`
+		if err := module.processMessage([]byte(message)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		m := <-ch
+		if m.Name != "electricity" {
+			t.Errorf("Expected metric name 'electricity', got '%s'", m.Name)
+		}
+		if !metrics.IsMissing(m.Fields["sum_power_total_out"]) {
+			t.Error("sum_power_total_out should be marked as missing when not reported")
+		}
+		m.Fields = metrics.ResolveMissing(m.Fields, metrics.MissingOmit)
+		if m.Fields["power"] != 1234.0 {
+			t.Errorf("Expected power 1234, got %v", m.Fields["power"])
+		}
+		if m.Fields["sum_power_today"] != 5.5 {
+			t.Errorf("Expected sum_power_today 5.5, got %v", m.Fields["sum_power_today"])
+		}
+		if m.Fields["sum_power_total"] != 12345.6 {
+			t.Errorf("Expected sum_power_total 12345.6, got %v", m.Fields["sum_power_total"])
+		}
+		if _, exists := m.Fields["sum_power_total_out"]; exists {
+			t.Error("sum_power_total_out should be omitted when not reported")
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		if err := module.processMessage([]byte(`{"id":"1","type":"error","payload":[{"message":"invalid home"}]}`)); err == nil {
+			t.Error("Expected error for subscription error message")
+		}
+	})
+}
+
+func TestLiveMeasurementHandshake(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/subscriptions"
+
+	mux.HandleFunc("/gql", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"data":{"viewer":{"websocketSubscriptionUrl":%q}}}`, wsURL)
+	})
+	received := make(chan string, 10)
+	mux.Handle("/subscriptions", websocket.Handler(func(ws *websocket.Conn) {
+		receive := func() string {
+			var msg subscriptionMessage
+			if err := websocket.JSON.Receive(ws, &msg); err != nil {
+				return ""
+			}
+			received <- msg.Type
+			return msg.Type
+		}
+		if receive() != "connection_init" {
+			return
+		}
+
+		// Like Tibber, reject a subscribe that doesn't wait for the ack
+		ws.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if early := receive(); early != "" {
+			return
+		}
+		ws.SetReadDeadline(time.Time{})
+		websocket.JSON.Send(ws, subscriptionMessage{Type: "connection_ack"})
+		if receive() != "subscribe" {
+			return
+		}
+
+		// A completed subscription is started again
+		websocket.JSON.Send(ws, subscriptionMessage{ID: subscriptionID, Type: "complete"})
+		if receive() != "subscribe" {
+			return
+		}
+		websocket.JSON.Send(ws, subscriptionMessage{ID: subscriptionID, Type: "next", Payload: json.RawMessage(
+			`{"data":{"liveMeasurement":{"timestamp":"2024-01-01T12:00:00.000+01:00","power":1234}}}`)})
+		receive() // until the client disconnects
+	}))
+
+	module, err := NewTibberModule(Config{Token: "secret", HomeID: "home-1", APIURL: server.URL + "/gql", LiveMeasurement: true})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	ch := make(chan metrics.Metric, 10)
+	module.metricsCh = ch
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go module.runLiveMeasurement(ctx)
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case m := <-ch:
+			if m.Name != "electricity" {
+				continue
+			}
+			cancel()
+			var messages []string
+			for len(received) > 0 {
+				messages = append(messages, <-received)
+			}
+			if strings.Join(messages, ",") != "connection_init,subscribe,subscribe" {
+				t.Errorf("Expected subscribe after the ack and after complete, got %v", messages)
+			}
+			return
+		case <-timeout:
+			t.Fatal("Expected a live measurement after the handshake")
+		}
+	}
+}
@@ -0,0 +1,434 @@
+// Package velux provides a metric collection module for VELUX ACTIVE roof
+// windows, shutters and blinds. VELUX ACTIVE runs on the Netatmo platform, so
+// the module authorizes with OAuth2 like the Netatmo module, only with another
+// scope and endpoints, and reads the state of the homes from the homesdata and
+// homestatus endpoints known from Netatmo thermostats.
+// It emits the position of each window, shutter and blind and the rain sensor
+// state of the gateway, which closes the windows when it starts raining.
+package velux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/connection"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// Metric names
+	metricNameCovering = "window_covering"
+	metricNameRain     = "rain"
+
+	// defaultBaseURL is the VELUX ACTIVE API, which also serves the OAuth2 endpoints
+	defaultBaseURL = "https://app.velux-active.com"
+
+	// API endpoints
+	homesDataEndpoint  = "/api/homesdata"
+	homeStatusEndpoint = "/syncapi/v1/homestatus"
+)
+
+// Config represents the configuration for the Velux module
+type Config struct {
+	config.BaseConfig
+	ClientID     string          `json:"client_id"`
+	ClientSecret string          `json:"client_secret"`
+	Timeout      config.Duration `json:"timeout"`
+	Interval     config.Duration `json:"interval"`
+	Hostname     string          `json:"hostname"` // Optional hostname/IP for OAuth redirect URI
+	Scope        string          `json:"scope"`    // Space-separated OAuth scopes (defaults to velux_scopes)
+	BaseURL      string          `json:"base_url"` // API and OAuth2 server (defaults to the VELUX ACTIVE cloud)
+}
+
+// HomesData represents the response of the homesdata endpoint
+type HomesData struct {
+	Body struct {
+		Homes []Home `json:"homes"`
+	} `json:"body"`
+	Status string `json:"status"`
+}
+
+// Home represents a home with its modules
+type Home struct {
+	ID      string       `json:"id"`
+	Name    string       `json:"name"`
+	Modules []HomeModule `json:"modules"`
+}
+
+// HomeModule represents a module of a home: the gateway (NXG), an opening
+// (NXO) such as a window, shutter or blind, a sensor (NXS) or a switch (NXD)
+type HomeModule struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	VeluxType string `json:"velux_type"` // Kind of an opening: window, shutter or blind
+}
+
+// HomeStatus represents the response of the homestatus endpoint
+type HomeStatus struct {
+	Body struct {
+		Home struct {
+			ID      string         `json:"id"`
+			Modules []ModuleStatus `json:"modules"`
+		} `json:"home"`
+	} `json:"body"`
+	Status string `json:"status"`
+}
+
+// ModuleStatus represents the current state of a module. Openings report
+// their position, the gateway reports the state of the rain sensor.
+type ModuleStatus struct {
+	ID              string `json:"id"`
+	Type            string `json:"type"`
+	VeluxType       string `json:"velux_type"`
+	Reachable       *bool  `json:"reachable"`
+	CurrentPosition *int   `json:"current_position"` // 0 (closed) to 100 (open)
+	TargetPosition  *int   `json:"target_position"`
+	Mode            string `json:"mode"` // "manual" or "algo" when moved by the automation
+	IsRaining       *bool  `json:"is_raining"`
+}
+
+// VeluxModule handles VELUX ACTIVE API authentication and data collection
+type VeluxModule struct {
+	config      Config
+	httpClient  *http.Client
+	baseURL     string
+	oauth2      *utils.OAuth2Client
+	metricsCh   chan<- metrics.Metric
+	clock       utils.Clock
+	collections *utils.CollectionLog // last successful collection, nil if not remembered
+	tracker     *connection.Tracker
+}
+
+// NewVeluxModule creates a new Velux module instance
+func NewVeluxModule(cfg Config) (*VeluxModule, error) {
+	utils.Debugf("Creating new Velux module instance")
+	timeout := 30 * time.Second
+	if cfg.Timeout > 0 {
+		timeout = cfg.Timeout.Duration()
+	}
+	baseURL := defaultBaseURL
+	if cfg.BaseURL != "" {
+		baseURL = cfg.BaseURL
+	}
+
+	// VELUX ACTIVE uses the OAuth2 flow of the Netatmo platform
+	oauth2Config := utils.OAuth2Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		AuthURL:      baseURL + "/oauth2/authorize",
+		TokenURL:     baseURL + "/oauth2/token",
+		Scope:        cfg.Scope,
+		State:        "velux_auth",
+		Hostname:     cfg.Hostname,
+	}
+
+	oauth2Client, err := utils.NewOAuth2Client(oauth2Config, cfg.InstanceName("velux"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OAuth2 client: %w", err)
+	}
+
+	utils.Debugf("Velux module created successfully")
+	return &VeluxModule{
+		clock:  utils.SystemClock,
+		config: cfg,
+		httpClient: &http.Client{
+			Timeout:   timeout,
+			Transport: utils.OutboundTransport(cfg.InstanceName("velux"), nil),
+		},
+		baseURL: baseURL,
+		oauth2:  oauth2Client,
+	}, nil
+}
+
+// Run starts the Velux module and begins collecting metrics
+func Run(ctx context.Context, ch chan<- metrics.Metric) error {
+	config, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	module, err := NewVeluxModule(config)
+	if err != nil {
+		return fmt.Errorf("failed to create Velux module: %w", err)
+	}
+	module.metricsCh = ch
+	module.clock = utils.ClockFromContext(ctx)
+	module.collections = utils.NewCollectionLog(module.oauth2.Storage(), module.clock)
+
+	return module.run(ctx)
+}
+
+// Probe validates the Velux configuration and checks that the API is reachable
+func Probe(ctx context.Context) error {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return &config.ModuleError{Module: "velux", Err: fmt.Errorf("client_id and client_secret are required but not configured")}
+	}
+	baseURL := defaultBaseURL
+	if cfg.BaseURL != "" {
+		baseURL = cfg.BaseURL
+	}
+	return utils.ProbeURL(ctx, baseURL, 10*time.Second)
+}
+
+// SelfTest checks the stored OAuth token and, if it is still valid, that the
+// API accepts it
+func SelfTest(ctx context.Context) []utils.Check {
+	cfg, err := LoadConfig(config.InstanceFromContext(ctx))
+	if err != nil {
+		return []utils.Check{{Name: "configuration", Err: err}}
+	}
+	module, err := NewVeluxModule(cfg)
+	if err != nil {
+		return []utils.Check{{Name: "configuration", Err: err}}
+	}
+
+	tokenCheck, accessToken := module.oauth2.TokenCheck()
+	checks := []utils.Check{tokenCheck}
+	if accessToken == "" {
+		return checks
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, module.baseURL+homesDataEndpoint, nil)
+	if err != nil {
+		return append(checks, utils.Check{Name: "API access", Err: err})
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := module.httpClient.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	return append(checks, utils.APICheck("API access", resp, err))
+}
+
+// run executes the main module loop
+func (vm *VeluxModule) run(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Velux module", "main", func() error {
+		if err := vm.authenticate(ctx); err != nil {
+			return fmt.Errorf("failed to authenticate with VELUX ACTIVE API: %w", err)
+		}
+
+		interval := 2 * time.Minute
+		if vm.config.Interval > 0 {
+			interval = vm.config.Interval.Duration()
+		}
+
+		ticker := utils.NewScheduledTicker(ctx, interval)
+		defer ticker.Stop()
+
+		// Collect initial data unless outside the collection schedule or skipped,
+		// but not before an interval has passed since the last collection
+		initial := vm.collections.Initial(ctx, interval)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-initial:
+				if err := utils.CollectWithRetry(ctx, interval, vm.collectData); err != nil {
+					utils.Warnf("Failed to collect initial data: %v", err)
+				} else {
+					vm.collections.Record()
+				}
+			case <-ticker.C:
+				if err := utils.CollectWithRetry(ctx, interval, vm.collectData); err != nil {
+					utils.Warnf("Failed to collect data: %v", err)
+				} else {
+					vm.collections.Record()
+				}
+			}
+		}
+	})
+}
+
+// authenticate performs OAuth2 authentication with the VELUX ACTIVE API
+func (vm *VeluxModule) authenticate(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Velux authentication", "oauth", func() error {
+		if vm.config.ClientID == "" {
+			return fmt.Errorf("client_id is required but not configured")
+		}
+		if vm.config.ClientSecret == "" {
+			return fmt.Errorf("client_secret is required but not configured")
+		}
+
+		if _, err := vm.oauth2.Authenticate(ctx); err != nil {
+			return fmt.Errorf("OAuth2 authentication failed: %w", err)
+		}
+
+		utils.Infof("Successfully authenticated with VELUX ACTIVE API")
+		return nil
+	})
+}
+
+// collectData fetches the homes and the state of their modules and sends metrics
+func (vm *VeluxModule) collectData(ctx context.Context) error {
+	return utils.WithPanicRecoveryAndReturnError("Velux data collection", "api", func() error {
+		var homesData HomesData
+		if err := vm.get(ctx, homesDataEndpoint, &homesData); err != nil {
+			return err
+		}
+		if homesData.Status != "ok" {
+			return fmt.Errorf("API returned non-ok status: %s", homesData.Status)
+		}
+
+		for _, home := range homesData.Body.Homes {
+			if len(home.Modules) == 0 {
+				continue
+			}
+
+			var status HomeStatus
+			if err := vm.get(ctx, homeStatusEndpoint+"?home_id="+url.QueryEscape(home.ID), &status); err != nil {
+				return err
+			}
+			if status.Status != "ok" {
+				return fmt.Errorf("API returned non-ok status for home %s: %s", home.Name, status.Status)
+			}
+			vm.processHomeStatus(&home, &status, vm.clock.Now())
+		}
+		return nil
+	})
+}
+
+// get requests an API endpoint and decodes the JSON response into result
+func (vm *VeluxModule) get(ctx context.Context, endpoint string, result interface{}) error {
+	req, err := http.NewRequest("GET", vm.baseURL+endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	// Use OAuth2Client's authenticated request method (handles retries automatically)
+	resp, err := vm.oauth2.AuthenticatedRequest(ctx, vm.httpClient, req)
+	vm.connection().SetPollResult(resp, err)
+	if err != nil {
+		return fmt.Errorf("API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse API response: %w", err)
+	}
+	return nil
+}
+
+// connection returns the tracker for the VELUX ACTIVE API, creating it on first use
+func (vm *VeluxModule) connection() *connection.Tracker {
+	if vm.tracker == nil {
+		vm.tracker = connection.NewTracker(vm.config.InstanceName("velux"), vm.baseURL, vm.metricsCh)
+	}
+	return vm.tracker
+}
+
+// processHomeStatus sends a window_covering metric for each opening reporting
+// its position and a rain metric for each gateway reporting its rain sensor
+func (vm *VeluxModule) processHomeStatus(home *Home, status *HomeStatus, timestamp time.Time) {
+	modules := make(map[string]HomeModule, len(home.Modules))
+	for _, module := range home.Modules {
+		modules[module.ID] = module
+	}
+
+	for _, module := range status.Body.Home.Modules {
+		name := modules[module.ID].Name
+
+		if module.IsRaining != nil {
+			raining := 0
+			if *module.IsRaining {
+				raining = 1
+			}
+			fields := map[string]interface{}{"raining": raining}
+			if module.Reachable != nil {
+				fields["reachable"] = *module.Reachable
+			}
+			vm.send(metricNameRain, vm.tags(home.Name, module.ID, name), fields, timestamp)
+		}
+
+		if module.CurrentPosition != nil {
+			fields := map[string]interface{}{
+				"position":        *module.CurrentPosition,
+				"target_position": module.TargetPosition,
+			}
+			if module.Reachable != nil {
+				fields["reachable"] = *module.Reachable
+			}
+			if module.Mode != "" {
+				fields["mode"] = module.Mode
+			}
+
+			tags := vm.tags(home.Name, module.ID, name)
+			if kind := modules[module.ID].VeluxType; kind != "" {
+				tags["type"] = kind
+			} else if module.VeluxType != "" {
+				tags["type"] = module.VeluxType
+			}
+			vm.send(metricNameCovering, tags, fields, timestamp)
+		}
+	}
+}
+
+// tags returns the tags of a module of a home
+func (vm *VeluxModule) tags(homeName, deviceID, name string) map[string]string {
+	return map[string]string{
+		"vendor":   "velux",
+		"home":     homeName,
+		"device":   deviceID,
+		"friendly": vm.config.GetFriendlyName(deviceID, name, deviceID),
+	}
+}
+
+// send sends a metric without blocking
+func (vm *VeluxModule) send(name string, tags map[string]string, fields map[string]interface{}, timestamp time.Time) {
+	metric := metrics.Metric{
+		Name:      name,
+		Tags:      tags,
+		Fields:    fields,
+		Timestamp: timestamp,
+	}
+
+	select {
+	case vm.metricsCh <- metric:
+	default:
+		utils.Warnf("Metrics channel is full, dropping %s metric for device %s", name, tags["device"])
+	}
+}
+
+// DefaultConfig returns the default configuration of the Velux module.
+func DefaultConfig() Config {
+	return Config{
+		Timeout:  config.Duration(30 * time.Second),
+		Interval: config.Duration(2 * time.Minute),
+		Scope:    "velux_scopes",
+		BaseURL:  defaultBaseURL,
+	}
+}
+
+// LoadConfig loads the Velux module configuration, scoped to the given instance if set
+func LoadConfig(instance string) (Config, error) {
+	defaultConfig := DefaultConfig()
+
+	loader := config.NewLoader("velux")
+	loader.SetInstance(instance)
+	if config.GlobalConfigPath != "" {
+		loader.SetConfigPath(config.GlobalConfigPath)
+	}
+
+	loadedConfig, err := loader.LoadConfig(&defaultConfig)
+	if err != nil {
+		return defaultConfig, err
+	}
+
+	return *loadedConfig.(*Config), nil
+}
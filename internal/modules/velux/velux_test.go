@@ -0,0 +1,137 @@
+package velux
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestDefaultConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.Scope != "velux_scopes" || cfg.BaseURL != defaultBaseURL || cfg.Interval.Duration() != 2*time.Minute {
+		t.Errorf("Unexpected default configuration %+v", cfg)
+	}
+}
+
+func TestProcessHomeStatus(t *testing.T) {
+	module, err := NewVeluxModule(Config{ClientID: "id", ClientSecret: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to create Velux module: %v", err)
+	}
+	module.config.FriendlyNameOverrides = map[string]string{"5231": "Bad"}
+	metricsCh := make(chan metrics.Metric, 10)
+	module.metricsCh = metricsCh
+
+	home := Home{
+		ID:   "5e1e",
+		Name: "Haus",
+		Modules: []HomeModule{
+			{ID: "70:ee:50:00:00:01", Name: "Gateway", Type: "NXG"},
+			{ID: "5230", Name: "Dachfenster Büro", Type: "NXO", VeluxType: "window"},
+			{ID: "5231", Name: "Rollladen Bad", Type: "NXO", VeluxType: "shutter"},
+		},
+	}
+
+	var status HomeStatus
+	payload := `{"status": "ok", "body": {"home": {"id": "5e1e",
+		"modules": [
+			{"id": "70:ee:50:00:00:01", "type": "NXG", "reachable": true, "is_raining": true},
+			{"id": "5230", "type": "NXO", "reachable": true, "current_position": 0, "target_position": 0, "mode": "algo"},
+			{"id": "5231", "type": "NXO", "reachable": false, "current_position": 40},
+			{"id": "5232", "type": "NXS", "reachable": true}
+		]}}}`
+	if err := json.Unmarshal([]byte(payload), &status); err != nil {
+		t.Fatalf("Failed to parse home status: %v", err)
+	}
+
+	module.processHomeStatus(&home, &status, time.Now())
+
+	if len(metricsCh) != 3 {
+		t.Fatalf("Expected 3 metrics (rain, 2 openings), got %d", len(metricsCh))
+	}
+
+	rain := <-metricsCh
+	if rain.Name != metricNameRain || rain.Fields["raining"] != 1 || rain.Tags["friendly"] != "Gateway" || rain.Tags["home"] != "Haus" {
+		t.Errorf("Unexpected rain metric %s %v %v", rain.Name, rain.Tags, rain.Fields)
+	}
+
+	window := <-metricsCh
+	fields := metrics.ResolveMissing(window.Fields, metrics.MissingOmit)
+	if window.Name != metricNameCovering || window.Tags["type"] != "window" || window.Tags["vendor"] != "velux" {
+		t.Errorf("Unexpected window metric %s %v", window.Name, window.Tags)
+	}
+	if fields["position"] != 0 || fields["target_position"] != 0 || fields["mode"] != "algo" || fields["reachable"] != true {
+		t.Errorf("Unexpected window fields %v", fields)
+	}
+
+	shutter := <-metricsCh
+	if shutter.Tags["friendly"] != "Bad" || shutter.Tags["type"] != "shutter" || shutter.Fields["position"] != 40 {
+		t.Errorf("Expected shutter with friendly name override, got %v %v", shutter.Tags, shutter.Fields)
+	}
+	if !metrics.IsMissing(shutter.Fields["target_position"]) {
+		t.Error("Expected missing target position for shutter without target")
+	}
+}
+
+func TestCollectData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case homesDataEndpoint:
+			w.Write([]byte(`{"status": "ok", "body": {"homes": [
+				{"id": "empty", "name": "Garage"},
+				{"id": "5e1e", "name": "Haus", "modules": [{"id": "5230", "name": "Dachfenster", "type": "NXO", "velux_type": "window"}]}
+			]}}`))
+		case homeStatusEndpoint:
+			if r.URL.Query().Get("home_id") != "5e1e" {
+				t.Errorf("Unexpected home %q", r.URL.Query().Get("home_id"))
+			}
+			w.Write([]byte(`{"status": "ok", "body": {"home": {"id": "5e1e", "modules": [{"id": "5230", "type": "NXO", "current_position": 100}]}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	module, err := NewVeluxModule(Config{
+		BaseConfig:   config.BaseConfig{Instance: "collect-test"},
+		ClientID:     "velux_test_client",
+		ClientSecret: "secret",
+		BaseURL:      server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create module: %v", err)
+	}
+	module.oauth2.Storage().Set("oauth2_token", map[string]interface{}{
+		"access_token":  "token",
+		"refresh_token": "refresh",
+		"expires_at":    time.Now().Add(time.Hour).Format(time.RFC3339),
+		"client_id":     "velux_test_client",
+	})
+	metricsCh := make(chan metrics.Metric, 10)
+	module.metricsCh = metricsCh
+
+	if err := module.collectData(context.Background()); err != nil {
+		t.Fatalf("Failed to collect data: %v", err)
+	}
+
+	// The connection tracker reports the API status on the same channel
+	var coverings []metrics.Metric
+	for len(metricsCh) > 0 {
+		if m := <-metricsCh; m.Name == metricNameCovering {
+			coverings = append(coverings, m)
+		}
+	}
+	if len(coverings) != 1 || coverings[0].Fields["position"] != 100 || coverings[0].Tags["friendly"] != "Dachfenster" {
+		t.Errorf("Expected one open window, got %v", coverings)
+	}
+}
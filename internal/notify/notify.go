@@ -0,0 +1,164 @@
+// Package notify sends notifications about modules that keep failing, so that
+// broken integrations are noticed without watching the logs.
+//
+// A Notifier posts each Event as JSON to a webhook and/or runs a command with
+// the event on stdin. Both are optional; a nil Notifier discards all events.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// Notification reasons
+const (
+	// ReasonRestartLimit is sent when a module exceeded the restart limit and was given up
+	ReasonRestartLimit = "restart_limit_exceeded"
+	// ReasonCrashLoop is sent when a module has been failing for longer than crash_loop_after
+	ReasonCrashLoop = "crash_loop"
+	// ReasonErrorBudget is sent when too many upstream calls of a module failed
+	ReasonErrorBudget = "error_budget_exceeded"
+)
+
+// defaultTimeout limits how long a single notification may take
+const defaultTimeout = 10 * time.Second
+
+// Event describes a module that keeps failing.
+type Event struct {
+	Host     string    `json:"host"`
+	Module   string    `json:"module"`
+	Reason   string    `json:"reason"`
+	Restarts int       `json:"restarts"`
+	Error    string    `json:"error,omitempty"`
+	Since    time.Time `json:"since"`
+	Time     time.Time `json:"time"`
+}
+
+// Notifier delivers events to the configured webhook and command.
+type Notifier struct {
+	webhook        string
+	command        []string
+	timeout        time.Duration
+	crashLoopAfter time.Duration
+	client         *http.Client
+}
+
+// New creates a notifier from the configuration. It returns nil if neither a
+// webhook nor a command is configured. Invalid durations are logged and
+// replaced by their defaults.
+func New(cfg config.NotifyConfig) *Notifier {
+	if cfg.Webhook == "" && len(cfg.Command) == 0 {
+		return nil
+	}
+
+	n := &Notifier{
+		webhook: cfg.Webhook,
+		command: cfg.Command,
+		timeout: defaultTimeout,
+		client:  &http.Client{Transport: utils.OutboundTransport("notify", nil)},
+	}
+	if cfg.Timeout > 0 {
+		n.timeout = cfg.Timeout.Duration()
+	}
+	if cfg.CrashLoopAfter > 0 {
+		n.crashLoopAfter = cfg.CrashLoopAfter.Duration()
+	}
+	return n
+}
+
+// CrashLoopAfter returns how long a module must keep failing before a crash
+// loop is notified. Zero disables crash loop notifications.
+func (n *Notifier) CrashLoopAfter() time.Duration {
+	if n == nil {
+		return 0
+	}
+	return n.crashLoopAfter
+}
+
+// Notify delivers the event to the webhook and the command. The host and time
+// are filled in if not set and secrets in the error are redacted. Both targets
+// are tried even if one of them fails.
+func (n *Notifier) Notify(event Event) error {
+	if n == nil {
+		return nil
+	}
+	event.Error = utils.RedactSecrets(event.Error)
+	if event.Host == "" {
+		event.Host, _ = os.Hostname()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+
+	var errs []error
+	if n.webhook != "" {
+		if err := n.postWebhook(ctx, payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(n.command) > 0 {
+		if err := n.runCommand(ctx, event, payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// postWebhook posts the payload to the webhook URL.
+func (n *Notifier) postWebhook(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.webhook, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// runCommand runs the notification command with the payload on stdin. The
+// command's output is captured, so it never ends up in the metrics on stdout.
+func (n *Notifier) runCommand(ctx context.Context, event Event, payload []byte) error {
+	cmd := exec.CommandContext(ctx, n.command[0], n.command[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(),
+		"METRICS_AGENT_HOST="+event.Host,
+		"METRICS_AGENT_MODULE="+event.Module,
+		"METRICS_AGENT_REASON="+event.Reason,
+		"METRICS_AGENT_RESTARTS="+strconv.Itoa(event.Restarts),
+		"METRICS_AGENT_ERROR="+event.Error,
+	)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("notify command failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+)
+
+func TestNewWithoutTargets(t *testing.T) {
+	n := New(config.NotifyConfig{CrashLoopAfter: config.Duration(10 * time.Minute)})
+	if n != nil {
+		t.Fatalf("Expected nil notifier without webhook and command")
+	}
+	if n.CrashLoopAfter() != 0 {
+		t.Errorf("Expected crash loop notifications to be disabled")
+	}
+	if err := n.Notify(Event{Module: "dwd"}); err != nil {
+		t.Errorf("Expected nil notifier to discard events, got %v", err)
+	}
+}
+
+func TestNotifyWebhook(t *testing.T) {
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Failed to decode notification: %v", err)
+		}
+		received <- event
+	}))
+	defer server.Close()
+
+	n := New(config.NotifyConfig{Webhook: server.URL, CrashLoopAfter: config.Duration(10 * time.Minute)})
+	if n.CrashLoopAfter() != 10*time.Minute {
+		t.Errorf("Expected crash_loop_after 10m, got %v", n.CrashLoopAfter())
+	}
+
+	err := n.Notify(Event{Module: "tibber", Reason: ReasonRestartLimit, Restarts: 3, Error: "unauthorized: token=abc123"})
+	if err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	event := <-received
+	if event.Module != "tibber" || event.Reason != ReasonRestartLimit || event.Restarts != 3 || event.Error != "unauthorized: token=***" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+	if event.Time.IsZero() {
+		t.Errorf("Expected time to be set")
+	}
+}
+
+func TestNotifyWebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	n := New(config.NotifyConfig{Webhook: server.URL})
+	if err := n.Notify(Event{Module: "dwd"}); err == nil {
+		t.Errorf("Expected error for status 500")
+	}
+}
+
+func TestNotifyCommand(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "notification")
+	n := New(config.NotifyConfig{
+		Command: []string{"sh", "-c", `cat > "$0" && echo "$METRICS_AGENT_MODULE $METRICS_AGENT_REASON" >> "$0"`, output},
+	})
+
+	if err := n.Notify(Event{Module: "proxmox", Reason: ReasonCrashLoop}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Failed to read command output: %v", err)
+	}
+	if !strings.Contains(string(data), `"module":"proxmox"`) {
+		t.Errorf("Expected event on stdin, got %s", data)
+	}
+	if !strings.HasSuffix(string(data), "proxmox crash_loop\n") {
+		t.Errorf("Expected event in environment, got %s", data)
+	}
+}
+
+func TestNotifyCommandError(t *testing.T) {
+	n := New(config.NotifyConfig{Command: []string{"sh", "-c", "echo boom; exit 1"}})
+
+	err := n.Notify(Event{Module: "nut"})
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Expected command error with output, got %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+// Package otlp exports metrics to an OpenTelemetry Collector or another
+// receiver of the OpenTelemetry protocol (OTLP), so the agent can feed OTel
+// pipelines and Grafana Cloud directly without going through line protocol.
+//
+// Metrics are batched and sent with OTLP/HTTP using the JSON encoding; package
+// output provides the batching, compression and retries. Each numeric or
+// boolean field becomes a metric named "<measurement>_<field>" whose data point
+// carries the tags as attributes, following the mapping of telegraf's
+// OpenTelemetry output. Fields the module marks as counter or cumulative are
+// exported as monotonic cumulative sums, all others as gauges. String fields
+// have no OTel metric representation and are skipped.
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/output"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const scopeName = "metrics-agent"
+
+// aggregationTemporalityCumulative is the OTLP AggregationTemporality of sums
+// reported as running totals
+const aggregationTemporalityCumulative = 2
+
+// Exporter buffers metrics and sends them to an OTLP/HTTP endpoint.
+type Exporter struct {
+	resource []keyValue
+	version  string
+	batcher  *output.Batcher
+	sender   *output.Sender
+}
+
+// New creates an exporter from the configuration. It returns nil if no
+// endpoint is configured.
+func New(cfg config.OTLPConfig, version string) (*Exporter, error) {
+	if cfg.Endpoint == "" {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	e := &Exporter{
+		version: version,
+		sender:  output.NewSender("otlp", cfg.Endpoint, cfg.OutputOptions),
+	}
+	e.batcher = output.NewBatcher("otlp", cfg.OutputOptions, e.send)
+
+	attributes := map[string]string{
+		"service.name":    scopeName,
+		"service.version": version,
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		attributes["host.name"] = hostname
+	}
+	for key, value := range cfg.ResourceAttributes {
+		attributes[key] = value
+	}
+	e.resource = stringAttributes(attributes)
+	return e, nil
+}
+
+// Export adds a metric to the buffer of the next export.
+func (e *Exporter) Export(m metrics.Metric) {
+	if e == nil {
+		return
+	}
+	e.batcher.Add(m)
+}
+
+// Start exports the buffered metrics periodically until Stop is called.
+func (e *Exporter) Start() {
+	if e == nil {
+		return
+	}
+	e.batcher.Start()
+}
+
+// Stop ends the periodic export and exports the remaining metrics.
+func (e *Exporter) Stop() {
+	if e == nil {
+		return
+	}
+	e.batcher.Stop()
+}
+
+// Flush sends all buffered metrics. On failure the metrics are kept for the
+// next attempt.
+func (e *Exporter) Flush(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+	return e.batcher.Flush(ctx)
+}
+
+// Stats returns the state of the export, e.g. the number of queued metrics.
+func (e *Exporter) Stats() output.Stats {
+	if e == nil {
+		return output.Stats{}
+	}
+	return e.batcher.Stats()
+}
+
+// send posts a batch of metrics to the endpoint.
+func (e *Exporter) send(ctx context.Context, batch []metrics.Metric) error {
+	payload, err := json.Marshal(e.request(batch))
+	if err != nil {
+		return fmt.Errorf("failed to encode metrics: %w", err)
+	}
+	return e.sender.Send(ctx, "application/json", payload)
+}
+
+// request translates a batch of metrics into an OTLP export request.
+func (e *Exporter) request(batch []metrics.Metric) exportRequest {
+	byName := make(map[string]*metric)
+	var names []string
+
+	for _, m := range batch {
+		timestamp := m.Timestamp
+		if timestamp.IsZero() {
+			timestamp = time.Now()
+		}
+		attributes := stringAttributes(m.Tags)
+
+		for field, value := range m.Fields {
+			point, ok := dataPointValue(value)
+			if !ok {
+				continue
+			}
+			point.Attributes = attributes
+			point.TimeUnixNano = strconv.FormatInt(timestamp.UnixNano(), 10)
+
+			name := m.Name + "_" + field
+			if _, exists := byName[name]; !exists {
+				byName[name] = newMetric(name, m.FieldKind(field))
+				names = append(names, name)
+			}
+			byName[name].addDataPoint(point)
+		}
+	}
+
+	sort.Strings(names)
+	result := make([]metric, 0, len(names))
+	for _, name := range names {
+		result = append(result, *byName[name])
+	}
+
+	return exportRequest{
+		ResourceMetrics: []resourceMetrics{{
+			Resource: resource{Attributes: e.resource},
+			ScopeMetrics: []scopeMetrics{{
+				Scope:   scope{Name: scopeName, Version: e.version},
+				Metrics: result,
+			}},
+		}},
+	}
+}
+
+// newMetric creates a gauge, or a monotonic cumulative sum for counter and
+// cumulative fields.
+func newMetric(name string, kind metrics.Kind) *metric {
+	if kind.IsMonotonic() {
+		return &metric{Name: name, Sum: &sum{AggregationTemporality: aggregationTemporalityCumulative, IsMonotonic: true}}
+	}
+	return &metric{Name: name, Gauge: &gauge{}}
+}
+
+// addDataPoint adds a data point to the gauge or sum of the metric.
+func (m *metric) addDataPoint(point numberDataPoint) {
+	if m.Sum != nil {
+		m.Sum.DataPoints = append(m.Sum.DataPoints, point)
+		return
+	}
+	m.Gauge.DataPoints = append(m.Gauge.DataPoints, point)
+}
+
+// dataPointValue converts a field value into a gauge data point. Booleans are
+// exported as 0 or 1; strings and other types are not supported.
+func dataPointValue(value interface{}) (numberDataPoint, bool) {
+	switch v := value.(type) {
+	case int:
+		return intDataPoint(int64(v)), true
+	case int32:
+		return intDataPoint(int64(v)), true
+	case int64:
+		return intDataPoint(v), true
+	case float32:
+		return doubleDataPoint(float64(v)), true
+	case float64:
+		return doubleDataPoint(v), true
+	case bool:
+		if v {
+			return intDataPoint(1), true
+		}
+		return intDataPoint(0), true
+	default:
+		return numberDataPoint{}, false
+	}
+}
+
+func intDataPoint(v int64) numberDataPoint {
+	value := strconv.FormatInt(v, 10)
+	return numberDataPoint{AsInt: &value}
+}
+
+func doubleDataPoint(v float64) numberDataPoint {
+	return numberDataPoint{AsDouble: &v}
+}
+
+// stringAttributes converts a map into OTLP attributes sorted by key.
+func stringAttributes(values map[string]string) []keyValue {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]keyValue, 0, len(keys))
+	for _, key := range keys {
+		attributes = append(attributes, keyValue{Key: key, Value: anyValue{StringValue: values[key]}})
+	}
+	return attributes
+}
+
+// The types below mirror the JSON encoding of the OTLP metrics protobuf
+// messages. 64-bit integers are encoded as strings.
+
+type exportRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeMetrics struct {
+	Scope   scope    `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type metric struct {
+	Name  string `json:"name"`
+	Gauge *gauge `json:"gauge,omitempty"`
+	Sum   *sum   `json:"sum,omitempty"`
+}
+
+type gauge struct {
+	DataPoints []numberDataPoint `json:"dataPoints"`
+}
+
+type sum struct {
+	DataPoints             []numberDataPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type numberDataPoint struct {
+	Attributes   []keyValue `json:"attributes,omitempty"`
+	TimeUnixNano string     `json:"timeUnixNano"`
+	AsDouble     *float64   `json:"asDouble,omitempty"`
+	AsInt        *string    `json:"asInt,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
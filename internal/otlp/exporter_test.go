@@ -0,0 +1,192 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// mustNew creates an exporter and fails the test on errors
+func mustNew(t *testing.T, cfg config.OTLPConfig, version string) *Exporter {
+	t.Helper()
+	e, err := New(cfg, version)
+	if err != nil {
+		t.Fatalf("Failed to create exporter: %v", err)
+	}
+	return e
+}
+
+func TestNewWithoutEndpoint(t *testing.T) {
+	e, err := New(config.OTLPConfig{}, "test")
+	if e != nil || err != nil {
+		t.Fatal("Expected nil exporter without endpoint")
+	}
+	// A nil exporter discards all metrics
+	e.Export(metrics.Metric{Name: "x"})
+	e.Start()
+	e.Stop()
+	if err := e.Flush(context.Background()); err != nil {
+		t.Errorf("Expected no error from nil exporter, got %v", err)
+	}
+}
+
+func TestNewInvalidOptions(t *testing.T) {
+	_, err := New(config.OTLPConfig{Endpoint: "http://collector:4318/v1/metrics", OutputOptions: config.OutputOptions{Compression: "snappy"}}, "test")
+	if err == nil {
+		t.Error("Expected error for unsupported compression")
+	}
+}
+
+func TestFlush(t *testing.T) {
+	received := make(chan exportRequest, 1)
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		var req exportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode export request: %v", err)
+		}
+		received <- req
+	}))
+	defer server.Close()
+
+	e := mustNew(t, config.OTLPConfig{
+		OutputOptions:      config.OutputOptions{Headers: map[string]string{"Authorization": "Basic abc"}},
+		Endpoint:           server.URL + "/v1/metrics",
+		ResourceAttributes: map[string]string{"deployment.environment": "home"},
+	}, "1.2.3")
+
+	timestamp := time.Unix(1700000000, 0)
+	e.Export(metrics.Metric{
+		Name:      "electricity",
+		Tags:      map[string]string{"device": "plug1"},
+		Fields:    map[string]interface{}{"power": 42, "voltage": 230.5, "on": true, "name": "Plug"},
+		Timestamp: timestamp,
+	})
+	e.Export(metrics.Metric{
+		Name:       "electricity",
+		Tags:       map[string]string{"device": "plug2"},
+		Fields:     map[string]interface{}{"power": int64(7), "sum_power_total": 12.5},
+		FieldKinds: metrics.ElectricityKinds,
+		Timestamp:  timestamp,
+	})
+
+	if err := e.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	req := <-received
+
+	if authorization != "Basic abc" {
+		t.Errorf("Expected configured header, got %q", authorization)
+	}
+	rm := req.ResourceMetrics[0]
+	resourceAttributes := map[string]string{}
+	for _, kv := range rm.Resource.Attributes {
+		resourceAttributes[kv.Key] = kv.Value.StringValue
+	}
+	if resourceAttributes["service.name"] != "metrics-agent" || resourceAttributes["service.version"] != "1.2.3" ||
+		resourceAttributes["deployment.environment"] != "home" {
+		t.Errorf("Unexpected resource attributes %v", resourceAttributes)
+	}
+
+	got := map[string]gauge{}
+	sums := map[string]sum{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if m.Sum != nil {
+			sums[m.Name] = *m.Sum
+			continue
+		}
+		got[m.Name] = *m.Gauge
+	}
+	if len(got) != 3 || len(sums) != 1 {
+		t.Fatalf("Expected 3 gauges (string field skipped) and 1 sum, got %v and %v", got, sums)
+	}
+
+	total := sums["electricity_sum_power_total"]
+	if !total.IsMonotonic || total.AggregationTemporality != aggregationTemporalityCumulative || *total.DataPoints[0].AsDouble != 12.5 {
+		t.Errorf("Unexpected counter %+v", total)
+	}
+
+	power := got["electricity_power"].DataPoints
+	if len(power) != 2 || *power[0].AsInt != "42" || *power[1].AsInt != "7" {
+		t.Errorf("Unexpected power data points %+v", power)
+	}
+	if power[0].Attributes[0].Key != "device" || power[0].Attributes[0].Value.StringValue != "plug1" {
+		t.Errorf("Expected tags as attributes, got %+v", power[0].Attributes)
+	}
+	if power[0].TimeUnixNano != "1700000000000000000" {
+		t.Errorf("Unexpected timestamp %s", power[0].TimeUnixNano)
+	}
+	if voltage := got["electricity_voltage"].DataPoints; *voltage[0].AsDouble != 230.5 {
+		t.Errorf("Unexpected voltage %v", *voltage[0].AsDouble)
+	}
+	if on := got["electricity_on"].DataPoints; *on[0].AsInt != "1" {
+		t.Errorf("Expected bool exported as 1, got %v", *on[0].AsInt)
+	}
+
+	// Nothing is sent without new metrics
+	if err := e.Flush(context.Background()); err != nil {
+		t.Errorf("Flush failed: %v", err)
+	}
+	select {
+	case <-received:
+		t.Error("Expected no request without metrics")
+	default:
+	}
+}
+
+func TestFlushRetriesAfterFailure(t *testing.T) {
+	fail := true
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	e := mustNew(t, config.OTLPConfig{Endpoint: server.URL, OutputOptions: config.OutputOptions{MaxRetries: 1}}, "test")
+	e.sender.SetBackoff(time.Millisecond, time.Millisecond)
+	e.Export(metrics.Metric{Name: "temperature", Fields: map[string]interface{}{"value": 21.5}})
+
+	if err := e.Flush(context.Background()); err == nil {
+		t.Fatal("Expected error from failing receiver")
+	}
+	if e.batcher.Len() != 1 {
+		t.Fatalf("Expected metric to be kept after failure, got %d pending", e.batcher.Len())
+	}
+
+	fail = false
+	if err := e.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if requests != 3 || e.batcher.Len() != 0 {
+		t.Errorf("Expected retry to succeed, got %d requests and %d pending", requests, e.batcher.Len())
+	}
+}
+
+func TestStopFlushes(t *testing.T) {
+	received := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+	}))
+	defer server.Close()
+
+	e := mustNew(t, config.OTLPConfig{Endpoint: server.URL, OutputOptions: config.OutputOptions{Interval: config.Duration(time.Hour)}}, "test")
+	e.Start()
+	e.Export(metrics.Metric{Name: "temperature", Fields: map[string]interface{}{"value": 21.5}})
+	e.Stop()
+
+	select {
+	case <-received:
+	default:
+		t.Error("Expected remaining metrics to be exported on stop")
+	}
+}
@@ -0,0 +1,268 @@
+// Package output provides the batching, compression and retry shared by the
+// network outputs (e.g. OTLP), so each output only has to encode its requests.
+//
+// A Batcher buffers metrics and hands them to a flush function in batches,
+// periodically and as soon as a batch is full. A Sender posts the encoded
+// batches, compresses them and retries transient failures with backoff.
+package output
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	defaultInterval    = 10 * time.Second
+	defaultBatchSize   = 1000
+	defaultBufferLimit = 10000
+)
+
+// FlushFunc sends a batch of metrics. A returned error keeps the batch for
+// the next flush, unless it wraps ErrRejected.
+type FlushFunc func(ctx context.Context, batch []metrics.Metric) error
+
+// Batcher buffers metrics and flushes them in batches of at most the batch
+// size, every interval and whenever a batch is full.
+type Batcher struct {
+	name        string
+	interval    time.Duration
+	batchSize   int
+	bufferLimit int
+	shadow      bool // drop failed batches and log errors at debug level only
+	flush       FlushFunc
+
+	mu      sync.Mutex
+	pending []metrics.Metric
+	dropped int // dropped since the last flush, for the log
+	stats   Stats
+
+	full      chan struct{}
+	cancel    context.CancelFunc
+	done      chan struct{}
+	startOnce sync.Once
+	flushMu   sync.Mutex // serializes flushes, so batches are sent in order
+}
+
+// Stats describes the state of an output, e.g. to see whether it falls behind.
+type Stats struct {
+	// Queued is the number of metrics waiting to be sent.
+	Queued int
+
+	// Dropped is the number of metrics dropped since the start, because the
+	// buffer was full or the receiver rejected them.
+	Dropped int64
+
+	// Failures is the number of failed flushes since the start.
+	Failures int64
+
+	// FlushDuration is how long the last flush took.
+	FlushDuration time.Duration
+
+	// LastSuccess is when metrics were last sent successfully, zero if never.
+	LastSuccess time.Time
+
+	// Shadow reports whether the output is a shadow output, see
+	// config.OutputOptions.
+	Shadow bool
+}
+
+// NewBatcher creates a batcher for the output with the given name, using the
+// batching options and defaults of opts.
+func NewBatcher(name string, opts config.OutputOptions, flush FlushFunc) *Batcher {
+	b := &Batcher{
+		name:        name,
+		interval:    opts.Interval.Duration(),
+		batchSize:   opts.BatchSize,
+		bufferLimit: opts.BufferLimit,
+		shadow:      opts.Shadow,
+		flush:       flush,
+		full:        make(chan struct{}, 1),
+	}
+	if b.interval <= 0 {
+		b.interval = defaultInterval
+	}
+	if b.batchSize <= 0 {
+		b.batchSize = defaultBatchSize
+	}
+	if b.bufferLimit <= 0 {
+		b.bufferLimit = defaultBufferLimit
+	}
+	if b.bufferLimit < b.batchSize {
+		b.bufferLimit = b.batchSize
+	}
+	return b
+}
+
+// Add buffers a metric. If the buffer limit is reached because the receiver
+// is unreachable, the metric is dropped.
+func (b *Batcher) Add(m metrics.Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending) >= b.bufferLimit {
+		b.dropped++
+		b.stats.Dropped++
+		return
+	}
+	b.pending = append(b.pending, m)
+	if len(b.pending) == b.batchSize {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Len returns the number of buffered metrics.
+func (b *Batcher) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending)
+}
+
+// Stats returns the state of the output.
+func (b *Batcher) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats
+	stats.Queued = len(b.pending)
+	stats.Shadow = b.shadow
+	return stats
+}
+
+// Start flushes every interval and whenever a batch is full until Stop is called.
+func (b *Batcher) Start() {
+	b.startOnce.Do(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		b.cancel = cancel
+		b.done = make(chan struct{})
+		go b.run(ctx)
+	})
+}
+
+// Stop ends the periodic flushes and flushes the remaining metrics.
+func (b *Batcher) Stop() {
+	if b.cancel == nil {
+		return
+	}
+	b.cancel()
+	<-b.done
+}
+
+// run flushes periodically, on full batches and once more when ctx is cancelled.
+func (b *Batcher) run(ctx context.Context) {
+	defer close(b.done)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.flushAndLog()
+		case <-b.full:
+			b.flushAndLog()
+		case <-ctx.Done():
+			b.flushAndLog()
+			return
+		}
+	}
+}
+
+// flushAndLog flushes the buffered metrics and logs failures.
+func (b *Batcher) flushAndLog() {
+	utils.WithPanicRecoveryAndContinue("Output flush", b.name, func() {
+		err := b.Flush(context.Background())
+		switch {
+		case err == nil:
+		case b.shadow:
+			utils.Debugf("[%s] shadow export failed: %v", b.name, err)
+		default:
+			utils.Errorf("[%s] export failed: %v", b.name, err)
+		}
+	})
+}
+
+// Flush sends all buffered metrics in batches of at most the batch size. If a
+// batch fails, it and all following metrics are kept for the next flush, or
+// dropped for a shadow output. A rejected batch is dropped, as sending it
+// again would fail the same way.
+func (b *Batcher) Flush(ctx context.Context) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	pending := b.pending
+	dropped := b.dropped
+	b.pending = nil
+	b.dropped = 0
+	b.mu.Unlock()
+
+	if dropped > 0 && !b.shadow {
+		utils.Warnf("[%s] output buffer full, dropped %d metrics", b.name, dropped)
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	var rejected error
+	for len(pending) > 0 {
+		n := min(len(pending), b.batchSize)
+		err := b.flush(ctx, pending[:n])
+		switch {
+		case errors.Is(err, ErrRejected):
+			if !b.shadow {
+				utils.Warnf("[%s] dropped %d metrics: %v", b.name, n, err)
+			}
+			rejected = err
+			b.record(start, n, err)
+		case err != nil && b.shadow:
+			// Don't pile up metrics for a trial receiver that is down
+			b.record(start, len(pending), err)
+			return err
+		case err != nil:
+			b.requeue(pending)
+			b.record(start, 0, err)
+			return err
+		default:
+			utils.Debugf("[%s] exported %d metrics", b.name, n)
+			b.record(start, 0, nil)
+		}
+		pending = pending[n:]
+	}
+	return rejected
+}
+
+// record updates the stats after sending a batch that started a flush at
+// start, with the number of metrics dropped because of the result.
+func (b *Batcher) record(start time.Time, dropped int, err error) {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stats.FlushDuration = now.Sub(start)
+	b.stats.Dropped += int64(dropped)
+	if err != nil {
+		b.stats.Failures++
+		return
+	}
+	b.stats.LastSuccess = now
+}
+
+// requeue puts unsent metrics back in front of the buffer, dropping the
+// oldest ones beyond the buffer limit.
+func (b *Batcher) requeue(unsent []metrics.Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(unsent, b.pending...)
+	if excess := len(b.pending) - b.bufferLimit; excess > 0 {
+		b.dropped += excess
+		b.stats.Dropped += int64(excess)
+		b.pending = b.pending[excess:]
+	}
+}
@@ -0,0 +1,185 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// recordingFlush records the sizes of flushed batches and fails while err is set
+type recordingFlush struct {
+	mu    sync.Mutex
+	sizes []int
+	err   error
+}
+
+func (r *recordingFlush) flush(ctx context.Context, batch []metrics.Metric) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.sizes = append(r.sizes, len(batch))
+	return nil
+}
+
+func (r *recordingFlush) batches() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.sizes...)
+}
+
+func testMetric(i int) metrics.Metric {
+	return metrics.Metric{Name: "test", Fields: map[string]interface{}{"value": i}}
+}
+
+func TestBatcherSplitsBatches(t *testing.T) {
+	r := &recordingFlush{}
+	b := NewBatcher("test", config.OutputOptions{BatchSize: 2}, r.flush)
+	for i := 0; i < 5; i++ {
+		b.Add(testMetric(i))
+	}
+
+	if err := b.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if got := fmt.Sprint(r.batches()); got != "[2 2 1]" {
+		t.Errorf("Expected batches [2 2 1], got %s", got)
+	}
+}
+
+func TestBatcherFlushesFullBatch(t *testing.T) {
+	r := &recordingFlush{}
+	b := NewBatcher("test", config.OutputOptions{BatchSize: 3, Interval: config.Duration(time.Hour)}, r.flush)
+	b.Start()
+	defer b.Stop()
+
+	for i := 0; i < 3; i++ {
+		b.Add(testMetric(i))
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(r.batches()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := fmt.Sprint(r.batches()); got != "[3]" {
+		t.Errorf("Expected full batch to be flushed before the interval, got %s", got)
+	}
+}
+
+func TestBatcherKeepsFailedBatches(t *testing.T) {
+	r := &recordingFlush{err: errors.New("connection refused")}
+	b := NewBatcher("test", config.OutputOptions{BatchSize: 2, BufferLimit: 3}, r.flush)
+	for i := 0; i < 3; i++ {
+		b.Add(testMetric(i))
+	}
+
+	if err := b.Flush(context.Background()); err == nil {
+		t.Fatal("Expected flush error")
+	}
+	if b.Len() != 3 {
+		t.Fatalf("Expected 3 metrics to be kept, got %d", b.Len())
+	}
+
+	// The buffer limit drops new metrics while the receiver is down
+	b.Add(testMetric(3))
+	if b.Len() != 3 {
+		t.Errorf("Expected buffer limit of 3, got %d", b.Len())
+	}
+
+	r.mu.Lock()
+	r.err = nil
+	r.mu.Unlock()
+	if err := b.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if got := fmt.Sprint(r.batches()); got != "[2 1]" || b.Len() != 0 {
+		t.Errorf("Expected batches [2 1] and empty buffer, got %s and %d", got, b.Len())
+	}
+}
+
+func TestBatcherDropsRejectedBatches(t *testing.T) {
+	r := &recordingFlush{err: fmt.Errorf("%w: status 400", ErrRejected)}
+	b := NewBatcher("test", config.OutputOptions{}, r.flush)
+	b.Add(testMetric(1))
+
+	if err := b.Flush(context.Background()); !errors.Is(err, ErrRejected) {
+		t.Fatalf("Expected rejected error, got %v", err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("Expected rejected batch to be dropped, got %d pending", b.Len())
+	}
+}
+
+func TestBatcherStopFlushes(t *testing.T) {
+	r := &recordingFlush{}
+	b := NewBatcher("test", config.OutputOptions{Interval: config.Duration(time.Hour)}, r.flush)
+	b.Start()
+	b.Add(testMetric(1))
+	b.Stop()
+
+	if got := fmt.Sprint(r.batches()); got != "[1]" {
+		t.Errorf("Expected remaining metrics to be flushed on stop, got %s", got)
+	}
+}
+
+func TestBatcherStats(t *testing.T) {
+	r := &recordingFlush{err: errors.New("connection refused")}
+	b := NewBatcher("test", config.OutputOptions{BatchSize: 2, BufferLimit: 3}, r.flush)
+	for i := 0; i < 4; i++ {
+		b.Add(testMetric(i))
+	}
+
+	if err := b.Flush(context.Background()); err == nil {
+		t.Fatal("Expected flush to fail")
+	}
+	stats := b.Stats()
+	if stats.Queued != 3 || stats.Dropped != 1 || stats.Failures != 1 || !stats.LastSuccess.IsZero() {
+		t.Errorf("Unexpected stats after failed flush: %+v", stats)
+	}
+
+	r.mu.Lock()
+	r.err = nil
+	r.mu.Unlock()
+	before := time.Now()
+	if err := b.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	stats = b.Stats()
+	if stats.Queued != 0 || stats.Failures != 1 || stats.LastSuccess.Before(before) {
+		t.Errorf("Unexpected stats after successful flush: %+v", stats)
+	}
+}
+
+func TestBatcherShadowDropsFailedBatches(t *testing.T) {
+	r := &recordingFlush{err: errors.New("connection refused")}
+	b := NewBatcher("test", config.OutputOptions{BatchSize: 2, Shadow: true}, r.flush)
+	for i := 0; i < 3; i++ {
+		b.Add(testMetric(i))
+	}
+
+	if err := b.Flush(context.Background()); err == nil {
+		t.Fatal("Expected flush to fail")
+	}
+	stats := b.Stats()
+	if stats.Queued != 0 || stats.Dropped != 3 || stats.Failures != 1 || !stats.Shadow {
+		t.Errorf("Expected the failed metrics to be dropped, got %+v", stats)
+	}
+
+	r.mu.Lock()
+	r.err = nil
+	r.mu.Unlock()
+	b.Add(testMetric(3))
+	if err := b.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if got := fmt.Sprint(r.batches()); got != "[1]" {
+		t.Errorf("Expected only the new metric to be sent, got %s", got)
+	}
+}
@@ -0,0 +1,170 @@
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// ErrRejected is returned for requests that must not be retried, because the
+// receiver rejected them (e.g. with 400 Bad Request) or the destination is not
+// in the network allowlist.
+var ErrRejected = errors.New("request rejected")
+
+const (
+	defaultTimeout    = 10 * time.Second
+	defaultMaxRetries = 3
+	initialBackoff    = time.Second
+	maxBackoff        = 30 * time.Second
+)
+
+// Sender posts request bodies to an HTTP endpoint with the compression,
+// headers and retries of the output options.
+type Sender struct {
+	name        string
+	endpoint    string
+	headers     map[string]string
+	compression string
+	timeout     time.Duration
+	maxRetries  int
+	client      *http.Client
+
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// NewSender creates a sender for the output with the given name. Requests are
+// made through utils.OutboundTransport, so they are subject to the network
+// allowlist and recorded in the audit log.
+func NewSender(name, endpoint string, opts config.OutputOptions) *Sender {
+	s := &Sender{
+		name:           name,
+		endpoint:       endpoint,
+		headers:        opts.Headers,
+		compression:    opts.Compression,
+		timeout:        opts.Timeout.Duration(),
+		maxRetries:     opts.MaxRetries,
+		client:         &http.Client{Transport: utils.OutboundTransport(name, nil)},
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+	}
+	if s.timeout <= 0 {
+		s.timeout = defaultTimeout
+	}
+	if s.maxRetries <= 0 {
+		s.maxRetries = defaultMaxRetries
+	}
+	if opts.Shadow {
+		s.maxRetries = 0
+	}
+	return s
+}
+
+// SetBackoff sets the initial and maximum wait between retries (for testing
+// purposes).
+func (s *Sender) SetBackoff(initial, max time.Duration) {
+	s.initialBackoff = initial
+	s.maxBackoff = max
+}
+
+// Send posts body with the given content type. Transport errors and 429 and
+// 5xx responses are retried with exponential backoff, honoring Retry-After.
+// Other error responses fail immediately with an error wrapping ErrRejected.
+func (s *Sender) Send(ctx context.Context, contentType string, body []byte) error {
+	encoding := ""
+	if s.compression == config.CompressionGzip {
+		compressed, err := gzipBytes(body)
+		if err != nil {
+			return fmt.Errorf("failed to compress request: %w", err)
+		}
+		body = compressed
+		encoding = "gzip"
+	}
+
+	backoff := s.initialBackoff
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := s.post(ctx, contentType, encoding, body)
+		if err == nil {
+			return nil
+		}
+		if retryAfter < 0 || attempt >= s.maxRetries {
+			return err
+		}
+
+		wait := backoff
+		if retryAfter > 0 {
+			wait = min(retryAfter, s.maxBackoff)
+		}
+		utils.Debugf("[%s] request failed, retrying in %v: %v", s.name, wait, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff = min(2*backoff, s.maxBackoff)
+	}
+}
+
+// post makes a single request. On failure it returns whether the request may
+// be retried: a negative duration if not, otherwise the wait requested by the
+// receiver with Retry-After, or zero.
+func (s *Sender) post(ctx context.Context, contentType, encoding string, body []byte) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if errors.Is(err, utils.ErrDestinationBlocked) {
+			return -1, fmt.Errorf("%w: %w", ErrRejected, err)
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return 0, nil
+	}
+
+	text, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("receiver returned status %d: %s", resp.StatusCode, bytes.TrimSpace(text))
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return -1, fmt.Errorf("%w: %w", ErrRejected, err)
+	}
+	if seconds, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); parseErr == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, err
+	}
+	return 0, err
+}
+
+// gzipBytes compresses data with gzip.
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,126 @@
+package output
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+)
+
+func TestSenderGzip(t *testing.T) {
+	var body, encoding, token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		token = r.Header.Get("X-Token")
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("Expected gzip body: %v", err)
+			return
+		}
+		data, _ := io.ReadAll(reader)
+		body = string(data)
+	}))
+	defer server.Close()
+
+	s := NewSender("test", server.URL, config.OutputOptions{
+		Compression: config.CompressionGzip,
+		Headers:     map[string]string{"X-Token": "abc"},
+	})
+	if err := s.Send(context.Background(), "application/json", []byte(`{"a":1}`)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if encoding != "gzip" || token != "abc" || body != `{"a":1}` {
+		t.Errorf("Unexpected request: encoding=%q token=%q body=%q", encoding, token, body)
+	}
+}
+
+func TestSenderRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantRequests int32
+		wantErr      bool
+		wantRejected bool
+	}{
+		{"success after 503", []int{503, 200}, 2, false, false},
+		{"success after 429", []int{429, 429, 204}, 3, false, false},
+		{"retries exhausted", []int{500, 500, 500}, 3, true, false},
+		{"client error not retried", []int{400}, 1, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := requests.Add(1)
+				w.WriteHeader(tt.statuses[min(int(n), len(tt.statuses))-1])
+			}))
+			defer server.Close()
+
+			s := NewSender("test", server.URL, config.OutputOptions{MaxRetries: 2})
+			s.SetBackoff(time.Millisecond, 2*time.Millisecond)
+			err := s.Send(context.Background(), "text/plain", []byte("x"))
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Unexpected error state: %v", err)
+			}
+			if errors.Is(err, ErrRejected) != tt.wantRejected {
+				t.Errorf("Expected rejected=%v, got %v", tt.wantRejected, err)
+			}
+			if requests.Load() != tt.wantRequests {
+				t.Errorf("Expected %d requests, got %d", tt.wantRequests, requests.Load())
+			}
+		})
+	}
+}
+
+func TestSenderRetryAfter(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	s := NewSender("test", server.URL, config.OutputOptions{})
+	// Retry-After is capped at the maximum backoff
+	s.SetBackoff(time.Millisecond, 10*time.Millisecond)
+
+	start := time.Now()
+	if err := s.Send(context.Background(), "text/plain", []byte("x")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Expected Retry-After to be capped, took %v", time.Since(start))
+	}
+	if requests.Load() != 2 {
+		t.Errorf("Expected 2 requests, got %d", requests.Load())
+	}
+}
+
+func TestSenderShadowDoesNotRetry(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	s := NewSender("test", server.URL, config.OutputOptions{Shadow: true, MaxRetries: 5})
+	s.SetBackoff(time.Millisecond, time.Millisecond)
+	if err := s.Send(context.Background(), "application/json", []byte(`{}`)); err == nil {
+		t.Fatal("Expected error for 503 response")
+	}
+	if requests.Load() != 1 {
+		t.Errorf("Expected a single request for a shadow output, got %d", requests.Load())
+	}
+}
@@ -0,0 +1,162 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the absence tracker, which sends a final marker for a
+// device that stopped sending a measurement, e.g. a plug that was unplugged,
+// instead of letting its series just go silent.
+package processors
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// defaultAbsenceField is the field of the markers if none is configured
+	defaultAbsenceField = "online"
+
+	// minAbsenceCheck and maxAbsenceCheck bound the interval the tracker is
+	// checked for absent devices at
+	minAbsenceCheck = time.Second
+	maxAbsenceCheck = time.Minute
+)
+
+// absenceKey identifies a measurement of a device of a module.
+type absenceKey struct {
+	module      string
+	measurement string
+	device      string
+}
+
+// absenceState holds when a measurement of a device was last seen and the
+// tags it was last seen with.
+type absenceState struct {
+	tags     map[string]string
+	lastSeen time.Time
+	absent   bool
+}
+
+// AbsenceTracker tracks when the devices last sent each measurement and
+// creates a marker with the field set to 0 once a device was silent for the
+// TTL of the measurement. The first metric after the device is back gets the
+// field set to 1. Only metrics with a device tag are tracked.
+type AbsenceTracker struct {
+	ttl          time.Duration
+	measurements map[string]time.Duration
+	field        string
+
+	mu     sync.Mutex
+	series map[absenceKey]*absenceState
+}
+
+// NewAbsenceTracker creates an absence tracker from the configuration, or
+// nil if no TTL is configured.
+func NewAbsenceTracker(cfg config.AbsenceConfig) *AbsenceTracker {
+	at := &AbsenceTracker{
+		ttl:          cfg.TTL.Duration(),
+		measurements: make(map[string]time.Duration, len(cfg.Measurements)),
+		field:        cfg.Field,
+		series:       make(map[absenceKey]*absenceState),
+	}
+	for measurement, ttl := range cfg.Measurements {
+		if ttl > 0 {
+			at.measurements[measurement] = ttl.Duration()
+		}
+	}
+	if at.ttl <= 0 && len(at.measurements) == 0 {
+		return nil
+	}
+	if at.field == "" {
+		at.field = defaultAbsenceField
+	}
+	return at
+}
+
+// timeToLive returns the TTL of a measurement, zero if it isn't tracked.
+func (at *AbsenceTracker) timeToLive(measurement string) time.Duration {
+	if ttl, ok := at.measurements[measurement]; ok {
+		return ttl
+	}
+	return max(at.ttl, 0)
+}
+
+// CheckInterval returns how often Expired should be called: a quarter of the
+// shortest TTL, at least a second and at most a minute.
+func (at *AbsenceTracker) CheckInterval() time.Duration {
+	shortest := at.ttl
+	for _, ttl := range at.measurements {
+		if shortest <= 0 || ttl < shortest {
+			shortest = ttl
+		}
+	}
+	return min(max(shortest/4, minAbsenceCheck), maxAbsenceCheck)
+}
+
+// Seen records a metric of a module. If the device was absent, the metric is
+// returned with a copy of its fields including the field set to 1.
+func (at *AbsenceTracker) Seen(module string, m metrics.Metric, now time.Time) metrics.Metric {
+	device := m.Tags["device"]
+	if at == nil || device == "" || at.timeToLive(m.Name) <= 0 {
+		return m
+	}
+
+	at.mu.Lock()
+	key := absenceKey{module: module, measurement: m.Name, device: device}
+	state, ok := at.series[key]
+	if !ok {
+		state = &absenceState{}
+		at.series[key] = state
+	}
+	wasAbsent := state.absent
+	state.tags = m.Tags // not copied, as processors copy the tags they change
+	state.lastSeen = now
+	state.absent = false
+	at.mu.Unlock()
+
+	if wasAbsent {
+		fields := make(map[string]interface{}, len(m.Fields)+1)
+		for k, v := range m.Fields {
+			fields[k] = v
+		}
+		fields[at.field] = 1
+		m.Fields = fields
+	}
+	return m
+}
+
+// Expired returns a marker for each measurement of a device that was silent
+// for its TTL, sorted by measurement and device. The
+// marker has the tags of the last metric and the field set to 0. A device is
+// marked once until it sends the measurement again.
+func (at *AbsenceTracker) Expired(now time.Time) []metrics.Metric {
+	if at == nil {
+		return nil
+	}
+
+	at.mu.Lock()
+	var markers []metrics.Metric
+	for key, state := range at.series {
+		if state.absent || now.Sub(state.lastSeen) < at.timeToLive(key.measurement) {
+			continue
+		}
+		state.absent = true
+		markers = append(markers, metrics.Metric{
+			Name:      key.measurement,
+			Tags:      state.tags,
+			Fields:    map[string]interface{}{at.field: 0},
+			Timestamp: now,
+		})
+	}
+	at.mu.Unlock()
+
+	sort.Slice(markers, func(i, j int) bool {
+		if markers[i].Name != markers[j].Name {
+			return markers[i].Name < markers[j].Name
+		}
+		return markers[i].Tags["device"] < markers[j].Tags["device"]
+	})
+	return markers
+}
@@ -0,0 +1,70 @@
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestAbsenceTracker(t *testing.T) {
+	tracker := NewAbsenceTracker(config.AbsenceConfig{
+		TTL:          config.Duration(5 * time.Minute),
+		Measurements: map[string]config.Duration{"climate": config.Duration(time.Hour)},
+	})
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	plug := metrics.Metric{Name: "electricity", Tags: map[string]string{"device": "plug", "vendor": "tasmota"}, Fields: map[string]interface{}{"power": 42.0}}
+	sensor := metrics.Metric{Name: "climate", Tags: map[string]string{"device": "sensor"}, Fields: map[string]interface{}{"temperature": 21.5}}
+	status := metrics.Metric{Name: "connection_status", Tags: map[string]string{"module": "tasmota"}, Fields: map[string]interface{}{"status": 1}}
+
+	for _, m := range []metrics.Metric{plug, sensor, status} {
+		if got := tracker.Seen("tasmota", m, start); len(got.Fields) != len(m.Fields) {
+			t.Errorf("Expected fields of present device to be unchanged, got %v", got.Fields)
+		}
+	}
+
+	if markers := tracker.Expired(start.Add(4 * time.Minute)); len(markers) != 0 {
+		t.Errorf("Expected no markers within the TTL, got %v", markers)
+	}
+	markers := tracker.Expired(start.Add(5 * time.Minute))
+	if len(markers) != 1 || markers[0].Name != "electricity" || markers[0].Tags["vendor"] != "tasmota" || markers[0].Fields["online"] != 0 {
+		t.Fatalf("Expected marker of the plug, got %v", markers)
+	}
+	if markers := tracker.Expired(start.Add(10 * time.Minute)); len(markers) != 0 {
+		t.Errorf("Expected the plug to be marked once, got %v", markers)
+	}
+	if markers := tracker.Expired(start.Add(time.Hour)); len(markers) != 1 || markers[0].Tags["device"] != "sensor" {
+		t.Errorf("Expected marker of the sensor after its TTL, got %v", markers)
+	}
+
+	back := tracker.Seen("tasmota", plug, start.Add(2*time.Hour))
+	if back.Fields["online"] != 1 || back.Fields["power"] != 42.0 {
+		t.Errorf("Expected online field when the plug is back, got %v", back.Fields)
+	}
+	if _, ok := plug.Fields["online"]; ok {
+		t.Error("Expected the original fields not to be modified")
+	}
+	if again := tracker.Seen("tasmota", plug, start.Add(2*time.Hour)); len(again.Fields) != 1 {
+		t.Errorf("Expected online field only after absence, got %v", again.Fields)
+	}
+}
+
+func TestAbsenceTrackerConfig(t *testing.T) {
+	if tracker := NewAbsenceTracker(config.AbsenceConfig{Field: "up"}); tracker != nil {
+		t.Error("Expected no tracker without TTL")
+	}
+	var tracker *AbsenceTracker
+	if markers := tracker.Expired(time.Now()); markers != nil {
+		t.Error("Expected no markers of nil tracker")
+	}
+
+	tracker = NewAbsenceTracker(config.AbsenceConfig{TTL: config.Duration(time.Hour), Measurements: map[string]config.Duration{"climate": config.Duration(20 * time.Second)}})
+	if interval := tracker.CheckInterval(); interval != 5*time.Second {
+		t.Errorf("Expected check interval of 5s, got %v", interval)
+	}
+	tracker = NewAbsenceTracker(config.AbsenceConfig{TTL: config.Duration(time.Hour)})
+	if interval := tracker.CheckInterval(); interval != time.Minute {
+		t.Errorf("Expected check interval of 1m, got %v", interval)
+	}
+}
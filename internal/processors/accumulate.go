@@ -0,0 +1,252 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the accumulator, which sums power or counter fields into
+// daily and weekly totals in a configurable timezone. Devices such as OpenDTU
+// reset their daily yield at device-local midnight, which doesn't necessarily
+// match the timezone the totals are reported in.
+package processors
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// defaultAccumulateInterval is the default cadence for emitting totals
+	defaultAccumulateInterval = time.Minute
+
+	// defaultAccumulateMaxGap is the default longest integrated gap between power samples
+	defaultAccumulateMaxGap = 10 * time.Minute
+
+	// accumulateStoragePrefix is the storage key prefix of accumulator states
+	accumulateStoragePrefix = "accumulate."
+)
+
+// accumulateRule is an AccumulateRule with parsed settings.
+type accumulateRule struct {
+	config.AccumulateRule
+	location *time.Location
+	interval time.Duration
+	maxGap   time.Duration
+}
+
+// accumulatorState is the persisted state of an accumulated series.
+type accumulatorState struct {
+	Last      float64   `json:"last"`
+	LastTime  time.Time `json:"last_time"`
+	Day       string    `json:"day"`
+	Today     float64   `json:"today"`
+	Week      string    `json:"week"`
+	WeekTotal float64   `json:"week_total"`
+
+	// emitted is when the totals were last added to a metric
+	emitted time.Time
+}
+
+// Accumulator adds daily and weekly totals of power or counter fields to metrics
+// as <field>_today and <field>_week. The totals are persisted in storage, so they
+// survive restarts of the agent.
+type Accumulator struct {
+	rules   []accumulateRule
+	storage *utils.Storage
+	clock   utils.Clock // for metrics without timestamp
+
+	mu     sync.Mutex
+	series map[string]*accumulatorState
+}
+
+// NewAccumulator creates an accumulator with the given rules.
+// If storage is nil, the totals are kept in memory only.
+func NewAccumulator(rules []config.AccumulateRule, storage *utils.Storage) *Accumulator {
+	parsed := make([]accumulateRule, 0, len(rules))
+	for _, rule := range rules {
+		parsed = append(parsed, parseAccumulateRule(rule))
+	}
+
+	return &Accumulator{
+		rules:   parsed,
+		storage: storage,
+		clock:   utils.SystemClock,
+		series:  make(map[string]*accumulatorState),
+	}
+}
+
+// parseAccumulateRule parses the timezone and durations of a rule, falling back
+// to the defaults for invalid values.
+func parseAccumulateRule(rule config.AccumulateRule) accumulateRule {
+	parsed := accumulateRule{
+		AccumulateRule: rule,
+		location:       utils.Location(),
+		interval:       defaultAccumulateInterval,
+		maxGap:         defaultAccumulateMaxGap,
+	}
+
+	// Without a mode, it is chosen per field from the metric kind in Process
+	if rule.Mode != "" && rule.Mode != config.AccumulateModePower && rule.Mode != config.AccumulateModeCounter {
+		utils.Warnf("[pipeline] unknown accumulate mode '%s', using '%s'", rule.Mode, config.AccumulateModePower)
+		parsed.Mode = config.AccumulateModePower
+	}
+	if rule.Timezone != "" {
+		if location, err := time.LoadLocation(rule.Timezone); err == nil {
+			parsed.location = location
+		} else {
+			utils.Warnf("[pipeline] invalid accumulate timezone '%s', using the global timezone: %v", rule.Timezone, err)
+		}
+	}
+	if rule.Interval > 0 {
+		parsed.interval = rule.Interval.Duration()
+	}
+	if rule.MaxGap > 0 {
+		parsed.maxGap = rule.MaxGap.Duration()
+	}
+	return parsed
+}
+
+// Process implements the Processor interface.
+func (a *Accumulator) Process(m metrics.Metric) (metrics.Metric, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	timestamp := m.Timestamp
+	if timestamp.IsZero() {
+		timestamp = a.clock.Now()
+	}
+
+	var fields map[string]interface{}
+	var kinds map[string]metrics.Kind
+	for field, value := range m.Fields {
+		current, ok := toFloat(value)
+		if !ok {
+			continue
+		}
+		rule, ok := a.matchRule(m.Name, field)
+		if !ok {
+			continue
+		}
+		if rule.Mode == "" {
+			rule.Mode = accumulateMode(m.FieldKind(field))
+		}
+
+		key := seriesKey(m, field)
+		state := a.getState(key)
+		state.add(current, timestamp, rule)
+
+		if !state.emitted.IsZero() && timestamp.Sub(state.emitted) < rule.interval {
+			continue
+		}
+		state.emitted = timestamp
+		a.saveState(key, state)
+
+		if fields == nil {
+			fields = copyFields(m.Fields)
+			kinds = copyFieldKinds(m.FieldKinds)
+		}
+		fields[field+"_today"] = state.Today
+		fields[field+"_week"] = state.WeekTotal
+		kinds[field+"_today"] = metrics.KindCumulative
+		kinds[field+"_week"] = metrics.KindCumulative
+	}
+
+	if fields != nil {
+		m.Fields = fields
+		m.FieldKinds = kinds
+	}
+	return m, true
+}
+
+// accumulateMode returns the accumulation mode for a field of the given kind:
+// counters are summed, gauges are integrated as power.
+func accumulateMode(kind metrics.Kind) string {
+	if kind.IsMonotonic() {
+		return config.AccumulateModeCounter
+	}
+	return config.AccumulateModePower
+}
+
+// matchRule returns the first rule matching the measurement and field.
+func (a *Accumulator) matchRule(measurement, field string) (accumulateRule, bool) {
+	for _, rule := range a.rules {
+		if matchesField(rule.Measurement, rule.Fields, measurement, field) {
+			return rule, true
+		}
+	}
+	return accumulateRule{}, false
+}
+
+// getState returns the state of a series, loading it from storage on first use.
+func (a *Accumulator) getState(key string) *accumulatorState {
+	if state, exists := a.series[key]; exists {
+		return state
+	}
+
+	state := &accumulatorState{}
+	if a.storage != nil {
+		if stored := a.storage.Get(accumulateStoragePrefix + key); stored != nil {
+			// Stored values are generic JSON maps, so decode them via JSON
+			if data, err := json.Marshal(stored); err == nil {
+				if err := json.Unmarshal(data, state); err != nil {
+					utils.Warnf("[pipeline] ignoring invalid accumulator state for %s: %v", key, err)
+					state = &accumulatorState{}
+				}
+			}
+		}
+	}
+	a.series[key] = state
+	return state
+}
+
+// saveState persists the state of a series.
+func (a *Accumulator) saveState(key string, state *accumulatorState) {
+	if a.storage == nil {
+		return
+	}
+	if err := a.storage.Set(accumulateStoragePrefix+key, *state); err != nil {
+		utils.Warnf("[pipeline] failed to persist accumulator state for %s: %v", key, err)
+	}
+}
+
+// add accumulates a new value into the totals, resetting them at day and week boundaries.
+func (s *accumulatorState) add(value float64, timestamp time.Time, rule accumulateRule) {
+	local := timestamp.In(rule.location)
+	if day := local.Format("2006-01-02"); day != s.Day {
+		s.Day = day
+		s.Today = 0
+	}
+	year, week := local.ISOWeek()
+	if weekKey := fmt.Sprintf("%d-W%02d", year, week); weekKey != s.Week {
+		s.Week = weekKey
+		s.WeekTotal = 0
+	}
+
+	if !s.LastTime.IsZero() {
+		increment := s.increment(value, timestamp, rule)
+		s.Today += increment
+		s.WeekTotal += increment
+	}
+	s.Last = value
+	s.LastTime = timestamp
+}
+
+// increment returns the amount to add to the totals for a new value.
+func (s *accumulatorState) increment(value float64, timestamp time.Time, rule accumulateRule) float64 {
+	if rule.Mode == config.AccumulateModeCounter {
+		if value < s.Last {
+			// Counter was reset, e.g. the device's daily yield at midnight
+			return value
+		}
+		return value - s.Last
+	}
+
+	// Integrate power using the trapezoidal rule
+	elapsed := timestamp.Sub(s.LastTime)
+	if elapsed <= 0 || elapsed > rule.maxGap {
+		return 0
+	}
+	return (s.Last + value) / 2 * elapsed.Hours()
+}
@@ -0,0 +1,132 @@
+package processors
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func assertTotal(t *testing.T, m metrics.Metric, field string, expected float64) {
+	t.Helper()
+	value, ok := m.Fields[field].(float64)
+	if !ok {
+		t.Fatalf("Expected field %s, got fields %v", field, m.Fields)
+	}
+	if math.Abs(value-expected) > 1e-9 {
+		t.Errorf("Expected %s=%v, got %v", field, expected, value)
+	}
+}
+
+func TestAccumulatorPower(t *testing.T) {
+	acc := NewAccumulator([]config.AccumulateRule{
+		{Measurement: "electricity", Fields: []string{"power"}, Timezone: "UTC", Interval: config.Duration(time.Nanosecond), MaxGap: config.Duration(time.Hour)},
+	}, nil)
+	start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	power := func(value float64, offset time.Duration) metrics.Metric {
+		m, _ := acc.Process(metrics.Metric{
+			Name:      "electricity",
+			Tags:      map[string]string{"device": "plug1"},
+			Fields:    map[string]interface{}{"power": value},
+			Timestamp: start.Add(offset),
+		})
+		return m
+	}
+
+	assertTotal(t, power(100, 0), "power_today", 0)
+	// 100 W -> 300 W over 30 minutes: 200 W average for 0.5 h = 100 Wh
+	m := power(300, 30*time.Minute)
+	assertTotal(t, m, "power_today", 100)
+	assertTotal(t, m, "power_week", 100)
+
+	// Gaps longer than max_gap are not integrated
+	assertTotal(t, power(300, 2*time.Hour), "power_today", 100)
+}
+
+func TestAccumulatorCounterRollover(t *testing.T) {
+	acc := NewAccumulator([]config.AccumulateRule{
+		{Fields: []string{"YieldDay"}, Mode: config.AccumulateModeCounter, Timezone: "Europe/Berlin", Interval: config.Duration(time.Nanosecond)},
+	}, nil)
+	yield := func(value float64, timestamp time.Time) metrics.Metric {
+		m, _ := acc.Process(metrics.Metric{
+			Name:      "inverter",
+			Fields:    map[string]interface{}{"YieldDay": value},
+			Timestamp: timestamp,
+		})
+		return m
+	}
+
+	// Wednesday evening in Berlin, the device still counts
+	yield(1000, time.Date(2026, 10, 14, 19, 0, 0, 0, time.UTC))
+	assertTotal(t, yield(1500, time.Date(2026, 10, 14, 20, 0, 0, 0, time.UTC)), "YieldDay_today", 500)
+
+	// Past midnight in Berlin the totals roll over, although the device hasn't reset yet
+	m := yield(1600, time.Date(2026, 10, 14, 22, 30, 0, 0, time.UTC))
+	assertTotal(t, m, "YieldDay_today", 100)
+	assertTotal(t, m, "YieldDay_week", 600)
+
+	// The device resets later, which counts as a counter reset
+	m = yield(50, time.Date(2026, 10, 15, 1, 0, 0, 0, time.UTC))
+	assertTotal(t, m, "YieldDay_today", 150)
+	assertTotal(t, m, "YieldDay_week", 650)
+
+	// Monday starts a new week
+	m = yield(80, time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC))
+	assertTotal(t, m, "YieldDay_week", 30)
+}
+
+func TestAccumulatorInterval(t *testing.T) {
+	acc := NewAccumulator([]config.AccumulateRule{{Mode: config.AccumulateModeCounter, Interval: config.Duration(time.Minute)}}, nil)
+	start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+
+	emitted := 0
+	for i := 0; i < 12; i++ {
+		m, keep := acc.Process(metrics.Metric{
+			Name:      "counter",
+			Fields:    map[string]interface{}{"value": float64(i)},
+			Timestamp: start.Add(time.Duration(i) * 10 * time.Second),
+		})
+		if !keep {
+			t.Fatal("Expected accumulator to keep metrics")
+		}
+		if _, exists := m.Fields["value_today"]; exists {
+			emitted++
+		}
+	}
+	if emitted != 2 {
+		t.Errorf("Expected totals to be emitted twice in 2 minutes, got %d", emitted)
+	}
+}
+
+func TestAccumulatorPersistence(t *testing.T) {
+	storageConfig := &utils.StorageConfig{ModuleName: "pipeline", PreferredDir: t.TempDir(), FallbackDir: t.TempDir()}
+	storage, err := utils.NewStorageWithConfig(storageConfig)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	rules := []config.AccumulateRule{{Mode: config.AccumulateModeCounter, Timezone: "UTC", Interval: config.Duration(time.Nanosecond)}}
+	start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	counter := func(acc *Accumulator, value float64, offset time.Duration) metrics.Metric {
+		m, _ := acc.Process(metrics.Metric{Name: "counter", Fields: map[string]interface{}{"value": value}, Timestamp: start.Add(offset)})
+		return m
+	}
+
+	acc := NewAccumulator(rules, storage)
+	counter(acc, 10, 0)
+	counter(acc, 15, time.Minute)
+
+	// A new accumulator, e.g. after a restart, continues with the stored totals
+	storage, err = utils.NewStorageWithConfig(storageConfig)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	if filepath.Dir(storage.GetFilePath()) != storageConfig.PreferredDir {
+		t.Fatalf("Unexpected storage path: %s", storage.GetFilePath())
+	}
+	acc = NewAccumulator(rules, storage)
+	assertTotal(t, counter(acc, 20, 2*time.Minute), "value_today", 10)
+}
@@ -0,0 +1,88 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the device aliaser, which gives a physical device that
+// is collected by several modules (e.g. a plug via tasmota and as Home
+// Assistant entity) the same device tag in all of them.
+package processors
+
+import (
+	"sort"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// defaultAliasTag is the tag looked up and written if no tags are configured
+const defaultAliasTag = "device"
+
+// DeviceAliaser replaces device identifiers that are configured as alias
+// with their canonical identifier.
+type DeviceAliaser struct {
+	canonical map[string]string // canonical identifier by alias
+	tags      []string
+	tag       string
+	aliasTag  string
+}
+
+// NewDeviceAliaser creates a device aliaser from the configuration. An alias
+// configured for several devices is assigned to the first of them in
+// alphabetical order.
+func NewDeviceAliaser(cfg config.DeviceAliasesConfig) *DeviceAliaser {
+	da := &DeviceAliaser{
+		canonical: make(map[string]string),
+		tags:      cfg.Tags,
+		tag:       cfg.Tag,
+		aliasTag:  cfg.AliasTag,
+	}
+	if len(da.tags) == 0 {
+		da.tags = []string{defaultAliasTag}
+	}
+	if da.tag == "" {
+		da.tag = defaultAliasTag
+	}
+
+	devices := make([]string, 0, len(cfg.Devices))
+	for device := range cfg.Devices {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+	for _, device := range devices {
+		for _, alias := range cfg.Devices[device] {
+			if other, exists := da.canonical[alias]; exists && other != device {
+				utils.Warnf("[pipeline] device alias '%s' is configured for %s and %s, using %s", alias, other, device, other)
+				continue
+			}
+			da.canonical[alias] = device
+		}
+	}
+	return da
+}
+
+// Process implements the Processor interface. The first configured tag whose
+// value is an alias decides the canonical identifier. The tags of the metric
+// are only copied if an alias is found.
+func (da *DeviceAliaser) Process(m metrics.Metric) (metrics.Metric, bool) {
+	for _, tag := range da.tags {
+		alias, ok := m.Tags[tag]
+		if !ok {
+			continue
+		}
+		device, ok := da.canonical[alias]
+		if !ok {
+			continue
+		}
+
+		tags := make(map[string]string, len(m.Tags)+1)
+		for k, v := range m.Tags {
+			tags[k] = v
+		}
+		tags[da.tag] = device
+		if da.aliasTag != "" {
+			tags[da.aliasTag] = alias
+		}
+		m.Tags = tags
+		break
+	}
+	return m, true
+}
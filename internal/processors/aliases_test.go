@@ -0,0 +1,64 @@
+package processors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestDeviceAliaser(t *testing.T) {
+	aliaser := NewDeviceAliaser(config.DeviceAliasesConfig{
+		Devices: map[string][]string{
+			"washer": {"tasmota_A1B2C3", "washer_plug"},
+			"dryer":  {"tasmota_D4E5F6", "washer_plug"},
+		},
+		AliasTag: "alias",
+	})
+
+	tests := []struct {
+		name string
+		tags map[string]string
+		want map[string]string
+	}{
+		{"tasmota", map[string]string{"device": "tasmota_A1B2C3", "vendor": "tasmota"},
+			map[string]string{"device": "washer", "alias": "tasmota_A1B2C3", "vendor": "tasmota"}},
+		{"first device wins duplicate alias", map[string]string{"device": "washer_plug"},
+			map[string]string{"device": "dryer", "alias": "washer_plug"}},
+		{"unknown device", map[string]string{"device": "fridge"}, map[string]string{"device": "fridge"}},
+		{"no device tag", map[string]string{"vendor": "tasmota"}, map[string]string{"vendor": "tasmota"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := make(map[string]string)
+			for k, v := range tt.tags {
+				original[k] = v
+			}
+			m, keep := aliaser.Process(metrics.Metric{Name: "power", Tags: tt.tags, Fields: map[string]interface{}{"value": 1}})
+			if !keep {
+				t.Fatal("Expected metric to be kept")
+			}
+			if !reflect.DeepEqual(m.Tags, tt.want) {
+				t.Errorf("Expected tags %v, got %v", tt.want, m.Tags)
+			}
+			if !reflect.DeepEqual(tt.tags, original) {
+				t.Error("Expected the original tags not to be modified")
+			}
+		})
+	}
+}
+
+func TestDeviceAliaserTags(t *testing.T) {
+	aliaser := NewDeviceAliaser(config.DeviceAliasesConfig{
+		Devices: map[string][]string{"washer": {"switch.washer"}},
+		Tags:    []string{"device", "entity_id"},
+		Tag:     "device_id",
+	})
+
+	m, _ := aliaser.Process(metrics.Metric{Name: "power", Tags: map[string]string{"device": "plug", "entity_id": "switch.washer"}})
+	want := map[string]string{"device": "plug", "entity_id": "switch.washer", "device_id": "washer"}
+	if !reflect.DeepEqual(m.Tags, want) {
+		t.Errorf("Expected tags %v, got %v", want, m.Tags)
+	}
+}
@@ -0,0 +1,46 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the timestamp aligner, which truncates the timestamps of
+// a module's metrics to interval boundaries, so series of different modules
+// share timestamps and can be joined in Flux or SQL without windowing.
+package processors
+
+import (
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// TimestampAligner truncates metric timestamps to multiples of an interval.
+// A nil aligner keeps all timestamps.
+type TimestampAligner struct {
+	interval time.Duration
+	clock    utils.Clock
+}
+
+// NewTimestampAligner creates an aligner truncating timestamps to the interval.
+// It returns nil if interval is not positive.
+func NewTimestampAligner(interval time.Duration) *TimestampAligner {
+	if interval <= 0 {
+		return nil
+	}
+	return &TimestampAligner{
+		interval: interval,
+		clock:    utils.SystemClock,
+	}
+}
+
+// Process implements the Processor interface. Metrics without a timestamp,
+// which would get the time of writing, are given the aligned current time.
+func (ta *TimestampAligner) Process(m metrics.Metric) (metrics.Metric, bool) {
+	if ta == nil {
+		return m, true
+	}
+	timestamp := m.Timestamp
+	if timestamp.IsZero() {
+		timestamp = ta.clock.Now()
+	}
+	m.Timestamp = timestamp.Truncate(ta.interval)
+	return m, true
+}
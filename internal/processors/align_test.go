@@ -0,0 +1,46 @@
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/testutil"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestTimestampAligner(t *testing.T) {
+	aligner := NewTimestampAligner(10 * time.Second)
+	clock := testutil.NewClock(time.Date(2024, 6, 1, 12, 0, 27, 0, time.UTC))
+	aligner.clock = clock
+
+	tests := []struct {
+		timestamp time.Time
+		expected  time.Time
+	}{
+		{time.Date(2024, 6, 1, 12, 0, 13, 500, time.UTC), time.Date(2024, 6, 1, 12, 0, 10, 0, time.UTC)},
+		{time.Date(2024, 6, 1, 12, 0, 20, 0, time.UTC), time.Date(2024, 6, 1, 12, 0, 20, 0, time.UTC)},
+		{time.Date(2024, 6, 1, 12, 0, 29, 999999999, time.UTC), time.Date(2024, 6, 1, 12, 0, 20, 0, time.UTC)},
+		{time.Time{}, time.Date(2024, 6, 1, 12, 0, 20, 0, time.UTC)}, // Current time is used
+	}
+	for _, tt := range tests {
+		m, keep := aligner.Process(metrics.Metric{Name: "power", Fields: map[string]interface{}{"value": 1}, Timestamp: tt.timestamp})
+		if !keep {
+			t.Fatal("Expected metric to be kept")
+		}
+		if !m.Timestamp.Equal(tt.expected) {
+			t.Errorf("Expected %v aligned to %v, got %v", tt.timestamp, tt.expected, m.Timestamp)
+		}
+	}
+}
+
+func TestTimestampAlignerNil(t *testing.T) {
+	aligner := NewTimestampAligner(0)
+	if aligner != nil {
+		t.Fatal("Expected nil aligner without interval")
+	}
+	timestamp := time.Date(2024, 6, 1, 12, 0, 13, 0, time.UTC)
+	m, keep := aligner.Process(metrics.Metric{Name: "power", Timestamp: timestamp})
+	if !keep || !m.Timestamp.Equal(timestamp) {
+		t.Errorf("Expected nil aligner to keep the timestamp, got %v", m.Timestamp)
+	}
+}
@@ -0,0 +1,86 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the anonymizer, which replaces device identifiers such as
+// MAC addresses with pseudonyms before metrics leave the house, e.g. when they
+// are forwarded to a shared or cloud database.
+package processors
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// defaultAnonymizeTag is the tag anonymized if no tags are configured
+	defaultAnonymizeTag = "device"
+
+	// pseudonymLength is the number of hex characters of a pseudonym
+	pseudonymLength = 16
+)
+
+// Anonymizer replaces the values of tags with a truncated HMAC-SHA256 of the
+// value. Pseudonyms are stable for the same key, so series stay continuous.
+type Anonymizer struct {
+	key  []byte
+	tags []string
+
+	mu         sync.Mutex
+	pseudonyms map[string]string // cache by original value
+}
+
+// NewAnonymizer creates an anonymizer from the configuration. The key must
+// have been checked with AnonymizeConfig.Validate.
+func NewAnonymizer(cfg config.AnonymizeConfig) *Anonymizer {
+	tags := cfg.Tags
+	if len(tags) == 0 {
+		tags = []string{defaultAnonymizeTag}
+	}
+	return &Anonymizer{
+		key:        []byte(cfg.Key),
+		tags:       tags,
+		pseudonyms: make(map[string]string),
+	}
+}
+
+// Process implements the Processor interface.
+func (a *Anonymizer) Process(m metrics.Metric) (metrics.Metric, bool) {
+	var tags map[string]string
+	for _, tag := range a.tags {
+		value, ok := m.Tags[tag]
+		if !ok || value == "" {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]string, len(m.Tags))
+			for k, v := range m.Tags {
+				tags[k] = v
+			}
+		}
+		tags[tag] = a.pseudonym(value)
+	}
+
+	if tags != nil {
+		m.Tags = tags
+	}
+	return m, true
+}
+
+// pseudonym returns the pseudonym of a value.
+func (a *Anonymizer) pseudonym(value string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if pseudonym, ok := a.pseudonyms[value]; ok {
+		return pseudonym
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(value))
+	pseudonym := hex.EncodeToString(mac.Sum(nil))[:pseudonymLength]
+	a.pseudonyms[value] = pseudonym
+	return pseudonym
+}
@@ -0,0 +1,56 @@
+package processors
+
+import (
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestAnonymizer(t *testing.T) {
+	anonymizer := NewAnonymizer(config.AnonymizeConfig{Key: "secret"})
+	tags := map[string]string{"device": "70:ee:50:00:00:10", "friendly": "Indoor"}
+	m, keep := anonymizer.Process(metrics.Metric{Name: "climate", Tags: tags})
+	if !keep {
+		t.Fatal("Expected metric to be kept")
+	}
+
+	pseudonym := m.Tags["device"]
+	if pseudonym == "70:ee:50:00:00:10" || len(pseudonym) != pseudonymLength {
+		t.Errorf("Expected device to be replaced by a pseudonym, got %q", pseudonym)
+	}
+	if m.Tags["friendly"] != "Indoor" {
+		t.Errorf("Expected other tags to be kept, got %v", m.Tags)
+	}
+	if tags["device"] != "70:ee:50:00:00:10" {
+		t.Error("Expected the tags of the original metric not to be modified")
+	}
+
+	// Pseudonyms are stable for the same key and differ for other keys
+	other, _ := NewAnonymizer(config.AnonymizeConfig{Key: "secret"}).Process(metrics.Metric{Tags: tags})
+	if other.Tags["device"] != pseudonym {
+		t.Errorf("Expected stable pseudonym %s, got %s", pseudonym, other.Tags["device"])
+	}
+	other, _ = NewAnonymizer(config.AnonymizeConfig{Key: "other"}).Process(metrics.Metric{Tags: tags})
+	if other.Tags["device"] == pseudonym {
+		t.Error("Expected a different key to yield a different pseudonym")
+	}
+}
+
+func TestAnonymizerTags(t *testing.T) {
+	anonymizer := NewAnonymizer(config.AnonymizeConfig{Key: "secret", Tags: []string{"home", "serial"}})
+	m, _ := anonymizer.Process(metrics.Metric{Tags: map[string]string{"device": "inverter", "home": "5e1e", "serial": ""}})
+	if m.Tags["device"] != "inverter" {
+		t.Errorf("Expected unconfigured tags to be kept, got %v", m.Tags)
+	}
+	if m.Tags["home"] == "5e1e" {
+		t.Errorf("Expected home to be anonymized, got %v", m.Tags)
+	}
+	if m.Tags["serial"] != "" {
+		t.Errorf("Expected empty values to be kept, got %v", m.Tags)
+	}
+
+	if err := (config.AnonymizeConfig{}).Validate(); err == nil {
+		t.Error("Expected an error without key")
+	}
+}
@@ -0,0 +1,135 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the attribute mapper, which moves device attributes such
+// as model or firmware version between tags and fields, or drops them. A
+// firmware version as tag starts new series on every update; as field it is
+// still recorded, optionally only once in a while.
+package processors
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// attributeState is the last value of a field attribute written for a series.
+type attributeState struct {
+	value   string
+	written time.Time
+}
+
+// AttributeMapper writes configured attributes as tags or fields, or drops
+// them. A nil mapper keeps all metrics unchanged.
+type AttributeMapper struct {
+	mappings map[string]config.AttributeMapping
+	clock    utils.Clock
+
+	mu     sync.Mutex
+	series map[string]attributeState
+}
+
+// NewAttributeMapper creates an attribute mapper from mappings keyed by
+// attribute name. Mappings with an unknown mode are ignored. It returns nil if
+// no valid mapping remains.
+func NewAttributeMapper(mappings map[string]config.AttributeMapping) *AttributeMapper {
+	valid := make(map[string]config.AttributeMapping, len(mappings))
+	for name, mapping := range mappings {
+		switch mapping.As {
+		case config.AttributeTag, config.AttributeField, config.AttributeDrop:
+			valid[name] = mapping
+		default:
+			utils.Warnf("[pipeline] unknown mode '%s' for attribute '%s', expected tag, field or drop", mapping.As, name)
+		}
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+	return &AttributeMapper{
+		mappings: valid,
+		clock:    utils.SystemClock,
+		series:   make(map[string]attributeState),
+	}
+}
+
+// Process implements the Processor interface. Metrics without remaining
+// fields are dropped.
+func (am *AttributeMapper) Process(m metrics.Metric) (metrics.Metric, bool) {
+	if am == nil {
+		return m, true
+	}
+
+	var fields map[string]interface{}
+	var tags map[string]string
+	copyMaps := func() {
+		if fields == nil {
+			fields = copyFields(m.Fields)
+			tags = make(map[string]string, len(m.Tags))
+			for key, value := range m.Tags {
+				tags[key] = value
+			}
+		}
+	}
+
+	for name, mapping := range am.mappings {
+		tag, isTag := m.Tags[name]
+		field, isField := m.Fields[name]
+		if !isTag && !isField {
+			continue
+		}
+		copyMaps()
+		switch mapping.As {
+		case config.AttributeTag:
+			if isField {
+				delete(fields, name)
+				if _, ok := tags[name]; !ok {
+					tags[name] = fmt.Sprint(field)
+				}
+			}
+		case config.AttributeField:
+			if isTag {
+				delete(tags, name)
+				if _, ok := fields[name]; !ok {
+					fields[name] = tag
+				}
+			}
+		case config.AttributeDrop:
+			delete(tags, name)
+			delete(fields, name)
+		}
+	}
+
+	if fields == nil {
+		return m, true
+	}
+	m.Fields = fields
+	m.Tags = tags
+
+	// Throttle field attributes once all attributes are moved, so the series
+	// doesn't depend on the attributes
+	for name, mapping := range am.mappings {
+		if value, ok := fields[name]; ok && mapping.As == config.AttributeField && mapping.Every > 0 {
+			if !am.due(seriesKey(m, name), fmt.Sprint(value), mapping.Every.Duration()) {
+				delete(fields, name)
+			}
+		}
+	}
+	return m, len(fields) > 0
+}
+
+// due reports whether a field attribute is written, and records it if so.
+func (am *AttributeMapper) due(key, value string, every time.Duration) bool {
+	now := am.clock.Now()
+
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	last, ok := am.series[key]
+	if ok && last.value == value && now.Sub(last.written) < every {
+		return false
+	}
+	am.series[key] = attributeState{value: value, written: now}
+	return true
+}
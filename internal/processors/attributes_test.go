@@ -0,0 +1,86 @@
+package processors
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/testutil"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestAttributeMapper(t *testing.T) {
+	mapper := NewAttributeMapper(map[string]config.AttributeMapping{
+		"model":    {As: config.AttributeTag},
+		"firmware": {As: config.AttributeField},
+		"ip":       {As: config.AttributeDrop},
+	})
+
+	tags := map[string]string{"device": "plug1", "firmware": "14.1.0", "ip": "192.168.1.20"}
+	fields := map[string]interface{}{"power": 12.5, "model": "Sonoff S26"}
+	m, keep := mapper.Process(metrics.Metric{Name: "tasmota", Tags: tags, Fields: fields})
+	if !keep {
+		t.Fatal("Expected metric to be kept")
+	}
+
+	expectedTags := map[string]string{"device": "plug1", "model": "Sonoff S26"}
+	expectedFields := map[string]interface{}{"power": 12.5, "firmware": "14.1.0"}
+	if !reflect.DeepEqual(m.Tags, expectedTags) || !reflect.DeepEqual(m.Fields, expectedFields) {
+		t.Errorf("Expected tags %v and fields %v, got %v and %v", expectedTags, expectedFields, m.Tags, m.Fields)
+	}
+	if len(tags) != 3 || len(fields) != 2 {
+		t.Error("Expected the original tags and fields not to be modified")
+	}
+
+	// Metrics without remaining fields are dropped
+	if _, keep := mapper.Process(metrics.Metric{Name: "tasmota", Fields: map[string]interface{}{"ip": "192.168.1.20"}}); keep {
+		t.Error("Expected metric without fields to be dropped")
+	}
+}
+
+func TestAttributeMapperEvery(t *testing.T) {
+	mapper := NewAttributeMapper(map[string]config.AttributeMapping{
+		"firmware": {As: config.AttributeField, Every: config.Duration(24 * time.Hour)},
+	})
+	clock := testutil.NewClock(time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC))
+	mapper.clock = clock
+
+	firmware := func(device, version string) interface{} {
+		m, _ := mapper.Process(metrics.Metric{
+			Name:   "esphome",
+			Tags:   map[string]string{"device": device, "firmware": version},
+			Fields: map[string]interface{}{"temperature": 21.5},
+		})
+		return m.Fields["firmware"]
+	}
+
+	if got := firmware("node1", "2026.9.0"); got != "2026.9.0" {
+		t.Errorf("Expected first firmware to be written, got %v", got)
+	}
+	clock.Advance(time.Hour)
+	if got := firmware("node1", "2026.9.0"); got != nil {
+		t.Errorf("Expected unchanged firmware to be skipped, got %v", got)
+	}
+	if got := firmware("node2", "2026.9.0"); got != "2026.9.0" {
+		t.Errorf("Expected firmware of another device to be written, got %v", got)
+	}
+	if got := firmware("node1", "2026.10.0"); got != "2026.10.0" {
+		t.Errorf("Expected changed firmware to be written, got %v", got)
+	}
+	clock.Advance(24 * time.Hour)
+	if got := firmware("node1", "2026.10.0"); got != "2026.10.0" {
+		t.Errorf("Expected firmware to be written again after the interval, got %v", got)
+	}
+}
+
+func TestAttributeMapperNil(t *testing.T) {
+	if mapper := NewAttributeMapper(map[string]config.AttributeMapping{"model": {As: "label"}}); mapper != nil {
+		t.Fatal("Expected no mapper without valid mappings")
+	}
+	var mapper *AttributeMapper
+	m, keep := mapper.Process(metrics.Metric{Name: "demo", Tags: map[string]string{"model": "x"}, Fields: map[string]interface{}{"value": 1}})
+	if !keep || m.Tags["model"] != "x" {
+		t.Errorf("Expected metric to be unchanged, got %v", m.Tags)
+	}
+}
@@ -0,0 +1,87 @@
+package processors
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+	"github.com/janhuddel/metrics-agent/pkg/processor"
+)
+
+// CustomProcessor applies a custom processor registered with package
+// pkg/processor. Errors and panics of the processor are logged and counted,
+// and the metric is passed on unchanged.
+type CustomProcessor struct {
+	name      string
+	processor processor.Processor
+	errors    atomic.Int64
+}
+
+// NewCustomProcessor wraps a custom processor for the pipeline.
+func NewCustomProcessor(name string, p processor.Processor) *CustomProcessor {
+	return &CustomProcessor{name: name, processor: p}
+}
+
+// newCustomProcessor creates the custom processor of a configuration entry.
+func newCustomProcessor(cfg config.CustomProcessorConfig) (*CustomProcessor, error) {
+	factory, ok := processor.Lookup(cfg.Name)
+	if !ok {
+		return nil, fmt.Errorf("processor %s is not compiled in", cfg.Name)
+	}
+	raw, err := json.Marshal(cfg.Config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config of processor %s: %w", cfg.Name, err)
+	}
+	p, err := factory(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create processor %s: %w", cfg.Name, err)
+	}
+	return NewCustomProcessor(cfg.Name, p), nil
+}
+
+// ValidateCustom checks that all configured custom processors are compiled in.
+func ValidateCustom(custom []config.CustomProcessorConfig) error {
+	for _, cfg := range custom {
+		if _, ok := processor.Lookup(cfg.Name); !ok {
+			return fmt.Errorf("processor %s is not compiled in (available: %v)", cfg.Name, processor.Names())
+		}
+	}
+	return nil
+}
+
+// Expand returns the metrics the custom processor replaces the metric with.
+func (cp *CustomProcessor) Expand(m metrics.Metric) (result []metrics.Metric) {
+	defer func() {
+		if r := recover(); r != nil {
+			cp.errors.Add(1)
+			utils.Errorf("[pipeline] processor %s panicked on %s: %v", cp.name, m.Name, r)
+			result = []metrics.Metric{m}
+		}
+	}()
+
+	processed, err := cp.processor.Process(m)
+	if err != nil {
+		cp.errors.Add(1)
+		utils.Warnf("[pipeline] processor %s failed on %s: %v", cp.name, m.Name, err)
+		return []metrics.Metric{m}
+	}
+	return processed
+}
+
+// Process returns the first metric the custom processor replaces the metric
+// with. Further metrics are only passed on by Expand.
+func (cp *CustomProcessor) Process(m metrics.Metric) (metrics.Metric, bool) {
+	processed := cp.Expand(m)
+	if len(processed) == 0 {
+		return m, false
+	}
+	return processed[0], true
+}
+
+// Stats returns the number of metrics the processor failed on.
+func (cp *CustomProcessor) Stats() map[string]int64 {
+	return map[string]int64{"custom_errors": cp.errors.Load()}
+}
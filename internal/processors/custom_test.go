@@ -0,0 +1,123 @@
+package processors
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+	"github.com/janhuddel/metrics-agent/pkg/processor"
+)
+
+func init() {
+	// cost adds a metric with the energy cost to every electricity metric
+	processor.MustRegister("test_cost", func(raw json.RawMessage) (processor.Processor, error) {
+		var cfg struct {
+			Price float64 `json:"price"`
+		}
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, err
+		}
+		if cfg.Price <= 0 {
+			return nil, fmt.Errorf("price is required")
+		}
+		return processor.Func(func(m metrics.Metric) ([]metrics.Metric, error) {
+			energy, ok := m.Fields["energy"].(float64)
+			if !ok {
+				return nil, fmt.Errorf("energy missing")
+			}
+			cost := metrics.Metric{Name: "cost", Tags: m.Tags, Fields: map[string]interface{}{"value": energy * cfg.Price}}
+			return []metrics.Metric{m, cost}, nil
+		}), nil
+	})
+	processor.MustRegister("test_panic", func(json.RawMessage) (processor.Processor, error) {
+		return processor.Func(func(m metrics.Metric) ([]metrics.Metric, error) {
+			panic("boom")
+		}), nil
+	})
+	processor.MustRegister("test_drop", func(json.RawMessage) (processor.Processor, error) {
+		return processor.Func(func(m metrics.Metric) ([]metrics.Metric, error) {
+			return nil, nil
+		}), nil
+	})
+}
+
+func TestCustomProcessorInPipeline(t *testing.T) {
+	pipeline := FromConfig(config.PipelineConfig{
+		Custom: []config.CustomProcessorConfig{{Name: "test_cost", Config: map[string]interface{}{"price": 0.5}}},
+		Round:  []config.RoundRule{{Decimals: 0}},
+	})
+	if pipeline.Len() != 2 {
+		t.Fatalf("Expected 2 processors, got %d", pipeline.Len())
+	}
+
+	// The custom processor runs after rounding, so the cost is not rounded
+	processed := pipeline.Expand(metrics.Metric{Name: "electricity", Fields: map[string]interface{}{"energy": 3.0}})
+	if len(processed) != 2 || processed[0].Name != "electricity" || processed[1].Name != "cost" || processed[1].Fields["value"] != 1.5 {
+		t.Fatalf("Unexpected metrics: %+v", processed)
+	}
+
+	// A failing processor passes the metric on unchanged
+	processed = pipeline.Expand(metrics.Metric{Name: "climate", Fields: map[string]interface{}{"temperature": 21.0}})
+	if len(processed) != 1 || processed[0].Name != "climate" {
+		t.Errorf("Expected the metric to be passed on, got %+v", processed)
+	}
+	if pipeline.Stats()["custom_errors"] != 1 {
+		t.Errorf("Expected 1 error, got %v", pipeline.Stats())
+	}
+}
+
+func TestPipelineNames(t *testing.T) {
+	pipeline := FromConfig(config.PipelineConfig{
+		Custom:      []config.CustomProcessorConfig{{Name: "test_drop"}},
+		Round:       []config.RoundRule{{Decimals: 1}},
+		SpikeFilter: []config.SpikeFilterRule{{Fields: []string{"power"}, MaxDelta: 1000}},
+	})
+	pipeline.Append(NewTagger(map[string]string{"agent_id": "a"}))
+
+	names := fmt.Sprint(pipeline.Names())
+	if names != "[spike_filter round custom:test_drop tags]" {
+		t.Errorf("Expected processors in the order they are applied, got %s", names)
+	}
+}
+
+func TestCustomProcessorPanic(t *testing.T) {
+	factory, _ := processor.Lookup("test_panic")
+	p, _ := factory(nil)
+	custom := NewCustomProcessor("test_panic", p)
+
+	m, keep := custom.Process(metrics.Metric{Name: "power"})
+	if !keep || m.Name != "power" || custom.Stats()["custom_errors"] != 1 {
+		t.Errorf("Expected the metric to be passed on after a panic, got %+v, %v", m, keep)
+	}
+}
+
+func TestPipelineExpandDrop(t *testing.T) {
+	pipeline := FromConfig(config.PipelineConfig{
+		Custom: []config.CustomProcessorConfig{{Name: "test_drop"}, {Name: "test_cost", Config: map[string]interface{}{"price": 1}}},
+	})
+	if processed := pipeline.Expand(metrics.Metric{Name: "electricity", Fields: map[string]interface{}{"energy": 1.0}}); len(processed) != 0 {
+		t.Errorf("Expected the metric to be dropped, got %+v", processed)
+	}
+	if _, keep := pipeline.Process(metrics.Metric{Name: "electricity"}); keep {
+		t.Error("Expected Process to drop the metric as well")
+	}
+}
+
+func TestCustomProcessorConfigErrors(t *testing.T) {
+	if err := ValidateCustom([]config.CustomProcessorConfig{{Name: "test_cost"}}); err != nil {
+		t.Errorf("Expected registered processor to be valid, got %v", err)
+	}
+	if err := ValidateCustom([]config.CustomProcessorConfig{{Name: "test_missing"}}); err == nil {
+		t.Error("Expected error for processor that is not compiled in")
+	}
+
+	// Processors failing to be created are left out
+	pipeline := FromConfig(config.PipelineConfig{
+		Custom: []config.CustomProcessorConfig{{Name: "test_missing"}, {Name: "test_cost"}},
+	})
+	if pipeline.Len() != 0 {
+		t.Errorf("Expected no processors, got %d", pipeline.Len())
+	}
+}
@@ -0,0 +1,194 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the derivative processor, which turns counters into rates
+// or deltas, e.g. an energy total in Wh into the average power in W or a byte
+// counter into bit/s, so every consumer doesn't have to derive them itself.
+package processors
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// defaultDerivativeUnit is the default time unit of rates
+	defaultDerivativeUnit = time.Second
+
+	// defaultDerivativeMaxGap is the default longest derived gap between two values
+	defaultDerivativeMaxGap = 10 * time.Minute
+)
+
+// derivativeRule is a DerivativeRule with parsed settings.
+type derivativeRule struct {
+	config.DerivativeRule
+	unit   time.Duration
+	maxGap time.Duration
+}
+
+// derivativeState holds the previous value of a series.
+type derivativeState struct {
+	value     float64
+	timestamp time.Time
+}
+
+// Derivative adds the rate or delta of counter fields to metrics as
+// <field>_rate or <field>_delta. The first value of a series only becomes the
+// baseline; a decreasing value is treated as a counter reset.
+type Derivative struct {
+	rules []derivativeRule
+	clock utils.Clock // for metrics without timestamp
+
+	mu     sync.Mutex
+	series map[string]*derivativeState
+
+	resets atomic.Int64
+}
+
+// NewDerivative creates a derivative processor with the given rules.
+func NewDerivative(rules []config.DerivativeRule) *Derivative {
+	parsed := make([]derivativeRule, 0, len(rules))
+	for _, rule := range rules {
+		parsed = append(parsed, parseDerivativeRule(rule))
+	}
+
+	return &Derivative{
+		rules:  parsed,
+		clock:  utils.SystemClock,
+		series: make(map[string]*derivativeState),
+	}
+}
+
+// parseDerivativeRule parses the mode and durations of a rule, falling back
+// to the defaults for invalid values.
+func parseDerivativeRule(rule config.DerivativeRule) derivativeRule {
+	parsed := derivativeRule{
+		DerivativeRule: rule,
+		unit:           defaultDerivativeUnit,
+		maxGap:         defaultDerivativeMaxGap,
+	}
+
+	if rule.Mode == "" {
+		parsed.Mode = config.DerivativeModeRate
+	} else if rule.Mode != config.DerivativeModeRate && rule.Mode != config.DerivativeModeDelta {
+		utils.Warnf("[pipeline] unknown derivative mode '%s', using '%s'", rule.Mode, config.DerivativeModeRate)
+		parsed.Mode = config.DerivativeModeRate
+	}
+	if rule.Suffix == "" {
+		parsed.Suffix = "_" + parsed.Mode
+	}
+	if rule.Factor == 0 {
+		parsed.Factor = 1
+	}
+	if rule.Unit > 0 {
+		parsed.unit = rule.Unit.Duration()
+	}
+	if rule.MaxGap > 0 {
+		parsed.maxGap = rule.MaxGap.Duration()
+	}
+	return parsed
+}
+
+// Process implements the Processor interface.
+func (d *Derivative) Process(m metrics.Metric) (metrics.Metric, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	timestamp := m.Timestamp
+	if timestamp.IsZero() {
+		timestamp = d.clock.Now()
+	}
+
+	var fields map[string]interface{}
+	var kinds map[string]metrics.Kind
+	for field, value := range m.Fields {
+		current, ok := toFloat(value)
+		if !ok {
+			continue
+		}
+		rule, ok := d.matchRule(m, field)
+		if !ok {
+			continue
+		}
+
+		result, ok := d.derive(seriesKey(m, field), current, timestamp, rule)
+		if !ok {
+			continue
+		}
+
+		if fields == nil {
+			fields = copyFields(m.Fields)
+			kinds = copyFieldKinds(m.FieldKinds)
+		}
+		fields[field+rule.Suffix] = result
+		// Rates and deltas go up and down, even if the metric is a counter
+		kinds[field+rule.Suffix] = metrics.KindGauge
+	}
+
+	if fields != nil {
+		m.Fields = fields
+		m.FieldKinds = kinds
+	}
+	return m, true
+}
+
+// Stats returns the number of detected counter resets.
+func (d *Derivative) Stats() map[string]int64 {
+	return map[string]int64{
+		"derivative_resets": d.resets.Load(),
+	}
+}
+
+// matchRule returns the first rule matching the measurement and field. Rules
+// without fields only match fields the module marks as counter or cumulative.
+func (d *Derivative) matchRule(m metrics.Metric, field string) (derivativeRule, bool) {
+	for _, rule := range d.rules {
+		if !matchesField(rule.Measurement, rule.Fields, m.Name, field) {
+			continue
+		}
+		if len(rule.Fields) == 0 && !m.FieldKind(field).IsMonotonic() {
+			continue
+		}
+		return rule, true
+	}
+	return derivativeRule{}, false
+}
+
+// derive records a new value of a series and returns its rate or delta since
+// the previous value. It returns false for the first value of a series and
+// after gaps longer than max_gap.
+func (d *Derivative) derive(key string, value float64, timestamp time.Time, rule derivativeRule) (float64, bool) {
+	state, exists := d.series[key]
+	if !exists {
+		d.series[key] = &derivativeState{value: value, timestamp: timestamp}
+		return 0, false
+	}
+
+	elapsed := timestamp.Sub(state.timestamp)
+	if elapsed <= 0 {
+		// Duplicate or out-of-order value, keep the baseline
+		return 0, false
+	}
+
+	increase := value - state.value
+	if increase < 0 {
+		// Counter was reset, e.g. a restarted device or the daily total at midnight
+		utils.Debugf("[pipeline] derivative detected reset of %s from %v to %v", key, state.value, value)
+		d.resets.Add(1)
+		increase = value
+	}
+	state.value = value
+	state.timestamp = timestamp
+
+	if elapsed > rule.maxGap {
+		return 0, false
+	}
+	if rule.Mode == config.DerivativeModeDelta {
+		return increase * rule.Factor, true
+	}
+	return increase / (float64(elapsed) / float64(rule.unit)) * rule.Factor, true
+}
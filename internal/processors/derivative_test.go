@@ -0,0 +1,86 @@
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/testutil"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestDerivativeRate(t *testing.T) {
+	d := NewDerivative([]config.DerivativeRule{
+		{Measurement: "electricity", Fields: []string{"sum_power_total"}, Unit: config.Duration(time.Hour), Factor: 1000},
+	})
+	start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	total := func(value float64, offset time.Duration) metrics.Metric {
+		m, _ := d.Process(metrics.Metric{
+			Name:      "electricity",
+			Tags:      map[string]string{"device": "plug1"},
+			Fields:    map[string]interface{}{"sum_power_total": value},
+			Timestamp: start.Add(offset),
+		})
+		return m
+	}
+
+	if m := total(10, 0); m.Fields["sum_power_total_rate"] != nil {
+		t.Fatalf("Expected no rate for the first value, got %v", m.Fields)
+	}
+	// 0.05 kWh in 6 minutes: 0.5 kW = 500 W
+	m := total(10.05, 6*time.Minute)
+	assertTotal(t, m, "sum_power_total_rate", 500)
+	if kind := m.FieldKind("sum_power_total_rate"); kind != metrics.KindGauge {
+		t.Errorf("Expected rate to be a gauge, got %s", kind)
+	}
+
+	// Gaps longer than max_gap only update the baseline
+	if m := total(11, time.Hour); m.Fields["sum_power_total_rate"] != nil {
+		t.Errorf("Expected no rate after a gap, got %v", m.Fields)
+	}
+	assertTotal(t, total(11.1, 66*time.Minute), "sum_power_total_rate", 1000)
+}
+
+func TestDerivativeDeltaReset(t *testing.T) {
+	d := NewDerivative([]config.DerivativeRule{
+		{Mode: config.DerivativeModeDelta},
+	})
+	start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	traffic := func(value float64, offset time.Duration) metrics.Metric {
+		m, _ := d.Process(metrics.Metric{
+			Name:       "virtual_machine",
+			Tags:       map[string]string{"device": "vm1"},
+			Fields:     map[string]interface{}{"net_in": value, "cpu_usage": 5.0},
+			FieldKinds: map[string]metrics.Kind{"net_in": metrics.KindCounter},
+			Timestamp:  start.Add(offset),
+		})
+		return m
+	}
+
+	traffic(1000, 0)
+	m := traffic(1500, time.Minute)
+	assertTotal(t, m, "net_in_delta", 500)
+	if _, exists := m.Fields["cpu_usage_delta"]; exists {
+		t.Error("Expected gauges not to be derived by rules without fields")
+	}
+
+	// The guest restarted, the counter starts over
+	assertTotal(t, traffic(200, 2*time.Minute), "net_in_delta", 200)
+	if resets := d.Stats()["derivative_resets"]; resets != 1 {
+		t.Errorf("Expected 1 reset, got %d", resets)
+	}
+}
+
+func TestDerivativeWithoutTimestamp(t *testing.T) {
+	d := NewDerivative([]config.DerivativeRule{
+		{Fields: []string{"bytes"}, Factor: 8},
+	})
+	clock := testutil.NewClock(time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC))
+	d.clock = clock
+
+	// Metrics without timestamp are derived using the time they are processed
+	d.Process(metrics.Metric{Name: "net", Fields: map[string]interface{}{"bytes": 1000}})
+	clock.Advance(10 * time.Second)
+	m, _ := d.Process(metrics.Metric{Name: "net", Fields: map[string]interface{}{"bytes": 2000}})
+	assertTotal(t, m, "bytes_rate", 800)
+}
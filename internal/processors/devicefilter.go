@@ -0,0 +1,72 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the device filter, which drops the metrics of devices a
+// module should ignore, e.g. devices of neighbors on a shared MQTT broker or a
+// test inverter.
+package processors
+
+import (
+	"path"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// DeviceFilter keeps or drops metrics based on their "device" tag. Metrics
+// without a device tag are always kept. A nil filter keeps all metrics.
+type DeviceFilter struct {
+	include []string
+	exclude []string
+}
+
+// NewDeviceFilter creates a device filter from the configured lists.
+// It returns nil if filter is nil or both lists are empty.
+func NewDeviceFilter(filter *config.DeviceFilter) *DeviceFilter {
+	if filter == nil || (len(filter.Include) == 0 && len(filter.Exclude) == 0) {
+		return nil
+	}
+	return &DeviceFilter{
+		include: validPatterns(filter.Include),
+		exclude: validPatterns(filter.Exclude),
+	}
+}
+
+// validPatterns returns the patterns with valid syntax, warning about the others.
+func validPatterns(patterns []string) []string {
+	valid := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			utils.Warnf("[pipeline] ignoring invalid device pattern '%s': %v", pattern, err)
+			continue
+		}
+		valid = append(valid, pattern)
+	}
+	return valid
+}
+
+// Process implements the Processor interface.
+func (df *DeviceFilter) Process(m metrics.Metric) (metrics.Metric, bool) {
+	if df == nil {
+		return m, true
+	}
+	device, ok := m.Tags["device"]
+	if !ok {
+		return m, true
+	}
+
+	if len(df.include) > 0 && !matchesDevice(df.include, device) {
+		return m, false
+	}
+	return m, !matchesDevice(df.exclude, device)
+}
+
+// matchesDevice reports whether a device matches any of the patterns.
+func matchesDevice(patterns []string, device string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, device); matched {
+			return true
+		}
+	}
+	return false
+}
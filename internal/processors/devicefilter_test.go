@@ -0,0 +1,49 @@
+package processors
+
+import (
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestDeviceFilter(t *testing.T) {
+	filter := NewDeviceFilter(&config.DeviceFilter{
+		Include: []string{"tasmota_6886*", "tasmota_A1B2C3"},
+		Exclude: []string{"tasmota_6886BC.1", "[invalid"},
+	})
+
+	for device, expected := range map[string]bool{
+		"tasmota_6886BC.0": true,
+		"tasmota_6886BC.1": false, // excluded although included
+		"tasmota_A1B2C3":   true,
+		"tasmota_FFFFFF":   false, // neighbor's device
+	} {
+		_, keep := filter.Process(metrics.Metric{Name: "electricity", Tags: map[string]string{"device": device}})
+		if keep != expected {
+			t.Errorf("Expected keep=%v for device %s, got %v", expected, device, keep)
+		}
+	}
+
+	if _, keep := filter.Process(metrics.Metric{Name: "agent_status"}); !keep {
+		t.Error("Expected metrics without device tag to be kept")
+	}
+}
+
+func TestDeviceFilterExcludeOnly(t *testing.T) {
+	filter := NewDeviceFilter(&config.DeviceFilter{Exclude: []string{"test-inverter"}})
+	if _, keep := filter.Process(metrics.Metric{Tags: map[string]string{"device": "test-inverter"}}); keep {
+		t.Error("Expected excluded device to be dropped")
+	}
+	if _, keep := filter.Process(metrics.Metric{Tags: map[string]string{"device": "roof"}}); !keep {
+		t.Error("Expected other devices to be kept")
+	}
+
+	if NewDeviceFilter(&config.DeviceFilter{}) != nil || NewDeviceFilter(nil) != nil {
+		t.Error("Expected nil filter without lists")
+	}
+	var nilFilter *DeviceFilter
+	if _, keep := nilFilter.Process(metrics.Metric{Tags: map[string]string{"device": "roof"}}); !keep {
+		t.Error("Expected nil filter to keep all metrics")
+	}
+}
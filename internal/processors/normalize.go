@@ -0,0 +1,131 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the tag normalizer, which cleans up tag values such as
+// friendly names ("Geschirrspüler ", "Living Room"), so that the same device
+// doesn't end up in differently spelled series and values don't need escaping.
+package processors
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// transliterations are the ASCII spellings of umlauts and accented letters.
+var transliterations = map[rune]string{
+	'ä': "ae", 'ö': "oe", 'ü': "ue", 'Ä': "Ae", 'Ö': "Oe", 'Ü': "Ue", 'ß': "ss", 'ẞ': "SS",
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'å': "a", 'ā': "a", 'ą': "a",
+	'À': "A", 'Á': "A", 'Â': "A", 'Ã': "A", 'Å': "A", 'Ā': "A", 'Ą': "A",
+	'æ': "ae", 'Æ': "Ae", 'œ': "oe", 'Œ': "Oe",
+	'ç': "c", 'ć': "c", 'č': "c", 'Ç': "C", 'Ć': "C", 'Č': "C",
+	'ď': "d", 'đ': "d", 'Ď': "D", 'Đ': "D",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ę': "e", 'ě': "e",
+	'È': "E", 'É': "E", 'Ê': "E", 'Ë': "E", 'Ē': "E", 'Ę': "E", 'Ě': "E",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'Ì': "I", 'Í': "I", 'Î': "I", 'Ï': "I",
+	'ł': "l", 'Ł': "L",
+	'ñ': "n", 'ń': "n", 'ň': "n", 'Ñ': "N", 'Ń': "N", 'Ň': "N",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ø': "o", 'Ò': "O", 'Ó': "O", 'Ô': "O", 'Õ': "O", 'Ø': "O",
+	'ř': "r", 'Ř': "R",
+	'ś': "s", 'š': "s", 'Ś': "S", 'Š': "S",
+	'ť': "t", 'Ť': "T",
+	'ù': "u", 'ú': "u", 'û': "u", 'ů': "u", 'Ù': "U", 'Ú': "U", 'Û': "U", 'Ů': "U",
+	'ý': "y", 'ÿ': "y", 'Ý': "Y",
+	'ź': "z", 'ż': "z", 'ž': "z", 'Ź': "Z", 'Ż': "Z", 'Ž': "Z",
+}
+
+// TagNormalizer normalizes tag values: whitespace is trimmed and collapsed,
+// and depending on the configuration the values are lowercased, spaces
+// replaced and non-ASCII letters transliterated.
+type TagNormalizer struct {
+	config config.NormalizeTagsConfig
+	tags   map[string]bool
+}
+
+// NewTagNormalizer creates a tag normalizer with the given configuration.
+func NewTagNormalizer(cfg config.NormalizeTagsConfig) *TagNormalizer {
+	tn := &TagNormalizer{config: cfg}
+	if len(cfg.Tags) > 0 {
+		tn.tags = make(map[string]bool, len(cfg.Tags))
+		for _, tag := range cfg.Tags {
+			tn.tags[tag] = true
+		}
+	}
+	return tn
+}
+
+// Process implements the Processor interface. The tags of the metric are
+// only copied if a value changes.
+func (tn *TagNormalizer) Process(m metrics.Metric) (metrics.Metric, bool) {
+	var tags map[string]string
+	for key, value := range m.Tags {
+		if tn.tags != nil && !tn.tags[key] {
+			continue
+		}
+		normalized := tn.Normalize(value)
+		if normalized == value {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]string, len(m.Tags))
+			for k, v := range m.Tags {
+				tags[k] = v
+			}
+		}
+		if normalized == "" {
+			// Line protocol doesn't allow empty tag values
+			delete(tags, key)
+		} else {
+			tags[key] = normalized
+		}
+	}
+	if tags != nil {
+		m.Tags = tags
+	}
+	return m, true
+}
+
+// Normalize returns the normalized tag value.
+func (tn *TagNormalizer) Normalize(value string) string {
+	if tn.config.Transliterate {
+		value = transliterate(value)
+	}
+	value = strings.Join(strings.Fields(value), " ")
+	if tn.config.Lowercase {
+		value = strings.ToLower(value)
+	}
+	if tn.config.Spaces != "" {
+		value = strings.ReplaceAll(value, " ", tn.config.Spaces)
+	}
+	return value
+}
+
+// transliterate replaces umlauts and accented letters with their ASCII
+// spelling and removes other non-ASCII characters.
+func transliterate(value string) string {
+	ascii := true
+	for _, r := range value {
+		if r > unicode.MaxASCII {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return value
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(value))
+	for _, r := range value {
+		switch {
+		case r <= unicode.MaxASCII:
+			sb.WriteRune(r)
+		case unicode.IsSpace(r):
+			sb.WriteByte(' ')
+		default:
+			sb.WriteString(transliterations[r])
+		}
+	}
+	return sb.String()
+}
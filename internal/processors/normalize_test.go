@@ -0,0 +1,51 @@
+package processors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestTagNormalizerNormalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		config config.NormalizeTagsConfig
+		value  string
+		want   string
+	}{
+		{"whitespace only", config.NormalizeTagsConfig{}, "  Living \t Room ", "Living Room"},
+		{"lowercase", config.NormalizeTagsConfig{Lowercase: true}, "Living Room", "living room"},
+		{"spaces", config.NormalizeTagsConfig{Spaces: "_"}, " Living  Room", "Living_Room"},
+		{"umlauts kept", config.NormalizeTagsConfig{Lowercase: true}, "Geschirrspüler", "geschirrspüler"},
+		{"umlauts", config.NormalizeTagsConfig{Transliterate: true}, "Geschirrspüler Küche", "Geschirrspueler Kueche"},
+		{"all", config.NormalizeTagsConfig{Lowercase: true, Spaces: "-", Transliterate: true}, " Große Süße Café ", "grosse-suesse-cafe"},
+		{"other characters", config.NormalizeTagsConfig{Transliterate: true}, "Büro ☀ 2", "Buero 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewTagNormalizer(tt.config).Normalize(tt.value); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTagNormalizer(t *testing.T) {
+	normalizer := NewTagNormalizer(config.NormalizeTagsConfig{Tags: []string{"device", "room"}, Lowercase: true, Transliterate: true})
+	tags := map[string]string{"device": "Geschirrspüler", "room": "☀", "vendor": "Tasmota"}
+	m, keep := normalizer.Process(metrics.Metric{Name: "power", Tags: tags, Fields: map[string]interface{}{"value": 1}})
+	if !keep {
+		t.Fatal("Expected metric to be kept")
+	}
+
+	// Values that become empty are removed, tags not configured are kept
+	expected := map[string]string{"device": "geschirrspueler", "vendor": "Tasmota"}
+	if !reflect.DeepEqual(m.Tags, expected) {
+		t.Errorf("Expected tags %v, got %v", expected, m.Tags)
+	}
+	if tags["device"] != "Geschirrspüler" {
+		t.Error("Expected the original tags not to be modified")
+	}
+}
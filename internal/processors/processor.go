@@ -0,0 +1,292 @@
+// Package processors provides the metric processing pipeline.
+// Processors are applied to every metric before it is serialized, and can
+// modify metrics or drop them entirely (e.g. to filter implausible values).
+package processors
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// Processor processes a single metric.
+type Processor interface {
+	// Process returns the processed metric and whether it should be kept.
+	Process(m metrics.Metric) (metrics.Metric, bool)
+}
+
+// Expander is implemented by processors that can replace a metric with
+// several metrics, e.g. custom processors. Where it is implemented, Expand is
+// used instead of Process.
+type Expander interface {
+	// Expand returns the metrics replacing m, none if m is dropped.
+	Expand(m metrics.Metric) []metrics.Metric
+}
+
+// Pipeline applies a list of processors in order.
+// A metric dropped by a processor is not passed to the following processors.
+type Pipeline struct {
+	processors []Processor
+}
+
+// NewPipeline creates a pipeline from the given processors.
+func NewPipeline(processors ...Processor) *Pipeline {
+	return &Pipeline{
+		processors: processors,
+	}
+}
+
+// FromConfig creates the pipeline configured in the global pipeline section.
+func FromConfig(cfg config.PipelineConfig) *Pipeline {
+	var processors []Processor
+	if cfg.DeviceAliases != nil {
+		processors = append(processors, NewDeviceAliaser(*cfg.DeviceAliases))
+	}
+	if cfg.Anonymize != nil {
+		processors = append(processors, NewAnonymizer(*cfg.Anonymize))
+	}
+	if len(cfg.Ranges) > 0 {
+		processors = append(processors, NewRangeFilter(cfg.Ranges))
+	}
+	if len(cfg.SpikeFilter) > 0 {
+		processors = append(processors, NewSpikeFilter(cfg.SpikeFilter))
+	}
+	if len(cfg.Derivative) > 0 {
+		processors = append(processors, NewDerivative(cfg.Derivative))
+	}
+	var storage *utils.Storage
+	if len(cfg.Accumulate) > 0 || len(cfg.DailySummary) > 0 {
+		var err error
+		if storage, err = utils.NewStorage("pipeline"); err != nil {
+			utils.Warnf("[pipeline] failed to create storage, accumulated totals and daily summaries won't survive restarts: %v", err)
+			storage = nil
+		}
+	}
+	if len(cfg.Accumulate) > 0 {
+		processors = append(processors, NewAccumulator(cfg.Accumulate, storage))
+	}
+	if len(cfg.DailySummary) > 0 {
+		processors = append(processors, NewDailySummary(cfg.DailySummary, storage))
+	}
+	if len(cfg.Round) > 0 {
+		processors = append(processors, NewRounder(cfg.Round))
+	}
+	for _, custom := range cfg.Custom {
+		processor, err := newCustomProcessor(custom)
+		if err != nil {
+			utils.Errorf("[pipeline] %v, metrics are passed on without it", err)
+			continue
+		}
+		processors = append(processors, processor)
+	}
+	if cfg.TypeChanges != "" {
+		guard, err := NewTypeGuard(cfg.TypeChanges)
+		if err != nil {
+			utils.Errorf("[pipeline] %v, field types are not tracked", err)
+		} else {
+			processors = append(processors, guard)
+		}
+	}
+	if cfg.NormalizeTags != nil {
+		processors = append(processors, NewTagNormalizer(*cfg.NormalizeTags))
+	}
+	return NewPipeline(processors...)
+}
+
+// Append adds processors to the end of the pipeline. It must not be called
+// while metrics are processed.
+func (p *Pipeline) Append(processors ...Processor) {
+	p.processors = append(p.processors, processors...)
+}
+
+// Len returns the number of processors in the pipeline.
+func (p *Pipeline) Len() int {
+	return len(p.processors)
+}
+
+// Names returns the names of the processors in the order they are applied,
+// using the keys of the pipeline configuration (e.g. "spike_filter") and
+// "custom:<name>" for custom processors.
+func (p *Pipeline) Names() []string {
+	names := make([]string, 0, len(p.processors))
+	for _, processor := range p.processors {
+		names = append(names, processorName(processor))
+	}
+	return names
+}
+
+// processorName returns the name of a processor for Names.
+func processorName(processor Processor) string {
+	switch p := processor.(type) {
+	case *DeviceAliaser:
+		return "device_aliases"
+	case *Anonymizer:
+		return "anonymize"
+	case *RangeFilter:
+		return "ranges"
+	case *SpikeFilter:
+		return "spike_filter"
+	case *Derivative:
+		return "derivative"
+	case *Accumulator:
+		return "accumulate"
+	case *DailySummary:
+		return "daily_summary"
+	case *Rounder:
+		return "round"
+	case *CustomProcessor:
+		return "custom:" + p.name
+	case *TypeGuard:
+		return "type_changes"
+	case *TagNormalizer:
+		return "normalize_tags"
+	case *Tagger:
+		return "tags"
+	default:
+		return fmt.Sprintf("%T", processor)
+	}
+}
+
+// Process applies all processors to the metric.
+// It returns false if the metric was dropped.
+func (p *Pipeline) Process(m metrics.Metric) (metrics.Metric, bool) {
+	for _, processor := range p.processors {
+		var keep bool
+		if m, keep = processor.Process(m); !keep {
+			return m, false
+		}
+	}
+	return m, true
+}
+
+// Expand applies all processors to the metric and returns the resulting
+// metrics. Unlike Process, it keeps all metrics of processors that replace a
+// metric with several, passing each of them to the following processors.
+func (p *Pipeline) Expand(m metrics.Metric) []metrics.Metric {
+	batch := []metrics.Metric{m}
+	for _, processor := range p.processors {
+		expander, expands := processor.(Expander)
+		next := batch[:0]
+		if expands {
+			next = make([]metrics.Metric, 0, len(batch))
+		}
+		for _, m := range batch {
+			if expands {
+				next = append(next, expander.Expand(m)...)
+				continue
+			}
+			if m, keep := processor.Process(m); keep {
+				next = append(next, m)
+			}
+		}
+		if batch = next; len(batch) == 0 {
+			break
+		}
+	}
+	return batch
+}
+
+// Stats returns the counters of all processors in the pipeline that keep
+// counters (e.g. the number of dropped values), keyed by counter name.
+func (p *Pipeline) Stats() map[string]int64 {
+	stats := make(map[string]int64)
+	for _, processor := range p.processors {
+		if counter, ok := processor.(statsProvider); ok {
+			for name, value := range counter.Stats() {
+				stats[name] += value
+			}
+		}
+	}
+	return stats
+}
+
+// statsProvider is implemented by processors that keep counters.
+type statsProvider interface {
+	Stats() map[string]int64
+}
+
+// matchesField reports whether a rule for the given measurement and fields
+// applies to a field of a metric. Empty values match everything.
+func matchesField(ruleMeasurement string, ruleFields []string, measurement, field string) bool {
+	if ruleMeasurement != "" && ruleMeasurement != measurement {
+		return false
+	}
+	if len(ruleFields) == 0 {
+		return true
+	}
+	for _, f := range ruleFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// seriesKey returns a key identifying the series of a metric field,
+// built from the measurement name, the sorted tags and the field name.
+func seriesKey(m metrics.Metric, field string) string {
+	tagKeys := make([]string, 0, len(m.Tags))
+	for key := range m.Tags {
+		tagKeys = append(tagKeys, key)
+	}
+	sort.Strings(tagKeys)
+
+	var sb strings.Builder
+	sb.WriteString(m.Name)
+	for _, key := range tagKeys {
+		sb.WriteByte(',')
+		sb.WriteString(key)
+		sb.WriteByte('=')
+		sb.WriteString(m.Tags[key])
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(field)
+	return sb.String()
+}
+
+// toFloat converts a numeric field value to float64.
+// It returns false for non-numeric values.
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// copyFields returns a copy of the metric fields, so that processors
+// don't modify maps that modules may still hold references to.
+func copyFields(fields map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		copied[key] = value
+	}
+	return copied
+}
+
+// copyFieldKinds returns a copy of the field kinds of a metric, so that
+// processors can add kinds without modifying maps shared between metrics.
+func copyFieldKinds(kinds map[string]metrics.Kind) map[string]metrics.Kind {
+	copied := make(map[string]metrics.Kind, len(kinds)+2)
+	for key, value := range kinds {
+		copied[key] = value
+	}
+	return copied
+}
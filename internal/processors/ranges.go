@@ -0,0 +1,114 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the range filter, which validates field values against
+// configured ranges (e.g. power 0-20000 W) and drops or clamps values outside.
+package processors
+
+import (
+	"sync/atomic"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// RangeFilter drops or clamps field values outside the configured ranges.
+// Metrics without remaining fields are dropped.
+type RangeFilter struct {
+	rules []config.RangeRule
+
+	dropped atomic.Int64
+	clamped atomic.Int64
+}
+
+// NewRangeFilter creates a range filter with the given rules.
+func NewRangeFilter(rules []config.RangeRule) *RangeFilter {
+	return &RangeFilter{
+		rules: rules,
+	}
+}
+
+// Process implements the Processor interface.
+func (rf *RangeFilter) Process(m metrics.Metric) (metrics.Metric, bool) {
+	var fields map[string]interface{}
+	for field, value := range m.Fields {
+		current, ok := toFloat(value)
+		if !ok {
+			continue
+		}
+		rule, ok := rf.matchRule(m.Name, field)
+		if !ok {
+			continue
+		}
+		bound, inRange := checkRange(current, rule)
+		if inRange {
+			continue
+		}
+
+		if fields == nil {
+			fields = copyFields(m.Fields)
+		}
+		if rule.Action == config.RangeActionClamp {
+			utils.Debugf("[pipeline] range filter clamped %s.%s=%v to %v (tags: %v)", m.Name, field, value, bound, m.Tags)
+			fields[field] = fromFloat(bound, value)
+			rf.clamped.Add(1)
+		} else {
+			utils.Debugf("[pipeline] range filter dropped %s.%s=%v (tags: %v)", m.Name, field, value, m.Tags)
+			delete(fields, field)
+			rf.dropped.Add(1)
+		}
+	}
+
+	if fields == nil {
+		return m, true
+	}
+	m.Fields = fields
+	return m, len(fields) > 0
+}
+
+// Stats returns the number of dropped and clamped values.
+func (rf *RangeFilter) Stats() map[string]int64 {
+	return map[string]int64{
+		"range_dropped": rf.dropped.Load(),
+		"range_clamped": rf.clamped.Load(),
+	}
+}
+
+// matchRule returns the first rule matching the measurement and field.
+func (rf *RangeFilter) matchRule(measurement, field string) (config.RangeRule, bool) {
+	for _, rule := range rf.rules {
+		if matchesField(rule.Measurement, rule.Fields, measurement, field) {
+			return rule, true
+		}
+	}
+	return config.RangeRule{}, false
+}
+
+// checkRange reports whether the value is within the rule's range.
+// If not, the violated bound is returned as well.
+func checkRange(value float64, rule config.RangeRule) (float64, bool) {
+	if rule.Min != nil && value < *rule.Min {
+		return *rule.Min, false
+	}
+	if rule.Max != nil && value > *rule.Max {
+		return *rule.Max, false
+	}
+	return value, true
+}
+
+// fromFloat converts a clamped value back to the type of the original field value,
+// so that integer fields stay integers in the line protocol output.
+func fromFloat(value float64, original interface{}) interface{} {
+	switch original.(type) {
+	case int:
+		return int(value)
+	case int32:
+		return int32(value)
+	case int64:
+		return int64(value)
+	case float32:
+		return float32(value)
+	default:
+		return value
+	}
+}
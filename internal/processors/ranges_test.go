@@ -0,0 +1,105 @@
+package processors
+
+import (
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestRangeFilter(t *testing.T) {
+	filter := NewRangeFilter([]config.RangeRule{
+		{Measurement: "electricity", Fields: []string{"power"}, Min: float(0), Max: float(20000)},
+		{Measurement: "climate", Fields: []string{"temperature"}, Min: float(-40), Max: float(60), Action: config.RangeActionClamp},
+	})
+
+	tests := []struct {
+		name     string
+		metric   metrics.Metric
+		keep     bool
+		expected map[string]interface{}
+	}{
+		{
+			name:     "in range",
+			metric:   metrics.Metric{Name: "electricity", Fields: map[string]interface{}{"power": 1500, "voltage": 230.0}},
+			keep:     true,
+			expected: map[string]interface{}{"power": 1500, "voltage": 230.0},
+		},
+		{
+			name:     "above max dropped",
+			metric:   metrics.Metric{Name: "electricity", Fields: map[string]interface{}{"power": 65535, "voltage": 230.0}},
+			keep:     true,
+			expected: map[string]interface{}{"voltage": 230.0},
+		},
+		{
+			name:   "only field dropped",
+			metric: metrics.Metric{Name: "electricity", Fields: map[string]interface{}{"power": -5.0}},
+			keep:   false,
+		},
+		{
+			name:     "clamped to max",
+			metric:   metrics.Metric{Name: "climate", Fields: map[string]interface{}{"temperature": 85.5}},
+			keep:     true,
+			expected: map[string]interface{}{"temperature": 60.0},
+		},
+		{
+			name:     "clamped int keeps type",
+			metric:   metrics.Metric{Name: "climate", Fields: map[string]interface{}{"temperature": -100}},
+			keep:     true,
+			expected: map[string]interface{}{"temperature": -40},
+		},
+		{
+			name:     "other measurement",
+			metric:   metrics.Metric{Name: "other", Fields: map[string]interface{}{"power": 65535}},
+			keep:     true,
+			expected: map[string]interface{}{"power": 65535},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, keep := filter.Process(tt.metric)
+			if keep != tt.keep {
+				t.Fatalf("Expected keep=%v, got %v", tt.keep, keep)
+			}
+			if !keep {
+				return
+			}
+			if len(m.Fields) != len(tt.expected) {
+				t.Fatalf("Expected fields %v, got %v", tt.expected, m.Fields)
+			}
+			for key, value := range tt.expected {
+				if m.Fields[key] != value {
+					t.Errorf("Expected %s=%v (%T), got %v (%T)", key, value, value, m.Fields[key], m.Fields[key])
+				}
+			}
+		})
+	}
+
+	stats := filter.Stats()
+	if stats["range_dropped"] != 2 || stats["range_clamped"] != 2 {
+		t.Errorf("Unexpected counters: %v", stats)
+	}
+}
+
+func TestPipelineRangesBeforeSpikeFilter(t *testing.T) {
+	pipeline := FromConfig(config.PipelineConfig{
+		Ranges:      []config.RangeRule{{Max: float(20000)}},
+		SpikeFilter: []config.SpikeFilterRule{{MaxDelta: 1000}},
+	})
+
+	pipeline.Process(metrics.Metric{Name: "power", Fields: map[string]interface{}{"value": 100}})
+	pipeline.Process(metrics.Metric{Name: "power", Fields: map[string]interface{}{"value": 65535}})
+
+	// The out-of-range value must not have become the spike baseline
+	if _, keep := pipeline.Process(metrics.Metric{Name: "power", Fields: map[string]interface{}{"value": 150}}); !keep {
+		t.Error("Expected value near previous valid value to be kept")
+	}
+	if stats := pipeline.Stats(); stats["range_dropped"] != 1 {
+		t.Errorf("Expected 1 dropped value, got %v", stats)
+	}
+}
@@ -0,0 +1,69 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the field renamer, which maps field names of a module to
+// the names used by existing dashboards, e.g. those built for other collectors.
+package processors
+
+import (
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// FieldRenamer renames fields of metrics. Fields without a new name are left
+// unchanged. A nil renamer keeps all fields.
+type FieldRenamer struct {
+	names map[string]string
+}
+
+// NewFieldRenamer creates a renamer mapping field names to new names.
+// It returns nil if names is empty.
+func NewFieldRenamer(names map[string]string) *FieldRenamer {
+	if len(names) == 0 {
+		return nil
+	}
+	return &FieldRenamer{
+		names: names,
+	}
+}
+
+// Process implements the Processor interface.
+func (fr *FieldRenamer) Process(m metrics.Metric) (metrics.Metric, bool) {
+	if fr == nil {
+		return m, true
+	}
+
+	renamed := false
+	for field := range m.Fields {
+		if fr.newName(field) != field {
+			renamed = true
+			break
+		}
+	}
+	if !renamed {
+		return m, true
+	}
+
+	// Build new maps instead of renaming in place, so fields can swap names
+	fields := make(map[string]interface{}, len(m.Fields))
+	for field, value := range m.Fields {
+		fields[fr.newName(field)] = value
+	}
+	var kinds map[string]metrics.Kind
+	if m.FieldKinds != nil {
+		kinds = make(map[string]metrics.Kind, len(m.FieldKinds))
+		for field, kind := range m.FieldKinds {
+			kinds[fr.newName(field)] = kind
+		}
+	}
+
+	m.Fields = fields
+	m.FieldKinds = kinds
+	return m, true
+}
+
+// newName returns the new name of a field, or the field itself if it is not renamed.
+func (fr *FieldRenamer) newName(field string) string {
+	if name := fr.names[field]; name != "" {
+		return name
+	}
+	return field
+}
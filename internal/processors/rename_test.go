@@ -0,0 +1,48 @@
+package processors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestFieldRenamer(t *testing.T) {
+	renamer := NewFieldRenamer(map[string]string{
+		"sum_power_today": "energy_today",
+		"sum_power_total": "energy_total",
+		"a":               "b",
+		"b":               "a",
+	})
+	fields := map[string]interface{}{"sum_power_today": 1.5, "sum_power_total": 100.0, "power": 50, "a": 1, "b": 2}
+	m, keep := renamer.Process(metrics.Metric{
+		Name:       "electricity",
+		Fields:     fields,
+		FieldKinds: metrics.ElectricityKinds,
+	})
+	if !keep {
+		t.Fatal("Expected metric to be kept")
+	}
+
+	expected := map[string]interface{}{"energy_today": 1.5, "energy_total": 100.0, "power": 50, "a": 2, "b": 1}
+	if !reflect.DeepEqual(m.Fields, expected) {
+		t.Errorf("Expected fields %v, got %v", expected, m.Fields)
+	}
+	if kind := m.FieldKind("energy_total"); kind != metrics.KindCounter {
+		t.Errorf("Expected the kind to be renamed as well, got %s", kind)
+	}
+	if _, ok := fields["energy_today"]; ok {
+		t.Error("Expected the original fields not to be modified")
+	}
+}
+
+func TestFieldRenamerNil(t *testing.T) {
+	renamer := NewFieldRenamer(nil)
+	if renamer != nil {
+		t.Fatal("Expected no renamer without names")
+	}
+	m, keep := renamer.Process(metrics.Metric{Name: "demo", Fields: map[string]interface{}{"value": 1}})
+	if !keep || m.Fields["value"] != 1 {
+		t.Errorf("Expected metric to be unchanged, got %v", m.Fields)
+	}
+}
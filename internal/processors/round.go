@@ -0,0 +1,80 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the rounder, which rounds float fields to a configurable
+// number of decimals, so that readings such as a voltage of 230.19999999 don't
+// bloat the output and the storage of the time series database.
+package processors
+
+import (
+	"math"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// Rounder rounds float fields to the number of decimals of the first matching
+// rule. Integer fields are left unchanged.
+type Rounder struct {
+	rules []config.RoundRule
+}
+
+// NewRounder creates a rounder with the given rules.
+func NewRounder(rules []config.RoundRule) *Rounder {
+	return &Rounder{
+		rules: rules,
+	}
+}
+
+// Process implements the Processor interface.
+func (r *Rounder) Process(m metrics.Metric) (metrics.Metric, bool) {
+	var fields map[string]interface{}
+	for field, value := range m.Fields {
+		var current float64
+		switch v := value.(type) {
+		case float64:
+			current = v
+		case float32:
+			current = float64(v)
+		default:
+			continue
+		}
+		rule, ok := r.matchRule(m.Name, field)
+		if !ok {
+			continue
+		}
+
+		rounded := round(current, rule.Decimals)
+		if rounded == current {
+			continue
+		}
+		if fields == nil {
+			fields = copyFields(m.Fields)
+		}
+		fields[field] = fromFloat(rounded, value)
+	}
+
+	if fields != nil {
+		m.Fields = fields
+	}
+	return m, true
+}
+
+// matchRule returns the first rule matching the measurement and field.
+func (r *Rounder) matchRule(measurement, field string) (config.RoundRule, bool) {
+	for _, rule := range r.rules {
+		if matchesField(rule.Measurement, rule.Fields, measurement, field) {
+			return rule, true
+		}
+	}
+	return config.RoundRule{}, false
+}
+
+// round rounds a value to the given number of decimals. Infinite and NaN
+// values are returned unchanged.
+func round(value float64, decimals int) float64 {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return value
+	}
+	scale := math.Pow10(decimals)
+	return math.Round(value*scale) / scale
+}
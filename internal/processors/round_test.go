@@ -0,0 +1,51 @@
+package processors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestRounder(t *testing.T) {
+	rounder := NewRounder([]config.RoundRule{
+		{Measurement: "electricity", Fields: []string{"sum_power_total"}, Decimals: 3},
+		{Measurement: "electricity", Decimals: 1},
+		{Measurement: "climate", Fields: []string{"pressure"}, Decimals: -1},
+	})
+
+	tests := []struct {
+		name     string
+		metric   metrics.Metric
+		expected map[string]interface{}
+	}{
+		{
+			name:     "first matching rule applies",
+			metric:   metrics.Metric{Name: "electricity", Fields: map[string]interface{}{"voltage": 230.19999999, "sum_power_total": 1234.56789}},
+			expected: map[string]interface{}{"voltage": 230.2, "sum_power_total": 1234.568},
+		},
+		{
+			name:     "integers and strings unchanged",
+			metric:   metrics.Metric{Name: "electricity", Fields: map[string]interface{}{"power": 1500, "status": "on", "current": float32(0.123)}},
+			expected: map[string]interface{}{"power": 1500, "status": "on", "current": float32(0.1)},
+		},
+		{
+			name:     "negative decimals",
+			metric:   metrics.Metric{Name: "climate", Fields: map[string]interface{}{"pressure": 1013.4, "temperature": 20.123}},
+			expected: map[string]interface{}{"pressure": 1010.0, "temperature": 20.123},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, keep := rounder.Process(tt.metric)
+			if !keep {
+				t.Fatal("Expected metric to be kept")
+			}
+			if !reflect.DeepEqual(result.Fields, tt.expected) {
+				t.Errorf("Expected fields %v, got %v", tt.expected, result.Fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the spike filter, which drops values that jump
+// implausibly compared to the previous value of the same series, such as
+// the occasional bogus 65535 W reading reported by Tasmota devices.
+package processors
+
+import (
+	"math"
+	"sync"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// defaultMaxRejects is the default number of consecutive spikes after which
+// a value is accepted as the new baseline.
+const defaultMaxRejects = 3
+
+// spikeState holds the last accepted value of a series.
+type spikeState struct {
+	value   float64
+	rejects int
+}
+
+// SpikeFilter drops field values that differ too much from the previous
+// accepted value of the same series. Metrics without remaining fields are dropped.
+type SpikeFilter struct {
+	rules []config.SpikeFilterRule
+
+	mu     sync.Mutex
+	series map[string]*spikeState
+}
+
+// NewSpikeFilter creates a spike filter with the given rules.
+func NewSpikeFilter(rules []config.SpikeFilterRule) *SpikeFilter {
+	return &SpikeFilter{
+		rules:  rules,
+		series: make(map[string]*spikeState),
+	}
+}
+
+// Process implements the Processor interface.
+func (sf *SpikeFilter) Process(m metrics.Metric) (metrics.Metric, bool) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	var fields map[string]interface{}
+	for field, value := range m.Fields {
+		current, ok := toFloat(value)
+		if !ok {
+			continue
+		}
+		rule, ok := sf.matchRule(m.Name, field)
+		if !ok {
+			continue
+		}
+		if sf.accept(seriesKey(m, field), current, rule) {
+			continue
+		}
+
+		utils.Debugf("[pipeline] spike filter dropped %s.%s=%v (tags: %v)", m.Name, field, value, m.Tags)
+		if fields == nil {
+			fields = copyFields(m.Fields)
+		}
+		delete(fields, field)
+	}
+
+	if fields == nil {
+		return m, true
+	}
+	m.Fields = fields
+	return m, len(fields) > 0
+}
+
+// matchRule returns the first rule matching the measurement and field.
+func (sf *SpikeFilter) matchRule(measurement, field string) (config.SpikeFilterRule, bool) {
+	for _, rule := range sf.rules {
+		if matchesField(rule.Measurement, rule.Fields, measurement, field) {
+			return rule, true
+		}
+	}
+	return config.SpikeFilterRule{}, false
+}
+
+// accept reports whether the value is accepted for the series and updates the series state.
+func (sf *SpikeFilter) accept(key string, value float64, rule config.SpikeFilterRule) bool {
+	state, exists := sf.series[key]
+	if !exists {
+		sf.series[key] = &spikeState{value: value}
+		return true
+	}
+
+	if !isSpike(state.value, value, rule) {
+		state.value = value
+		state.rejects = 0
+		return true
+	}
+
+	maxRejects := rule.MaxRejects
+	if maxRejects == 0 {
+		maxRejects = defaultMaxRejects
+	}
+	if maxRejects > 0 && state.rejects >= maxRejects {
+		// The value persisted, so it is a real change rather than a spike
+		state.value = value
+		state.rejects = 0
+		return true
+	}
+
+	state.rejects++
+	return false
+}
+
+// isSpike reports whether the change from previous to current exceeds the rule's limits.
+func isSpike(previous, current float64, rule config.SpikeFilterRule) bool {
+	delta := math.Abs(current - previous)
+	if rule.MaxDelta > 0 && delta > rule.MaxDelta {
+		return true
+	}
+	if rule.MaxChangePercent > 0 && previous != 0 && delta/math.Abs(previous)*100 > rule.MaxChangePercent {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package processors
+
+import (
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func powerMetric(device string, power interface{}) metrics.Metric {
+	return metrics.Metric{
+		Name:   "electricity",
+		Tags:   map[string]string{"device": device},
+		Fields: map[string]interface{}{"power": power, "voltage": 230.0},
+	}
+}
+
+func TestSpikeFilter(t *testing.T) {
+	filter := NewSpikeFilter([]config.SpikeFilterRule{
+		{Measurement: "electricity", Fields: []string{"power"}, MaxDelta: 5000},
+	})
+
+	tests := []struct {
+		name     string
+		metric   metrics.Metric
+		expected bool // whether the power field is kept
+	}{
+		{"first value", powerMetric("plug1", 100), true},
+		{"normal change", powerMetric("plug1", 1500.0), true},
+		{"spike", powerMetric("plug1", 65535), false},
+		{"other series", powerMetric("plug2", 65535), true},
+		{"back to normal", powerMetric("plug1", 1400), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, keep := filter.Process(tt.metric)
+			if !keep {
+				t.Fatal("Expected metric to be kept, other fields remain")
+			}
+			if _, exists := m.Fields["power"]; exists != tt.expected {
+				t.Errorf("Expected power kept=%v, got fields %v", tt.expected, m.Fields)
+			}
+			if _, exists := m.Fields["voltage"]; !exists {
+				t.Error("Expected unfiltered field to be kept")
+			}
+		})
+	}
+}
+
+func TestSpikeFilterPercent(t *testing.T) {
+	filter := NewSpikeFilter([]config.SpikeFilterRule{{MaxChangePercent: 50}})
+
+	filter.Process(metrics.Metric{Name: "temp", Fields: map[string]interface{}{"value": 20.0}})
+	if _, keep := filter.Process(metrics.Metric{Name: "temp", Fields: map[string]interface{}{"value": 85.0}}); keep {
+		t.Error("Expected metric without remaining fields to be dropped")
+	}
+	if _, keep := filter.Process(metrics.Metric{Name: "temp", Fields: map[string]interface{}{"value": 25.0}}); !keep {
+		t.Error("Expected change within limit to be kept")
+	}
+}
+
+func TestSpikeFilterMaxRejects(t *testing.T) {
+	filter := NewSpikeFilter([]config.SpikeFilterRule{{MaxDelta: 100, MaxRejects: 2}})
+	metric := func(value int) metrics.Metric {
+		return metrics.Metric{Name: "power", Fields: map[string]interface{}{"value": value}}
+	}
+
+	filter.Process(metric(10))
+	for i := 0; i < 2; i++ {
+		if _, keep := filter.Process(metric(1000)); keep {
+			t.Fatalf("Expected spike %d to be dropped", i+1)
+		}
+	}
+	if _, keep := filter.Process(metric(1000)); !keep {
+		t.Error("Expected persistent value to be accepted as new baseline")
+	}
+	if _, keep := filter.Process(metric(1010)); !keep {
+		t.Error("Expected value near new baseline to be kept")
+	}
+}
+
+func TestSpikeFilterDoesNotModifyInput(t *testing.T) {
+	filter := NewSpikeFilter([]config.SpikeFilterRule{{MaxDelta: 10}})
+	filter.Process(powerMetric("plug1", 100))
+
+	original := powerMetric("plug1", 1000)
+	filter.Process(original)
+	if _, exists := original.Fields["power"]; !exists {
+		t.Error("Expected original metric fields to be unchanged")
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	pipeline := FromConfig(config.PipelineConfig{
+		SpikeFilter: []config.SpikeFilterRule{{Fields: []string{"power"}, MaxDelta: 10}},
+	})
+	if pipeline.Len() != 1 {
+		t.Fatalf("Expected 1 processor, got %d", pipeline.Len())
+	}
+
+	pipeline.Process(powerMetric("plug1", 100))
+	m, keep := pipeline.Process(powerMetric("plug1", 1000))
+	if !keep || m.Fields["power"] != nil {
+		t.Errorf("Expected power to be filtered, got %v (keep=%v)", m.Fields, keep)
+	}
+
+	if FromConfig(config.PipelineConfig{}).Len() != 0 {
+		t.Error("Expected empty pipeline without configuration")
+	}
+}
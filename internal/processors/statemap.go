@@ -0,0 +1,80 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the state mapper, which converts enumerated string states
+// of a module (e.g. "charging"/"idle") into numeric fields, optionally keeping
+// the readable state as tag.
+package processors
+
+import (
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// StateMapper converts string fields with a configured mapping into numbers.
+// Fields without mapping and non-string values are left unchanged. A nil
+// mapper keeps all fields.
+type StateMapper struct {
+	mappings map[string]config.StateMapping
+}
+
+// NewStateMapper creates a state mapper from mappings keyed by field name.
+// It returns nil if mappings is empty.
+func NewStateMapper(mappings map[string]config.StateMapping) *StateMapper {
+	if len(mappings) == 0 {
+		return nil
+	}
+	return &StateMapper{
+		mappings: mappings,
+	}
+}
+
+// Process implements the Processor interface. Fields with unknown states are
+// dropped; metrics without remaining fields are dropped as well.
+func (sm *StateMapper) Process(m metrics.Metric) (metrics.Metric, bool) {
+	if sm == nil {
+		return m, true
+	}
+
+	var fields map[string]interface{}
+	var tags map[string]string
+	for field, value := range m.Fields {
+		state, ok := value.(string)
+		if !ok {
+			continue
+		}
+		mapping, ok := sm.mappings[field]
+		if !ok {
+			continue
+		}
+
+		if fields == nil {
+			fields = copyFields(m.Fields)
+		}
+		if number, ok := mapping.Lookup(state); ok {
+			fields[field] = number
+		} else {
+			utils.Debugf("[pipeline] state mapper dropped unknown state %s.%s=%q (tags: %v)", m.Name, field, state, m.Tags)
+			delete(fields, field)
+		}
+
+		if mapping.Tag != "" && state != "" {
+			if tags == nil {
+				tags = make(map[string]string, len(m.Tags)+1)
+				for key, value := range m.Tags {
+					tags[key] = value
+				}
+			}
+			tags[mapping.Tag] = state
+		}
+	}
+
+	if fields == nil {
+		return m, true
+	}
+	m.Fields = fields
+	if tags != nil {
+		m.Tags = tags
+	}
+	return m, len(fields) > 0
+}
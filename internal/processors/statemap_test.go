@@ -0,0 +1,56 @@
+package processors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestStateMapper(t *testing.T) {
+	unknown := -1
+	mapper := NewStateMapper(map[string]config.StateMapping{
+		"state":  {Values: map[string]int{"charging": 1, "idle": 0}, Tag: "state"},
+		"power":  config.BinaryStates,
+		"status": {Values: map[string]int{"ok": 0}, Default: &unknown},
+	})
+
+	tags := map[string]string{"device": "wallbox"}
+	fields := map[string]interface{}{"state": "Charging", "power": "ON", "status": "fault", "current": 16.0}
+	m, keep := mapper.Process(metrics.Metric{Name: "wallbox", Tags: tags, Fields: fields})
+	if !keep {
+		t.Fatal("Expected metric to be kept")
+	}
+
+	expected := map[string]interface{}{"state": 1, "power": 1, "status": -1, "current": 16.0}
+	if !reflect.DeepEqual(m.Fields, expected) {
+		t.Errorf("Expected fields %v, got %v", expected, m.Fields)
+	}
+	if m.Tags["state"] != "Charging" || m.Tags["device"] != "wallbox" {
+		t.Errorf("Expected state tag, got %v", m.Tags)
+	}
+	if fields["state"] != "Charging" || len(tags) != 1 {
+		t.Error("Expected the original fields and tags not to be modified")
+	}
+
+	// Unknown states without default are dropped, and so is a metric without fields
+	m, keep = mapper.Process(metrics.Metric{Name: "wallbox", Fields: map[string]interface{}{"state": "error", "current": 0.0}})
+	if !keep || !reflect.DeepEqual(m.Fields, map[string]interface{}{"current": 0.0}) || m.Tags["state"] != "error" {
+		t.Errorf("Expected unknown state to be dropped, got %v %v", m.Fields, m.Tags)
+	}
+	if _, keep = mapper.Process(metrics.Metric{Name: "wallbox", Fields: map[string]interface{}{"power": "toggling"}}); keep {
+		t.Error("Expected metric without fields to be dropped")
+	}
+}
+
+func TestStateMapperNil(t *testing.T) {
+	mapper := NewStateMapper(nil)
+	if mapper != nil {
+		t.Fatal("Expected no mapper without mappings")
+	}
+	m, keep := mapper.Process(metrics.Metric{Name: "demo", Fields: map[string]interface{}{"state": "on"}})
+	if !keep || m.Fields["state"] != "on" {
+		t.Errorf("Expected metric to be unchanged, got %v", m.Fields)
+	}
+}
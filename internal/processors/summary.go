@@ -0,0 +1,392 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the daily summary, which aggregates fields per series
+// and day (e.g. the minimum and maximum temperature or the energy of the
+// day) and emits the summaries once the day has ended, so they don't have to
+// be computed by scheduled queries in the database.
+package processors
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	// defaultSummaryMaxGap is the default longest gap counted as uptime
+	defaultSummaryMaxGap = 10 * time.Minute
+
+	// summarySaveInterval is how often the state of a series is persisted
+	summarySaveInterval = time.Minute
+
+	// summaryStoragePrefix is the storage key prefix of daily summary states
+	summaryStoragePrefix = "summary."
+
+	// summarySuffix is appended to the measurement name of the summaries
+	summarySuffix = "_daily"
+)
+
+// defaultSummaryAggregates are the aggregates of rules without aggregates.
+var defaultSummaryAggregates = []string{config.SummaryMin, config.SummaryMax, config.SummaryMean}
+
+// summaryRule is a DailySummaryRule with parsed settings.
+type summaryRule struct {
+	config.DailySummaryRule
+	location *time.Location
+	maxGap   time.Duration
+}
+
+// fieldSummary is the summary of a field for the current day.
+type fieldSummary struct {
+	Count    int       `json:"count"`
+	Min      float64   `json:"min"`
+	Max      float64   `json:"max"`
+	Sum      float64   `json:"sum"`
+	Increase float64   `json:"increase"`
+	Integral float64   `json:"integral"`
+	Uptime   float64   `json:"uptime"` // seconds
+	Last     float64   `json:"last"`
+	LastTime time.Time `json:"last_time"`
+}
+
+// seriesSummary is the persisted state of the summary of a series for a day.
+type seriesSummary struct {
+	Rule   int                      `json:"rule"`
+	Name   string                   `json:"name"`
+	Tags   map[string]string        `json:"tags,omitempty"`
+	Start  time.Time                `json:"start"`
+	End    time.Time                `json:"end"`
+	Fields map[string]*fieldSummary `json:"fields"`
+
+	// saved is when the state was last persisted
+	saved time.Time
+}
+
+// DailySummary emits the summaries of the fields of a series per day as
+// <measurement>_daily metrics. The summaries of a day are emitted with the
+// first metric after its end, as no metrics are processed without one. The
+// state is persisted in storage, so a restart doesn't lose the day.
+type DailySummary struct {
+	rules   []summaryRule
+	storage *utils.Storage
+	clock   utils.Clock // for metrics without timestamp
+
+	mu     sync.Mutex
+	series map[string]*seriesSummary
+	// nextEnd is the earliest end of the days of the series
+	nextEnd time.Time
+}
+
+// NewDailySummary creates a daily summary with the given rules.
+// If storage is nil, the summaries are kept in memory only.
+func NewDailySummary(rules []config.DailySummaryRule, storage *utils.Storage) *DailySummary {
+	parsed := make([]summaryRule, 0, len(rules))
+	for _, rule := range rules {
+		parsed = append(parsed, parseSummaryRule(rule))
+	}
+
+	s := &DailySummary{
+		rules:   parsed,
+		storage: storage,
+		clock:   utils.SystemClock,
+		series:  make(map[string]*seriesSummary),
+	}
+	s.load()
+	return s
+}
+
+// parseSummaryRule parses the aggregates, timezone and max gap of a rule,
+// falling back to the defaults for invalid values.
+func parseSummaryRule(rule config.DailySummaryRule) summaryRule {
+	parsed := summaryRule{
+		DailySummaryRule: rule,
+		location:         utils.Location(),
+		maxGap:           defaultSummaryMaxGap,
+	}
+
+	parsed.Aggregates = nil
+	for _, aggregate := range rule.Aggregates {
+		switch aggregate {
+		case config.SummaryMin, config.SummaryMax, config.SummaryMean,
+			config.SummaryIncrease, config.SummaryIntegral, config.SummaryUptime:
+			parsed.Aggregates = append(parsed.Aggregates, aggregate)
+		default:
+			utils.Warnf("[pipeline] unknown daily summary aggregate '%s', ignoring it", aggregate)
+		}
+	}
+	if len(parsed.Aggregates) == 0 {
+		parsed.Aggregates = defaultSummaryAggregates
+	}
+	if rule.Timezone != "" {
+		if location, err := time.LoadLocation(rule.Timezone); err == nil {
+			parsed.location = location
+		} else {
+			utils.Warnf("[pipeline] invalid daily summary timezone '%s', using the global timezone: %v", rule.Timezone, err)
+		}
+	}
+	if rule.MaxGap > 0 {
+		parsed.maxGap = rule.MaxGap.Duration()
+	}
+	return parsed
+}
+
+// load restores the summaries of the current days from storage, including
+// series that don't report again, so their day is still summarized.
+func (s *DailySummary) load() {
+	if s.storage == nil {
+		return
+	}
+	for _, key := range s.storage.Keys() {
+		if !strings.HasPrefix(key, summaryStoragePrefix) {
+			continue
+		}
+		// Stored values are generic JSON maps, so decode them via JSON
+		var state seriesSummary
+		data, err := json.Marshal(s.storage.Get(key))
+		if err == nil {
+			err = json.Unmarshal(data, &state)
+		}
+		if err != nil || state.Rule < 0 || state.Rule >= len(s.rules) || state.Fields == nil {
+			utils.Warnf("[pipeline] ignoring invalid daily summary state %s", key)
+			continue
+		}
+		s.series[strings.TrimPrefix(key, summaryStoragePrefix)] = &state
+		s.updateNextEnd(state.End)
+	}
+}
+
+// Process implements the Processor interface. The summaries are only passed
+// on by Expand.
+func (s *DailySummary) Process(m metrics.Metric) (metrics.Metric, bool) {
+	return s.Expand(m)[0], true
+}
+
+// Expand returns the metric followed by the summaries of the days that ended
+// before it.
+func (s *DailySummary) Expand(m metrics.Metric) []metrics.Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	timestamp := m.Timestamp
+	if timestamp.IsZero() {
+		timestamp = s.clock.Now()
+	}
+
+	result := []metrics.Metric{m}
+	if !s.nextEnd.IsZero() && !timestamp.Before(s.nextEnd) {
+		result = append(result, s.endDays(timestamp)...)
+	}
+	s.add(m, timestamp)
+	return result
+}
+
+// endDays returns the summaries of the series whose day ended before the
+// timestamp. Series that reported values move on to the day of the
+// timestamp, keeping their last values, the others are removed.
+func (s *DailySummary) endDays(timestamp time.Time) []metrics.Metric {
+	var summaries []metrics.Metric
+	s.nextEnd = time.Time{}
+	for key, state := range s.series {
+		if timestamp.Before(state.End) {
+			s.updateNextEnd(state.End)
+			continue
+		}
+
+		summary, ok := state.metric(s.rules[state.Rule])
+		if !ok {
+			delete(s.series, key)
+			s.deleteState(key)
+			continue
+		}
+		summaries = append(summaries, summary)
+
+		state.Start, state.End = dayBounds(timestamp, s.rules[state.Rule].location)
+		for field, fs := range state.Fields {
+			state.Fields[field] = &fieldSummary{Last: fs.Last, LastTime: fs.LastTime}
+		}
+		s.saveState(key, state, timestamp)
+		s.updateNextEnd(state.End)
+	}
+	return summaries
+}
+
+// add adds the fields of a metric to the summaries of its series.
+func (s *DailySummary) add(m metrics.Metric, timestamp time.Time) {
+	for field, value := range m.Fields {
+		current, ok := toFloat(value)
+		if !ok {
+			continue
+		}
+		index, ok := s.matchRule(m.Name, field)
+		if !ok {
+			continue
+		}
+
+		key := summaryKey(index, m)
+		state := s.series[key]
+		if state == nil {
+			state = &seriesSummary{
+				Rule:   index,
+				Name:   m.Name,
+				Tags:   make(map[string]string, len(m.Tags)),
+				Fields: make(map[string]*fieldSummary),
+			}
+			for tag, value := range m.Tags {
+				state.Tags[tag] = value
+			}
+			state.Start, state.End = dayBounds(timestamp, s.rules[index].location)
+			s.series[key] = state
+			s.updateNextEnd(state.End)
+		}
+		if timestamp.Before(state.Start) {
+			// Values of an ended day, e.g. delayed by the reorder window
+			continue
+		}
+
+		fs := state.Fields[field]
+		if fs == nil {
+			fs = &fieldSummary{}
+			state.Fields[field] = fs
+		}
+		fs.add(current, timestamp, state.Start, s.rules[index].maxGap)
+
+		if timestamp.Sub(state.saved) >= summarySaveInterval {
+			s.saveState(key, state, timestamp)
+		}
+	}
+}
+
+// matchRule returns the index of the first rule matching the measurement and field.
+func (s *DailySummary) matchRule(measurement, field string) (int, bool) {
+	for i, rule := range s.rules {
+		if matchesField(rule.Measurement, rule.Fields, measurement, field) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// updateNextEnd records the end of a day of a series.
+func (s *DailySummary) updateNextEnd(end time.Time) {
+	if s.nextEnd.IsZero() || end.Before(s.nextEnd) {
+		s.nextEnd = end
+	}
+}
+
+// saveState persists the summary of a series.
+func (s *DailySummary) saveState(key string, state *seriesSummary, timestamp time.Time) {
+	state.saved = timestamp
+	if s.storage == nil {
+		return
+	}
+	if err := s.storage.Set(summaryStoragePrefix+key, state); err != nil {
+		utils.Warnf("[pipeline] failed to persist daily summary state for %s: %v", key, err)
+	}
+}
+
+// deleteState removes the persisted summary of a series.
+func (s *DailySummary) deleteState(key string) {
+	if s.storage == nil {
+		return
+	}
+	if err := s.storage.Delete(summaryStoragePrefix + key); err != nil {
+		utils.Warnf("[pipeline] failed to remove daily summary state for %s: %v", key, err)
+	}
+}
+
+// summaryKey returns the key of the summary of a series by a rule. A series
+// matched by several rules has a summary per rule.
+func summaryKey(rule int, m metrics.Metric) string {
+	return seriesKey(m, "") + "#" + strconv.Itoa(rule)
+}
+
+// dayBounds returns the start and end of the day of the timestamp in the location.
+func dayBounds(timestamp time.Time, location *time.Location) (time.Time, time.Time) {
+	local := timestamp.In(location)
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// add adds a value to the summary. Gaps since the previous value, which may
+// be from the previous day, only count from the start of the day.
+func (fs *fieldSummary) add(value float64, timestamp, start time.Time, maxGap time.Duration) {
+	if fs.Count == 0 || value < fs.Min {
+		fs.Min = value
+	}
+	if fs.Count == 0 || value > fs.Max {
+		fs.Max = value
+	}
+	fs.Count++
+	fs.Sum += value
+
+	if !fs.LastTime.IsZero() {
+		if value < fs.Last {
+			// Counter was reset, e.g. the device's daily yield at midnight
+			fs.Increase += value
+		} else {
+			fs.Increase += value - fs.Last
+		}
+
+		elapsed := timestamp.Sub(fs.LastTime)
+		if elapsed > 0 && elapsed <= maxGap {
+			if fs.LastTime.Before(start) {
+				elapsed = timestamp.Sub(start)
+			}
+			fs.Uptime += elapsed.Seconds()
+			fs.Integral += (fs.Last + value) / 2 * elapsed.Hours()
+		}
+	}
+	fs.Last = value
+	fs.LastTime = timestamp
+}
+
+// metric returns the summary metric of the day. It returns false if the
+// series didn't report values during the day.
+func (state *seriesSummary) metric(rule summaryRule) (metrics.Metric, bool) {
+	fields := make(map[string]interface{})
+	day := state.End.Sub(state.Start).Seconds()
+	for field, fs := range state.Fields {
+		if fs.Count == 0 {
+			continue
+		}
+		for _, aggregate := range rule.Aggregates {
+			var value float64
+			switch aggregate {
+			case config.SummaryMin:
+				value = fs.Min
+			case config.SummaryMax:
+				value = fs.Max
+			case config.SummaryMean:
+				value = fs.Sum / float64(fs.Count)
+			case config.SummaryIncrease:
+				value = fs.Increase
+			case config.SummaryIntegral:
+				value = fs.Integral
+			case config.SummaryUptime:
+				value = fs.Uptime / day * 100
+			}
+			fields[field+"_"+aggregate] = value
+		}
+	}
+	if len(fields) == 0 {
+		return metrics.Metric{}, false
+	}
+
+	// The state keeps its tags for the next day
+	tags := make(map[string]string, len(state.Tags))
+	for key, value := range state.Tags {
+		tags[key] = value
+	}
+	return metrics.Metric{
+		Name:      state.Name + summarySuffix,
+		Tags:      tags,
+		Fields:    fields,
+		Timestamp: state.Start,
+	}, true
+}
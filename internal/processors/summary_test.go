@@ -0,0 +1,133 @@
+package processors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestDailySummary(t *testing.T) {
+	summary := NewDailySummary([]config.DailySummaryRule{
+		{Measurement: "climate", Fields: []string{"temperature"}, Timezone: "Europe/Berlin"},
+		{Measurement: "electricity", Aggregates: []string{"increase", "integral", "uptime"}, Timezone: "Europe/Berlin", MaxGap: config.Duration(time.Hour)},
+	}, nil)
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	day := time.Date(2026, 10, 15, 0, 0, 0, 0, berlin)
+
+	process := func(name string, fields map[string]interface{}, timestamp time.Time) []metrics.Metric {
+		return summary.Expand(metrics.Metric{
+			Name:      name,
+			Tags:      map[string]string{"device": "dev1"},
+			Fields:    fields,
+			Timestamp: timestamp,
+		})
+	}
+
+	process("climate", map[string]interface{}{"temperature": 18.0, "humidity": 60.0}, day.Add(6*time.Hour))
+	process("climate", map[string]interface{}{"temperature": 24.0}, day.Add(14*time.Hour))
+	process("climate", map[string]interface{}{"temperature": 21.0}, day.Add(22*time.Hour))
+	// The device reports for 12 hours: 100 W, then 300 W, with a counter reset in between
+	process("electricity", map[string]interface{}{"power": 100.0, "energy": 10.0}, day.Add(6*time.Hour))
+	for hour := 7; hour <= 12; hour++ {
+		process("electricity", map[string]interface{}{"power": 100.0, "energy": 10.0 + float64(hour-6)}, day.Add(time.Duration(hour)*time.Hour))
+	}
+	for hour := 13; hour <= 18; hour++ {
+		process("electricity", map[string]interface{}{"power": 300.0, "energy": float64(hour - 12)}, day.Add(time.Duration(hour)*time.Hour))
+	}
+
+	// The summaries are emitted with the first metric of the next day
+	processed := process("climate", map[string]interface{}{"temperature": 19.0}, day.Add(24*time.Hour+5*time.Minute))
+	if len(processed) != 3 {
+		t.Fatalf("Expected the metric and two summaries, got %v", processed)
+	}
+	if processed[0].Name != "climate" {
+		t.Errorf("Expected the metric to be passed on first, got %s", processed[0].Name)
+	}
+	summaries := make(map[string]metrics.Metric)
+	for _, m := range processed[1:] {
+		summaries[m.Name] = m
+		if !m.Timestamp.Equal(day) || m.Tags["device"] != "dev1" {
+			t.Errorf("Expected summary at the start of the day with the series tags, got %v", m)
+		}
+	}
+
+	climate := summaries["climate_daily"]
+	assertTotal(t, climate, "temperature_min", 18)
+	assertTotal(t, climate, "temperature_max", 24)
+	assertTotal(t, climate, "temperature_mean", 21)
+	if _, exists := climate.Fields["humidity_min"]; exists {
+		t.Error("Expected fields not matched by a rule not to be summarized")
+	}
+
+	electricity := summaries["electricity_daily"]
+	assertTotal(t, electricity, "energy_increase", 12)
+	// 6 h at 100 W, 1 h from 100 W to 300 W, 5 h at 300 W
+	assertTotal(t, electricity, "power_integral", 600+200+1500)
+	assertTotal(t, electricity, "power_uptime", 50)
+
+	// The next day starts with the last values of the previous one
+	processed = process("electricity", map[string]interface{}{"power": 300.0, "energy": 8.0}, day.Add(24*time.Hour+30*time.Minute))
+	if len(processed) != 1 {
+		t.Errorf("Expected no further summaries, got %v", processed)
+	}
+
+	// Series without values during a day are not summarized and removed
+	processed = process("climate", map[string]interface{}{"temperature": 19.0}, day.Add(72*time.Hour))
+	if len(processed) != 3 {
+		t.Fatalf("Expected the metric and two summaries, got %v", processed)
+	}
+	for _, m := range processed[1:] {
+		if m.Name == "electricity_daily" {
+			// The counter increased since the last value of the previous day,
+			// but the gap is longer than max_gap
+			assertTotal(t, m, "energy_increase", 2)
+			assertTotal(t, m, "power_integral", 0)
+		}
+	}
+	if processed = process("climate", map[string]interface{}{"temperature": 19.0}, day.Add(96*time.Hour)); len(processed) != 2 {
+		t.Errorf("Expected only the climate summary, got %v", processed)
+	}
+}
+
+func TestDailySummaryPersistence(t *testing.T) {
+	dir := t.TempDir()
+	newStorage := func() *utils.Storage {
+		storage, err := utils.NewStorageWithConfig(&utils.StorageConfig{
+			ModuleName:   "pipeline",
+			PreferredDir: dir,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		return storage
+	}
+	rules := []config.DailySummaryRule{{Measurement: "climate", Timezone: "UTC"}}
+	day := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	climate := func(value float64, timestamp time.Time) metrics.Metric {
+		return metrics.Metric{Name: "climate", Fields: map[string]interface{}{"temperature": value}, Timestamp: timestamp}
+	}
+
+	summary := NewDailySummary(rules, newStorage())
+	summary.Expand(climate(10, day.Add(time.Hour)))
+	summary.Expand(climate(30, day.Add(2*time.Hour)))
+
+	// After a restart, the day is summarized including the values before it
+	summary = NewDailySummary(rules, newStorage())
+	processed := summary.Expand(metrics.Metric{Name: "other", Fields: map[string]interface{}{"value": 1.0}, Timestamp: day.Add(25 * time.Hour)})
+	if len(processed) != 2 {
+		t.Fatalf("Expected the summary of the stored day, got %v", processed)
+	}
+	assertTotal(t, processed[1], "temperature_min", 10)
+	assertTotal(t, processed[1], "temperature_max", 30)
+	assertTotal(t, processed[1], "temperature_mean", 20)
+}
+
+func TestPipelineNamesDailySummary(t *testing.T) {
+	pipeline := NewPipeline(NewDailySummary([]config.DailySummaryRule{{}}, nil))
+	if names := pipeline.Names(); len(names) != 1 || names[0] != "daily_summary" {
+		t.Errorf("Expected daily_summary, got %v", names)
+	}
+}
@@ -0,0 +1,43 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the tagger, which adds fixed tags such as the agent ID
+// to every metric.
+package processors
+
+import (
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// Tagger adds fixed tags to every metric, overriding tags of the same name set
+// by modules. A nil tagger leaves metrics unchanged.
+type Tagger struct {
+	tags map[string]string
+}
+
+// NewTagger creates a tagger adding the given tags. It returns nil if tags is empty.
+func NewTagger(tags map[string]string) *Tagger {
+	if len(tags) == 0 {
+		return nil
+	}
+	return &Tagger{
+		tags: tags,
+	}
+}
+
+// Process implements the Processor interface. The tags of the metric are
+// copied, so maps that modules still hold references to are not modified.
+func (t *Tagger) Process(m metrics.Metric) (metrics.Metric, bool) {
+	if t == nil {
+		return m, true
+	}
+
+	tags := make(map[string]string, len(m.Tags)+len(t.tags))
+	for key, value := range m.Tags {
+		tags[key] = value
+	}
+	for key, value := range t.tags {
+		tags[key] = value
+	}
+	m.Tags = tags
+	return m, true
+}
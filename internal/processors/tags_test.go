@@ -0,0 +1,36 @@
+package processors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestTagger(t *testing.T) {
+	tagger := NewTagger(map[string]string{"agent_id": "a1b2", "device": "override"})
+	tags := map[string]string{"device": "plug1", "vendor": "tasmota"}
+	m, keep := tagger.Process(metrics.Metric{Name: "power", Tags: tags, Fields: map[string]interface{}{"value": 1}})
+	if !keep {
+		t.Fatal("Expected metric to be kept")
+	}
+
+	expected := map[string]string{"agent_id": "a1b2", "device": "override", "vendor": "tasmota"}
+	if !reflect.DeepEqual(m.Tags, expected) {
+		t.Errorf("Expected tags %v, got %v", expected, m.Tags)
+	}
+	if len(tags) != 2 || tags["device"] != "plug1" {
+		t.Error("Expected the original tags not to be modified")
+	}
+}
+
+func TestTaggerNil(t *testing.T) {
+	tagger := NewTagger(nil)
+	if tagger != nil {
+		t.Fatal("Expected no tagger without tags")
+	}
+	m, keep := tagger.Process(metrics.Metric{Name: "power", Tags: map[string]string{"device": "plug1"}})
+	if !keep || len(m.Tags) != 1 {
+		t.Errorf("Expected metric to be unchanged, got %v", m.Tags)
+	}
+}
@@ -0,0 +1,213 @@
+// Package processors provides the metric processing pipeline.
+//
+// This file contains the type guard, which detects fields changing their
+// type, e.g. a power reading arriving as string after a firmware update. The
+// database rejects values of another type than the field has, so without it
+// the data silently disappears downstream.
+package processors
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// Field types as written in Line Protocol
+const (
+	typeFloat   = "float"
+	typeInteger = "integer"
+	typeBoolean = "boolean"
+	typeString  = "string"
+)
+
+// TypeGuard tracks the type of every field per series and handles values of
+// another type than the first value of the series according to its policy.
+// The first type is kept, as it is the one the database expects. Types are
+// tracked in memory, so they are learned anew when the agent restarts.
+// Metrics without remaining fields are dropped.
+type TypeGuard struct {
+	policy string
+
+	mu     sync.Mutex
+	types  map[string]string // type of the first value by series key
+	warned map[string]bool   // series whose type change was logged as warning
+
+	changed atomic.Int64
+	coerced atomic.Int64
+	dropped atomic.Int64
+}
+
+// NewTypeGuard creates a type guard with one of the config.TypeChange* policies.
+func NewTypeGuard(policy string) (*TypeGuard, error) {
+	switch policy {
+	case config.TypeChangeWarn, config.TypeChangeCoerce, config.TypeChangeDrop:
+	default:
+		return nil, fmt.Errorf("unknown type_changes policy %q (use %q, %q or %q)",
+			policy, config.TypeChangeWarn, config.TypeChangeCoerce, config.TypeChangeDrop)
+	}
+	return &TypeGuard{
+		policy: policy,
+		types:  make(map[string]string),
+		warned: make(map[string]bool),
+	}, nil
+}
+
+// Process implements the Processor interface.
+func (tg *TypeGuard) Process(m metrics.Metric) (metrics.Metric, bool) {
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+
+	var fields map[string]interface{}
+	for field, value := range m.Fields {
+		current := fieldType(value)
+		if current == "" {
+			continue
+		}
+		key := seriesKey(m, field)
+		expected, known := tg.types[key]
+		if !known {
+			tg.types[key] = current
+			continue
+		}
+		if current == expected {
+			continue
+		}
+
+		tg.changed.Add(1)
+		if tg.policy == config.TypeChangeWarn {
+			tg.log(key, m, field, value, expected, "passing it on")
+			continue
+		}
+		if fields == nil {
+			fields = copyFields(m.Fields)
+		}
+		if tg.policy == config.TypeChangeCoerce {
+			if coerced, ok := coerceValue(value, expected); ok {
+				fields[field] = coerced
+				tg.coerced.Add(1)
+				tg.log(key, m, field, value, expected, "converting it")
+				continue
+			}
+		}
+		delete(fields, field)
+		tg.dropped.Add(1)
+		tg.log(key, m, field, value, expected, "dropping it")
+	}
+
+	if fields == nil {
+		return m, true
+	}
+	m.Fields = fields
+	return m, len(fields) > 0
+}
+
+// Stats returns the number of values of a changed type, and how many of
+// them were converted and dropped.
+func (tg *TypeGuard) Stats() map[string]int64 {
+	return map[string]int64{
+		"type_changed": tg.changed.Load(),
+		"type_coerced": tg.coerced.Load(),
+		"type_dropped": tg.dropped.Load(),
+	}
+}
+
+// log warns about the first type change of a series, and logs the following
+// ones at debug level, so a device sending the new type on every poll doesn't
+// flood the log.
+func (tg *TypeGuard) log(key string, m metrics.Metric, field string, value interface{}, expected, action string) {
+	if tg.warned[key] {
+		utils.Debugf("[pipeline] %s.%s=%v of %s is a %s instead of a %s, %s", m.Name, field, value, m.Tags["device"], fieldType(value), expected, action)
+		return
+	}
+	tg.warned[key] = true
+	utils.Warnf("[pipeline] field %s.%s of %s changed its type from %s to %s (value %q), %s (further ones are logged at debug level)",
+		m.Name, field, m.Tags["device"], expected, fieldType(value), fmt.Sprint(value), action)
+}
+
+// fieldType returns the Line Protocol type a value is written as, or "" for
+// values that are converted when writing, e.g. missing values and collections.
+func fieldType(value interface{}) string {
+	switch v := value.(type) {
+	case float32, float64:
+		return typeFloat
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return typeInteger
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return typeInteger
+		}
+		return typeFloat
+	case bool:
+		return typeBoolean
+	case string:
+		return typeString
+	default:
+		return ""
+	}
+}
+
+// coerceValue converts a value to the given type. It returns false if the
+// value can't be represented in that type, e.g. "n/a" as float or 1.5 as
+// integer.
+func coerceValue(value interface{}, target string) (interface{}, bool) {
+	s, isString := value.(string)
+	s = strings.TrimSpace(s)
+	b, isBool := value.(bool)
+
+	switch target {
+	case typeFloat:
+		if isBool {
+			return boolToFloat(b), true
+		}
+		f, ok := toFloat(value)
+		if isString {
+			var err error
+			f, err = strconv.ParseFloat(s, 64)
+			ok = err == nil
+		}
+		if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, false
+		}
+		return f, true
+	case typeInteger:
+		if isBool {
+			return int64(boolToFloat(b)), true
+		}
+		if isString {
+			i, err := strconv.ParseInt(s, 10, 64)
+			return i, err == nil
+		}
+		f, ok := toFloat(value)
+		if !ok || f != math.Trunc(f) || math.Abs(f) > math.MaxInt64 {
+			return nil, false
+		}
+		return int64(f), true
+	case typeBoolean:
+		if isString {
+			parsed, err := strconv.ParseBool(s)
+			return parsed, err == nil
+		}
+		f, ok := toFloat(value)
+		return f != 0, ok
+	case typeString:
+		return fmt.Sprint(value), true
+	default:
+		return nil, false
+	}
+}
+
+// boolToFloat converts true to 1 and false to 0.
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
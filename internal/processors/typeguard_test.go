@@ -0,0 +1,102 @@
+package processors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestTypeGuard(t *testing.T) {
+	tags := map[string]string{"device": "plug1"}
+	tests := []struct {
+		policy   string
+		expected map[string]interface{}
+	}{
+		{config.TypeChangeWarn, map[string]interface{}{"power": "150.5", "voltage": "n/a", "on": true}},
+		{config.TypeChangeCoerce, map[string]interface{}{"power": 150.5, "on": true}},
+		{config.TypeChangeDrop, map[string]interface{}{"on": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			guard, err := NewTypeGuard(tt.policy)
+			if err != nil {
+				t.Fatalf("NewTypeGuard failed: %v", err)
+			}
+			first := metrics.Metric{Name: "electricity", Tags: tags, Fields: map[string]interface{}{"power": 100.0, "voltage": 230.0, "on": true}}
+			if m, keep := guard.Process(first); !keep || !reflect.DeepEqual(m.Fields, first.Fields) {
+				t.Fatalf("Expected the first metric to be passed on, got %v", m.Fields)
+			}
+
+			// A firmware update sends the readings as strings
+			changed := metrics.Metric{Name: "electricity", Tags: tags, Fields: map[string]interface{}{"power": "150.5", "voltage": "n/a", "on": true}}
+			m, keep := guard.Process(changed)
+			if !keep || !reflect.DeepEqual(m.Fields, tt.expected) {
+				t.Errorf("Expected %v, got %v (keep %v)", tt.expected, m.Fields, keep)
+			}
+			if changed.Fields["power"] != "150.5" {
+				t.Error("Expected the fields of the module to be left unchanged")
+			}
+			if stats := guard.Stats(); stats["type_changed"] != 2 {
+				t.Errorf("Expected 2 changed values, got %v", stats)
+			}
+
+			// Other series keep their own type
+			other := metrics.Metric{Name: "electricity", Tags: map[string]string{"device": "plug2"}, Fields: map[string]interface{}{"power": "150.5"}}
+			if m, _ := guard.Process(other); m.Fields["power"] != "150.5" {
+				t.Errorf("Expected the first value of another series to be passed on, got %v", m.Fields)
+			}
+		})
+	}
+}
+
+func TestTypeGuardDropsEmptyMetric(t *testing.T) {
+	guard, _ := NewTypeGuard(config.TypeChangeCoerce)
+	guard.Process(metrics.Metric{Name: "climate", Fields: map[string]interface{}{"temperature": 21.5}})
+	if _, keep := guard.Process(metrics.Metric{Name: "climate", Fields: map[string]interface{}{"temperature": "error"}}); keep {
+		t.Error("Expected metric without remaining fields to be dropped")
+	}
+	if stats := guard.Stats(); stats["type_dropped"] != 1 || stats["type_coerced"] != 0 {
+		t.Errorf("Unexpected stats %v", stats)
+	}
+}
+
+func TestCoerceValue(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		target   string
+		expected interface{}
+		ok       bool
+	}{
+		{" 42.5 ", typeFloat, 42.5, true},
+		{int64(3), typeFloat, 3.0, true},
+		{true, typeFloat, 1.0, true},
+		{"NaN", typeFloat, nil, false},
+		{"n/a", typeFloat, nil, false},
+		{"7", typeInteger, int64(7), true},
+		{4.0, typeInteger, int64(4), true},
+		{4.5, typeInteger, nil, false},
+		{"on", typeBoolean, nil, false},
+		{"true", typeBoolean, true, true},
+		{0, typeBoolean, false, true},
+		{1.5, typeString, "1.5", true},
+		{false, typeString, "false", true},
+	}
+	for _, tt := range tests {
+		value, ok := coerceValue(tt.value, tt.target)
+		if ok != tt.ok || (ok && value != tt.expected) {
+			t.Errorf("coerceValue(%#v, %s) = %#v, %v; expected %#v, %v", tt.value, tt.target, value, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestTypeGuardFromConfig(t *testing.T) {
+	if pipeline := FromConfig(config.PipelineConfig{TypeChanges: "convert"}); pipeline.Len() != 0 {
+		t.Errorf("Expected unknown policy to be ignored, got %v", pipeline.Names())
+	}
+	pipeline := FromConfig(config.PipelineConfig{TypeChanges: config.TypeChangeDrop, Round: []config.RoundRule{{Decimals: 1}}})
+	if names := pipeline.Names(); len(names) != 2 || names[1] != "type_changes" {
+		t.Errorf("Expected the type guard after the other processors, got %v", names)
+	}
+}
@@ -0,0 +1,277 @@
+// Package prometheus exposes the latest value of each series on an HTTP
+// endpoint in the Prometheus text format, for users who prefer scraping the
+// agent over receiving pushed line protocol.
+//
+// Each numeric or boolean field becomes a series named "<measurement>_<field>"
+// with the tags as labels. Fields the module marks as counter or cumulative
+// are exposed as counters, all others as gauges. A series that has not been
+// updated for the stale duration is no longer exposed, so Prometheus marks it
+// stale instead of scraping an outdated value forever.
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+const (
+	defaultPath       = "/metrics"
+	defaultStaleAfter = 5 * time.Minute
+
+	contentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// label is a single label of a series.
+type label struct {
+	name  string
+	value string
+}
+
+// sample is the latest value of a series.
+type sample struct {
+	name    string
+	labels  []label
+	value   float64
+	counter bool
+	updated time.Time
+}
+
+// Exporter keeps the latest sample of every series and writes them in the
+// Prometheus text format.
+type Exporter struct {
+	listen     string
+	path       string
+	staleAfter time.Duration
+	clock      utils.Clock
+
+	mu     sync.Mutex
+	series map[string]*sample
+}
+
+// New creates an exporter from the configuration. It returns nil if no listen
+// address is configured.
+func New(cfg config.PrometheusConfig) *Exporter {
+	if cfg.Listen == "" {
+		return nil
+	}
+
+	e := &Exporter{
+		listen:     cfg.Listen,
+		path:       cfg.Path,
+		staleAfter: cfg.StaleAfter.Duration(),
+		clock:      utils.SystemClock,
+		series:     make(map[string]*sample),
+	}
+	if e.path == "" {
+		e.path = defaultPath
+	}
+	if e.staleAfter <= 0 {
+		e.staleAfter = defaultStaleAfter
+	}
+	return e
+}
+
+// Start serves the endpoint in the background; errors are logged.
+func (e *Exporter) Start() {
+	if e == nil {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle(e.path, e)
+
+	go utils.WithPanicRecoveryAndContinue("Prometheus endpoint", "main", func() {
+		utils.Infof("Serving Prometheus metrics on http://%s%s", e.listen, e.path)
+		if err := http.ListenAndServe(e.listen, mux); err != nil {
+			utils.Errorf("Prometheus endpoint stopped: %v", err)
+		}
+	})
+}
+
+// Export records the numeric and boolean fields of a metric as the latest
+// samples of their series. String fields are skipped.
+func (e *Exporter) Export(m metrics.Metric) {
+	if e == nil {
+		return
+	}
+
+	labels := make([]label, 0, len(m.Tags))
+	for name, value := range m.Tags {
+		labels = append(labels, label{name: sanitizeName(name, false), value: value})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+
+	now := e.clock.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for field, value := range m.Fields {
+		v, ok := toFloat(value)
+		if !ok {
+			continue
+		}
+		name := sanitizeName(m.Name+"_"+field, true)
+		key := seriesKey(name, labels)
+		counter := m.FieldKind(field).IsMonotonic()
+		if s, exists := e.series[key]; exists {
+			s.value = v
+			s.counter = counter
+			s.updated = now
+			continue
+		}
+		e.series[key] = &sample{name: name, labels: labels, value: v, counter: counter, updated: now}
+	}
+}
+
+// ServeHTTP writes all current series in the Prometheus text format.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	if err := e.Write(w); err != nil {
+		utils.Debugf("Failed to write Prometheus metrics: %v", err)
+	}
+}
+
+// Write removes stale series and writes the remaining ones to w, grouped by
+// metric name and sorted for stable output.
+func (e *Exporter) Write(w io.Writer) error {
+	e.mu.Lock()
+	cutoff := e.clock.Now().Add(-e.staleAfter)
+	samples := make([]sample, 0, len(e.series))
+	for key, s := range e.series {
+		if s.updated.Before(cutoff) {
+			delete(e.series, key)
+			continue
+		}
+		samples = append(samples, *s)
+	}
+	e.mu.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].name != samples[j].name {
+			return samples[i].name < samples[j].name
+		}
+		return seriesKey(samples[i].name, samples[i].labels) < seriesKey(samples[j].name, samples[j].labels)
+	})
+
+	bw := bufio.NewWriter(w)
+	previous := ""
+	for _, s := range samples {
+		if s.name != previous {
+			metricType := "gauge"
+			if s.counter {
+				metricType = "counter"
+			}
+			fmt.Fprintf(bw, "# TYPE %s %s\n", s.name, metricType)
+			previous = s.name
+		}
+		bw.WriteString(s.name)
+		if len(s.labels) > 0 {
+			bw.WriteByte('{')
+			for i, l := range s.labels {
+				if i > 0 {
+					bw.WriteByte(',')
+				}
+				fmt.Fprintf(bw, "%s=\"%s\"", l.name, escapeLabelValue(l.value))
+			}
+			bw.WriteByte('}')
+		}
+		bw.WriteByte(' ')
+		bw.WriteString(formatValue(s.value))
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// Len returns the number of series currently held, including stale ones.
+func (e *Exporter) Len() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.series)
+}
+
+// seriesKey identifies a series by its name and labels.
+func seriesKey(name string, labels []label) string {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, l := range labels {
+		b.WriteByte(0)
+		b.WriteString(l.name)
+		b.WriteByte(0)
+		b.WriteString(l.value)
+	}
+	return b.String()
+}
+
+// sanitizeName replaces characters that are not allowed in metric names (or
+// label names, which may not contain colons) with underscores.
+func sanitizeName(name string, metric bool) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		case r == ':' && metric:
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// escapeLabelValue escapes backslashes, double quotes and line feeds.
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatValue formats a sample value, including the special values.
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// toFloat converts a numeric or boolean field value to float64.
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,104 @@
+package prometheus
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/config"
+	"github.com/janhuddel/metrics-agent/internal/testutil"
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func newTestExporter(t *testing.T) (*Exporter, *testutil.Clock) {
+	t.Helper()
+	e := New(config.PrometheusConfig{Listen: ":0", StaleAfter: config.Duration(time.Minute)})
+	clock := testutil.NewClock(time.Unix(1700000000, 0))
+	e.clock = clock
+	return e, clock
+}
+
+func TestNewWithoutListen(t *testing.T) {
+	e := New(config.PrometheusConfig{})
+	if e != nil {
+		t.Fatal("Expected nil exporter without listen address")
+	}
+	// A nil exporter discards all metrics
+	e.Export(metrics.Metric{Name: "x", Fields: map[string]interface{}{"value": 1}})
+	e.Start()
+}
+
+func TestExposition(t *testing.T) {
+	e, _ := newTestExporter(t)
+
+	e.Export(metrics.Metric{
+		Name:   "electricity",
+		Tags:   map[string]string{"device": "plug1", "friendly-name": `Kitchen "Plug"`},
+		Fields: map[string]interface{}{"power": 42, "on": true, "name": "Plug"},
+	})
+	e.Export(metrics.Metric{
+		Name:   "electricity",
+		Tags:   map[string]string{"device": "plug2"},
+		Fields: map[string]interface{}{"power": 7.5},
+	})
+	// A newer sample replaces the value of the series
+	e.Export(metrics.Metric{
+		Name:       "electricity",
+		Tags:       map[string]string{"device": "plug2"},
+		Fields:     map[string]interface{}{"power": 8.25, "sum_power_total": 1234.5},
+		FieldKinds: metrics.ElectricityKinds,
+	})
+	e.Export(metrics.Metric{Name: "1wire.temp", Fields: map[string]interface{}{"value": math.Inf(1)}})
+
+	server := httptest.NewServer(e)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	var buf strings.Builder
+	if err := e.Write(&buf); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	expected := `# TYPE _1wire_temp_value gauge
+_1wire_temp_value +Inf
+# TYPE electricity_on gauge
+electricity_on{device="plug1",friendly_name="Kitchen \"Plug\""} 1
+# TYPE electricity_power gauge
+electricity_power{device="plug1",friendly_name="Kitchen \"Plug\""} 42
+electricity_power{device="plug2"} 8.25
+# TYPE electricity_sum_power_total counter
+electricity_sum_power_total{device="plug2"} 1234.5
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected exposition:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestStaleSeries(t *testing.T) {
+	e, clock := newTestExporter(t)
+
+	e.Export(metrics.Metric{Name: "ups", Tags: map[string]string{"ups": "a"}, Fields: map[string]interface{}{"load": 10}})
+	clock.Advance(45 * time.Second)
+	e.Export(metrics.Metric{Name: "ups", Tags: map[string]string{"ups": "b"}, Fields: map[string]interface{}{"load": 20}})
+	clock.Advance(30 * time.Second)
+
+	var buf strings.Builder
+	if err := e.Write(&buf); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if strings.Contains(buf.String(), `ups="a"`) || !strings.Contains(buf.String(), `ups_load{ups="b"} 20`) {
+		t.Errorf("Expected only the fresh series, got:\n%s", buf.String())
+	}
+	if e.Len() != 1 {
+		t.Errorf("Expected stale series to be removed, got %d series", e.Len())
+	}
+}
@@ -0,0 +1,55 @@
+// Package mqtt provides the self-test check of MQTT broker credentials shared
+// by the MQTT-based modules. It is kept out of package utils, so that builds
+// without MQTT modules don't link the MQTT client.
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// CheckLogin connects to an MQTT broker with the given credentials and
+// disconnects right away. It uses a clean session with its own client ID, so
+// the persistent session of the running agent is not affected.
+func CheckLogin(ctx context.Context, broker, username, password string, timeout time.Duration) utils.Check {
+	check := utils.Check{Name: "MQTT login"}
+	if err := utils.CheckDestination(ctx, broker); err != nil {
+		check.Err = err
+		return check
+	}
+
+	opts := paho.NewClientOptions()
+	opts.AddBroker(broker)
+	opts.SetClientID(fmt.Sprintf("metrics-agent-selftest-%d", time.Now().UnixNano()))
+	opts.SetUsername(username)
+	opts.SetPassword(password)
+	opts.SetConnectTimeout(timeout)
+	opts.SetAutoReconnect(false)
+	opts.SetCleanSession(true)
+	client := paho.NewClient(opts)
+
+	token := client.Connect()
+	select {
+	case <-token.Done():
+	case <-ctx.Done():
+		check.Err = ctx.Err()
+		return check
+	}
+	if err := token.Error(); err != nil {
+		check.Err = err
+		if errors.Is(err, packets.ErrorRefusedBadUsernameOrPassword) || errors.Is(err, packets.ErrorRefusedNotAuthorised) {
+			check.Hint = "Check username and password of the broker"
+		}
+		return check
+	}
+	client.Disconnect(250)
+	check.Detail = "connected to " + broker
+	return check
+}
@@ -0,0 +1,45 @@
+package testutil
+
+import (
+	"sync"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+// Clock is a fake utils.Clock for modules and processors, and for code that
+// takes the current time as a parameter, e.g. processFeed(feed, clock.Now()).
+// It only moves when advanced.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+var _ utils.Clock = (*Clock)(nil)
+
+// NewClock creates a clock set to start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d and returns the new time.
+func (c *Clock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+// Set sets the clock to t.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
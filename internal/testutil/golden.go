@@ -0,0 +1,72 @@
+package testutil
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// update rewrites golden files instead of comparing against them:
+// go test ./internal/modules/dwd/ -update
+var update = flag.Bool("update", false, "update golden files in testdata/")
+
+// Lines serializes metrics to Line Protocol without timestamps, so the output
+// is stable across test runs. The test fails if a metric can't be serialized.
+func Lines(t testing.TB, ms []metrics.Metric) []string {
+	t.Helper()
+
+	lines := make([]string, 0, len(ms))
+	for _, m := range ms {
+		m.Timestamp = time.Time{}
+		line, err := m.ToLineProtocol()
+		if err != nil {
+			t.Fatalf("Failed to serialize metric %s: %v", m.Name, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// AssertLines compares metrics with the expected Line Protocol lines
+// (without timestamps), in order.
+func AssertLines(t testing.TB, ms []metrics.Metric, want ...string) {
+	t.Helper()
+
+	got := Lines(t, ms)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected metrics:\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// AssertGolden compares metrics with the Line Protocol lines (without
+// timestamps) in testdata/<name>.golden. Run the test with -update to create
+// or rewrite the file.
+func AssertGolden(t testing.TB, name string, ms []metrics.Metric) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name+".golden")
+	got := strings.Join(Lines(t, ms), "\n") + "\n"
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Failed to create testdata directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatalf("Failed to write golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file (run with -update to create it): %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("Metrics differ from %s (run with -update to accept):\ngot:\n%swant:\n%s", path, got, want)
+	}
+}
@@ -0,0 +1,37 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// exampleMetrics returns metrics with timestamps, which are left out of the comparison
+func exampleMetrics() []metrics.Metric {
+	return []metrics.Metric{
+		{
+			Name:      "electricity",
+			Tags:      map[string]string{"device": "plug1"},
+			Fields:    map[string]interface{}{"power": 42, "voltage": 230.5},
+			Timestamp: time.Now(),
+		},
+		{
+			Name:      "weather",
+			Tags:      map[string]string{"station": "10637"},
+			Fields:    map[string]interface{}{"warning": "storm"},
+			Timestamp: time.Now(),
+		},
+	}
+}
+
+func TestAssertLines(t *testing.T) {
+	AssertLines(t, exampleMetrics(),
+		"electricity,device=plug1 power=42i,voltage=230.500000",
+		`weather,station=10637 warning="storm"`,
+	)
+}
+
+func TestAssertGolden(t *testing.T) {
+	AssertGolden(t, "example", exampleMetrics())
+}
@@ -0,0 +1,101 @@
+package testutil
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// ServeModbus serves Modbus TCP read holding registers requests from the
+// registers of each unit ID and returns the address of the server. Registers
+// that are not set read as 0; requests to other units are answered with a
+// "gateway target device failed to respond" exception.
+func ServeModbus(t testing.TB, units map[byte]map[uint16]uint16) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveModbusConn(conn, units)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// serveModbusConn answers the requests of a connection until it is closed
+func serveModbusConn(conn net.Conn, units map[byte]map[uint16]uint16) {
+	defer conn.Close()
+	request := make([]byte, 12)
+	for {
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		registers, ok := units[request[6]]
+		if !ok {
+			response := make([]byte, 9)
+			copy(response, request[:4])
+			binary.BigEndian.PutUint16(response[4:], 3)
+			response[6] = request[6]
+			response[7] = 0x83
+			response[8] = 0x0B
+			if _, err := conn.Write(response); err != nil {
+				return
+			}
+			continue
+		}
+
+		address := binary.BigEndian.Uint16(request[8:])
+		count := binary.BigEndian.Uint16(request[10:])
+		response := make([]byte, 9+2*count)
+		copy(response, request[:4])
+		binary.BigEndian.PutUint16(response[4:], 3+2*count)
+		response[6] = request[6]
+		response[7] = 0x03
+		response[8] = byte(2 * count)
+		for i := uint16(0); i < count; i++ {
+			binary.BigEndian.PutUint16(response[9+2*i:], registers[address+i])
+		}
+		if _, err := conn.Write(response); err != nil {
+			return
+		}
+	}
+}
+
+// SunSpecDevice builds the registers of a SunSpec device for ServeModbus.
+type SunSpecDevice struct {
+	Registers map[uint16]uint16
+	next      uint16
+}
+
+// NewSunSpecDevice creates a device with the SunSpec marker at base.
+func NewSunSpecDevice(base uint16) *SunSpecDevice {
+	return &SunSpecDevice{
+		Registers: map[uint16]uint16{base: 0x5375, base + 1: 0x6E53},
+		next:      base + 2,
+	}
+}
+
+// AddModel appends a model with the given points, by offset after the model
+// header. Points that are not set read as 0.
+func (d *SunSpecDevice) AddModel(id, length uint16, points map[uint16]uint16) {
+	d.Registers[d.next] = id
+	d.Registers[d.next+1] = length
+	for offset, value := range points {
+		d.Registers[d.next+2+offset] = value
+	}
+	d.next += 2 + length
+}
+
+// End appends the end marker after the models and returns the registers.
+func (d *SunSpecDevice) End() map[uint16]uint16 {
+	d.Registers[d.next] = 0xFFFF
+	return d.Registers
+}
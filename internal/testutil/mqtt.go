@@ -0,0 +1,236 @@
+package testutil
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTTClient is a fake MQTT client for modules that create their client with
+// a factory. It records subscriptions and delivers messages to their handlers
+// without a broker. Connection loss and reconnects are simulated by calling
+// the handlers of the client options the module created the client with.
+//
+// Subscriptions survive a reconnect unless the options request a clean
+// session, like on a broker that keeps the session of the client.
+type MQTTClient struct {
+	mu            sync.Mutex
+	opts          *mqtt.ClientOptions
+	connected     bool
+	connects      int
+	subscriptions map[string]mqtt.MessageHandler // by topic filter
+	connectErr    error
+	subscribeErrs map[string]error // by topic filter
+}
+
+// NewMQTTClient creates a disconnected fake client.
+func NewMQTTClient() *MQTTClient {
+	return &MQTTClient{
+		opts:          mqtt.NewClientOptions(),
+		subscriptions: make(map[string]mqtt.MessageHandler),
+		subscribeErrs: make(map[string]error),
+	}
+}
+
+// New records the options of the module and returns the client, to be called
+// from the client factory of the module.
+func (c *MQTTClient) New(opts *mqtt.ClientOptions) *MQTTClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opts = opts
+	return c
+}
+
+// FailConnect makes Connect fail with err; nil lets it succeed again.
+func (c *MQTTClient) FailConnect(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connectErr = err
+}
+
+// FailSubscribe makes subscribing to the topic filter fail with err; nil lets
+// it succeed again.
+func (c *MQTTClient) FailSubscribe(topic string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		delete(c.subscribeErrs, topic)
+		return
+	}
+	c.subscribeErrs[topic] = err
+}
+
+// Connect connects the client and calls the OnConnect handler.
+func (c *MQTTClient) Connect() mqtt.Token {
+	c.mu.Lock()
+	if c.connectErr != nil {
+		c.mu.Unlock()
+		return newMQTTToken(c.connectErr)
+	}
+	c.connected = true
+	c.connects++
+	onConnect := c.opts.OnConnect
+	c.mu.Unlock()
+
+	if onConnect != nil {
+		onConnect(nil)
+	}
+	return newMQTTToken(nil)
+}
+
+// Subscribe records the handler of the topic filter.
+func (c *MQTTClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.subscribeErrs[topic]; err != nil {
+		return newMQTTToken(err)
+	}
+	c.subscriptions[topic] = callback
+	return newMQTTToken(nil)
+}
+
+// Unsubscribe removes the handlers of the topic filters.
+func (c *MQTTClient) Unsubscribe(topics ...string) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, topic := range topics {
+		delete(c.subscriptions, topic)
+	}
+	return newMQTTToken(nil)
+}
+
+// IsConnected reports whether the client is connected.
+func (c *MQTTClient) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+// Disconnect disconnects the client without calling the OnConnectionLost
+// handler, like the paho client.
+func (c *MQTTClient) Disconnect(quiesce uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = false
+}
+
+// LoseConnection disconnects the client and calls the OnConnectionLost handler.
+func (c *MQTTClient) LoseConnection(err error) {
+	c.mu.Lock()
+	c.connected = false
+	if c.opts.CleanSession {
+		c.subscriptions = make(map[string]mqtt.MessageHandler)
+	}
+	onLost := c.opts.OnConnectionLost
+	c.mu.Unlock()
+
+	if onLost != nil {
+		onLost(nil, err)
+	}
+}
+
+// Reconnect connects the client again after LoseConnection, like the
+// automatic reconnect of the paho client.
+func (c *MQTTClient) Reconnect() {
+	c.Connect()
+}
+
+// Connects returns how often the client connected successfully.
+func (c *MQTTClient) Connects() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connects
+}
+
+// Subscriptions returns the sorted topic filters subscribed to.
+func (c *MQTTClient) Subscriptions() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	topics := make([]string, 0, len(c.subscriptions))
+	for topic := range c.subscriptions {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Subscribed reports whether the client is subscribed to the topic filter.
+func (c *MQTTClient) Subscribed(topic string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.subscriptions[topic]
+	return ok
+}
+
+// Deliver passes a message to the handlers of all subscriptions matching the
+// topic, synchronously, and returns how many handlers received it. Messages
+// are only delivered while the client is connected.
+func (c *MQTTClient) Deliver(topic string, payload []byte) int {
+	c.mu.Lock()
+	if !c.connected {
+		c.mu.Unlock()
+		return 0
+	}
+	var handlers []mqtt.MessageHandler
+	for filter, handler := range c.subscriptions {
+		if topicMatches(filter, topic) {
+			handlers = append(handlers, handler)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(nil, &mqttMessage{topic: topic, payload: payload})
+	}
+	return len(handlers)
+}
+
+// topicMatches reports whether a topic matches a filter with the + and #
+// wildcards.
+func topicMatches(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+// mqttToken is a completed token.
+type mqttToken struct {
+	err  error
+	done chan struct{}
+}
+
+func newMQTTToken(err error) *mqttToken {
+	done := make(chan struct{})
+	close(done)
+	return &mqttToken{err: err, done: done}
+}
+
+func (t *mqttToken) Wait() bool                     { return true }
+func (t *mqttToken) WaitTimeout(time.Duration) bool { return true }
+func (t *mqttToken) Done() <-chan struct{}          { return t.done }
+func (t *mqttToken) Error() error                   { return t.err }
+
+// mqttMessage is a message delivered by the fake client.
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (m *mqttMessage) Duplicate() bool   { return false }
+func (m *mqttMessage) Qos() byte         { return 0 }
+func (m *mqttMessage) Retained() bool    { return false }
+func (m *mqttMessage) Topic() string     { return m.topic }
+func (m *mqttMessage) MessageID() uint16 { return 0 }
+func (m *mqttMessage) Payload() []byte   { return m.payload }
+func (m *mqttMessage) Ack()              {}
@@ -0,0 +1,46 @@
+package testutil
+
+import (
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"tele/plug/SENSOR", "tele/plug/SENSOR", true},
+		{"tele/+/SENSOR", "tele/plug/SENSOR", true},
+		{"tele/+/SENSOR", "tele/plug/STATE", false},
+		{"tele/#", "tele/plug/SENSOR", true},
+		{"tele/+", "tele/plug/SENSOR", false},
+		{"tele/plug/SENSOR/x", "tele/plug/SENSOR", false},
+	}
+	for _, tt := range tests {
+		if got := topicMatches(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("topicMatches(%q, %q) = %v, expected %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestMQTTClientDeliver(t *testing.T) {
+	client := NewMQTTClient()
+	var received []string
+	client.Subscribe("tele/+/SENSOR", 0, func(_ mqtt.Client, msg mqtt.Message) {
+		received = append(received, string(msg.Payload()))
+	})
+
+	if n := client.Deliver("tele/plug/SENSOR", []byte("before")); n != 0 {
+		t.Error("Expected no delivery before connecting")
+	}
+	client.Connect()
+	client.Deliver("tele/plug/SENSOR", []byte("connected"))
+	client.Unsubscribe("tele/+/SENSOR")
+	client.Deliver("tele/plug/SENSOR", []byte("unsubscribed"))
+
+	if len(received) != 1 || received[0] != "connected" {
+		t.Errorf("Expected only the message while connected and subscribed, got %v", received)
+	}
+}
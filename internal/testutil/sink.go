@@ -0,0 +1,149 @@
+// Package testutil provides helpers for testing modules and processors: a sink
+// that collects the metrics a module sends, a fake clock, a fake MQTT client
+// and assertions on the Line Protocol output, optionally against golden files.
+//
+// It lives in its own package because pkg/metrics depends on internal/utils,
+// so the test helpers in internal/utils can't use the Metric type.
+package testutil
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// DefaultTimeout is how long the sink waits for metrics by default
+const DefaultTimeout = 2 * time.Second
+
+// CollectingSink receives metrics from a module and records them.
+// It drains its channel continuously, so modules never block or drop metrics
+// because of a full channel.
+type CollectingSink struct {
+	ch chan metrics.Metric
+
+	mu      sync.Mutex
+	metrics []metrics.Metric
+	added   chan struct{}
+}
+
+// NewCollectingSink creates a sink and starts collecting. Collecting stops when
+// the test finishes.
+func NewCollectingSink(t testing.TB) *CollectingSink {
+	s := &CollectingSink{
+		ch:    make(chan metrics.Metric, 100),
+		added: make(chan struct{}),
+	}
+
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go s.collect(done)
+
+	return s
+}
+
+// Chan returns the channel to pass to the module.
+func (s *CollectingSink) Chan() chan metrics.Metric {
+	return s.ch
+}
+
+// collect records metrics until done is closed.
+func (s *CollectingSink) collect(done <-chan struct{}) {
+	for {
+		select {
+		case m := <-s.ch:
+			s.mu.Lock()
+			s.metrics = append(s.metrics, m)
+			close(s.added)
+			s.added = make(chan struct{})
+			s.mu.Unlock()
+		case <-done:
+			return
+		}
+	}
+}
+
+// Metrics returns the metrics collected so far.
+func (s *CollectingSink) Metrics() []metrics.Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]metrics.Metric(nil), s.metrics...)
+}
+
+// Named returns the metrics collected so far with the given name.
+func (s *CollectingSink) Named(name string) []metrics.Metric {
+	var named []metrics.Metric
+	for _, m := range s.Metrics() {
+		if m.Name == name {
+			named = append(named, m)
+		}
+	}
+	return named
+}
+
+// Len returns the number of metrics collected so far.
+func (s *CollectingSink) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.metrics)
+}
+
+// WaitFor waits until at least n metrics were collected and returns them.
+// The test fails if they don't arrive within DefaultTimeout.
+func (s *CollectingSink) WaitFor(t testing.TB, n int) []metrics.Metric {
+	t.Helper()
+	return s.WaitForTimeout(t, n, DefaultTimeout)
+}
+
+// WaitForTimeout waits until at least n metrics were collected and returns them.
+// The test fails if they don't arrive within timeout.
+func (s *CollectingSink) WaitForTimeout(t testing.TB, n int, timeout time.Duration) []metrics.Metric {
+	t.Helper()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		s.mu.Lock()
+		if len(s.metrics) >= n {
+			collected := append([]metrics.Metric(nil), s.metrics...)
+			s.mu.Unlock()
+			return collected
+		}
+		added := s.added
+		got := len(s.metrics)
+		s.mu.Unlock()
+
+		select {
+		case <-added:
+		case <-deadline.C:
+			t.Fatalf("Expected %d metrics within %v, got %d", n, timeout, got)
+			return nil
+		}
+	}
+}
+
+// ExpectNone fails the test if any metric arrives within d.
+func (s *CollectingSink) ExpectNone(t testing.TB, d time.Duration) {
+	t.Helper()
+	s.ExpectCount(t, 0, d)
+}
+
+// ExpectCount waits for d and fails the test unless exactly n metrics were
+// collected by then. It returns the collected metrics.
+func (s *CollectingSink) ExpectCount(t testing.TB, n int, d time.Duration) []metrics.Metric {
+	t.Helper()
+	time.Sleep(d)
+	collected := s.Metrics()
+	if len(collected) != n {
+		t.Fatalf("Expected %d metrics, got %d: %v", n, len(collected), Lines(t, collected))
+	}
+	return collected
+}
+
+// Reset discards the metrics collected so far.
+func (s *CollectingSink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = nil
+}
@@ -0,0 +1,57 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+func TestCollectingSink(t *testing.T) {
+	sink := NewCollectingSink(t)
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(5 * time.Millisecond)
+			sink.Chan() <- metrics.Metric{Name: "test", Fields: map[string]interface{}{"value": i}}
+		}
+		sink.Chan() <- metrics.Metric{Name: "other", Fields: map[string]interface{}{"value": 0}}
+	}()
+
+	collected := sink.WaitFor(t, 4)
+	if len(collected) != 4 {
+		t.Fatalf("Expected 4 metrics, got %d", len(collected))
+	}
+	if named := sink.Named("test"); len(named) != 3 {
+		t.Errorf("Expected 3 metrics named test, got %d", len(named))
+	}
+
+	sink.Reset()
+	sink.ExpectNone(t, 10*time.Millisecond)
+}
+
+func TestCollectingSinkDoesNotBlock(t *testing.T) {
+	sink := NewCollectingSink(t)
+
+	// More metrics than the channel buffer can hold
+	for i := 0; i < 250; i++ {
+		sink.Chan() <- metrics.Metric{Name: "test", Fields: map[string]interface{}{"value": i}}
+	}
+	sink.WaitFor(t, 250)
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+
+	if !clock.Now().Equal(start) {
+		t.Errorf("Expected %v, got %v", start, clock.Now())
+	}
+	if now := clock.Advance(time.Minute); !now.Equal(start.Add(time.Minute)) || !clock.Now().Equal(now) {
+		t.Errorf("Expected clock to advance by 1m, got %v", clock.Now())
+	}
+	clock.Set(start)
+	if !clock.Now().Equal(start) {
+		t.Errorf("Expected clock to be reset to %v, got %v", start, clock.Now())
+	}
+}
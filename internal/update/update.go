@@ -0,0 +1,460 @@
+// Package update checks GitHub releases for a newer version of the agent and
+// optionally installs it, because the hosts the agent runs on rarely get
+// manual attention.
+//
+// An installed update replaces the running binary, keeping the previous one
+// next to it with an ".old" suffix, and the agent exits so that telegraf or
+// systemd start the new version. Until the new version has been running for a
+// while, every start is counted; if it fails to start repeatedly, the previous
+// binary is restored and the version is not installed again.
+//
+// The checksum of a release archive is published next to it, so it only
+// detects corrupted downloads. Archives are therefore only installed with an
+// Ed25519 public key the archive must be signed with; the key is pinned in
+// the configuration, not fetched from the release origin.
+package update
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+const (
+	// defaultAPIURL is the GitHub API the latest release is looked up in
+	defaultAPIURL = "https://api.github.com"
+
+	// defaultDownloadURL is the GitHub host release archives are downloaded from
+	defaultDownloadURL = "https://github.com"
+
+	// binaryName is the name of the agent binary in release archives
+	binaryName = "metrics-agent"
+
+	// maxArchiveSize limits the size of a downloaded release archive
+	maxArchiveSize = 100 << 20
+
+	// maxSignatureSize limits the size of a downloaded archive signature
+	maxSignatureSize = 1 << 10
+
+	// requestTimeout limits a single request to GitHub
+	requestTimeout = 5 * time.Minute
+
+	// versionCheckTimeout limits running the new binary with -version
+	versionCheckTimeout = 10 * time.Second
+
+	// MaxStartAttempts is how often an installed update may start without
+	// being confirmed before the previous binary is restored.
+	MaxStartAttempts = 3
+)
+
+// Storage keys of a pending update
+const (
+	keyPendingVersion  = "pending_version"
+	keyPreviousVersion = "previous_version"
+	keyStartAttempts   = "start_attempts"
+	keyFailedVersion   = "failed_version"
+)
+
+// ErrRolledBack is returned by Start if an update failed to start too often
+// and the previous binary was restored. The agent should exit, so that the
+// previous version is started.
+var ErrRolledBack = errors.New("update failed to start, previous version restored")
+
+// ErrNoPublicKey is returned by Install if no public key is set to verify the
+// signature of the release archive with.
+var ErrNoPublicKey = errors.New("no public key to verify the release signature with")
+
+// Updater looks up the latest release of a GitHub repository and installs it
+// over the running binary. The state of an installed update is kept in storage.
+type Updater struct {
+	repository  string
+	current     string
+	publicKey   ed25519.PublicKey
+	storage     *utils.Storage
+	apiURL      string
+	downloadURL string
+	client      *http.Client
+}
+
+// New creates an updater for the given repository ("owner/name") and the
+// running version. Without storage, updates can be checked but not installed.
+func New(repository, current string, storage *utils.Storage) *Updater {
+	return &Updater{
+		repository:  repository,
+		current:     current,
+		storage:     storage,
+		apiURL:      defaultAPIURL,
+		downloadURL: defaultDownloadURL,
+		client: &http.Client{
+			Timeout:   requestTimeout,
+			Transport: utils.OutboundTransport("update", nil),
+		},
+	}
+}
+
+// SetPublicKey sets the base64-encoded Ed25519 public key release archives
+// must be signed with. Without a key, updates can be checked but not installed.
+func (u *Updater) SetPublicKey(publicKey string) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key: expected %d base64-encoded bytes", ed25519.PublicKeySize)
+	}
+	u.publicKey = key
+	return nil
+}
+
+// Latest returns the version of the latest release, e.g. "v1.4.0".
+func (u *Updater) Latest(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("%s/repos/%s/releases/latest", u.apiURL, u.repository), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up latest release: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to look up latest release: unexpected status %d", resp.StatusCode)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&release); err != nil {
+		return "", fmt.Errorf("failed to decode latest release: %w", err)
+	}
+	if _, ok := parseVersion(release.TagName); !ok {
+		return "", fmt.Errorf("latest release has no valid version: %q", release.TagName)
+	}
+	return release.TagName, nil
+}
+
+// Newer reports whether latest is a newer version than current. Versions that
+// are not of the form "v1.2.3", such as "dev", are never newer or older.
+func Newer(latest, current string) bool {
+	l, ok := parseVersion(latest)
+	if !ok {
+		return false
+	}
+	c, ok := parseVersion(current)
+	if !ok {
+		return false
+	}
+	for i := range l {
+		if l[i] != c[i] {
+			return l[i] > c[i]
+		}
+	}
+	return false
+}
+
+// IsRelease reports whether version is a release version such as "v1.2.3",
+// which can be compared to the latest release.
+func IsRelease(version string) bool {
+	_, ok := parseVersion(version)
+	return ok
+}
+
+// parseVersion parses the major, minor and patch number of a version such as
+// "v1.2.3" or "1.2.3-rc1". Pre-release and build suffixes are ignored.
+func parseVersion(version string) ([3]int, bool) {
+	var parsed [3]int
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return parsed, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
+
+// Failed reports whether version was installed before and failed to start.
+func (u *Updater) Failed(version string) bool {
+	return u.storage != nil && u.storage.GetString(keyFailedVersion) == version
+}
+
+// Install downloads the release archive of version for this platform, checks
+// its signature and its published SHA-256 checksum, checks that the contained
+// binary runs and reports version, and replaces the binary at exePath with it. The
+// replaced binary is kept as exePath + ".old" until the update is confirmed.
+// The running process is not affected; the new version is used on the next start.
+func (u *Updater) Install(ctx context.Context, version, exePath string) error {
+	if u.storage == nil {
+		return errors.New("no storage for the update state")
+	}
+	if u.publicKey == nil {
+		return ErrNoPublicKey
+	}
+
+	archiveName := fmt.Sprintf("%s-%s-%s-%s.tar.gz", binaryName, version, runtime.GOOS, runtime.GOARCH)
+	archiveURL := fmt.Sprintf("%s/%s/releases/download/%s/%s", u.downloadURL, u.repository, version, archiveName)
+	archive, err := u.download(ctx, archiveURL)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", archiveName, err)
+	}
+	checksum, err := u.download(ctx, archiveURL+".sha256")
+	if err != nil {
+		return fmt.Errorf("failed to download checksum of %s: %w", archiveName, err)
+	}
+	if err := verifyChecksum(archive, checksum); err != nil {
+		return fmt.Errorf("invalid archive %s: %w", archiveName, err)
+	}
+	signature, err := u.download(ctx, archiveURL+".sig")
+	if err != nil {
+		return fmt.Errorf("failed to download signature of %s: %w", archiveName, err)
+	}
+	if err := verifySignature(u.publicKey, archive, signature); err != nil {
+		return fmt.Errorf("invalid archive %s: %w", archiveName, err)
+	}
+
+	newPath := exePath + ".new"
+	entry := fmt.Sprintf("%s-%s-%s", binaryName, runtime.GOOS, runtime.GOARCH)
+	if err := extractBinary(archive, entry, newPath); err != nil {
+		return fmt.Errorf("failed to extract %s from %s: %w", entry, archiveName, err)
+	}
+	defer os.Remove(newPath)
+	if err := checkVersion(ctx, newPath, version); err != nil {
+		return err
+	}
+
+	// Record the update before swapping, so a crash in between is rolled back
+	err = u.storage.SetMany(map[string]interface{}{
+		keyPendingVersion:  version,
+		keyPreviousVersion: u.current,
+		keyStartAttempts:   0,
+	})
+	if err == nil {
+		err = u.storage.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record update: %w", err)
+	}
+
+	oldPath := exePath + ".old"
+	if err := os.Rename(exePath, oldPath); err != nil {
+		u.clear()
+		return fmt.Errorf("failed to keep previous binary: %w", err)
+	}
+	if err := os.Rename(newPath, exePath); err != nil {
+		if restoreErr := os.Rename(oldPath, exePath); restoreErr != nil {
+			utils.Errorf("Failed to restore previous binary %s: %v", oldPath, restoreErr)
+		}
+		u.clear()
+		return fmt.Errorf("failed to replace binary: %w", err)
+	}
+	return nil
+}
+
+// Start counts a start of an installed update that is not confirmed yet and
+// reports whether the running version is such an update. After
+// MaxStartAttempts starts, the previous binary is restored and ErrRolledBack
+// is returned. Call Confirm once the update has been running fine for a while.
+func (u *Updater) Start(exePath string) (bool, error) {
+	if u.storage == nil {
+		return false, nil
+	}
+	pending := u.storage.GetString(keyPendingVersion)
+	if pending == "" {
+		return false, nil
+	}
+	if pending != u.current {
+		// The binary was replaced by something else, e.g. a manual install
+		u.clear()
+		os.Remove(exePath + ".old")
+		return false, nil
+	}
+
+	attempts := u.storage.GetInt(keyStartAttempts) + 1
+	if attempts > MaxStartAttempts {
+		if err := os.Rename(exePath+".old", exePath); err != nil {
+			return true, fmt.Errorf("update %s failed to start %d times, but the previous binary can't be restored: %w",
+				pending, attempts-1, err)
+		}
+		u.clear()
+		u.storage.Set(keyFailedVersion, pending)
+		u.storage.Flush()
+		return true, ErrRolledBack
+	}
+
+	err := u.storage.Set(keyStartAttempts, attempts)
+	if err == nil {
+		err = u.storage.Flush()
+	}
+	if err != nil {
+		return true, fmt.Errorf("failed to count start of update: %w", err)
+	}
+	return true, nil
+}
+
+// Confirm marks the running update as working and removes the previous binary.
+func (u *Updater) Confirm(exePath string) error {
+	if u.storage == nil || u.storage.GetString(keyPendingVersion) == "" {
+		return nil
+	}
+	u.clear()
+	if err := os.Remove(exePath + ".old"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// PreviousVersion returns the version the running update replaced, or "" if
+// no update is pending.
+func (u *Updater) PreviousVersion() string {
+	if u.storage == nil || u.storage.GetString(keyPendingVersion) == "" {
+		return ""
+	}
+	return u.storage.GetString(keyPreviousVersion)
+}
+
+// clear removes the state of a pending update.
+func (u *Updater) clear() {
+	for _, key := range []string{keyPendingVersion, keyPreviousVersion, keyStartAttempts} {
+		u.storage.Delete(key)
+	}
+	if err := u.storage.Flush(); err != nil {
+		utils.Warnf("Failed to clear update state: %v", err)
+	}
+}
+
+// download fetches url, which must not be larger than maxArchiveSize.
+func (u *Updater) download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxArchiveSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxArchiveSize {
+		return nil, fmt.Errorf("larger than %d bytes", maxArchiveSize)
+	}
+	return data, nil
+}
+
+// verifyChecksum checks data against a checksum file in sha256sum format.
+func verifyChecksum(data, checksumFile []byte) error {
+	fields := strings.Fields(string(checksumFile))
+	if len(fields) == 0 {
+		return errors.New("empty checksum file")
+	}
+	expected, err := hex.DecodeString(fields[0])
+	if err != nil || len(expected) != sha256.Size {
+		return fmt.Errorf("invalid checksum %q", fields[0])
+	}
+	actual := sha256.Sum256(data)
+	if !bytes.Equal(actual[:], expected) {
+		return errors.New("checksum mismatch")
+	}
+	return nil
+}
+
+// verifySignature checks the Ed25519 signature of data, either raw or
+// base64-encoded.
+func verifySignature(publicKey ed25519.PublicKey, data, signature []byte) error {
+	if len(signature) > maxSignatureSize {
+		return errors.New("signature too large")
+	}
+	if len(signature) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+		if err != nil {
+			return fmt.Errorf("invalid signature: %w", err)
+		}
+		signature = decoded
+	}
+	if !ed25519.Verify(publicKey, data, signature) {
+		return errors.New("signature does not match the public key")
+	}
+	return nil
+}
+
+// extractBinary writes the file named entry from a tar.gz archive to dest.
+func extractBinary(archive []byte, entry, dest string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return errors.New("binary not found in archive")
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg || path.Base(header.Name) != entry {
+			continue
+		}
+
+		file, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, io.LimitReader(tr, maxArchiveSize)); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+}
+
+// checkVersion runs the binary at path with -version and checks that it
+// reports version, so a binary that doesn't run on this host is not installed.
+func checkVersion(ctx context.Context, path, version string) error {
+	ctx, cancel := context.WithTimeout(ctx, versionCheckTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, path, "-version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("new binary failed to run: %w", err)
+	}
+	if !strings.Contains(string(output), version) {
+		return fmt.Errorf("new binary reports %q instead of version %s", strings.TrimSpace(string(output)), version)
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package update
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+)
+
+const testRepository = "owner/agent"
+
+// testPublicKey and testPrivateKey sign the archives of releaseServer
+var testPublicKey, testPrivateKey, _ = ed25519.GenerateKey(nil)
+
+// newTestStorage creates a storage in a temporary directory.
+func newTestStorage(t *testing.T, dir string) *utils.Storage {
+	t.Helper()
+	storage, err := utils.NewStorageWithConfig(&utils.StorageConfig{ModuleName: "update", PreferredDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	return storage
+}
+
+// releaseArchive returns a tar.gz archive containing a script that prints
+// script output when run, named like the binaries of release archives.
+func releaseArchive(t *testing.T, output string) []byte {
+	t.Helper()
+	script := []byte(fmt.Sprintf("#!/bin/sh\necho '%s' >&2\n", output))
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	name := fmt.Sprintf("%s-%s-%s", binaryName, runtime.GOOS, runtime.GOARCH)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(script)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	tw.Write(script)
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// releaseServer serves the latest release and its archive like GitHub.
+func releaseServer(t *testing.T, version string, archive []byte) *httptest.Server {
+	t.Helper()
+	sum := sha256.Sum256(archive)
+	archiveName := fmt.Sprintf("%s-%s-%s-%s.tar.gz", binaryName, version, runtime.GOOS, runtime.GOARCH)
+	archivePath := fmt.Sprintf("/%s/releases/download/%s/%s", testRepository, version, archiveName)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/"+testRepository+"/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"tag_name": %q}`, version)
+	})
+	mux.HandleFunc(archivePath, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	})
+	mux.HandleFunc(archivePath+".sha256", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s  %s\n", hex.EncodeToString(sum[:]), archiveName)
+	})
+	signature := ed25519.Sign(testPrivateKey, archive)
+	mux.HandleFunc(archivePath+".sig", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, base64.StdEncoding.EncodeToString(signature))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestUpdater creates an updater using server instead of GitHub.
+func newTestUpdater(server *httptest.Server, current string, storage *utils.Storage) *Updater {
+	u := New(testRepository, current, storage)
+	u.apiURL = server.URL
+	u.downloadURL = server.URL
+	u.publicKey = testPublicKey
+	return u
+}
+
+// writeExecutable writes the running binary that is replaced by an update.
+func writeExecutable(t *testing.T, dir string) string {
+	t.Helper()
+	exePath := filepath.Join(dir, binaryName)
+	if err := os.WriteFile(exePath, []byte("old binary"), 0755); err != nil {
+		t.Fatalf("Failed to write executable: %v", err)
+	}
+	return exePath
+}
+
+func TestNewer(t *testing.T) {
+	tests := []struct {
+		latest, current string
+		want            bool
+	}{
+		{"v1.2.0", "v1.1.9", true},
+		{"v1.10.0", "v1.9.0", true},
+		{"v2.0.0", "v1.99.99", true},
+		{"v1.2.0", "v1.2.0", false},
+		{"v1.1.0", "v1.2.0", false},
+		{"1.2.1", "v1.2.0", true},
+		{"v1.2.1", "v1.2.0-3-gabcdef", true},
+		{"v1.2.0", "dev", false},
+		{"latest", "v1.0.0", false},
+	}
+	for _, tt := range tests {
+		if got := Newer(tt.latest, tt.current); got != tt.want {
+			t.Errorf("Newer(%q, %q) = %v, want %v", tt.latest, tt.current, got, tt.want)
+		}
+	}
+}
+
+func TestLatest(t *testing.T) {
+	server := releaseServer(t, "v1.2.0", nil)
+	u := newTestUpdater(server, "v1.1.0", nil)
+
+	latest, err := u.Latest(context.Background())
+	if err != nil {
+		t.Fatalf("Latest failed: %v", err)
+	}
+	if latest != "v1.2.0" {
+		t.Errorf("Expected v1.2.0, got %q", latest)
+	}
+}
+
+func TestInstallAndConfirm(t *testing.T) {
+	dir := t.TempDir()
+	exePath := writeExecutable(t, dir)
+	server := releaseServer(t, "v1.2.0", releaseArchive(t, "metrics-agent v1.2.0 (linux amd64)"))
+	storage := newTestStorage(t, dir)
+
+	if err := newTestUpdater(server, "v1.1.0", storage).Install(context.Background(), "v1.2.0", exePath); err != nil {
+		t.Fatalf("Install failed: %v", err)
+	}
+	if old, err := os.ReadFile(exePath + ".old"); err != nil || string(old) != "old binary" {
+		t.Fatalf("Expected previous binary to be kept, got %q (%v)", old, err)
+	}
+	if _, err := os.Stat(exePath + ".new"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no leftover .new file, got %v", err)
+	}
+
+	// The new version counts its start until it is confirmed
+	updated := newTestUpdater(server, "v1.2.0", storage)
+	pending, err := updated.Start(exePath)
+	if err != nil || !pending {
+		t.Fatalf("Expected pending update, got %v (%v)", pending, err)
+	}
+	if previous := updated.PreviousVersion(); previous != "v1.1.0" {
+		t.Errorf("Expected previous version v1.1.0, got %q", previous)
+	}
+	if err := updated.Confirm(exePath); err != nil {
+		t.Fatalf("Confirm failed: %v", err)
+	}
+	if _, err := os.Stat(exePath + ".old"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected previous binary to be removed after confirming, got %v", err)
+	}
+	if pending, _ := updated.Start(exePath); pending {
+		t.Error("Expected no pending update after confirming")
+	}
+}
+
+func TestInstallRejectsBadReleases(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive []byte
+		corrupt bool
+	}{
+		{"wrong version", releaseArchive(t, "metrics-agent v1.1.0"), false},
+		{"checksum mismatch", releaseArchive(t, "metrics-agent v1.2.0"), true},
+		{"not an archive", []byte("not an archive"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			exePath := writeExecutable(t, dir)
+			server := releaseServer(t, "v1.2.0", tt.archive)
+			if tt.corrupt {
+				tt.archive[len(tt.archive)-1] ^= 0xff
+			}
+			storage := newTestStorage(t, dir)
+
+			if err := newTestUpdater(server, "v1.1.0", storage).Install(context.Background(), "v1.2.0", exePath); err == nil {
+				t.Fatal("Expected install to fail")
+			}
+			if data, _ := os.ReadFile(exePath); string(data) != "old binary" {
+				t.Errorf("Expected binary to be unchanged, got %q", data)
+			}
+			if storage.GetString(keyPendingVersion) != "" {
+				t.Error("Expected no pending update")
+			}
+		})
+	}
+}
+
+func TestInstallRequiresSignature(t *testing.T) {
+	otherKey, _, _ := ed25519.GenerateKey(nil)
+	tests := []struct {
+		name      string
+		publicKey ed25519.PublicKey
+		wantErr   error
+	}{
+		{"no public key", nil, ErrNoPublicKey},
+		{"other public key", otherKey, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			exePath := writeExecutable(t, dir)
+			server := releaseServer(t, "v1.2.0", releaseArchive(t, "metrics-agent v1.2.0"))
+			u := newTestUpdater(server, "v1.1.0", newTestStorage(t, dir))
+			u.publicKey = tt.publicKey
+
+			err := u.Install(context.Background(), "v1.2.0", exePath)
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Fatalf("Expected install to fail with %v, got %v", tt.wantErr, err)
+			}
+			if data, _ := os.ReadFile(exePath); string(data) != "old binary" {
+				t.Errorf("Expected binary to be unchanged, got %q", data)
+			}
+		})
+	}
+}
+
+func TestSetPublicKey(t *testing.T) {
+	u := New(testRepository, "v1.1.0", nil)
+	if err := u.SetPublicKey("not a key"); err == nil {
+		t.Error("Expected invalid key to be rejected")
+	}
+	if err := u.SetPublicKey(base64.StdEncoding.EncodeToString(testPublicKey)); err != nil || !u.publicKey.Equal(testPublicKey) {
+		t.Errorf("Expected key to be set, got %v", err)
+	}
+}
+
+func TestStartRollsBack(t *testing.T) {
+	dir := t.TempDir()
+	exePath := writeExecutable(t, dir)
+	server := releaseServer(t, "v1.2.0", releaseArchive(t, "metrics-agent v1.2.0"))
+	storage := newTestStorage(t, dir)
+
+	if err := newTestUpdater(server, "v1.1.0", storage).Install(context.Background(), "v1.2.0", exePath); err != nil {
+		t.Fatalf("Install failed: %v", err)
+	}
+
+	updated := newTestUpdater(server, "v1.2.0", storage)
+	for i := 0; i < MaxStartAttempts; i++ {
+		if _, err := updated.Start(exePath); err != nil {
+			t.Fatalf("Start %d failed: %v", i+1, err)
+		}
+	}
+	if _, err := updated.Start(exePath); !errors.Is(err, ErrRolledBack) {
+		t.Fatalf("Expected rollback after %d starts, got %v", MaxStartAttempts, err)
+	}
+	if data, _ := os.ReadFile(exePath); string(data) != "old binary" {
+		t.Errorf("Expected previous binary to be restored, got %q", data)
+	}
+
+	// The previous version doesn't install the failed version again
+	previous := newTestUpdater(server, "v1.1.0", storage)
+	if pending, err := previous.Start(exePath); pending || err != nil {
+		t.Errorf("Expected no pending update after rollback, got %v (%v)", pending, err)
+	}
+	if !previous.Failed("v1.2.0") {
+		t.Error("Expected v1.2.0 to be recorded as failed")
+	}
+}
@@ -0,0 +1,120 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains the audit log of outbound connections. When enabled, every
+// HTTP request and every connection to a broker or server is recorded with the
+// module that made it, so users can verify that the agent only talks to the
+// services they configured. HTTP requests are recorded by OutboundTransport.
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry is a single line of the audit log.
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	Module     string    `json:"module"`
+	Method     string    `json:"method"`
+	Host       string    `json:"host"`
+	Path       string    `json:"path,omitempty"`
+	Status     int       `json:"status,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+var (
+	auditMu     sync.Mutex
+	auditWriter io.Writer
+	auditFile   *os.File
+)
+
+// OpenAuditLog enables the audit log and appends entries to the file at path.
+// An already open audit log is closed first.
+func OpenAuditLog(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if auditFile != nil {
+		auditFile.Close()
+	}
+	auditFile = file
+	auditWriter = file
+	return nil
+}
+
+// SetAuditWriter enables the audit log and writes entries to w (for testing
+// purposes). A nil writer disables the audit log.
+func SetAuditWriter(w io.Writer) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditWriter = w
+}
+
+// CloseAuditLog disables the audit log and closes its file.
+func CloseAuditLog() error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditWriter = nil
+	if auditFile == nil {
+		return nil
+	}
+	err := auditFile.Close()
+	auditFile = nil
+	return err
+}
+
+// AuditEnabled reports whether the audit log is enabled.
+func AuditEnabled() bool {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	return auditWriter != nil
+}
+
+// WriteAuditEntry appends an entry to the audit log as a JSON line. It does
+// nothing if the audit log is disabled.
+func WriteAuditEntry(entry AuditEntry) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if auditWriter == nil {
+		return
+	}
+
+	entry.Error = RedactSecrets(entry.Error)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		Warnf("Failed to encode audit log entry: %v", err)
+		return
+	}
+	if _, err := auditWriter.Write(append(data, '\n')); err != nil {
+		Warnf("Failed to write audit log: %v", err)
+	}
+}
+
+// AuditConnect records a connection attempt of a module to a broker or server,
+// e.g. protocol "mqtt" and address "tcp://broker:1883". Addresses without a
+// scheme are taken as host:port. The attempt is counted as upstream call of
+// the module (see RecordCall).
+func AuditConnect(module, protocol, address string, duration time.Duration, err error) {
+	entry := AuditEntry{
+		Time:       time.Now(),
+		Module:     module,
+		Method:     strings.ToUpper(protocol),
+		Host:       destinationAddress(address),
+		DurationMs: duration.Milliseconds(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	WriteAuditEntry(entry)
+	RecordCall(module, 0, err)
+}
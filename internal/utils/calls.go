@@ -0,0 +1,126 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains the counters of upstream calls per module: HTTP requests
+// sent by OutboundTransport and connection attempts recorded by AuditConnect.
+// The supervisor compares the share of failed calls in a rolling window with
+// the module's error budget, e.g. to notice an exhausted API quota or an
+// expired token before the module stops working entirely.
+package utils
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// callBucketSize is the resolution of the rolling windows of upstream calls
+	callBucketSize = time.Minute
+
+	// MaxCallWindow is the longest window upstream calls can be counted in
+	MaxCallWindow = 24 * time.Hour
+)
+
+// CallCounts is the number of upstream calls of a module in a window.
+type CallCounts struct {
+	Calls    int // All calls, including failed ones
+	Failures int // Failed calls
+}
+
+// FailureRatio returns the share of failed calls, or 0 without calls.
+func (c CallCounts) FailureRatio() float64 {
+	if c.Calls == 0 {
+		return 0
+	}
+	return float64(c.Failures) / float64(c.Calls)
+}
+
+// callBucket counts the calls of a module that started in one bucket interval.
+type callBucket struct {
+	start    time.Time
+	calls    int
+	failures int
+}
+
+// CallTracker counts the upstream calls of modules in buckets covering the
+// last MaxCallWindow. It is safe for concurrent use.
+type CallTracker struct {
+	clock Clock
+
+	mu      sync.Mutex
+	buckets map[string][]callBucket
+}
+
+// NewCallTracker creates an empty call tracker.
+func NewCallTracker(clock Clock) *CallTracker {
+	return &CallTracker{
+		clock:   clock,
+		buckets: make(map[string][]callBucket),
+	}
+}
+
+// Record counts a call of a module.
+func (t *CallTracker) Record(module string, failed bool) {
+	if module == "" {
+		return
+	}
+	now := t.clock.Now()
+	start := now.Truncate(callBucketSize)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	buckets := t.buckets[module]
+	if len(buckets) == 0 || buckets[len(buckets)-1].start.Before(start) {
+		// Drop buckets that left the longest window
+		expired := 0
+		for expired < len(buckets) && now.Sub(buckets[expired].start) > MaxCallWindow {
+			expired++
+		}
+		buckets = append(buckets[expired:], callBucket{start: start})
+	}
+	last := &buckets[len(buckets)-1]
+	last.calls++
+	if failed {
+		last.failures++
+	}
+	t.buckets[module] = buckets
+}
+
+// Counts returns the calls of a module in the given window, at minute
+// resolution. Windows longer than MaxCallWindow are shortened.
+func (t *CallTracker) Counts(module string, window time.Duration) CallCounts {
+	if window > MaxCallWindow {
+		window = MaxCallWindow
+	}
+	since := t.clock.Now().Add(-window)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var counts CallCounts
+	for _, bucket := range t.buckets[module] {
+		if !bucket.start.Add(callBucketSize).After(since) {
+			continue
+		}
+		counts.Calls += bucket.calls
+		counts.Failures += bucket.failures
+	}
+	return counts
+}
+
+// calls counts the upstream calls of all modules
+var calls = NewCallTracker(SystemClock)
+
+// RecordCall counts an upstream call of a module. Calls that failed with an
+// error, a server error, a rejected authorization or an exhausted quota
+// (status 401, 403, 429 or 5xx) count as failed; other statuses as successful.
+// Pass status 0 for calls without HTTP status.
+func RecordCall(module string, status int, err error) {
+	failed := err != nil || status >= http.StatusInternalServerError ||
+		status == http.StatusUnauthorized || status == http.StatusForbidden || status == http.StatusTooManyRequests
+	calls.Record(module, failed)
+}
+
+// ModuleCalls returns the upstream calls of a module in the given window.
+func ModuleCalls(module string, window time.Duration) CallCounts {
+	return calls.Counts(module, window)
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that returns a settable time.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestCallTracker(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 10, 16, 10, 0, 30, 0, time.UTC)}
+	tracker := NewCallTracker(clock)
+
+	tracker.Record("netatmo", false)
+	tracker.Record("netatmo", true)
+	clock.now = clock.now.Add(30 * time.Minute)
+	tracker.Record("netatmo", false)
+	tracker.Record("netatmo", true)
+	tracker.Record("tibber", true)
+
+	if counts := tracker.Counts("netatmo", time.Hour); counts != (CallCounts{Calls: 4, Failures: 2}) {
+		t.Errorf("Expected 4 calls with 2 failures in the last hour, got %+v", counts)
+	}
+	if counts := tracker.Counts("netatmo", 5*time.Minute); counts != (CallCounts{Calls: 2, Failures: 1}) {
+		t.Errorf("Expected 2 calls with 1 failure in the last 5 minutes, got %+v", counts)
+	}
+	if ratio := tracker.Counts("netatmo", time.Hour).FailureRatio(); ratio != 0.5 {
+		t.Errorf("Expected failure ratio 0.5, got %v", ratio)
+	}
+	if counts := tracker.Counts("unknown", time.Hour); counts != (CallCounts{}) || counts.FailureRatio() != 0 {
+		t.Errorf("Expected no calls for unknown module, got %+v", counts)
+	}
+
+	// Calls older than the longest window are dropped
+	clock.now = clock.now.Add(MaxCallWindow + time.Hour)
+	tracker.Record("netatmo", false)
+	if counts := tracker.Counts("netatmo", 48*time.Hour); counts != (CallCounts{Calls: 1}) {
+		t.Errorf("Expected only the latest call, got %+v", counts)
+	}
+	if buckets := len(tracker.buckets["netatmo"]); buckets != 1 {
+		t.Errorf("Expected expired buckets to be removed, got %d buckets", buckets)
+	}
+}
+
+func TestRecordCall(t *testing.T) {
+	module := "calltest"
+	for _, call := range []struct {
+		status int
+		err    error
+	}{
+		{http.StatusOK, nil},
+		{http.StatusNotFound, nil},
+		{0, nil},
+		{http.StatusUnauthorized, nil},
+		{http.StatusTooManyRequests, nil},
+		{http.StatusBadGateway, nil},
+		{0, errors.New("connection refused")},
+	} {
+		RecordCall(module, call.status, call.err)
+	}
+	if counts := ModuleCalls(module, time.Hour); counts != (CallCounts{Calls: 7, Failures: 4}) {
+		t.Errorf("Expected 7 calls with 4 failures, got %+v", counts)
+	}
+}
+
+func TestOutboundTransportRecordsCalls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/quota" {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: OutboundTransport("transporttest", nil)}
+	for _, path := range []string{"/ok", "/quota"} {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if counts := ModuleCalls("transporttest", time.Hour); counts != (CallCounts{Calls: 2, Failures: 1}) {
+		t.Errorf("Expected 2 calls with 1 failure, got %+v", counts)
+	}
+}
@@ -0,0 +1,44 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains the clock abstraction. Modules and processors take the
+// current time from a Clock instead of calling time.Now directly, so tests can
+// control timestamps with a fake clock (see testutil.Clock).
+package utils
+
+import (
+	"context"
+	"time"
+)
+
+// Clock provides the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock returning the system time.
+var SystemClock Clock = systemClock{}
+
+// systemClock implements Clock using time.Now.
+type systemClock struct{}
+
+// Now returns the current system time.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// clockContextKey is the context key for the clock of the current module.
+type clockContextKey struct{}
+
+// WithClock returns a context carrying the clock modules take the current time from.
+func WithClock(ctx context.Context, clock Clock) context.Context {
+	return context.WithValue(ctx, clockContextKey{}, clock)
+}
+
+// ClockFromContext returns the clock carried by the context, or SystemClock if
+// there is none.
+func ClockFromContext(ctx context.Context) Clock {
+	if clock, ok := ctx.Value(clockContextKey{}).(Clock); ok && clock != nil {
+		return clock
+	}
+	return SystemClock
+}
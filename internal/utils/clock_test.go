@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fixedClock is a clock that always returns the same time
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestClockFromContext(t *testing.T) {
+	if clock := ClockFromContext(context.Background()); clock != SystemClock {
+		t.Errorf("Expected the system clock without a clock in the context, got %v", clock)
+	}
+
+	fixed := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	ctx := WithClock(context.Background(), fixedClock(fixed))
+	if now := ClockFromContext(ctx).Now(); !now.Equal(fixed) {
+		t.Errorf("Expected %v from the clock in the context, got %v", fixed, now)
+	}
+}
@@ -0,0 +1,102 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains the collection log of interval-based modules. It keeps the
+// time of the last successful collection in the module's storage, so a module
+// restarted within its interval doesn't collect the same data again, and
+// modules whose API has a history can backfill the gap since the last run.
+package utils
+
+import (
+	"context"
+	"time"
+)
+
+// lastCollectionKey is the storage key of the time of the last successful collection
+const lastCollectionKey = "last_collection"
+
+// CollectionLog records the time of the last successful collection of a module.
+// A nil log remembers nothing, so modules collect right after starting.
+type CollectionLog struct {
+	storage *Storage
+	clock   Clock
+}
+
+// NewCollectionLog creates a collection log kept in storage. It returns nil if
+// storage is nil.
+func NewCollectionLog(storage *Storage, clock Clock) *CollectionLog {
+	if storage == nil {
+		return nil
+	}
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &CollectionLog{
+		storage: storage,
+		clock:   clock,
+	}
+}
+
+// Last returns the time of the last successful collection, or the zero time
+// if none was recorded.
+func (l *CollectionLog) Last() time.Time {
+	if l == nil {
+		return time.Time{}
+	}
+	last, err := time.Parse(time.RFC3339Nano, l.storage.GetString(lastCollectionKey))
+	if err != nil {
+		return time.Time{}
+	}
+	return last
+}
+
+// Record records a successful collection at the current time.
+func (l *CollectionLog) Record() {
+	if l == nil {
+		return
+	}
+	if err := l.storage.Set(lastCollectionKey, l.clock.Now().Format(time.RFC3339Nano)); err != nil {
+		Warnf("Failed to record last collection: %v", err)
+	}
+}
+
+// Initial returns a channel delivering a single tick when the initial
+// collection of a module is due: right away, or interval after the last
+// collection if that was more recent. It never delivers a tick if the module
+// should not collect initially (see CollectInitially).
+func (l *CollectionLog) Initial(ctx context.Context, interval time.Duration) <-chan time.Time {
+	if !CollectInitially(ctx) {
+		return nil
+	}
+
+	wait := time.Duration(0)
+	if last := l.Last(); !last.IsZero() {
+		wait = last.Add(interval).Sub(l.clock.Now())
+	}
+	if wait <= 0 {
+		ch := make(chan time.Time, 1)
+		ch <- l.now()
+		return ch
+	}
+	Debugf("Last collection at %s, delaying initial collection by %v", l.Last().Format(time.RFC3339), wait.Round(time.Second))
+	return time.After(wait)
+}
+
+// now returns the current time of the log's clock.
+func (l *CollectionLog) now() time.Time {
+	if l == nil {
+		return time.Now()
+	}
+	return l.clock.Now()
+}
+
+// OpenCollectionLog creates a collection log kept in the storage of a module.
+// It returns nil, so the module collects right after starting, if the storage
+// can't be created.
+func OpenCollectionLog(moduleName string, clock Clock) *CollectionLog {
+	storage, err := NewStorage(moduleName)
+	if err != nil {
+		Warnf("[%s] failed to create storage, the last collection is not remembered across restarts: %v", moduleName, err)
+		return nil
+	}
+	return NewCollectionLog(storage, clock)
+}
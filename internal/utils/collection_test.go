@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCollectionLog(t *testing.T) {
+	storage, err := NewStorageWithConfig(&StorageConfig{ModuleName: "test-collection", PreferredDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	now := time.Now()
+	log := NewCollectionLog(storage, fixedClock(now))
+
+	// Without a previous collection, the initial collection is due right away
+	select {
+	case <-log.Initial(context.Background(), time.Minute):
+	default:
+		t.Fatal("Expected immediate initial collection")
+	}
+
+	log.Record()
+	if last := log.Last(); !last.Equal(now) {
+		t.Errorf("Expected last collection %v, got %v", now, last)
+	}
+
+	// A restart within the interval waits for the rest of it
+	select {
+	case <-log.Initial(context.Background(), time.Hour):
+		t.Error("Expected initial collection to wait for the interval")
+	case <-time.After(20 * time.Millisecond):
+	}
+	restarted := NewCollectionLog(storage, fixedClock(now.Add(time.Hour)))
+	select {
+	case <-restarted.Initial(context.Background(), time.Hour):
+	default:
+		t.Error("Expected immediate initial collection after the interval")
+	}
+
+	if ch := log.Initial(WithSkipInitialCollection(context.Background(), true), time.Minute); ch != nil {
+		t.Error("Expected no initial collection if skipped")
+	}
+}
+
+func TestCollectionLogNil(t *testing.T) {
+	var log *CollectionLog
+	log.Record()
+	if !log.Last().IsZero() {
+		t.Error("Expected no last collection")
+	}
+	select {
+	case <-log.Initial(context.Background(), time.Minute):
+	default:
+		t.Error("Expected immediate initial collection")
+	}
+}
@@ -0,0 +1,83 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains the identity of the agent: an agent ID that is generated
+// once and kept in a storage of its own, so it stays the same across restarts
+// and updates, and a run ID that changes with every start of the process.
+// Both are logged and reported, so crashes and restarts of agents in a fleet
+// can be correlated.
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+)
+
+// agentIDStorage is the name of the storage the agent ID is kept in
+const agentIDStorage = "agent"
+
+// agentIDKey is the storage key of the agent ID
+const agentIDKey = "agent_id"
+
+var (
+	identityMu sync.RWMutex
+	agentID    string
+	runID      = newID(4)
+)
+
+// RunID returns the ID of the running process, which changes on every start.
+func RunID() string {
+	return runID
+}
+
+// AgentID returns the ID of the agent, or "" before LoadAgentID was called.
+func AgentID() string {
+	identityMu.RLock()
+	defer identityMu.RUnlock()
+	return agentID
+}
+
+// LoadAgentID reads the agent ID from storage, generating and storing a new
+// one on first use, and returns it. The ID is returned by AgentID afterwards.
+// Without storage, a new ID is generated that only lasts for this process.
+func LoadAgentID(storage *Storage) string {
+	identityMu.Lock()
+	defer identityMu.Unlock()
+
+	id := ""
+	if storage != nil {
+		id = storage.GetString(agentIDKey)
+	}
+	if id == "" {
+		id = newID(8)
+		if storage != nil {
+			err := storage.Set(agentIDKey, id)
+			if err == nil {
+				// Don't wait for delayed writes, so a crash keeps the ID
+				err = storage.Flush()
+			}
+			if err != nil {
+				Warnf("Failed to store agent ID, a new one is generated on the next start: %v", err)
+			}
+		}
+	}
+	agentID = id
+	return id
+}
+
+// OpenAgentID loads the agent ID from the storage of the agent, see LoadAgentID.
+func OpenAgentID() string {
+	storage, err := NewStorage(agentIDStorage)
+	if err != nil {
+		Warnf("Failed to create storage, the agent ID changes on every start: %v", err)
+		storage = nil
+	}
+	return LoadAgentID(storage)
+}
+
+// newID returns a random hex ID of n bytes.
+func newID(n int) string {
+	id := make([]byte, n)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
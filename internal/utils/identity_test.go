@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestLoadAgentID(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewStorageWithConfig(&StorageConfig{ModuleName: "test-agent", PreferredDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	id := LoadAgentID(storage)
+	if len(id) != 16 || AgentID() != id {
+		t.Fatalf("Expected a 16 character agent ID, got %q (AgentID %q)", id, AgentID())
+	}
+
+	// The ID survives a restart of the agent
+	reloaded, err := NewStorageWithConfig(&StorageConfig{ModuleName: "test-agent", PreferredDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	if reloadedID := LoadAgentID(reloaded); reloadedID != id {
+		t.Errorf("Expected agent ID %q after restart, got %q", id, reloadedID)
+	}
+
+	// Without storage a new ID is generated
+	if other := LoadAgentID(nil); other == "" || other == id {
+		t.Errorf("Expected a new agent ID without storage, got %q", other)
+	}
+}
+
+func TestRunID(t *testing.T) {
+	if len(RunID()) != 8 || RunID() != RunID() {
+		t.Errorf("Expected a stable 8 character run ID, got %q", RunID())
+	}
+}
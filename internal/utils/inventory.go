@@ -0,0 +1,182 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains the device inventory, which collects the devices known to
+// the running modules with their metadata (model, IP address, firmware). The
+// agent reports it as low-frequency device_inventory metric, so dashboards can
+// show a fleet overview without these attributes as tags on every point.
+package utils
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeviceMetadata describes a device. Empty values are unknown.
+type DeviceMetadata struct {
+	Model    string
+	IP       string
+	Firmware string
+}
+
+// InventoryDevice is a device in the inventory.
+type InventoryDevice struct {
+	DeviceMetadata
+	Module   string    // Module or instance name, e.g. "tasmota.haus1"
+	Device   string    // Value of the device tag of the device's metrics
+	LastSeen time.Time // When the device last sent a metric or was reported
+}
+
+// inventoryKey identifies a device of a module.
+type inventoryKey struct {
+	module string
+	device string
+}
+
+// DeviceInventory holds the devices known to the running modules. It is safe
+// for concurrent use.
+type DeviceInventory struct {
+	mu      sync.Mutex
+	devices map[inventoryKey]*InventoryDevice
+}
+
+// NewDeviceInventory creates an empty device inventory.
+func NewDeviceInventory() *DeviceInventory {
+	return &DeviceInventory{
+		devices: make(map[inventoryKey]*InventoryDevice),
+	}
+}
+
+// device returns the entry of a device, creating it if needed. The caller
+// must hold the lock.
+func (di *DeviceInventory) device(module, device string) *InventoryDevice {
+	key := inventoryKey{module: module, device: device}
+	entry, ok := di.devices[key]
+	if !ok {
+		entry = &InventoryDevice{Module: module, Device: device}
+		di.devices[key] = entry
+	}
+	return entry
+}
+
+// Seen records that a device of a module sent a metric at the given time.
+func (di *DeviceInventory) Seen(module, device string, at time.Time) {
+	if di == nil || device == "" {
+		return
+	}
+	di.mu.Lock()
+	defer di.mu.Unlock()
+	if entry := di.device(module, device); at.After(entry.LastSeen) {
+		entry.LastSeen = at
+	}
+}
+
+// Report updates the metadata of a device of a module. Empty values don't
+// overwrite known ones.
+func (di *DeviceInventory) Report(module, device string, metadata DeviceMetadata, at time.Time) {
+	if di == nil || device == "" {
+		return
+	}
+	di.mu.Lock()
+	defer di.mu.Unlock()
+	entry := di.device(module, device)
+	if metadata.Model != "" {
+		entry.Model = metadata.Model
+	}
+	if metadata.IP != "" {
+		entry.IP = metadata.IP
+	}
+	if metadata.Firmware != "" {
+		entry.Firmware = metadata.Firmware
+	}
+	if at.After(entry.LastSeen) {
+		entry.LastSeen = at
+	}
+}
+
+// Remove removes a device of a module, e.g. after it expired.
+func (di *DeviceInventory) Remove(module, device string) {
+	if di == nil {
+		return
+	}
+	di.mu.Lock()
+	defer di.mu.Unlock()
+	delete(di.devices, inventoryKey{module: module, device: device})
+}
+
+// Devices returns a copy of the devices of the given modules, sorted by module
+// and device.
+func (di *DeviceInventory) Devices(modules []string) []InventoryDevice {
+	if di == nil {
+		return nil
+	}
+	included := make(map[string]bool, len(modules))
+	for _, module := range modules {
+		included[module] = true
+	}
+
+	di.mu.Lock()
+	devices := make([]InventoryDevice, 0, len(di.devices))
+	for key, entry := range di.devices {
+		if included[key.module] {
+			devices = append(devices, *entry)
+		}
+	}
+	di.mu.Unlock()
+
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Module != devices[j].Module {
+			return devices[i].Module < devices[j].Module
+		}
+		return devices[i].Device < devices[j].Device
+	})
+	return devices
+}
+
+// ModuleInventory reports the devices of one module to a DeviceInventory.
+// A nil ModuleInventory ignores all reports.
+type ModuleInventory struct {
+	inventory *DeviceInventory
+	module    string
+	clock     Clock
+}
+
+// Report updates the metadata of a device of the module.
+func (mi *ModuleInventory) Report(device string, metadata DeviceMetadata) {
+	if mi == nil {
+		return
+	}
+	mi.inventory.Report(mi.module, device, metadata, mi.clock.Now())
+}
+
+// Remove removes a device of the module, e.g. after it expired.
+func (mi *ModuleInventory) Remove(device string) {
+	if mi == nil {
+		return
+	}
+	mi.inventory.Remove(mi.module, device)
+}
+
+// inventoryContextKey is the context key for the device inventory.
+type inventoryContextKey struct{}
+
+// WithDeviceInventory returns a context carrying the device inventory modules
+// report their devices to.
+func WithDeviceInventory(ctx context.Context, inventory *DeviceInventory) context.Context {
+	return context.WithValue(ctx, inventoryContextKey{}, inventory)
+}
+
+// InventoryFromContext returns the inventory of the module carried by the
+// context, or nil if the context carries no device inventory.
+func InventoryFromContext(ctx context.Context) *ModuleInventory {
+	inventory, _ := ctx.Value(inventoryContextKey{}).(*DeviceInventory)
+	if inventory == nil {
+		return nil
+	}
+	return &ModuleInventory{
+		inventory: inventory,
+		module:    ModuleFromContext(ctx),
+		clock:     ClockFromContext(ctx),
+	}
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDeviceInventory(t *testing.T) {
+	inventory := NewDeviceInventory()
+	start := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+
+	inventory.Report("tasmota", "plug1", DeviceMetadata{Model: "Sonoff S26", IP: "192.168.1.20", Firmware: "14.1.0"}, start)
+	inventory.Seen("tasmota", "plug1", start.Add(time.Minute))
+	inventory.Report("tasmota", "plug1", DeviceMetadata{Firmware: "14.2.0"}, start)
+	inventory.Seen("netatmo.haus1", "indoor", start)
+	inventory.Seen("netatmo.haus1", "", start)
+	inventory.Seen("dwd", "station", start)
+
+	expected := []InventoryDevice{
+		{Module: "netatmo.haus1", Device: "indoor", LastSeen: start},
+		{
+			DeviceMetadata: DeviceMetadata{Model: "Sonoff S26", IP: "192.168.1.20", Firmware: "14.2.0"},
+			Module:         "tasmota",
+			Device:         "plug1",
+			LastSeen:       start.Add(time.Minute),
+		},
+	}
+	if devices := inventory.Devices([]string{"tasmota", "netatmo.haus1"}); !reflect.DeepEqual(devices, expected) {
+		t.Errorf("Expected devices %+v, got %+v", expected, devices)
+	}
+
+	inventory.Remove("tasmota", "plug1")
+	if devices := inventory.Devices([]string{"tasmota"}); len(devices) != 0 {
+		t.Errorf("Expected removed device to be gone, got %+v", devices)
+	}
+}
+
+func TestInventoryFromContext(t *testing.T) {
+	// Without inventory, reports are ignored
+	var none *ModuleInventory = InventoryFromContext(context.Background())
+	if none != nil {
+		t.Fatal("Expected no inventory without inventory in context")
+	}
+	none.Report("plug1", DeviceMetadata{Model: "S26"})
+	none.Remove("plug1")
+
+	inventory := NewDeviceInventory()
+	ctx := WithModule(WithDeviceInventory(context.Background(), inventory), "tasmota.haus1")
+	InventoryFromContext(ctx).Report("plug1", DeviceMetadata{Model: "S26"})
+
+	devices := inventory.Devices([]string{"tasmota.haus1"})
+	if len(devices) != 1 || devices[0].Model != "S26" || devices[0].LastSeen.IsZero() {
+		t.Errorf("Expected device reported under the module of the context, got %+v", devices)
+	}
+}
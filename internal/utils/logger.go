@@ -4,10 +4,13 @@
 // - Configurable log levels (DEBUG, INFO, WARN, ERROR)
 // - Structured logging with timestamps and caller information
 // - Thread-safe operations
+// - Redaction of secrets such as passwords and tokens
 // - Global convenience functions
+// - Module loggers prefixing messages with the module name
 package utils
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -51,9 +54,11 @@ func (l LogLevel) String() string {
 // Logger provides a structured logger with configurable levels.
 // It is thread-safe and supports multiple output destinations.
 type Logger struct {
-	mu     sync.RWMutex
-	level  LogLevel
-	output io.Writer
+	mu      sync.RWMutex
+	writeMu sync.Mutex // serializes writes so log lines are never interleaved
+	level   LogLevel
+	output  io.Writer
+	prefix  string // added in brackets before every message, e.g. the run ID
 }
 
 var (
@@ -117,6 +122,14 @@ func (l *Logger) SetOutput(output io.Writer) {
 	l.output = output
 }
 
+// SetPrefix sets a prefix written in brackets before every message, e.g. to
+// identify the agent in collected logs. An empty prefix is not written.
+func (l *Logger) SetPrefix(prefix string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prefix = prefix
+}
+
 // getCallerInfo gets the caller information for logging
 func getCallerInfo() (string, int) {
 	// Try different call depths to find the actual caller
@@ -140,9 +153,17 @@ func getCallerInfo() (string, int) {
 }
 
 // formatLogMessage formats a log message with the custom format:
-// timestamp [loglevel] [filename:line_no] message
+// timestamp [loglevel] [filename:line_no] [prefix] message
+// Secrets in the message are redacted.
 func (l *Logger) formatLogMessage(level LogLevel, message string) string {
 	filename, line := getCallerInfo()
+	message = RedactSecrets(message)
+
+	l.mu.RLock()
+	if l.prefix != "" {
+		message = "[" + l.prefix + "] " + message
+	}
+	l.mu.RUnlock()
 
 	// Format timestamp
 	timestamp := time.Now().Format("2006/01/02 15:04:05.000000")
@@ -154,13 +175,22 @@ func (l *Logger) formatLogMessage(level LogLevel, message string) string {
 // logMessage is a helper function that handles the common logging logic.
 func (l *Logger) logMessage(level LogLevel, message string) {
 	if l.shouldLog(level) {
-		l.mu.RLock()
-		output := l.output
-		l.mu.RUnlock()
-		fmt.Fprint(output, l.formatLogMessage(level, message))
+		l.writeLine(l.formatLogMessage(level, message))
 	}
 }
 
+// writeLine writes a formatted log line with a single write call.
+// Writes are serialized so that lines from concurrent goroutines stay intact.
+func (l *Logger) writeLine(line string) {
+	l.mu.RLock()
+	output := l.output
+	l.mu.RUnlock()
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	io.WriteString(output, line)
+}
+
 // Debug logs a debug message
 func (l *Logger) Debug(v ...interface{}) {
 	l.logMessage(DEBUG, fmt.Sprint(v...))
@@ -203,19 +233,13 @@ func (l *Logger) Errorf(format string, v ...interface{}) {
 
 // Fatal logs a fatal error message and exits
 func (l *Logger) Fatal(v ...interface{}) {
-	l.mu.RLock()
-	output := l.output
-	l.mu.RUnlock()
-	fmt.Fprint(output, l.formatLogMessage(ERROR, fmt.Sprint(v...)))
+	l.writeLine(l.formatLogMessage(ERROR, fmt.Sprint(v...)))
 	os.Exit(1)
 }
 
 // Fatalf logs a formatted fatal error message and exits
 func (l *Logger) Fatalf(format string, v ...interface{}) {
-	l.mu.RLock()
-	output := l.output
-	l.mu.RUnlock()
-	fmt.Fprint(output, l.formatLogMessage(ERROR, fmt.Sprintf(format, v...)))
+	l.writeLine(l.formatLogMessage(ERROR, fmt.Sprintf(format, v...)))
 	os.Exit(1)
 }
 
@@ -257,6 +281,11 @@ func SetGlobalLogLevelFromString(level string) {
 	SetGlobalLogLevel(ParseLogLevel(level))
 }
 
+// SetGlobalLogPrefix sets the prefix of the global logger.
+func SetGlobalLogPrefix(prefix string) {
+	GetLogger().SetPrefix(prefix)
+}
+
 // Debug logs a debug message using the global logger
 func Debug(v ...interface{}) {
 	GetLogger().Debug(v...)
@@ -306,3 +335,49 @@ func Fatal(v ...interface{}) {
 func Fatalf(format string, v ...interface{}) {
 	GetLogger().Fatalf(format, v...)
 }
+
+// ModuleLogger logs messages prefixed with the name of a module, the way
+// module messages are logged throughout the agent ("[tasmota.haus1] ...").
+type ModuleLogger struct {
+	module string
+}
+
+// LoggerFromContext returns a logger for the module carried by the context
+// (see WithModule). Without a module, messages are logged without prefix.
+func LoggerFromContext(ctx context.Context) ModuleLogger {
+	return ModuleLogger{module: ModuleFromContext(ctx)}
+}
+
+// Module returns the name of the module the logger logs for.
+func (l ModuleLogger) Module() string {
+	return l.module
+}
+
+// prefix returns the message with the module name prepended.
+func (l ModuleLogger) prefix(format string, v ...interface{}) string {
+	message := fmt.Sprintf(format, v...)
+	if l.module == "" {
+		return message
+	}
+	return "[" + l.module + "] " + message
+}
+
+// Debugf logs a formatted debug message of the module.
+func (l ModuleLogger) Debugf(format string, v ...interface{}) {
+	GetLogger().logMessage(DEBUG, l.prefix(format, v...))
+}
+
+// Infof logs a formatted info message of the module.
+func (l ModuleLogger) Infof(format string, v ...interface{}) {
+	GetLogger().logMessage(INFO, l.prefix(format, v...))
+}
+
+// Warnf logs a formatted warning message of the module.
+func (l ModuleLogger) Warnf(format string, v ...interface{}) {
+	GetLogger().logMessage(WARN, l.prefix(format, v...))
+}
+
+// Errorf logs a formatted error message of the module.
+func (l ModuleLogger) Errorf(format string, v ...interface{}) {
+	GetLogger().logMessage(ERROR, l.prefix(format, v...))
+}
@@ -0,0 +1,109 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains garbage collector tuning for small devices (e.g. Pi Zero,
+// router boards), where the agent shares little RAM with other services such
+// as InfluxDB and telegraf.
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+const (
+	// lowMemoryThreshold is the total system memory up to which low-memory defaults apply
+	lowMemoryThreshold = 1 << 30 // 1 GiB
+
+	// lowMemoryGCPercent is the default GOGC on low-memory systems
+	lowMemoryGCPercent = 50
+
+	// minMemoryLimit is the lowest default memory limit on low-memory systems
+	minMemoryLimit = 32 << 20 // 32 MiB
+)
+
+// memoryUnits maps size suffixes to their multipliers, longest suffixes first
+var memoryUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// ParseMemorySize parses a memory size such as "64MiB", "100MB" or "1048576" into bytes.
+func ParseMemorySize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	multiplier := int64(1)
+	for _, unit := range memoryUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid memory size: %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// SystemMemory returns the total system memory in bytes as reported by /proc/meminfo.
+// It returns false if the total memory cannot be determined (e.g. on non-Linux systems).
+func SystemMemory() (int64, bool) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			return kb * 1024, true
+		}
+	}
+	return 0, false
+}
+
+// DefaultGCSettings returns the GC settings for a system with the given total memory.
+// On systems with up to 1 GiB the GC runs more often (GOGC=50) and a soft memory limit
+// of 10% of the system memory (at least 32 MiB) is set. Otherwise the Go defaults
+// are kept (GOGC=100, no memory limit).
+func DefaultGCSettings(totalMemory int64) (gcPercent int, memoryLimit int64) {
+	if totalMemory <= 0 || totalMemory > lowMemoryThreshold {
+		return 100, math.MaxInt64
+	}
+	memoryLimit = totalMemory / 10
+	if memoryLimit < minMemoryLimit {
+		memoryLimit = minMemoryLimit
+	}
+	return lowMemoryGCPercent, memoryLimit
+}
+
+// ConfigureGC applies the GC percentage and soft memory limit to the runtime.
+// Settings made through the GOGC and GOMEMLIMIT environment variables take precedence.
+func ConfigureGC(gcPercent int, memoryLimit int64) {
+	if os.Getenv("GOGC") == "" {
+		debug.SetGCPercent(gcPercent)
+	} else {
+		Debugf("GOGC set in environment, keeping it")
+	}
+	if os.Getenv("GOMEMLIMIT") == "" {
+		debug.SetMemoryLimit(memoryLimit)
+	} else {
+		Debugf("GOMEMLIMIT set in environment, keeping it")
+	}
+}
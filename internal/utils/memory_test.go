@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseMemorySize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{"1048576", 1048576, false},
+		{"64MiB", 64 << 20, false},
+		{"100MB", 100 * 1000 * 1000, false},
+		{"1.5GiB", 3 << 29, false},
+		{"512 KiB", 512 << 10, false},
+		{"10B", 10, false},
+		{"lots", 0, true},
+		{"-1MiB", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			size, err := ParseMemorySize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error=%v, got %v", tt.wantErr, err)
+			}
+			if size != tt.expected {
+				t.Errorf("Expected %d, got %d", tt.expected, size)
+			}
+		})
+	}
+}
+
+func TestDefaultGCSettings(t *testing.T) {
+	tests := []struct {
+		name        string
+		totalMemory int64
+		gcPercent   int
+		memoryLimit int64
+	}{
+		{"unknown", 0, 100, math.MaxInt64},
+		{"large system", 8 << 30, 100, math.MaxInt64},
+		{"pi zero", 512 << 20, 50, 512 << 20 / 10},
+		{"router board", 128 << 20, 50, 32 << 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gcPercent, memoryLimit := DefaultGCSettings(tt.totalMemory)
+			if gcPercent != tt.gcPercent || memoryLimit != tt.memoryLimit {
+				t.Errorf("Expected %d/%d, got %d/%d", tt.gcPercent, tt.memoryLimit, gcPercent, memoryLimit)
+			}
+		})
+	}
+}
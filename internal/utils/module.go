@@ -0,0 +1,48 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains the module identity carried by the context given to a
+// module's Run and Probe functions. Shared helpers such as storage, OAuth2 and
+// websocket clients, the config loader and LoggerFromContext take the module
+// name from the context, so modules don't have to pass it as a string
+// parameter everywhere.
+package utils
+
+import (
+	"context"
+	"fmt"
+)
+
+// moduleContextKey is the context key for the name of the running module.
+type moduleContextKey struct{}
+
+// WithModule returns a context carrying the name of the running module,
+// scoped to its instance if it has one (e.g. "tasmota.haus1").
+func WithModule(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, moduleContextKey{}, name)
+}
+
+// ModuleFromContext returns the module name carried by the context, or an
+// empty string if there is none.
+func ModuleFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(moduleContextKey{}).(string)
+	return name
+}
+
+// StorageFromContext creates the storage of the module carried by the context.
+func StorageFromContext(ctx context.Context) (*Storage, error) {
+	name := ModuleFromContext(ctx)
+	if name == "" {
+		return nil, fmt.Errorf("no module in context")
+	}
+	return NewStorage(name)
+}
+
+// OAuth2ClientFromContext creates an OAuth2 client for the module carried by
+// the context, keeping its tokens in the module's storage.
+func OAuth2ClientFromContext(ctx context.Context, config OAuth2Config) (*OAuth2Client, error) {
+	name := ModuleFromContext(ctx)
+	if name == "" {
+		return nil, fmt.Errorf("no module in context")
+	}
+	return NewOAuth2Client(config, name)
+}
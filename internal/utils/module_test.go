@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestModuleFromContext(t *testing.T) {
+	if name := ModuleFromContext(context.Background()); name != "" {
+		t.Errorf("Expected no module without a module in the context, got %q", name)
+	}
+	ctx := WithModule(context.Background(), "tasmota.haus1")
+	if name := ModuleFromContext(ctx); name != "tasmota.haus1" {
+		t.Errorf("Expected module tasmota.haus1, got %q", name)
+	}
+
+	if _, err := StorageFromContext(context.Background()); err == nil {
+		t.Error("Expected storage error without a module in the context")
+	}
+	if _, err := OAuth2ClientFromContext(context.Background(), OAuth2Config{}); err == nil {
+		t.Error("Expected OAuth2 client error without a module in the context")
+	}
+}
+
+func TestLoggerFromContext(t *testing.T) {
+	var logBuf bytes.Buffer
+	originalLogger := GetGlobalLogger()
+	SetGlobalLogger(NewLogger(INFO, &logBuf))
+	defer SetGlobalLogger(originalLogger)
+
+	logger := LoggerFromContext(WithModule(context.Background(), "tasmota.haus1"))
+	if logger.Module() != "tasmota.haus1" {
+		t.Errorf("Expected logger of module tasmota.haus1, got %q", logger.Module())
+	}
+	logger.Infof("connected to %s", "broker")
+	logger.Debugf("not logged at level INFO")
+	LoggerFromContext(context.Background()).Warnf("no module")
+
+	lines := strings.Split(strings.TrimSpace(logBuf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got %q", logBuf.String())
+	}
+	if !strings.Contains(lines[0], "[module_test.go:") || !strings.HasSuffix(lines[0], "] [tasmota.haus1] connected to broker") {
+		t.Errorf("Expected prefixed message with the caller, got %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "] no module") || strings.Contains(lines[1], "[tasmota") {
+		t.Errorf("Expected message without prefix, got %q", lines[1])
+	}
+}
@@ -0,0 +1,203 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains the network allowlist. When it is set, modules may only
+// connect to the listed host names, IP addresses and CIDR ranges; every other
+// connection is blocked before it is established and reported in the log and
+// the audit log.
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDestinationBlocked is returned for connections to destinations that are
+// not in the network allowlist.
+var ErrDestinationBlocked = errors.New("destination not in network allowlist")
+
+// networkAllowlist holds the parsed entries of the network allowlist.
+type networkAllowlist struct {
+	hosts []string // lower-case host names, "*.example.com" matches all subdomains
+	nets  []*net.IPNet
+}
+
+var (
+	allowlistMu sync.RWMutex
+	allowlist   *networkAllowlist // nil allows all destinations
+)
+
+// SetNetworkAllowlist restricts outbound connections to the given host names
+// (e.g. "api.netatmo.com" or "*.tibber.com"), IP addresses and CIDR ranges
+// (e.g. "192.168.10.0/24"). A nil list allows all destinations, an empty list
+// blocks all of them.
+func SetNetworkAllowlist(entries []string) error {
+	if entries == nil {
+		allowlistMu.Lock()
+		allowlist = nil
+		allowlistMu.Unlock()
+		return nil
+	}
+
+	parsed := &networkAllowlist{}
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			return fmt.Errorf("empty network allowlist entry")
+		case strings.Contains(entry, "/"):
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return fmt.Errorf("invalid network allowlist entry %q: %w", entry, err)
+			}
+			parsed.nets = append(parsed.nets, ipNet)
+		case net.ParseIP(entry) != nil:
+			ip := net.ParseIP(entry)
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			parsed.nets = append(parsed.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		default:
+			parsed.hosts = append(parsed.hosts, entry)
+		}
+	}
+
+	allowlistMu.Lock()
+	allowlist = parsed
+	allowlistMu.Unlock()
+	return nil
+}
+
+// CheckDestination returns an error wrapping ErrDestinationBlocked if a network
+// allowlist is set and does not contain the host of address, which is a URL or
+// host[:port]. A host name that is not listed itself is allowed if all of its
+// IP addresses are in listed ranges.
+func CheckDestination(ctx context.Context, address string) error {
+	allowlistMu.RLock()
+	list := allowlist
+	allowlistMu.RUnlock()
+	if list == nil {
+		return nil
+	}
+
+	host := destinationAddress(address)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+
+	if list.allowsHost(host) {
+		return nil
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else if addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host); err == nil {
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	if len(ips) > 0 && list.allowsIPs(ips) {
+		return nil
+	}
+
+	Warnf("Blocked connection to %s: not in network allowlist", host)
+	return fmt.Errorf("%w: %s", ErrDestinationBlocked, host)
+}
+
+// allowsHost reports whether host matches a listed host name.
+func (l *networkAllowlist) allowsHost(host string) bool {
+	for _, pattern := range l.hosts {
+		if pattern == host {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok && strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsIPs reports whether all ips are in listed ranges.
+func (l *networkAllowlist) allowsIPs(ips []net.IP) bool {
+	for _, ip := range ips {
+		allowed := false
+		for _, ipNet := range l.nets {
+			if ipNet.Contains(ip) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// destinationAddress returns the host[:port] of a URL, or address itself if it
+// has no scheme.
+func destinationAddress(address string) string {
+	if u, err := url.Parse(address); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return address
+}
+
+// outboundTransport checks every request against the network allowlist and
+// records it in the audit log.
+type outboundTransport struct {
+	module string
+	base   http.RoundTripper
+}
+
+// OutboundTransport wraps base (http.DefaultTransport if nil) for the HTTP
+// clients of modules: requests to destinations outside the network allowlist
+// are blocked and every request is recorded in the audit log under the given
+// module name and counted as upstream call of the module (see RecordCall).
+func OutboundTransport(module string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &outboundTransport{module: module, base: base}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *outboundTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+
+	var resp *http.Response
+	err := CheckDestination(req.Context(), req.URL.Host)
+	if err == nil {
+		resp, err = t.base.RoundTrip(req)
+	} else if req.Body != nil {
+		req.Body.Close()
+	}
+
+	entry := AuditEntry{
+		Time:       start,
+		Module:     t.module,
+		Method:     req.Method,
+		Host:       req.URL.Host,
+		Path:       req.URL.Path,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if resp != nil {
+		entry.Status = resp.StatusCode
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	WriteAuditEntry(entry)
+	RecordCall(t.module, entry.Status, err)
+	return resp, err
+}
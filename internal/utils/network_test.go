@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckDestination(t *testing.T) {
+	t.Cleanup(func() { SetNetworkAllowlist(nil) })
+
+	if err := CheckDestination(context.Background(), "https://example.com"); err != nil {
+		t.Fatalf("Expected all destinations to be allowed without allowlist, got %v", err)
+	}
+
+	if err := SetNetworkAllowlist([]string{"api.netatmo.com", "*.tibber.com", "192.168.10.0/24", "10.0.0.1", "localhost"}); err != nil {
+		t.Fatalf("Failed to set allowlist: %v", err)
+	}
+
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"https://api.netatmo.com/api/getstationsdata", true},
+		{"API.Netatmo.com:443", true},
+		{"wss://websocket-api.tibber.com/v1-beta/gql/subscriptions", true},
+		{"https://tibber.com", false},
+		{"tcp://192.168.10.5:1883", true},
+		{"192.168.11.5:3493", false},
+		{"10.0.0.1:8006", true},
+		{"http://10.0.0.2", false},
+		{"https://example.com", false},
+		{"[::1]:8080", false},
+	}
+	for _, tt := range tests {
+		err := CheckDestination(context.Background(), tt.address)
+		if tt.allowed && err != nil {
+			t.Errorf("%s: expected to be allowed, got %v", tt.address, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrDestinationBlocked) {
+			t.Errorf("%s: expected to be blocked, got %v", tt.address, err)
+		}
+	}
+}
+
+func TestSetNetworkAllowlist(t *testing.T) {
+	t.Cleanup(func() { SetNetworkAllowlist(nil) })
+
+	if err := SetNetworkAllowlist([]string{"192.168.1.0/33"}); err == nil {
+		t.Error("Expected error for invalid CIDR")
+	}
+	if err := SetNetworkAllowlist([]string{""}); err == nil {
+		t.Error("Expected error for empty entry")
+	}
+
+	// An empty allowlist blocks everything (offline mode)
+	if err := SetNetworkAllowlist([]string{}); err != nil {
+		t.Fatalf("Failed to set allowlist: %v", err)
+	}
+	if err := CheckDestination(context.Background(), "127.0.0.1:80"); !errors.Is(err, ErrDestinationBlocked) {
+		t.Errorf("Expected destination to be blocked in offline mode, got %v", err)
+	}
+}
+
+func TestOutboundTransportBlocks(t *testing.T) {
+	t.Cleanup(func() { SetNetworkAllowlist(nil) })
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	if err := SetNetworkAllowlist([]string{"api.netatmo.com"}); err != nil {
+		t.Fatalf("Failed to set allowlist: %v", err)
+	}
+
+	client := &http.Client{Transport: OutboundTransport("test", nil)}
+	if _, err := client.Get(server.URL); !errors.Is(err, ErrDestinationBlocked) {
+		t.Errorf("Expected request to be blocked, got %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("Expected no request to reach the server, got %d", requests)
+	}
+
+	if err := SetNetworkAllowlist([]string{"127.0.0.0/8"}); err != nil {
+		t.Fatalf("Failed to set allowlist: %v", err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected request to be allowed, got %v", err)
+	}
+	resp.Body.Close()
+	if requests != 1 {
+		t.Errorf("Expected 1 request, got %d", requests)
+	}
+}
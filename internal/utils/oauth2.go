@@ -7,12 +7,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"os/exec"
+	"strings"
 	"time"
 )
 
@@ -23,7 +23,7 @@ type OAuth2Config struct {
 	AuthURL      string
 	TokenURL     string
 	RedirectURI  string
-	Scope        string
+	Scope        string // space-separated scopes requested during authorization
 	State        string
 	Hostname     string // Optional hostname/IP for redirect URI (defaults to localhost)
 }
@@ -41,11 +41,22 @@ type OAuth2Token struct {
 type OAuth2Client struct {
 	config  OAuth2Config
 	storage *Storage
+	module  string
 }
 
 // NewOAuth2Client creates a new OAuth2 client.
 func NewOAuth2Client(config OAuth2Config, moduleName string) (*OAuth2Client, error) {
 	Debugf("Creating OAuth2 client for module: %s", moduleName)
+	// Credentials set in code rather than loaded from the configuration file
+	// may still be secret references
+	var err error
+	if config.ClientID, err = ResolveSecret(context.Background(), config.ClientID); err != nil {
+		return nil, fmt.Errorf("invalid client_id: %w", err)
+	}
+	if config.ClientSecret, err = ResolveSecret(context.Background(), config.ClientSecret); err != nil {
+		return nil, fmt.Errorf("invalid client_secret: %w", err)
+	}
+
 	storage, err := NewStorage(moduleName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create storage: %w", err)
@@ -55,6 +66,7 @@ func NewOAuth2Client(config OAuth2Config, moduleName string) (*OAuth2Client, err
 	return &OAuth2Client{
 		config:  config,
 		storage: storage,
+		module:  moduleName,
 	}, nil
 }
 
@@ -63,6 +75,12 @@ func (c *OAuth2Client) GetConfig() OAuth2Config {
 	return c.config
 }
 
+// Storage returns the storage holding the tokens, which the module may use
+// for its own state as well.
+func (c *OAuth2Client) Storage() *Storage {
+	return c.storage
+}
+
 // Authenticate performs OAuth2 authentication using Authorization Code flow.
 // It will try to use stored tokens first, then perform web authorization if needed.
 func (c *OAuth2Client) Authenticate(ctx context.Context) (*OAuth2Token, error) {
@@ -129,7 +147,7 @@ func (c *OAuth2Client) performWebAuthorization(ctx context.Context) (string, str
 		c.config.AuthURL,
 		c.config.ClientID,
 		redirectURI,
-		c.config.Scope,
+		url.QueryEscape(c.config.Scope),
 		c.config.State)
 
 	// Channel to receive the authorization code
@@ -140,33 +158,9 @@ func (c *OAuth2Client) performWebAuthorization(ctx context.Context) (string, str
 	mux := http.NewServeMux()
 
 	// Landing page
+	pages := loadOAuth2Pages()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		html := `
-<!DOCTYPE html>
-<html>
-<head>
-    <meta charset="UTF-8">
-    <title>OAuth2 Authorization</title>
-    <style>
-        body { font-family: Arial, sans-serif; max-width: 600px; margin: 50px auto; padding: 20px; }
-        .button { background: #007bff; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block; margin: 10px 0; }
-        .button:hover { background: #0056b3; }
-        .info { background: #f8f9fa; padding: 15px; border-radius: 4px; margin: 20px 0; }
-    </style>
-</head>
-<body>
-    <h1>OAuth2 Authorization</h1>
-    <div class="info">
-        <p>Click the button below to authorize the application.</p>
-    </div>
-    <a href="{{.AuthURL}}" class="button">Authorize Application</a>
-    <p><small>This will open the authorization page in a new tab.</small></p>
-</body>
-</html>`
-
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		tmpl, _ := template.New("auth").Parse(html)
-		tmpl.Execute(w, map[string]string{"AuthURL": authURL})
+		writeOAuth2Page(w, pages.landing, OAuth2PageData{AuthURL: authURL, Module: c.module})
 	})
 
 	// Callback handler
@@ -186,28 +180,7 @@ func (c *OAuth2Client) performWebAuthorization(ctx context.Context) (string, str
 		}
 
 		// Send success response
-		html := `
-<!DOCTYPE html>
-<html>
-<head>
-    <meta charset="UTF-8">
-    <title>Authorization Successful</title>
-    <style>
-        body { font-family: Arial, sans-serif; max-width: 600px; margin: 50px auto; padding: 20px; }
-        .success { background: #d4edda; color: #155724; padding: 15px; border-radius: 4px; margin: 20px 0; }
-    </style>
-</head>
-<body>
-    <h1>Authorization Successful!</h1>
-    <div class="success">
-        <p>✅ Your application has been successfully authorized.</p>
-        <p>You can now close this browser tab. The application will continue running.</p>
-    </div>
-</body>
-</html>`
-
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		w.Write([]byte(html))
+		writeOAuth2Page(w, pages.success, OAuth2PageData{Module: c.module})
 
 		// Send the code to the channel
 		authCodeChan <- code
@@ -292,7 +265,7 @@ func (c *OAuth2Client) exchangeAuthorizationCode(authCode, redirectURI string) (
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{Timeout: 30 * time.Second}
+	client := &http.Client{Timeout: 30 * time.Second, Transport: OutboundTransport(c.module, nil)}
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -361,7 +334,7 @@ func (c *OAuth2Client) refreshToken(refreshToken string) (*OAuth2Token, error) {
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{Timeout: 30 * time.Second}
+	client := &http.Client{Timeout: 30 * time.Second, Transport: OutboundTransport(c.module, nil)}
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -420,17 +393,54 @@ func (c *OAuth2Client) refreshToken(refreshToken string) (*OAuth2Token, error) {
 	return &token, nil
 }
 
-// storeToken stores an OAuth2 token in the storage.
+// storeToken stores an OAuth2 token in the storage. A stored token of the same
+// client that expires later is kept: it was refreshed concurrently (e.g. by
+// another module sharing the storage), and the refresh token of the older
+// token may already be invalidated.
 func (c *OAuth2Client) storeToken(token *OAuth2Token) error {
 	tokenData := map[string]interface{}{
 		"access_token":  token.AccessToken,
 		"refresh_token": token.RefreshToken,
 		"expires_at":    token.ExpiresAt.Format(time.RFC3339),
 		"client_id":     c.config.ClientID,
+		"scope":         c.grantedScope(token),
 		"last_updated":  time.Now().Format(time.RFC3339),
 	}
 
-	return c.storage.Set("oauth2_token", tokenData)
+	return c.storage.Update("oauth2_token", func(old interface{}) interface{} {
+		if stored, ok := old.(map[string]interface{}); ok && stored["client_id"] == c.config.ClientID {
+			expiresAt, err := time.Parse(time.RFC3339, fmt.Sprint(stored["expires_at"]))
+			if err == nil && expiresAt.After(token.ExpiresAt) {
+				Debugf("Keeping stored OAuth2 token, it expires later than the token to store")
+				return old
+			}
+		}
+		return tokenData
+	})
+}
+
+// grantedScope returns the space-separated scopes granted with token, or the
+// configured scopes if the token response did not list them.
+func (c *OAuth2Client) grantedScope(token *OAuth2Token) string {
+	if len(token.Scope) == 0 {
+		return c.config.Scope
+	}
+	return strings.Join(token.Scope, " ")
+}
+
+// scopeCovers reports whether the space-separated scopes granted include all
+// scopes in required.
+func scopeCovers(granted, required string) bool {
+	have := make(map[string]bool)
+	for _, scope := range strings.Fields(granted) {
+		have[scope] = true
+	}
+	for _, scope := range strings.Fields(required) {
+		if !have[scope] {
+			return false
+		}
+	}
+	return true
 }
 
 // AuthenticatedRequest makes an HTTP request with automatic token refresh and retry logic.
@@ -519,6 +529,31 @@ func (c *OAuth2Client) ForceRefresh(ctx context.Context) (*OAuth2Token, error) {
 	return refreshedToken, nil
 }
 
+// TokenCheck checks the stored token for "metrics-agent selftest". It doesn't
+// refresh the token, as the refresh would invalidate the refresh token of a
+// running agent. It also returns the access token if it is still valid.
+func (c *OAuth2Client) TokenCheck() (Check, string) {
+	check := Check{Name: "OAuth token"}
+	token, err := c.loadStoredToken()
+	switch {
+	case err != nil:
+		check.Err = fmt.Errorf("invalid stored token: %w", err)
+	case token == nil || token.RefreshToken == "":
+		check.Err = fmt.Errorf("no token stored")
+	}
+	if check.Err != nil {
+		check.Hint = "Start the agent once to authorize access in the browser"
+		return check, ""
+	}
+
+	if time.Now().Before(token.ExpiresAt) {
+		check.Detail = "access token valid until " + token.ExpiresAt.Local().Format(time.RFC3339)
+		return check, token.AccessToken
+	}
+	check.Detail = "access token expired, it is refreshed on the next request"
+	return check, ""
+}
+
 // loadStoredToken loads an OAuth2 token from the storage.
 func (c *OAuth2Client) loadStoredToken() (*OAuth2Token, error) {
 	tokenData := c.storage.Get("oauth2_token")
@@ -537,6 +572,13 @@ func (c *OAuth2Client) loadStoredToken() (*OAuth2Token, error) {
 		return nil, nil
 	}
 
+	// Verify the token was granted all configured scopes. Tokens stored without
+	// scope predate scope tracking and are kept.
+	if scope, ok := data["scope"].(string); ok && !scopeCovers(scope, c.config.Scope) {
+		Warnf("Stored token was granted scope %q instead of %q, ignoring stored token", scope, c.config.Scope)
+		return nil, nil
+	}
+
 	// Parse expires_at
 	expiresAtStr, ok := data["expires_at"].(string)
 	if !ok {
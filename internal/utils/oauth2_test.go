@@ -93,6 +93,40 @@ func TestOAuth2Client_StoreToken(t *testing.T) {
 	if data["client_id"] != client.config.ClientID {
 		t.Errorf("Expected client_id %s, got %s", client.config.ClientID, data["client_id"])
 	}
+
+	// Without scopes in the token response, the configured scopes are stored
+	if data["scope"] != client.config.Scope {
+		t.Errorf("Expected scope %q, got %v", client.config.Scope, data["scope"])
+	}
+
+	token.Scope = []string{"read"}
+	tah.AssertNoError(t, client.storeToken(token), "storeToken failed")
+	if data := client.storage.Get("oauth2_token").(map[string]interface{}); data["scope"] != "read" {
+		t.Errorf("Expected granted scope %q, got %v", "read", data["scope"])
+	}
+}
+
+func TestOAuth2Client_StoreTokenKeepsNewer(t *testing.T) {
+	tdg := NewTestDataGenerator()
+	tah := NewTestAssertionHelper()
+
+	client := createTestOAuth2Client(tdg.CreateTestOAuth2ConfigWithClientID("test-client-id"))
+	defer os.Remove(client.storage.GetFilePath())
+
+	newer := tdg.CreateValidTestToken()
+	newer.AccessToken = "newer-access-token"
+	newer.ExpiresAt = time.Now().Add(2 * time.Hour)
+	tah.AssertNoError(t, client.storeToken(newer), "storeToken failed")
+
+	// A token refreshed earlier by a concurrent client must not replace the newer one
+	older := tdg.CreateValidTestToken()
+	older.AccessToken = "older-access-token"
+	older.ExpiresAt = time.Now().Add(time.Hour)
+	tah.AssertNoError(t, client.storeToken(older), "storeToken failed")
+
+	if data := client.storage.Get("oauth2_token").(map[string]interface{}); data["access_token"] != "newer-access-token" {
+		t.Errorf("Expected the newer token to be kept, got %v", data["access_token"])
+	}
 }
 
 func TestOAuth2Client_LoadStoredToken(t *testing.T) {
@@ -142,6 +176,36 @@ func TestOAuth2Client_LoadStoredToken(t *testing.T) {
 			expectToken: false,
 			expectError: false,
 		},
+		{
+			name: "scope covers configured scopes",
+			storedData: map[string]interface{}{
+				"access_token":  "access-token-123",
+				"refresh_token": "refresh-token-456",
+				"expires_at":    time.Now().Add(time.Hour).Format(time.RFC3339),
+				"client_id":     "test-client-id",
+				"scope":         "write read admin",
+			},
+			clientID:    "test-client-id",
+			expectToken: true,
+			expectError: false,
+			expectedToken: &OAuth2Token{
+				AccessToken:  "access-token-123",
+				RefreshToken: "refresh-token-456",
+			},
+		},
+		{
+			name: "scope mismatch",
+			storedData: map[string]interface{}{
+				"access_token":  "access-token-123",
+				"refresh_token": "refresh-token-456",
+				"expires_at":    time.Now().Add(time.Hour).Format(time.RFC3339),
+				"client_id":     "test-client-id",
+				"scope":         "read",
+			},
+			clientID:    "test-client-id",
+			expectToken: false,
+			expectError: false,
+		},
 		{
 			name: "invalid token data format",
 			storedData: map[string]interface{}{
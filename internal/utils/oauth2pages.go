@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"sort"
+	"sync/atomic"
+)
+
+// OAuth2PageData is passed to the templates of the authorization pages.
+type OAuth2PageData struct {
+	AuthURL string // Authorization page of the provider the user is sent to (landing page only)
+	Module  string // Module requesting the authorization, e.g. "netatmo.haus1"
+}
+
+// oauth2Pages are the parsed templates of the pages served during the
+// OAuth2 authorization flow.
+type oauth2Pages struct {
+	landing *template.Template // links to the authorization page of the provider
+	success *template.Template // shown after the provider redirected back
+}
+
+// oauth2PageStyle is shared by the built-in pages. Buttons are large, as the
+// authorization is often completed on a phone.
+const oauth2PageStyle = `
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 600px; margin: 50px auto; padding: 20px; }
+        .button { background: #007bff; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block; margin: 10px 0; }
+        .button:hover { background: #0056b3; }
+        .info { background: #f8f9fa; padding: 15px; border-radius: 4px; margin: 20px 0; }
+        .success { background: #d4edda; color: #155724; padding: 15px; border-radius: 4px; margin: 20px 0; }
+    </style>`
+
+// builtinOAuth2Pages are the landing and success page templates by language.
+var builtinOAuth2Pages = map[string][2]string{
+	"en": {`<!DOCTYPE html>
+<html lang="en">
+<head>` + oauth2PageStyle + `
+    <title>OAuth2 Authorization</title>
+</head>
+<body>
+    <h1>OAuth2 Authorization</h1>
+    <div class="info">
+        <p>Click the button below to authorize the application.</p>
+    </div>
+    <a href="{{.AuthURL}}" class="button">Authorize Application</a>
+    <p><small>This will open the authorization page in a new tab.</small></p>
+</body>
+</html>`, `<!DOCTYPE html>
+<html lang="en">
+<head>` + oauth2PageStyle + `
+    <title>Authorization Successful</title>
+</head>
+<body>
+    <h1>Authorization Successful!</h1>
+    <div class="success">
+        <p>✅ Your application has been successfully authorized.</p>
+        <p>You can now close this browser tab. The application will continue running.</p>
+    </div>
+</body>
+</html>`},
+	"de": {`<!DOCTYPE html>
+<html lang="de">
+<head>` + oauth2PageStyle + `
+    <title>Zugriff erlauben</title>
+</head>
+<body>
+    <h1>Zugriff erlauben</h1>
+    <div class="info">
+        <p>Damit die Messwerte aufgezeichnet werden können, braucht das Programm einmalig deine Erlaubnis.</p>
+        <p>Tippe auf den Knopf, melde dich mit deinem Konto an und bestätige den Zugriff.</p>
+    </div>
+    <a href="{{.AuthURL}}" class="button">Weiter zur Anmeldung</a>
+</body>
+</html>`, `<!DOCTYPE html>
+<html lang="de">
+<head>` + oauth2PageStyle + `
+    <title>Fertig</title>
+</head>
+<body>
+    <h1>Fertig!</h1>
+    <div class="success">
+        <p>✅ Vielen Dank, der Zugriff ist erlaubt.</p>
+        <p>Du kannst diese Seite jetzt schließen.</p>
+    </div>
+</body>
+</html>`},
+}
+
+// currentOAuth2Pages are the pages set with SetOAuth2Pages, nil for the
+// built-in English pages.
+var currentOAuth2Pages atomic.Pointer[oauth2Pages]
+
+// SetOAuth2Pages sets the language of the pages served during the OAuth2
+// authorization flow ("en" or "de", empty for English), and optionally
+// template files replacing the landing and success page. The templates are
+// html/template files receiving OAuth2PageData; the landing page must link to
+// {{.AuthURL}}.
+func SetOAuth2Pages(language, landingFile, successFile string) error {
+	if language == "" {
+		language = "en"
+	}
+	builtin, ok := builtinOAuth2Pages[language]
+	if !ok {
+		languages := make([]string, 0, len(builtinOAuth2Pages))
+		for l := range builtinOAuth2Pages {
+			languages = append(languages, l)
+		}
+		sort.Strings(languages)
+		return fmt.Errorf("unsupported language %q (available: %v)", language, languages)
+	}
+
+	landing, err := parseOAuth2Page("landing", builtin[0], landingFile)
+	if err != nil {
+		return err
+	}
+	success, err := parseOAuth2Page("success", builtin[1], successFile)
+	if err != nil {
+		return err
+	}
+	currentOAuth2Pages.Store(&oauth2Pages{landing: landing, success: success})
+	return nil
+}
+
+// parseOAuth2Page parses the template file of a page, or the built-in
+// template if no file is set.
+func parseOAuth2Page(name, builtin, file string) (*template.Template, error) {
+	text := builtin
+	if file != "" {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s page: %w", name, err)
+		}
+		text = string(content)
+	}
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s page: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// loadOAuth2Pages returns the configured pages, or the built-in English pages.
+func loadOAuth2Pages() *oauth2Pages {
+	if pages := currentOAuth2Pages.Load(); pages != nil {
+		return pages
+	}
+	builtin := builtinOAuth2Pages["en"]
+	return &oauth2Pages{
+		landing: template.Must(template.New("landing").Parse(builtin[0])),
+		success: template.Must(template.New("success").Parse(builtin[1])),
+	}
+}
+
+// writeOAuth2Page renders a page. It is rendered before writing, so a
+// template failing on the data results in an error response instead of half
+// a page.
+func writeOAuth2Page(w http.ResponseWriter, tmpl *template.Template, data OAuth2PageData) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		Errorf("Failed to render OAuth2 %s page: %v", tmpl.Name(), err)
+		http.Error(w, "failed to render page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
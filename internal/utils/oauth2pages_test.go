@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetOAuth2Pages(t *testing.T) {
+	defer currentOAuth2Pages.Store(nil)
+	data := OAuth2PageData{AuthURL: "https://example.com/auth?client_id=a&state=b", Module: "netatmo"}
+
+	render := func(page string) string {
+		pages := loadOAuth2Pages()
+		tmpl := pages.landing
+		if page == "success" {
+			tmpl = pages.success
+		}
+		rec := httptest.NewRecorder()
+		writeOAuth2Page(rec, tmpl, data)
+		return rec.Body.String()
+	}
+
+	// English by default, with the escaped authorization URL
+	if body := render("landing"); !strings.Contains(body, "Authorize Application") || !strings.Contains(body, `href="https://example.com/auth?client_id=a&amp;state=b"`) {
+		t.Errorf("Unexpected default landing page: %s", body)
+	}
+
+	if err := SetOAuth2Pages("de", "", ""); err != nil {
+		t.Fatalf("SetOAuth2Pages failed: %v", err)
+	}
+	if body := render("landing"); !strings.Contains(body, `lang="de"`) || !strings.Contains(body, "Weiter zur Anmeldung") {
+		t.Errorf("Expected German landing page, got: %s", body)
+	}
+	if body := render("success"); !strings.Contains(body, "Fertig") {
+		t.Errorf("Expected German success page, got: %s", body)
+	}
+
+	// A custom template replaces one page, the other stays built-in
+	dir := t.TempDir()
+	landing := filepath.Join(dir, "landing.html")
+	os.WriteFile(landing, []byte(`<a href="{{.AuthURL}}">{{.Module}} freigeben</a>`), 0644)
+	if err := SetOAuth2Pages("de", landing, ""); err != nil {
+		t.Fatalf("SetOAuth2Pages failed: %v", err)
+	}
+	if body := render("landing"); body != `<a href="https://example.com/auth?client_id=a&amp;state=b">netatmo freigeben</a>` {
+		t.Errorf("Expected custom landing page, got: %s", body)
+	}
+	if body := render("success"); !strings.Contains(body, "Fertig") {
+		t.Errorf("Expected built-in success page, got: %s", body)
+	}
+
+	// Invalid settings keep the previous pages
+	invalid := filepath.Join(dir, "invalid.html")
+	os.WriteFile(invalid, []byte(`{{.AuthURL`), 0644)
+	for _, args := range [][3]string{{"fr", "", ""}, {"", filepath.Join(dir, "missing.html"), ""}, {"", "", invalid}} {
+		if err := SetOAuth2Pages(args[0], args[1], args[2]); err == nil {
+			t.Errorf("Expected error for %v", args)
+		}
+	}
+	if body := render("landing"); !strings.Contains(body, "netatmo freigeben") {
+		t.Errorf("Expected previous pages to be kept, got: %s", body)
+	}
+}
+
+func TestWriteOAuth2PageError(t *testing.T) {
+	dir := t.TempDir()
+	landing := filepath.Join(dir, "landing.html")
+	// Fails on execution, as the data has no such field
+	os.WriteFile(landing, []byte(`<p>{{.Unknown}}</p>`), 0644)
+	defer currentOAuth2Pages.Store(nil)
+	if err := SetOAuth2Pages("", landing, ""); err != nil {
+		t.Fatalf("SetOAuth2Pages failed: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	writeOAuth2Page(rec, loadOAuth2Pages().landing, OAuth2PageData{})
+	if rec.Code != 500 || strings.Contains(rec.Body.String(), "<p>") {
+		t.Errorf("Expected error response without partial page, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
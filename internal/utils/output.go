@@ -0,0 +1,99 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains the line-oriented output writer used for metric output.
+// All writes to stdout go through a single mutex-protected writer so that lines
+// written from different goroutines are never interleaved, which telegraf's
+// inputs.execd plugin relies on when parsing the output.
+//
+// Output that bypasses the writer (e.g. a stray fmt.Println) would corrupt the
+// metric stream; RedirectStrayStdout diverts it to the log on stderr instead.
+package utils
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sync"
+)
+
+// LineWriter writes complete lines to an underlying writer.
+// Each line is written with a single Write call while holding a mutex,
+// which guarantees line atomicity across concurrent writers.
+type LineWriter struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// stdout is the process-wide writer for metric output
+var stdout = NewLineWriter(os.Stdout)
+
+// NewLineWriter creates a new line writer for the given writer.
+func NewLineWriter(writer io.Writer) *LineWriter {
+	return &LineWriter{
+		writer: writer,
+	}
+}
+
+// Stdout returns the process-wide line writer for stdout.
+// All metric output must be written through this writer.
+func Stdout() *LineWriter {
+	return stdout
+}
+
+// WriteLine writes a single line, appending a newline if it is missing.
+func (lw *LineWriter) WriteLine(line string) error {
+	buf := make([]byte, 0, len(line)+1)
+	buf = append(buf, line...)
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		buf = append(buf, '\n')
+	}
+
+	_, err := lw.Write(buf)
+	return err
+}
+
+// Write implements io.Writer. The given bytes are written in a single call
+// while holding the writer's mutex.
+func (lw *LineWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.writer.Write(p)
+}
+
+// SetWriter replaces the underlying writer (for testing purposes).
+func (lw *LineWriter) SetWriter(writer io.Writer) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.writer = writer
+}
+
+// RedirectStrayStdout replaces os.Stdout with a pipe, so that output written
+// directly to os.Stdout (e.g. debug prints) cannot corrupt the metric stream.
+// Metric output written through Stdout() still goes to the original stdout.
+// Stray lines are logged as warnings on stderr. The returned function restores
+// os.Stdout and waits until all stray output has been logged.
+func RedirectStrayStdout() (restore func(), err error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	original := os.Stdout
+	os.Stdout = w
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			Warnf("[stdout] dropped stray output: %s", scanner.Text())
+		}
+		r.Close()
+	}()
+
+	return func() {
+		os.Stdout = original
+		w.Close()
+		<-done
+	}, nil
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// chunkedWriter splits every write into single-byte writes to provoke interleaving
+type chunkedWriter struct {
+	buf bytes.Buffer
+}
+
+func (cw *chunkedWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		cw.buf.WriteByte(b)
+	}
+	return len(p), nil
+}
+
+func TestLineWriterWriteLine(t *testing.T) {
+	var buf bytes.Buffer
+	lw := NewLineWriter(&buf)
+
+	if err := lw.WriteLine("first"); err != nil {
+		t.Fatalf("WriteLine failed: %v", err)
+	}
+	if err := lw.WriteLine("second\n"); err != nil {
+		t.Fatalf("WriteLine failed: %v", err)
+	}
+
+	if buf.String() != "first\nsecond\n" {
+		t.Errorf("Unexpected output: %q", buf.String())
+	}
+}
+
+func TestLineWriterConcurrentWrites(t *testing.T) {
+	cw := &chunkedWriter{}
+	lw := NewLineWriter(cw)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 10; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				_ = lw.WriteLine(fmt.Sprintf("metric,goroutine=%d value=%di", g, i))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(cw.buf.String(), "\n"), "\n")
+	if len(lines) != 1000 {
+		t.Fatalf("Expected 1000 lines, got %d", len(lines))
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "metric,goroutine=") || strings.Count(line, "value=") != 1 {
+			t.Fatalf("Interleaved line detected: %q", line)
+		}
+	}
+}
+
+func TestRedirectStrayStdout(t *testing.T) {
+	var logBuf bytes.Buffer
+	originalLogger := GetGlobalLogger()
+	SetGlobalLogger(NewLogger(INFO, &logBuf))
+	defer SetGlobalLogger(originalLogger)
+
+	originalStdout := os.Stdout
+	restore, err := RedirectStrayStdout()
+	if err != nil {
+		t.Fatalf("RedirectStrayStdout failed: %v", err)
+	}
+	fmt.Println("debug: value is 42")
+	restore()
+
+	if os.Stdout != originalStdout {
+		t.Error("Expected os.Stdout to be restored")
+	}
+	if !strings.Contains(logBuf.String(), "dropped stray output: debug: value is 42") {
+		t.Errorf("Expected stray output in log, got %q", logBuf.String())
+	}
+}
@@ -0,0 +1,69 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains connectivity probes used by modules to check quickly at
+// startup whether their upstream endpoints are reachable.
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// defaultPorts maps URL schemes to their default ports
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+	"tcp":   "1883",
+	"mqtt":  "1883",
+	"ssl":   "8883",
+	"tls":   "8883",
+	"mqtts": "8883",
+}
+
+// ProbeAddress checks that a TCP connection to address (host:port) can be established.
+func ProbeAddress(ctx context.Context, address string, timeout time.Duration) error {
+	if err := CheckDestination(ctx, address); err != nil {
+		return err
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("%s not reachable: %w", address, err)
+	}
+	return conn.Close()
+}
+
+// ProbeURL checks that a TCP connection to the host of rawURL can be established.
+// The port defaults to the standard port of the URL scheme (e.g. 443 for https, 1883 for tcp).
+func ProbeURL(ctx context.Context, rawURL string, timeout time.Duration) error {
+	address, err := urlAddress(rawURL)
+	if err != nil {
+		return err
+	}
+	return ProbeAddress(ctx, address, timeout)
+}
+
+// urlAddress returns the host:port address of a URL.
+func urlAddress(rawURL string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	if parsed.Hostname() == "" {
+		return "", fmt.Errorf("invalid URL %q: missing host", rawURL)
+	}
+
+	port := parsed.Port()
+	if port == "" {
+		var known bool
+		if port, known = defaultPorts[parsed.Scheme]; !known {
+			return "", fmt.Errorf("invalid URL %q: missing port", rawURL)
+		}
+	}
+	return net.JoinHostPort(parsed.Hostname(), port), nil
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestURLAddress(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+		wantErr  bool
+	}{
+		{"tcp://localhost:1883", "localhost:1883", false},
+		{"tcp://broker", "broker:1883", false},
+		{"https://pve.local:8006/api2/json", "pve.local:8006", false},
+		{"https://api.tibber.com/v1-beta/gql", "api.tibber.com:443", false},
+		{"ws://opendtu.local/livedata", "opendtu.local:80", false},
+		{"ftp://example.com", "", true},
+		{"localhost:1883", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			address, err := urlAddress(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error=%v, got %v", tt.wantErr, err)
+			}
+			if address != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, address)
+			}
+		})
+	}
+}
+
+func TestProbeAddress(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	address := listener.Addr().String()
+
+	if err := ProbeURL(context.Background(), "tcp://"+address, time.Second); err != nil {
+		t.Errorf("Expected listening address to be reachable, got %v", err)
+	}
+
+	listener.Close()
+	if err := ProbeAddress(context.Background(), address, time.Second); err == nil {
+		t.Error("Expected closed address not to be reachable")
+	}
+}
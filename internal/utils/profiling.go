@@ -0,0 +1,147 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains profiling helpers for diagnosing performance issues in the
+// field, e.g. on low-power ARM devices: an optional net/http/pprof endpoint,
+// dumping CPU and heap profiles to files and counting goroutines per label.
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// profileMu prevents overlapping profile dumps, as only one CPU profile can run at a time
+var profileMu sync.Mutex
+
+// StartPprofServer serves the net/http/pprof endpoints under /debug/pprof/ on the given address.
+// The server runs in the background; errors are logged.
+func StartPprofServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go WithPanicRecoveryAndContinue("Pprof server", "main", func() {
+		Infof("Serving pprof endpoints on http://%s/debug/pprof/", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			Errorf("Pprof server stopped: %v", err)
+		}
+	})
+}
+
+// WriteProfiles records a CPU profile for the given duration and writes it, followed
+// by a heap profile, to timestamped files in dir. It returns the written file paths.
+// It fails if another profile dump is still running.
+func WriteProfiles(dir string, cpuDuration time.Duration) ([]string, error) {
+	if !profileMu.TryLock() {
+		return nil, fmt.Errorf("profile dump already in progress")
+	}
+	defer profileMu.Unlock()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create profile directory: %w", err)
+	}
+	prefix := filepath.Join(dir, "metrics-agent-"+time.Now().Format("20060102-150405"))
+
+	cpuPath := prefix + "-cpu.pprof"
+	if err := writeCPUProfile(cpuPath, cpuDuration); err != nil {
+		return nil, err
+	}
+
+	heapPath := prefix + "-heap.pprof"
+	if err := writeHeapProfile(heapPath); err != nil {
+		return []string{cpuPath}, err
+	}
+
+	return []string{cpuPath, heapPath}, nil
+}
+
+// writeCPUProfile records a CPU profile for the given duration into path.
+func writeCPUProfile(path string, duration time.Duration) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create CPU profile: %w", err)
+	}
+	defer file.Close()
+
+	if err := runtimepprof.StartCPUProfile(file); err != nil {
+		return fmt.Errorf("failed to start CPU profile: %w", err)
+	}
+	time.Sleep(duration)
+	runtimepprof.StopCPUProfile()
+	return nil
+}
+
+// writeHeapProfile writes a heap profile into path.
+func writeHeapProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create heap profile: %w", err)
+	}
+	defer file.Close()
+
+	// Get up-to-date statistics
+	runtime.GC()
+	if err := runtimepprof.WriteHeapProfile(file); err != nil {
+		return fmt.Errorf("failed to write heap profile: %w", err)
+	}
+	return nil
+}
+
+// GoroutinesByLabel counts the running goroutines per value of the given pprof
+// label. Goroutines inherit the labels of the goroutine that started them, so a
+// label set with runtime/pprof.Do covers all goroutines started by a module.
+// Goroutines without the label are not counted.
+func GoroutinesByLabel(key string) (map[string]int, error) {
+	var buf bytes.Buffer
+	if err := runtimepprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return nil, fmt.Errorf("failed to write goroutine profile: %w", err)
+	}
+	return parseGoroutineLabels(&buf, key)
+}
+
+// parseGoroutineLabels parses a goroutine profile in debug=1 format. Each stack
+// starts with "<count> @ <pcs>" and may be followed by "# labels: {...}".
+func parseGoroutineLabels(profile *bytes.Buffer, key string) (map[string]int, error) {
+	counts := make(map[string]int)
+	count := 0
+
+	scanner := bufio.NewScanner(profile)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if n, _, ok := strings.Cut(line, " @ "); ok {
+			count, _ = strconv.Atoi(n)
+			continue
+		}
+		rest, ok := strings.CutPrefix(line, "# labels: ")
+		if !ok {
+			continue
+		}
+		var labels map[string]string
+		if err := json.Unmarshal([]byte(rest), &labels); err != nil {
+			return nil, fmt.Errorf("failed to parse goroutine labels %q: %w", rest, err)
+		}
+		if value, ok := labels[key]; ok {
+			counts[value] += count
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read goroutine profile: %w", err)
+	}
+	return counts, nil
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"context"
+	"os"
+	runtimepprof "runtime/pprof"
+	"testing"
+	"time"
+)
+
+func TestWriteProfiles(t *testing.T) {
+	dir := t.TempDir()
+
+	paths, err := WriteProfiles(dir, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to write profiles: %v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("Expected CPU and heap profile, got %v", paths)
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Errorf("Expected profile %s to exist: %v", path, err)
+		} else if info.Size() == 0 {
+			t.Errorf("Expected profile %s not to be empty", path)
+		}
+	}
+}
+
+func TestWriteProfilesInProgress(t *testing.T) {
+	profileMu.Lock()
+	defer profileMu.Unlock()
+
+	if _, err := WriteProfiles(t.TempDir(), time.Millisecond); err == nil {
+		t.Error("Expected error while another profile dump is running")
+	}
+}
+
+func TestGoroutinesByLabel(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	started := make(chan struct{}, 3)
+	runtimepprof.Do(context.Background(), runtimepprof.Labels("module", "test-a"), func(context.Context) {
+		for i := 0; i < 2; i++ {
+			go func() {
+				started <- struct{}{}
+				<-stop
+			}()
+		}
+	})
+	runtimepprof.Do(context.Background(), runtimepprof.Labels("module", "test-b"), func(context.Context) {
+		go func() {
+			started <- struct{}{}
+			<-stop
+		}()
+	})
+	for i := 0; i < 3; i++ {
+		<-started
+	}
+
+	counts, err := GoroutinesByLabel("module")
+	if err != nil {
+		t.Fatalf("Failed to count goroutines: %v", err)
+	}
+	if counts["test-a"] != 2 || counts["test-b"] != 1 {
+		t.Errorf("Expected 2 goroutines for test-a and 1 for test-b, got %v", counts)
+	}
+}
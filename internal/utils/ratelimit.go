@@ -0,0 +1,128 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains the per-host rate limiter of HTTP requests to devices.
+// The tiny webservers of devices such as Tasmota plugs or OpenDTUs stop
+// responding when they get many requests at once, e.g. one per channel of a
+// multi-channel device, so requests to each host are spaced out.
+package utils
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultDeviceRequestsPerSecond is the default rate of requests per device
+const DefaultDeviceRequestsPerSecond = 2
+
+// RateLimiter limits the rate of operations per key with a token bucket of
+// the given size, refilled at a steady rate. A nil RateLimiter does not limit.
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration        // Time to refill one token
+	burst    time.Duration        // Time to refill the whole bucket but one token
+	next     map[string]time.Time // When the bucket of a key holds a token again
+	clock    Clock
+}
+
+// NewRateLimiter creates a rate limiter allowing perSecond operations per key,
+// with up to burst operations at once. For perSecond <= 0 it returns nil,
+// which does not limit.
+func NewRateLimiter(perSecond float64, burst int) *RateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	interval := time.Duration(float64(time.Second) / perSecond)
+	return &RateLimiter{
+		interval: interval,
+		burst:    time.Duration(max(burst, 1)-1) * interval,
+		next:     make(map[string]time.Time),
+		clock:    SystemClock,
+	}
+}
+
+// Reserve takes a token of the key and returns how long the operation has to
+// wait until the token is available.
+func (l *RateLimiter) Reserve(key string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	start := l.next[key]
+	if start.Before(now) {
+		start = now
+	}
+	l.next[key] = start.Add(l.interval)
+
+	// Drop keys that may send again right away, so removed devices don't accumulate
+	for k, next := range l.next {
+		if !next.After(now) {
+			delete(l.next, k)
+		}
+	}
+	return max(start.Sub(now)-l.burst, 0)
+}
+
+// Wait blocks until an operation of the key is allowed or ctx is cancelled.
+func (l *RateLimiter) Wait(ctx context.Context, key string) error {
+	delay := l.Reserve(key)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+var (
+	deviceLimiterMu sync.RWMutex
+	deviceLimiter   = NewRateLimiter(DefaultDeviceRequestsPerSecond, 1)
+)
+
+// SetDeviceRateLimit sets how many requests per second the HTTP clients of
+// DeviceTransport send to each device. Values <= 0 disable the limit.
+func SetDeviceRateLimit(perSecond float64) {
+	deviceLimiterMu.Lock()
+	defer deviceLimiterMu.Unlock()
+	deviceLimiter = NewRateLimiter(perSecond, 1)
+}
+
+// currentDeviceLimiter returns the rate limiter of requests to devices.
+func currentDeviceLimiter() *RateLimiter {
+	deviceLimiterMu.RLock()
+	defer deviceLimiterMu.RUnlock()
+	return deviceLimiter
+}
+
+// deviceTransport spaces out the requests to each device.
+type deviceTransport struct {
+	base http.RoundTripper
+}
+
+// DeviceTransport is the OutboundTransport for HTTP clients of modules that
+// talk to devices in the local network. The requests of all such clients to
+// the same host share one rate limit (see SetDeviceRateLimit), no matter which
+// module or channel sends them.
+func DeviceTransport(module string, base http.RoundTripper) http.RoundTripper {
+	return &deviceTransport{base: OutboundTransport(module, base)}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *deviceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := currentDeviceLimiter().Wait(req.Context(), req.URL.Hostname()); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	limiter := NewRateLimiter(2, 2)
+	limiter.clock = fixedClock(start)
+
+	// The burst is allowed at once, further requests are spaced out
+	expected := []time.Duration{0, 0, 500 * time.Millisecond, time.Second}
+	for i, want := range expected {
+		if delay := limiter.Reserve("plug1"); delay != want {
+			t.Errorf("Request %d: expected delay %s, got %s", i, want, delay)
+		}
+	}
+
+	// Other hosts have their own bucket
+	if delay := limiter.Reserve("plug2"); delay != 0 {
+		t.Errorf("Expected no delay for another host, got %s", delay)
+	}
+
+	// The bucket refills while idle
+	limiter.clock = fixedClock(start.Add(10 * time.Second))
+	if delay := limiter.Reserve("plug1"); delay != 0 {
+		t.Errorf("Expected no delay after idling, got %s", delay)
+	}
+	if _, ok := limiter.next["plug2"]; ok {
+		t.Error("Expected idle hosts to be forgotten")
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	limiter := NewRateLimiter(0, 1)
+	if limiter != nil {
+		t.Fatal("Expected nil limiter for rate 0")
+	}
+	for range 10 {
+		if err := limiter.Wait(context.Background(), "plug1"); err != nil {
+			t.Fatalf("Expected nil limiter not to block, got %v", err)
+		}
+	}
+}
+
+func TestRateLimiterWaitCancelled(t *testing.T) {
+	limiter := NewRateLimiter(0.1, 1)
+	limiter.Reserve("plug1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx, "plug1"); err == nil {
+		t.Error("Expected error when the context expires while waiting")
+	}
+}
+
+func TestDeviceTransport(t *testing.T) {
+	t.Cleanup(func() { SetDeviceRateLimit(DefaultDeviceRequestsPerSecond) })
+	SetDeviceRateLimit(20)
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+
+	// Two clients share the limit of the host
+	clients := []*http.Client{
+		{Transport: DeviceTransport("test", nil)},
+		{Transport: DeviceTransport("other", nil)},
+	}
+	start := time.Now()
+	for i := range 6 {
+		resp, err := clients[i%2].Get(server.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected requests to the host to be spaced out, 6 requests took %s", elapsed)
+	}
+	if requests.Load() != 6 {
+		t.Errorf("Expected 6 requests, got %d", requests.Load())
+	}
+}
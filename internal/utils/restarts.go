@@ -0,0 +1,113 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains the restart history of modules. The supervisor records
+// every restart with its reason in a storage of its own, so intermittent
+// failures, e.g. overnight, can be investigated after the fact and even after
+// the agent itself was restarted.
+package utils
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// restartHistoryStorage is the name of the storage the restart history is kept in
+const restartHistoryStorage = "restarts"
+
+// Restart is a restart of a module recorded in the restart history.
+type Restart struct {
+	Time   time.Time     `json:"time"`             // When the module stopped
+	Reason string        `json:"reason"`           // Error the module stopped with
+	Uptime time.Duration `json:"uptime"`           // How long the module ran before it stopped
+	RunID  string        `json:"run_id,omitempty"` // Run of the agent the module ran in, see RunID
+}
+
+// RestartHistory keeps the last restarts of each module in a storage.
+// A nil history records nothing.
+type RestartHistory struct {
+	storage *Storage
+	limit   int
+}
+
+// NewRestartHistory creates a restart history kept in storage, holding up to
+// limit restarts per module. It returns nil if storage is nil or limit is not
+// positive.
+func NewRestartHistory(storage *Storage, limit int) *RestartHistory {
+	if storage == nil || limit <= 0 {
+		return nil
+	}
+	return &RestartHistory{storage: storage, limit: limit}
+}
+
+// OpenRestartHistory creates a restart history kept in the storage of the
+// supervisor. It returns nil if limit is not positive or the storage can't be
+// created.
+func OpenRestartHistory(limit int) *RestartHistory {
+	if limit <= 0 {
+		return nil
+	}
+	storage, err := NewStorage(restartHistoryStorage)
+	if err != nil {
+		Warnf("Failed to create storage, module restarts are not recorded: %v", err)
+		return nil
+	}
+	return NewRestartHistory(storage, limit)
+}
+
+// Record adds a restart of a module, dropping its oldest restarts beyond the limit.
+func (h *RestartHistory) Record(module string, restart Restart) {
+	if h == nil {
+		return
+	}
+	err := h.storage.Update(module, func(old interface{}) interface{} {
+		restarts := append(decodeRestarts(old), restart)
+		if len(restarts) > h.limit {
+			restarts = restarts[len(restarts)-h.limit:]
+		}
+		return restarts
+	})
+	if err != nil {
+		Warnf("[%s] failed to record restart: %v", module, err)
+	}
+}
+
+// Get returns the recorded restarts of a module, oldest first.
+func (h *RestartHistory) Get(module string) []Restart {
+	if h == nil {
+		return nil
+	}
+	return decodeRestarts(h.storage.Get(module))
+}
+
+// Modules returns the names of the modules with recorded restarts in sorted order.
+func (h *RestartHistory) Modules() []string {
+	if h == nil {
+		return nil
+	}
+	modules := h.storage.Keys()
+	sort.Strings(modules)
+	return modules
+}
+
+// decodeRestarts converts a stored restart list, which is a []Restart when it
+// was recorded by this process and decoded JSON when it was loaded from disk.
+func decodeRestarts(value interface{}) []Restart {
+	switch restarts := value.(type) {
+	case nil:
+		return nil
+	case []Restart:
+		return append([]Restart(nil), restarts...)
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var restarts []Restart
+	if err := json.Unmarshal(data, &restarts); err != nil {
+		Debugf("Ignoring invalid restart history: %v", err)
+		return nil
+	}
+	return restarts
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRestartHistory(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewStorageWithConfig(&StorageConfig{ModuleName: "test-restarts", PreferredDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	history := NewRestartHistory(storage, 2)
+
+	start := time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC)
+	for i := range 3 {
+		history.Record("tasmota", Restart{
+			Time:   start.Add(time.Duration(i) * time.Hour),
+			Reason: errors.New("connection lost").Error(),
+			Uptime: time.Duration(i+1) * time.Minute,
+		})
+	}
+	history.Record("netatmo", Restart{Time: start, Reason: "token expired"})
+
+	// Only the last restarts are kept
+	restarts := history.Get("tasmota")
+	if len(restarts) != 2 {
+		t.Fatalf("Expected 2 restarts, got %d", len(restarts))
+	}
+	if !restarts[0].Time.Equal(start.Add(time.Hour)) || restarts[1].Uptime != 3*time.Minute {
+		t.Errorf("Expected the last two restarts, got %+v", restarts)
+	}
+
+	// The history survives a restart of the agent
+	reloaded, err := NewStorageWithConfig(&StorageConfig{ModuleName: "test-restarts", PreferredDir: dir})
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	history = NewRestartHistory(reloaded, 2)
+	restarts = history.Get("tasmota")
+	if len(restarts) != 2 || restarts[1].Reason != "connection lost" || restarts[1].Uptime != 3*time.Minute {
+		t.Errorf("Expected restarts to be loaded from disk, got %+v", restarts)
+	}
+	if modules := history.Modules(); len(modules) != 2 || modules[0] != "netatmo" || modules[1] != "tasmota" {
+		t.Errorf("Expected modules [netatmo tasmota], got %v", modules)
+	}
+}
+
+func TestRestartHistoryNil(t *testing.T) {
+	if history := NewRestartHistory(nil, 10); history != nil {
+		t.Fatal("Expected nil history without storage")
+	}
+	var history *RestartHistory
+	history.Record("tasmota", Restart{Time: time.Now()})
+	if restarts := history.Get("tasmota"); restarts != nil {
+		t.Errorf("Expected no restarts, got %v", restarts)
+	}
+}
@@ -0,0 +1,72 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains the retry of failed collections of interval-based
+// modules. A collection failing on a transient network error would otherwise
+// lose the sample of that interval; with a retry policy it is retried with
+// backoff until it succeeds or the next collection is due.
+package utils
+
+import (
+	"context"
+	"time"
+)
+
+// defaultRetryBackoff is the delay before the first retry if the policy doesn't set one
+const defaultRetryBackoff = 5 * time.Second
+
+// RetryPolicy configures the retries of failed collections.
+type RetryPolicy struct {
+	Attempts int           // retries after a failed collection, 0 disables retries
+	Backoff  time.Duration // delay before the first retry, doubled for each further one
+}
+
+// retryContextKey is the context key for the retry policy of the current module.
+type retryContextKey struct{}
+
+// WithRetry returns a context carrying the retry policy of a module.
+func WithRetry(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryContextKey{}, policy)
+}
+
+// RetryFromContext returns the retry policy carried by the context. Without
+// one, failed collections are not retried.
+func RetryFromContext(ctx context.Context) RetryPolicy {
+	policy, _ := ctx.Value(retryContextKey{}).(RetryPolicy)
+	return policy
+}
+
+// CollectWithRetry calls collect and retries it according to the retry
+// policy carried by ctx until it succeeds. Retries stop when they are used
+// up, when the next retry wouldn't start before the next collection is due
+// (interval after the first attempt), or when ctx is done. It returns the
+// error of the last attempt.
+func CollectWithRetry(ctx context.Context, interval time.Duration, collect func(context.Context) error) error {
+	policy := RetryFromContext(ctx)
+	deadline := time.Now().Add(interval)
+	backoff := policy.Backoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	err := collect(ctx)
+	for attempt := 1; err != nil && attempt <= policy.Attempts; attempt++ {
+		if interval > 0 && time.Now().Add(backoff).After(deadline) {
+			Debugf("Not retrying failed collection, the next collection is due before the retry")
+			break
+		}
+		Warnf("Collection failed, retrying in %v (attempt %d/%d): %v", backoff, attempt, policy.Attempts, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		if err = collect(ctx); err == nil {
+			Infof("Collection succeeded on retry %d/%d", attempt, policy.Attempts)
+		}
+		backoff *= 2
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCollectWithRetry(t *testing.T) {
+	errTransient := errors.New("connection reset")
+
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		interval time.Duration
+		failures int // collections failing before one succeeds
+		calls    int
+		fails    bool
+	}{
+		{"no policy", RetryPolicy{}, time.Minute, 1, 1, true},
+		{"succeeds on retry", RetryPolicy{Attempts: 3, Backoff: time.Millisecond}, time.Minute, 2, 3, false},
+		{"attempts used up", RetryPolicy{Attempts: 2, Backoff: time.Millisecond}, time.Minute, 5, 3, true},
+		{"next collection due", RetryPolicy{Attempts: 3, Backoff: time.Second}, 100 * time.Millisecond, 5, 1, true},
+		{"first attempt succeeds", RetryPolicy{Attempts: 3, Backoff: time.Millisecond}, time.Minute, 0, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			collect := func(context.Context) error {
+				calls++
+				if calls <= tt.failures {
+					return errTransient
+				}
+				return nil
+			}
+			err := CollectWithRetry(WithRetry(context.Background(), tt.policy), tt.interval, collect)
+			if calls != tt.calls {
+				t.Errorf("Expected %d calls, got %d", tt.calls, calls)
+			}
+			if (err != nil) != tt.fails || (err != nil && !errors.Is(err, errTransient)) {
+				t.Errorf("Unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestCollectWithRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(WithRetry(context.Background(), RetryPolicy{Attempts: 3, Backoff: time.Hour}))
+	calls := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- CollectWithRetry(ctx, 0, func(context.Context) error {
+			calls++
+			return errors.New("timeout")
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err == nil || calls != 1 {
+			t.Errorf("Expected the error of the first attempt, got %v after %d calls", err, calls)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the backoff to end when the context is cancelled")
+	}
+}
@@ -0,0 +1,165 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains collection schedules. A schedule restricts the collections
+// of interval-based modules to daily time windows (e.g. only poll a cloud API
+// between 06:00 and 23:00), optionally with a different interval per window
+// (e.g. poll less often at night).
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScheduleWindow is a daily time window in the configured timezone (see Location). From and To are offsets
+// from midnight; a window with To before From spans midnight.
+type ScheduleWindow struct {
+	From     time.Duration
+	To       time.Duration
+	Interval time.Duration // collection interval within the window, zero keeps the module's interval
+}
+
+// Schedule is a set of daily windows in which collections take place.
+// A nil schedule is always active.
+type Schedule struct {
+	windows []ScheduleWindow
+}
+
+// scheduleContextKey is the context key for the schedule of the current module.
+type scheduleContextKey struct{}
+
+// ParseTimeOfDay parses a time of day in "HH:MM" format into the offset from
+// midnight. "24:00" is accepted as the end of the day.
+func ParseTimeOfDay(s string) (time.Duration, error) {
+	hours, minutes, ok := strings.Cut(strings.TrimSpace(s), ":")
+	h, errH := strconv.Atoi(hours)
+	m, errM := strconv.Atoi(minutes)
+	if !ok || errH != nil || errM != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// NewSchedule creates a schedule from the given windows. It returns nil, which
+// is always active, if no windows are given.
+func NewSchedule(windows []ScheduleWindow) (*Schedule, error) {
+	if len(windows) == 0 {
+		return nil, nil
+	}
+	for _, w := range windows {
+		if w.From == w.To {
+			return nil, fmt.Errorf("schedule window must not be empty")
+		}
+		if w.Interval < 0 {
+			return nil, fmt.Errorf("schedule window interval must not be negative")
+		}
+	}
+	return &Schedule{windows: windows}, nil
+}
+
+// WithSchedule returns a context carrying the collection schedule of a module.
+func WithSchedule(ctx context.Context, schedule *Schedule) context.Context {
+	return context.WithValue(ctx, scheduleContextKey{}, schedule)
+}
+
+// ScheduleFromContext returns the collection schedule carried by the context,
+// or nil if the module has none.
+func ScheduleFromContext(ctx context.Context) *Schedule {
+	schedule, _ := ctx.Value(scheduleContextKey{}).(*Schedule)
+	return schedule
+}
+
+// InSchedule reports whether the collection schedule carried by ctx is active
+// now. It is true if there is no schedule.
+func InSchedule(ctx context.Context) bool {
+	return ScheduleFromContext(ctx).Active(time.Now())
+}
+
+// Active reports whether t is within one of the windows.
+func (s *Schedule) Active(t time.Time) bool {
+	_, _, ok := s.window(t)
+	return ok
+}
+
+// window returns the first window containing t and the time it ends.
+func (s *Schedule) window(t time.Time) (ScheduleWindow, time.Time, bool) {
+	if s == nil {
+		return ScheduleWindow{}, time.Time{}, true
+	}
+	t = t.In(Location())
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	for _, w := range s.windows {
+		switch {
+		case w.From < w.To && offset >= w.From && offset < w.To:
+			return w, midnight.Add(w.To), true
+		case w.From > w.To && offset >= w.From:
+			return w, midnight.AddDate(0, 0, 1).Add(w.To), true
+		case w.From > w.To && offset < w.To:
+			return w, midnight.Add(w.To), true
+		}
+	}
+	return ScheduleWindow{}, time.Time{}, false
+}
+
+// next returns the next time after t at which a window starts.
+func (s *Schedule) next(t time.Time) time.Time {
+	t = t.In(Location())
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	var next time.Time
+	for _, w := range s.windows {
+		start := midnight.Add(w.From)
+		if !start.After(t) {
+			start = midnight.AddDate(0, 0, 1).Add(w.From)
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next
+}
+
+// runScheduled delivers ticks on ch every interval while the schedule is active
+// and once at the start of each window, until stop is closed.
+func runScheduled(schedule *Schedule, interval time.Duration, ch chan<- time.Time, stop <-chan struct{}) {
+	for {
+		now := time.Now()
+		w, end, active := schedule.window(now)
+
+		var wait time.Duration
+		tick := true
+		switch {
+		case !active:
+			wait = schedule.next(now).Sub(now)
+		default:
+			wait = interval
+			if w.Interval > 0 {
+				wait = w.Interval
+			}
+			// Re-evaluate at the end of the window, as the next one may use another interval
+			if untilEnd := end.Sub(now); untilEnd < wait {
+				wait = untilEnd
+				tick = false
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case t := <-timer.C:
+			if !tick {
+				continue
+			}
+			select {
+			case ch <- t:
+			default:
+				// Previous tick not consumed yet, the collection is still running
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func mustSchedule(t *testing.T, windows ...ScheduleWindow) *Schedule {
+	t.Helper()
+	schedule, err := NewSchedule(windows)
+	if err != nil {
+		t.Fatalf("NewSchedule failed: %v", err)
+	}
+	return schedule
+}
+
+func at(hour, minute int) time.Time {
+	return time.Date(2025, 6, 1, hour, minute, 0, 0, time.Local)
+}
+
+func TestParseTimeOfDay(t *testing.T) {
+	tests := []struct {
+		input string
+		want  time.Duration
+		ok    bool
+	}{
+		{"06:00", 6 * time.Hour, true},
+		{"23:59", 23*time.Hour + 59*time.Minute, true},
+		{"24:00", 24 * time.Hour, true},
+		{"24:30", 0, false},
+		{"12:60", 0, false},
+		{"6", 0, false},
+		{"ab:cd", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimeOfDay(tt.input)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseTimeOfDay(%q) = %v, %v", tt.input, got, err)
+		}
+	}
+}
+
+func TestScheduleWindows(t *testing.T) {
+	day := ScheduleWindow{From: 6 * time.Hour, To: 23 * time.Hour}
+	night := ScheduleWindow{From: 23 * time.Hour, To: 6 * time.Hour, Interval: 30 * time.Minute}
+
+	schedule := mustSchedule(t, day)
+	if !schedule.Active(at(6, 0)) || !schedule.Active(at(22, 59)) {
+		t.Error("Expected schedule to be active within the window")
+	}
+	if schedule.Active(at(23, 0)) || schedule.Active(at(5, 59)) {
+		t.Error("Expected schedule to be inactive outside the window")
+	}
+	if next := schedule.next(at(23, 30)); !next.Equal(at(6, 0).AddDate(0, 0, 1)) {
+		t.Errorf("Expected next window tomorrow at 06:00, got %v", next)
+	}
+
+	// A window spanning midnight ends on the following day
+	schedule = mustSchedule(t, day, night)
+	w, end, ok := schedule.window(at(23, 30))
+	if !ok || w.Interval != 30*time.Minute || !end.Equal(at(6, 0).AddDate(0, 0, 1)) {
+		t.Errorf("Unexpected night window %+v ending %v", w, end)
+	}
+	if w, _, _ := schedule.window(at(3, 0)); w.Interval != 30*time.Minute {
+		t.Error("Expected night window to be active at 03:00")
+	}
+
+	var none *Schedule
+	if !none.Active(at(3, 0)) || !InSchedule(context.Background()) {
+		t.Error("Expected missing schedule to be always active")
+	}
+}
+
+func TestScheduledTicker(t *testing.T) {
+	SetTriggeredCollection(false)
+
+	// Active around the clock with a short interval
+	always := mustSchedule(t, ScheduleWindow{From: 0, To: 24 * time.Hour, Interval: 10 * time.Millisecond})
+	ticker := NewScheduledTicker(WithSchedule(context.Background(), always), time.Hour)
+	defer ticker.Stop()
+
+	select {
+	case <-ticker.C:
+	case <-time.After(time.Second):
+		t.Fatal("Scheduled ticker did not fire with the window's interval")
+	}
+}
+
+func TestScheduledTickerTriggered(t *testing.T) {
+	SetTriggeredCollection(true)
+	defer SetTriggeredCollection(false)
+
+	// A window that is never active now
+	now := time.Now()
+	offset := now.Sub(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()))
+	from := (offset + 12*time.Hour) % (24 * time.Hour)
+	inactive := mustSchedule(t, ScheduleWindow{From: from, To: from + time.Minute})
+
+	ticker := NewScheduledTicker(WithSchedule(context.Background(), inactive), time.Hour)
+	defer ticker.Stop()
+
+	if notified := TriggerCollection(); notified != 0 {
+		t.Errorf("Expected triggers outside the schedule to be ignored, notified %d", notified)
+	}
+}
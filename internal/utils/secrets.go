@@ -0,0 +1,200 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains the secrets providers. Instead of a password or token, a
+// setting can hold a reference like "${env:TIBBER_TOKEN}", which is resolved
+// when the configuration is loaded, so credentials don't have to be stored in
+// the configuration file. Supported are:
+//   - "${file:/path}": the content of a file, without trailing newlines
+//   - "${env:NAME}": an environment variable
+//   - "${creds:NAME}": a systemd credential (LoadCredential= or SetCredential=)
+//   - "${vault:path#field}": a field of a HashiCorp Vault KV secret, e.g.
+//     "${vault:secret/data/metrics-agent#tibber_token}", using the VAULT_ADDR,
+//     VAULT_TOKEN and VAULT_NAMESPACE environment variables
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vaultTimeout limits how long a request to Vault may take
+const vaultTimeout = 10 * time.Second
+
+// SecretsProvider returns secrets by reference. The reference is the part of
+// a secret reference after the scheme, e.g. "TIBBER_TOKEN" for "${env:TIBBER_TOKEN}".
+type SecretsProvider interface {
+	Secret(ctx context.Context, ref string) (string, error)
+}
+
+var (
+	secretsProvidersMu sync.RWMutex
+	secretsProviders   = map[string]SecretsProvider{
+		"file":  FileSecrets{},
+		"env":   EnvSecrets{},
+		"creds": CredentialSecrets{},
+		"vault": VaultSecrets{},
+	}
+)
+
+// RegisterSecretsProvider adds a provider for references with the given
+// scheme, replacing an existing provider of the scheme.
+func RegisterSecretsProvider(scheme string, provider SecretsProvider) {
+	secretsProvidersMu.Lock()
+	defer secretsProvidersMu.Unlock()
+	secretsProviders[scheme] = provider
+}
+
+// parseSecretReference splits a secret reference "${scheme:ref}" into its
+// scheme and reference. ok is false if value is no secret reference.
+func parseSecretReference(value string) (scheme, ref string, ok bool) {
+	inner, found := strings.CutPrefix(value, "${")
+	if !found || !strings.HasSuffix(inner, "}") {
+		return "", "", false
+	}
+	scheme, ref, found = strings.Cut(strings.TrimSuffix(inner, "}"), ":")
+	if !found || scheme == "" {
+		return "", "", false
+	}
+	return scheme, ref, true
+}
+
+// IsSecretReference reports whether value is a secret reference like "${env:NAME}".
+func IsSecretReference(value string) bool {
+	_, _, ok := parseSecretReference(value)
+	return ok
+}
+
+// ResolveSecret returns the secret referenced by value. Values that are no
+// secret reference are returned unchanged.
+func ResolveSecret(ctx context.Context, value string) (string, error) {
+	scheme, ref, ok := parseSecretReference(value)
+	if !ok {
+		return value, nil
+	}
+
+	secretsProvidersMu.RLock()
+	provider, exists := secretsProviders[scheme]
+	secretsProvidersMu.RUnlock()
+	if !exists {
+		return "", fmt.Errorf("unknown secrets provider %q in %s", scheme, value)
+	}
+
+	secret, err := provider.Secret(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", value, err)
+	}
+	return secret, nil
+}
+
+// FileSecrets reads secrets from files, e.g. Docker or Kubernetes secrets.
+type FileSecrets struct{}
+
+// Secret returns the content of the file at path without trailing newlines.
+func (FileSecrets) Secret(ctx context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// EnvSecrets reads secrets from environment variables.
+type EnvSecrets struct{}
+
+// Secret returns the value of the environment variable name.
+func (EnvSecrets) Secret(ctx context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// CredentialSecrets reads credentials passed by systemd with LoadCredential=
+// or SetCredential= from the directory in $CREDENTIALS_DIRECTORY.
+type CredentialSecrets struct{}
+
+// Secret returns the credential name.
+func (CredentialSecrets) Secret(ctx context.Context, name string) (string, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return "", fmt.Errorf("CREDENTIALS_DIRECTORY is not set, use LoadCredential= in the systemd unit")
+	}
+	if name == "" || strings.ContainsRune(name, '/') {
+		return "", fmt.Errorf("invalid credential name %q", name)
+	}
+	return FileSecrets{}.Secret(ctx, filepath.Join(dir, name))
+}
+
+// VaultSecrets reads fields of HashiCorp Vault KV secrets, of both version 1
+// and 2 of the secrets engine. The address and token are taken from the
+// VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE environment variables, like the
+// Vault CLI does.
+type VaultSecrets struct{}
+
+// Secret returns a field of a secret referenced as "path#field", where path
+// is the API path of the secret without "/v1/", e.g. "secret/data/metrics-agent".
+func (VaultSecrets) Secret(ctx context.Context, ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("vault reference must be path#field, got %q", ref)
+	}
+	address := os.Getenv("VAULT_ADDR")
+	if address == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, vaultTimeout)
+	defer cancel()
+	url := strings.TrimRight(address, "/") + "/v1/" + strings.TrimLeft(path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	client := &http.Client{Transport: OutboundTransport("vault", nil)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("vault returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("failed to parse secret: %w", err)
+	}
+
+	// KV version 2 nests the fields in data.data, version 1 returns them in data
+	fields := secret.Data
+	if nested, ok := fields["data"].(map[string]interface{}); ok {
+		if _, hasMetadata := fields["metadata"]; hasMetadata {
+			fields = nested
+		}
+	}
+	value, exists := fields[field]
+	if !exists {
+		return "", fmt.Errorf("secret %s has no field %s", path, field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte("file-secret\n"), 0600); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+	t.Setenv("TEST_SECRET", "env-secret")
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+
+	tests := []struct {
+		value    string
+		expected string
+		wantErr  bool
+	}{
+		{value: "plain-password", expected: "plain-password"},
+		{value: "${not a reference", expected: "${not a reference"},
+		{value: "${file:" + filepath.Join(dir, "token") + "}", expected: "file-secret"},
+		{value: "${env:TEST_SECRET}", expected: "env-secret"},
+		{value: "${creds:token}", expected: "file-secret"},
+		{value: "${env:TEST_SECRET_MISSING}", wantErr: true},
+		{value: "${creds:../token}", wantErr: true},
+		{value: "${file:" + filepath.Join(dir, "missing") + "}", wantErr: true},
+		{value: "${unknown:x}", wantErr: true},
+	}
+	for _, tt := range tests {
+		secret, err := ResolveSecret(context.Background(), tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ResolveSecret(%q): expected error, got %q", tt.value, secret)
+			}
+			continue
+		}
+		if err != nil || secret != tt.expected {
+			t.Errorf("ResolveSecret(%q) = %q, %v, expected %q", tt.value, secret, err, tt.expected)
+		}
+	}
+}
+
+func TestVaultSecrets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/metrics-agent": // KV version 2
+			w.Write([]byte(`{"data":{"data":{"tibber_token":"kv2-secret"},"metadata":{"version":3}}}`))
+		case "/v1/kv/metrics-agent": // KV version 1
+			w.Write([]byte(`{"data":{"tibber_token":"kv1-secret"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "vault-token")
+
+	for value, expected := range map[string]string{
+		"${vault:secret/data/metrics-agent#tibber_token}": "kv2-secret",
+		"${vault:kv/metrics-agent#tibber_token}":          "kv1-secret",
+	} {
+		if secret, err := ResolveSecret(context.Background(), value); err != nil || secret != expected {
+			t.Errorf("ResolveSecret(%q) = %q, %v, expected %q", value, secret, err, expected)
+		}
+	}
+
+	for _, value := range []string{
+		"${vault:secret/data/metrics-agent#missing}",
+		"${vault:secret/data/unknown#tibber_token}",
+		"${vault:secret/data/metrics-agent}",
+	} {
+		if _, err := ResolveSecret(context.Background(), value); err == nil {
+			t.Errorf("ResolveSecret(%q): expected error", value)
+		}
+	}
+
+	t.Setenv("VAULT_TOKEN", "wrong")
+	if _, err := ResolveSecret(context.Background(), "${vault:secret/data/metrics-agent#tibber_token}"); err == nil {
+		t.Error("Expected error for a denied token")
+	}
+}
+
+// staticSecrets is a secrets provider returning the reference as secret
+type staticSecrets struct{}
+
+func (staticSecrets) Secret(ctx context.Context, ref string) (string, error) {
+	return "static-" + ref, nil
+}
+
+func TestRegisterSecretsProvider(t *testing.T) {
+	RegisterSecretsProvider("static", staticSecrets{})
+	t.Cleanup(func() {
+		secretsProvidersMu.Lock()
+		delete(secretsProviders, "static")
+		secretsProvidersMu.Unlock()
+	})
+
+	if !IsSecretReference("${static:x}") || IsSecretReference("static:x") {
+		t.Error("Unexpected result of IsSecretReference")
+	}
+	if secret, err := ResolveSecret(context.Background(), "${static:x}"); err != nil || secret != "static-x" {
+		t.Errorf("Expected secret from registered provider, got %q, %v", secret, err)
+	}
+}
@@ -0,0 +1,112 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains the results of self-test checks, which modules run for
+// "metrics-agent selftest" to verify credentials and API access in more depth
+// than the startup probes, and remediation hints for common failures. The check
+// of MQTT credentials is in package internal/selftest/mqtt.
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+)
+
+// Check is the result of a single self-test check of a module.
+type Check struct {
+	Name   string // What was checked, e.g. "OAuth token"
+	Detail string // Additional information on success, e.g. the remaining API quota
+	Err    error  // nil if the check passed
+	Hint   string // How to fix a failed check; derived from Err if empty
+}
+
+// Passed reports whether the check passed.
+func (c Check) Passed() bool {
+	return c.Err == nil
+}
+
+// Remediation returns the hint for a failed check, or "" if none is known.
+func (c Check) Remediation() string {
+	if c.Hint != "" {
+		return c.Hint
+	}
+	return ErrorHint(c.Err)
+}
+
+// ErrorHint returns a remediation hint for common connectivity errors, or ""
+// if the error is not recognized.
+func ErrorHint(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrDestinationBlocked):
+		return "Add the host to allowed_destinations"
+	case errors.As(err, &dnsErr):
+		return "Check the host name and the DNS resolution of this machine"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "Check that the service is running and listening on the configured port"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "Check the network route to the host"
+	case errors.As(err, &certErr), errors.As(err, &authorityErr), errors.As(err, &hostnameErr):
+		return "Check the TLS certificate of the server and the configured host name"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "Check that the host is online and no firewall blocks the connection"
+	}
+	return ""
+}
+
+// APICheck builds the check of an API request from its response. Rejected
+// credentials and exhausted quotas fail with a matching hint; on success the
+// remaining quota is reported if the API sends rate limit headers. The
+// response body is not read or closed.
+func APICheck(name string, resp *http.Response, err error) Check {
+	check := Check{Name: name}
+	switch {
+	case err != nil:
+		check.Err = err
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		check.Err = fmt.Errorf("credentials rejected with status %d", resp.StatusCode)
+		check.Hint = "Check the configured token or credentials"
+	case resp.StatusCode == http.StatusTooManyRequests:
+		check.Err = fmt.Errorf("API quota exhausted")
+		check.Hint = "Wait for the quota to reset or increase the collection interval"
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		check.Err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+	default:
+		if remaining, ok := RateLimitRemaining(resp.Header); ok {
+			check.Detail = fmt.Sprintf("%d requests remaining", remaining)
+			if remaining == 0 {
+				check.Err = fmt.Errorf("API quota exhausted")
+				check.Hint = "Wait for the quota to reset or increase the collection interval"
+			}
+		}
+	}
+	return check
+}
+
+// RateLimitRemaining returns the number of remaining requests reported in the
+// X-RateLimit-Remaining or RateLimit-Remaining header of a response.
+func RateLimitRemaining(header http.Header) (int, bool) {
+	for _, key := range []string{"X-RateLimit-Remaining", "RateLimit-Remaining"} {
+		if value := header.Get(key); value != "" {
+			remaining, err := strconv.Atoi(value)
+			if err == nil && remaining >= 0 {
+				return remaining, true
+			}
+		}
+	}
+	return 0, false
+}
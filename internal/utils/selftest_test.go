@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestErrorHint(t *testing.T) {
+	if hint := ErrorHint(nil); hint != "" {
+		t.Errorf("Expected no hint without error, got %q", hint)
+	}
+	if hint := ErrorHint(errors.New("something")); hint != "" {
+		t.Errorf("Expected no hint for unknown error, got %q", hint)
+	}
+	for _, err := range []error{
+		&net.DNSError{Err: "no such host", Name: "broker.invalid"},
+		fmt.Errorf("probe failed: %w", context.DeadlineExceeded),
+		fmt.Errorf("blocked: %w", ErrDestinationBlocked),
+	} {
+		if ErrorHint(err) == "" {
+			t.Errorf("Expected hint for %v", err)
+		}
+	}
+
+	// A refused connection to a closed port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	err = ProbeAddress(context.Background(), address, time.Second)
+	if err == nil || ErrorHint(err) == "" {
+		t.Errorf("Expected hint for refused connection, got %v", err)
+	}
+}
+
+func TestAPICheck(t *testing.T) {
+	response := func(status int, remaining string) *http.Response {
+		resp := &http.Response{StatusCode: status, Header: http.Header{}}
+		if remaining != "" {
+			resp.Header.Set("X-RateLimit-Remaining", remaining)
+		}
+		return resp
+	}
+
+	check := APICheck("API", response(http.StatusOK, "480"), nil)
+	if !check.Passed() || check.Detail != "480 requests remaining" {
+		t.Errorf("Expected passed check with quota, got %+v", check)
+	}
+	if check := APICheck("API", response(http.StatusOK, ""), nil); !check.Passed() || check.Detail != "" {
+		t.Errorf("Expected passed check without quota, got %+v", check)
+	}
+
+	for _, resp := range []*http.Response{
+		response(http.StatusOK, "0"),
+		response(http.StatusTooManyRequests, ""),
+		response(http.StatusUnauthorized, ""),
+	} {
+		if check := APICheck("API", resp, nil); check.Passed() || check.Remediation() == "" {
+			t.Errorf("Expected failed check with hint for status %d, got %+v", resp.StatusCode, check)
+		}
+	}
+	if check := APICheck("API", response(http.StatusInternalServerError, ""), nil); check.Passed() {
+		t.Error("Expected failed check for server error")
+	}
+	if check := APICheck("API", nil, errors.New("unreachable")); check.Passed() {
+		t.Error("Expected failed check for request error")
+	}
+}
@@ -0,0 +1,85 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains a semaphore for limiting concurrent message processing,
+// and the per-module limit of concurrently running goroutines, which the
+// module manager puts in the context given to a module's Run function.
+package utils
+
+import "context"
+
+// Semaphore limits the number of concurrently running operations.
+// A nil Semaphore does not limit.
+type Semaphore chan struct{}
+
+// NewSemaphore creates a semaphore that allows up to n concurrent operations.
+// For n <= 0 it returns nil, which does not limit.
+func NewSemaphore(n int) Semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(Semaphore, n)
+}
+
+// Do runs fn once a slot is free.
+func (s Semaphore) Do(fn func()) {
+	if s == nil {
+		fn()
+		return
+	}
+	s <- struct{}{}
+	defer func() { <-s }()
+	fn()
+}
+
+// acquire waits for a free slot. It returns false if the context is done first.
+func (s Semaphore) acquire(ctx context.Context) bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees a slot taken by acquire.
+func (s Semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// concurrencyContextKey is the context key for the goroutine limit of the running module.
+type concurrencyContextKey struct{}
+
+// WithConcurrencyLimit returns a context carrying the semaphore that limits the
+// goroutines a module starts with Go. A nil semaphore does not limit.
+func WithConcurrencyLimit(ctx context.Context, sem Semaphore) context.Context {
+	return context.WithValue(ctx, concurrencyContextKey{}, sem)
+}
+
+// ConcurrencyLimitFromContext returns the semaphore carried by the context,
+// or nil if there is none.
+func ConcurrencyLimitFromContext(ctx context.Context) Semaphore {
+	sem, _ := ctx.Value(concurrencyContextKey{}).(Semaphore)
+	return sem
+}
+
+// Go runs fn in a new goroutine with panic recovery once the module carried by
+// the context is below its goroutine limit. It blocks while the limit is
+// reached, so a module emitting faster than its work completes is slowed down
+// instead of piling up goroutines. It returns false without running fn if the
+// context is done before a slot is free.
+func Go(ctx context.Context, operation string, fn func()) bool {
+	sem := ConcurrencyLimitFromContext(ctx)
+	if !sem.acquire(ctx) {
+		return false
+	}
+	go func() {
+		defer sem.release()
+		WithPanicRecoveryAndContinue(operation, ModuleFromContext(ctx), fn)
+	}()
+	return true
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSemaphore(t *testing.T) {
+	sem := NewSemaphore(2)
+
+	var running, maxRunning atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem.Do(func() {
+				current := running.Add(1)
+				for {
+					max := maxRunning.Load()
+					if current <= max || maxRunning.CompareAndSwap(max, current) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				running.Add(-1)
+			})
+		}()
+	}
+	wg.Wait()
+
+	if maxRunning.Load() > 2 {
+		t.Errorf("Expected at most 2 concurrent operations, got %d", maxRunning.Load())
+	}
+}
+
+func TestSemaphoreUnlimited(t *testing.T) {
+	sem := NewSemaphore(0)
+	if sem != nil {
+		t.Fatal("Expected nil semaphore for n <= 0")
+	}
+
+	called := false
+	sem.Do(func() { called = true })
+	if !called {
+		t.Error("Expected nil semaphore to run the function")
+	}
+}
+
+func TestGo(t *testing.T) {
+	ctx, cancel := context.WithCancel(WithConcurrencyLimit(context.Background(), NewSemaphore(1)))
+	defer cancel()
+
+	release := make(chan struct{})
+	done := make(chan struct{})
+	if !Go(ctx, "first", func() {
+		<-release
+		close(done)
+	}) {
+		t.Fatal("Expected first goroutine to start")
+	}
+
+	// The second goroutine waits for the first one to finish
+	started := make(chan bool, 1)
+	go func() {
+		started <- Go(ctx, "second", func() {
+			select {
+			case <-done:
+			default:
+				t.Error("Expected second goroutine to start after the first one finished")
+			}
+		})
+	}()
+	select {
+	case <-started:
+		t.Fatal("Expected Go to block while the limit is reached")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if !<-started {
+		t.Error("Expected second goroutine to start once a slot is free")
+	}
+
+	// A panic frees the slot as well
+	Go(ctx, "panicking", func() { panic("boom") })
+	finished := make(chan struct{})
+	Go(ctx, "after panic", func() { close(finished) })
+	<-finished
+
+	// Go gives up when the context is done while waiting
+	block := make(chan struct{})
+	defer close(block)
+	Go(ctx, "blocking", func() { <-block })
+	cancel()
+	if Go(ctx, "cancelled", func() { t.Error("Expected cancelled goroutine not to run") }) {
+		t.Error("Expected Go to return false for a done context")
+	}
+}
+
+func TestGoUnlimited(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(3)
+	for i := 0; i < 3; i++ {
+		if !Go(context.Background(), "unlimited", wg.Done) {
+			t.Error("Expected Go to start without limit")
+		}
+	}
+	wg.Wait()
+}
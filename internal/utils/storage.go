@@ -12,6 +12,23 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
+)
+
+// defaultMaxPendingWrites is the number of delayed changes after which a storage
+// is written even if it keeps changing, unless configured otherwise.
+const defaultMaxPendingWrites = 100
+
+var (
+	// storageWriteDelay and storageMaxPending are the write settings of storages
+	// created with DefaultStorageConfig.
+	storageWriteDelay time.Duration
+	storageMaxPending int
+
+	// delayedStorages holds the storages with delayed writes by file path,
+	// so pending changes can be flushed on shutdown.
+	delayedStoragesMu sync.Mutex
+	delayedStorages   = make(map[string]*Storage)
 )
 
 // Storage provides a thread-safe key-value storage system for modules.
@@ -24,10 +41,28 @@ import (
 // - Atomic file operations
 // - JSON serialization/deserialization
 // - Thread-safe concurrent access
+//
+// With delayed writes (see StorageConfig.WriteDelay), changes are written to
+// disk in the background, and errors writing them are logged instead of
+// being returned by the method making the change.
 type Storage struct {
 	filePath string
 	data     map[string]interface{}
 	mutex    sync.RWMutex
+
+	// Delayed writes: changes are written after writeDelay without further
+	// changes, or once maxPending changes are pending.
+	writeDelay time.Duration
+	maxPending int
+	pending    int
+	timer      *time.Timer
+
+	// Quota: accessed holds the last access of every entry in Unix nanoseconds. It
+	// has its own mutex, as entries are also accessed under the read lock.
+	quota       StorageQuota
+	accessMu    sync.Mutex
+	accessed    map[string]int64
+	quotaWarned bool
 }
 
 // StorageConfig holds configuration for storage initialization.
@@ -43,15 +78,51 @@ type StorageConfig struct {
 	// FallbackDir is the fallback directory for development (default: ".data").
 	// Used when the preferred directory is not accessible.
 	FallbackDir string
+
+	// WriteDelay delays writing changes to disk until no further change was made
+	// for this long, so frequent updates don't wear out SD cards.
+	// 0 writes every change immediately.
+	WriteDelay time.Duration
+
+	// MaxPendingWrites is the number of delayed changes after which the file is
+	// written even if the storage keeps changing (default: 100).
+	MaxPendingWrites int
+
+	// Quota limits the size of the storage file and the age of its entries.
+	// The zero value keeps all entries.
+	Quota StorageQuota
 }
 
 // DefaultStorageConfig returns a default storage configuration.
 // It sets up the standard directory hierarchy for production and development environments.
 func DefaultStorageConfig(moduleName string) *StorageConfig {
 	return &StorageConfig{
-		ModuleName:   moduleName,
-		PreferredDir: "/var/lib/metrics-agent",
-		FallbackDir:  ".data",
+		ModuleName:       moduleName,
+		PreferredDir:     "/var/lib/metrics-agent",
+		FallbackDir:      ".data",
+		WriteDelay:       storageWriteDelay,
+		MaxPendingWrites: storageMaxPending,
+		Quota:            storageQuota(moduleName),
+	}
+}
+
+// SetStorageWriteDelay configures delayed writes for storages created afterwards
+// with NewStorage. A delay of 0 writes every change immediately.
+func SetStorageWriteDelay(delay time.Duration, maxPendingWrites int) {
+	storageWriteDelay = delay
+	storageMaxPending = maxPendingWrites
+}
+
+// FlushStorages writes the pending changes of all storages with delayed writes.
+// It is called on shutdown and before modules are restarted.
+func FlushStorages() {
+	delayedStoragesMu.Lock()
+	defer delayedStoragesMu.Unlock()
+
+	for path, storage := range delayedStorages {
+		if err := storage.Flush(); err != nil {
+			Warnf("Failed to write storage %s: %v", path, err)
+		}
 	}
 }
 
@@ -75,8 +146,26 @@ func NewStorageWithConfig(config *StorageConfig) (*Storage, error) {
 	}
 
 	storage := &Storage{
-		filePath: filePath,
-		data:     make(map[string]interface{}),
+		filePath:   filePath,
+		data:       make(map[string]interface{}),
+		writeDelay: config.WriteDelay,
+		maxPending: config.MaxPendingWrites,
+		quota:      config.Quota,
+		accessed:   make(map[string]int64),
+	}
+	if storage.writeDelay > 0 {
+		if storage.maxPending <= 0 {
+			storage.maxPending = defaultMaxPendingWrites
+		}
+		delayedStoragesMu.Lock()
+		defer delayedStoragesMu.Unlock()
+		// A restarted module must see the changes still pending in its previous storage
+		if previous := delayedStorages[filePath]; previous != nil {
+			if err := previous.Flush(); err != nil {
+				Warnf("Failed to write storage %s: %v", filePath, err)
+			}
+		}
+		delayedStorages[filePath] = storage
 	}
 
 	// Load existing data if file exists
@@ -84,6 +173,13 @@ func NewStorageWithConfig(config *StorageConfig) (*Storage, error) {
 		// If file doesn't exist or is corrupted, start with empty data
 		storage.data = make(map[string]interface{})
 	}
+	if expired := storage.loadAccessTimes(); expired > 0 {
+		storage.mutex.Lock()
+		if err := storage.persist(); err != nil {
+			Warnf("Failed to write storage %s: %v", filePath, err)
+		}
+		storage.mutex.Unlock()
+	}
 
 	return storage, nil
 }
@@ -106,6 +202,14 @@ func determineStoragePath(config *StorageConfig) (string, error) {
 	return fileName, nil
 }
 
+// StorageDir returns the directory storages are created in: the preferred
+// directory if it is writable, otherwise the fallback directory, otherwise the
+// current directory.
+func StorageDir() string {
+	path, _ := determineStoragePath(DefaultStorageConfig("agent"))
+	return filepath.Dir(path)
+}
+
 // tryStorageDirectory attempts to use a specific directory for storage.
 // Returns the full file path if successful, or an error if the directory cannot be used.
 func tryStorageDirectory(dir, moduleName string, isFallback bool) (string, error) {
@@ -138,7 +242,42 @@ func (s *Storage) Set(key string, value interface{}) error {
 	defer s.mutex.Unlock()
 
 	s.data[key] = value
-	return s.save()
+	s.touch(key)
+	return s.persist()
+}
+
+// SetMany stores multiple key-value pairs and persists them to disk with a
+// single write, so either all or none of the values reach the file.
+// Returns an error if the data cannot be persisted to disk.
+func (s *Storage) SetMany(values map[string]interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key, value := range values {
+		s.data[key] = value
+		s.touch(key)
+	}
+	return s.persist()
+}
+
+// Update replaces the value of a key with the result of fn, which receives the
+// current value (nil if the key doesn't exist), and persists it to disk.
+// The storage stays locked while fn runs and the data is saved, so concurrent
+// read-modify-write cycles on the same storage can't overwrite each other.
+// If fn returns nil, the key is deleted. fn must not call other methods of the storage.
+// Returns an error if the data cannot be persisted to disk.
+func (s *Storage) Update(key string, fn func(old interface{}) interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if value := fn(s.data[key]); value != nil {
+		s.data[key] = value
+		s.touch(key)
+	} else {
+		delete(s.data, key)
+		s.forget(key)
+	}
+	return s.persist()
 }
 
 // Get retrieves a value by key from the storage.
@@ -147,7 +286,11 @@ func (s *Storage) Get(key string) interface{} {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	return s.data[key]
+	value, exists := s.data[key]
+	if exists {
+		s.touch(key)
+	}
+	return value
 }
 
 // GetString retrieves a string value by key from the storage.
@@ -165,7 +308,12 @@ func (s *Storage) GetString(key string) string {
 // Returns 0 if the key doesn't exist or the value is not a number.
 // Handles both int and float64 types (common when unmarshaling JSON).
 func (s *Storage) GetInt(key string) int {
-	value := s.Get(key)
+	return IntValue(s.Get(key))
+}
+
+// IntValue converts a stored value to an integer, e.g. the old value passed to
+// Update. Returns 0 if the value is not a number.
+func IntValue(value interface{}) int {
 	if i, ok := value.(int); ok {
 		return i
 	}
@@ -208,7 +356,8 @@ func (s *Storage) Delete(key string) error {
 	defer s.mutex.Unlock()
 
 	delete(s.data, key)
-	return s.save()
+	s.forget(key)
+	return s.persist()
 }
 
 // Exists checks if a key exists in the storage.
@@ -244,7 +393,10 @@ func (s *Storage) Clear() error {
 	defer s.mutex.Unlock()
 
 	s.data = make(map[string]interface{})
-	return s.save()
+	s.accessMu.Lock()
+	s.accessed = make(map[string]int64)
+	s.accessMu.Unlock()
+	return s.persist()
 }
 
 // load reads data from the storage file into memory.
@@ -275,13 +427,68 @@ func (s *Storage) load() error {
 	return nil
 }
 
+// Flush writes pending changes of a storage with delayed writes to disk.
+// Returns an error if the data cannot be persisted to disk.
+func (s *Storage) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.pending == 0 {
+		return nil
+	}
+	return s.flushPending()
+}
+
+// persist writes the data to disk, or schedules the write if writes are delayed.
+// The mutex must be held.
+func (s *Storage) persist() error {
+	if s.writeDelay <= 0 {
+		return s.save()
+	}
+
+	s.pending++
+	if s.pending >= s.maxPending {
+		return s.flushPending()
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.writeDelay, s.flushDelayed)
+	} else {
+		s.timer.Reset(s.writeDelay)
+	}
+	return nil
+}
+
+// flushPending writes the pending changes and stops the write timer.
+// The mutex must be held.
+func (s *Storage) flushPending() error {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.pending = 0
+	return s.save()
+}
+
+// flushDelayed is called by the write timer once the storage stopped changing.
+func (s *Storage) flushDelayed() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.pending == 0 {
+		return
+	}
+	if err := s.flushPending(); err != nil {
+		Warnf("Failed to write storage %s: %v", s.filePath, err)
+	}
+}
+
 // save writes the current storage data to disk as formatted JSON.
 // Uses appropriate file permissions based on the storage location:
 // - 0600 (owner read/write only) for system directories like /var/lib
 // - 0644 (owner read/write, group/other read) for development directories
 func (s *Storage) save() error {
-	// Marshal data with pretty-printing for human readability
-	data, err := json.MarshalIndent(s.data, "", "  ")
+	// Marshal data with pretty-printing for human readability, removing
+	// entries first if the storage exceeds its quota
+	data, err := s.enforceQuota()
 	if err != nil {
 		return fmt.Errorf("failed to marshal storage data: %w", err)
 	}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestNewStorage(t *testing.T) {
@@ -288,6 +290,125 @@ func TestStorage_Delete(t *testing.T) {
 	}
 }
 
+func TestStorage_SetMany(t *testing.T) {
+	storage, err := NewStorage("test-set-many")
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer os.Remove(storage.filePath)
+
+	if err := storage.SetMany(map[string]interface{}{"key1": "value1", "key2": 2}); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+
+	// Both values are persisted
+	reloaded, err := NewStorage("test-set-many")
+	if err != nil {
+		t.Fatalf("Failed to reload storage: %v", err)
+	}
+	if reloaded.GetString("key1") != "value1" || reloaded.GetInt("key2") != 2 {
+		t.Errorf("Expected both values to be persisted, got %v", reloaded.data)
+	}
+}
+
+func TestStorage_Update(t *testing.T) {
+	storage, err := NewStorage("test-update")
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer os.Remove(storage.filePath)
+
+	// Concurrent increments must not overwrite each other
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := storage.Update("counter", func(old interface{}) interface{} {
+				return IntValue(old) + 1
+			}); err != nil {
+				t.Errorf("Update failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if counter := storage.GetInt("counter"); counter != 20 {
+		t.Errorf("Expected counter 20, got %d", counter)
+	}
+
+	// Returning nil deletes the key
+	if err := storage.Update("counter", func(old interface{}) interface{} { return nil }); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if storage.Exists("counter") {
+		t.Error("Expected counter to be deleted")
+	}
+}
+
+func TestStorage_DelayedWrites(t *testing.T) {
+	dir := t.TempDir()
+	newStorage := func(maxPending int) *Storage {
+		storage, err := NewStorageWithConfig(&StorageConfig{
+			ModuleName:       "test-delayed",
+			PreferredDir:     dir,
+			WriteDelay:       20 * time.Millisecond,
+			MaxPendingWrites: maxPending,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		return storage
+	}
+	stored := func(storage *Storage) map[string]interface{} {
+		data := make(map[string]interface{})
+		if content, err := os.ReadFile(storage.filePath); err == nil {
+			json.Unmarshal(content, &data)
+		}
+		return data
+	}
+
+	storage := newStorage(0)
+	storage.Set("key1", "value1")
+	if _, exists := stored(storage)["key1"]; exists {
+		t.Fatal("Expected the change not to be written immediately")
+	}
+
+	// Written once the storage stopped changing
+	deadline := time.Now().Add(2 * time.Second)
+	for stored(storage)["key1"] != "value1" {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the change to be written after the write delay")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Written after max pending changes even if the storage keeps changing
+	storage = newStorage(3)
+	storage.Set("a", 1)
+	storage.Set("b", 2)
+	if _, exists := stored(storage)["b"]; exists {
+		t.Error("Expected two changes to stay pending")
+	}
+	storage.Set("c", 3)
+	if _, exists := stored(storage)["c"]; !exists {
+		t.Error("Expected the third change to write the storage")
+	}
+
+	// Flushed explicitly, e.g. on shutdown
+	storage.Set("d", 4)
+	FlushStorages()
+	if _, exists := stored(storage)["d"]; !exists {
+		t.Error("Expected pending changes to be written by FlushStorages")
+	}
+
+	// A new storage for the same file sees the changes pending in the previous one
+	storage.Set("e", 5)
+	if reloaded := newStorage(0); !reloaded.Exists("e") {
+		t.Error("Expected pending changes to be flushed before the storage is reloaded")
+	}
+}
+
 func TestStorage_Exists(t *testing.T) {
 	storage, err := NewStorage("test-exists")
 	if err != nil {
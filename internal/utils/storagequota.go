@@ -0,0 +1,195 @@
+// Package utils provides utility functions for the metrics agent.
+//
+// This file contains the storage quotas. A module with a bug, e.g. one
+// keeping state for every device ID it ever saw, could otherwise grow its
+// state file until the SD card under /var/lib/metrics-agent is full. Entries
+// not used for a while expire, and storages over their size limit drop their
+// least recently used entries.
+package utils
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// storageAccessKey is the key the access times of the entries are kept under
+// in the storage file. It is not visible through the storage methods.
+const storageAccessKey = "_accessed"
+
+var (
+	// storageQuotaDefault and storageQuotaOverrides are the quotas of storages
+	// created with DefaultStorageConfig.
+	storageQuotaDefault   StorageQuota
+	storageQuotaOverrides map[string]StorageQuota
+)
+
+// StorageQuota limits the state a storage keeps. Zero values disable a limit.
+type StorageQuota struct {
+	MaxSize int           // maximum size of the storage file in bytes
+	MaxAge  time.Duration // entries not read or written for this long are removed
+}
+
+// enabled reports whether any limit is set.
+func (q StorageQuota) enabled() bool {
+	return q.MaxSize > 0 || q.MaxAge > 0
+}
+
+// SetStorageQuotas configures the quotas of storages created afterwards with
+// NewStorage: the quota of a storage is looked up in overrides by its name,
+// then by the module name of an instance (e.g. "netatmo" for
+// "netatmo.haus1"), and defaults to defaultQuota.
+func SetStorageQuotas(defaultQuota StorageQuota, overrides map[string]StorageQuota) {
+	storageQuotaDefault = defaultQuota
+	storageQuotaOverrides = overrides
+}
+
+// storageQuota returns the quota of the storage with the given name.
+func storageQuota(name string) StorageQuota {
+	if quota, ok := storageQuotaOverrides[name]; ok {
+		return quota
+	}
+	moduleName, _, _ := strings.Cut(name, ".")
+	if quota, ok := storageQuotaOverrides[moduleName]; ok {
+		return quota
+	}
+	return storageQuotaDefault
+}
+
+// touch records an access of the entries. It is safe to call with the read lock held.
+func (s *Storage) touch(keys ...string) {
+	if !s.quota.enabled() {
+		return
+	}
+	s.accessMu.Lock()
+	defer s.accessMu.Unlock()
+	now := time.Now().UnixNano()
+	for _, key := range keys {
+		s.accessed[key] = now
+	}
+}
+
+// forget removes the access times of deleted entries. The mutex must be held.
+func (s *Storage) forget(keys ...string) {
+	s.accessMu.Lock()
+	defer s.accessMu.Unlock()
+	for _, key := range keys {
+		delete(s.accessed, key)
+	}
+}
+
+// loadAccessTimes takes the access times out of the loaded data and removes
+// the expired entries. Entries without an access time, e.g. written before a
+// quota was configured, count as accessed now. It returns the number of
+// expired entries. The mutex must be held.
+func (s *Storage) loadAccessTimes() int {
+	times, _ := s.data[storageAccessKey].(map[string]interface{})
+	delete(s.data, storageAccessKey)
+	if !s.quota.enabled() {
+		return 0
+	}
+
+	s.accessMu.Lock()
+	defer s.accessMu.Unlock()
+	now := time.Now().UnixNano()
+	for key := range s.data {
+		if t, ok := times[key].(float64); ok {
+			s.accessed[key] = int64(t)
+		} else {
+			s.accessed[key] = now
+		}
+	}
+	return s.expire()
+}
+
+// expire removes the entries not accessed within the maximum age and
+// returns their number. The mutex and accessMu must be held.
+func (s *Storage) expire() int {
+	if s.quota.MaxAge <= 0 {
+		return 0
+	}
+	cutoff := time.Now().Add(-s.quota.MaxAge).UnixNano()
+	expired := 0
+	for key := range s.data {
+		if s.accessed[key] < cutoff {
+			delete(s.data, key)
+			delete(s.accessed, key)
+			expired++
+		}
+	}
+	if expired > 0 {
+		Debugf("[storage] removed %d entries of %s not used for %v", expired, s.filePath, s.quota.MaxAge)
+	}
+	return expired
+}
+
+// enforceQuota removes expired entries and, if the storage exceeds its size
+// limit, the least recently used entries. It returns the data to write,
+// including the access times. The mutex must be held.
+func (s *Storage) enforceQuota() ([]byte, error) {
+	if !s.quota.enabled() {
+		return json.MarshalIndent(s.data, "", "  ")
+	}
+
+	s.accessMu.Lock()
+	defer s.accessMu.Unlock()
+
+	s.expire()
+	data, err := s.marshalWithAccessTimes()
+	if err != nil || s.quota.MaxSize <= 0 || len(data) <= s.quota.MaxSize {
+		return data, err
+	}
+
+	// Remove the least recently used entries, estimating the size they take
+	// up in the file to avoid marshaling the data after every removal
+	keys := make([]string, 0, len(s.data))
+	for key := range s.data {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return s.accessed[keys[i]] < s.accessed[keys[j]]
+	})
+	size, evicted := len(data), 0
+	for _, key := range keys {
+		if size <= s.quota.MaxSize {
+			if data, err = s.marshalWithAccessTimes(); err != nil {
+				return nil, err
+			}
+			if len(data) <= s.quota.MaxSize {
+				break
+			}
+			size = len(data)
+		}
+		size -= entrySize(key, s.data[key])
+		delete(s.data, key)
+		delete(s.accessed, key)
+		evicted++
+	}
+
+	if s.quotaWarned {
+		Debugf("[storage] removed %d least recently used entries of %s to stay within %d bytes", evicted, s.filePath, s.quota.MaxSize)
+	} else {
+		s.quotaWarned = true
+		Warnf("[storage] %s exceeds its quota of %d bytes, removed %d least recently used entries (further removals are logged at debug level)", s.filePath, s.quota.MaxSize, evicted)
+	}
+	return s.marshalWithAccessTimes()
+}
+
+// marshalWithAccessTimes marshals the data together with the access times of
+// the entries. The mutex and accessMu must be held.
+func (s *Storage) marshalWithAccessTimes() ([]byte, error) {
+	data := make(map[string]interface{}, len(s.data)+1)
+	for key, value := range s.data {
+		data[key] = value
+	}
+	data[storageAccessKey] = s.accessed
+	return json.MarshalIndent(data, "", "  ")
+}
+
+// entrySize estimates the size of an entry in the storage file, including
+// its access time.
+func entrySize(key string, value interface{}) int {
+	encoded, _ := json.MarshalIndent(value, "  ", "  ")
+	return 2*len(key) + len(encoded) + 32
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newQuotaStorage(t *testing.T, dir string, quota StorageQuota) *Storage {
+	t.Helper()
+	storage, err := NewStorageWithConfig(&StorageConfig{
+		ModuleName:   "test-quota",
+		PreferredDir: dir,
+		Quota:        quota,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	return storage
+}
+
+func TestStorage_QuotaMaxSize(t *testing.T) {
+	storage := newQuotaStorage(t, t.TempDir(), StorageQuota{MaxSize: 1000})
+
+	value := strings.Repeat("x", 100)
+	for i := 0; i < 20; i++ {
+		if err := storage.Set(fmt.Sprintf("key%02d", i), value); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+
+	content, err := os.ReadFile(storage.GetFilePath())
+	if err != nil {
+		t.Fatalf("Failed to read storage file: %v", err)
+	}
+	if len(content) > 1000 {
+		t.Errorf("Expected the storage file to stay within 1000 bytes, got %d", len(content))
+	}
+	if !storage.Exists("key19") {
+		t.Error("Expected the most recently written entry to be kept")
+	}
+	if storage.Exists("key00") {
+		t.Error("Expected the least recently used entry to be removed")
+	}
+	if len(storage.Keys()) < 5 {
+		t.Errorf("Expected the storage to keep as many entries as fit, got %d", len(storage.Keys()))
+	}
+}
+
+func TestStorage_QuotaKeepsRecentlyRead(t *testing.T) {
+	storage := newQuotaStorage(t, t.TempDir(), StorageQuota{MaxSize: 700})
+
+	value := strings.Repeat("x", 100)
+	storage.Set("old", value)
+	storage.accessed["old"] = time.Now().Add(-time.Hour).UnixNano()
+	storage.Set("unused", value)
+	storage.accessed["unused"] = time.Now().Add(-2 * time.Hour).UnixNano()
+
+	// Reading an entry marks it as used
+	storage.Get("old")
+	for i := 0; i < 3; i++ {
+		storage.Set(fmt.Sprintf("key%d", i), value)
+	}
+
+	if !storage.Exists("old") {
+		t.Error("Expected the recently read entry to be kept")
+	}
+	if storage.Exists("unused") {
+		t.Error("Expected the unused entry to be removed")
+	}
+}
+
+func TestStorage_QuotaMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	storage := newQuotaStorage(t, dir, StorageQuota{MaxAge: time.Hour})
+	storage.Set("stale", 1)
+	storage.Set("fresh", 2)
+	storage.accessed["stale"] = time.Now().Add(-2 * time.Hour).UnixNano()
+	storage.Set("fresh", 3)
+	if storage.Exists("stale") {
+		t.Error("Expected the stale entry to be removed on write")
+	}
+
+	// Access times are kept in the file, but not visible as entry
+	content, err := os.ReadFile(storage.GetFilePath())
+	if err != nil {
+		t.Fatalf("Failed to read storage file: %v", err)
+	}
+	var stored map[string]interface{}
+	json.Unmarshal(content, &stored)
+	if _, ok := stored[storageAccessKey]; !ok {
+		t.Errorf("Expected the access times to be stored, got %s", content)
+	}
+	for _, key := range storage.Keys() {
+		if key == storageAccessKey {
+			t.Error("Expected the access times not to be returned as key")
+		}
+	}
+
+	// Entries expire when the storage is loaded
+	stored["old"] = "value"
+	stored[storageAccessKey].(map[string]interface{})["old"] = float64(time.Now().Add(-3 * time.Hour).UnixNano())
+	content, _ = json.Marshal(stored)
+	os.WriteFile(storage.GetFilePath(), content, 0644)
+
+	reloaded := newQuotaStorage(t, dir, StorageQuota{MaxAge: time.Hour})
+	if reloaded.Exists("old") {
+		t.Error("Expected the expired entry to be removed when loaded")
+	}
+	if reloaded.GetInt("fresh") != 3 {
+		t.Errorf("Expected the fresh entry to be kept, got %v", reloaded.Get("fresh"))
+	}
+
+	// Without a quota, the access times are dropped
+	unlimited := newQuotaStorage(t, dir, StorageQuota{})
+	if unlimited.Exists(storageAccessKey) {
+		t.Error("Expected the access times to be hidden without a quota")
+	}
+}
+
+func TestStorageQuotaLookup(t *testing.T) {
+	defer SetStorageQuotas(StorageQuota{}, nil)
+
+	SetStorageQuotas(StorageQuota{MaxSize: 1000}, map[string]StorageQuota{
+		"tasmota":       {MaxSize: 5000},
+		"netatmo.haus1": {MaxAge: time.Hour},
+	})
+
+	tests := []struct {
+		name string
+		want StorageQuota
+	}{
+		{"meter", StorageQuota{MaxSize: 1000}},
+		{"tasmota", StorageQuota{MaxSize: 5000}},
+		{"tasmota.keller", StorageQuota{MaxSize: 5000}},
+		{"netatmo.haus1", StorageQuota{MaxAge: time.Hour}},
+		{"netatmo.haus2", StorageQuota{MaxSize: 1000}},
+	}
+	for _, tt := range tests {
+		if got := DefaultStorageConfig(tt.name).Quota; got != tt.want {
+			t.Errorf("Quota of %s = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains the timezone of day boundaries, e.g. of daily totals and
+// collection schedules, so they don't depend on the timezone a container
+// happens to run in.
+package utils
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// timezone is the configured timezone, nil for the system timezone.
+var timezone atomic.Pointer[time.Location]
+
+// SetTimezone sets the IANA timezone (e.g. "Europe/Berlin") day boundaries are
+// computed in. An empty name uses the system timezone.
+func SetTimezone(name string) error {
+	if name == "" {
+		timezone.Store(nil)
+		return nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", name, err)
+	}
+	timezone.Store(location)
+	return nil
+}
+
+// Location returns the timezone day boundaries are computed in: the configured
+// timezone, or the system timezone if none is set.
+func Location() *time.Location {
+	if location := timezone.Load(); location != nil {
+		return location
+	}
+	return time.Local
+}
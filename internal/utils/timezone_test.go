@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetTimezone(t *testing.T) {
+	defer SetTimezone("")
+
+	if Location() != time.Local {
+		t.Errorf("Expected system timezone by default, got %v", Location())
+	}
+	if err := SetTimezone("Mars/Olympus"); err == nil {
+		t.Error("Expected error for unknown timezone")
+	}
+
+	if err := SetTimezone("UTC"); err != nil {
+		t.Fatalf("SetTimezone failed: %v", err)
+	}
+	if Location() != time.UTC {
+		t.Errorf("Expected UTC, got %v", Location())
+	}
+
+	// Schedule windows follow the configured timezone
+	schedule := mustSchedule(t, ScheduleWindow{From: 6 * time.Hour, To: 7 * time.Hour})
+	berlin := time.FixedZone("CEST", 2*60*60)
+	if !schedule.Active(time.Date(2025, 6, 1, 8, 30, 0, 0, berlin)) {
+		t.Error("Expected 08:30 CEST to be within the 06:00-07:00 UTC window")
+	}
+	if schedule.Active(time.Date(2025, 6, 1, 6, 30, 0, 0, berlin)) {
+		t.Error("Expected 06:30 CEST to be outside the 06:00-07:00 UTC window")
+	}
+}
@@ -0,0 +1,147 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains the collection trigger used by interval-based modules.
+// By default modules collect on their own timers. When triggered collection is
+// enabled (e.g. telegraf's inputs.execd sending SIGUSR1 or a newline on stdin),
+// collections happen on external triggers instead, so sampling aligns with
+// telegraf's agent interval.
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	triggerMu      sync.Mutex
+	triggerEnabled bool
+	triggerNextID  int
+	triggerSubs    = make(map[int]triggerSub)
+)
+
+// triggerSub is a ticker created in triggered collection mode.
+type triggerSub struct {
+	ch       chan time.Time
+	schedule *Schedule // triggers outside the schedule are ignored
+}
+
+// CollectionTicker delivers collection ticks on C.
+// Ticks come from an interval timer or, in triggered collection mode,
+// from calls to TriggerCollection.
+type CollectionTicker struct {
+	// C delivers the ticks. Like time.Ticker, ticks are dropped if the receiver is busy.
+	C <-chan time.Time
+
+	ticker *time.Ticker
+	stop   chan struct{}
+	id     int
+}
+
+// SetTriggeredCollection enables or disables triggered collection mode.
+// It only affects tickers created afterwards.
+func SetTriggeredCollection(enabled bool) {
+	triggerMu.Lock()
+	defer triggerMu.Unlock()
+	triggerEnabled = enabled
+}
+
+// IsTriggeredCollection reports whether triggered collection mode is enabled.
+func IsTriggeredCollection() bool {
+	triggerMu.Lock()
+	defer triggerMu.Unlock()
+	return triggerEnabled
+}
+
+// NewCollectionTicker creates a ticker for interval-based collection.
+// In triggered collection mode the interval is ignored and the ticker fires on TriggerCollection.
+func NewCollectionTicker(interval time.Duration) *CollectionTicker {
+	return newCollectionTicker(interval, nil)
+}
+
+// NewScheduledTicker creates a ticker for interval-based collection that only
+// fires within the collection schedule carried by ctx (see WithSchedule). The
+// interval of the active schedule window takes precedence over interval.
+// Without a schedule it behaves like NewCollectionTicker.
+func NewScheduledTicker(ctx context.Context, interval time.Duration) *CollectionTicker {
+	return newCollectionTicker(interval, ScheduleFromContext(ctx))
+}
+
+func newCollectionTicker(interval time.Duration, schedule *Schedule) *CollectionTicker {
+	triggerMu.Lock()
+	defer triggerMu.Unlock()
+
+	if triggerEnabled {
+		ch := make(chan time.Time, 1)
+		triggerNextID++
+		triggerSubs[triggerNextID] = triggerSub{ch: ch, schedule: schedule}
+		return &CollectionTicker{C: ch, id: triggerNextID}
+	}
+
+	if schedule == nil {
+		ticker := time.NewTicker(interval)
+		return &CollectionTicker{C: ticker.C, ticker: ticker}
+	}
+
+	ch := make(chan time.Time, 1)
+	stop := make(chan struct{})
+	go runScheduled(schedule, interval, ch, stop)
+	return &CollectionTicker{C: ch, stop: stop}
+}
+
+// Stop stops the ticker. No more ticks are delivered after Stop returns.
+func (t *CollectionTicker) Stop() {
+	if t.ticker != nil {
+		t.ticker.Stop()
+		return
+	}
+	if t.stop != nil {
+		close(t.stop)
+		return
+	}
+
+	triggerMu.Lock()
+	defer triggerMu.Unlock()
+	delete(triggerSubs, t.id)
+}
+
+// skipInitialContextKey is the context key marking modules that skip their initial collection.
+type skipInitialContextKey struct{}
+
+// WithSkipInitialCollection returns a context telling interval-based modules
+// not to collect right after starting.
+func WithSkipInitialCollection(ctx context.Context, skip bool) context.Context {
+	return context.WithValue(ctx, skipInitialContextKey{}, skip)
+}
+
+// CollectInitially reports whether an interval-based module should collect
+// right after starting: the collection schedule carried by ctx is active and
+// the initial collection is not skipped (see WithSkipInitialCollection).
+func CollectInitially(ctx context.Context) bool {
+	if skip, _ := ctx.Value(skipInitialContextKey{}).(bool); skip {
+		return false
+	}
+	return InSchedule(ctx)
+}
+
+// TriggerCollection fires all tickers created in triggered collection mode.
+// It returns the number of tickers that were notified.
+func TriggerCollection() int {
+	triggerMu.Lock()
+	defer triggerMu.Unlock()
+
+	now := time.Now()
+	notified := 0
+	for _, sub := range triggerSubs {
+		if !sub.schedule.Active(now) {
+			continue
+		}
+		select {
+		case sub.ch <- now:
+			notified++
+		default:
+			// Previous trigger not consumed yet, the collection is still running
+		}
+	}
+	return notified
+}
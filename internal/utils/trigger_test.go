@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCollectionTickerInterval(t *testing.T) {
+	SetTriggeredCollection(false)
+
+	ticker := NewCollectionTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	select {
+	case <-ticker.C:
+	case <-time.After(time.Second):
+		t.Fatal("Interval ticker did not fire")
+	}
+
+	if notified := TriggerCollection(); notified != 0 {
+		t.Errorf("Interval tickers must not be triggered, notified %d", notified)
+	}
+}
+
+func TestCollectionTickerTriggered(t *testing.T) {
+	SetTriggeredCollection(true)
+	defer SetTriggeredCollection(false)
+
+	ticker := NewCollectionTicker(10 * time.Millisecond)
+
+	// The interval is ignored in triggered mode
+	select {
+	case <-ticker.C:
+		t.Fatal("Triggered ticker fired without trigger")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if notified := TriggerCollection(); notified != 1 {
+		t.Errorf("Expected 1 notified ticker, got %d", notified)
+	}
+	// A pending trigger is not queued twice
+	if notified := TriggerCollection(); notified != 0 {
+		t.Errorf("Expected pending trigger to be dropped, notified %d", notified)
+	}
+
+	select {
+	case <-ticker.C:
+	case <-time.After(time.Second):
+		t.Fatal("Triggered ticker did not fire")
+	}
+
+	ticker.Stop()
+	if notified := TriggerCollection(); notified != 0 {
+		t.Errorf("Stopped ticker must not be notified, notified %d", notified)
+	}
+}
+
+func TestCollectInitially(t *testing.T) {
+	ctx := context.Background()
+	if !CollectInitially(ctx) {
+		t.Error("Expected initial collection without schedule")
+	}
+	if CollectInitially(WithSkipInitialCollection(ctx, true)) {
+		t.Error("Expected skipped initial collection")
+	}
+	if !CollectInitially(WithSkipInitialCollection(ctx, false)) {
+		t.Error("Expected initial collection if not skipped")
+	}
+}
@@ -0,0 +1,82 @@
+// Package utils provides common utility functions used across multiple modules.
+//
+// This file contains a file watcher. It polls the file instead of relying on
+// inotify, so it works the same on all platforms and for files replaced by
+// editors or configuration management (write to a temporary file and rename).
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"os"
+	"time"
+)
+
+// watchPollInterval is how often a watched file is checked for changes
+var watchPollInterval = time.Second
+
+// fileState identifies a version of a watched file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// WatchFile calls onChange whenever the content of the file at path changed
+// and then stayed unchanged for the debounce duration, so a file that is still
+// being written is not read half-way. Changes that don't modify the content
+// (e.g. touch) are ignored. It returns when ctx is cancelled.
+func WatchFile(ctx context.Context, path string, debounce time.Duration, onChange func()) {
+	state, _ := statFile(path)
+	hash := hashFile(path)
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	var changed time.Time // when the last unapplied change was seen
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			current, err := statFile(path)
+			if err != nil {
+				// Deleted or being replaced, wait for the new file
+				continue
+			}
+			if current != state {
+				state = current
+				changed = now
+				continue
+			}
+			if changed.IsZero() || now.Sub(changed) < debounce {
+				continue
+			}
+			changed = time.Time{}
+
+			if current := hashFile(path); !bytes.Equal(current, hash) {
+				hash = current
+				onChange()
+			}
+		}
+	}
+}
+
+// statFile returns the modification time and size of a file.
+func statFile(path string) (fileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// hashFile returns the SHA-256 hash of a file's content, or nil if it can't be read.
+func hashFile(path string) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
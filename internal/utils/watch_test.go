@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchFile(t *testing.T) {
+	interval := watchPollInterval
+	watchPollInterval = 5 * time.Millisecond
+	defer func() { watchPollInterval = interval }()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"a": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		WatchFile(ctx, path, 30*time.Millisecond, func() { changes <- struct{}{} })
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// Touching the file without changing the content is ignored
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+		t.Fatal("Expected touching the file not to be reported")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Several writes in quick succession are reported once
+	for _, content := range []string{`{"a": 2}`, `{"a": 23}`, `{"a": 234}`} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the change to be reported")
+	}
+	select {
+	case <-changes:
+		t.Error("Expected the changes to be reported once")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected WatchFile to return when the context is cancelled")
+	}
+}
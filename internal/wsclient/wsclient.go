@@ -0,0 +1,37 @@
+// Package wsclient creates the websocket clients of the modules. The clients
+// of the public pkg/websocket package log, check their destinations and audit
+// their connections like the rest of the agent. The package is kept apart
+// from package utils, so modules without a websocket don't pull in the
+// websocket client.
+package wsclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/internal/utils"
+	"github.com/janhuddel/metrics-agent/pkg/websocket"
+)
+
+// New creates a websocket client for the module carried by the context. It
+// logs with the name of the module as prefix, only connects to allowed
+// destinations (see utils.CheckDestination) and records its connection
+// attempts in the audit log.
+func New(ctx context.Context, config websocket.Config, handler websocket.MessageHandler) (*websocket.Client, error) {
+	client, err := websocket.NewClient(config, handler)
+	if err != nil {
+		return nil, err
+	}
+	client.SetLogger(utils.LoggerFromContext(ctx))
+	client.SetDestinationCheck(utils.CheckDestination)
+	client.SetConnectObserver(audit)
+	return client, nil
+}
+
+// audit records a connection attempt in the audit log under the module
+// carried by the context, if any.
+func audit(ctx context.Context, url string, duration time.Duration, err error) {
+	if module := utils.ModuleFromContext(ctx); module != "" {
+		utils.AuditConnect(module, "websocket", url, duration, err)
+	}
+}
@@ -0,0 +1,36 @@
+// Package connstate provides the state of a client connection. It has no
+// dependencies, so code reporting connection states doesn't pull in the
+// clients that produce them.
+package connstate
+
+import "fmt"
+
+// State represents the current state of a client connection
+type State int
+
+// Connection states
+const (
+	Disconnected State = iota
+	Connecting
+	Connected
+	Reconnecting
+	Failed
+)
+
+// String returns the name of the connection state
+func (s State) String() string {
+	switch s {
+	case Disconnected:
+		return "disconnected"
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	case Reconnecting:
+		return "reconnecting"
+	case Failed:
+		return "failed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
@@ -0,0 +1,57 @@
+// Package duration provides the Duration type used by all duration settings
+// of the agent and its public packages.
+//
+// Durations are written as strings such as "30s" and read from strings or,
+// as encoding/json writes time.Duration, from numbers of nanoseconds.
+package duration
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration that is configured as a string such as "30s" or
+// "10m". Numbers are read as nanoseconds for compatibility with the encoding of
+// time.Duration.
+type Duration time.Duration
+
+// Duration returns d as a time.Duration.
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+// String returns the duration formatted like time.Duration, e.g. "1m30s".
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case string:
+		duration, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", v, err)
+		}
+		*d = Duration(duration)
+	case float64:
+		if v != float64(int64(v)) {
+			return fmt.Errorf("invalid duration %v: nanoseconds must be an integer", v)
+		}
+		*d = Duration(v)
+	default:
+		return fmt.Errorf("invalid duration %s: expected a string such as \"30s\"", data)
+	}
+	return nil
+}
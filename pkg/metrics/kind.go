@@ -0,0 +1,46 @@
+package metrics
+
+// Kind describes how the values of a field evolve over time, so consumers such
+// as the delta processor or the Prometheus and OTLP exports do not have to
+// guess it from field names.
+type Kind string
+
+const (
+	// KindGauge is a value that can go up and down, e.g. power or temperature.
+	// Fields without a kind are gauges.
+	KindGauge Kind = "gauge"
+
+	// KindCounter is a monotonically increasing total that only resets when the
+	// device restarts or is replaced, e.g. an energy meter reading or a byte counter.
+	KindCounter Kind = "counter"
+
+	// KindCumulative is a total accumulated since a periodic reset, e.g. the
+	// energy of the current day.
+	KindCumulative Kind = "cumulative"
+)
+
+// ElectricityKinds are the kinds of the energy fields of the "electricity"
+// measurement shared by the energy modules.
+var ElectricityKinds = map[string]Kind{
+	"sum_power_total":     KindCounter,
+	"sum_power_total_out": KindCounter,
+	"sum_power_today":     KindCumulative,
+	"sum_power_today_out": KindCumulative,
+}
+
+// FieldKind returns the kind of a field: its entry in FieldKinds, otherwise
+// the Kind of the metric, otherwise KindGauge.
+func (m Metric) FieldKind(field string) Kind {
+	if kind, ok := m.FieldKinds[field]; ok {
+		return kind
+	}
+	if m.Kind != "" {
+		return m.Kind
+	}
+	return KindGauge
+}
+
+// IsMonotonic reports whether values of the kind only increase between resets.
+func (k Kind) IsMonotonic() bool {
+	return k == KindCounter || k == KindCumulative
+}
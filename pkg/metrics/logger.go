@@ -0,0 +1,36 @@
+package metrics
+
+import "sync/atomic"
+
+// Logger receives the messages about field values that are skipped while
+// converting the fields of a metric.
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+}
+
+// nopLogger discards the messages until a logger is set
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Warnf(string, ...interface{})  {}
+
+// logger holds the Logger set with SetLogger
+var logger atomic.Pointer[Logger]
+
+// SetLogger sets the logger of the package. Without a logger, skipped field
+// values are not logged.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	logger.Store(&l)
+}
+
+// getLogger returns the logger of the package.
+func getLogger() Logger {
+	if l := logger.Load(); l != nil {
+		return *l
+	}
+	return nopLogger{}
+}
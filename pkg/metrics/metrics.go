@@ -6,15 +6,20 @@
 // - Line Protocol serialization
 // - Field type conversion and validation
 // - Safe metric handling with error recovery
+//
+// The package is public and can be imported by other projects. Its API
+// (Metric, Kind, ToLineProtocol, ToLineProtocolSafe, Validate,
+// ValidateAndConvertFields and SetLogger) is kept backwards compatible. It
+// logs through the Logger set with SetLogger only.
 package metrics
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"sort"
 	"strings"
 	"time"
-
-	"github.com/janhuddel/metrics-agent/internal/utils"
 )
 
 // Metric represents a single metric measurement in InfluxDB Line Protocol format.
@@ -31,12 +36,23 @@ type Metric struct {
 
 	// Fields are key-value pairs containing the actual metric data (e.g., {"value": 42, "temp": 21.5}).
 	// Fields are not indexed and should contain the actual measurement values.
-	// Supported types: int, int32, int64, float32, float64, bool, string.
+	// Supported types: int, int32, int64, float32, float64, bool, string, and
+	// pointers to them for optional values. Other integer types and json.Number
+	// are converted by ToLineProtocolSafe, keeping integers as integers. A nil pointer marks a value that is
+	// not present, as opposed to a reading of zero (see MissingMode).
 	Fields map[string]interface{}
 
 	// Timestamp is the time when the measurement was taken.
 	// If zero, the current time will be used during serialization.
 	Timestamp time.Time
+
+	// Kind is the kind of all fields (gauge, counter or cumulative). It is
+	// optional and not part of the Line Protocol output.
+	Kind Kind
+
+	// FieldKinds sets the kind of individual fields, overriding Kind
+	// (e.g. {"sum_power_total": KindCounter} on a metric that also has gauges).
+	FieldKinds map[string]Kind
 }
 
 // ToLineProtocol converts a Metric to InfluxDB Line Protocol format.
@@ -48,6 +64,7 @@ type Metric struct {
 // - Escapes special characters in names, tags, and fields
 // - Sorts tags and fields alphabetically for consistent output
 // - Converts field values to appropriate Line Protocol types
+// - Writes pointers as their values and omits missing (nil) values
 // - Uses the metric's timestamp or current time if timestamp is zero
 func (m Metric) ToLineProtocol() (string, error) {
 	if m.Name == "" {
@@ -76,8 +93,9 @@ func (m Metric) ToLineProtocol() (string, error) {
 
 	// Write fields in alphabetical order
 	sb.WriteByte(' ')
-	fieldKeys := make([]string, 0, len(m.Fields))
-	for k := range m.Fields {
+	fields := ResolveMissing(m.Fields, MissingOmit)
+	fieldKeys := make([]string, 0, len(fields))
+	for k := range fields {
 		fieldKeys = append(fieldKeys, k)
 	}
 	sort.Strings(fieldKeys)
@@ -88,7 +106,7 @@ func (m Metric) ToLineProtocol() (string, error) {
 		}
 		sb.WriteString(escape(k))
 		sb.WriteByte('=')
-		switch val := m.Fields[k].(type) {
+		switch val := fields[k].(type) {
 		case int, int32, int64:
 			sb.WriteString(fmt.Sprintf("%di", val))
 		case float32, float64:
@@ -119,22 +137,47 @@ func (m Metric) ToLineProtocol() (string, error) {
 // ValidateAndConvertFields validates and converts field values to supported types.
 // It processes all fields in the input map and returns a new map with converted values.
 // Unsupported types are logged as warnings and excluded from the result.
+// Missing values (nil and nil pointers) are excluded without warning. Unsigned
+// values beyond the int64 range are excluded with a warning. Decoding counters
+// with json.Decoder.UseNumber keeps their precision beyond 2^53. NaN and
+// infinite floats, which Line Protocol can't represent, are excluded and counted
+// (see NonFiniteDropped).
 //
 // Supported field types:
 // - Numeric: int, int32, int64, float32, float64
+// - Other integers: int8, int16, uint, uint8, uint16, uint32, uint64 (converted to int64)
+// - JSON numbers: json.Number (int64 if integral, float64 otherwise)
 // - Boolean: bool
 // - String: string
 // - Collections: []interface{}, map[string]interface{} (converted to strings)
-// - Nil values: converted to empty strings
+// - Pointers: converted to the value they point to
 func ValidateAndConvertFields(fields map[string]interface{}) map[string]interface{} {
+	return convertFields(fields, true)
+}
+
+// convertFields converts the field values to supported types. With report
+// set, skipped values are logged and non-finite values counted; without, the
+// conversion has no side effects, e.g. for validating a metric before it is
+// serialized.
+func convertFields(fields map[string]interface{}, report bool) map[string]interface{} {
 	converted := make(map[string]interface{})
 
-	for key, value := range fields {
-		if convertedValue, err := convertToSupportedType(value); err == nil {
-			converted[key] = convertedValue
-		} else {
-			utils.Warnf("Skipping unsupported field type %T for key '%s': %v", value, key, err)
+	for key, value := range ResolveMissing(fields, MissingOmit) {
+		convertedValue, err := convertToSupportedType(value)
+		if err != nil {
+			if report {
+				getLogger().Warnf("Skipping unsupported field type %T for key '%s': %v", value, key, err)
+			}
+			continue
+		}
+		if IsNonFinite(convertedValue) {
+			if report {
+				nonFiniteDropped.Add(1)
+				getLogger().Debugf("Skipping non-finite value %v for key '%s'", convertedValue, key)
+			}
+			continue
 		}
+		converted[key] = convertedValue
 	}
 
 	return converted
@@ -146,6 +189,25 @@ func convertToSupportedType(value interface{}) (interface{}, error) {
 	case int, int32, int64, float32, float64, bool, string:
 		// Already supported types
 		return val, nil
+	case int8:
+		return int64(val), nil
+	case int16:
+		return int64(val), nil
+	case uint8:
+		return int64(val), nil
+	case uint16:
+		return int64(val), nil
+	case uint32:
+		return int64(val), nil
+	case uint:
+		return convertUnsigned(uint64(val))
+	case uint64:
+		return convertUnsigned(val)
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i, nil
+		}
+		return val.Float64()
 	case []interface{}:
 		// Convert slice to string representation
 		if len(val) == 0 {
@@ -177,6 +239,7 @@ func convertToSupportedType(value interface{}) (interface{}, error) {
 		}
 		return strings.Join(pairs, ","), nil
 	case nil:
+		// Missing elements of collections are written as empty strings
 		return "", nil
 	default:
 		// Try to convert to string as last resort
@@ -184,18 +247,30 @@ func convertToSupportedType(value interface{}) (interface{}, error) {
 	}
 }
 
+// convertUnsigned converts an unsigned integer to int64, as Line Protocol
+// integers are signed.
+func convertUnsigned(value uint64) (interface{}, error) {
+	if value > math.MaxInt64 {
+		return nil, fmt.Errorf("value %d exceeds the int64 range", value)
+	}
+	return int64(value), nil
+}
+
 // Validate checks if the metric can be serialized and returns an error if not.
 // It performs the following validations:
 // - Ensures the metric name is not empty
 // - Validates and converts field values to supported types
 // - Ensures at least one valid field exists after conversion
+//
+// It has no side effects: skipped values are neither logged nor counted, as
+// they are when the metric is serialized.
 func (m Metric) Validate() error {
 	if m.Name == "" {
 		return fmt.Errorf("metric name is required")
 	}
 
 	// Validate and convert fields
-	convertedFields := ValidateAndConvertFields(m.Fields)
+	convertedFields := convertFields(m.Fields, false)
 	if len(convertedFields) == 0 {
 		return fmt.Errorf("metric has no valid fields after conversion")
 	}
@@ -207,17 +282,20 @@ func (m Metric) Validate() error {
 // It validates and converts fields before serialization, ensuring that the output is always valid.
 // This is the recommended method for serializing metrics as it handles type conversion gracefully.
 func (m Metric) ToLineProtocolSafe() (string, error) {
-	if err := m.Validate(); err != nil {
-		return "", err
+	if m.Name == "" {
+		return "", fmt.Errorf("metric name is required")
 	}
 
-	// Create a copy with converted fields
+	// Create a copy with converted fields, converting them only once
 	safeMetric := Metric{
 		Name:      m.Name,
 		Tags:      m.Tags,
 		Fields:    ValidateAndConvertFields(m.Fields),
 		Timestamp: m.Timestamp,
 	}
+	if len(safeMetric.Fields) == 0 {
+		return "", fmt.Errorf("metric has no valid fields after conversion")
+	}
 
 	return safeMetric.ToLineProtocol()
 }
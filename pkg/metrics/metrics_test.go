@@ -0,0 +1,248 @@
+package metrics_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// TestToLineProtocol_IntAndTags tests Line Protocol conversion with integer fields and tags.
+func TestToLineProtocol_IntAndTags(t *testing.T) {
+	m := metrics.Metric{
+		Name: "cpu_usage",
+		Tags: map[string]string{
+			"host":   "my host", // contains spaces → must be escaped
+			"vendor": "demo",
+		},
+		Fields: map[string]interface{}{
+			"value": 42,
+		},
+		Timestamp: time.Unix(0, 1234567890),
+	}
+
+	got, err := m.ToLineProtocol()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Check that the result contains the expected components (order may vary)
+	expectedComponents := []string{
+		"cpu_usage",
+		"host=my\\ host",
+		"vendor=demo",
+		"value=42i",
+		"1234567890",
+	}
+
+	for _, component := range expectedComponents {
+		if !containsString(got, component) {
+			t.Errorf("wrong line protocol: missing component '%s' in result: %s", component, got)
+		}
+	}
+}
+
+// TestToLineProtocol_StringField tests Line Protocol conversion with string fields.
+func TestToLineProtocol_StringField(t *testing.T) {
+	m := metrics.Metric{
+		Name:   "demo_metric",
+		Fields: map[string]interface{}{"status": "ok"},
+	}
+
+	got, err := m.ToLineProtocol()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := `demo_metric status="ok"`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// Helper function to check if a string contains a substring.
+func containsString(s, substr string) bool {
+	return len(s) >= len(substr) && (s == substr ||
+		(len(s) > len(substr) &&
+			(s[:len(substr)] == substr ||
+				s[len(s)-len(substr):] == substr ||
+				containsSubstring(s, substr))))
+}
+
+func containsSubstring(s, substr string) bool {
+	for i := 0; i <= len(s)-len(substr); i++ {
+		if s[i:i+len(substr)] == substr {
+			return true
+		}
+	}
+	return false
+}
+
+func ExampleMetric_ToLineProtocol() {
+	m := metrics.Metric{
+		Name:      "electricity",
+		Tags:      map[string]string{"device": "plug1", "vendor": "tasmota"},
+		Fields:    map[string]interface{}{"power": 42, "voltage": 230.5},
+		Timestamp: time.Unix(1634234234, 0),
+	}
+
+	line, err := m.ToLineProtocol()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(line)
+	// Output: electricity,device=plug1,vendor=tasmota power=42i,voltage=230.500000 1634234234000000000
+}
+
+// TestFieldKind tests that field kinds take precedence over the metric kind and default to gauge.
+func TestFieldKind(t *testing.T) {
+	m := metrics.Metric{
+		Name:       "electricity",
+		Fields:     map[string]interface{}{"power": 100, "sum_power_total": 1.5},
+		FieldKinds: metrics.ElectricityKinds,
+	}
+	if got := m.FieldKind("power"); got != metrics.KindGauge {
+		t.Errorf("expected gauge for power, got %s", got)
+	}
+	if got := m.FieldKind("sum_power_total"); got != metrics.KindCounter {
+		t.Errorf("expected counter for sum_power_total, got %s", got)
+	}
+
+	m.Kind = metrics.KindCumulative
+	if got := m.FieldKind("power"); got != metrics.KindCumulative {
+		t.Errorf("expected metric kind for power, got %s", got)
+	}
+	if got := m.FieldKind("sum_power_total"); got != metrics.KindCounter {
+		t.Errorf("expected field kind to take precedence, got %s", got)
+	}
+	if metrics.KindGauge.IsMonotonic() || !metrics.KindCumulative.IsMonotonic() {
+		t.Error("unexpected IsMonotonic result")
+	}
+}
+
+// TestMissingValues tests that nil pointers are treated as missing values while zeros are written.
+func TestMissingValues(t *testing.T) {
+	zero := 0.0
+	m := metrics.Metric{
+		Name:      "climate",
+		Fields:    map[string]interface{}{"temperature": &zero, "humidity": (*int)(nil), "note": nil},
+		Timestamp: time.Unix(0, 1),
+	}
+	line, err := m.ToLineProtocol()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "climate temperature=0.000000 1"; line != expected {
+		t.Errorf("expected %q, got %q", expected, line)
+	}
+	if line, err := m.ToLineProtocolSafe(); err != nil || line != "climate temperature=0.000000 1" {
+		t.Errorf("expected missing values omitted by safe serialization, got %q, %v", line, err)
+	}
+
+	resolved := metrics.ResolveMissing(m.Fields, metrics.MissingZero)
+	if len(resolved) != 2 || resolved["temperature"] != 0.0 || resolved["humidity"] != 0 {
+		t.Errorf("expected missing humidity as zero, got %v", resolved)
+	}
+	if !metrics.IsMissing((*string)(nil)) || metrics.IsMissing(&zero) || metrics.IsMissing(0) {
+		t.Error("unexpected IsMissing result")
+	}
+
+	// A metric with only missing values is invalid
+	if err := (metrics.Metric{Name: "climate", Fields: map[string]interface{}{"humidity": (*int)(nil)}}).Validate(); err == nil {
+		t.Error("expected error for metric without values")
+	}
+}
+
+// TestNonFiniteValues tests that NaN and infinite values are never serialized.
+func TestNonFiniteValues(t *testing.T) {
+	m := metrics.Metric{
+		Name:      "power",
+		Fields:    map[string]interface{}{"value": math.NaN(), "limit": float32(math.Inf(-1)), "voltage": 230.0},
+		Timestamp: time.Unix(0, 1),
+	}
+	before := metrics.NonFiniteDropped()
+	if line, err := m.ToLineProtocolSafe(); err != nil || line != "power voltage=230.000000 1" {
+		t.Errorf("expected non-finite values skipped by safe serialization, got %q, %v", line, err)
+	}
+	if dropped := metrics.NonFiniteDropped() - before; dropped != 2 {
+		t.Errorf("expected 2 dropped values counted, got %d", dropped)
+	}
+
+	fields, dropped := metrics.DropNonFinite(m.Fields, metrics.NonFiniteDropField)
+	if dropped != 2 || len(fields) != 1 || fields["voltage"] != 230.0 {
+		t.Errorf("expected only voltage kept, got %v (%d dropped)", fields, dropped)
+	}
+	if fields, _ := metrics.DropNonFinite(m.Fields, metrics.NonFiniteDropMetric); len(fields) != 0 {
+		t.Errorf("expected no fields with drop_metric, got %v", fields)
+	}
+	finite := map[string]interface{}{"voltage": 230.0, "state": "on"}
+	if fields, dropped := metrics.DropNonFinite(finite, metrics.NonFiniteDropMetric); dropped != 0 || len(fields) != 2 {
+		t.Errorf("expected finite fields unchanged, got %v", fields)
+	}
+}
+
+// TestNonFiniteCountedOnce tests that validating a metric before it is
+// serialized doesn't count its NaN value a second time.
+func TestNonFiniteCountedOnce(t *testing.T) {
+	m := metrics.Metric{
+		Name:      "power",
+		Fields:    map[string]interface{}{"value": math.NaN(), "voltage": 230.0},
+		Timestamp: time.Unix(0, 1),
+	}
+	before := metrics.NonFiniteDropped()
+	if err := m.Validate(); err != nil {
+		t.Fatalf("expected metric with a finite field to be valid, got %v", err)
+	}
+	if _, err := m.ToLineProtocolSafe(); err != nil {
+		t.Fatalf("failed to serialize metric: %v", err)
+	}
+	if dropped := metrics.NonFiniteDropped() - before; dropped != 1 {
+		t.Errorf("expected the NaN value counted once, got %d", dropped)
+	}
+}
+
+// TestIntegerTypes tests that integers of all types and JSON numbers are written as integers.
+func TestIntegerTypes(t *testing.T) {
+	m := metrics.Metric{
+		Name: "counter",
+		Fields: map[string]interface{}{
+			"bytes":  uint64(9007199254740993),
+			"count":  uint32(7),
+			"energy": json.Number("9007199254740993"),
+			"power":  json.Number("12.5"),
+			"small":  int8(-3),
+		},
+		Timestamp: time.Unix(0, 1),
+	}
+	line, err := m.ToLineProtocolSafe()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "counter bytes=9007199254740993i,count=7i,energy=9007199254740993i,power=12.500000,small=-3i 1"; line != expected {
+		t.Errorf("expected %q, got %q", expected, line)
+	}
+
+	// Unsigned values beyond the int64 range can't be written
+	if fields := metrics.ValidateAndConvertFields(map[string]interface{}{"huge": uint64(1 << 63)}); len(fields) != 0 {
+		t.Errorf("expected value beyond int64 range skipped, got %v", fields)
+	}
+}
+
+// BenchmarkToLineProtocolSafe measures the serialization of a typical metric.
+// "make bench-check" compares the results with a stored baseline.
+func BenchmarkToLineProtocolSafe(b *testing.B) {
+	m := metrics.Metric{
+		Name:      "electricity",
+		Tags:      map[string]string{"device": "tasmota_A1B2C3", "friendly": "Washing Machine", "vendor": "tasmota"},
+		Fields:    map[string]interface{}{"power": 42.5, "sum_power_total": 1234.567, "voltage": 230.1, "current": 0.185},
+		Timestamp: time.Unix(1760000000, 0),
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.ToLineProtocolSafe(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
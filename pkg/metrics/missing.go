@@ -0,0 +1,74 @@
+package metrics
+
+import "reflect"
+
+// MissingMode decides how fields without a value are written.
+//
+// A module distinguishes a value that is not present (the sensor is absent or
+// did not report) from a reading of zero by putting a nil pointer of the
+// field's type into Fields, e.g. (*float64)(nil). Optional values of an API
+// response can be put into Fields as pointers directly. Non-nil pointers are
+// written as their value, so 0 always means a reading of zero.
+type MissingMode string
+
+const (
+	// MissingOmit leaves missing fields out, so queries see no value instead
+	// of zero. This is the default.
+	MissingOmit MissingMode = "omit"
+
+	// MissingZero writes missing fields as the zero value of their type, for
+	// consumers that expect every field in every metric.
+	MissingZero MissingMode = "zero"
+)
+
+// IsValid reports whether the mode is known.
+func (mode MissingMode) IsValid() bool {
+	return mode == MissingOmit || mode == MissingZero
+}
+
+// IsMissing reports whether a field value is not present: nil or a nil pointer.
+func IsMissing(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
+
+// ResolveMissing returns the fields with pointers replaced by their values and
+// missing values handled according to mode; unknown modes omit them. Untyped
+// nil values are always omitted, as their type is unknown. The fields are
+// returned as they are if they contain no pointers or nil values.
+func ResolveMissing(fields map[string]interface{}, mode MissingMode) map[string]interface{} {
+	if !hasOptional(fields) {
+		return fields
+	}
+
+	resolved := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		if value == nil {
+			continue
+		}
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.Pointer {
+			resolved[key] = value
+			continue
+		}
+		if !v.IsNil() {
+			resolved[key] = v.Elem().Interface()
+		} else if mode == MissingZero {
+			resolved[key] = reflect.Zero(v.Type().Elem()).Interface()
+		}
+	}
+	return resolved
+}
+
+// hasOptional reports whether any field value is nil or a pointer.
+func hasOptional(fields map[string]interface{}) bool {
+	for _, value := range fields {
+		if value == nil || reflect.ValueOf(value).Kind() == reflect.Pointer {
+			return true
+		}
+	}
+	return false
+}
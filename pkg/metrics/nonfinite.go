@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// NonFiniteMode decides how float fields that are NaN or infinite are handled.
+//
+// Line Protocol can't represent NaN and infinity, so consumers like telegraf
+// reject the whole batch containing such a value. A buggy device reporting one
+// would otherwise cost the metrics of all other devices.
+type NonFiniteMode string
+
+const (
+	// NonFiniteDropField drops the NaN or infinite fields and keeps the other
+	// fields of the metric. This is the default.
+	NonFiniteDropField NonFiniteMode = "drop_field"
+
+	// NonFiniteDropMetric drops the whole metric if one of its fields is NaN or
+	// infinite, for fields that are only meaningful together.
+	NonFiniteDropMetric NonFiniteMode = "drop_metric"
+)
+
+// nonFiniteDropped counts the NaN and infinite values dropped since the start.
+var nonFiniteDropped atomic.Int64
+
+// IsValid reports whether the mode is known.
+func (mode NonFiniteMode) IsValid() bool {
+	return mode == NonFiniteDropField || mode == NonFiniteDropMetric
+}
+
+// IsNonFinite reports whether a field value is a NaN or infinite float.
+func IsNonFinite(value interface{}) bool {
+	switch v := value.(type) {
+	case float64:
+		return math.IsNaN(v) || math.IsInf(v, 0)
+	case float32:
+		return math.IsNaN(float64(v)) || math.IsInf(float64(v), 0)
+	}
+	return false
+}
+
+// DropNonFinite returns the fields without NaN and infinite values handled
+// according to mode, and the number of such values; unknown modes drop only
+// the fields. With NonFiniteDropMetric no fields are returned if any value is
+// NaN or infinite. The fields are returned as they are if all values are finite.
+// Dropped values are counted (see NonFiniteDropped).
+func DropNonFinite(fields map[string]interface{}, mode NonFiniteMode) (map[string]interface{}, int) {
+	count := 0
+	for _, value := range fields {
+		if IsNonFinite(value) {
+			count++
+		}
+	}
+	if count == 0 {
+		return fields, 0
+	}
+	nonFiniteDropped.Add(int64(count))
+	if mode == NonFiniteDropMetric {
+		return nil, count
+	}
+
+	finite := make(map[string]interface{}, len(fields)-count)
+	for key, value := range fields {
+		if !IsNonFinite(value) {
+			finite[key] = value
+		}
+	}
+	return finite, count
+}
+
+// NonFiniteDropped returns the number of NaN and infinite values dropped by
+// DropNonFinite and ValidateAndConvertFields since the start.
+func NonFiniteDropped() int64 {
+	return nonFiniteDropped.Load()
+}
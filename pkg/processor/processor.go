@@ -0,0 +1,109 @@
+// Package processor lets custom processors be compiled into the metric
+// pipeline of the agent, e.g. for site-specific business logic, without
+// changing the pipeline code.
+//
+// A processor registers a factory under a name in an init function:
+//
+//	func init() {
+//		processor.MustRegister("site_cost", func(config json.RawMessage) (processor.Processor, error) {
+//			var cfg costConfig
+//			if err := json.Unmarshal(config, &cfg); err != nil {
+//				return nil, err
+//			}
+//			return &costProcessor{price: cfg.Price}, nil
+//		})
+//	}
+//
+// The package containing it is compiled in with a blank import in the agent's
+// main package, and the processor is enabled by listing its name in the
+// "custom" section of the pipeline configuration.
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+)
+
+// Processor processes the metrics passing the pipeline. It may be called from
+// several goroutines at once, so it must be safe for concurrent use.
+type Processor interface {
+	// Process returns the metrics replacing m: m itself (modified or not),
+	// several metrics, e.g. m and a metric derived from it, or none to drop m.
+	// If an error is returned, m is passed on unchanged.
+	//
+	// The maps of m may be shared with the module that sent it, so they must
+	// be copied before being modified.
+	Process(m metrics.Metric) ([]metrics.Metric, error)
+}
+
+// Func is a function used as Processor.
+type Func func(m metrics.Metric) ([]metrics.Metric, error)
+
+// Process calls the function.
+func (fn Func) Process(m metrics.Metric) ([]metrics.Metric, error) {
+	return fn(m)
+}
+
+// Factory creates a processor from its configuration, the JSON object of the
+// "config" setting of the processor (null if it is not set).
+type Factory func(config json.RawMessage) (Processor, error)
+
+// ErrDuplicate is returned when a processor name is registered twice.
+var ErrDuplicate = errors.New("already registered")
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register adds a processor factory under the given name.
+// Returns an error if the name is empty or already registered.
+func Register(name string, factory Factory) error {
+	if name == "" {
+		return fmt.Errorf("processor name is required")
+	}
+	if factory == nil {
+		return fmt.Errorf("factory of processor %s is nil", name)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, exists := factories[name]; exists {
+		return fmt.Errorf("processor %s: %w", name, ErrDuplicate)
+	}
+	factories[name] = factory
+	return nil
+}
+
+// MustRegister is like Register, but panics on error. It is meant to be
+// called from init functions.
+func MustRegister(name string, factory Factory) {
+	if err := Register(name, factory); err != nil {
+		panic(err)
+	}
+}
+
+// Lookup returns the factory registered under the given name.
+func Lookup(name string) (Factory, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	factory, ok := factories[name]
+	return factory, ok
+}
+
+// Names returns the sorted names of all registered processors.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
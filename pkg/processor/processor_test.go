@@ -0,0 +1,52 @@
+package processor_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/janhuddel/metrics-agent/pkg/metrics"
+	"github.com/janhuddel/metrics-agent/pkg/processor"
+)
+
+func passThrough(json.RawMessage) (processor.Processor, error) {
+	return processor.Func(func(m metrics.Metric) ([]metrics.Metric, error) {
+		return []metrics.Metric{m}, nil
+	}), nil
+}
+
+func TestRegister(t *testing.T) {
+	if err := processor.Register("test_pass_through", passThrough); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if err := processor.Register("test_pass_through", passThrough); !errors.Is(err, processor.ErrDuplicate) {
+		t.Errorf("Expected ErrDuplicate, got %v", err)
+	}
+	if err := processor.Register("", passThrough); err == nil {
+		t.Error("Expected error for empty name")
+	}
+	if err := processor.Register("test_nil", nil); err == nil {
+		t.Error("Expected error for nil factory")
+	}
+
+	factory, ok := processor.Lookup("test_pass_through")
+	if !ok {
+		t.Fatal("Expected registered processor to be found")
+	}
+	p, err := factory(nil)
+	if err != nil {
+		t.Fatalf("Factory failed: %v", err)
+	}
+	processed, err := p.Process(metrics.Metric{Name: "power"})
+	if err != nil || len(processed) != 1 || processed[0].Name != "power" {
+		t.Errorf("Unexpected result %v, %v", processed, err)
+	}
+
+	if _, ok := processor.Lookup("test_missing"); ok {
+		t.Error("Expected unregistered processor not to be found")
+	}
+	names := processor.Names()
+	if len(names) == 0 || names[len(names)-1] != "test_pass_through" {
+		t.Errorf("Expected test_pass_through in names, got %v", names)
+	}
+}
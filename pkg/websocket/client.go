@@ -0,0 +1,657 @@
+// Package websocket provides a websocket client with automatic reconnection.
+//
+// The client reconnects with exponential backoff after connection losses,
+// gives up after a configurable number of attempts or on unrecoverable errors,
+// and passes every received message to a MessageHandler. A ConnectHandler can
+// be set to run protocol handshakes (e.g. subscriptions) after each connect,
+// and a StateChangeHandler to get notified of connection state transitions.
+// Stats returns counters of received messages, bytes, handler errors and
+// reconnects together with the connection state and the last error, e.g. to
+// publish them as self-metrics or in the agent status. SetDialer replaces the
+// network connection, e.g. with a fake Conn in tests.
+//
+// The client doesn't depend on the application using it: SetLogger,
+// SetDestinationCheck and SetConnectObserver connect it to the logging and
+// network policy of the application. Without a logger, nothing is logged.
+//
+// The package is public and can be imported by other projects. Its API
+// (Config, Client, NewClient, Stats, Conn, Dialer, Logger and the handler and
+// hook types) is kept backwards compatible.
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/pkg/connstate"
+	"github.com/janhuddel/metrics-agent/pkg/duration"
+	"golang.org/x/net/websocket"
+)
+
+// ConnectionState represents the current state of the websocket connection
+type ConnectionState = connstate.State
+
+// Connection states reported by Client.GetState
+const (
+	StateDisconnected = connstate.Disconnected
+	StateConnecting   = connstate.Connecting
+	StateConnected    = connstate.Connected
+	StateReconnecting = connstate.Reconnecting
+	StateFailed       = connstate.Failed
+)
+
+// Config represents the configuration for the websocket client. Durations
+// are written as strings such as "30s".
+type Config struct {
+	URL                  string            `json:"url"`
+	ReconnectInterval    duration.Duration `json:"reconnect_interval,omitempty"`
+	MaxReconnectAttempts int               `json:"max_reconnect_attempts,omitempty"`
+	ConnectionTimeout    duration.Duration `json:"connection_timeout,omitempty"`
+	ReadTimeout          duration.Duration `json:"read_timeout,omitempty"`
+	WriteTimeout         duration.Duration `json:"write_timeout,omitempty"`
+	MaxBackoffInterval   duration.Duration `json:"max_backoff_interval,omitempty"`
+	BackoffMultiplier    float64           `json:"backoff_multiplier,omitempty"`
+	Origin               string            `json:"origin,omitempty"`
+	Protocol             string            `json:"protocol,omitempty"`
+	Headers              map[string]string `json:"headers,omitempty"`
+}
+
+// MessageHandler is a function that processes incoming websocket messages
+type MessageHandler func(message []byte) error
+
+// ConnectHandler is called after every successful (re)connection, before messages
+// are read. It can be used to perform protocol handshakes such as subscriptions.
+type ConnectHandler func(c *Client) error
+
+// StateChangeHandler is called whenever the connection state changes. It runs
+// synchronously in the client's goroutine and should return quickly.
+type StateChangeHandler func(oldState, newState ConnectionState)
+
+// Logger receives the log messages of a client.
+type Logger interface {
+	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// nopLogger discards the log messages of a client without logger
+type nopLogger struct{}
+
+func (nopLogger) Infof(string, ...interface{})  {}
+func (nopLogger) Warnf(string, ...interface{})  {}
+func (nopLogger) Errorf(string, ...interface{}) {}
+
+// DestinationCheck is called with the URL of the client before every
+// connection attempt, e.g. to enforce an allowlist of destinations. An error
+// rejects the attempt.
+type DestinationCheck func(ctx context.Context, url string) error
+
+// ConnectObserver is called after every connection attempt with its duration
+// and result, e.g. to record it in an audit log. The context is the one the
+// client runs with.
+type ConnectObserver func(ctx context.Context, url string, duration time.Duration, err error)
+
+// Conn is a websocket connection of a client. Receive blocks until a message
+// arrives, the read deadline passes or the connection is closed.
+type Conn interface {
+	Receive() ([]byte, error)
+	Send(message []byte) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+// Dialer opens a connection to the URL of the client configuration. It should
+// give up when the context is done.
+type Dialer func(ctx context.Context, config Config) (Conn, error)
+
+// Stats is a snapshot of the state of a client and its counters since it
+// was created.
+type Stats struct {
+	MessagesReceived  uint64          // Messages read from the connection
+	BytesReceived     uint64          // Payload bytes of the messages read
+	HandlerErrors     uint64          // Messages the MessageHandler returned an error for
+	Reconnects        uint64          // Connections established after the first one
+	State             ConnectionState // Current connection state
+	ReconnectAttempts int             // Connection attempts since the last successful one
+	LastError         error           // Last connection error, nil while connected
+}
+
+// Client represents a robust websocket client with automatic reconnection
+type Client struct {
+	config        Config
+	handler       MessageHandler
+	onConnect     ConnectHandler
+	onStateChange StateChangeHandler
+	logger        Logger
+	checkDest     DestinationCheck
+	onConnected   ConnectObserver
+	dialer        Dialer
+	conn          Conn
+
+	// Guarded by mu, written only by the goroutine running the client
+	mu                sync.RWMutex
+	state             ConnectionState
+	reconnectAttempts int
+	lastError         error
+
+	messagesReceived atomic.Uint64
+	bytesReceived    atomic.Uint64
+	handlerErrors    atomic.Uint64
+	connects         atomic.Uint64
+}
+
+// NewClient creates a new websocket client with the given configuration and message handler
+func NewClient(config Config, handler MessageHandler) (*Client, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("websocket URL is required")
+	}
+	if handler == nil {
+		return nil, fmt.Errorf("message handler is required")
+	}
+
+	// Set default values
+	if config.ReconnectInterval == 0 {
+		config.ReconnectInterval = duration.Duration(5 * time.Second)
+	}
+	if config.MaxReconnectAttempts == 0 {
+		config.MaxReconnectAttempts = 10
+	}
+	if config.ConnectionTimeout == 0 {
+		config.ConnectionTimeout = duration.Duration(10 * time.Second)
+	}
+	if config.ReadTimeout == 0 {
+		config.ReadTimeout = duration.Duration(30 * time.Second)
+	}
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = duration.Duration(10 * time.Second)
+	}
+	if config.MaxBackoffInterval == 0 {
+		config.MaxBackoffInterval = duration.Duration(5 * time.Minute)
+	}
+	if config.BackoffMultiplier == 0 {
+		config.BackoffMultiplier = 2.0
+	}
+	if config.Origin == "" {
+		config.Origin = "http://localhost"
+	}
+
+	return &Client{
+		config:  config,
+		handler: handler,
+		logger:  nopLogger{},
+		dialer:  dial,
+		state:   StateDisconnected,
+	}, nil
+}
+
+// Run starts the websocket client with robust reconnection handling. A panic
+// of a handler is returned as error.
+func (c *Client) Run(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger.Errorf("WebSocket client panic recovered: %v", r)
+			err = fmt.Errorf("panic in WebSocket client: %v", r)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.setState(StateDisconnected)
+			return ctx.Err()
+		default:
+			// Attempt to connect
+			if err := c.connect(ctx); err != nil {
+				if c.isUnrecoverableError(err) {
+					c.setState(StateFailed)
+					return fmt.Errorf("unrecoverable connection error: %w", err)
+				}
+
+				// Wait before retrying
+				if err := c.waitForReconnect(ctx); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// Connected successfully, start message processing
+			if err := c.processMessages(ctx); err != nil {
+				c.closeConnection()
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+
+				if c.isUnrecoverableError(err) {
+					c.setState(StateFailed)
+					return fmt.Errorf("unrecoverable processing error: %w", err)
+				}
+
+				// Wait before retrying
+				if err := c.waitForReconnect(ctx); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// Probe connects once without reconnecting, runs the connect handler and
+// passes received messages to accept until it reports true, e.g. to verify
+// the handshake of a subscription protocol. With a nil accept function it
+// returns right after connecting. The connection is closed afterwards.
+func (c *Client) Probe(ctx context.Context, accept func(message []byte) (bool, error)) error {
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+	defer c.closeConnection()
+	conn := c.conn
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	if c.onConnect != nil {
+		if err := c.onConnect(c); err != nil {
+			return fmt.Errorf("connect handler failed: %w", err)
+		}
+	}
+	if accept == nil {
+		return nil
+	}
+
+	deadline := time.Now().Add(c.config.ReadTimeout.Duration())
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := c.conn.SetReadDeadline(deadline); err != nil {
+		return fmt.Errorf("failed to set read deadline: %w", err)
+	}
+	for {
+		message, err := c.conn.Receive()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to receive websocket message: %w", err)
+		}
+		if ok, err := accept(message); err != nil || ok {
+			return err
+		}
+	}
+}
+
+// SetConnectHandler sets a handler that is invoked after each successful connection
+func (c *Client) SetConnectHandler(handler ConnectHandler) {
+	c.onConnect = handler
+}
+
+// SetDialer replaces the function that opens the connections of the client,
+// e.g. to inject a fake connection in tests. It must be called before Run.
+func (c *Client) SetDialer(dialer Dialer) {
+	c.dialer = dialer
+}
+
+// SetStateChangeHandler sets a handler that is invoked on every connection state change
+func (c *Client) SetStateChangeHandler(handler StateChangeHandler) {
+	c.onStateChange = handler
+}
+
+// SetLogger sets the logger receiving the log messages of the client. It must
+// be called before Run.
+func (c *Client) SetLogger(logger Logger) {
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	c.logger = logger
+}
+
+// SetDestinationCheck sets a check run before every connection attempt. It
+// must be called before Run.
+func (c *Client) SetDestinationCheck(check DestinationCheck) {
+	c.checkDest = check
+}
+
+// SetConnectObserver sets an observer called after every connection attempt.
+// It must be called before Run.
+func (c *Client) SetConnectObserver(observer ConnectObserver) {
+	c.onConnected = observer
+}
+
+// Send writes a text message to the current connection using the configured write timeout
+func (c *Client) Send(message []byte) error {
+	if c.conn == nil {
+		return fmt.Errorf("websocket is not connected")
+	}
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout.Duration())); err != nil {
+		return fmt.Errorf("failed to set write deadline: %w", err)
+	}
+	if err := c.conn.Send(message); err != nil {
+		return fmt.Errorf("failed to send websocket message: %w", err)
+	}
+	return nil
+}
+
+// GetState returns the current connection state
+func (c *Client) GetState() ConnectionState {
+	return c.Stats().State
+}
+
+// GetReconnectAttempts returns the number of reconnection attempts made
+func (c *Client) GetReconnectAttempts() int {
+	return c.Stats().ReconnectAttempts
+}
+
+// GetLastError returns the last error encountered
+func (c *Client) GetLastError() error {
+	return c.Stats().LastError
+}
+
+// Stats returns a snapshot of the client's state and counters. It is safe to
+// call while the client runs; use it instead of several getters to get
+// consistent values.
+func (c *Client) Stats() Stats {
+	c.mu.RLock()
+	stats := Stats{
+		State:             c.state,
+		ReconnectAttempts: c.reconnectAttempts,
+		LastError:         c.lastError,
+	}
+	c.mu.RUnlock()
+
+	stats.MessagesReceived = c.messagesReceived.Load()
+	stats.BytesReceived = c.bytesReceived.Load()
+	stats.HandlerErrors = c.handlerErrors.Load()
+	if connects := c.connects.Load(); connects > 1 {
+		stats.Reconnects = connects - 1
+	}
+	return stats
+}
+
+// connect establishes a websocket connection with timeout
+func (c *Client) connect(ctx context.Context) error {
+	c.setState(StateConnecting)
+	c.mu.Lock()
+	c.reconnectAttempts++
+	c.mu.Unlock()
+
+	c.logger.Infof("Attempting to connect to websocket (attempt %d/%d): %s",
+		c.reconnectAttempts, c.config.MaxReconnectAttempts, c.config.URL)
+
+	start := time.Now()
+	if c.checkDest != nil {
+		if err := c.checkDest(ctx, c.config.URL); err != nil {
+			c.setLastError(err)
+			c.observeConnect(ctx, start, err)
+			return err
+		}
+	}
+
+	// Create a context with timeout for the connection
+	connCtx, cancel := context.WithTimeout(ctx, c.config.ConnectionTimeout.Duration())
+	defer cancel()
+
+	// Use a channel to handle the connection attempt
+	connChan := make(chan Conn, 1)
+	errChan := make(chan error, 1)
+
+	go func() {
+		conn, err := c.dialer(connCtx, c.config)
+		if err != nil {
+			errChan <- err
+			return
+		}
+		connChan <- conn
+	}()
+
+	select {
+	case <-connCtx.Done():
+		err := fmt.Errorf("connection timeout after %v", c.config.ConnectionTimeout.Duration())
+		c.observeConnect(ctx, start, err)
+		// Close a connection the dialer establishes after the timeout
+		go func() {
+			if conn := <-connChan; conn != nil {
+				conn.Close()
+			}
+		}()
+		return err
+	case err := <-errChan:
+		c.setLastError(err)
+		c.observeConnect(ctx, start, err)
+		return fmt.Errorf("failed to connect to websocket: %w", err)
+	case conn := <-connChan:
+		c.observeConnect(ctx, start, nil)
+		c.conn = conn
+		c.connects.Add(1)
+		c.mu.Lock()
+		c.reconnectAttempts = 0 // Reset on successful connection
+		c.lastError = nil
+		c.mu.Unlock()
+		c.setState(StateConnected)
+		c.logger.Infof("Successfully connected to websocket")
+		return nil
+	}
+}
+
+// dial opens a connection with golang.org/x/net/websocket
+func dial(ctx context.Context, config Config) (Conn, error) {
+	wsConfig, err := websocket.NewConfig(config.URL, config.Origin)
+	if err != nil {
+		return nil, err
+	}
+	if config.Protocol != "" {
+		wsConfig.Protocol = []string{config.Protocol}
+	}
+	for key, value := range config.Headers {
+		wsConfig.Header.Set(key, value)
+	}
+	conn, err := wsConfig.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return netConn{conn}, nil
+}
+
+// netConn is a Conn of golang.org/x/net/websocket
+type netConn struct {
+	*websocket.Conn
+}
+
+// Receive reads the next message
+func (c netConn) Receive() ([]byte, error) {
+	var message []byte
+	err := websocket.Message.Receive(c.Conn, &message)
+	return message, err
+}
+
+// Send writes a text message
+func (c netConn) Send(message []byte) error {
+	return websocket.Message.Send(c.Conn, string(message))
+}
+
+// observeConnect passes the result of a connection attempt to the connect observer, if any
+func (c *Client) observeConnect(ctx context.Context, start time.Time, err error) {
+	if c.onConnected != nil {
+		c.onConnected(ctx, c.config.URL, time.Since(start), err)
+	}
+}
+
+// processMessages handles incoming websocket messages
+func (c *Client) processMessages(ctx context.Context) error {
+	// Run the connect handler (e.g. protocol handshake) before reading
+	if c.onConnect != nil {
+		if err := c.onConnect(c); err != nil {
+			c.setLastError(err)
+			return fmt.Errorf("connect handler failed: %w", err)
+		}
+	}
+
+	// Close the connection on shutdown from a watcher goroutine, so a blocking
+	// Receive returns right away instead of after the read timeout
+	conn := c.conn
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	// Set read timeout on the connection
+	if err := c.conn.SetReadDeadline(time.Now().Add(c.config.ReadTimeout.Duration())); err != nil {
+		return fmt.Errorf("failed to set read deadline: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// Read message from websocket
+			message, err := c.conn.Receive()
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				c.setLastError(err)
+				return fmt.Errorf("failed to receive websocket message: %w", err)
+			}
+
+			c.messagesReceived.Add(1)
+			c.bytesReceived.Add(uint64(len(message)))
+
+			// Update read deadline for next message
+			if err := c.conn.SetReadDeadline(time.Now().Add(c.config.ReadTimeout.Duration())); err != nil {
+				c.logger.Warnf("Failed to update read deadline: %v", err)
+			}
+
+			// Process the message using the handler
+			if err := c.handler(message); err != nil {
+				c.handlerErrors.Add(1)
+				c.logger.Errorf("Failed to process websocket message: %v", err)
+				// Continue processing other messages even if one fails
+				continue
+			}
+		}
+	}
+}
+
+// closeConnection safely closes the websocket connection
+func (c *Client) closeConnection() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	c.setState(StateDisconnected)
+}
+
+// waitForReconnect implements exponential backoff for reconnection attempts
+func (c *Client) waitForReconnect(ctx context.Context) error {
+	if c.reconnectAttempts >= c.config.MaxReconnectAttempts {
+		c.setState(StateFailed)
+		return fmt.Errorf("max reconnection attempts (%d) exceeded", c.config.MaxReconnectAttempts)
+	}
+
+	c.setState(StateReconnecting)
+
+	// Calculate backoff delay with exponential backoff
+	baseDelay := float64(c.config.ReconnectInterval.Duration())
+	backoffDelay := baseDelay * math.Pow(c.config.BackoffMultiplier, float64(c.reconnectAttempts-1))
+
+	// Cap the delay at max backoff interval
+	if backoffDelay > float64(c.config.MaxBackoffInterval.Duration()) {
+		backoffDelay = float64(c.config.MaxBackoffInterval.Duration())
+	}
+
+	delay := time.Duration(backoffDelay)
+	c.logger.Infof("Waiting %v before reconnection attempt %d/%d (last error: %v)",
+		delay, c.reconnectAttempts, c.config.MaxReconnectAttempts, c.lastError)
+
+	// Wait with context cancellation support
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// isUnrecoverableError determines if an error is unrecoverable and should cause client exit
+func (c *Client) isUnrecoverableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// Check for context cancellation
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return true
+	}
+
+	// Check for network errors that might be recoverable
+	if netErr, ok := err.(net.Error); ok {
+		// Temporary network errors are usually recoverable
+		if netErr.Temporary() {
+			return false
+		}
+		// Timeout errors might be recoverable
+		if netErr.Timeout() {
+			return false
+		}
+	}
+
+	// Check for specific websocket errors
+	if err.Error() == "EOF" {
+		// EOF usually means connection was closed, which is recoverable
+		return false
+	}
+
+	// Check for authentication/authorization errors (usually unrecoverable)
+	errorStr := err.Error()
+	if containsAny(errorStr, []string{"401", "403", "unauthorized", "forbidden"}) {
+		return true
+	}
+
+	// Check for malformed URL errors (unrecoverable)
+	if containsAny(errorStr, []string{"invalid URL", "malformed", "parse"}) {
+		return true
+	}
+
+	// Most other errors are considered recoverable
+	return false
+}
+
+// setState safely updates the connection state and notifies the state change handler
+func (c *Client) setState(state ConnectionState) {
+	c.mu.Lock()
+	oldState := c.state
+	c.state = state
+	c.mu.Unlock()
+
+	if oldState != state && c.onStateChange != nil {
+		c.onStateChange(oldState, state)
+	}
+}
+
+// setLastError records the last connection error
+func (c *Client) setLastError(err error) {
+	c.mu.Lock()
+	c.lastError = err
+	c.mu.Unlock()
+}
+
+// containsAny checks if a string contains any of the given substrings
+func containsAny(s string, substrings []string) bool {
+	if len(substrings) == 0 {
+		return false
+	}
+
+	for _, substr := range substrings {
+		if len(substr) == 0 {
+			continue // Skip empty substrings
+		}
+		if len(s) >= len(substr) {
+			for i := 0; i <= len(s)-len(substr); i++ {
+				if s[i:i+len(substr)] == substr {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
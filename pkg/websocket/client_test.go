@@ -0,0 +1,667 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/janhuddel/metrics-agent/pkg/duration"
+	"golang.org/x/net/websocket"
+)
+
+func TestConfigDefaults(t *testing.T) {
+	config := Config{
+		URL: "ws://localhost:8080/ws",
+	}
+
+	client, err := NewClient(config, func(message []byte) error { return nil })
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// Check that defaults are set
+	if client.config.ReconnectInterval == 0 {
+		t.Error("ReconnectInterval should have a default value")
+	}
+	if client.config.MaxReconnectAttempts == 0 {
+		t.Error("MaxReconnectAttempts should have a default value")
+	}
+	if client.config.ConnectionTimeout == 0 {
+		t.Error("ConnectionTimeout should have a default value")
+	}
+	if client.config.ReadTimeout == 0 {
+		t.Error("ReadTimeout should have a default value")
+	}
+	if client.config.WriteTimeout == 0 {
+		t.Error("WriteTimeout should have a default value")
+	}
+	if client.config.MaxBackoffInterval == 0 {
+		t.Error("MaxBackoffInterval should have a default value")
+	}
+	if client.config.BackoffMultiplier == 0 {
+		t.Error("BackoffMultiplier should have a default value")
+	}
+
+	// Check specific default values
+	if client.config.ReconnectInterval.Duration() != 5*time.Second {
+		t.Errorf("Expected ReconnectInterval to be 5s, got %v", client.config.ReconnectInterval)
+	}
+	if client.config.MaxReconnectAttempts != 10 {
+		t.Errorf("Expected MaxReconnectAttempts to be 10, got %d", client.config.MaxReconnectAttempts)
+	}
+	if client.config.ConnectionTimeout.Duration() != 10*time.Second {
+		t.Errorf("Expected ConnectionTimeout to be 10s, got %v", client.config.ConnectionTimeout)
+	}
+	if client.config.ReadTimeout.Duration() != 30*time.Second {
+		t.Errorf("Expected ReadTimeout to be 30s, got %v", client.config.ReadTimeout)
+	}
+	if client.config.WriteTimeout.Duration() != 10*time.Second {
+		t.Errorf("Expected WriteTimeout to be 10s, got %v", client.config.WriteTimeout)
+	}
+	if client.config.MaxBackoffInterval.Duration() != 5*time.Minute {
+		t.Errorf("Expected MaxBackoffInterval to be 5m, got %v", client.config.MaxBackoffInterval)
+	}
+	if client.config.BackoffMultiplier != 2.0 {
+		t.Errorf("Expected BackoffMultiplier to be 2.0, got %f", client.config.BackoffMultiplier)
+	}
+}
+
+func TestConnectionState(t *testing.T) {
+	config := Config{
+		URL: "ws://localhost:8080/ws",
+	}
+
+	client, err := NewClient(config, func(message []byte) error { return nil })
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// Test initial state
+	if client.GetState() != StateDisconnected {
+		t.Errorf("Expected initial state to be StateDisconnected, got %v", client.GetState())
+	}
+
+	// Test state transitions (using reflection to access private setState method)
+	// Since setState is private, we'll test through the public interface
+	// by checking that the state changes appropriately during operations
+}
+
+func TestIsUnrecoverableError(t *testing.T) {
+	config := Config{
+		URL: "ws://localhost:8080/ws",
+	}
+
+	client, err := NewClient(config, func(message []byte) error { return nil })
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	// Test context cancellation (unrecoverable)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if !client.isUnrecoverableError(ctx.Err()) {
+		t.Error("Context cancellation should be unrecoverable")
+	}
+
+	// Test authentication errors (unrecoverable)
+	authErr := &mockError{msg: "401 Unauthorized"}
+	if !client.isUnrecoverableError(authErr) {
+		t.Error("Authentication errors should be unrecoverable")
+	}
+
+	// Test EOF errors (recoverable)
+	eofErr := &mockError{msg: "EOF"}
+	if client.isUnrecoverableError(eofErr) {
+		t.Error("EOF errors should be recoverable")
+	}
+
+	// Test nil error (recoverable)
+	if client.isUnrecoverableError(nil) {
+		t.Error("Nil errors should be recoverable")
+	}
+}
+
+func TestContainsAny(t *testing.T) {
+	tests := []struct {
+		s          string
+		substrings []string
+		expected   bool
+	}{
+		{"hello world", []string{"world"}, true},
+		{"hello world", []string{"foo"}, false},
+		{"401 Unauthorized", []string{"401", "403"}, true},
+		{"invalid URL", []string{"invalid", "malformed"}, true},
+		{"connection refused", []string{"timeout", "refused"}, true},
+		{"", []string{"test"}, false},
+		{"test", []string{""}, false},
+		{"test", []string{}, false}, // Empty slice should return false
+	}
+
+	for _, test := range tests {
+		result := containsAny(test.s, test.substrings)
+		if result != test.expected {
+			t.Errorf("containsAny(%q, %v) = %v, expected %v", test.s, test.substrings, result, test.expected)
+		}
+	}
+}
+
+func TestNewClientValidation(t *testing.T) {
+	// Test empty URL
+	_, err := NewClient(Config{}, func(message []byte) error { return nil })
+	if err == nil {
+		t.Error("Should return error for empty URL")
+	}
+
+	// Test nil handler
+	_, err = NewClient(Config{URL: "ws://localhost:8080/ws"}, nil)
+	if err == nil {
+		t.Error("Should return error for nil handler")
+	}
+
+	// Test valid config
+	_, err = NewClient(Config{URL: "ws://localhost:8080/ws"}, func(message []byte) error { return nil })
+	if err != nil {
+		t.Errorf("Should not return error for valid config, got: %v", err)
+	}
+}
+
+func TestConnectHandlerAndSend(t *testing.T) {
+	// Echo server that replies to every message it receives
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		for {
+			var msg string
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				return
+			}
+			if err := websocket.Message.Send(ws, "echo:"+msg); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	received := make(chan string, 1)
+	client, err := NewClient(Config{
+		URL:      "ws" + strings.TrimPrefix(server.URL, "http"),
+		Protocol: "test-protocol",
+		Headers:  map[string]string{"User-Agent": "metrics-agent-test"},
+	}, func(message []byte) error {
+		received <- string(message)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	if err := client.Send([]byte("hello")); err == nil {
+		t.Error("Send should fail when not connected")
+	}
+
+	client.SetConnectHandler(func(c *Client) error {
+		return c.Send([]byte("hello"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = client.Run(ctx)
+	}()
+
+	select {
+	case msg := <-received:
+		if msg != "echo:hello" {
+			t.Errorf("Expected 'echo:hello', got %q", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received within 2s")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	// Server that acknowledges an init message and then sends nothing
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var msg string
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			return
+		}
+		websocket.Message.Send(ws, "hello")
+		websocket.Message.Send(ws, "ack:"+msg)
+		websocket.Message.Receive(ws, &msg)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{URL: "ws" + strings.TrimPrefix(server.URL, "http"), ReadTimeout: duration.Duration(200 * time.Millisecond)}, func([]byte) error { return nil })
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.SetConnectHandler(func(c *Client) error {
+		return c.Send([]byte("init"))
+	})
+
+	var messages []string
+	err = client.Probe(context.Background(), func(message []byte) (bool, error) {
+		messages = append(messages, string(message))
+		return string(message) == "ack:init", nil
+	})
+	if err != nil || len(messages) != 2 {
+		t.Errorf("Expected probe to pass after 2 messages, got %v, %v", messages, err)
+	}
+	if client.GetState() != StateDisconnected {
+		t.Errorf("Expected connection to be closed, got %s", client.GetState())
+	}
+
+	// Without the expected message the probe fails after the read timeout
+	err = client.Probe(context.Background(), func(message []byte) (bool, error) {
+		return false, nil
+	})
+	if err == nil {
+		t.Error("Expected probe to fail without accepted message")
+	}
+
+	// A server that is not reachable fails without reconnecting
+	server.Close()
+	if err := client.Probe(context.Background(), nil); err == nil {
+		t.Error("Expected probe to fail for closed server")
+	}
+}
+
+func TestStateChangeHandler(t *testing.T) {
+	// Server that closes every connection right away
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		URL:               "ws" + strings.TrimPrefix(server.URL, "http"),
+		ReconnectInterval: duration.Duration(10 * time.Millisecond),
+	}, func(message []byte) error { return nil })
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	type transition struct{ from, to ConnectionState }
+	transitions := make(chan transition, 100)
+	client.SetStateChangeHandler(func(oldState, newState ConnectionState) {
+		transitions <- transition{oldState, newState}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = client.Run(ctx)
+	}()
+
+	expected := []transition{
+		{StateDisconnected, StateConnecting},
+		{StateConnecting, StateConnected},
+		{StateConnected, StateDisconnected},
+		{StateDisconnected, StateReconnecting},
+		{StateReconnecting, StateConnecting},
+	}
+	for _, want := range expected {
+		select {
+		case got := <-transitions:
+			if got != want {
+				t.Fatalf("Expected transition %s -> %s, got %s -> %s", want.from, want.to, got.from, got.to)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no transition %s -> %s within 2s", want.from, want.to)
+		}
+	}
+}
+
+func TestConnectionStateString(t *testing.T) {
+	if StateReconnecting.String() != "reconnecting" {
+		t.Errorf("Expected 'reconnecting', got %q", StateReconnecting.String())
+	}
+	if ConnectionState(42).String() != "unknown(42)" {
+		t.Errorf("Expected 'unknown(42)', got %q", ConnectionState(42).String())
+	}
+}
+
+// mockError is a simple error implementation for testing
+type mockError struct {
+	msg string
+}
+
+func (e *mockError) Error() string {
+	return e.msg
+}
+
+func TestConfigJSON(t *testing.T) {
+	config := Config{
+		URL:               "ws://localhost/ws",
+		ReconnectInterval: duration.Duration(5 * time.Second),
+		ReadTimeout:       duration.Duration(time.Minute),
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	if !strings.Contains(string(data), `"reconnect_interval":"5s"`) || !strings.Contains(string(data), `"read_timeout":"1m0s"`) {
+		t.Errorf("Expected durations as strings, got %s", data)
+	}
+
+	var decoded Config
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal config: %v", err)
+	}
+	if decoded.URL != config.URL || decoded.ReconnectInterval != config.ReconnectInterval || decoded.ReadTimeout != config.ReadTimeout {
+		t.Errorf("Round trip mismatch: %+v", decoded)
+	}
+
+	// Nanoseconds as written by earlier versions are still accepted
+	if err := json.Unmarshal([]byte(`{"url":"ws://x","write_timeout":10000000000}`), &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal legacy config: %v", err)
+	}
+	if decoded.WriteTimeout.Duration() != 10*time.Second {
+		t.Errorf("Expected write timeout 10s, got %v", decoded.WriteTimeout)
+	}
+}
+
+func TestStats(t *testing.T) {
+	// Server that sends two messages and closes the first connection, and
+	// keeps later connections open without sending anything
+	var connections atomic.Int32
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		if connections.Add(1) == 1 {
+			_ = websocket.Message.Send(ws, "ok")
+			_ = websocket.Message.Send(ws, "fail")
+			return
+		}
+		var msg string
+		_ = websocket.Message.Receive(ws, &msg)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		URL:               "ws" + strings.TrimPrefix(server.URL, "http"),
+		ReconnectInterval: duration.Duration(10 * time.Millisecond),
+	}, func(message []byte) error {
+		if string(message) == "fail" {
+			return fmt.Errorf("invalid message")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if stats := client.Stats(); stats != (Stats{}) {
+		t.Errorf("Expected zero stats before running, got %+v", stats)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = client.Run(ctx)
+	}()
+
+	want := Stats{MessagesReceived: 2, BytesReceived: 6, HandlerErrors: 1, Reconnects: 1, State: StateConnected}
+	deadline := time.Now().Add(2 * time.Second)
+	for client.Stats() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected stats %+v within 2s, got %+v", want, client.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStatsWhileReconnecting(t *testing.T) {
+	// Polls the snapshot from another goroutine while the client fails to
+	// connect, for the race detector
+	client, err := NewClient(Config{
+		URL:                  "ws://metrics-agent.invalid",
+		ReconnectInterval:    duration.Duration(time.Millisecond),
+		MaxBackoffInterval:   duration.Duration(time.Millisecond),
+		MaxReconnectAttempts: 5,
+	}, func([]byte) error { return nil })
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.SetDialer(func(ctx context.Context, config Config) (Conn, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- client.Run(context.Background())
+	}()
+	for {
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Fatal("Expected error after max reconnection attempts")
+			}
+			stats := client.Stats()
+			if stats.State != StateFailed || stats.ReconnectAttempts != 5 || stats.LastError == nil {
+				t.Errorf("Unexpected stats %+v", stats)
+			}
+			return
+		default:
+			_ = client.Stats()
+			_ = client.GetLastError()
+		}
+	}
+}
+
+// fakeConn is a Conn that returns queued messages and blocks in Receive
+// until it is closed once the queue is empty.
+type fakeConn struct {
+	messages chan []byte
+	sent     chan []byte
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newFakeConn(messages ...string) *fakeConn {
+	conn := &fakeConn{
+		messages: make(chan []byte, len(messages)),
+		sent:     make(chan []byte, 10),
+		closed:   make(chan struct{}),
+	}
+	for _, message := range messages {
+		conn.messages <- []byte(message)
+	}
+	return conn
+}
+
+func (c *fakeConn) Receive() ([]byte, error) {
+	select {
+	case message := <-c.messages:
+		return message, nil
+	case <-c.closed:
+		return nil, errors.New("use of closed connection")
+	}
+}
+
+func (c *fakeConn) Send(message []byte) error {
+	c.sent <- message
+	return nil
+}
+
+func (c *fakeConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *fakeConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (c *fakeConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func TestSetDialer(t *testing.T) {
+	conn := newFakeConn("first", "second")
+	received := make(chan string, 2)
+	client, err := NewClient(Config{URL: "ws://device.invalid/ws"}, func(message []byte) error {
+		received <- string(message)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	var dialedURL string
+	client.SetDialer(func(ctx context.Context, config Config) (Conn, error) {
+		dialedURL = config.URL
+		return conn, nil
+	})
+	client.SetConnectHandler(func(c *Client) error {
+		return c.Send([]byte("subscribe"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = client.Run(ctx)
+	}()
+
+	for _, want := range []string{"first", "second"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("Expected message %q, got %q", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no message %q received within 2s", want)
+		}
+	}
+	if sent := <-conn.sent; string(sent) != "subscribe" || dialedURL != "ws://device.invalid/ws" {
+		t.Errorf("Expected subscription sent to the dialed URL, got %q to %q", sent, dialedURL)
+	}
+}
+
+func TestRunStopsWhileReceiving(t *testing.T) {
+	conn := newFakeConn()
+	client, err := NewClient(Config{URL: "ws://device.invalid/ws", ReadTimeout: duration.Duration(time.Hour)}, func([]byte) error { return nil })
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.SetDialer(func(ctx context.Context, config Config) (Conn, error) {
+		return conn, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- client.Run(ctx)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for client.GetState() != StateConnected {
+		if time.Now().After(deadline) {
+			t.Fatal("client did not connect within 2s")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Cancelling closes the connection instead of waiting for the read timeout
+	cancel()
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return within 2s after cancellation")
+	}
+	select {
+	case <-conn.closed:
+	default:
+		t.Error("Expected connection to be closed")
+	}
+	if client.GetState() != StateDisconnected {
+		t.Errorf("Expected state disconnected, got %s", client.GetState())
+	}
+}
+
+func TestProbeStopsWhileReceiving(t *testing.T) {
+	client, err := NewClient(Config{URL: "ws://device.invalid/ws", ReadTimeout: duration.Duration(time.Hour)}, func([]byte) error { return nil })
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.SetDialer(func(ctx context.Context, config Config) (Conn, error) {
+		return newFakeConn(), nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = client.Probe(ctx, func([]byte) (bool, error) { return false, nil })
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Errorf("Expected probe to stop with the context, got %v after %v", err, time.Since(start))
+	}
+}
+
+// recordingLogger records the messages logged by a client
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) record(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func (l *recordingLogger) Infof(format string, v ...interface{})  { l.record(format, v...) }
+func (l *recordingLogger) Warnf(format string, v ...interface{})  { l.record(format, v...) }
+func (l *recordingLogger) Errorf(format string, v ...interface{}) { l.record(format, v...) }
+
+func TestHooks(t *testing.T) {
+	client, err := NewClient(Config{URL: "ws://device.invalid/ws"}, func([]byte) error { return nil })
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.SetDialer(func(ctx context.Context, config Config) (Conn, error) {
+		return newFakeConn(), nil
+	})
+	logger := &recordingLogger{}
+	client.SetLogger(logger)
+
+	denied := errors.New("destination not allowed")
+	allowed := false
+	client.SetDestinationCheck(func(ctx context.Context, url string) error {
+		if !allowed {
+			return denied
+		}
+		return nil
+	})
+	var attempts []error
+	client.SetConnectObserver(func(ctx context.Context, url string, duration time.Duration, err error) {
+		attempts = append(attempts, err)
+	})
+
+	if err := client.Probe(context.Background(), nil); !errors.Is(err, denied) {
+		t.Errorf("Expected the destination check to reject the connection, got %v", err)
+	}
+	allowed = true
+	if err := client.Probe(context.Background(), nil); err != nil {
+		t.Errorf("Expected an allowed connection, got %v", err)
+	}
+	if len(attempts) != 2 || !errors.Is(attempts[0], denied) || attempts[1] != nil {
+		t.Errorf("Expected both attempts observed, got %v", attempts)
+	}
+	if len(logger.messages) == 0 || !strings.Contains(logger.messages[0], "Attempting to connect") {
+		t.Errorf("Expected the connection attempts logged, got %v", logger.messages)
+	}
+}
+
+func TestRunRecoversPanic(t *testing.T) {
+	client, err := NewClient(Config{URL: "ws://device.invalid/ws"}, func([]byte) error { panic("boom") })
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.SetDialer(func(ctx context.Context, config Config) (Conn, error) {
+		return newFakeConn("message"), nil
+	})
+
+	if err := client.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Expected the panic returned as error, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+#!/bin/bash
+
+# Benchmark comparison script for metrics-agent
+# This script compares benchmark results with a stored baseline and fails if
+# a benchmark got slower or allocates more than the allowed regression.
+#
+# Usage: scripts/bench-check.sh <baseline> <results> [max-regression-percent]
+#
+# Both files contain the output of "go test -bench ... -benchmem", usually
+# with -count > 1; the runs of a benchmark are averaged.
+
+set -e
+
+# Colors for output
+RED='\033[0;31m'
+GREEN='\033[0;32m'
+NC='\033[0m' # No Color
+
+BASELINE="$1"
+RESULTS="$2"
+THRESHOLD="${3:-20}"
+
+if [ -z "$BASELINE" ] || [ -z "$RESULTS" ]; then
+    echo "Usage: $0 <baseline> <results> [max-regression-percent]"
+    exit 2
+fi
+if [ ! -f "$BASELINE" ]; then
+    echo -e "❌ ${RED}No baseline found at $BASELINE, record one with 'make bench-baseline'${NC}"
+    exit 2
+fi
+if [ ! -f "$RESULTS" ]; then
+    echo -e "❌ ${RED}No benchmark results found at $RESULTS${NC}"
+    exit 2
+fi
+
+# averages prints "<benchmark> <ns/op> <allocs/op>" per benchmark, without
+# the GOMAXPROCS suffix of the name
+averages() {
+    awk '
+        /^Benchmark/ {
+            name = $1
+            sub(/-[0-9]+$/, "", name)
+            for (i = 3; i < NF; i++) {
+                if ($(i + 1) == "ns/op") { ns[name] += $i }
+                if ($(i + 1) == "allocs/op") { allocs[name] += $i }
+            }
+            runs[name]++
+        }
+        END {
+            for (name in runs) {
+                printf "%s %f %f\n", name, ns[name] / runs[name], allocs[name] / runs[name]
+            }
+        }
+    ' "$1" | sort
+}
+
+echo "Comparing $RESULTS with $BASELINE (max regression: ${THRESHOLD}%)"
+echo
+
+join <(averages "$BASELINE") <(averages "$RESULTS") | awk -v threshold="$THRESHOLD" '
+    function change(old, new) {
+        return old > 0 ? (new - old) / old * 100 : 0
+    }
+    {
+        ns = change($2, $4)
+        allocs = change($3, $5)
+        status = "ok"
+        if (ns > threshold || allocs > threshold) {
+            status = "REGRESSION"
+            failed++
+        }
+        printf "%-60s %12.0f -> %12.0f ns/op (%+6.1f%%) %8.0f -> %8.0f allocs/op (%+6.1f%%)  %s\n", $1, $2, $4, ns, $3, $5, allocs, status
+        compared++
+    }
+    END {
+        if (compared == 0) {
+            print "No benchmark found in both files"
+            exit 2
+        }
+        exit failed > 0 ? 1 : 0
+    }
+' && status=0 || status=$?
+
+echo
+case $status in
+    0) echo -e "✅ ${GREEN}No performance regression${NC}" ;;
+    1) echo -e "❌ ${RED}Performance regression of more than ${THRESHOLD}%${NC}" ;;
+esac
+exit $status
@@ -0,0 +1,58 @@
+#!/bin/bash
+
+# Dependency check script for metrics-agent
+# This script lists the packages of builds with selected modules and fails if
+# they pull in the dependencies of other modules, e.g. because shared code
+# started importing the websocket client. It also fails if the public packages
+# under pkg/ import internal packages.
+#
+# Usage: scripts/check-deps.sh
+
+set -e
+
+# Colors for output
+RED='\033[0;31m'
+GREEN='\033[0;32m'
+NC='\033[0m' # No Color
+
+PKG=github.com/janhuddel/metrics-agent/cmd/metrics-agent
+FAILED=0
+
+# check <modules> <forbidden package>...
+check() {
+    local modules="$1"
+    shift
+    local deps
+    deps=$(go list -tags "only_selected,$modules" -deps "$PKG")
+    for forbidden in "$@"; do
+        if grep -qx "$forbidden" <<< "$deps"; then
+            echo -e "❌ ${RED}Build with $modules depends on $forbidden${NC}"
+            FAILED=1
+        fi
+    done
+}
+
+# Tasmota is MQTT-based, but must not pull in the websocket stack
+check tasmota \
+    github.com/janhuddel/metrics-agent/pkg/websocket \
+    golang.org/x/net/websocket
+
+# The demo module has no external dependencies at all
+check demo \
+    github.com/janhuddel/metrics-agent/pkg/websocket \
+    golang.org/x/net/websocket \
+    github.com/eclipse/paho.mqtt.golang \
+    github.com/gorilla/websocket
+
+# The public packages must not depend on the internal packages of the agent
+internal=$(go list -deps ./pkg/... | grep '^github.com/janhuddel/metrics-agent/internal/' || true)
+if [ -n "$internal" ]; then
+    echo -e "❌ ${RED}Public packages depend on internal packages:${NC}"
+    echo "$internal"
+    FAILED=1
+fi
+
+if [ "$FAILED" -ne 0 ]; then
+    exit 1
+fi
+echo -e "✅ ${GREEN}Builds with selected modules only pull in their own dependencies, public packages no internal ones${NC}"